@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// This file contains the multi-account support. The same policy is run in
+// every account by assuming a role there, and each account gets its own
+// summary.
+
+// defaultRoleSessionName identifies the tool's sessions in CloudTrail
+const defaultRoleSessionName = "ecr-cleanup"
+
+// accountTarget is an account to clean up. An empty RoleArn means the
+// account of the current credentials, which needs no role assumption.
+type accountTarget struct {
+	AccountID string
+	RoleArn   string
+}
+
+// AccountSummary holds the cleanup results for a single account
+type AccountSummary struct {
+	AccountID string
+	RoleArn   string
+	CleanupSummary
+}
+
+// readRoleArns reads role ARNs from a file, one per line. Blank lines and
+// lines starting with # are ignored.
+func readRoleArns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var roleArns []string
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !arn.IsARN(line) {
+			return nil, fmt.Errorf("%s:%d: invalid role ARN %q", path, lineNo, line)
+		}
+		roleArns = append(roleArns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return roleArns, nil
+}
+
+// targetsFromRoleArns converts role ARNs into account targets
+func targetsFromRoleArns(roleArns []string) []accountTarget {
+	targets := make([]accountTarget, len(roleArns))
+	for i, roleArn := range roleArns {
+		targets[i] = accountTarget{
+			AccountID: accountIDFromRoleArn(roleArn),
+			RoleArn:   roleArn,
+		}
+	}
+	return targets
+}
+
+// accountIDFromRoleArn extracts the account ID from a role ARN
+func accountIDFromRoleArn(roleArn string) string {
+	parsed, err := arn.Parse(roleArn)
+	if err != nil {
+		return "unknown"
+	}
+	return parsed.AccountID
+}
+
+// assumeRoleConfig returns a copy of the AWS config whose credentials come
+// from assuming the given role, using the session name and external ID
+// from the configuration
+func assumeRoleConfig(awsConfig aws.Config, roleArn string, cfg Config) aws.Config {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = cfg.RoleSessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = defaultRoleSessionName
+		}
+		if cfg.ExternalID != "" {
+			o.ExternalID = aws.String(cfg.ExternalID)
+		}
+	})
+
+	accountConfig := awsConfig.Copy()
+	accountConfig.Credentials = aws.NewCredentialsCache(provider)
+	return accountConfig
+}
+
+// cleanupAccounts assumes the role of each target in turn, runs the cleanup
+// in that account and aggregates the results
+func cleanupAccounts(ctx context.Context, awsConfig aws.Config, cfg Config, targets []accountTarget) (CleanupSummary, error) {
+	summary := CleanupSummary{}
+	var lastErr error
+
+	for _, target := range targets {
+		accountConfig := awsConfig
+		if target.RoleArn != "" {
+			accountConfig = assumeRoleConfig(awsConfig, target.RoleArn, cfg)
+		}
+
+		accountCfg := cfg
+		accountCfg.accountID = target.AccountID
+
+		slog.Info("Cleaning up account", "account", target.AccountID)
+		accountSummary, err := cleanupAccount(ctx, accountConfig, accountCfg)
+		if err != nil && !errors.Is(err, errRunStopped) {
+			if cfg.FailOnError {
+				return summary, fmt.Errorf("account %s: %w", target.AccountID, err)
+			}
+			slog.Error("Error cleaning up account", "account", target.AccountID, "error", err)
+			summary.Failures = append(summary.Failures, fmt.Sprintf("account %s: %v", target.AccountID, err))
+			lastErr = err
+			continue
+		}
+
+		for i := range accountSummary.Repositories {
+			accountSummary.Repositories[i].AccountID = target.AccountID
+		}
+
+		summary.add(accountSummary)
+		summary.Accounts = append(summary.Accounts, AccountSummary{
+			AccountID:      target.AccountID,
+			RoleArn:        target.RoleArn,
+			CleanupSummary: accountSummary,
+		})
+
+		// A stopped run reports what it deleted and goes no further
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	// Only fail the run when no account could be cleaned up at all
+	if len(summary.Accounts) == 0 && lastErr != nil {
+		return summary, fmt.Errorf("all accounts failed: %w", lastErr)
+	}
+
+	return summary, nil
+}
+
+// cleanupAccount runs the cleanup in every requested region of the account
+// the AWS config has credentials for
+func cleanupAccount(ctx context.Context, awsConfig aws.Config, cfg Config) (CleanupSummary, error) {
+	regions, err := resolveRegions(ctx, awsConfig, cfg)
+	if err != nil {
+		return CleanupSummary{}, fmt.Errorf("failed to resolve regions: %w", err)
+	}
+
+	if len(regions) == 0 {
+		return cleanupRegion(ctx, awsConfig, cfg)
+	}
+
+	return cleanupRegions(ctx, awsConfig, cfg, regions)
+}
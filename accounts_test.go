@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// writeTestFile writes content to a file in a temporary directory
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path
+}
+
+// TestReadRoleArns tests reading the -assume-roles file
+func TestReadRoleArns(t *testing.T) {
+	t.Run("Valid file with comments", func(t *testing.T) {
+		path := writeTestFile(t, "roles.txt", `# production accounts
+arn:aws:iam::111111111111:role/EcrCleanup
+
+  arn:aws:iam::222222222222:role/EcrCleanup
+`)
+
+		roleArns, err := readRoleArns(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := []string{
+			"arn:aws:iam::111111111111:role/EcrCleanup",
+			"arn:aws:iam::222222222222:role/EcrCleanup",
+		}
+		if !reflect.DeepEqual(roleArns, expected) {
+			t.Errorf("Expected %v, got %v", expected, roleArns)
+		}
+	})
+
+	t.Run("Invalid ARN", func(t *testing.T) {
+		path := writeTestFile(t, "roles.txt", "arn:aws:iam::111111111111:role/EcrCleanup\nnot-an-arn\n")
+
+		_, err := readRoleArns(path)
+		if err == nil {
+			t.Fatal("Expected an error for an invalid ARN")
+		}
+		if !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("Expected error to include the line number, got %v", err)
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := readRoleArns(filepath.Join(t.TempDir(), "missing.txt"))
+		if err == nil {
+			t.Fatal("Expected an error for a missing file")
+		}
+	})
+}
+
+// TestAccountIDFromRoleArn tests extracting the account ID from a role ARN
+func TestAccountIDFromRoleArn(t *testing.T) {
+	if id := accountIDFromRoleArn("arn:aws:iam::123456789012:role/EcrCleanup"); id != "123456789012" {
+		t.Errorf("Expected 123456789012, got %s", id)
+	}
+	if id := accountIDFromRoleArn("garbage"); id != "unknown" {
+		t.Errorf("Expected unknown, got %s", id)
+	}
+}
+
+// TestAssumeRoleConfig tests that assumed-role configs don't modify the base config
+func TestAssumeRoleConfig(t *testing.T) {
+	base := aws.Config{Region: "us-east-1"}
+
+	accountConfig := assumeRoleConfig(base, "arn:aws:iam::123456789012:role/EcrCleanup", Config{})
+
+	if accountConfig.Credentials == nil {
+		t.Error("Expected assumed-role config to have credentials")
+	}
+	if base.Credentials != nil {
+		t.Error("Expected base config credentials to be unchanged")
+	}
+	if accountConfig.Region != "us-east-1" {
+		t.Errorf("Expected region to be preserved, got %s", accountConfig.Region)
+	}
+}
+
+// TestAssumeRoleConfigOptions tests that the session name and external ID are sent to STS
+func TestAssumeRoleConfigOptions(t *testing.T) {
+	var captured url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		captured = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>AKID</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey>
+<SessionToken>TOKEN</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer server.Close()
+
+	base := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("base", "base", ""),
+	}
+	cfg := Config{ExternalID: "ext-123", RoleSessionName: "nightly"}
+
+	accountConfig := assumeRoleConfig(base, "arn:aws:iam::123456789012:role/EcrCleanup", cfg)
+	creds, err := accountConfig.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if creds.AccessKeyID != "AKID" {
+		t.Errorf("Expected assumed-role credentials, got %s", creds.AccessKeyID)
+	}
+	if captured.Get("ExternalId") != "ext-123" {
+		t.Errorf("Expected external ID ext-123, got %q", captured.Get("ExternalId"))
+	}
+	if captured.Get("RoleSessionName") != "nightly" {
+		t.Errorf("Expected session name nightly, got %q", captured.Get("RoleSessionName"))
+	}
+}
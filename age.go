@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains -older-than and -before, the finer-grained age limits
+// that replace -days. -older-than takes a Go duration that may start with a
+// number of days, such as 36h or 45d12h, for registries that fill up within
+// a day; -before takes an absolute RFC 3339 cutoff, such as the date a
+// compromised base image was replaced. Repository overrides of -days, such
+// as -pull-through-days and the ecr-cleanup/days tag, still replace either.
+
+// ageDuration is the value of -older-than
+type ageDuration time.Duration
+
+// String returns the duration with days as a d suffix
+func (d *ageDuration) String() string {
+	if d == nil || *d == 0 {
+		return ""
+	}
+	return formatAgeDuration(time.Duration(*d))
+}
+
+// Set parses a duration such as 36h or 45d12h
+func (d *ageDuration) Set(value string) error {
+	duration, err := parseAgeDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = ageDuration(duration)
+	return nil
+}
+
+// parseAgeDuration parses a positive Go duration that may start with a
+// number of days, e.g. 7d, 36h or 45d12h
+func parseAgeDuration(value string) (time.Duration, error) {
+	var duration time.Duration
+	rest := value
+	if days, after, ok := strings.Cut(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q: expected e.g. 36h or 45d12h", value)
+		}
+		duration, rest = time.Duration(n)*24*time.Hour, after
+	}
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid duration %q: expected e.g. 36h or 45d12h", value)
+		}
+		duration += d
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q: must be positive", value)
+	}
+	return duration, nil
+}
+
+// formatAgeDuration formats a duration the way -older-than takes it, e.g.
+// 45d12h
+func formatAgeDuration(d time.Duration) string {
+	var b strings.Builder
+	if days := d / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&b, "%dd", days)
+		d -= days * 24 * time.Hour
+	}
+	if d > 0 {
+		s := d.String()
+		if strings.HasSuffix(s, "m0s") {
+			s = strings.TrimSuffix(s, "0s")
+		}
+		if strings.HasSuffix(s, "h0m") {
+			s = strings.TrimSuffix(s, "0m")
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// timestamp is the value of -before
+type timestamp time.Time
+
+// String returns the cutoff in RFC 3339
+func (t *timestamp) String() string {
+	if t == nil || time.Time(*t).IsZero() {
+		return ""
+	}
+	return time.Time(*t).Format(time.RFC3339)
+}
+
+// Set parses an RFC 3339 timestamp, or a date meaning its UTC midnight
+func (t *timestamp) Set(value string) error {
+	cutoff, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		cutoff, err = time.Parse(time.DateOnly, value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid time %q: expected e.g. 2024-06-01T00:00:00Z or 2024-06-01", value)
+	}
+	*t = timestamp(cutoff)
+	return nil
+}
+
+// ageCutoff returns the push time before which images are old enough to be
+// deleted: the end of the -pushed-after/-pushed-before range, -before,
+// -older-than or -days, whichever is set
+func (cfg Config) ageCutoff(now time.Time) time.Time {
+	switch {
+	case !cfg.PushedBefore.IsZero():
+		return cfg.PushedBefore
+	case !cfg.PushedAfter.IsZero():
+		return now
+	case !cfg.Before.IsZero():
+		return cfg.Before
+	case cfg.OlderThan > 0:
+		return now.Add(-cfg.OlderThan)
+	default:
+		return now.AddDate(0, 0, -cfg.Days)
+	}
+}
+
+// ageLimit describes the age limit, e.g. "older than 10 days"
+func (cfg Config) ageLimit() string {
+	switch {
+	case cfg.pushedRange():
+		return cfg.pushedRangeLimit()
+	case !cfg.Before.IsZero():
+		return "pushed before " + cfg.Before.Format(time.RFC3339)
+	case cfg.OlderThan > 0:
+		return "older than " + formatAgeDuration(cfg.OlderThan)
+	default:
+		return fmt.Sprintf("older than %d days", cfg.Days)
+	}
+}
+
+// withDays returns the config with its age limit replaced by a number of
+// days, as repository overrides of -days do
+func (cfg Config) withDays(days int) Config {
+	cfg.Days = days
+	cfg.OlderThan = 0
+	cfg.Before = time.Time{}
+	cfg.PushedAfter = time.Time{}
+	cfg.PushedBefore = time.Time{}
+	return cfg
+}
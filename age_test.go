@@ -0,0 +1,164 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestParseAgeDuration tests durations with a day suffix
+func TestParseAgeDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"36h":     36 * time.Hour,
+		"7d":      7 * 24 * time.Hour,
+		"45d12h":  45*24*time.Hour + 12*time.Hour,
+		"1d30m":   24*time.Hour + 30*time.Minute,
+		"90m":     90 * time.Minute,
+		"0d1h30m": 90 * time.Minute,
+	}
+	for value, want := range tests {
+		got, err := parseAgeDuration(value)
+		if err != nil || got != want {
+			t.Errorf("parseAgeDuration(%q) = %v, %v; expected %v", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "0h", "0d", "-1h", "1.5d", "d", "12", "3w", "1h1d"} {
+		if _, err := parseAgeDuration(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestFormatAgeDuration tests formatting a duration the way -older-than
+// takes it
+func TestFormatAgeDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		36 * time.Hour:                                "1d12h",
+		45*24*time.Hour + 12*time.Hour:                "45d12h",
+		7 * 24 * time.Hour:                            "7d",
+		90 * time.Minute:                              "1h30m",
+		10 * time.Second:                              "10s",
+		2*24*time.Hour + 10*time.Minute:               "2d10m",
+		24*time.Hour + 5*time.Minute + 10*time.Second: "1d5m10s",
+	}
+	for d, want := range tests {
+		if got := formatAgeDuration(d); got != want {
+			t.Errorf("formatAgeDuration(%v) = %q, expected %q", d, got, want)
+		}
+	}
+}
+
+// TestTimestampFlag tests parsing -before
+func TestTimestampFlag(t *testing.T) {
+	var ts timestamp
+	if err := ts.Set("2024-06-01T12:00:00+02:00"); err != nil || !time.Time(ts).Equal(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time %v, %v", time.Time(ts), err)
+	}
+	if err := ts.Set("2024-06-01"); err != nil || !time.Time(ts).Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time %v, %v", time.Time(ts), err)
+	}
+	if err := ts.Set("June 1st"); err == nil {
+		t.Error("Expected an invalid time to be rejected")
+	}
+}
+
+// TestAgeCutoff tests the precedence of -before, -older-than and -days
+func TestAgeCutoff(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		cfg    Config
+		cutoff time.Time
+		limit  string
+	}{
+		{Config{Days: 10}, now.AddDate(0, 0, -10), "older than 10 days"},
+		{Config{Days: 10, OlderThan: 36 * time.Hour}, now.Add(-36 * time.Hour), "older than 1d12h"},
+		{Config{Days: 10, Before: before}, before, "pushed before 2024-06-01T00:00:00Z"},
+		{Config{Days: 10, OlderThan: 36 * time.Hour}.withDays(3), now.AddDate(0, 0, -3), "older than 3 days"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.ageCutoff(now); !got.Equal(tt.cutoff) {
+			t.Errorf("Expected cutoff %v for %+v, got %v", tt.cutoff, tt.cfg, got)
+		}
+		if got := tt.cfg.ageLimit(); got != tt.limit {
+			t.Errorf("Expected %q, got %q", tt.limit, got)
+		}
+	}
+}
+
+// TestSelectImagesOlderThan tests selecting images by a duration shorter
+// than a day
+func TestSelectImagesOlderThan(t *testing.T) {
+	now := time.Now()
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:new"), ImagePushedAt: aws.Time(now.Add(-2 * time.Hour))},
+		{ImageDigest: aws.String("sha256:old"), ImagePushedAt: aws.Time(now.Add(-8 * time.Hour))},
+	}
+
+	selected := selectImagesForDeletion(images, Config{Days: 10, OlderThan: 6 * time.Hour})
+	if digests := digestsOf(selected); len(digests) != 1 || digests[0] != "sha256:old" {
+		t.Errorf("Expected only the image older than 6 hours, got %v", digests)
+	}
+}
+
+// TestLifecyclePolicyAge tests converting -older-than and -before into a
+// lifecycle policy
+func TestLifecyclePolicyAge(t *testing.T) {
+	policy, warnings := lifecyclePolicyFor("repo", Config{OlderThan: 36 * time.Hour})
+	if policy == nil || policy.Rules[0].Selection.CountNumber != 2 || len(warnings) != 1 {
+		t.Errorf("Expected 36h to be rounded up to 2 days with a warning, got %+v, %v", policy, warnings)
+	}
+
+	policy, warnings = lifecyclePolicyFor("repo", Config{OlderThan: 7 * 24 * time.Hour})
+	if policy == nil || policy.Rules[0].Selection.CountNumber != 7 || len(warnings) != 0 {
+		t.Errorf("Expected a 7 day policy, got %+v, %v", policy, warnings)
+	}
+
+	policy, warnings = lifecyclePolicyFor("repo", Config{Days: 10, Before: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)})
+	if policy != nil || len(warnings) != 1 {
+		t.Errorf("Expected no policy for an absolute cutoff, got %+v, %v", policy, warnings)
+	}
+}
+
+// TestPushedRange tests selecting only the images pushed within
+// -pushed-after and -pushed-before
+func TestPushedRange(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	after := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:later"), ImagePushedAt: aws.Time(time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC))},
+		{ImageDigest: aws.String("sha256:within"), ImagePushedAt: aws.Time(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC))},
+		{ImageDigest: aws.String("sha256:earlier"), ImagePushedAt: aws.Time(time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC))},
+	}
+
+	tests := []struct {
+		cfg      Config
+		selected string
+		limit    string
+	}{
+		{Config{Days: 10, PushedAfter: after, PushedBefore: before}, "sha256:within", "pushed between 2024-06-01T00:00:00Z and 2024-06-03T00:00:00Z"},
+		{Config{Days: 10, PushedAfter: after}, "sha256:later,sha256:within", "pushed after 2024-06-01T00:00:00Z"},
+		{Config{Days: 10, PushedBefore: before}, "sha256:within,sha256:earlier", "pushed before 2024-06-03T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		tt.cfg.Clock = FixedClock(now)
+		selected := selectImagesForDeletion(slices.Clone(images), tt.cfg)
+		if got := strings.Join(digestsOf(selected), ","); got != tt.selected {
+			t.Errorf("%s: expected %s, got %s", tt.limit, tt.selected, got)
+		}
+		if got := tt.cfg.ageLimit(); got != tt.limit {
+			t.Errorf("Expected %q, got %q", tt.limit, got)
+		}
+	}
+
+	if policy, warnings := lifecyclePolicyFor("repo", Config{Days: 10, PushedAfter: after}); policy != nil || len(warnings) != 1 {
+		t.Errorf("Expected no policy for a range of push times, got %+v, %v", policy, warnings)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// This file contains the ECR API call statistics of a run: how often each
+// operation was called, how often ECR throttled it and how often it was
+// retried. They are counted by a middleware on the AWS configuration, which
+// sees every request the SDK sends, including the ones its own retryer
+// repeats, and by throttledClient for the calls it retries on top of that.
+// Comparing them across runs shows whether -concurrency and -api-rate are
+// too high for the account's limits.
+
+// APICallStats holds the statistics of one ECR operation
+type APICallStats struct {
+	Operation string
+	Calls     int // requests sent, retries included
+	Throttles int // requests rejected for exceeding the rate limits
+	Retries   int // requests that repeated a failed one
+}
+
+// apiStats counts the ECR calls of a run. A nil apiStats counts nothing,
+// so callers don't need to check whether it is set.
+type apiStats struct {
+	mu         sync.Mutex
+	operations map[string]*APICallStats
+}
+
+// newAPIStats creates an empty set of statistics
+func newAPIStats() *apiStats {
+	return &apiStats{operations: make(map[string]*APICallStats)}
+}
+
+// instrument counts the ECR calls of every client created from awsConfig,
+// and of the copies made for other accounts and regions
+func (s *apiStats) instrument(awsConfig *aws.Config) {
+	awsConfig.APIOptions = append(awsConfig.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ecrCleanupAPIStats", s.handleInitialize), middleware.After)
+	})
+}
+
+// handleInitialize records the attempts the SDK made for an ECR call
+func (s *apiStats) handleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	switch awsmiddleware.GetServiceID(ctx) {
+	case "ECR", "ECR PUBLIC":
+	default:
+		return out, metadata, err
+	}
+
+	// Calls that fail before being sent have no attempt results
+	results, _ := retry.GetAttemptResults(metadata)
+	if len(results.Results) == 0 {
+		s.record(awsmiddleware.GetOperationName(ctx), 1, isThrottlingError(err), false)
+		return out, metadata, err
+	}
+	for i, result := range results.Results {
+		s.record(awsmiddleware.GetOperationName(ctx), 1, isThrottlingError(result.Err), i > 0)
+	}
+	return out, metadata, err
+}
+
+// retried records that a call was repeated after ECR throttled it, beyond
+// the SDK's own retries
+func (s *apiStats) retried(operation string) {
+	s.record(operation, 0, false, true)
+}
+
+// record adds to the statistics of an operation
+func (s *apiStats) record(operation string, calls int, throttled, retry bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.operations[operation]
+	if stats == nil {
+		stats = &APICallStats{Operation: operation}
+		s.operations[operation] = stats
+	}
+	stats.Calls += calls
+	if throttled {
+		stats.Throttles++
+	}
+	if retry {
+		stats.Retries++
+	}
+}
+
+// calls returns the statistics of every operation called, by operation name
+func (s *apiStats) calls() []APICallStats {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]APICallStats, 0, len(s.operations))
+	for _, stats := range s.operations {
+		calls = append(calls, *stats)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Operation < calls[j].Operation })
+	return calls
+}
+
+// apiCallTotals sums up the statistics of every operation
+func apiCallTotals(calls []APICallStats) APICallStats {
+	var total APICallStats
+	for _, stats := range calls {
+		total.Calls += stats.Calls
+		total.Throttles += stats.Throttles
+		total.Retries += stats.Retries
+	}
+	return total
+}
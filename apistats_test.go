@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// TestAPIStatsCountsAttempts tests counting every request the SDK sends,
+// throttles and retries included
+func TestAPIStatsCountsAttempts(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if requests <= 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ThrottlingException","message":"Rate exceeded"}`)
+			return
+		}
+		fmt.Fprint(w, `{"repositories":[]}`)
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.RateLimiter = ratelimit.None
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			})
+		},
+	}
+	stats := newAPIStats()
+	stats.instrument(&awsConfig)
+
+	client := ecr.NewFromConfig(awsConfig)
+	if _, err := client.DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	client.DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{})
+
+	calls := stats.calls()
+	want := APICallStats{Operation: "DescribeRepositories", Calls: 4, Throttles: 2, Retries: 2}
+	if len(calls) != 1 || calls[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, calls)
+	}
+}
+
+// TestAPIStatsThrottledClient tests counting the retries of throttledClient
+// on top of the SDK's
+func TestAPIStatsThrottledClient(t *testing.T) {
+	stats := newAPIStats()
+	flaky := &FlakyECRClient{MockECRClient: MockECRClient{DescribeImagesOutput: &ecr.DescribeImagesOutput{}}, Throttles: 2}
+	client, _ := newTestThrottledClient(flaky, Config{apiStats: stats})
+
+	if _, err := client.DescribeImages(context.Background(), &ecr.DescribeImagesInput{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls := stats.calls(); len(calls) != 1 || calls[0].Retries != 2 {
+		t.Errorf("Expected 2 DescribeImages retries, got %+v", calls)
+	}
+
+	total := apiCallTotals([]APICallStats{{Calls: 3, Throttles: 1}, {Calls: 2, Retries: 1}})
+	if total != (APICallStats{Calls: 5, Throttles: 1, Retries: 1}) {
+		t.Errorf("Expected the operations summed up, got %+v", total)
+	}
+
+	var none *apiStats
+	none.retried("DescribeImages")
+	if none.calls() != nil {
+		t.Error("Expected no statistics without a collector")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -archive-to: every image is copied into an archive
+// repository before it is deleted, so a deletion can be undone for as long
+// as the archive keeps it. The target is either one repository holding
+// every archived image by digest, or a prefix ending in "/" under which
+// each repository gets its own archive repository with the original tags.
+// Either can be in another ECR registry, written as a host before the
+// repository as in an image reference.
+
+// ecrRegistryHost matches the host of an ECR registry
+var ecrRegistryHost = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// ArchiveClient defines the ECR operations needed to archive images
+type ArchiveClient interface {
+	AuthorizationClient
+	CreateRepository(ctx context.Context, params *ecr.CreateRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.CreateRepositoryOutput, error)
+}
+
+// archiveTarget is where -archive-to copies images
+type archiveTarget struct {
+	// Host is the archive registry, or empty for the registry being cleaned up
+	Host string
+
+	// Repository is the archive repository, or the prefix of the archive
+	// repositories when it ends in "/"
+	Repository string
+}
+
+// archiver copies images into the archive before they are deleted
+type archiver struct {
+	target     archiveTarget
+	registryID string
+	src        *registryClient
+	dst        *registryClient
+	client     ArchiveClient
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+// parseArchiveTarget parses the value of -archive-to
+func parseArchiveTarget(value string) (archiveTarget, error) {
+	target := archiveTarget{Repository: value}
+	if first, rest, ok := strings.Cut(value, "/"); ok && strings.ContainsAny(first, ".:") {
+		if !ecrRegistryHost.MatchString(first) {
+			return archiveTarget{}, fmt.Errorf("invalid -archive-to %q: %s is not an ECR registry", value, first)
+		}
+		target = archiveTarget{Host: first, Repository: rest}
+	}
+	if strings.Trim(target.Repository, "/") == "" {
+		return archiveTarget{}, fmt.Errorf("invalid -archive-to %q: expected a repository or a prefix ending in /", value)
+	}
+	return target, nil
+}
+
+// region returns the region of the archive registry, or empty for the
+// registry being cleaned up
+func (t archiveTarget) region() string {
+	if match := ecrRegistryHost.FindStringSubmatch(t.Host); match != nil {
+		return match[2]
+	}
+	return ""
+}
+
+// newArchiver logs in to the registry being cleaned up and the archive
+// registry, whose client is dstClient, over httpClient
+func newArchiver(ctx context.Context, srcClient AuthorizationClient, dstClient ArchiveClient, target archiveTarget, httpClient aws.HTTPClient) (*archiver, error) {
+	src, err := newRegistryClient(ctx, srcClient, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := newRegistryClient(ctx, dstClient, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// The archive registry's token is for its region; the host picks the
+	// account within it
+	var registryID string
+	if target.Host != "" {
+		dst.baseURL = "https://" + target.Host
+		registryID = ecrRegistryHost.FindStringSubmatch(target.Host)[1]
+	}
+
+	return &archiver{
+		target:     target,
+		registryID: registryID,
+		src:        src,
+		dst:        dst,
+		client:     dstClient,
+		created:    make(map[string]bool),
+	}, nil
+}
+
+// repository returns the archive repository of a repository
+func (a *archiver) repository(repoName string) string {
+	if strings.HasSuffix(a.target.Repository, "/") {
+		return a.target.Repository + repoName
+	}
+	return a.target.Repository
+}
+
+// isArchive reports whether a repository of the registry being cleaned up
+// is an archive repository, which must not be cleaned up itself
+func (a *archiver) isArchive(repoName string) bool {
+	if a == nil || a.src.baseURL != a.dst.baseURL {
+		return false
+	}
+	if strings.HasSuffix(a.target.Repository, "/") {
+		return strings.HasPrefix(repoName, a.target.Repository)
+	}
+	return repoName == a.target.Repository
+}
+
+// withoutArchive returns the repositories that aren't archive repositories
+func (a *archiver) withoutArchive(repos []types.Repository) []types.Repository {
+	var remaining []types.Repository
+	for _, repo := range repos {
+		if a.isArchive(aws.ToString(repo.RepositoryName)) {
+			slog.Info("Skipping archive repository", "repository", aws.ToString(repo.RepositoryName))
+			continue
+		}
+		remaining = append(remaining, repo)
+	}
+	return remaining
+}
+
+// archiveImages copies images of a repository into its archive repository
+func (a *archiver) archiveImages(ctx context.Context, repoName string, images []types.ImageDetail) error {
+	dstRepo := a.repository(repoName)
+	if err := a.createRepository(ctx, dstRepo); err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		// A shared archive repository keeps images by digest only, since
+		// tags of different repositories would collide
+		var tags []string
+		if strings.HasSuffix(a.target.Repository, "/") {
+			tags = img.ImageTags
+		}
+
+		digest := aws.ToString(img.ImageDigest)
+		if err := a.dst.copyImage(ctx, a.src, repoName, digest, dstRepo, tags); err != nil {
+			return fmt.Errorf("failed to archive image %s: %w", digest, err)
+		}
+		slog.Info("Archived image", "action", "archive", "repository", repoName, "tag", getImageTag(img), "digest", digest, "archive", a.dst.host()+"/"+dstRepo)
+	}
+	return nil
+}
+
+// createRepository creates an archive repository unless it exists
+func (a *archiver) createRepository(ctx context.Context, repoName string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.created[repoName] {
+		return nil
+	}
+
+	input := &ecr.CreateRepositoryInput{RepositoryName: aws.String(repoName)}
+	if a.registryID != "" {
+		input.RegistryId = aws.String(a.registryID)
+	}
+	_, err := a.client.CreateRepository(ctx, input)
+	var exists *types.RepositoryAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create archive repository %s: %w", repoName, err)
+	}
+	if err == nil {
+		slog.Info("Created archive repository", "repository", repoName)
+	}
+
+	a.created[repoName] = true
+	return nil
+}
+
+// newRegionArchiver creates the archiver for the region of the AWS config
+func newRegionArchiver(ctx context.Context, awsConfig aws.Config, value string) (*archiver, error) {
+	target, err := parseArchiveTarget(value)
+	if err != nil {
+		return nil, err
+	}
+
+	srcClient := ecr.NewFromConfig(awsConfig)
+	dstClient := srcClient
+	if region := target.region(); region != "" && region != awsConfig.Region {
+		dstConfig := awsConfig.Copy()
+		dstConfig.Region = region
+		dstClient = ecr.NewFromConfig(dstConfig)
+	}
+	return newArchiver(ctx, srcClient, dstClient, target, awsConfig.HTTPClient)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// fakeRegistry serves the OCI distribution API from memory
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string]map[string][]byte
+	manifests map[string]map[string]fakeManifest
+	uploads   map[string][]byte
+	mounts    int
+	streamed  int
+}
+
+// fakeManifest is a manifest stored by the fake registry
+type fakeManifest struct {
+	body      []byte
+	mediaType string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		blobs:     make(map[string]map[string][]byte),
+		manifests: make(map[string]map[string]fakeManifest),
+		uploads:   make(map[string][]byte),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// digestOf returns the digest of content
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// addBlob stores a blob in a repository and returns its descriptor
+func (r *fakeRegistry) addBlob(repo, content string) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf([]byte(content))
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = make(map[string][]byte)
+	}
+	r.blobs[repo][digest] = []byte(content)
+	return descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: digest, Size: int64(len(content))}
+}
+
+// addManifest stores a manifest in a repository under its digest and tags
+// and returns its digest
+func (r *fakeRegistry) addManifest(repo string, m any, mediaType string, tags ...string) string {
+	body, _ := json.Marshal(m)
+	digest := digestOf(body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ref := range append([]string{digest}, tags...) {
+		r.putManifestLocked(repo, ref, fakeManifest{body: body, mediaType: mediaType})
+	}
+	return digest
+}
+
+// manifest returns a stored manifest
+func (r *fakeRegistry) manifest(repo, ref string) (fakeManifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[repo][ref]
+	return m, ok
+}
+
+// hasBlob reports whether a repository holds a blob
+func (r *fakeRegistry) hasBlob(repo, digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.blobs[repo][digest]
+	return ok
+}
+
+func (r *fakeRegistry) putManifestLocked(repo, ref string, m fakeManifest) {
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = make(map[string]fakeManifest)
+	}
+	r.manifests[repo][ref] = m
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Basic token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repo, id, _ := strings.Cut(path, "/blobs/uploads/")
+		r.serveUpload(w, req, repo, id)
+	case strings.Contains(path, "/blobs/"):
+		repo, digest, _ := strings.Cut(path, "/blobs/")
+		content, ok := r.blobs[repo][digest]
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		if req.Method == http.MethodPut {
+			body, _ := io.ReadAll(req.Body)
+			for _, blob := range referencedBlobs(body) {
+				if _, ok := r.blobs[repo][blob]; !ok {
+					http.Error(w, "blob unknown "+blob, http.StatusBadRequest)
+					return
+				}
+			}
+			r.putManifestLocked(repo, ref, fakeManifest{body: body, mediaType: req.Header.Get("Content-Type")})
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		m, ok := r.manifests[repo][ref]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Write(m.body)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = make(map[string][]byte)
+	}
+	switch req.Method {
+	case http.MethodPost:
+		if digest := req.URL.Query().Get("mount"); digest != "" {
+			if content, ok := r.blobs[req.URL.Query().Get("from")][digest]; ok {
+				r.blobs[repo][digest] = content
+				r.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		id = fmt.Sprintf("upload-%d", len(r.uploads))
+		r.uploads[id] = nil
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		body, _ := io.ReadAll(req.Body)
+		r.uploads[id] = append(r.uploads[id], body...)
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		content := r.uploads[id]
+		if digest := req.URL.Query().Get("digest"); digest != digestOf(content) {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		r.blobs[repo][digestOf(content)] = content
+		r.streamed++
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// referencedBlobs returns the config and layer digests of a manifest
+func referencedBlobs(body []byte) []string {
+	var m manifest
+	json.Unmarshal(body, &m)
+	var digests []string
+	for _, layer := range m.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	if m.Config != nil {
+		digests = append(digests, m.Config.Digest)
+	}
+	return digests
+}
+
+// mockArchiveClient logs in to a fake registry and records created repositories
+type mockArchiveClient struct {
+	endpoint string
+	created  []string
+}
+
+func (m *mockArchiveClient) GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	return &ecr.GetAuthorizationTokenOutput{AuthorizationData: []types.AuthorizationData{{
+		AuthorizationToken: aws.String("token"),
+		ProxyEndpoint:      aws.String(m.endpoint),
+	}}}, nil
+}
+
+func (m *mockArchiveClient) CreateRepository(ctx context.Context, params *ecr.CreateRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.CreateRepositoryOutput, error) {
+	for _, name := range m.created {
+		if name == *params.RepositoryName {
+			return nil, &types.RepositoryAlreadyExistsException{Message: aws.String("exists")}
+		}
+	}
+	m.created = append(m.created, *params.RepositoryName)
+	return &ecr.CreateRepositoryOutput{}, nil
+}
+
+// pushTestImage stores a multi-platform image in a repository of the fake
+// registry and returns its digest
+func pushTestImage(r *fakeRegistry, repo string, tags ...string) string {
+	config := r.addBlob(repo, `{"architecture":"amd64"}`)
+	layer := r.addBlob(repo, "layer "+repo)
+	child := r.addManifest(repo, manifest{MediaType: mediaTypeOCIManifest, Config: &config, Layers: []descriptor{layer}}, mediaTypeOCIManifest)
+	return r.addManifest(repo, manifest{MediaType: mediaTypeOCIIndex, Manifests: []descriptor{{MediaType: mediaTypeOCIManifest, Digest: child}}}, mediaTypeOCIIndex, tags...)
+}
+
+// TestParseArchiveTarget tests parsing -archive-to
+func TestParseArchiveTarget(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected archiveTarget
+		valid    bool
+	}{
+		{"archive", archiveTarget{Repository: "archive"}, true},
+		{"archive/", archiveTarget{Repository: "archive/"}, true},
+		{"team/archive", archiveTarget{Repository: "team/archive"}, true},
+		{"123456789012.dkr.ecr.eu-west-1.amazonaws.com/archive/", archiveTarget{Host: "123456789012.dkr.ecr.eu-west-1.amazonaws.com", Repository: "archive/"}, true},
+		{"docker.io/archive", archiveTarget{}, false},
+		{"123456789012.dkr.ecr.eu-west-1.amazonaws.com/", archiveTarget{}, false},
+		{"/", archiveTarget{}, false},
+	}
+
+	for _, tt := range tests {
+		target, err := parseArchiveTarget(tt.value)
+		if tt.valid != (err == nil) || target != tt.expected {
+			t.Errorf("parseArchiveTarget(%q) = %+v, %v", tt.value, target, err)
+		}
+	}
+
+	target, _ := parseArchiveTarget("123456789012.dkr.ecr.eu-west-1.amazonaws.com/archive")
+	if target.region() != "eu-west-1" {
+		t.Errorf("Expected the archive region, got %q", target.region())
+	}
+}
+
+// TestArchiveImages tests copying images into the archive
+func TestArchiveImages(t *testing.T) {
+	t.Run("Prefix in the same registry", func(t *testing.T) {
+		registry := newFakeRegistry(t)
+		digest := pushTestImage(registry, "team/api", "v1")
+		client := &mockArchiveClient{endpoint: registry.URL}
+
+		a, err := newArchiver(context.Background(), client, client, archiveTarget{Repository: "archive/"}, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		images := []types.ImageDetail{{ImageDigest: aws.String(digest), ImageTags: []string{"v1"}}}
+		for i := 0; i < 2; i++ {
+			if err := a.archiveImages(context.Background(), "team/api", images); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+
+		if _, ok := registry.manifest("archive/team/api", digest); !ok {
+			t.Error("Expected the image archived by digest")
+		}
+		if m, ok := registry.manifest("archive/team/api", "v1"); !ok || digestOf(m.body) != digest || m.mediaType != mediaTypeOCIIndex {
+			t.Error("Expected the archived image tagged as in its repository")
+		}
+		if registry.mounts != 2 || registry.streamed != 0 {
+			t.Errorf("Expected both blobs mounted once, got %d mounts and %d uploads", registry.mounts, registry.streamed)
+		}
+		if len(client.created) != 1 || client.created[0] != "archive/team/api" {
+			t.Errorf("Expected the archive repository created, got %v", client.created)
+		}
+	})
+
+	t.Run("Repository in another registry", func(t *testing.T) {
+		source := newFakeRegistry(t)
+		archive := newFakeRegistry(t)
+		digest := pushTestImage(source, "web", "latest")
+
+		a, err := newArchiver(context.Background(), &mockArchiveClient{endpoint: source.URL}, &mockArchiveClient{endpoint: archive.URL}, archiveTarget{Repository: "archive"}, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		images := []types.ImageDetail{{ImageDigest: aws.String(digest), ImageTags: []string{"latest"}}}
+		if err := a.archiveImages(context.Background(), "web", images); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if _, ok := archive.manifest("archive", digest); !ok {
+			t.Error("Expected the image archived by digest")
+		}
+		if _, ok := archive.manifest("archive", "latest"); ok {
+			t.Error("Expected a shared archive repository to keep images by digest only")
+		}
+		if archive.streamed != 2 || !archive.hasBlob("archive", digestOf([]byte("layer web"))) {
+			t.Errorf("Expected both blobs uploaded, got %d uploads", archive.streamed)
+		}
+	})
+}
+
+// TestDeleteRepositoryImagesArchive tests that images are only deleted once archived
+func TestDeleteRepositoryImagesArchive(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := &mockArchiveClient{endpoint: registry.URL}
+	a, err := newArchiver(context.Background(), client, client, archiveTarget{Repository: "archive/"}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	archived := pushTestImage(registry, "api")
+	ecrClient := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}
+	images := []types.ImageDetail{{ImageDigest: aws.String(archived)}, {ImageDigest: aws.String("sha256:missing")}}
+
+	deleted, err := deleteRepositoryImages(context.Background(), ecrClient, "api", images, Config{archive: a})
+	if err == nil || !strings.Contains(err.Error(), "failed to archive image sha256:missing") {
+		t.Errorf("Expected the archive error, got %v", err)
+	}
+	if len(deleted) != 0 || ecrClient.BatchDeleteImageCalls != 0 {
+		t.Errorf("Expected nothing deleted, got %d images in %d calls", len(deleted), ecrClient.BatchDeleteImageCalls)
+	}
+
+	if !a.isArchive("archive/api") || a.isArchive("api") {
+		t.Error("Expected only the archive repositories to be recognized")
+	}
+	repos := a.withoutArchive([]types.Repository{{RepositoryName: aws.String("api")}, {RepositoryName: aws.String("archive/api")}})
+	if len(repos) != 1 || *repos[0].RepositoryName != "api" {
+		t.Errorf("Expected the archive repository left out, got %v", repos)
+	}
+}
+
+// TestNewRegistryClientAuthorizationError tests failing before anything is deleted
+// when the registry can't be logged in to
+func TestNewRegistryClientAuthorizationError(t *testing.T) {
+	client := &mockAuthorizationClient{err: errors.New("access denied")}
+	if _, err := newRegistryClient(context.Background(), client, nil); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("Expected the authorization error, got %v", err)
+	}
+}
+
+// TestNewRegistryClientHTTPClient tests talking to the registry with the
+// HTTP client of the AWS config, so -proxy-url and -ca-bundle apply
+func TestNewRegistryClientHTTPClient(t *testing.T) {
+	httpClient := &countingHTTPClient{}
+	r, err := newRegistryClient(context.Background(), &mockArchiveClient{endpoint: "https://registry.example"}, httpClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, err := r.getManifest(context.Background(), "api", "latest"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if httpClient.requests != 1 {
+		t.Errorf("Expected the manifest fetched with the given client, got %d requests", httpClient.requests)
+	}
+
+	r, err = newRegistryClient(context.Background(), &mockArchiveClient{endpoint: "https://registry.example"}, nil)
+	if err != nil || r.http != http.DefaultClient {
+		t.Errorf("Expected the default client without one, got %v", err)
+	}
+}
+
+// countingHTTPClient counts the requests it answers
+type countingHTTPClient struct {
+	requests int
+}
+
+func (c *countingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+// mockAuthorizationClient fails to log in
+type mockAuthorizationClient struct {
+	err error
+}
+
+func (m *mockAuthorizationClient) GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	return nil, m.err
+}
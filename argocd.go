@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This file contains the Argo CD in-use provider. For teams that treat Argo
+// CD as the authority on what's deployed, the images its applications report
+// in their status are never deleted, whether or not the cluster currently
+// runs them.
+
+// argoCDTimeout bounds the request that lists the Argo CD applications
+const argoCDTimeout = 30 * time.Second
+
+// argoCDApplications is the part of Argo CD's application list the provider
+// reads
+type argoCDApplications struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Summary struct {
+				Images []string `json:"images"`
+			} `json:"summary"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// parseArgoCDServer validates an -argocd-server URL
+func parseArgoCDServer(server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Argo CD server %q: expected an http(s) URL", server)
+	}
+	return u, nil
+}
+
+// loadArgoCDImages collects the images deployed by the Argo CD applications
+// of -argocd-server, if any
+func loadArgoCDImages(ctx context.Context, cfg Config) (*keepSet, error) {
+	if cfg.ArgoCDServer == "" {
+		return nil, nil
+	}
+
+	client := &http.Client{Timeout: argoCDTimeout}
+	keep, err := collectArgoCDImages(ctx, client, cfg.ArgoCDServer, cfg.ArgoCDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect Argo CD images: %w", err)
+	}
+	slog.Info("Protecting images deployed by Argo CD", "images", keep.size(), "server", cfg.ArgoCDServer)
+	return keep, nil
+}
+
+// collectArgoCDImages lists the applications of an Argo CD server and adds
+// the ECR images in their status summaries
+func collectArgoCDImages(ctx context.Context, client *http.Client, server, token string) (*keepSet, error) {
+	u, err := parseArgoCDServer(server)
+	if err != nil {
+		return nil, err
+	}
+	u = u.JoinPath("api", "v1", "applications")
+	u.RawQuery = url.Values{"fields": {"items.metadata.name,items.status.summary.images"}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "ecr-cleanup")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Argo CD returned %s", resp.Status)
+	}
+
+	var apps argoCDApplications
+	if err := json.NewDecoder(resp.Body).Decode(&apps); err != nil {
+		return nil, fmt.Errorf("failed to decode the Argo CD applications: %w", err)
+	}
+
+	keep := newKeepSet()
+	for _, app := range apps.Items {
+		for _, image := range app.Status.Summary.Images {
+			keep.addURI(strings.TrimSpace(image))
+		}
+		slog.Debug("Read Argo CD application", "application", app.Metadata.Name, "images", len(app.Status.Summary.Images))
+	}
+	return keep, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newArgoCDServer serves an application list to requests with the token
+func newArgoCDServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/applications" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "no session information", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"items":[
+			{"metadata":{"name":"api"},"status":{"summary":{"images":["123456789012.dkr.ecr.us-east-1.amazonaws.com/repo0@sha256:001","redis:7"]}}},
+			{"metadata":{"name":"web"},"status":{"summary":{"images":["123456789012.dkr.ecr.us-east-1.amazonaws.com/web:v2"]}}},
+			{"metadata":{"name":"new"},"status":{}}
+		]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestCollectArgoCDImages tests reading the images of Argo CD applications
+func TestCollectArgoCDImages(t *testing.T) {
+	server := newArgoCDServer(t, "secret")
+
+	keep, err := collectArgoCDImages(context.Background(), server.Client(), server.URL+"/", "secret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if keep.size() != 2 || !keep.digests["repo0"]["sha256:001"] || !keep.tags["web"]["v2"] {
+		t.Errorf("Expected the ECR images of every application, got %+v %+v", keep.tags, keep.digests)
+	}
+
+	if _, err := collectArgoCDImages(context.Background(), server.Client(), server.URL, "wrong"); err == nil {
+		t.Error("Expected an error for a rejected token")
+	}
+	for _, invalid := range []string{"argocd.example.com", "ftp://argocd.example.com", "https://"} {
+		if _, err := parseArgoCDServer(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+// TestCleanupWithArgoCD tests that images deployed by Argo CD are never
+// deleted, and that the run fails when Argo CD can't be read
+func TestCleanupWithArgoCD(t *testing.T) {
+	server := newArgoCDServer(t, "secret")
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, ArgoCDServer: server.URL, ArgoCDToken: "secret"}, newGuardrailClient(2, 5))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 9 {
+		t.Errorf("Expected the deployed image kept, got %d images deleted", summary.ImagesDeleted)
+	}
+
+	if _, err := CleanupWithClient(context.Background(), Config{Days: 10, ArgoCDServer: server.URL}, newGuardrailClient(1, 1)); err == nil {
+		t.Error("Expected an unreadable Argo CD to fail the run")
+	}
+}
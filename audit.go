@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the -audit-file log: every decision a run makes about
+// an image is appended to the file as a JSON line tagged with the run's
+// ID, so the file answers questions such as "why was v1.4.2 deleted?"
+// long after the run's logs are gone. Images are recorded when they are
+// selected for deletion, deleted, fail to be deleted, or are skipped
+// although the retention policy selected them.
+
+// Audit actions
+const (
+	auditSelected = "selected"
+	auditDeleted  = "deleted"
+	auditFailed   = "failed"
+	auditSkipped  = "skipped"
+)
+
+// AuditEvent is one line of the audit file
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	RunID      string    `json:"run_id"`
+	Action     string    `json:"action"`
+	DryRun     bool      `json:"dry_run"`
+	AccountID  string    `json:"account_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Repository string    `json:"repository"`
+	Digest     string    `json:"digest"`
+	Tags       []string  `json:"tags,omitempty"`
+	PushedAt   time.Time `json:"pushed_at"`
+	SizeBytes  int64     `json:"size_bytes"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// auditLog appends events to the audit file. A nil auditLog records
+// nothing, so callers don't need to check whether -audit-file is set.
+type auditLog struct {
+	runID string
+	now   func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openAuditLog opens the audit file for appending, creating it if needed
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &auditLog{runID: newRunID(), now: time.Now, file: file}, nil
+}
+
+// newRunID returns a random (version 4) UUID
+func newRunID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// close closes the audit file
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// record appends an event for each image of a repository
+func (a *auditLog) record(cfg Config, action, repoName string, images []types.ImageDetail, reason string) {
+	if a == nil || len(images) == 0 {
+		return
+	}
+
+	var lines []byte
+	for _, img := range images {
+		line, _ := json.Marshal(AuditEvent{
+			Time:       a.now().UTC(),
+			RunID:      a.runID,
+			Action:     action,
+			DryRun:     cfg.DryRun,
+			AccountID:  cfg.accountID,
+			Region:     cfg.region,
+			Repository: repoName,
+			Digest:     aws.ToString(img.ImageDigest),
+			Tags:       img.ImageTags,
+			PushedAt:   aws.ToTime(img.ImagePushedAt).UTC(),
+			SizeBytes:  aws.ToInt64(img.ImageSizeInBytes),
+			Reason:     reason,
+		})
+		lines = append(append(lines, line...), '\n')
+	}
+
+	// One write per call keeps the lines of concurrent repositories whole
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file.Write(lines)
+}
+
+// kept records the images of before that a guardrail left out of after as
+// skipped, and returns after
+func (a *auditLog) kept(cfg Config, repoName string, before, after []types.ImageDetail, reason string) []types.ImageDetail {
+	if a == nil || len(before) == len(after) {
+		return after
+	}
+
+	remaining := make(map[string]bool, len(after))
+	for _, img := range after {
+		remaining[aws.ToString(img.ImageDigest)] = true
+	}
+	var skipped []types.ImageDetail
+	for _, img := range before {
+		if !remaining[aws.ToString(img.ImageDigest)] {
+			skipped = append(skipped, img)
+		}
+	}
+	a.record(cfg, auditSkipped, repoName, skipped, reason)
+	return after
+}
+
+// selectionReason explains why the images of a repository were selected
+func selectionReason(repoName string, cfg Config) string {
+	if cfg.plan != nil {
+		return "selected in plan " + cfg.plan.ID
+	}
+
+	retention := cfg
+	if cfg.pullThrough.contains(repoName) {
+		retention = pullThroughRetention(cfg)
+	}
+	reasons := []string{retention.ageLimit()}
+	if retention.MaxImages > 0 {
+		reasons = append(reasons, fmt.Sprintf("not among the %d newest images", retention.MaxImages))
+	}
+	if retention.NeverPulledDays > 0 {
+		reasons = append(reasons, fmt.Sprintf("or never pulled %d days after push", retention.NeverPulledDays))
+	}
+	if retention.HonorExpiryLabels {
+		reasons = append(reasons, "or past its expiry label")
+	}
+	return strings.Join(reasons, ", ")
+}
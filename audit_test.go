@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// readAuditFile returns the events of an audit file
+func readAuditFile(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit file: %v", err)
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Expected a JSON line, got %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// TestAuditLog tests recording every decision of a run in the audit file
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	client := newGuardrailClient(1, 3)
+	client.BatchDeleteImageOutput = &ecr.BatchDeleteImageOutput{Failures: []types.ImageFailure{{
+		ImageId:       &types.ImageIdentifier{ImageDigest: aws.String("sha256:002")},
+		FailureReason: aws.String("image is referenced by an index"),
+	}}}
+
+	for run := 0; run < 2; run++ {
+		audit, err := openAuditLog(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		cfg := Config{Days: 10, KeepNewest: true, region: "us-east-1", audit: audit}
+		if _, err := CleanupWithClient(context.Background(), cfg, client); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		audit.close()
+	}
+
+	events := readAuditFile(t, path)
+	if len(events) != 10 {
+		t.Fatalf("Expected 5 events per run appended, got %d", len(events))
+	}
+	if events[0].RunID == events[5].RunID || !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(events[0].RunID) {
+		t.Errorf("Expected a UUID per run, got %q and %q", events[0].RunID, events[5].RunID)
+	}
+
+	expected := []struct {
+		action, digest, reason string
+	}{
+		{auditSkipped, "sha256:000", "newest image of the repository"},
+		{auditSelected, "sha256:001", "older than 10 days"},
+		{auditSelected, "sha256:002", "older than 10 days"},
+		{auditDeleted, "sha256:001", ""},
+		{auditFailed, "sha256:002", "image is referenced by an index"},
+	}
+	for i, want := range expected {
+		got := events[i]
+		if got.Action != want.action || got.Digest != want.digest || got.Reason != want.reason || got.Repository != "repo0" || got.Region != "us-east-1" || got.RunID != events[0].RunID {
+			t.Errorf("Event %d: expected %s %s (%s), got %+v", i, want.action, want.digest, want.reason, got)
+		}
+	}
+}
+
+// TestAuditLogStopped tests recording the images a refused run didn't delete
+func TestAuditLogStopped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer audit.close()
+
+	cfg := Config{Days: 10, MaxDeletePercent: 50, audit: audit}
+	if _, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(1, 4)); err == nil {
+		t.Fatal("Expected the registry to be refused")
+	}
+
+	events := readAuditFile(t, path)
+	if len(events) != 8 {
+		t.Fatalf("Expected every image selected and skipped, got %d events", len(events))
+	}
+	for _, event := range events[4:] {
+		if event.Action != auditSkipped || event.Reason == "" {
+			t.Errorf("Expected the image skipped with the refusal, got %+v", event)
+		}
+	}
+}
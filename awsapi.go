@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go/logging"
+)
+
+// This file contains the client of the AWS APIs the tool calls without an
+// SDK module: Lambda, Security Hub and CloudWatch, each used for one or two
+// operations. Their requests are signed with the credentials of the AWS
+// config and sent over the config's HTTP client, to the endpoint the SDK
+// would pick: the partition's, with the FIPS and dual-stack variants of
+// -fips and -dual-stack. Failed calls are retried by the AWS config's
+// retryer, so -aws-retry-mode and -aws-max-attempts apply to them too.
+
+// maxAPIResponseSize bounds the response bodies read from those APIs
+const maxAPIResponseSize = 16 << 20
+
+// signedAPIClient calls the API of an AWS service in one region
+type signedAPIClient struct {
+	endpoint    string
+	service     string
+	region      string
+	credentials aws.CredentialsProvider
+	http        aws.HTTPClient
+	signer      *v4.Signer
+	retryer     aws.Retryer
+	logger      logging.Logger
+	logMode     aws.ClientLogMode
+
+	// sleep waits between attempts; replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newSignedAPIClient creates the client of a service (its endpoint prefix,
+// e.g. securityhub) in the AWS config's region, honoring -endpoint-url
+func newSignedAPIClient(awsConfig aws.Config, service string) *signedAPIClient {
+	endpoint := serviceEndpoint(awsConfig, service)
+	if awsConfig.BaseEndpoint != nil {
+		endpoint = strings.TrimSuffix(aws.ToString(awsConfig.BaseEndpoint), "/")
+	}
+	httpClient := awsConfig.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &signedAPIClient{
+		endpoint:    endpoint,
+		service:     service,
+		region:      awsConfig.Region,
+		credentials: awsConfig.Credentials,
+		http:        httpClient,
+		signer:      v4.NewSigner(),
+		retryer:     configRetryer(awsConfig),
+		logger:      awsConfig.Logger,
+		logMode:     awsConfig.ClientLogMode,
+		sleep:       sleepContext,
+	}
+}
+
+// serviceEndpoint returns the endpoint of a service in the AWS config's
+// region: the partition's domain, in its FIPS and dual-stack variants when
+// the config asks for them
+func serviceEndpoint(awsConfig aws.Config, service string) string {
+	fips, dualStack := endpointVariants(awsConfig)
+	domain, dualStackDomain := "amazonaws.com", "api.aws"
+	switch {
+	case strings.HasPrefix(awsConfig.Region, "cn-"):
+		domain, dualStackDomain = "amazonaws.com.cn", "api.amazonwebservices.com.cn"
+	case strings.HasPrefix(awsConfig.Region, "us-isob-"):
+		domain, dualStackDomain = "sc2s.sgov.gov", "sc2s.sgov.gov"
+	case strings.HasPrefix(awsConfig.Region, "us-iso-"):
+		domain, dualStackDomain = "c2s.ic.gov", "c2s.ic.gov"
+	}
+	if dualStack {
+		domain = dualStackDomain
+	}
+	if fips {
+		service += "-fips"
+	}
+	return fmt.Sprintf("https://%s.%s.%s", service, awsConfig.Region, domain)
+}
+
+// endpointVariants reports whether the AWS config's sources, the -fips and
+// -dual-stack flags among them, enable the FIPS and dual-stack endpoints
+func endpointVariants(awsConfig aws.Config) (fips, dualStack bool) {
+	ctx := context.Background()
+	fipsFound, dualStackFound := false, false
+	for _, source := range awsConfig.ConfigSources {
+		if p, ok := source.(interface {
+			GetUseFIPSEndpoint(context.Context) (aws.FIPSEndpointState, bool, error)
+		}); ok && !fipsFound {
+			if state, found, err := p.GetUseFIPSEndpoint(ctx); err == nil && found {
+				fips, fipsFound = state == aws.FIPSEndpointStateEnabled, true
+			}
+		}
+		if p, ok := source.(interface {
+			GetUseDualStackEndpoint(context.Context) (aws.DualStackEndpointState, bool, error)
+		}); ok && !dualStackFound {
+			if state, found, err := p.GetUseDualStackEndpoint(ctx); err == nil && found {
+				dualStack, dualStackFound = state == aws.DualStackEndpointStateEnabled, true
+			}
+		}
+	}
+	return fips, dualStack
+}
+
+// configRetryer returns the retryer the SDK clients of the AWS config use
+func configRetryer(awsConfig aws.Config) aws.Retryer {
+	if awsConfig.Retryer != nil {
+		return awsConfig.Retryer()
+	}
+	var retryer aws.Retryer = retry.NewStandard()
+	if awsConfig.RetryMode == aws.RetryModeAdaptive {
+		retryer = retry.NewAdaptiveMode()
+	}
+	if awsConfig.RetryMaxAttempts > 0 {
+		retryer = retry.AddWithMaxAttempts(retryer, awsConfig.RetryMaxAttempts)
+	}
+	return retryer
+}
+
+// signedAPIError is a response of the API other than 200. It carries the
+// status and error code the retryer classifies errors by.
+type signedAPIError struct {
+	status     string
+	statusCode int
+	body       string
+	errorCode  string
+}
+
+func (e *signedAPIError) Error() string       { return fmt.Sprintf("%s: %s", e.status, e.body) }
+func (e *signedAPIError) HTTPStatusCode() int { return e.statusCode }
+func (e *signedAPIError) ErrorCode() string   { return e.errorCode }
+
+// apiErrorCode reads the error code of a failed response: the header of the
+// JSON APIs, or the code in the JSON or XML body
+func apiErrorCode(resp *http.Response, body []byte) string {
+	if code := resp.Header.Get("X-Amzn-ErrorType"); code != "" {
+		code, _, _ = strings.Cut(code, ":")
+		return code
+	}
+	var jsonErr struct {
+		Type string `json:"__type"`
+		Code string `json:"code"`
+	}
+	if json.Unmarshal(body, &jsonErr) == nil {
+		code := jsonErr.Code
+		if jsonErr.Type != "" {
+			code = jsonErr.Type[strings.LastIndex(jsonErr.Type, "#")+1:]
+		}
+		return code
+	}
+	var xmlErr struct {
+		Code string `xml:"Error>Code"`
+	}
+	if xml.Unmarshal(body, &xmlErr) == nil {
+		return xmlErr.Code
+	}
+	return ""
+}
+
+// call sends a signed request and returns the body of its response,
+// failing with the service's error unless the status is 200. Throttled
+// calls, server errors and dropped connections are retried.
+func (c *signedAPIClient) call(ctx context.Context, method, path string, headers map[string]string, body []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		respBody, err := c.send(ctx, method, path, headers, body)
+		if err == nil || attempt >= c.retryer.MaxAttempts() || !c.retryer.IsErrorRetryable(err) {
+			return respBody, err
+		}
+		delay, delayErr := c.retryer.RetryDelay(attempt, err)
+		if delayErr != nil {
+			return nil, err
+		}
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes one attempt of a call
+func (c *signedAPIClient) send(ctx context.Context, method, path string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	credentials, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), c.service, c.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign the request: %w", err)
+	}
+
+	logHTTPRequest(c.logger, c.logMode, req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	logHTTPResponse(c.logger, c.logMode, resp)
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &signedAPIError{status: resp.Status, statusCode: resp.StatusCode, body: strings.TrimSpace(string(respBody)), errorCode: apiErrorCode(resp, respBody)}
+	}
+	return respBody, nil
+}
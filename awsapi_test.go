@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// TestServiceEndpoint tests picking the endpoint of the region's partition,
+// in the variants of -fips and -dual-stack
+func TestServiceEndpoint(t *testing.T) {
+	tests := []struct {
+		region          string
+		fips, dualStack bool
+		want            string
+	}{
+		{"us-east-1", false, false, "https://securityhub.us-east-1.amazonaws.com"},
+		{"us-gov-west-1", true, false, "https://securityhub-fips.us-gov-west-1.amazonaws.com"},
+		{"eu-west-1", false, true, "https://securityhub.eu-west-1.api.aws"},
+		{"us-east-1", true, true, "https://securityhub-fips.us-east-1.api.aws"},
+		{"cn-north-1", false, false, "https://securityhub.cn-north-1.amazonaws.com.cn"},
+		{"us-iso-east-1", false, false, "https://securityhub.us-iso-east-1.c2s.ic.gov"},
+	}
+	for _, tt := range tests {
+		awsConfig, err := loadAWSConfig(context.Background(), Config{Region: tt.region, FIPS: tt.fips, DualStack: tt.dualStack})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got := newSignedAPIClient(awsConfig, "securityhub").endpoint; got != tt.want {
+			t.Errorf("%s with FIPS %v and dual-stack %v: expected %s, got %s", tt.region, tt.fips, tt.dualStack, tt.want, got)
+		}
+	}
+
+	// Disabling a variant explicitly wins over the sources after it
+	awsConfig := aws.Config{Region: "us-east-1", ConfigSources: []any{
+		config.LoadOptions{UseFIPSEndpoint: aws.FIPSEndpointStateDisabled},
+		config.LoadOptions{UseFIPSEndpoint: aws.FIPSEndpointStateEnabled},
+	}}
+	if got := serviceEndpoint(awsConfig, "lambda"); got != "https://lambda.us-east-1.amazonaws.com" {
+		t.Errorf("Expected the first source to win, got %s", got)
+	}
+}
+
+// TestSignedAPIClientRetries tests retrying throttled calls and server
+// errors up to the AWS config's attempts, and not the caller's errors
+func TestSignedAPIClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		attempts int
+		wantErr  bool
+	}{
+		{"Throttled", http.StatusBadRequest, `{"__type":"ThrottlingException","message":"Rate exceeded"}`, 2, false},
+		{"Server error", http.StatusServiceUnavailable, `{"message":"unavailable"}`, 2, false},
+		{"Always throttled", http.StatusTooManyRequests, `{"code":"TooManyRequestsException"}`, 3, true},
+		{"Denied", http.StatusForbidden, `<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>`, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 || tt.wantErr {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), RetryMaxAttempts: 3,
+				Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+			api := newSignedAPIClient(awsConfig, "lambda")
+			var delays []time.Duration
+			api.sleep = func(ctx context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			_, err := api.call(context.Background(), http.MethodGet, "/", nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected an error %v, got %v", tt.wantErr, err)
+			}
+			if calls != tt.attempts || len(delays) != tt.attempts-1 {
+				t.Errorf("Expected %d attempts, got %d with %d delays", tt.attempts, calls, len(delays))
+			}
+		})
+	}
+}
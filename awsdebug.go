@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/logging"
+)
+
+// This file contains -debug-aws, which logs every AWS request and response
+// the SDK sends, with their bodies and retries, to diagnose a permission,
+// endpoint or throttling problem. The dumps go through the configured
+// logger, with the credentials they carry (the signature, the session
+// token, and the keys and tokens in responses) redacted, so the logs can be
+// shared.
+
+// awsDebugLogMode is what -debug-aws logs of each call
+const awsDebugLogMode = aws.LogRequestWithBody | aws.LogResponseWithBody | aws.LogRetries
+
+// awsSecrets match the credentials in request and response dumps, with the
+// part before the secret as their first group
+var awsSecrets = []*regexp.Regexp{
+	// Headers of signed requests
+	regexp.MustCompile(`(?im)^((?:Authorization|X-Amz-Security-Token):\s*)\S.*$`),
+	// Presigned URLs
+	regexp.MustCompile(`((?:X-Amz-Signature|X-Amz-Security-Token|X-Amz-Credential)=)[^&\s]+`),
+	// JSON responses, such as GetAuthorizationToken's
+	regexp.MustCompile(`("(?i:authorizationToken|secretAccessKey|sessionToken|accessKeyId|password)"\s*:\s*")[^"]*`),
+	// XML responses, such as AssumeRole's
+	regexp.MustCompile(`(<(?:SecretAccessKey|SessionToken|AccessKeyId)>)[^<]*`),
+}
+
+// redactAWSSecrets replaces the credentials in a request or response dump
+func redactAWSSecrets(dump string) string {
+	for _, secret := range awsSecrets {
+		dump = secret.ReplaceAllString(dump, "${1}REDACTED")
+	}
+	return dump
+}
+
+// awsDebugLogger writes the SDK's log messages to the default slog logger,
+// redacted. The request and response dumps are logged at info level, since
+// -debug-aws asks for them, and the SDK's warnings at warn level.
+type awsDebugLogger struct{}
+
+// Logf implements logging.Logger
+func (awsDebugLogger) Logf(classification logging.Classification, format string, v ...interface{}) {
+	level := slog.LevelInfo
+	if classification == logging.Warn {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "AWS SDK", "action", "debug-aws", "detail", redactAWSSecrets(fmt.Sprintf(format, v...)))
+}
+
+// debugAWSOptions returns the AWS config options that enable -debug-aws
+func debugAWSOptions(cfg Config) []func(*config.LoadOptions) error {
+	if !cfg.DebugAWS {
+		return nil
+	}
+	return []func(*config.LoadOptions) error{
+		config.WithClientLogMode(awsDebugLogMode),
+		config.WithLogger(awsDebugLogger{}),
+	}
+}
+
+// logHTTPRequest logs a request sent outside the SDK the way the SDK logs
+// its own, when the AWS config's log mode asks for it
+func logHTTPRequest(logger logging.Logger, mode aws.ClientLogMode, req *http.Request) {
+	if logger == nil || !mode.IsRequest() && !mode.IsRequestWithBody() {
+		return
+	}
+	dump, err := httputil.DumpRequestOut(req, mode.IsRequestWithBody())
+	if err != nil {
+		logger.Logf(logging.Debug, "Failed to dump request: %v", err)
+		return
+	}
+	logger.Logf(logging.Debug, "Request\n%s", dump)
+}
+
+// logHTTPResponse logs the response to a request sent outside the SDK
+func logHTTPResponse(logger logging.Logger, mode aws.ClientLogMode, resp *http.Response) {
+	if logger == nil || !mode.IsResponse() && !mode.IsResponseWithBody() {
+		return
+	}
+	dump, err := httputil.DumpResponse(resp, mode.IsResponseWithBody())
+	if err != nil {
+		logger.Logf(logging.Debug, "Failed to dump response: %v", err)
+		return
+	}
+	logger.Logf(logging.Debug, "Response\n%s", dump)
+}
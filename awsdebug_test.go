@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// TestRedactAWSSecrets tests removing the credentials from request and
+// response dumps
+func TestRedactAWSSecrets(t *testing.T) {
+	dump := strings.Join([]string{
+		"POST / HTTP/1.1",
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/20240101/us-east-1/ecr/aws4_request, Signature=abc123",
+		"X-Amz-Security-Token: FwoGZXIvYXdzEXAMPLE",
+		"X-Amz-Target: AmazonEC2ContainerRegistry_V20150921.DescribeRepositories",
+		"",
+		`{"authorizationData":[{"authorizationToken":"QVdTOnNlY3JldA==","proxyEndpoint":"https://123.dkr.ecr.us-east-1.amazonaws.com"}]}`,
+		"<Credentials><AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>wJalrXUtnFEMI</SecretAccessKey><SessionToken>FQoGZXIvYXdz</SessionToken></Credentials>",
+		"https://bucket.s3.amazonaws.com/layer?X-Amz-Credential=AKIAEXAMPLE%2F20240101&X-Amz-Signature=deadbeef",
+	}, "\n")
+
+	redacted := redactAWSSecrets(dump)
+	for _, secret := range []string{"AKIAEXAMPLE", "abc123", "FwoGZXIvYXdzEXAMPLE", "QVdTOnNlY3JldA==", "ASIAEXAMPLE", "wJalrXUtnFEMI", "FQoGZXIvYXdz", "deadbeef"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("Expected %q redacted, got:\n%s", secret, redacted)
+		}
+	}
+	for _, kept := range []string{"X-Amz-Target: AmazonEC2ContainerRegistry_V20150921.DescribeRepositories", `"proxyEndpoint":"https://123.dkr.ecr.us-east-1.amazonaws.com"`, "Authorization: REDACTED"} {
+		if !strings.Contains(redacted, kept) {
+			t.Errorf("Expected %q kept, got:\n%s", kept, redacted)
+		}
+	}
+}
+
+// TestSignedAPIClientDebugLogging tests that -debug-aws also logs the
+// requests sent without an SDK module, redacted
+func TestSignedAPIClientDebugLogging(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(previous)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"FailedCount":0}`))
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{
+		Region:        "us-east-1",
+		BaseEndpoint:  aws.String(server.URL),
+		Credentials:   credentials.NewStaticCredentialsProvider("id", "secret", "session-token"),
+		ClientLogMode: awsDebugLogMode,
+		Logger:        awsDebugLogger{},
+	}
+	if _, err := newSignedAPIClient(awsConfig, "securityhub").call(context.Background(), http.MethodPost, "/findings/import", nil, []byte(`{}`)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	got := logs.String()
+	if !strings.Contains(got, "POST /findings/import") || !strings.Contains(got, "FailedCount") {
+		t.Errorf("Expected the request and response logged, got:\n%s", got)
+	}
+	if strings.Contains(got, "Signature=") || strings.Contains(got, "session-token") {
+		t.Errorf("Expected the credentials redacted, got:\n%s", got)
+	}
+}
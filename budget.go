@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the storage budget mode. Instead of deleting every
+// image the retention policy selects, a size target deletes the oldest of
+// those images only until the repository, or the whole registry, is under
+// the target. Sizes are the image sizes ECR reports.
+
+// gbToBytes converts a size target in GB to bytes
+func gbToBytes(gb float64) int64 {
+	return int64(gb * (1 << 30))
+}
+
+// compareOldestFirst orders images by pushed time, oldest first, with
+// images of unknown age last
+func compareOldestFirst(a, b types.ImageDetail) int {
+	switch {
+	case a.ImagePushedAt == nil && b.ImagePushedAt == nil:
+		return 0
+	case a.ImagePushedAt == nil:
+		return 1
+	case b.ImagePushedAt == nil:
+		return -1
+	}
+	return a.ImagePushedAt.Compare(*b.ImagePushedAt)
+}
+
+// trimToTarget returns the oldest of the selected images that need to go
+// for a repository of size bytes to fit in target bytes
+func trimToTarget(repoName string, size int64, toDelete []types.ImageDetail, target int64) []types.ImageDetail {
+	if size <= target {
+		slog.Info("Repository is within its size target", "repository", repoName, "size_mb", roundMB(size), "target_mb", roundMB(target))
+		return nil
+	}
+
+	toDelete = slices.Clone(toDelete)
+	slices.SortStableFunc(toDelete, compareOldestFirst)
+
+	excess, n := size-target, 0
+	for ; n < len(toDelete) && excess > 0; n++ {
+		excess -= aws.ToInt64(toDelete[n].ImageSizeInBytes)
+	}
+	if excess > 0 {
+		slog.Warn("Deleting every eligible image doesn't bring the repository under its size target", "repository", repoName, "size_mb", roundMB(size), "target_mb", roundMB(target))
+	}
+	return toDelete[:n]
+}
+
+// trimRunsToTarget trims the selections of a registry's repositories to the
+// oldest images, across repositories, that need to go for the registry to
+// fit in target bytes. Repositories that failed don't count.
+func trimRunsToTarget(runs []*repositoryRun, target int64) {
+	type candidate struct {
+		run *repositoryRun
+		img types.ImageDetail
+	}
+
+	var size int64
+	var candidates []candidate
+	for _, run := range runs {
+		if run.err != nil {
+			continue
+		}
+		size += run.stats.size
+		for _, img := range run.toDelete {
+			candidates = append(candidates, candidate{run, img})
+		}
+		run.toDelete = nil
+	}
+
+	if size <= target {
+		slog.Info("Registry is within its size target", "size_mb", roundMB(size), "target_mb", roundMB(target))
+		return
+	}
+
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return compareOldestFirst(a.img, b.img)
+	})
+
+	excess := size - target
+	for _, c := range candidates {
+		if excess <= 0 {
+			break
+		}
+		c.run.toDelete = append(c.run.toDelete, c.img)
+		excess -= aws.ToInt64(c.img.ImageSizeInBytes)
+	}
+	if excess > 0 {
+		slog.Warn("Deleting every eligible image doesn't bring the registry under its size target", "size_mb", roundMB(size), "target_mb", roundMB(target))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// imagesSize returns the total size of the images
+func imagesSize(images []types.ImageDetail) int64 {
+	var size int64
+	for _, img := range images {
+		size += aws.ToInt64(img.ImageSizeInBytes)
+	}
+	return size
+}
+
+// TestTrimToTarget tests deleting the oldest images until under a target
+func TestTrimToTarget(t *testing.T) {
+	// sha256:000 is the newest image and sha256:004 the oldest, 1000 bytes each
+	images := newGuardrailClient(1, 5).DescribeImagesOutput.ImageDetails
+
+	tests := []struct {
+		name     string
+		target   int64
+		expected []string
+	}{
+		{"Within target", 5000, nil},
+		{"Just over", 4999, []string{"sha256:004"}},
+		{"Half", 2500, []string{"sha256:004", "sha256:003", "sha256:002"}},
+		{"Unreachable", 0, []string{"sha256:004", "sha256:003", "sha256:002", "sha256:001"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the four oldest images are eligible
+			got := trimToTarget("repo", imagesSize(images), images[1:], tt.target)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %d images", tt.expected, len(got))
+			}
+			for i, img := range got {
+				if aws.ToString(img.ImageDigest) != tt.expected[i] {
+					t.Errorf("Expected %v, got %s at %d", tt.expected, aws.ToString(img.ImageDigest), i)
+				}
+			}
+		})
+	}
+}
+
+// TestCompareOldestFirst tests ordering images of unknown age last
+func TestCompareOldestFirst(t *testing.T) {
+	now := time.Now()
+	old := types.ImageDetail{ImagePushedAt: aws.Time(now.Add(-time.Hour))}
+	recent := types.ImageDetail{ImagePushedAt: aws.Time(now)}
+	unknown := types.ImageDetail{}
+
+	if compareOldestFirst(old, recent) >= 0 || compareOldestFirst(recent, unknown) >= 0 || compareOldestFirst(unknown, old) <= 0 {
+		t.Error("Expected old, recent, then unknown")
+	}
+}
+
+// TestCleanupWithClientSizeTargets tests the repository and registry targets
+func TestCleanupWithClientSizeTargets(t *testing.T) {
+	t.Run("Per repository", func(t *testing.T) {
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, TargetRepoSizeGB: 3000.0 / (1 << 30)}, newGuardrailClient(2, 5))
+		if err != nil || summary.ImagesDeleted != 4 {
+			t.Errorf("Expected 2 images deleted from each repository, got %d and %v", summary.ImagesDeleted, err)
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, TargetTotalGB: 12000.0 / (1 << 30)}, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		// Every repository holds the same images, so the oldest go first everywhere
+		if summary.ImagesDeleted != 3 {
+			t.Errorf("Expected 3 images deleted, got %d", summary.ImagesDeleted)
+		}
+		for _, repo := range summary.Repositories {
+			if len(repo.Images) != 1 || repo.Images[0].Digest != "sha256:004" {
+				t.Errorf("Expected only the oldest image deleted from %s, got %+v", repo.Name, repo.Images)
+			}
+		}
+	})
+
+	t.Run("Registry within target", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, TargetTotalGB: 1}, client)
+		if err != nil || summary.ImagesDeleted != 0 || client.BatchDeleteImageCalls != 0 {
+			t.Errorf("Expected nothing deleted, got %d and %v", summary.ImagesDeleted, err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the -state-file checkpoint, which lets a run over
+// thousands of repositories resume where it was interrupted instead of
+// scanning everything again. Each repository the run finishes is appended
+// to the file as a JSON line; a run started with an existing state file
+// skips the repositories it lists, and a run that finishes without errors
+// removes the file. Repositories are checkpointed whole: a repository whose
+// deletions were interrupted is scanned again, which finds the images that
+// were already deleted gone.
+
+// CheckpointEntry is one line of the state file
+type CheckpointEntry struct {
+	Time       time.Time `json:"time"`
+	AccountID  string    `json:"account_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Repository string    `json:"repository"`
+}
+
+// checkpoint records the repositories a run has finished. A nil checkpoint
+// records nothing, so callers don't need to check whether -state-file is
+// set.
+type checkpoint struct {
+	path string
+	done map[CheckpointEntry]bool
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openCheckpoint reads the repositories finished by an interrupted run from
+// the state file, if any, and opens it to record more
+func openCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, done: make(map[CheckpointEntry]bool)}
+
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			var entry CheckpointEntry
+			// A line cut short by the interruption is ignored
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Repository != "" {
+				c.done[checkpointKey(entry.AccountID, entry.Region, entry.Repository)] = true
+			}
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read state file: %w", err)
+		}
+	}
+
+	c.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	return c, nil
+}
+
+// checkpointKey identifies a repository across accounts and regions
+func checkpointKey(accountID, region, repoName string) CheckpointEntry {
+	return CheckpointEntry{AccountID: accountID, Region: region, Repository: repoName}
+}
+
+// resumed returns how many repositories an interrupted run already finished
+func (c *checkpoint) resumed() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.done)
+}
+
+// withoutCompleted returns the repositories that an interrupted run didn't
+// finish
+func (c *checkpoint) withoutCompleted(cfg Config, repos []types.Repository) []types.Repository {
+	if c == nil || len(c.done) == 0 {
+		return repos
+	}
+
+	var remaining []types.Repository
+	for _, repo := range repos {
+		if !c.done[checkpointKey(cfg.accountID, cfg.region, aws.ToString(repo.RepositoryName))] {
+			remaining = append(remaining, repo)
+		}
+	}
+	if skipped := len(repos) - len(remaining); skipped > 0 {
+		slog.Info("Skipping repositories finished before the run was interrupted", "repositories", skipped, "state_file", c.path)
+	}
+	return remaining
+}
+
+// complete records that the run finished a repository. Failing to record
+// it only means the repository is scanned again on resume.
+func (c *checkpoint) complete(cfg Config, repoName string) {
+	if c == nil {
+		return
+	}
+
+	entry := checkpointKey(cfg.accountID, cfg.region, repoName)
+	entry.Time = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err == nil {
+		c.mu.Lock()
+		_, err = c.file.Write(append(line, '\n'))
+		c.mu.Unlock()
+	}
+	if err != nil {
+		slog.Warn("Failed to record repository in the state file", "repository", repoName, "error", err)
+	}
+}
+
+// finish closes the state file, and removes it when the run finished
+// everything so the next run starts afresh
+func (c *checkpoint) finish(finished bool) {
+	if c == nil {
+		return
+	}
+	c.file.Close()
+	if !finished {
+		slog.Info("Run state kept to resume the run", "state_file", c.path)
+		return
+	}
+	if err := os.Remove(c.path); err != nil {
+		slog.Warn("Failed to remove the state file", "state_file", c.path, "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestCheckpointResume tests that a resumed run skips the repositories the
+// interrupted run finished, and only those
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	cfg := Config{region: "eu-west-1"}
+
+	c, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if c.resumed() != 0 {
+		t.Errorf("Expected a fresh run, got %d repositories finished", c.resumed())
+	}
+	c.complete(cfg, "repo0")
+	c.complete(Config{region: "us-east-1"}, "repo1")
+	c.finish(false)
+
+	// A line cut short by the interruption is ignored
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	file.WriteString(`{"time":"2025-01-01T00:00:00Z","repos`)
+	file.Close()
+
+	c, err = openCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	repos := []types.Repository{{RepositoryName: aws.String("repo0")}, {RepositoryName: aws.String("repo1")}, {RepositoryName: aws.String("repo2")}}
+	remaining := c.withoutCompleted(cfg, repos)
+	if c.resumed() != 2 || len(remaining) != 2 || aws.ToString(remaining[0].RepositoryName) != "repo1" {
+		t.Errorf("Expected repo1 and repo2 left in eu-west-1, got %v", remaining)
+	}
+
+	c.finish(true)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the state file removed after a finished run, got %v", err)
+	}
+
+	var none *checkpoint
+	none.complete(cfg, "repo0")
+	if len(none.withoutCompleted(cfg, repos)) != 3 {
+		t.Error("Expected every repository without a state file")
+	}
+}
+
+// TestCheckpointStoppedRun tests that repositories whose deletions were
+// interrupted are scanned again on resume
+func TestCheckpointStoppedRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	c, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	stop := make(chan struct{})
+	client := &stoppingClient{MockECRClient: newGuardrailClient(3, 150), stop: stop}
+	cfg := Config{Days: 10, MinKeep: 1, KeepNewest: true, stop: stop, checkpoint: c}
+	CleanupWithClient(context.Background(), cfg, client)
+	c.finish(false)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the state file kept, got %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 0 {
+		t.Errorf("Expected no repository finished, got %s", data)
+	}
+
+	// A run that isn't stopped finishes every repository
+	c, _ = openCheckpoint(path)
+	cfg = Config{Days: 10, MinKeep: 1, checkpoint: c}
+	if _, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(3, 5)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	c.finish(false)
+	c, _ = openCheckpoint(path)
+	if c.resumed() != 3 {
+		t.Errorf("Expected 3 repositories finished, got %d", c.resumed())
+	}
+	c.finish(true)
+}
+
+// TestCheckpointConcurrentWrites tests recording repositories finished by
+// concurrent workers
+func TestCheckpointConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	c, _ := openCheckpoint(path)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.complete(Config{}, "repo"+strings.Repeat("x", i))
+		}(i)
+	}
+	wg.Wait()
+	c.finish(false)
+
+	c, _ = openCheckpoint(path)
+	defer c.finish(true)
+	if c.resumed() != 50 {
+		t.Errorf("Expected 50 repositories recorded, got %d", c.resumed())
+	}
+}
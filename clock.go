@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// This file contains the clock and the source of randomness of a run.
+// Image selection measures ages against Config.Clock and the scheduler
+// waits for its runs by it, so a policy can be evaluated as of a fixed time
+// and gives the same result whenever it runs. Config.Rand draws the
+// schedule jitter. Both default to the system's when unset.
+
+// Clock tells the time to the selection engine and the scheduler
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to a Clock
+type ClockFunc func() time.Time
+
+// Now calls the function
+func (f ClockFunc) Now() time.Time { return f() }
+
+// FixedClock is a Clock that always tells the same time
+type FixedClock time.Time
+
+// Now returns the fixed time
+func (c FixedClock) Now() time.Time { return time.Time(c) }
+
+// now returns the time of the run's clock
+func (cfg Config) now() time.Time {
+	if cfg.Clock == nil {
+		return time.Now()
+	}
+	return cfg.Clock.Now()
+}
+
+// int63n draws a random number in [0, n) from the run's source of
+// randomness
+func (cfg Config) int63n(n int64) int64 {
+	if cfg.Rand == nil {
+		return rand.Int63n(n)
+	}
+	return cfg.Rand.Int63n(n)
+}
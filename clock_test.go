@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestSelectImagesForDeletionClock tests that images are selected against
+// the run's clock, so a policy evaluated at a fixed time always selects the
+// same images
+func TestSelectImagesForDeletionClock(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:new"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -3))},
+		{ImageDigest: aws.String("sha256:old"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -30))},
+	}
+
+	selected := selectImagesForDeletion(images, Config{Days: 10, Clock: FixedClock(now)})
+	if len(selected) != 1 || aws.ToString(selected[0].ImageDigest) != "sha256:old" {
+		t.Errorf("Expected only the old image, got %v", digestsOf(selected))
+	}
+
+	// A month later both images are past the cutoff
+	later := FixedClock(now.AddDate(0, 1, 0))
+	if selected := selectImagesForDeletion(images, Config{Days: 10, Clock: later}); len(selected) != 2 {
+		t.Errorf("Expected both images, got %v", digestsOf(selected))
+	}
+}
+
+// TestConfigNow tests that the system clock is used when none is set
+func TestConfigNow(t *testing.T) {
+	before := time.Now()
+	if now := (Config{}).now(); now.Before(before) || now.After(time.Now()) {
+		t.Errorf("Expected the current time, got %v", now)
+	}
+	fixed := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if now := (Config{Clock: FixedClock(fixed)}).now(); !now.Equal(fixed) {
+		t.Errorf("Expected %v, got %v", fixed, now)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the compliance command, which turns the scan into a
+// governance check. It deletes nothing: every repository is checked
+// against the -compliance-* thresholds (its size, the age of its oldest
+// image and whether it has a lifecycle policy), the results are reported,
+// and with -security-hub they are imported into Security Hub as findings,
+// so repositories nobody cleans up show up with the account's other
+// findings. The command exits with status 3 when a repository fails a
+// check.
+
+// Checks of the compliance command
+const (
+	checkRepositorySize  = "repository-size"
+	checkImageAge        = "image-age"
+	checkLifecyclePolicy = "lifecycle-policy"
+)
+
+// exitNonCompliant is the exit status of the compliance command when a
+// repository fails a check
+const exitNonCompliant = 3
+
+// ComplianceCheck is the result of one check of a repository
+type ComplianceCheck struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// RepositoryCompliance is the result of the checks of a repository
+type RepositoryCompliance struct {
+	Name            string            `json:"name"`
+	Arn             string            `json:"arn"`
+	Size            int64             `json:"size_bytes"`
+	OldestPush      *time.Time        `json:"oldest_pushed_at,omitempty"`
+	LifecyclePolicy bool              `json:"lifecycle_policy"`
+	Checks          []ComplianceCheck `json:"checks"`
+}
+
+// compliant reports whether the repository passed every check
+func (r RepositoryCompliance) compliant() bool {
+	return !slices.ContainsFunc(r.Checks, func(c ComplianceCheck) bool { return !c.Passed })
+}
+
+// hasComplianceChecks reports whether any -compliance-* threshold is set
+func hasComplianceChecks(cfg Config) bool {
+	return cfg.ComplianceMaxSizeGB > 0 || cfg.ComplianceMaxAgeDays > 0 || cfg.ComplianceRequireLifecyclePolicy
+}
+
+// runCompliance runs the compliance command: it writes the checks of every
+// repository to stdout and imports them into Security Hub
+func runCompliance(config Config) int {
+	ctx := context.Background()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error checking compliance", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	results, err := checkCompliance(ctx, client, ecr.NewFromConfig(awsConfig), config, time.Now())
+	if err != nil {
+		slog.Error("Error checking compliance", "error", err)
+		return 1
+	}
+
+	if strings.EqualFold(config.LogFormat, "json") {
+		err = writeComplianceJSON(os.Stdout, results)
+	} else {
+		err = writeComplianceTable(os.Stdout, results, time.Now())
+	}
+	if err != nil {
+		slog.Error("Error writing compliance results", "error", err)
+		return 1
+	}
+
+	if config.SecurityHub {
+		identity, err := getCallerIdentity(ctx, awsConfig)
+		if err != nil {
+			slog.Error("Error importing findings", "error", fmt.Errorf("failed to verify AWS credentials: %w", err))
+			return 1
+		}
+		findings := complianceFindings(results, aws.ToString(identity.Account), awsConfig.Region, time.Now())
+		if err := newSecurityHubClient(awsConfig).BatchImportFindings(ctx, findings); err != nil {
+			slog.Error("Error importing findings", "error", err)
+			return 1
+		}
+		slog.Info("Imported findings into Security Hub", "findings", len(findings))
+	}
+
+	for _, result := range results {
+		if !result.compliant() {
+			return exitNonCompliant
+		}
+	}
+	return 0
+}
+
+// checkCompliance checks every repository against the thresholds, in name
+// order
+func checkCompliance(ctx context.Context, client ECRClient, policies LifecyclePolicyClient, cfg Config, now time.Time) ([]RepositoryCompliance, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	var mu sync.Mutex
+	var firstErr error
+	results := []RepositoryCompliance{}
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		result, err := checkRepositoryCompliance(ctx, client, policies, repo, cfg, now)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		results = append(results, result)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	slices.SortFunc(results, func(a, b RepositoryCompliance) int { return strings.Compare(a.Name, b.Name) })
+	return results, nil
+}
+
+// checkRepositoryCompliance checks a repository against the thresholds
+func checkRepositoryCompliance(ctx context.Context, client ECRClient, policies LifecyclePolicyClient, repo types.Repository, cfg Config, now time.Time) (RepositoryCompliance, error) {
+	repoName := aws.ToString(repo.RepositoryName)
+	result := RepositoryCompliance{Name: repoName, Arn: aws.ToString(repo.RepositoryArn), Checks: []ComplianceCheck{}}
+
+	// Only scan the images when a check needs them
+	if cfg.ComplianceMaxSizeGB > 0 || cfg.ComplianceMaxAgeDays > 0 {
+		inv, err := inventoryRepository(ctx, client, repoName, cfg)
+		if err != nil {
+			return RepositoryCompliance{}, err
+		}
+		result.Size = inv.Size
+		result.OldestPush = inv.OldestPush
+	}
+
+	if cfg.ComplianceMaxSizeGB > 0 {
+		check := ComplianceCheck{Check: checkRepositorySize, Passed: result.Size <= gbToBytes(cfg.ComplianceMaxSizeGB)}
+		check.Detail = fmt.Sprintf("holds %s, limit %g GB", formatBytes(result.Size), cfg.ComplianceMaxSizeGB)
+		result.Checks = append(result.Checks, check)
+	}
+	if cfg.ComplianceMaxAgeDays > 0 {
+		check := ComplianceCheck{Check: checkImageAge, Passed: true, Detail: "holds no images"}
+		if result.OldestPush != nil {
+			check.Passed = !result.OldestPush.Before(now.AddDate(0, 0, -cfg.ComplianceMaxAgeDays))
+			check.Detail = fmt.Sprintf("oldest image pushed %s ago, limit %d days", formatAge(result.OldestPush, now, ""), cfg.ComplianceMaxAgeDays)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+	if cfg.ComplianceRequireLifecyclePolicy {
+		text, err := getLifecyclePolicyText(ctx, policies, repoName)
+		if err != nil {
+			return RepositoryCompliance{}, fmt.Errorf("failed to get lifecycle policy of %s: %w", repoName, err)
+		}
+		result.LifecyclePolicy = text != ""
+		check := ComplianceCheck{Check: checkLifecyclePolicy, Passed: result.LifecyclePolicy, Detail: "has a lifecycle policy"}
+		if !check.Passed {
+			check.Detail = "has no lifecycle policy"
+		}
+		result.Checks = append(result.Checks, check)
+	}
+	return result, nil
+}
+
+// complianceTitles are the titles of the findings of failed checks
+var complianceTitles = map[string]string{
+	checkRepositorySize:  "ECR repository exceeds the size limit",
+	checkImageAge:        "ECR repository holds images older than the age limit",
+	checkLifecyclePolicy: "ECR repository has no lifecycle policy",
+}
+
+// complianceFindings converts the checks into Security Hub findings of the
+// account's default product, one per repository and check
+func complianceFindings(results []RepositoryCompliance, accountID, region string, now time.Time) []asffFinding {
+	timestamp := now.UTC().Format(time.RFC3339)
+	var findings []asffFinding
+	for _, result := range results {
+		partition := "aws"
+		if parsed, err := arn.Parse(result.Arn); err == nil {
+			partition = parsed.Partition
+		}
+		for _, check := range result.Checks {
+			finding := asffFinding{
+				SchemaVersion: "2018-10-08",
+				ID:            fmt.Sprintf("ecr-cleanup/%s/%s", check.Check, result.Arn),
+				ProductArn:    fmt.Sprintf("arn:%s:securityhub:%s:%s:product/%s/default", partition, region, accountID, accountID),
+				GeneratorID:   "ecr-cleanup/" + check.Check,
+				AwsAccountID:  accountID,
+				Types:         []string{"Software and Configuration Checks/AWS Security Best Practices"},
+				CreatedAt:     timestamp,
+				UpdatedAt:     timestamp,
+				Severity:      asffSeverity{Label: "LOW"},
+				Title:         complianceTitles[check.Check],
+				Description:   fmt.Sprintf("Repository %s %s.", result.Name, check.Detail),
+				Resources:     []asffResource{{Type: "AwsEcrRepository", ID: result.Arn, Region: region}},
+				Compliance:    asffCompliance{Status: "FAILED"},
+				RecordState:   "ACTIVE",
+			}
+			if check.Passed {
+				finding.Severity.Label = "INFORMATIONAL"
+				finding.Compliance.Status = "PASSED"
+			}
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// writeComplianceJSON writes the checks as JSON
+func writeComplianceJSON(w io.Writer, results []RepositoryCompliance) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string][]RepositoryCompliance{"repositories": results})
+}
+
+// writeComplianceTable writes the checks as an aligned table, with the
+// failed checks of each repository
+func writeComplianceTable(w io.Writer, results []RepositoryCompliance, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSIZE\tOLDEST\tSTATUS")
+	for _, result := range results {
+		status := "ok"
+		var failed []string
+		for _, check := range result.Checks {
+			if !check.Passed {
+				failed = append(failed, check.Check+": "+check.Detail)
+			}
+		}
+		if len(failed) > 0 {
+			status = strings.Join(failed, "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Name, formatBytes(result.Size), formatAge(result.OldestPush, now, "-"), status)
+	}
+	return tw.Flush()
+}
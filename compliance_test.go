@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// TestCheckCompliance tests checking repositories against the thresholds
+func TestCheckCompliance(t *testing.T) {
+	// Each repository holds 3000 bytes of images 30 to 32 days old
+	client := newGuardrailClient(2, 3)
+	for i := range client.DescribeRepositoriesOutput.Repositories {
+		repo := &client.DescribeRepositoriesOutput.Repositories[i]
+		repo.RepositoryArn = aws.String("arn:aws:ecr:us-east-1:123456789012:repository/" + aws.ToString(repo.RepositoryName))
+	}
+	policies := &mockLifecycleClient{policies: map[string]string{"repo0": `{"rules":[]}`}}
+
+	cfg := Config{ComplianceMaxSizeGB: 1, ComplianceMaxAgeDays: 31, ComplianceRequireLifecyclePolicy: true}
+	now := time.Now()
+	results, err := checkCompliance(context.Background(), client, policies, cfg, now)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "repo0" || results[1].Name != "repo1" {
+		t.Fatalf("Expected both repositories in name order, got %+v", results)
+	}
+
+	// Both hold an image older than 31 days; only repo0 has a policy
+	for i, want := range []string{"repository-size:true image-age:false lifecycle-policy:true", "repository-size:true image-age:false lifecycle-policy:false"} {
+		var got []string
+		for _, check := range results[i].Checks {
+			got = append(got, check.Check+":"+map[bool]string{true: "true", false: "false"}[check.Passed])
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("Expected %s for %s, got %v", want, results[i].Name, got)
+		}
+	}
+	if results[0].compliant() || results[0].Size != 3000 || !results[0].LifecyclePolicy {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+
+	findings := complianceFindings(results, "123456789012", "us-east-1", now)
+	if len(findings) != 6 {
+		t.Fatalf("Expected a finding per repository and check, got %d", len(findings))
+	}
+	size, age := findings[0], findings[1]
+	if size.Compliance.Status != "PASSED" || size.Severity.Label != "INFORMATIONAL" || age.Compliance.Status != "FAILED" || age.Severity.Label != "LOW" {
+		t.Errorf("Unexpected findings %+v, %+v", size, age)
+	}
+	if age.ID != "ecr-cleanup/image-age/arn:aws:ecr:us-east-1:123456789012:repository/repo0" || age.ProductArn != "arn:aws:securityhub:us-east-1:123456789012:product/123456789012/default" {
+		t.Errorf("Unexpected finding identity %s, %s", age.ID, age.ProductArn)
+	}
+	if age.Resources[0].Type != "AwsEcrRepository" || age.Resources[0].ID != results[0].Arn {
+		t.Errorf("Unexpected resources %+v", age.Resources)
+	}
+
+	var out bytes.Buffer
+	if err := writeComplianceTable(&out, results, now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lines := strings.Split(out.String(), "\n"); !strings.Contains(lines[2], "image-age: oldest image pushed 32d ago, limit 31 days; lifecycle-policy: has no lifecycle policy") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
+
+// TestCheckComplianceSkipsScan tests that checking lifecycle policies
+// alone doesn't scan the images
+func TestCheckComplianceSkipsScan(t *testing.T) {
+	client := newGuardrailClient(1, 3)
+	results, err := checkCompliance(context.Background(), client, &mockLifecycleClient{}, Config{ComplianceRequireLifecyclePolicy: true}, time.Now())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if client.DescribeImagesCalls != 0 || len(results) != 1 || results[0].compliant() {
+		t.Errorf("Expected a failed check without a scan, got %d calls and %+v", client.DescribeImagesCalls, results)
+	}
+}
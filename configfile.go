@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// This file contains the -config file, a YAML file for the settings that
+// don't fit on a command line, such as the in-use providers of
+// providers.go and the named policies of policies.go. Unknown keys are
+// rejected, like unknown environment variables, so a misspelled setting is
+// never silently ignored.
+
+// fileConfig is the content of the -config file
+type fileConfig struct {
+	InUseProviders []providerConfig                `yaml:"in_use_providers"`
+	Policies       map[string]map[string]yaml.Node `yaml:"policies"`
+}
+
+// readConfigFile reads and validates the -config file
+func readConfigFile(path string) (fileConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("failed to read the config file: %w", err)
+	}
+	defer file.Close()
+
+	var cfg fileConfig
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fileConfig{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// This file contains the confirmation prompt. When someone runs a deleting
+// cleanup from a terminal, the run first scans like a dry run, shows the
+// totals and asks before deleting anything; -yes skips the prompt for
+// automation. The confirmed images are deleted as a plan, so the run never
+// deletes more than was shown. The prompt follows a breakdown of the plan
+// by repository, so the one answer covers every repository at once.
+
+// errDeletionDeclined is returned when the user answers no at the prompt
+var errDeletionDeclined = errors.New("deletion declined; no images were deleted")
+
+// needsConfirmation reports whether the run should ask before deleting:
+// only deleting runs started from a terminal without -yes
+func needsConfirmation(cfg Config, in *os.File) bool {
+	return !cfg.DryRun && !cfg.Yes && cfg.plan == nil && !cfg.ManageLifecyclePolicies && isTerminal(in)
+}
+
+// confirmDeletion scans for the images the run would delete and asks
+// whether to delete them. It returns the plan to apply, which is empty when
+// there is nothing to delete.
+func confirmDeletion(cfg Config, in io.Reader, out io.Writer) (*Plan, error) {
+	plan, err := scanPlan(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Images == 0 && plan.EmptyRepositories == 0 && plan.quarantineImages() == 0 {
+		return plan, nil
+	}
+
+	if err := writeConfirmationTable(out, plan, time.Now()); err != nil {
+		return nil, err
+	}
+	question := fmt.Sprintf("Delete %d images freeing %s? [y/N] ", plan.Images, formatBytes(plan.SizeBytes))
+	if cfg.UntagOnly {
+		question = fmt.Sprintf("Remove the tags of %d images? [y/N] ", plan.Images)
+	}
+	if plan.EmptyRepositories > 0 {
+		question = strings.Replace(question, "? [y/N]", fmt.Sprintf(", and delete %d empty repositories? [y/N]", plan.EmptyRepositories), 1)
+	}
+	if n := plan.quarantineImages(); n > 0 {
+		question = strings.Replace(question, "? [y/N]", fmt.Sprintf(", and change the quarantine of %d images? [y/N]", n), 1)
+	}
+	ok, err := promptYesNo(in, out, question)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errDeletionDeclined
+	}
+	return plan, nil
+}
+
+// confirmationRows bounds the repositories listed before the prompt; the
+// rest are summed up in one line
+const confirmationRows = 20
+
+// writeConfirmationTable writes the planned deletions of each repository,
+// largest first, with the totals of the plan
+func writeConfirmationTable(w io.Writer, plan *Plan, now time.Time) error {
+	repos := slices.Clone(plan.Repositories)
+	size := func(repo PlanRepository) int64 {
+		var total int64
+		for _, img := range repo.Images {
+			total += img.SizeBytes
+		}
+		return total
+	}
+	slices.SortStableFunc(repos, func(a, b PlanRepository) int { return cmp.Compare(size(b), size(a)) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tIMAGES\tSIZE\tOLDEST")
+	for i, repo := range repos {
+		if i == confirmationRows {
+			fmt.Fprintf(tw, "... and %d more repositories\t\t\t\n", len(repos)-i)
+			break
+		}
+		var oldest *time.Time
+		for _, img := range repo.Images {
+			if oldest == nil || img.PushedAt.Before(*oldest) {
+				oldest = &img.PushedAt
+			}
+		}
+		images := fmt.Sprint(len(repo.Images))
+		if repo.DeleteRepository {
+			images = "empty, deleted"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", qualifiedPlanName(repo), images, formatBytes(size(repo)), formatAge(oldest, now, "-"))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t\n", plan.Images, formatBytes(plan.SizeBytes))
+	return tw.Flush()
+}
+
+// promptYesNo asks a question and reports whether the answer was yes.
+// Anything but y or yes, including no answer, means no.
+func promptYesNo(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprint(out, question)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
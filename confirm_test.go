@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPromptYesNo tests reading the answer to the confirmation prompt
+func TestPromptYesNo(t *testing.T) {
+	tests := map[string]bool{
+		"y\n":      true,
+		"YES\n":    true,
+		" yes \n":  true,
+		"n\n":      false,
+		"\n":       false,
+		"":         false,
+		"yep\n":    false,
+		"y":        true,
+		"no way\n": false,
+	}
+
+	for input, want := range tests {
+		var out strings.Builder
+		got, err := promptYesNo(strings.NewReader(input), &out, "Delete 412 images freeing 18.2 GB? [y/N] ")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if got != want {
+			t.Errorf("Answer %q: expected %v, got %v", input, want, got)
+		}
+		if out.String() != "Delete 412 images freeing 18.2 GB? [y/N] " {
+			t.Errorf("Expected the question to be printed, got %q", out.String())
+		}
+	}
+}
+
+// TestNeedsConfirmation tests when a run asks before deleting
+func TestNeedsConfirmation(t *testing.T) {
+	// Test input is never a terminal
+	file, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer file.Close()
+
+	for _, cfg := range []Config{{}, {DryRun: true}, {Yes: true}, {plan: &Plan{}}} {
+		if needsConfirmation(cfg, file) {
+			t.Errorf("Expected no confirmation for %+v without a terminal", cfg)
+		}
+	}
+}
+
+// TestWriteConfirmationTable tests breaking the plan down by repository,
+// largest first
+func TestWriteConfirmationTable(t *testing.T) {
+	now := time.Now()
+	plan := &Plan{
+		Images:    3,
+		SizeBytes: 3072,
+		Repositories: []PlanRepository{
+			{Name: "small", Images: []PlanImage{{SizeBytes: 1024, PushedAt: now.AddDate(0, 0, -40)}}},
+			{Region: "us-east-1", Name: "large", Images: []PlanImage{{SizeBytes: 1024, PushedAt: now.AddDate(0, 0, -12)}, {SizeBytes: 1024, PushedAt: now.AddDate(0, 0, -90)}}},
+			{Name: "empty", Images: []PlanImage{}, DeleteRepository: true},
+		},
+	}
+	var out strings.Builder
+	if err := writeConfirmationTable(&out, plan, now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := `REPOSITORY       IMAGES          SIZE    OLDEST
+us-east-1/large  2               2.0 KB  90d
+small            1               1.0 KB  40d
+empty            empty, deleted  0 B     -
+TOTAL            3               3.0 KB  
+`
+	if out.String() != want {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
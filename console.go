@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file contains the console output used when the text logs go to a
+// terminal. Records are laid out in columns (time, level, message, fields)
+// so a run can be followed by eye, and colored by what they mean: deletions
+// red, kept images green and dry-run lines yellow. Piped or redirected
+// output, -no-color and the NO_COLOR convention (https://no-color.org) keep
+// the plain key=value lines of slog's text handler, which tools parse.
+
+// ANSI escape sequences of the console colors
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorDim    = "\033[2m"
+)
+
+// consoleMessageWidth is the width the message column is padded to
+const consoleMessageWidth = 44
+
+// useColor reports whether the text logs written to out should be colored
+func useColor(cfg Config, out *os.File) bool {
+	return !cfg.NoColor && os.Getenv("NO_COLOR") == "" && strings.EqualFold(cfg.LogFormat, "text") && isTerminal(out)
+}
+
+// actionColor returns the color of a record with the given action, or ""
+func actionColor(action string) string {
+	switch {
+	case strings.HasPrefix(action, "would-"):
+		return colorYellow
+	case action == "keep":
+		return colorGreen
+	case action == "delete", action == "untag", action == "quarantine", action == "delete-repository":
+		return colorRed
+	}
+	return ""
+}
+
+// levelColor returns the color of a level name, or ""
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return colorRed
+	case level >= slog.LevelWarn:
+		return colorYellow
+	case level < slog.LevelInfo:
+		return colorDim
+	}
+	return ""
+}
+
+// consoleHandler is a slog handler writing colored, column-aligned records
+type consoleHandler struct {
+	w      io.Writer
+	level  slog.Leveler
+	mu     *sync.Mutex
+	attrs  []slog.Attr
+	groups []string
+}
+
+// newConsoleHandler creates a console handler writing to w
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+// Enabled reports whether records of the level are written
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// WithAttrs returns a handler adding the attributes to every record
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(clone.attrs[:len(clone.attrs):len(clone.attrs)], h.qualify(attrs)...)
+	return &clone
+}
+
+// WithGroup returns a handler nesting the attributes of later records in
+// the group
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups = append(clone.groups[:len(clone.groups):len(clone.groups)], name)
+	return &clone
+}
+
+// qualify prefixes the keys of the attributes with the handler's groups
+func (h *consoleHandler) qualify(attrs []slog.Attr) []slog.Attr {
+	if len(h.groups) == 0 {
+		return attrs
+	}
+	prefix := strings.Join(h.groups, ".") + "."
+	qualified := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		qualified[i] = slog.Attr{Key: prefix + attr.Key, Value: attr.Value}
+	}
+	return qualified
+}
+
+// Handle writes a record as one line
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := h.attrs
+	if r.NumAttrs() > 0 {
+		var recordAttrs []slog.Attr
+		r.Attrs(func(attr slog.Attr) bool {
+			recordAttrs = append(recordAttrs, attr)
+			return true
+		})
+		attrs = append(attrs[:len(attrs):len(attrs)], h.qualify(recordAttrs)...)
+	}
+
+	color := ""
+	var fields strings.Builder
+	for _, attr := range attrs {
+		if attr.Key == "action" {
+			color = actionColor(attr.Value.String())
+		}
+		appendConsoleAttr(&fields, "", attr)
+	}
+
+	var line strings.Builder
+	if !r.Time.IsZero() {
+		line.WriteString(colorDim + r.Time.Format(time.TimeOnly) + colorReset + " ")
+	}
+	level := fmt.Sprintf("%-5s", r.Level.String())
+	if c := levelColor(r.Level); c != "" {
+		level = c + level + colorReset
+	}
+	line.WriteString(level + " ")
+	message := fmt.Sprintf("%-*s", consoleMessageWidth, r.Message)
+	if color != "" {
+		message = color + message + colorReset
+	}
+	line.WriteString(message)
+	line.WriteString(fields.String())
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+// appendConsoleAttr writes an attribute as " key=value", flattening groups
+func appendConsoleAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			appendConsoleAttr(b, prefix, member)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if attr.Value.Kind() == slog.KindTime {
+		value = attr.Value.Time().Format(time.RFC3339)
+	}
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(" " + colorDim + prefix + attr.Key + "=" + colorReset + value)
+}
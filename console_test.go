@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestConsoleHandler tests coloring records by action and aligning the
+// message column
+func TestConsoleHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newConsoleHandler(&buf, slog.LevelInfo)).With("run", "r1")
+
+	logger.Info("Deleted images", "action", "delete", "repository", "repo1")
+	logger.Info("[DRY RUN] Would delete image", "action", "would-delete", "tags", "v1,latest")
+	logger.Info("Kept image", "action", "keep", "reason", "newest image")
+	logger.WithGroup("api").Warn("Throttled", "calls", 3)
+	logger.Debug("Not logged")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got:\n%s", buf.String())
+	}
+	for i, color := range []string{colorRed, colorYellow, colorGreen} {
+		if !strings.Contains(lines[i], color) {
+			t.Errorf("Expected color %q in %q", color, lines[i])
+		}
+	}
+	if !strings.Contains(lines[0], "Deleted images"+strings.Repeat(" ", consoleMessageWidth-len("Deleted images"))+colorReset) {
+		t.Errorf("Expected a padded message in %q", lines[0])
+	}
+	if !strings.Contains(lines[2], `reason=`+colorReset+`"newest image"`) || !strings.Contains(lines[0], "run="+colorReset+"r1") {
+		t.Errorf("Unexpected fields in %q", lines[2])
+	}
+	if !strings.Contains(lines[3], colorYellow+"WARN ") || !strings.Contains(lines[3], "api.calls="+colorReset+"3") {
+		t.Errorf("Unexpected warning %q", lines[3])
+	}
+}
+
+// TestUseColor tests that colors are only used for text logs on a terminal
+func TestUseColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	// A file is not a terminal
+	f, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if useColor(Config{LogFormat: "text"}, f) {
+		t.Error("Expected no colors when not writing to a terminal")
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("No terminal available")
+	}
+	defer tty.Close()
+	if !useColor(Config{LogFormat: "text"}, tty) {
+		t.Error("Expected colors on a terminal")
+	}
+	if useColor(Config{LogFormat: "json"}, tty) || useColor(Config{LogFormat: "text", NoColor: true}, tty) {
+		t.Error("Expected no colors for JSON logs or with -no-color")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if useColor(Config{LogFormat: "text"}, tty) {
+		t.Error("Expected no colors with NO_COLOR set")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file contains the cost estimate of a run. Deleted images stop
+// accruing ECR storage charges, so the space freed is priced at the
+// -storage-price per GB-month to estimate the monthly savings.
+
+// defaultStoragePrice is the ECR storage price in USD per GB-month in most
+// regions
+const defaultStoragePrice = 0.10
+
+// monthlySavings estimates the monthly storage cost in USD of the given
+// number of bytes
+func monthlySavings(bytes int64, pricePerGBMonth float64) float64 {
+	return float64(bytes) / (1 << 30) * pricePerGBMonth
+}
+
+// roundUSD rounds an amount in USD to cents
+func roundUSD(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// formatUSD formats an amount in USD, e.g. $12.34
+func formatUSD(amount float64) string {
+	return fmt.Sprintf("$%.2f", amount)
+}
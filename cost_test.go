@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// TestMonthlySavings tests pricing freed space
+func TestMonthlySavings(t *testing.T) {
+	if got := monthlySavings(5<<30, 0.10); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected $0.50 for 5 GB, got %v", got)
+	}
+	if got := roundUSD(monthlySavings(1536<<20, 0.10)); got != 0.15 {
+		t.Errorf("Expected $0.15 for 1.5 GB, got %v", got)
+	}
+	if got := formatUSD(1234.5); got != "$1234.50" {
+		t.Errorf("Expected $1234.50, got %s", got)
+	}
+}
+
+// TestCleanupWithClientSavings tests the estimate per repository and in total
+func TestCleanupWithClientSavings(t *testing.T) {
+	// 5 images of 1000 bytes in each repository, priced per byte. Both
+	// repositories hold the same digests, whose layers are stored once.
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, StoragePrice: 1 << 30}, newGuardrailClient(2, 5))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.EstimatedMonthlySavings != 5000 {
+		t.Errorf("Expected 5000 in total for the digests counted once, got %v", summary.EstimatedMonthlySavings)
+	}
+	for _, repo := range summary.Repositories {
+		if repo.EstimatedMonthlySavings != 5000 {
+			t.Errorf("Expected 5000 for %s, got %v", repo.Name, repo.EstimatedMonthlySavings)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the coverage command, which gives platform teams a
+// view of which repositories have a retention at all. It deletes nothing: a
+// repository is covered when it has a native lifecycle policy or a cleanup
+// tag (an ecr-cleanup/* tag of -tag-policies, or the -opt-in-tag), and the
+// others are reported as missing. With -enforce the missing repositories
+// get the lifecycle policy equivalent to the retention flags, like
+// -manage-lifecycle-policies puts it. The command exits with status 3 when
+// a repository is left without a retention.
+
+// cleanupTagPrefix is the prefix of the repository tags of -tag-policies
+const cleanupTagPrefix = "ecr-cleanup/"
+
+// Statuses of the coverage command
+const (
+	coverageCovered      = "covered"
+	coverageMissing      = "missing"
+	coverageEnforced     = "enforced"
+	coverageWouldEnforce = "would-enforce"
+)
+
+// RepositoryCoverage is how a repository's retention is covered
+type RepositoryCoverage struct {
+	Name            string   `json:"name"`
+	LifecyclePolicy bool     `json:"lifecycle_policy"`
+	CleanupTags     []string `json:"cleanup_tags,omitempty"`
+	Status          string   `json:"status"`
+}
+
+// runCoverage runs the coverage command: it writes the coverage of every
+// repository to stdout, after enforcing the default policy with -enforce
+func runCoverage(config Config) int {
+	ctx := context.Background()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error checking retention coverage", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	ecrClient := ecr.NewFromConfig(awsConfig)
+	results, err := checkCoverage(ctx, client, ecrClient, ecrClient, config)
+	if err != nil {
+		slog.Error("Error checking retention coverage", "error", err)
+		return 1
+	}
+	if config.Enforce {
+		if err := enforceCoverage(ctx, ecrClient, results, config); err != nil {
+			slog.Error("Error enforcing the default lifecycle policy", "error", err)
+			return 1
+		}
+	}
+
+	if strings.EqualFold(config.LogFormat, "json") {
+		err = writeCoverageJSON(os.Stdout, results)
+	} else {
+		err = writeCoverageTable(os.Stdout, results)
+	}
+	if err != nil {
+		slog.Error("Error writing retention coverage", "error", err)
+		return 1
+	}
+
+	missing := 0
+	for _, result := range results {
+		if result.Status == coverageMissing || result.Status == coverageWouldEnforce {
+			missing++
+		}
+	}
+	slog.Info("Retention coverage", "repositories", len(results), "missing", missing)
+	if missing > 0 {
+		return exitNonCompliant
+	}
+	return 0
+}
+
+// checkCoverage reads the lifecycle policy and tags of every repository,
+// in name order
+func checkCoverage(ctx context.Context, client ECRClient, policies LifecyclePolicyClient, tags RepositoryTagClient, cfg Config) ([]RepositoryCoverage, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	var mu sync.Mutex
+	var firstErr error
+	results := []RepositoryCoverage{}
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		result, err := checkRepositoryCoverage(ctx, policies, tags, repo, cfg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		results = append(results, result)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	slices.SortFunc(results, func(a, b RepositoryCoverage) int { return strings.Compare(a.Name, b.Name) })
+	return results, nil
+}
+
+// checkRepositoryCoverage reads whether a repository has a lifecycle
+// policy or a cleanup tag
+func checkRepositoryCoverage(ctx context.Context, policies LifecyclePolicyClient, tags RepositoryTagClient, repo types.Repository, cfg Config) (RepositoryCoverage, error) {
+	repoName := aws.ToString(repo.RepositoryName)
+	result := RepositoryCoverage{Name: repoName}
+
+	text, err := getLifecyclePolicyText(ctx, policies, repoName)
+	if err != nil {
+		return RepositoryCoverage{}, fmt.Errorf("failed to get lifecycle policy of %s: %w", repoName, err)
+	}
+	result.LifecyclePolicy = text != ""
+
+	resp, err := tags.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{ResourceArn: repo.RepositoryArn})
+	if err != nil {
+		return RepositoryCoverage{}, fmt.Errorf("failed to read the tags of repository %s: %w", repoName, err)
+	}
+	result.CleanupTags = cleanupTags(resp.Tags, cfg.OptInTag)
+
+	result.Status = coverageMissing
+	if result.LifecyclePolicy || len(result.CleanupTags) > 0 {
+		result.Status = coverageCovered
+	}
+	return result, nil
+}
+
+// cleanupTags returns the repository tags that give it a retention, as
+// key=value: the ecr-cleanup/* tags, and the -opt-in-tag when set
+func cleanupTags(tags []types.Tag, optInTag string) []string {
+	var found []string
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		if strings.HasPrefix(key, cleanupTagPrefix) || optInTag != "" && hasTag([]types.Tag{tag}, optInTag) {
+			found = append(found, key+"="+aws.ToString(tag.Value))
+		}
+	}
+	return found
+}
+
+// enforceCoverage puts the lifecycle policy derived from the retention
+// flags on the repositories missing a retention, or only logs it in dry
+// run mode
+func enforceCoverage(ctx context.Context, client LifecyclePolicyClient, results []RepositoryCoverage, cfg Config) error {
+	for i, result := range results {
+		if result.Status != coverageMissing {
+			continue
+		}
+		// The warnings are logged when the policy is put
+		if policy, _ := lifecyclePolicyFor(result.Name, cfg); policy == nil {
+			slog.Warn("Leaving repository without a retention: the retention flags can't be expressed as a lifecycle policy", "repository", result.Name)
+			continue
+		}
+		if err := manageLifecyclePolicy(ctx, client, result.Name, cfg); err != nil {
+			return fmt.Errorf("repository %s: %w", result.Name, err)
+		}
+		results[i].Status = coverageEnforced
+		if cfg.DryRun {
+			results[i].Status = coverageWouldEnforce
+		}
+	}
+	return nil
+}
+
+// writeCoverageJSON writes the coverage as JSON
+func writeCoverageJSON(w io.Writer, results []RepositoryCoverage) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string][]RepositoryCoverage{"repositories": results})
+}
+
+// writeCoverageTable writes the coverage as an aligned table
+func writeCoverageTable(w io.Writer, results []RepositoryCoverage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tLIFECYCLE POLICY\tCLEANUP TAGS\tSTATUS")
+	for _, result := range results {
+		policy := "no"
+		if result.LifecyclePolicy {
+			policy = "yes"
+		}
+		tags := "-"
+		if len(result.CleanupTags) > 0 {
+			tags = strings.Join(result.CleanupTags, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Name, policy, tags, result.Status)
+	}
+	return tw.Flush()
+}
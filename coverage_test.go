@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// newCoverageClients builds four repositories: repo0 with a lifecycle
+// policy, repo1 with a cleanup tag, repo2 opted in and repo3 with neither
+func newCoverageClients() (*MockECRClient, *mockLifecycleClient, *repositoryTagsClient) {
+	client := newGuardrailClient(4, 1)
+	for i := range client.DescribeRepositoriesOutput.Repositories {
+		repo := &client.DescribeRepositoriesOutput.Repositories[i]
+		repo.RepositoryArn = aws.String("arn:aws:ecr:us-east-1:123456789012:repository/" + aws.ToString(repo.RepositoryName))
+	}
+	policies := &mockLifecycleClient{policies: map[string]string{"repo0": `{"rules":[]}`}}
+	tags := &repositoryTagsClient{tags: map[string][]types.Tag{
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo1": repositoryTags("ecr-cleanup/days", "45", "team", "web"),
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo2": repositoryTags("cleanup", "enabled"),
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo3": repositoryTags("team", "data"),
+	}}
+	return client, policies, tags
+}
+
+// TestCheckCoverage tests finding the repositories without a retention
+func TestCheckCoverage(t *testing.T) {
+	client, policies, tags := newCoverageClients()
+	results, err := checkCoverage(context.Background(), client, policies, tags, Config{OptInTag: "cleanup=enabled"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, result.Name+":"+result.Status+":"+strings.Join(result.CleanupTags, ","))
+	}
+	want := "repo0:covered: repo1:covered:ecr-cleanup/days=45 repo2:covered:cleanup=enabled repo3:missing:"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, " "))
+	}
+	if !results[0].LifecyclePolicy || results[3].LifecyclePolicy {
+		t.Errorf("Expected only repo0 to have a lifecycle policy, got %+v", results)
+	}
+}
+
+// TestEnforceCoverage tests putting the default lifecycle policy on the
+// repositories without a retention
+func TestEnforceCoverage(t *testing.T) {
+	client, policies, tags := newCoverageClients()
+	cfg := Config{Days: 30, Enforce: true}
+	results, err := checkCoverage(context.Background(), client, policies, tags, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// A dry run puts nothing
+	dryRun := cfg
+	dryRun.DryRun = true
+	if err := enforceCoverage(context.Background(), policies, results, dryRun); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(policies.puts) != 0 || results[2].Status != coverageWouldEnforce || results[3].Status != coverageWouldEnforce {
+		t.Errorf("Expected nothing put in a dry run, got %v and %+v", policies.puts, results)
+	}
+
+	for i := range results {
+		if results[i].Status == coverageWouldEnforce {
+			results[i].Status = coverageMissing
+		}
+	}
+	if err := enforceCoverage(context.Background(), policies, results, cfg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(policies.puts, ",") != "repo2,repo3" || results[3].Status != coverageEnforced || results[0].Status != coverageCovered {
+		t.Errorf("Expected the policy put on repo2 and repo3, got %v and %+v", policies.puts, results)
+	}
+	if !strings.Contains(policies.policies["repo3"], `"countNumber":30`) {
+		t.Errorf("Expected the 30-day policy, got %s", policies.policies["repo3"])
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"gopkg.in/yaml.v3"
+)
+
+// This file contains -protect-deployments, which makes the desired state of
+// a GitOps repository the source of truth for what's in use. The ECR images
+// referenced by the YAML files of a directory or Git repository are never
+// deleted:
+//
+//	image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/api:v1   Kubernetes, docker-compose
+//	repository: .../api, tag: v1 (and registry, digest)          Helm values
+//	newName: .../api, newTag: v1 (and digest)                    kustomize images
+//
+// Files that aren't valid YAML, such as Helm templates, are skipped.
+
+// gitURLPrefixes are the prefixes of the -protect-deployments values that
+// are cloned rather than read as a local directory
+var gitURLPrefixes = []string{"https://", "http://", "ssh://", "git://", "git@", "file://"}
+
+// isGitURL reports whether a -protect-deployments value is a Git repository
+// URL
+func isGitURL(source string) bool {
+	for _, prefix := range gitURLPrefixes {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(source, ".git")
+}
+
+// loadDeployments reads the images of the run's -protect-deployments, if
+// any, cloning the Git repository first
+func loadDeployments(ctx context.Context, cfg Config) (*keepSet, error) {
+	if cfg.ProtectDeployments == "" {
+		return nil, nil
+	}
+
+	dir := cfg.ProtectDeployments
+	if isGitURL(dir) {
+		clone, err := os.MkdirTemp("", "ecr-cleanup-deployments-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone deployment manifests: %w", err)
+		}
+		defer os.RemoveAll(clone)
+		if err := cloneRepository(ctx, cfg.ProtectDeployments, cfg.ProtectDeploymentsRef, clone); err != nil {
+			return nil, fmt.Errorf("failed to clone deployment manifests: %w", err)
+		}
+		dir = clone
+	}
+
+	keep, err := readDeployments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment manifests: %w", err)
+	}
+	slog.Info("Protecting images of deployment manifests", "images", keep.size(), "source", cfg.ProtectDeployments)
+	return keep, nil
+}
+
+// cloneRepository makes a shallow clone of a Git repository's ref, or of
+// its default branch, into dir
+func cloneRepository(ctx context.Context, url, ref, dir string) error {
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", url, dir)
+
+	output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git clone %s: %w: %s", url, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readDeployments collects the ECR images referenced by every YAML file
+// under dir
+func readDeployments(dir string) (*keepSet, error) {
+	keep := newKeepSet()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		return readManifestFile(path, keep)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keep, nil
+}
+
+// readManifestFile adds the images referenced by each document of a YAML
+// file
+func readManifestFile(path string, keep *keepSet) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			slog.Debug("Skipping file that isn't valid YAML", "file", path, "error", err)
+			return nil
+		}
+		addManifestImages(&doc, keep)
+	}
+}
+
+// addManifestImages walks a YAML node and adds the image references found
+// in it
+func addManifestImages(node *yaml.Node, keep *keepSet) {
+	if node.Kind == yaml.MappingNode {
+		fields := make(map[string]string)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if value := node.Content[i+1]; value.Kind == yaml.ScalarNode {
+				fields[node.Content[i].Value] = value.Value
+			}
+		}
+		if fields["image"] != "" {
+			keep.addURI(fields["image"])
+		}
+		if uri := splitImageURI(fields); uri != "" {
+			keep.addURI(uri)
+		}
+	}
+
+	for _, child := range node.Content {
+		addManifestImages(child, keep)
+	}
+}
+
+// splitImageURI joins an image reference split across Helm value or
+// kustomize fields, or returns "" if the fields don't hold one
+func splitImageURI(fields map[string]string) string {
+	repository, tag := fields["repository"], fields["tag"]
+	if fields["newName"] != "" {
+		repository, tag = fields["newName"], fields["newTag"]
+	}
+	if repository == "" {
+		return ""
+	}
+
+	uri := repository
+	if registry := fields["registry"]; registry != "" {
+		uri = strings.TrimSuffix(registry, "/") + "/" + repository
+	}
+	if tag != "" {
+		uri += ":" + tag
+	}
+	if digest := fields["digest"]; digest != "" {
+		uri += "@" + digest
+	}
+	return uri
+}
+
+// excludeDeployed returns the images that no deployment manifest references
+func excludeDeployed(repoName string, images []types.ImageDetail, deployed *keepSet) []types.ImageDetail {
+	if deployed.size() == 0 {
+		return images
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range images {
+		if deployed.contains(repoName, img) {
+			slog.Info("Keeping deployed image", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// writeDeployments writes a GitOps repository of deployment manifests into
+// a temporary directory
+func writeDeployments(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"apps/api/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: api
+          image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/api:v1.4.2
+        - name: proxy
+          image: envoyproxy/envoy:v1.30
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/jobs@sha256:aaa
+`,
+		"charts/web/values.yml": `image:
+  registry: 123456789012.dkr.ecr.us-east-1.amazonaws.com
+  repository: web
+  tag: 2024.05
+`,
+		"charts/web/templates/deployment.yaml": `image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+{{- if .Values.sidecar }}
+`,
+		"compose/docker-compose.yml": `services:
+  worker:
+    image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/worker
+`,
+		"overlays/prod/kustomization.yaml": `images:
+  - name: api
+    newName: 123456789012.dkr.ecr.us-east-1.amazonaws.com/api
+    newTag: v1.5.0
+`,
+		"README.md": "image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/docs:v1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	return dir
+}
+
+// TestReadDeployments tests extracting the ECR images of Kubernetes
+// manifests, Helm values, docker-compose files and kustomizations
+func TestReadDeployments(t *testing.T) {
+	keep, err := readDeployments(writeDeployments(t))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for repo, tag := range map[string]string{"api": "v1.4.2", "web": "2024.05", "worker": "latest"} {
+		if !keep.tags[repo][tag] {
+			t.Errorf("Expected %s:%s to be protected", repo, tag)
+		}
+	}
+	if !keep.tags["api"]["v1.5.0"] || !keep.digests["jobs"]["sha256:aaa"] {
+		t.Errorf("Expected the kustomize tag and the digest to be protected, got %+v %+v", keep.tags, keep.digests)
+	}
+	if keep.size() != 5 {
+		t.Errorf("Expected only the ECR images of YAML files, got %d", keep.size())
+	}
+
+	if _, err := readDeployments(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
+
+// TestLoadDeploymentsFromGit tests cloning the deployment manifests
+func TestLoadDeploymentsFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := writeDeployments(t)
+	for _, args := range [][]string{
+		{"init", "--quiet", "--initial-branch", "main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "Deploy"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+
+	keep, err := loadDeployments(context.Background(), Config{ProtectDeployments: "file://" + dir, ProtectDeploymentsRef: "main"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if keep.size() != 5 {
+		t.Errorf("Expected the images of the clone, got %d", keep.size())
+	}
+
+	if _, err := loadDeployments(context.Background(), Config{ProtectDeployments: "file://" + dir, ProtectDeploymentsRef: "missing"}); err == nil {
+		t.Error("Expected an error for a missing branch")
+	}
+}
+
+// TestIsGitURL tests telling Git repositories from local directories
+func TestIsGitURL(t *testing.T) {
+	for source, want := range map[string]bool{
+		"https://github.com/acme/deploy":   true,
+		"git@github.com:acme/deploy.git":   true,
+		"ssh://git@example.com/deploy.git": true,
+		"../deploy.git":                    true,
+		"./deploy":                         false,
+		"/srv/gitops":                      false,
+	} {
+		if isGitURL(source) != want {
+			t.Errorf("Expected %q to be a Git URL: %v", source, want)
+		}
+	}
+}
+
+// TestCleanupWithDeployments tests that deployed images are never deleted
+func TestCleanupWithDeployments(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/repo0@sha256:001\n"
+	if err := os.WriteFile(filepath.Join(dir, "deployment.yaml"), []byte(manifest), 0o600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, ProtectDeployments: dir}, newGuardrailClient(2, 5))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 9 {
+		t.Errorf("Expected the deployed image kept, got %d images deleted", summary.ImagesDeleted)
+	}
+
+	images := []types.ImageDetail{{ImageDigest: aws.String("sha256:001")}}
+	if remaining := excludeDeployed("repo0", images, nil); len(remaining) != 1 {
+		t.Error("Expected no manifests to protect nothing")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the dry-run output, which is what gets reviewed before
+// a cleanup is enabled. Every candidate is logged with all of its tags, its
+// age, size and last pull, so a reviewer can tell a stale build from a
+// release somebody still pulls. The lines of a repository are logged
+// together, even when repositories are scanned concurrently, and end with
+// its subtotal. Text output shortens digests for reading; JSON output keeps
+// them whole for tooling.
+
+// dryRunMu keeps the dry-run lines of concurrent repositories apart
+var dryRunMu sync.Mutex
+
+// logDryRun logs the images a run would delete or untag from a repository,
+// followed by the repository's subtotal
+func logDryRun(repoName string, images []types.ImageDetail, cfg Config, now time.Time) {
+	action, message := "would-delete", "[DRY RUN] Would delete image"
+	if cfg.UntagOnly {
+		action, message = "would-untag", "[DRY RUN] Would remove the tags of image"
+	}
+	text := !strings.EqualFold(cfg.LogFormat, "json")
+
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	var total int64
+	for _, img := range images {
+		digest := aws.ToString(img.ImageDigest)
+		if text {
+			digest = shortDigest(digest)
+		}
+		attrs := []any{
+			"action", action,
+			"repository", repoName,
+			"tag", getImageTag(img),
+			"tags", strings.Join(img.ImageTags, ","),
+			"digest", digest,
+		}
+		if img.ImagePushedAt != nil {
+			attrs = append(attrs, "pushed_at", img.ImagePushedAt.Format(time.RFC3339), "age_days", int(now.Sub(*img.ImagePushedAt).Hours()/24))
+		}
+		if img.ImageSizeInBytes != nil {
+			total += *img.ImageSizeInBytes
+			attrs = append(attrs, "size_bytes", *img.ImageSizeInBytes, "size", formatBytes(*img.ImageSizeInBytes))
+		}
+		lastPull := "never"
+		if img.LastRecordedPullTime != nil {
+			lastPull = img.LastRecordedPullTime.Format(time.RFC3339)
+		}
+		attrs = append(attrs, "last_pulled", lastPull)
+
+		slog.Info(message, attrs...)
+	}
+	slog.Info("[DRY RUN] Repository subtotal", "action", action+"-subtotal", "repository", repoName, "images", len(images), "size_bytes", total, "size", formatBytes(total))
+}
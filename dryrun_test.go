@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestLogDryRun tests logging every tag and the context of each candidate,
+// followed by the repository's subtotal
+func TestLogDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := newLogHandler(&buf, "info", "text")
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	defer slog.SetDefault(previous)
+
+	now := time.Now()
+	images := []types.ImageDetail{
+		{
+			ImageDigest:          aws.String("sha256:0123456789abcdef0123456789abcdef"),
+			ImageTags:            []string{"v1", "latest-stable"},
+			ImagePushedAt:        aws.Time(now.AddDate(0, 0, -40)),
+			ImageSizeInBytes:     aws.Int64(2048),
+			LastRecordedPullTime: aws.Time(now.AddDate(0, 0, -3)),
+		},
+		{ImageDigest: aws.String("sha256:fedcba"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -12)), ImageSizeInBytes: aws.Int64(1024)},
+	}
+	logDryRun("repo1", images, Config{}, now)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected two images and a subtotal, got:\n%s", buf.String())
+	}
+	for _, want := range []string{"tags=v1,latest-stable", "digest=sha256:0123456789ab ", "age_days=40", `size="2.0 KB"`, "last_pulled=" + now.AddDate(0, 0, -3).Format(time.RFC3339)} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("Expected %s in %q", want, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], "age_days=12") || !strings.Contains(lines[1], "last_pulled=never") {
+		t.Errorf("Unexpected line %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "Repository subtotal") || !strings.Contains(lines[2], "images=2") || !strings.Contains(lines[2], "size_bytes=3072") {
+		t.Errorf("Unexpected subtotal %q", lines[2])
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// This file contains the ECS in-use provider. The images of the tasks
+// running in every cluster of a region are never deleted, by tag and by the
+// digest the task actually pulled, so re-pushing a tag doesn't expose the
+// image a long-running task still runs.
+
+// ecsDescribeTasksBatchSize is the maximum number of tasks DescribeTasks
+// accepts per call
+const ecsDescribeTasksBatchSize = 100
+
+// ECSClient defines the ECS operations the provider needs
+type ECSClient interface {
+	ListClusters(ctx context.Context, params *ecs.ListClustersInput, optFns ...func(*ecs.Options)) (*ecs.ListClustersOutput, error)
+	ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error)
+	DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+}
+
+// ecsProvider protects the images of running ECS tasks
+type ecsProvider struct{}
+
+// Name identifies the provider
+func (ecsProvider) Name() string { return "ECS" }
+
+// Regional reports that ECS tasks are collected in every region
+func (ecsProvider) Regional() bool { return true }
+
+// Collect adds the images of the running tasks of every cluster
+func (ecsProvider) Collect(ctx context.Context, awsConfig aws.Config, keep *keepSet) error {
+	return collectECSImages(ctx, ecs.NewFromConfig(awsConfig), keep)
+}
+
+// collectECSImages adds the images of the running tasks of every cluster
+func collectECSImages(ctx context.Context, client ECSClient, keep *keepSet) error {
+	var clusters []string
+	paginator := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ListClusters failed: %w", err)
+		}
+		clusters = append(clusters, page.ClusterArns...)
+	}
+
+	for _, cluster := range clusters {
+		if err := collectECSClusterImages(ctx, client, cluster, keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectECSClusterImages adds the images of the running tasks of a
+// cluster
+func collectECSClusterImages(ctx context.Context, client ECSClient, cluster string, keep *keepSet) error {
+	paginator := ecs.NewListTasksPaginator(client, &ecs.ListTasksInput{Cluster: aws.String(cluster), DesiredStatus: ecstypes.DesiredStatusRunning})
+	for paginator.HasMorePages() {
+		list, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ListTasks failed: %w", err)
+		}
+
+		for i := 0; i < len(list.TaskArns); i += ecsDescribeTasksBatchSize {
+			out, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+				Cluster: aws.String(cluster),
+				Tasks:   list.TaskArns[i:min(i+ecsDescribeTasksBatchSize, len(list.TaskArns))],
+			})
+			if err != nil {
+				return fmt.Errorf("DescribeTasks failed: %w", err)
+			}
+			for _, task := range out.Tasks {
+				for _, container := range task.Containers {
+					ref, ok := parseImageRef(aws.ToString(container.Image))
+					if !ok {
+						continue
+					}
+					keep.add(ref)
+					if digest := aws.ToString(container.ImageDigest); digest != "" {
+						keep.add(imageRef{Repository: ref.Repository, Digest: digest})
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
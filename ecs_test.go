@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestCollectECSImages tests protecting the images of running tasks by tag
+// and by the digest they pulled
+func TestCollectECSImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]any
+		json.NewDecoder(r.Body).Decode(&input)
+		switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerServiceV20141113.") {
+		case "ListClusters":
+			w.Write([]byte(`{"clusterArns":["prod"]}`))
+		case "ListTasks":
+			if input["nextToken"] == nil {
+				w.Write([]byte(`{"taskArns":["t1"],"nextToken":"page2"}`))
+			} else {
+				w.Write([]byte(`{"taskArns":["t2"]}`))
+			}
+		case "DescribeTasks":
+			if input["tasks"].([]any)[0] == "t1" {
+				w.Write([]byte(`{"tasks":[{"containers":[{"image":"123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1","imageDigest":"sha256:111"},{"image":"nginx:latest"}]}]}`))
+			} else {
+				w.Write([]byte(`{"tasks":[{"containers":[{"image":"123456789012.dkr.ecr.us-east-1.amazonaws.com/worker@sha256:222"}]}]}`))
+			}
+		default:
+			http.Error(w, `{"message":"unexpected"}`, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+	keep := newKeepSet()
+	if err := (ecsProvider{}).Collect(context.Background(), awsConfig, keep); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if keep.size() != 3 {
+		t.Errorf("Expected the tag and two digests, got %d references", keep.size())
+	}
+	// A re-pushed tag still protects the digest the task runs
+	if !keep.contains("app", types.ImageDetail{ImageDigest: aws.String("sha256:111")}) || !keep.contains("worker", types.ImageDetail{ImageDigest: aws.String("sha256:222")}) {
+		t.Error("Expected the digests of the running tasks to be protected")
+	}
+}
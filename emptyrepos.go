@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -delete-empty-repos, which deletes repositories that
+// hold no image and were created more than -empty-repo-days ago. Empty
+// repositories cost nothing but clutter the registry as projects come and
+// go. A repository is only deleted when its scan found no image, and ECR
+// refuses to delete one that got an image since, so no image is ever lost.
+
+// defaultEmptyRepoDays is how old an empty repository must be to be deleted
+const defaultEmptyRepoDays = 30
+
+// RepositoryDeleter defines the ECR operation needed to delete repositories
+type RepositoryDeleter interface {
+	DeleteRepository(ctx context.Context, params *ecr.DeleteRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.DeleteRepositoryOutput, error)
+}
+
+// isDeletableEmptyRepository reports whether a scanned repository is empty
+// and old enough to be deleted. Applying a plan deletes the repositories
+// the plan lists, and only those.
+func isDeletableEmptyRepository(run *repositoryRun, cfg Config) bool {
+	if run.err != nil || run.stats.images > 0 {
+		return false
+	}
+	if cfg.plan != nil {
+		repo := cfg.plan.repository(cfg.accountID, cfg.region, run.name)
+		return repo != nil && repo.DeleteRepository
+	}
+	cutoff := cfg.now().AddDate(0, 0, -cfg.EmptyRepoDays)
+	return cfg.DeleteEmptyRepos && run.createdAt != nil && run.createdAt.Before(cutoff)
+}
+
+// deleteEmptyRepository deletes an empty repository, or only logs it in
+// dry run mode. It reports whether the repository was deleted; one that
+// got an image since it was scanned is kept.
+func deleteEmptyRepository(ctx context.Context, deleter RepositoryDeleter, repoName string, createdAt *time.Time, cfg Config) (bool, error) {
+	attrs := []any{"repository", repoName}
+	if createdAt != nil {
+		attrs = append(attrs, "created_at", createdAt.Format(time.RFC3339))
+	}
+	if cfg.DryRun {
+		slog.Info("[DRY RUN] Would delete empty repository", append([]any{"action", "would-delete-repository"}, attrs...)...)
+		return true, nil
+	}
+	if deleter == nil {
+		return false, fmt.Errorf("deleting empty repositories isn't supported here")
+	}
+
+	_, err := deleter.DeleteRepository(ctx, &ecr.DeleteRepositoryInput{RepositoryName: aws.String(repoName)})
+	var notEmpty *types.RepositoryNotEmptyException
+	if errors.As(err, &notEmpty) {
+		slog.Info("Keeping repository that is no longer empty", append([]any{"action", "keep"}, attrs...)...)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete empty repository: %w", err)
+	}
+	slog.Info("Deleted empty repository", append([]any{"action", "delete-repository"}, attrs...)...)
+	return true, nil
+}
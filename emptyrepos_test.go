@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// emptyRepoClient serves the images of each repository separately
+type emptyRepoClient struct {
+	*MockECRClient
+	images map[string][]types.ImageDetail
+}
+
+func (c *emptyRepoClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	return &ecr.DescribeImagesOutput{ImageDetails: c.images[aws.ToString(params.RepositoryName)]}, nil
+}
+
+// mockRepositoryDeleter records the repositories it deletes
+type mockRepositoryDeleter struct {
+	deleted  []string
+	notEmpty map[string]bool
+}
+
+func (m *mockRepositoryDeleter) DeleteRepository(ctx context.Context, params *ecr.DeleteRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.DeleteRepositoryOutput, error) {
+	name := aws.ToString(params.RepositoryName)
+	if m.notEmpty[name] {
+		return nil, &types.RepositoryNotEmptyException{Message: aws.String("not empty")}
+	}
+	m.deleted = append(m.deleted, name)
+	return &ecr.DeleteRepositoryOutput{}, nil
+}
+
+// newEmptyRepoClient returns repositories created the given number of days
+// ago, with one recent image in "busy"
+func newEmptyRepoClient(created map[string]int) *emptyRepoClient {
+	var repos []types.Repository
+	for name, days := range created {
+		repos = append(repos, types.Repository{RepositoryName: aws.String(name), CreatedAt: aws.Time(time.Now().AddDate(0, 0, -days))})
+	}
+	return &emptyRepoClient{
+		MockECRClient: &MockECRClient{
+			DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: repos},
+			BatchDeleteImageOutput:     &ecr.BatchDeleteImageOutput{},
+		},
+		images: map[string][]types.ImageDetail{
+			"busy": {{ImageDigest: aws.String("sha256:busy"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(time.Now())}},
+		},
+	}
+}
+
+// TestDeleteEmptyRepos tests deleting only old empty repositories
+func TestDeleteEmptyRepos(t *testing.T) {
+	client := newEmptyRepoClient(map[string]int{"abandoned": 90, "new": 3, "busy": 90, "refilled": 90})
+	deleter := &mockRepositoryDeleter{notEmpty: map[string]bool{"refilled": true}}
+
+	cfg := Config{Days: 10, DeleteEmptyRepos: true, EmptyRepoDays: 30, SkipListImages: true, repoDeleter: deleter}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sort.Strings(deleter.deleted)
+	if strings.Join(deleter.deleted, ",") != "abandoned" {
+		t.Errorf("Expected only abandoned deleted, got %v", deleter.deleted)
+	}
+	if summary.RepositoriesDeleted != 1 {
+		t.Errorf("Expected 1 repository deleted, got %d", summary.RepositoriesDeleted)
+	}
+
+	// Without the flag nothing is deleted
+	deleter = &mockRepositoryDeleter{}
+	cfg.DeleteEmptyRepos, cfg.repoDeleter = false, deleter
+	if _, err := CleanupWithClient(context.Background(), cfg, client); err != nil || len(deleter.deleted) > 0 {
+		t.Errorf("Expected no repository deleted, got %v, %v", deleter.deleted, err)
+	}
+}
+
+// TestPlanEmptyRepos tests that a plan lists the empty repositories it
+// deletes, and applying it deletes those only
+func TestPlanEmptyRepos(t *testing.T) {
+	client := newEmptyRepoClient(map[string]int{"abandoned": 90, "old": 60, "busy": 90})
+	deleter := &mockRepositoryDeleter{}
+
+	cfg := Config{Days: 10, DeleteEmptyRepos: true, EmptyRepoDays: 30, SkipListImages: true, DryRun: true, repoDeleter: deleter}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(deleter.deleted) > 0 || summary.RepositoriesDeleted != 2 {
+		t.Fatalf("Expected 2 repositories that would be deleted in a dry run, got %d and %v deleted", summary.RepositoriesDeleted, deleter.deleted)
+	}
+
+	plan := newPlan(summary, time.Now())
+	if plan.EmptyRepositories != 2 || plan.repository("", "", "old") == nil || !plan.repository("", "", "old").DeleteRepository {
+		t.Fatalf("Expected both empty repositories planned, got %+v", plan)
+	}
+
+	// Only the planned repositories are deleted, with or without the flag
+	plan.Repositories = plan.Repositories[:1]
+	cfg = Config{Days: 10, SkipListImages: true, repoDeleter: deleter, plan: plan}
+	if _, err := CleanupWithClient(context.Background(), cfg, client); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(deleter.deleted) != 1 || deleter.deleted[0] != plan.Repositories[0].Name {
+		t.Errorf("Expected only %s deleted, got %v", plan.Repositories[0].Name, deleter.deleted)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// This file contains the options that point the AWS clients somewhere other
+// than the public AWS endpoints: -endpoint-url sends every call to a single
+// endpoint such as LocalStack or a moto server, or to an interface VPC
+// endpoint of an air-gapped network; -proxy-url sends the calls through an
+// HTTP proxy; and -ca-bundle trusts the certificate authorities of a PEM
+// file, e.g. of a TLS-inspecting proxy. -fips and -dual-stack pick the FIPS
+// 140 validated and the IPv6 variants of the AWS endpoints instead, as
+// GovCloud and IPv6-only VPCs require. Without them, the SDK's own settings
+// apply, such as AWS_ENDPOINT_URL, AWS_USE_FIPS_ENDPOINT and HTTPS_PROXY.
+
+// parseEndpointURL validates an -endpoint-url or -proxy-url value
+func parseEndpointURL(flag, value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q: expected an http(s) URL", flag, value)
+	}
+	return u, nil
+}
+
+// endpointOptions returns the AWS config options of -endpoint-url, -fips,
+// -dual-stack, -proxy-url and -ca-bundle
+func endpointOptions(cfg Config) ([]func(*config.LoadOptions) error, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.EndpointURL != "" {
+		if _, err := parseEndpointURL("-endpoint-url", cfg.EndpointURL); err != nil {
+			return nil, err
+		}
+		// A custom endpoint is used as is, so it can't have variants
+		if cfg.FIPS || cfg.DualStack {
+			return nil, errors.New("-endpoint-url can't be combined with -fips or -dual-stack")
+		}
+		opts = append(opts, config.WithBaseEndpoint(cfg.EndpointURL))
+	}
+	if cfg.FIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if cfg.DualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if cfg.ProxyURL != "" {
+		proxy, err := parseEndpointURL("-proxy-url", cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		client := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.Proxy = http.ProxyURL(proxy)
+		})
+		opts = append(opts, config.WithHTTPClient(client))
+	}
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		opts = append(opts, config.WithCustomCABundle(bytes.NewReader(pem)))
+	}
+	return opts, nil
+}
+
+// newS3Client returns an S3 client of the AWS config. Emulators and VPC
+// endpoints serve buckets under the endpoint's path rather than as
+// subdomains.
+func newS3Client(awsConfig aws.Config) *s3.Client {
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = awsConfig.BaseEndpoint != nil
+	})
+}
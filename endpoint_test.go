@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// TestLoadAWSConfigEndpoint tests pointing every client at a custom endpoint
+func TestLoadAWSConfigEndpoint(t *testing.T) {
+	awsConfig, err := loadAWSConfig(context.Background(), Config{Region: "us-east-1", EndpointURL: "http://localhost:4566", ProxyURL: "http://proxy:3128"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if endpoint := aws.ToString(awsConfig.BaseEndpoint); endpoint != "http://localhost:4566" {
+		t.Errorf("Expected the LocalStack endpoint, got %q", endpoint)
+	}
+	if !newS3Client(awsConfig).Options().UsePathStyle {
+		t.Error("Expected path-style S3 addressing with a custom endpoint")
+	}
+
+	awsConfig, err = loadAWSConfig(context.Background(), Config{Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if awsConfig.BaseEndpoint != nil || newS3Client(awsConfig).Options().UsePathStyle {
+		t.Error("Expected the default endpoints and addressing")
+	}
+}
+
+// TestEndpointOptionsInvalid tests rejecting unusable endpoint options
+func TestEndpointOptionsInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{EndpointURL: "localhost:4566"},
+		{ProxyURL: "socks5://proxy:1080"},
+		{CABundle: filepath.Join(t.TempDir(), "missing.pem")},
+		{EndpointURL: "http://localhost:4566", FIPS: true},
+	} {
+		if _, err := endpointOptions(cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+// TestEndpointOptionsVariants tests picking the FIPS and dual-stack endpoints
+func TestEndpointOptionsVariants(t *testing.T) {
+	opts, err := endpointOptions(Config{FIPS: true, DualStack: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var loaded config.LoadOptions
+	for _, opt := range opts {
+		if err := opt(&loaded); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if loaded.UseFIPSEndpoint != aws.FIPSEndpointStateEnabled || loaded.UseDualStackEndpoint != aws.DualStackEndpointStateEnabled {
+		t.Errorf("Expected FIPS and dual-stack endpoints, got %v and %v", loaded.UseFIPSEndpoint, loaded.UseDualStackEndpoint)
+	}
+}
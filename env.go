@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// This file contains the environment variable equivalents of the flags, so
+// the tool can be configured through a container's environment in ECS or
+// Kubernetes without templating a command line. Each flag has a variable
+// named after it, e.g. ECR_CLEANUP_DRY_RUN for -dry-run. Flags given on the
+// command line take precedence over the environment, which takes
+// precedence over the defaults.
+
+// envPrefix prefixes the environment variables that set options
+const envPrefix = "ECR_CLEANUP_"
+
+// repeatableFlags are the flags that may be given more than once; their
+// variables hold one value per line
+var repeatableFlags = map[string]bool{
+	"repository":     true,
+	"webhook-header": true,
+}
+
+// optionName converts an option key such as DRY_RUN or dry_run to its flag
+// name, dry-run
+func optionName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// applyEnvironment sets the flags that weren't given on the command line
+// from their ECR_CLEANUP_* variables in environ. A variable that matches no
+// flag is an error, so a misspelled one doesn't go unnoticed.
+func applyEnvironment(fs *flag.FlagSet, environ []string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// Sorted, so the first invalid variable is always the one reported
+	environ = slices.Clone(environ)
+	slices.Sort(environ)
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		name := optionName(strings.TrimPrefix(key, envPrefix))
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s doesn't match any flag", key)
+		}
+		if given[name] {
+			continue
+		}
+
+		values := []string{value}
+		if repeatableFlags[name] {
+			values = strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == '\r' })
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", value, key, err)
+			}
+		}
+	}
+	return nil
+}
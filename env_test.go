@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestApplyEnvironment tests setting flags from ECR_CLEANUP_* variables,
+// with the command line taking precedence
+func TestApplyEnvironment(t *testing.T) {
+	t.Setenv("ECR_CLEANUP_DRY_RUN", "true")
+	t.Setenv("ECR_CLEANUP_DAYS", "30")
+	t.Setenv("ECR_CLEANUP_MAX_IMAGES", "5")
+	t.Setenv("ECR_CLEANUP_REPOSITORY", "team/api\nteam/web\n")
+	t.Setenv("ECR_CLEANUP_WEBHOOK_HEADER", "X-A: 1, 2")
+
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-days", "14"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.DryRun || cfg.Days != 14 || cfg.MaxImages != 5 {
+		t.Errorf("Expected the environment under the command line, got %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Repositories, []string{"team/api", "team/web"}) {
+		t.Errorf("Expected a repository per line, got %v", cfg.Repositories)
+	}
+	if !reflect.DeepEqual(cfg.WebhookHeaders, []string{"X-A: 1, 2"}) {
+		t.Errorf("Expected one header, got %v", cfg.WebhookHeaders)
+	}
+
+	// A repeatable flag on the command line replaces the variable
+	cfg, _ = parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-repository", "team/db"})
+	if !reflect.DeepEqual(cfg.Repositories, []string{"team/db"}) {
+		t.Errorf("Expected only the command line repository, got %v", cfg.Repositories)
+	}
+}
+
+// TestApplyEnvironmentErrors tests rejecting misspelled variables and
+// invalid values
+func TestApplyEnvironmentErrors(t *testing.T) {
+	for environ, want := range map[string]string{
+		"ECR_CLEANUP_DAZE=30":     "ECR_CLEANUP_DAZE doesn't match any flag",
+		"ECR_CLEANUP_DAYS=thirty": `invalid value "thirty" for ECR_CLEANUP_DAYS`,
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("days", 10, "")
+		err := applyEnvironment(fs, []string{"PATH=/usr/bin", environ})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// This file contains the EventBridge events sent for every deleted image,
+// so downstream automation such as cache invalidation or CMDB updates can
+// react to deletions as they happen rather than after the run.
+
+// Source and detail type of the events sent to -event-bus
+const (
+	eventSource           = "ecr-cleanup"
+	eventImageDeletedType = "ecr-cleanup.image.deleted"
+)
+
+// putEventsBatchSize is the maximum number of entries PutEvents accepts
+const putEventsBatchSize = 10
+
+// EventBridgeClient defines the EventBridge operation needed to send events
+type EventBridgeClient interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// ImageDeletedEvent is the detail of an ecr-cleanup.image.deleted event
+type ImageDeletedEvent struct {
+	RegistryID string    `json:"registry_id"`
+	Region     string    `json:"region,omitempty"`
+	Repository string    `json:"repository"`
+	Digest     string    `json:"digest"`
+	Tags       []string  `json:"tags,omitempty"`
+	PushedAt   time.Time `json:"pushed_at"`
+	SizeBytes  int64     `json:"size_bytes"`
+}
+
+// eventEmitter sends an event to the -event-bus bus for each deleted image.
+// A nil eventEmitter sends nothing.
+type eventEmitter struct {
+	client EventBridgeClient
+	bus    string
+}
+
+// newEventEmitter creates the emitter for the -event-bus bus, sending in
+// the bus's region when it is given as an ARN
+func newEventEmitter(awsConfig aws.Config, bus string) *eventEmitter {
+	if busArn, err := arn.Parse(bus); err == nil {
+		awsConfig = awsConfig.Copy()
+		awsConfig.Region = busArn.Region
+	}
+	return &eventEmitter{client: eventbridge.NewFromConfig(awsConfig), bus: bus}
+}
+
+// imagesDeleted sends an event for each deleted image of a repository. The
+// images are gone by then, so failures are logged rather than failing the
+// repository.
+func (e *eventEmitter) imagesDeleted(ctx context.Context, cfg Config, repoName string, images []types.ImageDetail) {
+	if e == nil {
+		return
+	}
+
+	for i := 0; i < len(images); i += putEventsBatchSize {
+		batch := images[i:min(i+putEventsBatchSize, len(images))]
+		entries := make([]ebtypes.PutEventsRequestEntry, len(batch))
+		for j, img := range batch {
+			detail, _ := json.Marshal(ImageDeletedEvent{
+				RegistryID: aws.ToString(img.RegistryId),
+				Region:     cfg.region,
+				Repository: repoName,
+				Digest:     aws.ToString(img.ImageDigest),
+				Tags:       img.ImageTags,
+				PushedAt:   aws.ToTime(img.ImagePushedAt).UTC(),
+				SizeBytes:  aws.ToInt64(img.ImageSizeInBytes),
+			})
+			entries[j] = ebtypes.PutEventsRequestEntry{
+				EventBusName: aws.String(e.bus),
+				Source:       aws.String(eventSource),
+				DetailType:   aws.String(eventImageDeletedType),
+				Detail:       aws.String(string(detail)),
+			}
+		}
+
+		if err := e.put(ctx, entries); err != nil {
+			slog.Error("Failed to send image deleted events", "repository", repoName, "images", len(batch), "bus", e.bus, "error", err)
+		}
+	}
+}
+
+// put sends a batch of events, failing if any entry was rejected
+func (e *eventEmitter) put(ctx context.Context, entries []ebtypes.PutEventsRequestEntry) error {
+	resp, err := e.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+	if err != nil {
+		return err
+	}
+	if resp.FailedEntryCount > 0 {
+		for _, entry := range resp.Entries {
+			if entry.ErrorCode != nil {
+				return fmt.Errorf("%d of %d events rejected: %s: %s", resp.FailedEntryCount, len(entries), aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+			}
+		}
+		return fmt.Errorf("%d of %d events rejected", resp.FailedEntryCount, len(entries))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// mockEventBridgeClient records the events it is sent
+type mockEventBridgeClient struct {
+	inputs   []*eventbridge.PutEventsInput
+	failures int32
+}
+
+func (m *mockEventBridgeClient) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	m.inputs = append(m.inputs, params)
+	out := &eventbridge.PutEventsOutput{FailedEntryCount: m.failures}
+	for range params.Entries {
+		out.Entries = append(out.Entries, ebtypes.PutEventsResultEntry{EventId: aws.String("id")})
+	}
+	if m.failures > 0 {
+		out.Entries[0] = ebtypes.PutEventsResultEntry{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("try again")}
+	}
+	return out, nil
+}
+
+// TestImagesDeletedEvents tests sending an event per deleted image
+func TestImagesDeletedEvents(t *testing.T) {
+	pushedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var images []types.ImageDetail
+	for i := 0; i < 25; i++ {
+		images = append(images, types.ImageDetail{
+			RegistryId:       aws.String("123456789012"),
+			ImageDigest:      aws.String(fmt.Sprintf("sha256:%03d", i)),
+			ImageTags:        []string{fmt.Sprintf("v%d", i)},
+			ImagePushedAt:    aws.Time(pushedAt),
+			ImageSizeInBytes: aws.Int64(2048),
+		})
+	}
+
+	client := &mockEventBridgeClient{}
+	emitter := &eventEmitter{client: client, bus: "cleanup"}
+	emitter.imagesDeleted(context.Background(), Config{region: "eu-west-1"}, "team/api", images)
+
+	if len(client.inputs) != 3 || len(client.inputs[2].Entries) != 5 {
+		t.Fatalf("Expected the events sent in batches of 10, got %d calls", len(client.inputs))
+	}
+	entry := client.inputs[0].Entries[1]
+	if aws.ToString(entry.EventBusName) != "cleanup" || aws.ToString(entry.Source) != eventSource || aws.ToString(entry.DetailType) != eventImageDeletedType {
+		t.Errorf("Unexpected event envelope: %+v", entry)
+	}
+	var detail ImageDeletedEvent
+	if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail); err != nil {
+		t.Fatalf("Expected a JSON detail, got %v", err)
+	}
+	expected := ImageDeletedEvent{RegistryID: "123456789012", Region: "eu-west-1", Repository: "team/api", Digest: "sha256:001", Tags: []string{"v1"}, PushedAt: pushedAt, SizeBytes: 2048}
+	if fmt.Sprint(detail) != fmt.Sprint(expected) {
+		t.Errorf("Expected %+v, got %+v", expected, detail)
+	}
+
+	// Rejected events are logged, and the next batches are still sent
+	client = &mockEventBridgeClient{failures: 1}
+	(&eventEmitter{client: client, bus: "cleanup"}).imagesDeleted(context.Background(), Config{}, "team/api", images)
+	if len(client.inputs) != 3 {
+		t.Errorf("Expected every batch sent despite rejections, got %d calls", len(client.inputs))
+	}
+
+	// No bus, no events
+	var none *eventEmitter
+	none.imagesDeleted(context.Background(), Config{}, "team/api", images)
+}
+
+// TestDeleteImagesEvents tests that only the images ECR deleted are announced
+func TestDeleteImagesEvents(t *testing.T) {
+	client := &mockEventBridgeClient{}
+	ecrClient := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{Failures: []types.ImageFailure{{
+		ImageId:       &types.ImageIdentifier{ImageDigest: aws.String("sha256:bbb")},
+		FailureReason: aws.String("not found"),
+	}}}}
+	images := []types.ImageDetail{{ImageDigest: aws.String("sha256:aaa")}, {ImageDigest: aws.String("sha256:bbb")}}
+
+	cfg := Config{events: &eventEmitter{client: client, bus: "default"}}
+	if _, err := deleteImages(context.Background(), ecrClient, "api", images, cfg); !errors.Is(err, errImagesNotDeleted) {
+		t.Fatalf("Expected the failed image reported, got %v", err)
+	}
+	if len(client.inputs) != 1 || len(client.inputs[0].Entries) != 1 {
+		t.Fatalf("Expected one event, got %v", client.inputs)
+	}
+	var detail ImageDeletedEvent
+	json.Unmarshal([]byte(aws.ToString(client.inputs[0].Entries[0].Detail)), &detail)
+	if detail.Digest != "sha256:aaa" {
+		t.Errorf("Expected the deleted image announced, got %s", detail.Digest)
+	}
+}
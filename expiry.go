@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -honor-expiry-labels, which deletes images past the
+// expiry they declare themselves, whatever the age limit and -max-images
+// say. The expiry is read from the manifest's annotations or, failing
+// that, from the labels of the image config, which takes a BatchGetImage
+// call per 100 images and a download of each image's config blob. Quay's
+// quay.expires-after label (e.g. 12h, 2d or 4w after the push) and the
+// -expiry-label label, which also takes an absolute time, are honored. The
+// keep-list, protected tags, in-use protection, -keep-newest and -min-keep
+// still apply.
+
+// defaultExpiryLabel is the label -expiry-label names by default
+const defaultExpiryLabel = "ecr-cleanup.expires"
+
+// quayExpiryLabel is the label Quay reads an image's expiry from
+const quayExpiryLabel = "quay.expires-after"
+
+// maxImageConfigSize caps the size of the image configs read for their
+// labels
+const maxImageConfigSize = 4 << 20
+
+// ImageConfigClient defines the ECR operations needed to read the labels
+// of images
+type ImageConfigClient interface {
+	BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	GetDownloadUrlForLayer(ctx context.Context, params *ecr.GetDownloadUrlForLayerInput, optFns ...func(*ecr.Options)) (*ecr.GetDownloadUrlForLayerOutput, error)
+}
+
+// expiryReader reads the expiry labels of the images of a region
+type expiryReader struct {
+	images ImageConfigClient
+	http   aws.HTTPClient // downloads image configs from their presigned URLs
+	label  string
+}
+
+// newExpiryReader creates the reader of the images of a region. The HTTP
+// client of the AWS config is used, so -proxy-url and -ca-bundle apply.
+func newExpiryReader(images ImageConfigClient, httpClient aws.HTTPClient, label string) *expiryReader {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &expiryReader{images: images, http: httpClient, label: label}
+}
+
+// expired returns the images past their expiry. Images whose labels can't
+// be read are kept.
+func (r *expiryReader) expired(ctx context.Context, repoName string, images []types.ImageDetail, now time.Time) []types.ImageDetail {
+	if r == nil || len(images) == 0 {
+		return nil
+	}
+
+	details := make(map[string]types.ImageDetail, len(images))
+	ids := make([]types.ImageIdentifier, 0, len(images))
+	for _, img := range images {
+		details[aws.ToString(img.ImageDigest)] = img
+		ids = append(ids, types.ImageIdentifier{ImageDigest: img.ImageDigest})
+	}
+
+	var expired []types.ImageDetail
+	for i := 0; i < len(ids); i += describeImagesBatchSize {
+		resp, err := r.images.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			RepositoryName:     aws.String(repoName),
+			ImageIds:           ids[i:min(i+describeImagesBatchSize, len(ids))],
+			AcceptedMediaTypes: []string{mediaTypeDockerManifest, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex},
+		})
+		if err != nil {
+			slog.Warn("Failed to read image manifests; their expiry labels are ignored", "repository", repoName, "error", err)
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, image := range resp.Images {
+			digest := aws.ToString(image.ImageId.ImageDigest)
+			if seen[digest] {
+				continue
+			}
+			seen[digest] = true
+			img := details[digest]
+
+			labels, err := r.labels(ctx, repoName, aws.ToString(image.ImageManifest))
+			if err != nil {
+				slog.Warn("Failed to read image labels; its expiry is ignored", "repository", repoName, "digest", digest, "error", err)
+				continue
+			}
+			expiry, label, ok := r.expiry(labels, aws.ToTime(img.ImagePushedAt))
+			if !ok || now.Before(expiry) {
+				continue
+			}
+			slog.Info("Image is past its expiry", "repository", repoName, "digest", digest, "label", label, "expired_at", expiry.UTC().Format(time.RFC3339))
+			expired = append(expired, img)
+		}
+	}
+	return expired
+}
+
+// labels returns the annotations of a manifest, or the labels of its image
+// config when it has no expiry annotation
+func (r *expiryReader) labels(ctx context.Context, repoName, body string) (map[string]string, error) {
+	var m struct {
+		Config      *descriptor       `json:"config"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Annotations[r.label] != "" || m.Annotations[quayExpiryLabel] != "" {
+		return m.Annotations, nil
+	}
+	// Indexes and artifacts have no image config to read labels from
+	if m.Config == nil || !imageConfigMediaTypes[m.Config.MediaType] {
+		return m.Annotations, nil
+	}
+
+	resp, err := r.images.GetDownloadUrlForLayer(ctx, &ecr.GetDownloadUrlForLayerInput{
+		RepositoryName: aws.String(repoName),
+		LayerDigest:    aws.String(m.Config.Digest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the image config URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, aws.ToString(resp.DownloadUrl), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := r.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the image config: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download the image config: %s", httpResp.Status)
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, maxImageConfigSize)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid image config: %w", err)
+	}
+	return config.Config.Labels, nil
+}
+
+// expiry returns when an image with the given labels expires, and the
+// label that says so
+func (r *expiryReader) expiry(labels map[string]string, pushed time.Time) (time.Time, string, bool) {
+	for _, label := range []string{r.label, quayExpiryLabel} {
+		value := strings.TrimSpace(labels[label])
+		if value == "" {
+			continue
+		}
+		if expiry, ok := parseExpiry(value, pushed); ok {
+			return expiry, label, true
+		}
+		slog.Warn("Ignoring invalid expiry label", "label", label, "value", value)
+	}
+	return time.Time{}, "", false
+}
+
+// parseExpiry parses an expiry label: an RFC 3339 time or date, or a
+// duration after the push such as 12h, 45d12h or Quay's 4w
+func parseExpiry(value string, pushed time.Time) (time.Time, bool) {
+	if expiry, err := time.Parse(time.RFC3339, value); err == nil {
+		return expiry, true
+	}
+	if expiry, err := time.Parse(time.DateOnly, value); err == nil {
+		return expiry, true
+	}
+	// Without a push time, there is nothing to count from
+	if pushed.IsZero() {
+		return time.Time{}, false
+	}
+	if weeks, ok := strings.CutSuffix(value, "w"); ok {
+		n, err := strconv.Atoi(weeks)
+		if err != nil || n <= 0 {
+			return time.Time{}, false
+		}
+		return pushed.AddDate(0, 0, 7*n), true
+	}
+	d, err := parseAgeDuration(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return pushed.Add(d), true
+}
+
+// withExpired adds the expired images the retention policy didn't select
+func withExpired(selected, expired []types.ImageDetail) []types.ImageDetail {
+	if len(expired) == 0 {
+		return selected
+	}
+	digests := make(map[string]bool, len(selected))
+	for _, img := range selected {
+		digests[aws.ToString(img.ImageDigest)] = true
+	}
+	for _, img := range expired {
+		if !digests[aws.ToString(img.ImageDigest)] {
+			selected = append(selected, img)
+		}
+	}
+	return selected
+}
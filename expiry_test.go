@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// mockImageConfigClient serves manifests, and the configs they reference
+// from a download server
+type mockImageConfigClient struct {
+	manifests  map[string]string // by image digest
+	server     string
+	downloads  int
+	batchCalls int
+}
+
+func (m *mockImageConfigClient) BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	m.batchCalls++
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range params.ImageIds {
+		if body, ok := m.manifests[aws.ToString(id.ImageDigest)]; ok {
+			out.Images = append(out.Images, types.Image{ImageId: &types.ImageIdentifier{ImageDigest: id.ImageDigest}, ImageManifest: aws.String(body)})
+		}
+	}
+	return out, nil
+}
+
+func (m *mockImageConfigClient) GetDownloadUrlForLayer(ctx context.Context, params *ecr.GetDownloadUrlForLayerInput, optFns ...func(*ecr.Options)) (*ecr.GetDownloadUrlForLayerOutput, error) {
+	m.downloads++
+	return &ecr.GetDownloadUrlForLayerOutput{DownloadUrl: aws.String(m.server + "/" + aws.ToString(params.LayerDigest))}, nil
+}
+
+// imageManifest returns an image manifest referencing the given config
+func imageManifest(configDigest string) string {
+	return `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` + configDigest + `"}}`
+}
+
+// newConfigServer serves image configs by digest
+func newConfigServer(t *testing.T, configs map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, ok := configs[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(config))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestParseExpiry tests absolute and relative expiry labels
+func TestParseExpiry(t *testing.T) {
+	pushed := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2024-07-01T12:00:00Z": time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC),
+		"2024-07-01":           time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		"12h":                  pushed.Add(12 * time.Hour),
+		"30d":                  pushed.AddDate(0, 0, 30),
+		"2w":                   pushed.AddDate(0, 0, 14),
+	}
+	for value, want := range tests {
+		if got, ok := parseExpiry(value, pushed); !ok || !got.Equal(want) {
+			t.Errorf("parseExpiry(%q) = %v, %v; expected %v", value, got, ok, want)
+		}
+	}
+
+	for _, value := range []string{"soon", "0w", "-1h", "w"} {
+		if _, ok := parseExpiry(value, pushed); ok {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+	if _, ok := parseExpiry("30d", time.Time{}); ok {
+		t.Error("Expected a relative expiry without a push time to be rejected")
+	}
+}
+
+// TestExpiryReader tests reading expiry labels from annotations and image
+// configs
+func TestExpiryReader(t *testing.T) {
+	now := time.Now()
+	server := newConfigServer(t, map[string]string{
+		"sha256:c-quay":   `{"config":{"Labels":{"quay.expires-after":"1w"}}}`,
+		"sha256:c-future": `{"config":{"Labels":{"ecr-cleanup.expires":"2999-01-01"}}}`,
+		"sha256:c-none":   `{"config":{"Labels":{"maintainer":"ops"}}}`,
+	})
+	client := &mockImageConfigClient{server: server.URL, manifests: map[string]string{
+		"sha256:annotated": `{"schemaVersion":2,"annotations":{"ecr-cleanup.expires":"2024-01-01T00:00:00Z"},"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:c-none"}}`,
+		"sha256:quay":      imageManifest("sha256:c-quay"),
+		"sha256:future":    imageManifest("sha256:c-future"),
+		"sha256:plain":     imageManifest("sha256:c-none"),
+		"sha256:missing":   imageManifest("sha256:c-missing"),
+		"sha256:index":     `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`,
+	}}
+
+	var images []types.ImageDetail
+	for _, digest := range []string{"sha256:annotated", "sha256:quay", "sha256:future", "sha256:plain", "sha256:missing", "sha256:index"} {
+		images = append(images, types.ImageDetail{ImageDigest: aws.String(digest), ImagePushedAt: aws.Time(now.AddDate(0, 0, -10))})
+	}
+
+	reader := newExpiryReader(client, nil, defaultExpiryLabel)
+	expired := reader.expired(context.Background(), "repo", images, now)
+	if got := strings.Join(digestsOf(expired), ","); got != "sha256:annotated,sha256:quay" {
+		t.Errorf("Expected the annotated and Quay-labeled images to be expired, got %s", got)
+	}
+	// The annotation makes reading the config unnecessary
+	if client.downloads != 4 {
+		t.Errorf("Expected 4 config downloads, got %d", client.downloads)
+	}
+
+	var none *expiryReader
+	if expired := none.expired(context.Background(), "repo", images, now); expired != nil {
+		t.Errorf("Expected a nil reader to expire nothing, got %v", digestsOf(expired))
+	}
+}
+
+// TestSelectExpiredImages tests deleting images past their expiry that the
+// age limit keeps
+func TestSelectExpiredImages(t *testing.T) {
+	// Images are 30 to 32 days old, newer than the age limit
+	client := newGuardrailClient(1, 3)
+	labels := &mockImageConfigClient{manifests: map[string]string{
+		"sha256:001": `{"schemaVersion":2,"annotations":{"release.expires":"7d"}}`,
+		"sha256:002": `{"schemaVersion":2,"annotations":{"release.expires":"2999-01-01"}}`,
+	}}
+
+	cfg := Config{Days: 60, HonorExpiryLabels: true, expiry: newExpiryReader(labels, nil, "release.expires")}
+	_, toDelete, err := selectRepositoryImages(context.Background(), client, "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := strings.Join(digestsOf(toDelete), ","); got != "sha256:001" {
+		t.Errorf("Expected only the expired image, got %s", got)
+	}
+	if labels.batchCalls != 1 {
+		t.Errorf("Expected the labels of the page to be read at once, got %d calls", labels.batchCalls)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// This file contains the exporter command, which keeps the process up and
+// scans the registry every -exporter-interval without deleting anything,
+// serving what each repository holds on -metrics-addr so dashboards can
+// follow the registry's hygiene between cleanups. A failed scan keeps the
+// repository gauges of the last successful one and is reported by
+// ecr_cleanup_exporter_last_scan_success.
+
+// defaultExporterInterval is the default time between two scans of the
+// exporter command
+const defaultExporterInterval = 15 * time.Minute
+
+// exporterScan is the state of the exporter after a scan
+type exporterScan struct {
+	Region   string
+	Finished time.Time
+	Duration time.Duration
+	Err      error
+
+	// Inventory is the result of the last successful scan, taken at
+	// ScannedAt
+	Inventory []RepositoryInventory
+	ScannedAt time.Time
+}
+
+// runExporter runs the exporter command until the process receives SIGINT
+// or SIGTERM
+func runExporter(config Config) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error starting exporter", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+	if config.Public {
+		awsConfig.Region = publicRegion
+		config.SkipListImages = true
+	}
+
+	if _, err := startMetricsServer(config.MetricsAddr); err != nil {
+		slog.Error("Error starting metrics server", "error", err)
+		return 1
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	runExporterLoop(ctx, config, awsConfig.Region, lastRunMetrics, func(ctx context.Context) ([]RepositoryInventory, error) {
+		return takeInventory(ctx, client, config)
+	})
+	return 0
+}
+
+// runExporterLoop scans the registry, renders the metrics into store and
+// waits -exporter-interval, until ctx is cancelled. Scans never overlap:
+// the interval starts when the previous scan finishes.
+func runExporterLoop(ctx context.Context, config Config, region string, store *metricsStore, scan func(context.Context) ([]RepositoryInventory, error)) {
+	state := exporterScan{Region: region}
+	for {
+		start := config.now()
+		inventory, err := scan(ctx)
+		if ctx.Err() != nil {
+			slog.Info("Exporter stopped")
+			return
+		}
+
+		state.Finished = config.now()
+		state.Duration = state.Finished.Sub(start)
+		state.Err = err
+		if err != nil {
+			slog.Error("Error scanning registry", "error", err)
+		} else {
+			state.Inventory = inventory
+			state.ScannedAt = state.Finished
+			slog.Info("Scanned registry", "repositories", len(inventory), "duration", state.Duration.Round(time.Millisecond).String())
+		}
+		store.set(renderExporterMetrics(state))
+
+		if err := sleepContext(ctx, config.ExporterInterval); err != nil {
+			slog.Info("Exporter stopped")
+			return
+		}
+	}
+}
+
+// renderExporterMetrics renders the exporter's state in the Prometheus text
+// format. Image ages are as of the last successful scan.
+func renderExporterMetrics(state exporterScan) string {
+	w := &metricsWriter{}
+
+	w.gauge("ecr_cleanup_exporter_last_scan_timestamp_seconds", "Unix time the last scan finished.", nil, float64(state.Finished.Unix()))
+	w.gauge("ecr_cleanup_exporter_last_scan_success", "Whether the last scan succeeded.", nil, boolValue(state.Err == nil))
+	w.gauge("ecr_cleanup_exporter_last_scan_duration_seconds", "Duration of the last scan.", nil, state.Duration.Seconds())
+	if state.ScannedAt.IsZero() {
+		return w.b.String()
+	}
+	w.gauge("ecr_cleanup_exporter_last_success_timestamp_seconds", "Unix time the last successful scan finished.", nil, float64(state.ScannedAt.Unix()))
+	w.gauge("ecr_cleanup_exporter_repositories", "Repositories found by the last successful scan.", nil, float64(len(state.Inventory)))
+
+	for _, inv := range state.Inventory {
+		labels := map[string]string{"repository": inv.Name}
+		if state.Region != "" {
+			labels["region"] = state.Region
+		}
+
+		w.gauge("ecr_cleanup_repository_images", "Images in the repository.", labels, float64(inv.Images))
+		w.gauge("ecr_cleanup_repository_size_bytes", "Bytes stored in the repository.", labels, float64(inv.Size))
+		w.gauge("ecr_cleanup_repository_untagged_images", "Untagged images in the repository.", labels, float64(inv.Untagged))
+		w.gauge("ecr_cleanup_repository_untagged_size_bytes", "Bytes stored in the repository's untagged images.", labels, float64(inv.UntaggedSize))
+		if inv.OldestPush != nil {
+			w.gauge("ecr_cleanup_repository_oldest_image_age_seconds", "Age of the repository's oldest image.", labels, state.ScannedAt.Sub(*inv.OldestPush).Seconds())
+		}
+	}
+
+	return w.b.String()
+}
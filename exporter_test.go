@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunExporterLoop tests rescanning the registry, keeping the gauges of
+// the last successful scan when one fails
+func TestRunExporterLoop(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	oldest := now.Add(-48 * time.Hour)
+	inventory := []RepositoryInventory{
+		{Name: "api", Images: 4, Untagged: 1, Size: 4000, UntaggedSize: 1000, OldestPush: &oldest},
+		{Name: "empty"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &metricsStore{}
+	var bodies []string
+	scans := 0
+	runExporterLoop(ctx, Config{ExporterInterval: time.Millisecond, Clock: FixedClock(now)}, "us-east-1", store, func(ctx context.Context) ([]RepositoryInventory, error) {
+		scans++
+		store.mu.Lock()
+		bodies = append(bodies, store.body)
+		store.mu.Unlock()
+		switch scans {
+		case 1:
+			return inventory, nil
+		case 2:
+			return nil, errors.New("throttled")
+		}
+		cancel()
+		return nil, ctx.Err()
+	})
+
+	if scans != 3 {
+		t.Fatalf("Expected the loop to stop during the third scan, got %d scans", scans)
+	}
+	for _, want := range []string{
+		"ecr_cleanup_exporter_last_scan_success 1\n",
+		"ecr_cleanup_exporter_repositories 2\n",
+		`ecr_cleanup_repository_images{region="us-east-1",repository="api"} 4`,
+		`ecr_cleanup_repository_size_bytes{region="us-east-1",repository="api"} 4000`,
+		`ecr_cleanup_repository_untagged_size_bytes{region="us-east-1",repository="api"} 1000`,
+		`ecr_cleanup_repository_oldest_image_age_seconds{region="us-east-1",repository="api"} 172800`,
+		`ecr_cleanup_repository_images{region="us-east-1",repository="empty"} 0`,
+	} {
+		if !strings.Contains(bodies[1], want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, bodies[1])
+		}
+	}
+	if strings.Contains(bodies[1], `ecr_cleanup_repository_oldest_image_age_seconds{region="us-east-1",repository="empty"}`) {
+		t.Error("Expected no image age for an empty repository")
+	}
+
+	// The failed scan keeps the repositories of the previous one
+	if !strings.Contains(bodies[2], "ecr_cleanup_exporter_last_scan_success 0\n") || !strings.Contains(bodies[2], `ecr_cleanup_repository_images{region="us-east-1",repository="api"} 4`) {
+		t.Errorf("Expected the failure reported next to the last inventory, got:\n%s", bodies[2])
+	}
+}
+
+// TestRenderExporterMetricsBeforeFirstScan tests the metrics when no scan
+// succeeded yet
+func TestRenderExporterMetricsBeforeFirstScan(t *testing.T) {
+	body := renderExporterMetrics(exporterScan{Finished: time.Unix(1700000000, 0), Err: errors.New("denied")})
+	if !strings.Contains(body, "ecr_cleanup_exporter_last_scan_success 0\n") || strings.Contains(body, "ecr_cleanup_repository_") || strings.Contains(body, "ecr_cleanup_exporter_repositories") {
+		t.Errorf("Expected only the scan status, got:\n%s", body)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// This file contains how a run reports failures. The process exits with
+// 0 when everything was cleaned up, 2 when some repositories, regions or
+// accounts failed while the rest were cleaned up, and 1 when the run itself
+// failed. With -fail-on-error the first repository error aborts the run,
+// and with -max-failure-percent too many failed deletions do.
+
+// minBreakerAttempts is how many image deletions a run attempts before
+// -max-failure-percent can abort it, so that a single image that can't be
+// deleted doesn't
+const minBreakerAttempts = 20
+
+// exitCode returns the exit code of a run that finished: 0 when nothing
+// failed, 2 when part of it did
+func exitCode(summary CleanupSummary) int {
+	if len(summary.failedRepositories()) > 0 || len(summary.Failures) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// runAbort stops a run at the first repository error when -fail-on-error
+// is set. Repositories already in flight finish; later ones are skipped.
+type runAbort struct {
+	enabled bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// newRunAbort returns the abort state of a run
+func newRunAbort(cfg Config) *runAbort {
+	return &runAbort{enabled: cfg.FailOnError}
+}
+
+// fail records a repository error, aborting the run if enabled
+func (a *runAbort) fail(repoName string, err error) {
+	if !a.enabled {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err == nil {
+		a.err = fmt.Errorf("aborted after repository %s failed (-fail-on-error): %w", repoName, err)
+	}
+}
+
+// aborted reports whether the run should stop
+func (a *runAbort) aborted() bool {
+	return a.error() != nil
+}
+
+// error returns the error that aborted the run, if any
+func (a *runAbort) error() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// failureBreaker aborts a run once more than -max-failure-percent of its
+// image deletions failed, across every account and region. That many
+// failures usually mean missing permissions or a repository policy that
+// denies deletes, and going on only burns API quota. A nil failureBreaker
+// never trips, so callers don't need to check whether the option is set.
+type failureBreaker struct {
+	maxPercent int
+
+	mu        sync.Mutex
+	attempted int
+	failed    int
+	tripped   error
+}
+
+// newFailureBreaker returns the breaker of a run, or nil without
+// -max-failure-percent
+func newFailureBreaker(cfg Config) *failureBreaker {
+	if cfg.MaxFailurePercent <= 0 {
+		return nil
+	}
+	return &failureBreaker{maxPercent: cfg.MaxFailurePercent}
+}
+
+// record counts attempted image deletions and how many of them failed
+func (b *failureBreaker) record(attempted, failed int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attempted += attempted
+	b.failed += failed
+	if b.tripped == nil && b.attempted >= minBreakerAttempts && exceedsPercent(b.failed, b.attempted, b.maxPercent) {
+		b.tripped = fmt.Errorf("aborted after %d of %d image deletions failed: more than -max-failure-percent %d%% (check the permissions and repository policies)", b.failed, b.attempted, b.maxPercent)
+		slog.Error("Too many deletions failed, aborting the run", "failed", b.failed, "attempted", b.attempted, "max_failure_percent", b.maxPercent)
+	}
+}
+
+// err returns the error that aborted the run, if the breaker tripped
+func (b *failureBreaker) err() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestExitCode tests telling clean runs from partial failures
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		summary  CleanupSummary
+		expected int
+	}{
+		{"Clean", CleanupSummary{Repositories: []RepositorySummary{{Name: "a"}}}, 0},
+		{"Failed repository", CleanupSummary{Repositories: []RepositorySummary{{Name: "a"}, {Name: "b", Error: "access denied"}}}, 2},
+		{"Failed region", CleanupSummary{Failures: []string{"region eu-west-1: access denied"}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.summary); got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestCleanupWithClientFailOnError tests aborting at the first repository error
+func TestCleanupWithClientFailOnError(t *testing.T) {
+	t.Run("Moves on by default", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		client.BatchDeleteImageError = errors.New("access denied")
+
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10}, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if client.BatchDeleteImageCalls != 3 || len(summary.failedRepositories()) != 3 {
+			t.Errorf("Expected every repository to be tried, got %d calls", client.BatchDeleteImageCalls)
+		}
+		if exitCode(summary) != 2 {
+			t.Error("Expected a partial failure")
+		}
+	})
+
+	t.Run("Deletion error", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		client.BatchDeleteImageError = errors.New("access denied")
+
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, FailOnError: true}, client)
+		if err == nil || !strings.Contains(err.Error(), "aborted after repository repo0 failed (-fail-on-error): ") {
+			t.Errorf("Expected the run to abort, got %v", err)
+		}
+		if client.BatchDeleteImageCalls != 1 {
+			t.Errorf("Expected no deletions after the first error, got %d calls", client.BatchDeleteImageCalls)
+		}
+		if summary.RepositoriesProcessed != 3 || summary.ImagesDeleted != 0 {
+			t.Errorf("Expected every scanned repository reported with nothing deleted, got %+v", summary)
+		}
+	})
+
+	t.Run("Scan error", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		client.ListImagesError = errors.New("throttled")
+
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, FailOnError: true}, client)
+		if err == nil {
+			t.Error("Expected the run to abort")
+		}
+		if client.ListImagesCalls != 1 || client.BatchDeleteImageCalls != 0 || summary.RepositoriesProcessed != 1 {
+			t.Errorf("Expected the other repositories to be skipped, got %d scans and %d processed", client.ListImagesCalls, summary.RepositoriesProcessed)
+		}
+	})
+}
+
+// TestCleanupWithClientImageFailures tests that images BatchDeleteImage
+// refused to delete fail the repository without being reported deleted
+func TestCleanupWithClientImageFailures(t *testing.T) {
+	client := newGuardrailClient(1, 3)
+	client.BatchDeleteImageOutput = &ecr.BatchDeleteImageOutput{
+		Failures: []types.ImageFailure{{
+			ImageId:       &types.ImageIdentifier{ImageDigest: aws.String("sha256:001")},
+			FailureCode:   types.ImageFailureCodeImageReferencedByManifestList,
+			FailureReason: aws.String("referenced by a manifest list"),
+		}},
+	}
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10}, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 2 || summary.SpaceFreed != 2000 {
+		t.Errorf("Expected only the 2 deleted images counted, got %d images and %d bytes", summary.ImagesDeleted, summary.SpaceFreed)
+	}
+	if failed := summary.failedRepositories(); len(failed) != 1 || !strings.Contains(failed[0].Error, "1 of 3") {
+		t.Errorf("Expected the repository failed, got %+v", summary.Repositories)
+	}
+	if exitCode(summary) != 2 {
+		t.Error("Expected a partial failure")
+	}
+}
+
+// TestFailureBreaker tests aborting a run whose deletions mostly fail
+func TestFailureBreaker(t *testing.T) {
+	if newFailureBreaker(Config{}) != nil {
+		t.Error("Expected no breaker by default")
+	}
+
+	// 4 of the 5 images of each repository can't be deleted
+	client := newGuardrailClient(10, 5)
+	for i := 0; i < 4; i++ {
+		client.BatchDeleteImageOutput.Failures = append(client.BatchDeleteImageOutput.Failures, types.ImageFailure{
+			ImageId:       &types.ImageIdentifier{ImageDigest: aws.String(fmt.Sprintf("sha256:%03d", i))},
+			FailureReason: aws.String("denied by repository policy"),
+		})
+	}
+
+	cfg := Config{Days: 10, MaxFailurePercent: 50}
+	cfg.breaker = newFailureBreaker(cfg)
+	if _, err := CleanupWithClient(context.Background(), cfg, client); err != nil {
+		t.Fatalf("Expected the repositories to be reported, got %v", err)
+	}
+	if client.BatchDeleteImageCalls != 4 {
+		t.Errorf("Expected deletions to stop after %d attempts, got %d calls", minBreakerAttempts, client.BatchDeleteImageCalls)
+	}
+	if err := cfg.breaker.err(); err == nil || !strings.Contains(err.Error(), "16 of 20 image deletions failed") {
+		t.Errorf("Expected the breaker to trip, got %v", err)
+	}
+
+	// Failures add up across the run
+	breaker := newFailureBreaker(Config{MaxFailurePercent: 10})
+	breaker.record(100, 10)
+	if breaker.err() != nil {
+		t.Error("Expected 10 of 100 failed deletions not to trip the breaker")
+	}
+	breaker.record(5, 5)
+	if breaker.err() == nil {
+		t.Error("Expected 15 of 105 failed deletions to trip the breaker")
+	}
+	breaker = newFailureBreaker(Config{MaxFailurePercent: 10})
+	breaker.record(10, 10)
+	if breaker.err() != nil {
+		t.Error("Expected the breaker not to trip before enough attempts")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the guardrails that limit the blast radius of a
+// misconfigured policy. They fail closed: a repository that would go past a
+// limit is refused as a whole rather than partially cleaned up, a registry
+// that would lose too large a share of its images is left untouched, and
+// the run fails so the refusal doesn't go unnoticed.
+
+// defaultMinKeep is the default -min-keep: a repository is never emptied,
+// so there is always an image to roll back to
+const defaultMinKeep = 1
+
+// defaultMaxDeletePercent is the default -max-delete-percent: a policy that
+// would empty a whole registry is more likely a typo than intended
+const defaultMaxDeletePercent = 90
+
+// deletionLimits enforces the -max-deletions and -max-delete-percent limits
+// across every repository, account and region of a run
+type deletionLimits struct {
+	maxTotal          int
+	maxPerRepo        int
+	maxPercent        int
+	maxPercentPerRepo int
+
+	mu                sync.Mutex
+	reserved          int
+	refused           []string
+	refusedRegistries int
+}
+
+// newDeletionLimits returns the limits configured for a run, or nil when
+// deletions are unlimited. -force lifts the percentage limits only.
+func newDeletionLimits(cfg Config) *deletionLimits {
+	limits := &deletionLimits{maxTotal: cfg.MaxDeletions, maxPerRepo: cfg.MaxDeletionsPerRepo}
+	if !cfg.Force {
+		limits.maxPercent = cfg.MaxDeletePercent
+		limits.maxPercentPerRepo = cfg.MaxDeletePercentPerRepo
+	}
+	if limits.maxTotal <= 0 && limits.maxPerRepo <= 0 && limits.maxPercent <= 0 && limits.maxPercentPerRepo <= 0 {
+		return nil
+	}
+	return limits
+}
+
+// exceedsPercent reports whether deleting n of total images goes past a
+// percentage limit
+func exceedsPercent(n, total, maxPercent int) bool {
+	return maxPercent > 0 && total > 0 && n*100 > maxPercent*total
+}
+
+// reserve claims n deletions of a repository's total images, or refuses
+// them when they would exceed a limit. Once the run's limit has been hit,
+// every later repository is refused too.
+func (l *deletionLimits) reserve(repoName string, n, total int) error {
+	if l == nil || n == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	switch {
+	case exceedsPercent(n, total, l.maxPercentPerRepo):
+		err = fmt.Errorf("refusing to delete %d of %d images from %s: more than -max-delete-percent-per-repo %d%% (use -force to delete anyway)", n, total, repoName, l.maxPercentPerRepo)
+	case l.maxPerRepo > 0 && n > l.maxPerRepo:
+		err = fmt.Errorf("refusing to delete %d images from %s: more than -max-deletions-per-repo %d", n, repoName, l.maxPerRepo)
+	case l.maxTotal > 0 && l.reserved+n > l.maxTotal:
+		// Leave the budget exhausted so smaller repositories can't slip in
+		l.reserved = l.maxTotal
+		err = fmt.Errorf("refusing to delete %d images from %s: the run would delete more than -max-deletions %d", n, repoName, l.maxTotal)
+	default:
+		l.reserved += n
+		return nil
+	}
+
+	l.refused = append(l.refused, repoName)
+	return err
+}
+
+// err returns an error describing the refused repositories, if any
+func (l *deletionLimits) err() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.refusedRegistries > 0:
+		return fmt.Errorf("deletion limits reached: %d registries were refused (see the errors above)", l.refusedRegistries)
+	case len(l.refused) > 0:
+		return fmt.Errorf("deletion limits reached: %d repositories were refused (see the repository errors)", len(l.refused))
+	}
+	return nil
+}
+
+// checkRegistry refuses a registry, before anything in it is deleted, when
+// its repositories together would lose more than -max-delete-percent of
+// their images. Repositories that were refused don't count.
+func (l *deletionLimits) checkRegistry(n, total int) error {
+	if l == nil || !exceedsPercent(n, total, l.maxPercent) {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refusedRegistries++
+	return fmt.Errorf("refusing to delete %d of %d images from the registry: more than -max-delete-percent %d%% (use -force to delete anyway)", n, total, l.maxPercent)
+}
+
+// keepNewestImage spares the repository's most recent image, so a dormant
+// service keeps the image it last shipped
+func keepNewestImage(repoName string, toDelete, images []types.ImageDetail) []types.ImageDetail {
+	var newest types.ImageDetail
+	for _, img := range images {
+		if img.ImagePushedAt != nil && (newest.ImagePushedAt == nil || img.ImagePushedAt.After(*newest.ImagePushedAt)) {
+			newest = img
+		}
+	}
+	if newest.ImagePushedAt == nil {
+		return toDelete
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range toDelete {
+		if aws.ToString(img.ImageDigest) == aws.ToString(newest.ImageDigest) {
+			slog.Info("Keeping newest image", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
+
+// keepMinimum spares the newest of the selected images so the repository
+// keeps at least minKeep of its total images, whatever selected them.
+// Unlike the limits it never refuses a repository: keeping a few more
+// images is always safe.
+func keepMinimum(repoName string, toDelete []types.ImageDetail, total, minKeep int) []types.ImageDetail {
+	spare := minKeep - (total - len(toDelete))
+	if spare <= 0 {
+		return toDelete
+	}
+	if spare > len(toDelete) {
+		spare = len(toDelete)
+	}
+
+	sortImagesByPushedTime(toDelete)
+	for _, img := range toDelete[:spare] {
+		slog.Info("Keeping image to stay above -min-keep", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+	}
+	return toDelete[spare:]
+}
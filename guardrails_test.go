@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// newGuardrailClient returns a mock client with the given repositories,
+// each holding the same old images
+func newGuardrailClient(repoCount, imageCount int) *MockECRClient {
+	var repos []types.Repository
+	for i := 0; i < repoCount; i++ {
+		repos = append(repos, types.Repository{RepositoryName: aws.String(fmt.Sprintf("repo%d", i))})
+	}
+
+	var ids []types.ImageIdentifier
+	var details []types.ImageDetail
+	for i := 0; i < imageCount; i++ {
+		digest := fmt.Sprintf("sha256:%03d", i)
+		ids = append(ids, types.ImageIdentifier{ImageDigest: aws.String(digest)})
+		details = append(details, types.ImageDetail{
+			ImageDigest:      aws.String(digest),
+			ImagePushedAt:    aws.Time(time.Now().AddDate(0, 0, -30-i)),
+			ImageSizeInBytes: aws.Int64(1000),
+		})
+	}
+
+	return &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: repos},
+		ListImagesOutput:           &ecr.ListImagesOutput{ImageIds: ids},
+		DescribeImagesOutput:       &ecr.DescribeImagesOutput{ImageDetails: details},
+		BatchDeleteImageOutput:     &ecr.BatchDeleteImageOutput{},
+	}
+}
+
+// TestDeletionLimitsReserve tests claiming deletions against the limits
+func TestDeletionLimitsReserve(t *testing.T) {
+	if newDeletionLimits(Config{}) != nil {
+		t.Error("Expected no limits by default")
+	}
+
+	var unlimited *deletionLimits
+	if err := unlimited.reserve("repo", 1000, 1000); err != nil || unlimited.err() != nil {
+		t.Errorf("Expected nil limits to allow everything, got %v", err)
+	}
+
+	limits := newDeletionLimits(Config{MaxDeletions: 10, MaxDeletionsPerRepo: 6})
+	if err := limits.reserve("a", 6, 100); err != nil {
+		t.Errorf("Expected 6 deletions to be allowed, got %v", err)
+	}
+	if err := limits.reserve("b", 7, 100); err == nil || !strings.Contains(err.Error(), "-max-deletions-per-repo 6") {
+		t.Errorf("Expected the per-repository limit, got %v", err)
+	}
+	if err := limits.reserve("c", 5, 100); err == nil || !strings.Contains(err.Error(), "-max-deletions 10") {
+		t.Errorf("Expected the run limit, got %v", err)
+	}
+	// Once the run limit is hit, nothing else is deleted
+	if err := limits.reserve("d", 1, 100); err == nil {
+		t.Error("Expected later repositories to be refused")
+	}
+
+	if err := limits.err(); err == nil || !strings.Contains(err.Error(), "3 repositories were refused") {
+		t.Errorf("Expected the refusals to fail the run, got %v", err)
+	}
+}
+
+// TestCleanupWithClientDeletionLimits tests that limited runs fail closed
+func TestCleanupWithClientDeletionLimits(t *testing.T) {
+	t.Run("Per repository", func(t *testing.T) {
+		client := newGuardrailClient(2, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletionsPerRepo: 4}, client)
+		if err == nil || !strings.Contains(err.Error(), "deletion limits reached") {
+			t.Errorf("Expected the run to fail, got %v", err)
+		}
+		if client.BatchDeleteImageCalls != 0 || summary.ImagesDeleted != 0 {
+			t.Errorf("Expected nothing deleted, got %d calls and %d images", client.BatchDeleteImageCalls, summary.ImagesDeleted)
+		}
+		for _, repo := range summary.Repositories {
+			if !strings.Contains(repo.Error, "more than -max-deletions-per-repo 4") {
+				t.Errorf("Expected %s to be refused, got %q", repo.Name, repo.Error)
+			}
+		}
+	})
+
+	t.Run("Per run", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletions: 12}, client)
+		if err == nil {
+			t.Error("Expected the run to fail")
+		}
+		if summary.ImagesDeleted != 10 || len(summary.failedRepositories()) != 1 {
+			t.Errorf("Expected two repositories cleaned and one refused, got %d images and %d failures", summary.ImagesDeleted, len(summary.failedRepositories()))
+		}
+	})
+
+	t.Run("Within the limits", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletions: 15, MaxDeletionsPerRepo: 5}, client)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if summary.ImagesDeleted != 15 {
+			t.Errorf("Expected 15 images deleted, got %d", summary.ImagesDeleted)
+		}
+	})
+}
+
+// TestDeletionLimitsPercent tests the percentage limits and -force
+func TestDeletionLimitsPercent(t *testing.T) {
+	limits := newDeletionLimits(Config{MaxDeletePercent: 90, MaxDeletePercentPerRepo: 50})
+	if err := limits.reserve("a", 5, 10); err != nil {
+		t.Errorf("Expected half the images to be allowed, got %v", err)
+	}
+	if err := limits.reserve("b", 6, 10); err == nil || !strings.Contains(err.Error(), "6 of 10 images from b: more than -max-delete-percent-per-repo 50%") {
+		t.Errorf("Expected the repository to be refused, got %v", err)
+	}
+	if err := limits.checkRegistry(9, 10); err != nil {
+		t.Errorf("Expected 90%% of the registry to be allowed, got %v", err)
+	}
+	if err := limits.checkRegistry(10, 10); err == nil || !strings.Contains(err.Error(), "-max-delete-percent 90%") {
+		t.Errorf("Expected the registry to be refused, got %v", err)
+	}
+	if err := limits.err(); err == nil || !strings.Contains(err.Error(), "1 registries were refused") {
+		t.Errorf("Expected the refusal to fail the run, got %v", err)
+	}
+
+	if newDeletionLimits(Config{MaxDeletePercent: 90, MaxDeletePercentPerRepo: 50, Force: true}) != nil {
+		t.Error("Expected -force to lift the percentage limits")
+	}
+}
+
+// TestCleanupWithClientDeletePercent tests that a registry is refused before
+// anything in it is deleted
+func TestCleanupWithClientDeletePercent(t *testing.T) {
+	t.Run("Registry", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletePercent: 90}, client)
+		if err == nil || !strings.Contains(err.Error(), "refusing to delete 15 of 15 images from the registry") {
+			t.Errorf("Expected the registry to be refused, got %v", err)
+		}
+		if client.BatchDeleteImageCalls != 0 || summary.ImagesDeleted != 0 {
+			t.Errorf("Expected nothing deleted, got %d calls and %d images", client.BatchDeleteImageCalls, summary.ImagesDeleted)
+		}
+	})
+
+	t.Run("Per repository", func(t *testing.T) {
+		client := newGuardrailClient(2, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletePercentPerRepo: 80}, client)
+		if err == nil || len(summary.failedRepositories()) != 2 || client.BatchDeleteImageCalls != 0 {
+			t.Errorf("Expected both repositories to be refused, got %v with %d failures", err, len(summary.failedRepositories()))
+		}
+	})
+
+	t.Run("Forced", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MaxDeletePercent: 90, MaxDeletePercentPerRepo: 80, Force: true}, client)
+		if err != nil || summary.ImagesDeleted != 15 {
+			t.Errorf("Expected every image deleted, got %d and %v", summary.ImagesDeleted, err)
+		}
+	})
+}
+
+// TestKeepMinimum tests sparing the newest selected images
+func TestKeepMinimum(t *testing.T) {
+	images := newGuardrailClient(1, 5).DescribeImagesOutput.ImageDetails
+
+	tests := []struct {
+		name     string
+		total    int
+		minKeep  int
+		expected []string
+	}{
+		{"Emptying allowed", 5, 0, []string{"sha256:000", "sha256:001", "sha256:002", "sha256:003", "sha256:004"}},
+		{"Never empty", 5, 1, []string{"sha256:001", "sha256:002", "sha256:003", "sha256:004"}},
+		{"Floor of three", 5, 3, []string{"sha256:003", "sha256:004"}},
+		{"Other images remain", 8, 3, []string{"sha256:000", "sha256:001", "sha256:002", "sha256:003", "sha256:004"}},
+		{"Floor above total", 5, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toDelete := append([]types.ImageDetail(nil), images...)
+			var got []string
+			for _, img := range keepMinimum("repo", toDelete, tt.total, tt.minKeep) {
+				got = append(got, aws.ToString(img.ImageDigest))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestCleanupWithClientMinKeep tests that a stale repository is not emptied
+func TestCleanupWithClientMinKeep(t *testing.T) {
+	client := newGuardrailClient(2, 5)
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MinKeep: 2}, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 6 {
+		t.Errorf("Expected 3 images deleted from each repository, got %d", summary.ImagesDeleted)
+	}
+}
+
+// TestKeepNewestImage tests that the most recent image is never deleted
+func TestKeepNewestImage(t *testing.T) {
+	images := newGuardrailClient(1, 3).DescribeImagesOutput.ImageDetails
+
+	// The newest image is spared even when older images remain
+	toDelete := keepNewestImage("repo", []types.ImageDetail{images[0], images[2]}, images)
+	if len(toDelete) != 1 || aws.ToString(toDelete[0].ImageDigest) != "sha256:002" {
+		t.Errorf("Expected only sha256:002, got %+v", toDelete)
+	}
+
+	// Nothing changes when the newest image isn't selected
+	if toDelete := keepNewestImage("repo", images[1:], images); len(toDelete) != 2 {
+		t.Errorf("Expected both older images, got %d", len(toDelete))
+	}
+}
+
+// TestCleanupWithClientKeepNewest tests the safeguard and its opt-out
+func TestCleanupWithClientKeepNewest(t *testing.T) {
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, KeepNewest: true}, newGuardrailClient(2, 5))
+	if err != nil || summary.ImagesDeleted != 8 {
+		t.Errorf("Expected 4 images deleted from each repository, got %d and %v", summary.ImagesDeleted, err)
+	}
+
+	summary, err = CleanupWithClient(context.Background(), Config{Days: 10}, newGuardrailClient(2, 5))
+	if err != nil || summary.ImagesDeleted != 10 {
+		t.Errorf("Expected every image deleted without the safeguard, got %d and %v", summary.ImagesDeleted, err)
+	}
+}
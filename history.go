@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// This file contains the run history, which keeps the summary of each run
+// under -history-s3 or in the -history-dynamodb table so the next run can
+// report the trend since: how much the registry grew, which repositories
+// grew fastest, and whether the space the cleanup reclaims keeps up with
+// that growth. The history of each -policy-name is kept apart, since jobs
+// of different policies clean up different repositories.
+//
+// Under -history-s3 each run is stored as <job>/<time>.json, and
+// <job>/latest.json is the latest one. The -history-dynamodb table needs
+// the string partition key job and sort key run_at.
+
+// historyVersion is the version of the run record schema. Fields are only
+// added within a version; renaming or removing one bumps it.
+const historyVersion = 1
+
+// defaultHistoryJob is the job of runs without a -policy-name
+const defaultHistoryJob = "default"
+
+// fastestGrowingRepositories is how many of the fastest growing
+// repositories the trend reports
+const fastestGrowingRepositories = 5
+
+// historyRecord is the summary of a run kept in the history
+type historyRecord struct {
+	Version       int       `json:"version"`
+	Job           string    `json:"job"`
+	RunAt         time.Time `json:"run_at"`
+	DryRun        bool      `json:"dry_run"`
+	ImagesScanned int       `json:"images_scanned"`
+	ImagesDeleted int       `json:"images_deleted"`
+	SpaceScanned  int64     `json:"space_scanned_bytes"` // stored when the run started
+	SpaceFreed    int64     `json:"space_freed_bytes"`
+	StoredBytes   int64     `json:"stored_bytes"` // stored when the run finished
+
+	// Bytes stored in each repository when the run finished, by qualified
+	// name
+	Repositories map[string]int64 `json:"repositories"`
+}
+
+// newHistoryRecord sums up a run for the history. A dry run frees nothing, so
+// what it scanned is still stored.
+func newHistoryRecord(summary CleanupSummary, cfg Config, now time.Time) historyRecord {
+	record := historyRecord{
+		Version:       historyVersion,
+		Job:           historyJob(cfg),
+		RunAt:         now.UTC(),
+		DryRun:        cfg.DryRun,
+		ImagesScanned: summary.ImagesScanned,
+		ImagesDeleted: summary.ImagesDeleted,
+		SpaceScanned:  summary.SpaceScanned,
+		Repositories:  make(map[string]int64),
+	}
+	if !cfg.DryRun {
+		record.SpaceFreed = summary.SpaceFreed
+	}
+	record.StoredBytes = record.SpaceScanned - record.SpaceFreed
+	for _, repo := range summary.Repositories {
+		if repo.Error != "" || repo.Deleted {
+			continue
+		}
+		stored := repo.SpaceScanned
+		if !cfg.DryRun {
+			stored -= repo.SpaceFreed
+		}
+		record.Repositories[repo.qualifiedName()] = stored
+	}
+	return record
+}
+
+// historyJob returns the job whose history a run belongs to
+func historyJob(cfg Config) string {
+	if cfg.PolicyName != "" {
+		return cfg.PolicyName
+	}
+	return defaultHistoryJob
+}
+
+// repositoryGrowth is how much a repository grew between two runs
+type repositoryGrowth struct {
+	Name   string
+	Growth int64
+}
+
+// runTrend compares a run with the previous one
+type runTrend struct {
+	Previous time.Time
+	// Growth is what was pushed between the end of the previous run and
+	// the start of this one, and Reclaimed what this run freed
+	Growth    int64
+	Reclaimed int64
+	NetChange int64
+	KeepingUp bool
+	Fastest   []repositoryGrowth
+}
+
+// compareRuns computes the trend between the previous run and this one
+func compareRuns(summary CleanupSummary, current, previous historyRecord) runTrend {
+	trend := runTrend{
+		Previous:  previous.RunAt,
+		Growth:    current.SpaceScanned - previous.StoredBytes,
+		Reclaimed: current.SpaceFreed,
+		NetChange: current.StoredBytes - previous.StoredBytes,
+	}
+	trend.KeepingUp = trend.Reclaimed >= trend.Growth
+
+	for _, repo := range summary.Repositories {
+		if repo.Error != "" {
+			continue
+		}
+		name := repo.qualifiedName()
+		if growth := repo.SpaceScanned - previous.Repositories[name]; growth > 0 {
+			trend.Fastest = append(trend.Fastest, repositoryGrowth{Name: name, Growth: growth})
+		}
+	}
+	slices.SortFunc(trend.Fastest, func(a, b repositoryGrowth) int {
+		if c := cmp.Compare(b.Growth, a.Growth); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	if len(trend.Fastest) > fastestGrowingRepositories {
+		trend.Fastest = trend.Fastest[:fastestGrowingRepositories]
+	}
+	return trend
+}
+
+// logTrend logs the trend since the previous run. A dry run reclaims
+// nothing, so it isn't expected to keep up.
+func logTrend(trend runTrend, dryRun bool) {
+	slog.Info("Trend since the last run",
+		"previous_run", trend.Previous.Format(time.RFC3339),
+		"storage_growth_mb", roundMB(trend.Growth),
+		"space_reclaimed_mb", roundMB(trend.Reclaimed),
+		"net_change_mb", roundMB(trend.NetChange),
+		"keeping_up", trend.KeepingUp)
+	if !trend.KeepingUp && !dryRun {
+		slog.Warn("The cleanup is not keeping up with the registry's growth", "storage_growth_mb", roundMB(trend.Growth), "space_reclaimed_mb", roundMB(trend.Reclaimed))
+	}
+	for _, repo := range trend.Fastest {
+		slog.Info("Fast-growing repository", "repository", repo.Name, "growth_mb", roundMB(repo.Growth))
+	}
+}
+
+// HistoryStore keeps the run history of a job
+type HistoryStore interface {
+	// Latest returns the latest run of the job, or nil when there is none
+	Latest(ctx context.Context, job string) (*historyRecord, error)
+	Save(ctx context.Context, record historyRecord) error
+}
+
+// recordHistory reports the trend since the previous run and adds this
+// one to the history, when -history-s3 or -history-dynamodb is set
+func recordHistory(summary CleanupSummary, cfg Config) error {
+	if cfg.HistoryS3 == "" && cfg.HistoryDynamoDB == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	awsConfig, err := loadRunAWSConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	var store HistoryStore
+	if cfg.HistoryS3 != "" {
+		location, err := parseS3URI(cfg.HistoryS3)
+		if err != nil {
+			return err
+		}
+		store = &s3History{client: newS3Client(awsConfig), location: location}
+	} else {
+		store = &dynamoDBHistory{client: dynamodb.NewFromConfig(awsConfig), table: cfg.HistoryDynamoDB}
+	}
+	return recordHistoryWithStore(ctx, store, summary, cfg, time.Now())
+}
+
+// recordHistoryWithStore reports the trend since the job's latest run and
+// saves this one
+func recordHistoryWithStore(ctx context.Context, store HistoryStore, summary CleanupSummary, cfg Config, now time.Time) error {
+	current := newHistoryRecord(summary, cfg, now)
+	previous, err := store.Latest(ctx, current.Job)
+	if err != nil {
+		return fmt.Errorf("failed to read the run history: %w", err)
+	}
+	if previous == nil {
+		slog.Info("No previous run in the history; the trend starts with the next run", "job", current.Job)
+	} else {
+		logTrend(compareRuns(summary, current, *previous), cfg.DryRun)
+	}
+
+	if err := store.Save(ctx, current); err != nil {
+		return fmt.Errorf("failed to save the run history: %w", err)
+	}
+	return nil
+}
+
+// S3HistoryClient defines the S3 operations needed to keep the history
+type S3HistoryClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// s3History keeps the history under an S3 prefix
+type s3History struct {
+	client   S3HistoryClient
+	location s3Location
+}
+
+// Latest implements HistoryStore
+func (h *s3History) Latest(ctx context.Context, job string) (*historyRecord, error) {
+	resp, err := h.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(h.location.Bucket),
+		Key:    aws.String(h.location.Prefix + job + "/latest.json"),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var record historyRecord
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return nil, fmt.Errorf("invalid run record: %w", err)
+	}
+	return &record, nil
+}
+
+// Save implements HistoryStore: the run is stored under its time, then as
+// the latest
+func (h *s3History) Save(ctx context.Context, record historyRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{record.RunAt.Format("20060102T150405Z"), "latest"} {
+		key := h.location.Prefix + record.Job + "/" + name + ".json"
+		_, err := h.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(h.location.Bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload s3://%s/%s: %w", h.location.Bucket, key, err)
+		}
+	}
+	slog.Info("Saved run to the history", "url", fmt.Sprintf("s3://%s/%s%s/", h.location.Bucket, h.location.Prefix, record.Job))
+	return nil
+}
+
+// DynamoDBHistoryClient defines the DynamoDB operations needed to keep the
+// history
+type DynamoDBHistoryClient interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// dynamoDBHistory keeps the history in a DynamoDB table
+type dynamoDBHistory struct {
+	client DynamoDBHistoryClient
+	table  string
+}
+
+// Latest implements HistoryStore
+func (h *dynamoDBHistory) Latest(ctx context.Context, job string) (*historyRecord, error) {
+	out, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(h.table),
+		KeyConditionExpression:    aws.String("#job = :job"),
+		ExpressionAttributeNames:  map[string]string{"#job": "job"},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{":job": &dynamodbtypes.AttributeValueMemberS{Value: job}},
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DynamoDB Query on table %s: %w", h.table, err)
+	}
+	if len(out.Items) == 0 {
+		return nil, nil
+	}
+
+	summary, _ := out.Items[0]["summary"].(*dynamodbtypes.AttributeValueMemberS)
+	if summary == nil {
+		return nil, fmt.Errorf("invalid run record: no summary")
+	}
+	var record historyRecord
+	if err := json.Unmarshal([]byte(summary.Value), &record); err != nil {
+		return nil, fmt.Errorf("invalid run record: %w", err)
+	}
+	return &record, nil
+}
+
+// Save implements HistoryStore
+func (h *dynamoDBHistory) Save(ctx context.Context, record historyRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(h.table),
+		Item: map[string]dynamodbtypes.AttributeValue{
+			"job":     &dynamodbtypes.AttributeValueMemberS{Value: record.Job},
+			"run_at":  &dynamodbtypes.AttributeValueMemberS{Value: record.RunAt.Format(time.RFC3339)},
+			"summary": &dynamodbtypes.AttributeValueMemberS{Value: string(body)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("DynamoDB PutItem on table %s: %w", h.table, err)
+	}
+	slog.Info("Saved run to the history", "table", h.table, "job", record.Job)
+	return nil
+}
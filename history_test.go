@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// testHistorySummary is a run over three repositories, where web grew the
+// most since the previous run
+func testHistorySummary() CleanupSummary {
+	return CleanupSummary{
+		ImagesScanned: 30,
+		ImagesDeleted: 6,
+		SpaceScanned:  1700,
+		SpaceFreed:    300,
+		Repositories: []RepositorySummary{
+			{Name: "api", SpaceScanned: 500, SpaceFreed: 100},
+			{Name: "web", SpaceScanned: 1000, SpaceFreed: 200},
+			{Name: "tools", SpaceScanned: 200},
+		},
+	}
+}
+
+// TestCompareRuns tests the trend between two runs
+func TestCompareRuns(t *testing.T) {
+	now := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	summary := testHistorySummary()
+	current := newHistoryRecord(summary, Config{}, now)
+	if current.StoredBytes != 1400 || current.Repositories["web"] != 800 || current.Job != defaultHistoryJob {
+		t.Fatalf("Expected 1400 bytes stored and 800 in web, got %+v", current)
+	}
+
+	previous := historyRecord{
+		RunAt:        now.Add(-24 * time.Hour),
+		StoredBytes:  1200,
+		Repositories: map[string]int64{"api": 450, "web": 600, "tools": 200},
+	}
+	trend := compareRuns(summary, current, previous)
+	if trend.Growth != 500 || trend.Reclaimed != 300 || trend.NetChange != 200 || trend.KeepingUp {
+		t.Errorf("Expected 500 bytes grown, 300 reclaimed and not keeping up, got %+v", trend)
+	}
+	if len(trend.Fastest) != 2 || trend.Fastest[0].Name != "web" || trend.Fastest[0].Growth != 400 || trend.Fastest[1].Name != "api" {
+		t.Errorf("Expected web then api as the fastest growing, got %+v", trend.Fastest)
+	}
+
+	// A dry run frees nothing, so what it scanned stays stored
+	dryRun := newHistoryRecord(summary, Config{DryRun: true, PolicyName: "nightly"}, now)
+	if dryRun.SpaceFreed != 0 || dryRun.StoredBytes != 1700 || dryRun.Repositories["web"] != 1000 || dryRun.Job != "nightly" {
+		t.Errorf("Expected nothing freed by the dry run, got %+v", dryRun)
+	}
+}
+
+// TestRecordHistoryS3 tests keeping the history under an S3 prefix
+func TestRecordHistoryS3(t *testing.T) {
+	bucket := newMockLockBucket()
+	store := &s3History{client: bucket, location: s3Location{Bucket: "history", Prefix: "ecr/"}}
+	first := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// The first run has nothing to compare with
+	if err := recordHistoryWithStore(context.Background(), store, testHistorySummary(), Config{}, first); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := bucket.objects["ecr/default/20240601T000000Z.json"]; !ok {
+		t.Errorf("Expected the run stored under its time, got %v", bucket.objects)
+	}
+
+	latest, err := store.Latest(context.Background(), defaultHistoryJob)
+	if err != nil || latest == nil {
+		t.Fatalf("Expected the latest run, got %v and %v", latest, err)
+	}
+	if !latest.RunAt.Equal(first) || latest.StoredBytes != 1400 || latest.Version != historyVersion {
+		t.Errorf("Expected the first run as the latest, got %+v", latest)
+	}
+
+	if err := recordHistoryWithStore(context.Background(), store, testHistorySummary(), Config{}, first.Add(time.Hour)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(bucket.objects) != 3 {
+		t.Errorf("Expected two runs and the latest, got %d objects", len(bucket.objects))
+	}
+
+	if latest, err := store.Latest(context.Background(), "nightly"); err != nil || latest != nil {
+		t.Errorf("Expected no history for another job, got %v and %v", latest, err)
+	}
+}
+
+// TestDynamoDBHistory tests keeping the history in a DynamoDB table
+func TestDynamoDBHistory(t *testing.T) {
+	// dynamoDBString is a string attribute value on the wire
+	type dynamoDBString struct {
+		S string `json:"S"`
+	}
+	var items []map[string]dynamoDBString
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.Query":
+			queries = append(queries, string(body))
+			out := map[string]any{"Items": []map[string]dynamoDBString{}}
+			if len(items) > 0 {
+				out["Items"] = items[len(items)-1:]
+			}
+			json.NewEncoder(w).Encode(out)
+		case "DynamoDB_20120810.PutItem":
+			var input struct {
+				TableName string
+				Item      map[string]dynamoDBString
+			}
+			if err := json.Unmarshal(body, &input); err != nil || input.TableName != "ecr-cleanup-history" {
+				t.Errorf("Invalid PutItem request: %s", body)
+			}
+			items = append(items, input.Item)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("Unexpected operation %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+	store := &dynamoDBHistory{client: dynamodb.NewFromConfig(awsConfig), table: "ecr-cleanup-history"}
+	first := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{first, first.Add(time.Hour)} {
+		if err := recordHistoryWithStore(context.Background(), store, testHistorySummary(), Config{PolicyName: "nightly"}, now); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if len(items) != 2 || items[1]["job"].S != "nightly" || items[1]["run_at"].S != "2024-06-01T01:00:00Z" {
+		t.Fatalf("Expected both runs put under their job and time, got %v", items)
+	}
+	if len(queries) != 2 || !strings.Contains(queries[0], `":job":{"S":"nightly"}`) || !strings.Contains(queries[0], `"ScanIndexForward":false`) {
+		t.Errorf("Expected the latest run of the job queried, got %v", queries)
+	}
+
+	latest, err := store.Latest(context.Background(), "nightly")
+	if err != nil || latest == nil || !latest.RunAt.Equal(first.Add(time.Hour)) || latest.Repositories["api"] != 400 {
+		t.Errorf("Expected the second run as the latest, got %+v and %v", latest, err)
+	}
+}
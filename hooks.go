@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the hook commands, which plug site-specific logic into
+// a run without forking the tool. Commands run through sh -c and receive
+// JSON on stdin.
+//
+// -pre-delete-hook runs once for each image selected for deletion and
+// vetoes its deletion by exiting non-zero, e.g. to check a deployment
+// inventory the tool doesn't know about. -post-run-hook runs once the run
+// is over with the same JSON result the webhook receives, alongside the
+// other notifications.
+
+// Bounds on how long one hook call may take
+const (
+	preDeleteHookTimeout = time.Minute
+	postRunHookTimeout   = 5 * time.Minute
+)
+
+// PreDeleteHookInput is the JSON a -pre-delete-hook command reads on stdin
+type PreDeleteHookInput struct {
+	AccountID  string    `json:"account_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	RegistryID string    `json:"registry_id,omitempty"`
+	Repository string    `json:"repository"`
+	Digest     string    `json:"digest"`
+	Tags       []string  `json:"tags,omitempty"`
+	PushedAt   time.Time `json:"pushed_at"`
+	SizeBytes  int64     `json:"size_bytes"`
+	DryRun     bool      `json:"dry_run"`
+}
+
+// runHook runs a hook command with input as JSON on stdin and returns its
+// combined output. An *exec.ExitError means the command ran and exited
+// non-zero.
+func runHook(ctx context.Context, command string, input any) ([]byte, error) {
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
+
+// applyPreDeleteHook runs -pre-delete-hook for each image selected for
+// deletion and returns the images it didn't veto. A hook that can't be run
+// fails the repository, so nothing is deleted unchecked.
+func applyPreDeleteHook(ctx context.Context, repoName string, toDelete []types.ImageDetail, cfg Config) ([]types.ImageDetail, error) {
+	if cfg.PreDeleteHook == "" {
+		return toDelete, nil
+	}
+
+	var allowed []types.ImageDetail
+	for _, img := range toDelete {
+		input := PreDeleteHookInput{
+			AccountID:  cfg.accountID,
+			Region:     cfg.region,
+			RegistryID: aws.ToString(img.RegistryId),
+			Repository: repoName,
+			Digest:     aws.ToString(img.ImageDigest),
+			Tags:       img.ImageTags,
+			PushedAt:   aws.ToTime(img.ImagePushedAt).UTC(),
+			SizeBytes:  aws.ToInt64(img.ImageSizeInBytes),
+			DryRun:     cfg.DryRun,
+		}
+
+		hookCtx, cancel := context.WithTimeout(ctx, preDeleteHookTimeout)
+		output, err := runHook(hookCtx, cfg.PreDeleteHook, input)
+		timedOut := errors.Is(hookCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		var exitErr *exec.ExitError
+		switch {
+		case timedOut:
+			return nil, fmt.Errorf("pre-delete hook timed out after %s for image %s", preDeleteHookTimeout, input.Digest)
+		case errors.As(err, &exitErr):
+			slog.Info("Keeping image vetoed by the pre-delete hook", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", input.Digest,
+				"exit_code", exitErr.ExitCode(), "output", strings.TrimSpace(string(output)))
+		case err != nil:
+			return nil, fmt.Errorf("failed to run pre-delete hook: %w", err)
+		default:
+			allowed = append(allowed, img)
+		}
+	}
+	return allowed, nil
+}
+
+// runPostRunHook runs -post-run-hook with the run's JSON result on stdin.
+// Its output is logged; a non-zero exit fails the notification.
+func runPostRunHook(summary CleanupSummary, cfg Config, runErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postRunHookTimeout)
+	defer cancel()
+
+	output, err := runHook(ctx, cfg.PostRunHook, newRunResult(summary, cfg, runErr))
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", postRunHookTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("exited with status %d: %s", exitErr.ExitCode(), strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Ran post-run hook", "output", strings.TrimSpace(string(output)))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestApplyPreDeleteHook tests vetoing deletions with the pre-delete hook
+func TestApplyPreDeleteHook(t *testing.T) {
+	dir := t.TempDir()
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))},
+		{ImageDigest: aws.String("sha256:bbb"), ImageTags: []string{"v2"}},
+	}
+
+	t.Run("Non-zero exit vetoes", func(t *testing.T) {
+		// The hook saves its input and vetoes v2
+		hook := `input=$(cat); echo "$input" > ` + dir + `/$(echo "$input" | grep -o 'sha256:[a-z]*' | tr : -).json; case "$input" in *'"v2"'*) echo "deployed in prod"; exit 3;; esac`
+		cfg := Config{PreDeleteHook: hook, DryRun: true, region: "eu-west-1"}
+
+		allowed, err := applyPreDeleteHook(context.Background(), "team/api", images, cfg)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(allowed) != 1 || *allowed[0].ImageDigest != "sha256:aaa" {
+			t.Errorf("Expected only v1 allowed, got %v", allowed)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "sha256-aaa.json"))
+		if err != nil {
+			t.Fatalf("Expected the hook to receive the image, got %v", err)
+		}
+		var input PreDeleteHookInput
+		if err := json.Unmarshal(data, &input); err != nil {
+			t.Fatalf("Expected JSON on stdin, got %q", data)
+		}
+		if input.Repository != "team/api" || input.Digest != "sha256:aaa" || input.Tags[0] != "v1" || input.Region != "eu-west-1" || !input.DryRun || input.PushedAt.Year() != 2025 {
+			t.Errorf("Unexpected hook input: %+v", input)
+		}
+	})
+
+	t.Run("No hook", func(t *testing.T) {
+		allowed, err := applyPreDeleteHook(context.Background(), "team/api", images, Config{})
+		if err != nil || len(allowed) != 2 {
+			t.Errorf("Expected every image allowed, got %v, %v", allowed, err)
+		}
+	})
+
+	t.Run("Hook that can't run fails the repository", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := applyPreDeleteHook(ctx, "team/api", images, Config{PreDeleteHook: "true"})
+		if err == nil || !strings.Contains(err.Error(), "failed to run pre-delete hook") {
+			t.Errorf("Expected the hook error, got %v", err)
+		}
+	})
+}
+
+// TestPreDeleteHookCleanup tests that vetoed images are not deleted
+func TestPreDeleteHookCleanup(t *testing.T) {
+	client := newGuardrailClient(1, 3)
+	cfg := Config{Days: 10, PreDeleteHook: `grep -q 'sha256:001' && exit 1; exit 0`}
+
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 2 {
+		t.Errorf("Expected the vetoed image kept, got %d deleted", summary.ImagesDeleted)
+	}
+	for _, id := range client.LastBatchDeleteImageInput.ImageIds {
+		if aws.ToString(id.ImageDigest) == "sha256:001" {
+			t.Error("Expected the vetoed image not to be deleted")
+		}
+	}
+}
+
+// TestRunPostRunHook tests running the post-run hook with the run summary
+func TestRunPostRunHook(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "summary.json")
+	summary := CleanupSummary{RepositoriesProcessed: 2, ImagesScanned: 10, ImagesDeleted: 4, Repositories: []RepositorySummary{
+		{Name: "api", ImagesScanned: 6, ImagesDeleted: 4},
+		{Name: "web", ImagesScanned: 4, Error: "access denied"},
+	}}
+
+	if err := sendNotifications(summary, Config{PostRunHook: "cat > " + output}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected the hook to run, got %v", err)
+	}
+	var result runResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Expected the JSON summary on stdin, got %q", data)
+	}
+	if result.Status != "succeeded" || result.ImagesDeleted != 4 || result.Failures != 1 || len(result.Repositories) != 2 {
+		t.Errorf("Unexpected summary: %+v", result)
+	}
+
+	err = sendNotifications(summary, Config{PostRunHook: "echo ticket system down; exit 2"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exited with status 2: ticket system down") {
+		t.Errorf("Expected the hook failure, got %v", err)
+	}
+}
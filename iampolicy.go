@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// This file contains the print-iam-policy command, which prints the
+// smallest IAM policy the tool needs for the features its flags enable, so
+// the role of a scheduled job can be granted exactly that. ECR and the
+// other services are granted on every resource, since the repositories
+// aren't known up front, while S3 locations and the SNS topic are granted
+// on what the flags name.
+
+// IAMPolicy is an IAM policy document
+type IAMPolicy struct {
+	Version   string               `json:"Version"`
+	Statement []IAMPolicyStatement `json:"Statement"`
+}
+
+// IAMPolicyStatement is a statement of an IAM policy document
+type IAMPolicyStatement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// iamPolicyVersion is the version of the IAM policy language
+const iamPolicyVersion = "2012-10-17"
+
+// iamGrants collects the actions of a policy by the resources they apply to
+type iamGrants struct {
+	sids      []string
+	actions   map[string][]string
+	resources map[string][]string
+}
+
+// grant adds actions on resources under a statement ID
+func (g *iamGrants) grant(sid string, resources []string, actions ...string) {
+	if g.actions == nil {
+		g.actions, g.resources = make(map[string][]string), make(map[string][]string)
+	}
+	if !slices.Contains(g.sids, sid) {
+		g.sids = append(g.sids, sid)
+	}
+	for _, action := range actions {
+		if !slices.Contains(g.actions[sid], action) {
+			g.actions[sid] = append(g.actions[sid], action)
+		}
+	}
+	for _, resource := range resources {
+		if !slices.Contains(g.resources[sid], resource) {
+			g.resources[sid] = append(g.resources[sid], resource)
+		}
+	}
+}
+
+// policy returns the policy document, with the actions of each statement
+// sorted
+func (g *iamGrants) policy() IAMPolicy {
+	policy := IAMPolicy{Version: iamPolicyVersion, Statement: []IAMPolicyStatement{}}
+	for _, sid := range g.sids {
+		actions := slices.Clone(g.actions[sid])
+		slices.Sort(actions)
+		policy.Statement = append(policy.Statement, IAMPolicyStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: g.resources[sid]})
+	}
+	return policy
+}
+
+// anyResource grants actions on every resource
+var anyResource = []string{"*"}
+
+// s3ObjectResource returns the ARN of the objects under an S3 location
+func s3ObjectResource(uri string) ([]string, error) {
+	location, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("arn:aws:s3:::%s/%s*", location.Bucket, location.Prefix)}, nil
+}
+
+// requiredIAMPolicy returns the IAM policy the configured features need
+func requiredIAMPolicy(cfg Config) (IAMPolicy, error) {
+	var g iamGrants
+	g.grant("Identity", anyResource, "sts:GetCallerIdentity")
+
+	// The cleanup itself
+	switch {
+	case cfg.Public:
+		g.grant("ECRPublic", anyResource, "ecr-public:DescribeRepositories", "ecr-public:DescribeImages")
+		if !cfg.DryRun {
+			g.grant("ECRPublic", anyResource, "ecr-public:BatchDeleteImage")
+		}
+	case cfg.ManageLifecyclePolicies:
+		g.grant("ECR", anyResource, "ecr:DescribeRepositories", "ecr:GetLifecyclePolicy")
+		if !cfg.DryRun {
+			g.grant("ECR", anyResource, "ecr:PutLifecyclePolicy")
+		}
+	default:
+		// BatchGetImage reads the manifests that tie artifacts to their image
+		g.grant("ECR", anyResource, "ecr:DescribeRepositories", "ecr:ListImages", "ecr:DescribeImages", "ecr:BatchGetImage")
+		if !cfg.DryRun && !cfg.UntagOnly {
+			g.grant("ECR", anyResource, "ecr:BatchDeleteImage")
+		}
+	}
+	if !cfg.Public && !cfg.ManageLifecyclePolicies {
+		if cfg.HonorExpiryLabels {
+			g.grant("ECR", anyResource, "ecr:GetDownloadUrlForLayer")
+		}
+		if cfg.UntagOnly || cfg.QuarantineDays > 0 {
+			g.grant("ECR", anyResource, "ecr:PutImage")
+		}
+		if cfg.DeleteEmptyRepos && !cfg.DryRun {
+			g.grant("ECR", anyResource, "ecr:DeleteRepository")
+		}
+		if usesRepositoryTags(cfg) {
+			g.grant("ECR", anyResource, "ecr:ListTagsForResource")
+		}
+		if cfg.Replication != "" && cfg.Replication != replicationIgnore {
+			g.grant("ECR", anyResource, "ecr:DescribeRegistry")
+		}
+		if handlesPullThrough(cfg) {
+			g.grant("ECR", anyResource, "ecr:DescribePullThroughCacheRules")
+		}
+		if cfg.PullActivityDays > 0 {
+			g.grant("PullActivity", anyResource, "cloudwatch:GetMetricData")
+		}
+		if cfg.ArchiveTo != "" {
+			g.grant("ECR", anyResource, "ecr:GetAuthorizationToken", "ecr:GetDownloadUrlForLayer", "ecr:BatchCheckLayerAvailability",
+				"ecr:InitiateLayerUpload", "ecr:UploadLayerPart", "ecr:CompleteLayerUpload", "ecr:PutImage", "ecr:CreateRepository")
+		}
+	}
+	if cfg.ComplianceRequireLifecyclePolicy {
+		g.grant("ECR", anyResource, "ecr:GetLifecyclePolicy")
+	}
+
+	// Accounts and regions
+	if cfg.AllRegions {
+		g.grant("Regions", anyResource, "account:ListRegions")
+	}
+	if cfg.OrgMode {
+		g.grant("Organization", anyResource, "organizations:ListAccounts", "organizations:ListTagsForResource")
+	}
+	if cfg.RoleArn != "" {
+		g.grant("AssumeRole", []string{cfg.RoleArn}, "sts:AssumeRole")
+	}
+	if cfg.AssumeRolesFile != "" {
+		roleArns, err := readRoleArns(cfg.AssumeRolesFile)
+		if err != nil {
+			return IAMPolicy{}, fmt.Errorf("failed to read role list: %w", err)
+		}
+		g.grant("AssumeRole", roleArns, "sts:AssumeRole")
+	}
+	if cfg.OrgMode {
+		g.grant("AssumeRole", []string{"arn:aws:iam::*:role/" + cfg.OrgRoleName}, "sts:AssumeRole")
+	}
+
+	// In-use protection
+	providers, err := loadInUseProviders(cfg)
+	if err != nil {
+		return IAMPolicy{}, err
+	}
+	for _, provider := range providers {
+		switch provider.(type) {
+		case appRunnerProvider:
+			g.grant("InUse", anyResource, "apprunner:ListServices", "apprunner:DescribeService")
+		case batchProvider:
+			g.grant("InUse", anyResource, "batch:DescribeJobDefinitions")
+		case ecsProvider:
+			g.grant("InUse", anyResource, "ecs:ListClusters", "ecs:ListTasks", "ecs:DescribeTasks")
+		case lambdaProvider:
+			g.grant("InUse", anyResource, "lambda:ListFunctions")
+		}
+	}
+
+	// Outputs kept in S3, notifications and findings
+	s3Locations := []struct {
+		uri     string
+		actions []string
+	}{
+		{cfg.ManifestsS3, []string{"s3:PutObject"}},
+		{cfg.ReportS3, []string{"s3:PutObject"}},
+		{cfg.LockS3, []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject"}},
+		{cfg.HistoryS3, []string{"s3:GetObject", "s3:PutObject"}},
+	}
+	for _, location := range s3Locations {
+		if location.uri == "" {
+			continue
+		}
+		resources, err := s3ObjectResource(location.uri)
+		if err != nil {
+			return IAMPolicy{}, err
+		}
+		g.grant("S3", resources, location.actions...)
+	}
+	if cfg.HistoryS3 != "" {
+		// Without it a missing object reads as access denied, not as a
+		// first run
+		location, _ := parseS3URI(cfg.HistoryS3)
+		g.grant("S3Bucket", []string{"arn:aws:s3:::" + location.Bucket}, "s3:ListBucket")
+	}
+	if cfg.LockTable != "" {
+		g.grant("Locks", []string{"arn:aws:dynamodb:*:*:table/" + cfg.LockTable}, "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem")
+	}
+	if cfg.HistoryDynamoDB != "" {
+		g.grant("History", []string{"arn:aws:dynamodb:*:*:table/" + cfg.HistoryDynamoDB}, "dynamodb:Query", "dynamodb:PutItem")
+	}
+	if cfg.SNSTopicArn != "" {
+		g.grant("Notifications", []string{cfg.SNSTopicArn}, "sns:Publish")
+	}
+	if cfg.EventBus != "" {
+		bus := cfg.EventBus
+		if !strings.HasPrefix(bus, "arn:") {
+			bus = "arn:aws:events:*:*:event-bus/" + bus
+		}
+		g.grant("Events", []string{bus}, "events:PutEvents")
+	}
+	if cfg.SecurityHub {
+		g.grant("SecurityHub", anyResource, "securityhub:BatchImportFindings")
+	}
+
+	return g.policy(), nil
+}
+
+// writeIAMPolicy writes a policy document as indented JSON
+func writeIAMPolicy(w io.Writer, policy IAMPolicy) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(policy)
+}
+
+// runPrintIAMPolicy prints the IAM policy the configured features need
+func runPrintIAMPolicy(config Config) int {
+	policy, err := requiredIAMPolicy(config)
+	if err != nil {
+		slog.Error("Error generating the IAM policy", "error", err)
+		return 1
+	}
+	if err := writeIAMPolicy(os.Stdout, policy); err != nil {
+		slog.Error("Error writing the IAM policy", "error", err)
+		return 1
+	}
+	return 0
+}
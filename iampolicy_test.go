@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// TestRequiredIAMPolicy tests granting the actions of the enabled features
+func TestRequiredIAMPolicy(t *testing.T) {
+	actions := func(policy IAMPolicy, sid string) []string {
+		for _, statement := range policy.Statement {
+			if statement.Sid == sid {
+				return statement.Action
+			}
+		}
+		return nil
+	}
+
+	policy, err := requiredIAMPolicy(Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []string{"ecr:BatchDeleteImage", "ecr:BatchGetImage", "ecr:DescribeImages", "ecr:DescribeRepositories", "ecr:ListImages"}
+	if got := actions(policy, "ECR"); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(policy.Statement) != 2 {
+		t.Errorf("Expected only the identity and ECR statements, got %+v", policy.Statement)
+	}
+
+	// Dry runs delete nothing
+	policy, _ = requiredIAMPolicy(Config{DryRun: true})
+	if slices.Contains(actions(policy, "ECR"), "ecr:BatchDeleteImage") {
+		t.Error("Expected no deletion permission for a dry run")
+	}
+
+	// Outputs are granted on the locations the flags name
+	policy, err = requiredIAMPolicy(Config{LockS3: "s3://bucket/locks", SNSTopicArn: "arn:aws:sns:us-east-1:123456789012:cleanup", ProtectBatch: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, statement := range policy.Statement {
+		switch statement.Sid {
+		case "S3":
+			if !slices.Equal(statement.Resource, []string{"arn:aws:s3:::bucket/locks/*"}) || len(statement.Action) != 3 {
+				t.Errorf("Unexpected S3 statement %+v", statement)
+			}
+		case "Notifications":
+			if statement.Resource[0] != "arn:aws:sns:us-east-1:123456789012:cleanup" {
+				t.Errorf("Unexpected SNS statement %+v", statement)
+			}
+		}
+	}
+	if got := actions(policy, "InUse"); !slices.Equal(got, []string{"batch:DescribeJobDefinitions"}) {
+		t.Errorf("Expected the Batch provider's action, got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeIAMPolicy(&buf, policy); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var decoded IAMPolicy
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.Version != iamPolicyVersion {
+		t.Errorf("Expected a policy document, got %s (%v)", buf.String(), err)
+	}
+}
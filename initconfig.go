@@ -0,0 +1,245 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the init command, which scans the registry and prints
+// a starter -config file for it. It deletes nothing. Each repository is
+// assigned a named policy from its tag conventions and activity: commit
+// and build tags suggest short-lived CI builds, version tags releases kept
+// for long, and repositories nobody pushed to in months a dormant policy.
+// Each policy lists its repositories, so it runs on its own with
+// -policy-name, and the suggestions are comments a reviewer can adjust.
+
+// Patterns of the tag conventions init recognizes
+var (
+	// versionTag matches release versions such as v1, 1.4 or v2.3.1-rc1
+	versionTag = regexp.MustCompile(`^(v\d+(\.\d+){0,2}|\d+\.\d+(\.\d+)?)([-+][0-9A-Za-z.-]+)?$`)
+	// buildTag matches commit hashes and build numbers, alone or after a
+	// branch, such as 3f9c2ab, main-3f9c2ab or build-1042
+	buildTag = regexp.MustCompile(`(^|[-_.])([0-9a-f]{7,40}|\d{3,})$`)
+)
+
+// dormantAge is how long a repository goes without a push before init
+// considers it dormant
+const dormantAge = 180 * 24 * time.Hour
+
+// suggestedPolicy is a named policy init can suggest
+type suggestedPolicy struct {
+	name        string
+	description string
+	settings    [][2]string // flag name and value, in order
+}
+
+// suggestedPolicies are the policies init suggests, in the order they are
+// written
+var suggestedPolicies = []suggestedPolicy{
+	{"ci-builds", "mostly commit or build tags: short-lived CI images",
+		[][2]string{{"days", "14"}, {"max-images", "20"}}},
+	{"releases", "mostly version tags: releases kept for rollbacks",
+		[][2]string{{"days", "365"}, {"min-keep", "10"}, {"keep-per-tag-family", "true"}, {"tag-family-pattern", `'^(v?\d+)\.'`}}},
+	{"dormant", fmt.Sprintf("no push in %d days: keep the last few images", int(dormantAge.Hours()/24)),
+		[][2]string{{"days", "90"}, {"min-keep", "3"}}},
+	{"default", "no clear tag convention",
+		[][2]string{{"days", "30"}, {"max-images", "10"}}},
+}
+
+// RepositoryProfile sums up the images and tag conventions of a repository
+type RepositoryProfile struct {
+	RepositoryInventory
+	VersionTags int
+	BuildTags   int
+	OtherTags   int
+}
+
+// add counts an image and its tags in the profile
+func (p *RepositoryProfile) add(img types.ImageDetail) {
+	p.RepositoryInventory.add(img)
+	for _, tag := range img.ImageTags {
+		switch {
+		case versionTag.MatchString(tag):
+			p.VersionTags++
+		case buildTag.MatchString(tag):
+			p.BuildTags++
+		default:
+			p.OtherTags++
+		}
+	}
+}
+
+// policy returns the name of the policy init suggests for the repository
+func (p RepositoryProfile) policy(now time.Time) string {
+	tags := p.VersionTags + p.BuildTags + p.OtherTags
+	switch {
+	case p.NewestPush != nil && now.Sub(*p.NewestPush) > dormantAge:
+		return "dormant"
+	case tags > 0 && p.BuildTags*2 > tags:
+		return "ci-builds"
+	case tags > 0 && p.VersionTags*2 > tags:
+		return "releases"
+	default:
+		return "default"
+	}
+}
+
+// runInit runs the init command: it writes a starter -config file for the
+// registry to stdout
+func runInit(config Config) int {
+	ctx := context.Background()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error scanning the registry", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+	if config.Public {
+		awsConfig.Region = publicRegion
+		config.SkipListImages = true
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	profiles, err := profileRepositories(ctx, client, config)
+	if err != nil {
+		slog.Error("Error scanning the registry", "error", err)
+		return 1
+	}
+
+	if err := writeStarterConfig(os.Stdout, profiles, awsConfig.Region, config.now()); err != nil {
+		slog.Error("Error writing the config file", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// profileRepositories scans every repository and sums up its images and
+// tag conventions, in name order
+func profileRepositories(ctx context.Context, client ECRClient, cfg Config) ([]RepositoryProfile, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	var mu sync.Mutex
+	var firstErr error
+	profiles := []RepositoryProfile{}
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		profile := RepositoryProfile{RepositoryInventory: RepositoryInventory{Name: aws.ToString(repo.RepositoryName)}}
+		err := scanImagePages(ctx, client, profile.Name, cfg, func(page []types.ImageDetail) error {
+			for _, img := range page {
+				profile.add(img)
+			}
+			return nil
+		})
+
+		if profile.Images > 0 {
+			profile.UntaggedRatio = float64(profile.Untagged) / float64(profile.Images)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to scan repository %s: %w", profile.Name, err)
+			}
+			return
+		}
+		profiles = append(profiles, profile)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	slices.SortFunc(profiles, func(a, b RepositoryProfile) int { return cmp.Compare(a.Name, b.Name) })
+	return profiles, nil
+}
+
+// writeStarterConfig writes a -config file with a named policy for each
+// group of repositories, each repository's numbers as a comment, and the
+// empty repositories as a suggestion for -delete-empty-repos
+func writeStarterConfig(w io.Writer, profiles []RepositoryProfile, region string, now time.Time) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Starter ecr-cleanup config generated by ecr-cleanup init on %s\n", now.UTC().Format(time.DateOnly))
+	fmt.Fprintf(&b, "# from %d repositories", len(profiles))
+	if region != "" {
+		fmt.Fprintf(&b, " in %s", region)
+	}
+	b.WriteString(". Review the suggestions, then preview each policy with:\n")
+	b.WriteString("#\n#   ecr-cleanup -config ecr-cleanup.yaml -policy-name <name> -dry-run\n\n")
+
+	groups := make(map[string][]RepositoryProfile)
+	var empty []string
+	for _, p := range profiles {
+		if p.Images == 0 {
+			empty = append(empty, p.Name)
+			continue
+		}
+		name := p.policy(now)
+		groups[name] = append(groups[name], p)
+	}
+
+	b.WriteString("policies:\n")
+	if len(groups) == 0 {
+		b.WriteString("  {}\n")
+	}
+	for _, policy := range suggestedPolicies {
+		repos := groups[policy.name]
+		if len(repos) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  # %s\n", policy.description)
+		fmt.Fprintf(&b, "  %s:\n", policy.name)
+		b.WriteString("    repository:\n")
+		for _, p := range repos {
+			fmt.Fprintf(&b, "      - %q # %s\n", p.Name, describeProfile(p, now))
+		}
+		for _, setting := range policy.settings {
+			fmt.Fprintf(&b, "    %s: %s\n", setting[0], setting[1])
+		}
+	}
+
+	if len(empty) > 0 {
+		fmt.Fprintf(&b, "\n# %d repositories hold no image; -delete-empty-repos deletes them once\n# they are older than -empty-repo-days: %s\n", len(empty), strings.Join(empty, ", "))
+	}
+
+	b.WriteString("\n# Protect the images of running workloads, whatever the policy selects:\n")
+	b.WriteString("# in_use_providers:\n#   - type: ecs\n#   - type: lambda\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describeProfile sums up a repository in a few words, e.g. "120 images,
+// 4.2 GB, 30% untagged, 85% build tags, last push 2d ago"
+func describeProfile(p RepositoryProfile, now time.Time) string {
+	parts := []string{fmt.Sprintf("%d images", p.Images), formatBytes(p.Size)}
+	if p.Untagged > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% untagged", p.UntaggedRatio*100))
+	}
+	if tags := p.VersionTags + p.BuildTags + p.OtherTags; tags > 0 {
+		switch {
+		case p.BuildTags*2 > tags:
+			parts = append(parts, fmt.Sprintf("%.0f%% build tags", float64(p.BuildTags)/float64(tags)*100))
+		case p.VersionTags*2 > tags:
+			parts = append(parts, fmt.Sprintf("%.0f%% version tags", float64(p.VersionTags)/float64(tags)*100))
+		}
+	}
+	if p.NewestPush != nil {
+		parts = append(parts, "last push "+formatAge(p.NewestPush, now, "")+" ago")
+	}
+	return strings.Join(parts, ", ")
+}
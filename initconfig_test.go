@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestRepositoryProfilePolicy tests suggesting a policy from a
+// repository's tags and activity
+func TestRepositoryProfilePolicy(t *testing.T) {
+	now := time.Now()
+	profile := func(pushed time.Time, tags ...string) RepositoryProfile {
+		var p RepositoryProfile
+		p.add(types.ImageDetail{ImageTags: tags, ImagePushedAt: aws.Time(pushed)})
+		return p
+	}
+	tests := []struct {
+		profile RepositoryProfile
+		policy  string
+	}{
+		{profile(now, "main-3f9c2ab", "build-1042", "latest"), "ci-builds"},
+		{profile(now, "v1.4.2", "v1", "1.4"), "releases"},
+		{profile(now, "latest", "stable"), "default"},
+		{profile(now), "default"},
+		{profile(now.AddDate(-1, 0, 0), "v1.4.2"), "dormant"},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.policy(now); got != tt.policy {
+			t.Errorf("%+v: expected %s, got %s", tt.profile, tt.policy, got)
+		}
+	}
+}
+
+// TestWriteStarterConfig tests that the starter config groups repositories
+// into policies that -policy-name can load
+func TestWriteStarterConfig(t *testing.T) {
+	client := newGuardrailClient(2, 2)
+	details := client.DescribeImagesOutput.ImageDetails
+	details[0].ImageTags = []string{"main-3f9c2ab"}
+	details[1].ImageTags = []string{"main-8e1d07c"}
+	profiles, err := profileRepositories(context.Background(), client, Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	profiles = append(profiles, RepositoryProfile{RepositoryInventory: RepositoryInventory{Name: "abandoned"}})
+
+	var out bytes.Buffer
+	if err := writeStarterConfig(&out, profiles, "us-east-1", time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "1 repositories hold no image") || !strings.Contains(out.String(), "100% build tags") {
+		t.Errorf("Unexpected config:\n%s", out.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "ecr-cleanup.yaml")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-policy-name", "ci-builds"})
+	if err != nil {
+		t.Fatalf("Expected the starter config to load, got %v\n%s", err, out.String())
+	}
+	if cfg.Days != 14 || cfg.MaxImages != 20 || !reflect.DeepEqual(cfg.Repositories, []string{"repo0", "repo1"}) {
+		t.Errorf("Unexpected policy: days %d, max images %d, repositories %v", cfg.Days, cfg.MaxImages, cfg.Repositories)
+	}
+}
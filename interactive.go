@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// This file contains the -interactive terminal UI. The run scans like a dry
+// run, lists the candidate deletions grouped by repository and lets the user
+// toggle single images or whole repositories. The confirmed selection is
+// then applied as a plan, so exactly the chosen images are deleted.
+
+// selectionRow is a line of the selection list: a repository, or one of
+// its images when image is not -1
+type selectionRow struct {
+	repo  int
+	image int
+}
+
+// selectionUI holds the state of the selection list
+type selectionUI struct {
+	repos    []PlanRepository
+	selected [][]bool
+	expanded []bool
+
+	cursor     int
+	offset     int
+	confirming bool
+}
+
+// newSelectionUI creates a selection list with every candidate selected
+func newSelectionUI(plan *Plan) *selectionUI {
+	ui := &selectionUI{
+		repos:    plan.Repositories,
+		selected: make([][]bool, len(plan.Repositories)),
+		expanded: make([]bool, len(plan.Repositories)),
+	}
+	for i, repo := range plan.Repositories {
+		ui.selected[i] = make([]bool, len(repo.Images))
+		for j := range ui.selected[i] {
+			ui.selected[i][j] = true
+		}
+	}
+	return ui
+}
+
+// rows returns the visible lines: every repository, followed by its images
+// when expanded
+func (ui *selectionUI) rows() []selectionRow {
+	var rows []selectionRow
+	for i, repo := range ui.repos {
+		rows = append(rows, selectionRow{repo: i, image: -1})
+		if ui.expanded[i] {
+			for j := range repo.Images {
+				rows = append(rows, selectionRow{repo: i, image: j})
+			}
+		}
+	}
+	return rows
+}
+
+// repoSelection returns how many of a repository's images are selected and
+// their size
+func (ui *selectionUI) repoSelection(i int) (int, int64) {
+	count, size := 0, int64(0)
+	for j, selected := range ui.selected[i] {
+		if selected {
+			count++
+			size += ui.repos[i].Images[j].SizeBytes
+		}
+	}
+	return count, size
+}
+
+// totals returns how many images are selected overall and their size
+func (ui *selectionUI) totals() (int, int64) {
+	count, size := 0, int64(0)
+	for i := range ui.repos {
+		c, s := ui.repoSelection(i)
+		count += c
+		size += s
+	}
+	return count, size
+}
+
+// toggle flips the row under the cursor. On a repository it selects every
+// image, or none when all of them were selected.
+func (ui *selectionUI) toggle() {
+	rows := ui.rows()
+	if len(rows) == 0 {
+		return
+	}
+
+	row := rows[ui.cursor]
+	if row.image >= 0 {
+		ui.selected[row.repo][row.image] = !ui.selected[row.repo][row.image]
+		return
+	}
+
+	count, _ := ui.repoSelection(row.repo)
+	all := count < len(ui.selected[row.repo])
+	for j := range ui.selected[row.repo] {
+		ui.selected[row.repo][j] = all
+	}
+}
+
+// toggleAll selects every image, or none when all of them were selected
+func (ui *selectionUI) toggleAll() {
+	count, _ := ui.totals()
+	total := 0
+	for i := range ui.selected {
+		total += len(ui.selected[i])
+	}
+
+	all := count < total
+	for i := range ui.selected {
+		for j := range ui.selected[i] {
+			ui.selected[i][j] = all
+		}
+	}
+}
+
+// setExpanded expands or collapses the repository under the cursor, keeping
+// the cursor on the repository
+func (ui *selectionUI) setExpanded(expanded bool) {
+	rows := ui.rows()
+	if len(rows) == 0 {
+		return
+	}
+
+	repo := rows[ui.cursor].repo
+	ui.expanded[repo] = expanded
+	for i, row := range ui.rows() {
+		if row.repo == repo && row.image == -1 {
+			ui.cursor = i
+			return
+		}
+	}
+}
+
+// moveCursor moves the cursor by delta rows, staying within the list
+func (ui *selectionUI) moveCursor(delta int) {
+	ui.cursor += delta
+	if last := len(ui.rows()) - 1; ui.cursor > last {
+		ui.cursor = last
+	}
+	if ui.cursor < 0 {
+		ui.cursor = 0
+	}
+}
+
+// handleKey applies a key press. It reports whether the UI is finished and,
+// if so, whether the selection was confirmed.
+func (ui *selectionUI) handleKey(key string, pageSize int) (done, confirmed bool) {
+	// Deleting asks for confirmation first
+	if ui.confirming {
+		ui.confirming = false
+		return key == "y" || key == "Y", key == "y" || key == "Y"
+	}
+
+	switch key {
+	case "up", "k":
+		ui.moveCursor(-1)
+	case "down", "j":
+		ui.moveCursor(1)
+	case "pgup":
+		ui.moveCursor(-pageSize)
+	case "pgdown":
+		ui.moveCursor(pageSize)
+	case "home", "g":
+		ui.cursor = 0
+	case "end", "G":
+		ui.moveCursor(len(ui.rows()))
+	case " ", "x":
+		ui.toggle()
+	case "a":
+		ui.toggleAll()
+	case "right", "l", "enter":
+		rows := ui.rows()
+		if len(rows) > 0 {
+			ui.setExpanded(key != "enter" || !ui.expanded[rows[ui.cursor].repo])
+		}
+	case "left", "h":
+		ui.setExpanded(false)
+	case "d":
+		if count, _ := ui.totals(); count > 0 {
+			ui.confirming = true
+		}
+	case "q", "esc", "ctrl-c":
+		return true, false
+	}
+	return false, false
+}
+
+// checkbox renders the selection state of count out of total images
+func checkbox(count, total int) string {
+	switch {
+	case count == 0:
+		return "[ ]"
+	case count == total:
+		return "[x]"
+	default:
+		return "[-]"
+	}
+}
+
+// render draws the list into a screen of the given size
+func (ui *selectionUI) render(w io.Writer, width, height int) {
+	const header, footer = 2, 2
+	pageSize := height - header - footer
+	if pageSize < 1 {
+		pageSize = 1
+	}
+
+	// Scroll so the cursor stays visible
+	if ui.cursor < ui.offset {
+		ui.offset = ui.cursor
+	}
+	if ui.cursor >= ui.offset+pageSize {
+		ui.offset = ui.cursor - pageSize + 1
+	}
+
+	var lines []string
+	count, size := ui.totals()
+	lines = append(lines, fmt.Sprintf("ECR cleanup: %d images selected, %s", count, formatBytes(size)))
+	lines = append(lines, "")
+
+	rows := ui.rows()
+	for i := ui.offset; i < len(rows) && i < ui.offset+pageSize; i++ {
+		row := rows[i]
+		repo := ui.repos[row.repo]
+
+		var line string
+		if row.image == -1 {
+			c, s := ui.repoSelection(row.repo)
+			arrow := "▸"
+			if ui.expanded[row.repo] {
+				arrow = "▾"
+			}
+			line = fmt.Sprintf("%s %s %s  %d/%d images, %s", arrow, checkbox(c, len(repo.Images)), qualifiedPlanName(repo), c, len(repo.Images), formatBytes(s))
+		} else {
+			img := repo.Images[row.image]
+			mark := checkbox(0, 1)
+			if ui.selected[row.repo][row.image] {
+				mark = checkbox(1, 1)
+			}
+			tags := "<untagged>"
+			if len(img.Tags) > 0 {
+				tags = strings.Join(img.Tags, ",")
+			}
+			line = fmt.Sprintf("    %s %-20s %s  %s  %s", mark, shortDigest(img.Digest), img.PushedAt.Format("2006-01-02"), formatBytes(img.SizeBytes), tags)
+		}
+
+		line = truncateLine(line, width)
+		if i == ui.cursor {
+			line = "\033[7m" + line + "\033[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	for len(lines) < height-footer {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "")
+	if ui.confirming {
+		lines = append(lines, fmt.Sprintf("Delete %d images freeing %s? [y/N]", count, formatBytes(size)))
+	} else {
+		lines = append(lines, truncateLine("↑/↓ move  space toggle  a all  →/← expand/collapse  d delete  q quit", width))
+	}
+
+	fmt.Fprint(w, "\033[H\033[2J"+strings.Join(lines, "\r\n"))
+}
+
+// result returns the plan of the selected images
+func (ui *selectionUI) result(plan *Plan) *Plan {
+	selected := &Plan{
+		SchemaVersion: plan.SchemaVersion,
+		ID:            plan.ID,
+		CreatedAt:     plan.CreatedAt,
+		Repositories:  []PlanRepository{},
+	}
+
+	for i, repo := range ui.repos {
+		planRepo := PlanRepository{AccountID: repo.AccountID, Region: repo.Region, Name: repo.Name}
+		for j, img := range repo.Images {
+			if ui.selected[i][j] {
+				planRepo.Images = append(planRepo.Images, img)
+				selected.Images++
+				selected.SizeBytes += img.SizeBytes
+			}
+		}
+		if len(planRepo.Images) > 0 {
+			selected.Repositories = append(selected.Repositories, planRepo)
+		}
+	}
+	return selected
+}
+
+// qualifiedPlanName returns the repository name prefixed with its account
+// and region, when known
+func qualifiedPlanName(repo PlanRepository) string {
+	return RepositorySummary{AccountID: repo.AccountID, Region: repo.Region, Name: repo.Name}.qualifiedName()
+}
+
+// shortDigest abbreviates a digest for display
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
+
+// truncateLine cuts a line to the terminal width
+func truncateLine(line string, width int) string {
+	runes := []rune(line)
+	if width > 0 && len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+// parseKeys splits terminal input into key names
+func parseKeys(input []byte) []string {
+	sequences := map[string]string{
+		"\033[A": "up", "\033[B": "down", "\033[C": "right", "\033[D": "left",
+		"\033OA": "up", "\033OB": "down", "\033OC": "right", "\033OD": "left",
+		"\033[5~": "pgup", "\033[6~": "pgdown", "\033[H": "home", "\033[F": "end",
+	}
+
+	var keys []string
+	for len(input) > 0 {
+		matched := false
+		for seq, name := range sequences {
+			if bytes.HasPrefix(input, []byte(seq)) {
+				keys = append(keys, name)
+				input = input[len(seq):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		switch input[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 3:
+			keys = append(keys, "ctrl-c")
+		case 27:
+			keys = append(keys, "esc")
+		default:
+			keys = append(keys, string(input[0]))
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// runSelectionUI shows the selection list on the terminal until the user
+// confirms or quits. It returns the selected plan, or nil when cancelled.
+func runSelectionUI(plan *Plan, in, out *os.File) (*Plan, error) {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal UI: %w", err)
+	}
+	defer term.Restore(int(in.Fd()), state)
+
+	// Use the alternate screen so the list doesn't stay in the scrollback
+	fmt.Fprint(out, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(out, "\033[?25h\033[?1049l")
+
+	ui := newSelectionUI(plan)
+	buf := make([]byte, 64)
+	for {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		ui.render(out, width, height)
+
+		n, err := in.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			done, confirmed := ui.handleKey(key, height-4)
+			if !done {
+				continue
+			}
+			if !confirmed {
+				return nil, nil
+			}
+			return ui.result(plan), nil
+		}
+	}
+}
+
+// runInteractive scans like a dry run, lets the user pick the images to
+// delete and deletes exactly those
+func runInteractive(config Config) int {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		slog.Error("-interactive needs a terminal")
+		return 1
+	}
+
+	plan, err := scanPlan(config)
+	if err != nil {
+		slog.Error("Error cleaning up ECR repositories", "error", err)
+		return 1
+	}
+	if plan.Images == 0 {
+		slog.Info("No images to delete")
+		return 0
+	}
+
+	selected, err := runSelectionUI(plan, os.Stdin, os.Stdout)
+	if err != nil {
+		slog.Error("Error in terminal UI", "error", err)
+		return 1
+	}
+	if selected == nil || selected.Images == 0 {
+		slog.Info("Cancelled; no images were deleted")
+		return 0
+	}
+
+	// Delete the selection through the plan machinery, which also refuses
+	// images re-pushed while the list was open
+	config.plan = selected
+	config, restoreSignals := stopOnSignal(config)
+	summary, err := runCleanup(config)
+	restoreSignals()
+	if err != nil {
+		return 1
+	}
+	return exitCode(summary)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseKeys tests decoding terminal input
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("\033[Aj \033[B\r\033[6~q\033\x03"))
+	want := []string{"up", "j", " ", "down", "enter", "pgdown", "q", "esc", "ctrl-c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestSelectionUI tests toggling images and repositories
+func TestSelectionUI(t *testing.T) {
+	plan := newPlan(testPlanSummary(), time.Now())
+	ui := newSelectionUI(plan)
+
+	if count, size := ui.totals(); count != 3 || size != 600 {
+		t.Fatalf("Expected every image selected, got %d images and %d bytes", count, size)
+	}
+
+	// Deselect the first repository, then expand it and select one image
+	ui.handleKey(" ", 10)
+	if count, _ := ui.repoSelection(0); count != 0 {
+		t.Errorf("Expected the repository to be deselected, got %d images", count)
+	}
+	ui.handleKey("right", 10)
+	if len(ui.rows()) != 4 {
+		t.Fatalf("Expected the repository's images to be listed, got %d rows", len(ui.rows()))
+	}
+	ui.handleKey("down", 10)
+	ui.handleKey("down", 10)
+	ui.handleKey(" ", 10)
+
+	result := ui.result(plan)
+	if result.Images != 2 || result.SizeBytes != 500 || result.ID != plan.ID {
+		t.Errorf("Expected 2 images and 500 bytes, got %+v", result)
+	}
+	if repo := result.repository("", "us-east-1", "api"); repo == nil || len(repo.Images) != 1 || repo.Images[0].Digest != "sha256:bbb" {
+		t.Errorf("Expected only sha256:bbb from the first repository, got %+v", repo)
+	}
+
+	// Collapsing from an image moves the cursor back to its repository
+	ui.handleKey("left", 10)
+	if ui.cursor != 0 || len(ui.rows()) != 2 {
+		t.Errorf("Expected the cursor on the collapsed repository, got cursor %d and %d rows", ui.cursor, len(ui.rows()))
+	}
+
+	// Toggling everything selects all, then nothing
+	ui.handleKey("a", 10)
+	if count, _ := ui.totals(); count != 3 {
+		t.Errorf("Expected every image selected, got %d", count)
+	}
+	ui.handleKey("a", 10)
+	if count, _ := ui.totals(); count != 0 {
+		t.Errorf("Expected no image selected, got %d", count)
+	}
+
+	// The cursor stays within the list
+	ui.handleKey("end", 10)
+	ui.handleKey("down", 10)
+	if ui.cursor != 1 {
+		t.Errorf("Expected the cursor on the last row, got %d", ui.cursor)
+	}
+}
+
+// TestSelectionUIConfirm tests confirming and cancelling the deletion
+func TestSelectionUIConfirm(t *testing.T) {
+	plan := newPlan(testPlanSummary(), time.Now())
+
+	t.Run("Confirm", func(t *testing.T) {
+		ui := newSelectionUI(plan)
+		if done, _ := ui.handleKey("d", 10); done || !ui.confirming {
+			t.Fatal("Expected a confirmation prompt")
+		}
+
+		var b strings.Builder
+		ui.render(&b, 80, 24)
+		if !strings.Contains(b.String(), "Delete 3 images freeing 600 B? [y/N]") {
+			t.Errorf("Expected the prompt on screen, got:\n%s", b.String())
+		}
+
+		if done, confirmed := ui.handleKey("y", 10); !done || !confirmed {
+			t.Error("Expected the selection to be confirmed")
+		}
+	})
+
+	t.Run("Decline", func(t *testing.T) {
+		ui := newSelectionUI(plan)
+		ui.handleKey("d", 10)
+		if done, _ := ui.handleKey("n", 10); done || ui.confirming {
+			t.Error("Expected to return to the list")
+		}
+		if done, confirmed := ui.handleKey("q", 10); !done || confirmed {
+			t.Error("Expected to quit without confirming")
+		}
+	})
+
+	t.Run("Nothing selected", func(t *testing.T) {
+		ui := newSelectionUI(plan)
+		ui.handleKey("a", 10)
+		ui.handleKey("d", 10)
+		if ui.confirming {
+			t.Error("Expected no prompt without a selection")
+		}
+	})
+}
+
+// TestSelectionUIRender tests drawing and scrolling the list
+func TestSelectionUIRender(t *testing.T) {
+	ui := newSelectionUI(newPlan(testPlanSummary(), time.Now()))
+	ui.handleKey("right", 10)
+
+	var b strings.Builder
+	ui.render(&b, 80, 24)
+	screen := b.String()
+	for _, want := range []string{
+		"ECR cleanup: 3 images selected, 600 B",
+		"▾ [x] us-east-1/api  2/2 images, 300 B",
+		"[x] sha256:aaa",
+		"2024-05-01  100 B  v1",
+		"<untagged>",
+		"▸ [x] eu-west-1/api  1/1 images, 300 B",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("Expected the screen to contain %q, got:\n%s", want, screen)
+		}
+	}
+
+	// With room for one row, the list scrolls to the cursor
+	ui.handleKey("end", 1)
+	b.Reset()
+	ui.render(&b, 80, 5)
+	if strings.Contains(b.String(), "us-east-1/api") || !strings.Contains(b.String(), "eu-west-1/api") {
+		t.Errorf("Expected only the last repository, got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file contains how a run stops early. -timeout puts a deadline on
+// every AWS call of the run. SIGINT or SIGTERM, the end of the maintenance
+// window and the end of a Lambda invocation stop it gracefully: no new
+// repository is scanned and no new deletion starts, the batches being
+// deleted finish, and the summary shows what was deleted until then.
+
+// errRunStopped is returned when a run stops early, because it was
+// interrupted or timed out. Its summary shows what was deleted.
+var errRunStopped = errors.New("run stopped before it finished")
+
+// stopOnSignal makes the run stop gracefully on SIGINT or SIGTERM. A second
+// signal exits right away. The returned function restores the default
+// handling once the run is over.
+func stopOnSignal(config Config) (Config, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("Stopping: finishing the deletions in flight; signal again to exit now", "signal", sig.String())
+			signal.Stop(signals)
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	config.stop = stop
+	return config, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// stopAfter makes the run also stop gracefully once d has passed, giving
+// reason as why. The calls in flight are not cancelled, so the batches
+// being deleted finish. The returned function releases the timer once the
+// run is over.
+func stopAfter(config Config, d time.Duration, reason error) (Config, func()) {
+	stop := make(chan struct{})
+	var once sync.Once
+	var cause error
+	stopWith := func(err error) {
+		once.Do(func() {
+			cause = err
+			close(stop)
+		})
+	}
+
+	timer := time.AfterFunc(d, func() { stopWith(reason) })
+	done := make(chan struct{})
+	go func(previous <-chan struct{}, previousReason func() error) {
+		select {
+		case <-previous:
+			var err error
+			if previousReason != nil {
+				err = previousReason()
+			}
+			stopWith(err)
+		case <-done:
+		}
+	}(config.stop, config.stopReason)
+
+	config.stop = stop
+	config.stopReason = func() error { return cause }
+	return config, func() {
+		timer.Stop()
+		close(done)
+	}
+}
+
+// withRunTimeout returns the context for the AWS calls of a run, with the
+// -timeout deadline if set
+func withRunTimeout(ctx context.Context, cfg Config) (context.Context, context.CancelFunc) {
+	if cfg.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.Timeout)
+}
+
+// stopError returns why the run has to stop, or nil if it can go on
+func stopError(ctx context.Context, cfg Config) error {
+	select {
+	case <-cfg.stop:
+		if cfg.stopReason != nil {
+			if reason := cfg.stopReason(); reason != nil {
+				return fmt.Errorf("%w: %w", errRunStopped, reason)
+			}
+		}
+		return fmt.Errorf("%w: interrupted", errRunStopped)
+	default:
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: timed out after %s", errRunStopped, cfg.Timeout)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", errRunStopped, ctx.Err())
+	}
+	return nil
+}
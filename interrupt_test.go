@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// stoppingClient stops the run once its first batch is deleted
+type stoppingClient struct {
+	*MockECRClient
+	stop chan struct{}
+	once sync.Once
+}
+
+func (c *stoppingClient) BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	c.once.Do(func() { close(c.stop) })
+	return c.MockECRClient.BatchDeleteImage(ctx, params, optFns...)
+}
+
+// TestStopError tests telling why a run has to stop
+func TestStopError(t *testing.T) {
+	if err := stopError(context.Background(), Config{}); err != nil {
+		t.Errorf("Expected no stop without a signal or deadline, got %v", err)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	err := stopError(context.Background(), Config{stop: stop})
+	if !errors.Is(err, errRunStopped) || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("Expected an interrupted run, got %v", err)
+	}
+
+	ctx, cancel := withRunTimeout(context.Background(), Config{Timeout: time.Nanosecond})
+	defer cancel()
+	<-ctx.Done()
+	err = stopError(ctx, Config{Timeout: 30 * time.Minute})
+	if !errors.Is(err, errRunStopped) || !strings.Contains(err.Error(), "timed out after 30m0s") {
+		t.Errorf("Expected a timed out run, got %v", err)
+	}
+}
+
+// TestCleanupWithClientStopped tests that a stopped run scans and deletes nothing more
+func TestCleanupWithClientStopped(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+	client := newGuardrailClient(3, 5)
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, stop: stop}, client)
+	if !errors.Is(err, errRunStopped) {
+		t.Errorf("Expected the run to stop, got %v", err)
+	}
+	if client.ListImagesCalls != 0 || client.BatchDeleteImageCalls != 0 || summary.RepositoriesProcessed != 0 {
+		t.Errorf("Expected nothing scanned or deleted, got %d scans and %d deletions", client.ListImagesCalls, client.BatchDeleteImageCalls)
+	}
+}
+
+// TestDeleteRepositoryImagesStopped tests finishing the batch in flight and
+// starting no other
+func TestDeleteRepositoryImagesStopped(t *testing.T) {
+	var images []types.ImageDetail
+	for i := 0; i < 250; i++ {
+		images = append(images, types.ImageDetail{
+			ImageDigest:      aws.String(fmt.Sprintf("sha256:%03d", i)),
+			ImageSizeInBytes: aws.Int64(1000),
+		})
+	}
+	client := &stoppingClient{
+		MockECRClient: &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}},
+		stop:          make(chan struct{}),
+	}
+
+	cfg := Config{stop: client.stop}
+	deleted, err := deleteRepositoryImages(context.Background(), client, "repo", images, cfg)
+	if !errors.Is(err, errRunStopped) {
+		t.Errorf("Expected the deletion to stop, got %v", err)
+	}
+	if len(deleted) != batchDeleteSize || client.BatchDeleteImageCalls != 1 {
+		t.Errorf("Expected one batch deleted, got %d images in %d calls", len(deleted), client.BatchDeleteImageCalls)
+	}
+
+	summary := deletionSummary(len(images), deleted, cfg)
+	if summary.ImagesDeleted != batchDeleteSize || summary.SpaceFreed != batchDeleteSize*1000 {
+		t.Errorf("Expected the summary to show only the deleted batch, got %+v", summary)
+	}
+}
+
+// TestDeleteRepositoryImagesPaced tests deleting in -delete-batch-size
+// batches, pausing -delete-batch-delay between them
+func TestDeleteRepositoryImagesPaced(t *testing.T) {
+	var images []types.ImageDetail
+	for i := 0; i < 25; i++ {
+		images = append(images, types.ImageDetail{ImageDigest: aws.String(fmt.Sprintf("sha256:%03d", i))})
+	}
+	client := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}
+
+	start := time.Now()
+	cfg := Config{DeleteBatchSize: 10, DeleteBatchDelay: 20 * time.Millisecond}
+	deleted, err := deleteRepositoryImages(context.Background(), client, "repo", images, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(deleted) != 25 || client.BatchDeleteImageCalls != 3 || len(client.LastBatchDeleteImageInput.ImageIds) != 5 {
+		t.Errorf("Expected 25 images deleted in 3 batches, got %d in %d", len(deleted), client.BatchDeleteImageCalls)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected two pauses between batches, took %v", elapsed)
+	}
+
+	// Stopping the run cuts the pause short and starts no other batch
+	stopping := &stoppingClient{MockECRClient: &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}, stop: make(chan struct{})}
+	start = time.Now()
+	cfg = Config{DeleteBatchSize: 10, DeleteBatchDelay: time.Hour, stop: stopping.stop}
+	deleted, err = deleteRepositoryImages(context.Background(), stopping, "repo", images, cfg)
+	if !errors.Is(err, errRunStopped) || len(deleted) != 10 || time.Since(start) > time.Minute {
+		t.Errorf("Expected the run stopped after one batch, got %d deleted, %v", len(deleted), err)
+	}
+}
+
+// TestDeleteBatchSize tests that out of range batch sizes fall back to the
+// BatchDeleteImage limit
+func TestDeleteBatchSize(t *testing.T) {
+	for size, want := range map[int]int{0: 100, 1: 1, 50: 50, 100: 100, 500: 100} {
+		if got := (Config{DeleteBatchSize: size}).deleteBatchSize(); got != want {
+			t.Errorf("Expected batch size %d for %d, got %d", want, size, got)
+		}
+	}
+}
+
+// TestStopAfter tests stopping gracefully after a while, or earlier when the
+// run was already going to stop
+func TestStopAfter(t *testing.T) {
+	cfg, release := stopAfter(Config{}, 10*time.Millisecond, errors.New("out of time"))
+	defer release()
+	select {
+	case <-cfg.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to stop")
+	}
+	if err := stopError(context.Background(), cfg); !errors.Is(err, errRunStopped) || !strings.Contains(err.Error(), "out of time") {
+		t.Errorf("Expected the run to stop out of time, got %v", err)
+	}
+
+	interrupt := make(chan struct{})
+	cfg, release = stopAfter(Config{stop: interrupt}, time.Hour, errors.New("out of time"))
+	defer release()
+	close(interrupt)
+	select {
+	case <-cfg.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the interrupt to stop the run")
+	}
+	if err := stopError(context.Background(), cfg); !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("Expected an interrupted run, got %v", err)
+	}
+}
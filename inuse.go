@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	apprunnertypes "github.com/aws/aws-sdk-go-v2/service/apprunner/types"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the in-use protection logic. Images referenced by running
+// workloads are collected into a keep-set by the providers of providers.go
+// before the cleanup starts, and any image in the keep-set is excluded from
+// deletion regardless of its age.
+
+// AppRunnerClient defines the App Runner operations needed to find in-use images
+type AppRunnerClient interface {
+	ListServices(ctx context.Context, params *apprunner.ListServicesInput, optFns ...func(*apprunner.Options)) (*apprunner.ListServicesOutput, error)
+	DescribeService(ctx context.Context, params *apprunner.DescribeServiceInput, optFns ...func(*apprunner.Options)) (*apprunner.DescribeServiceOutput, error)
+}
+
+// BatchClient defines the AWS Batch operations needed to find in-use images
+type BatchClient interface {
+	DescribeJobDefinitions(ctx context.Context, params *batch.DescribeJobDefinitionsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobDefinitionsOutput, error)
+}
+
+// imageRef is a container image reference that points at an ECR repository
+type imageRef struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageRef parses an ECR image URI such as
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com/repo:tag or .../repo@sha256:...
+// It returns false for references that do not point at a private ECR registry.
+func parseImageRef(uri string) (imageRef, bool) {
+	slash := strings.Index(uri, "/")
+	if slash < 0 || !strings.Contains(uri[:slash], ".dkr.ecr.") {
+		return imageRef{}, false
+	}
+
+	ref := imageRef{}
+	name := uri[slash+1:]
+
+	if at := strings.Index(name, "@"); at >= 0 {
+		ref.Digest = name[at+1:]
+		name = name[:at]
+	}
+
+	// The tag separator is the last colon after the last path component
+	if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		ref.Tag = name[colon+1:]
+		name = name[:colon]
+	}
+
+	// An image without tag or digest resolves to latest
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	ref.Repository = name
+	if ref.Repository == "" {
+		return imageRef{}, false
+	}
+
+	return ref, true
+}
+
+// keepSet holds the tags and digests of images that must never be deleted,
+// keyed by repository name
+type keepSet struct {
+	tags    map[string]map[string]bool
+	digests map[string]map[string]bool
+}
+
+// newKeepSet creates an empty keep-set
+func newKeepSet() *keepSet {
+	return &keepSet{
+		tags:    make(map[string]map[string]bool),
+		digests: make(map[string]map[string]bool),
+	}
+}
+
+// add records an image reference in the keep-set
+func (k *keepSet) add(ref imageRef) {
+	if ref.Tag != "" {
+		if k.tags[ref.Repository] == nil {
+			k.tags[ref.Repository] = make(map[string]bool)
+		}
+		k.tags[ref.Repository][ref.Tag] = true
+	}
+	if ref.Digest != "" {
+		if k.digests[ref.Repository] == nil {
+			k.digests[ref.Repository] = make(map[string]bool)
+		}
+		k.digests[ref.Repository][ref.Digest] = true
+	}
+}
+
+// addURI parses an image URI and records it if it points at ECR
+func (k *keepSet) addURI(uri string) {
+	if ref, ok := parseImageRef(uri); ok {
+		k.add(ref)
+	}
+}
+
+// merge adds the references of another keep-set
+func (k *keepSet) merge(other *keepSet) {
+	if other == nil {
+		return
+	}
+	for repo, tags := range other.tags {
+		for tag := range tags {
+			k.add(imageRef{Repository: repo, Tag: tag})
+		}
+	}
+	for repo, digests := range other.digests {
+		for digest := range digests {
+			k.add(imageRef{Repository: repo, Digest: digest})
+		}
+	}
+}
+
+// size returns the number of protected references in the keep-set
+func (k *keepSet) size() int {
+	if k == nil {
+		return 0
+	}
+	n := 0
+	for _, tags := range k.tags {
+		n += len(tags)
+	}
+	for _, digests := range k.digests {
+		n += len(digests)
+	}
+	return n
+}
+
+// contains reports whether the image is protected by the keep-set
+func (k *keepSet) contains(repoName string, img types.ImageDetail) bool {
+	if k == nil {
+		return false
+	}
+	if img.ImageDigest != nil && k.digests[repoName][*img.ImageDigest] {
+		return true
+	}
+	for _, tag := range img.ImageTags {
+		if k.tags[repoName][tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// exclude returns the images that are not protected by the keep-set
+func (k *keepSet) exclude(repoName string, images []types.ImageDetail) []types.ImageDetail {
+	if k.size() == 0 {
+		return images
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range images {
+		if k.contains(repoName, img) {
+			slog.Info("Keeping in-use image", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
+
+// collectAppRunnerImages adds the ECR images configured on App Runner services
+func collectAppRunnerImages(ctx context.Context, client AppRunnerClient, keep *keepSet) error {
+	var nextToken *string
+
+	for {
+		resp, err := client.ListServices(ctx, &apprunner.ListServicesInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, summary := range resp.ServiceSummaryList {
+			desc, err := client.DescribeService(ctx, &apprunner.DescribeServiceInput{
+				ServiceArn: summary.ServiceArn,
+			})
+			if err != nil {
+				return err
+			}
+
+			if desc.Service == nil || desc.Service.SourceConfiguration == nil {
+				continue
+			}
+			repo := desc.Service.SourceConfiguration.ImageRepository
+			if repo == nil || repo.ImageIdentifier == nil || repo.ImageRepositoryType != apprunnertypes.ImageRepositoryTypeEcr {
+				continue
+			}
+			keep.addURI(*repo.ImageIdentifier)
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// collectBatchImages adds the images referenced by active Batch job definitions
+func collectBatchImages(ctx context.Context, client BatchClient, keep *keepSet) error {
+	var nextToken *string
+
+	for {
+		resp, err := client.DescribeJobDefinitions(ctx, &batch.DescribeJobDefinitionsInput{
+			Status:    aws.String("ACTIVE"),
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, def := range resp.JobDefinitions {
+			for _, image := range batchJobDefinitionImages(def) {
+				keep.addURI(image)
+			}
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// batchJobDefinitionImages returns every container image in a job definition,
+// covering single-container, multi-node, ECS and EKS job types
+func batchJobDefinitionImages(def batchtypes.JobDefinition) []string {
+	var images []string
+
+	if def.ContainerProperties != nil && def.ContainerProperties.Image != nil {
+		images = append(images, *def.ContainerProperties.Image)
+	}
+
+	if def.NodeProperties != nil {
+		for _, node := range def.NodeProperties.NodeRangeProperties {
+			if node.Container != nil && node.Container.Image != nil {
+				images = append(images, *node.Container.Image)
+			}
+		}
+	}
+
+	if def.EcsProperties != nil {
+		for _, task := range def.EcsProperties.TaskProperties {
+			for _, container := range task.Containers {
+				if container.Image != nil {
+					images = append(images, *container.Image)
+				}
+			}
+		}
+	}
+
+	if def.EksProperties != nil && def.EksProperties.PodProperties != nil {
+		pod := def.EksProperties.PodProperties
+		for _, container := range append(pod.Containers, pod.InitContainers...) {
+			if container.Image != nil {
+				images = append(images, *container.Image)
+			}
+		}
+	}
+
+	return images
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	apprunnertypes "github.com/aws/aws-sdk-go-v2/service/apprunner/types"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// MockAppRunnerClient implements the AppRunnerClient interface for testing
+type MockAppRunnerClient struct {
+	Services map[string]*apprunnertypes.Service
+}
+
+// ListServices mock implementation
+func (m *MockAppRunnerClient) ListServices(ctx context.Context, params *apprunner.ListServicesInput, optFns ...func(*apprunner.Options)) (*apprunner.ListServicesOutput, error) {
+	out := &apprunner.ListServicesOutput{}
+	for arn := range m.Services {
+		out.ServiceSummaryList = append(out.ServiceSummaryList, apprunnertypes.ServiceSummary{ServiceArn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+// DescribeService mock implementation
+func (m *MockAppRunnerClient) DescribeService(ctx context.Context, params *apprunner.DescribeServiceInput, optFns ...func(*apprunner.Options)) (*apprunner.DescribeServiceOutput, error) {
+	return &apprunner.DescribeServiceOutput{Service: m.Services[*params.ServiceArn]}, nil
+}
+
+// MockBatchClient implements the BatchClient interface for testing
+type MockBatchClient struct {
+	JobDefinitions []batchtypes.JobDefinition
+	LastInput      *batch.DescribeJobDefinitionsInput
+}
+
+// DescribeJobDefinitions mock implementation
+func (m *MockBatchClient) DescribeJobDefinitions(ctx context.Context, params *batch.DescribeJobDefinitionsInput, optFns ...func(*batch.Options)) (*batch.DescribeJobDefinitionsOutput, error) {
+	m.LastInput = params
+	return &batch.DescribeJobDefinitionsOutput{JobDefinitions: m.JobDefinitions}, nil
+}
+
+// TestParseImageRef tests parsing of ECR image URIs
+func TestParseImageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		uri      string
+		ok       bool
+		expected imageRef
+	}{
+		{"Tagged image", "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1", true, imageRef{Repository: "app", Tag: "v1"}},
+		{"Nested repository", "123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app:v2", true, imageRef{Repository: "team/app", Tag: "v2"}},
+		{"Digest image", "123456789012.dkr.ecr.us-east-1.amazonaws.com/app@sha256:abc", true, imageRef{Repository: "app", Digest: "sha256:abc"}},
+		{"Untagged image", "123456789012.dkr.ecr.us-east-1.amazonaws.com/app", true, imageRef{Repository: "app", Tag: "latest"}},
+		{"Docker Hub image", "nginx:latest", false, imageRef{}},
+		{"Other registry", "ghcr.io/org/app:v1", false, imageRef{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, ok := parseImageRef(tc.uri)
+			if ok != tc.ok {
+				t.Fatalf("Expected ok=%v, got %v", tc.ok, ok)
+			}
+			if ref != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, ref)
+			}
+		})
+	}
+}
+
+// TestKeepSetExclude tests that protected images are removed from the deletion list
+func TestKeepSetExclude(t *testing.T) {
+	keep := newKeepSet()
+	keep.add(imageRef{Repository: "app", Tag: "prod"})
+	keep.add(imageRef{Repository: "app", Digest: "sha256:222"})
+
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:111"), ImageTags: []string{"v1", "prod"}},
+		{ImageDigest: aws.String("sha256:222")},
+		{ImageDigest: aws.String("sha256:333"), ImageTags: []string{"v3"}},
+	}
+
+	remaining := keep.exclude("app", images)
+	if len(remaining) != 1 || *remaining[0].ImageDigest != "sha256:333" {
+		t.Errorf("Expected only sha256:333 to remain, got %v", remaining)
+	}
+
+	// Other repositories are not affected
+	if len(keep.exclude("other", images)) != 3 {
+		t.Errorf("Expected keep-set to only apply to its own repository")
+	}
+
+	// A nil keep-set protects nothing
+	var empty *keepSet
+	if len(empty.exclude("app", images)) != 3 {
+		t.Errorf("Expected nil keep-set to return all images")
+	}
+}
+
+// TestCollectAppRunnerImages tests collecting images from App Runner services
+func TestCollectAppRunnerImages(t *testing.T) {
+	mockClient := &MockAppRunnerClient{
+		Services: map[string]*apprunnertypes.Service{
+			"arn:service/ecr": {
+				SourceConfiguration: &apprunnertypes.SourceConfiguration{
+					ImageRepository: &apprunnertypes.ImageRepository{
+						ImageIdentifier:     aws.String("123456789012.dkr.ecr.us-east-1.amazonaws.com/web:v7"),
+						ImageRepositoryType: apprunnertypes.ImageRepositoryTypeEcr,
+					},
+				},
+			},
+			"arn:service/public": {
+				SourceConfiguration: &apprunnertypes.SourceConfiguration{
+					ImageRepository: &apprunnertypes.ImageRepository{
+						ImageIdentifier:     aws.String("public.ecr.aws/nginx/nginx:latest"),
+						ImageRepositoryType: apprunnertypes.ImageRepositoryTypeEcrPublic,
+					},
+				},
+			},
+			"arn:service/code": {
+				SourceConfiguration: &apprunnertypes.SourceConfiguration{},
+			},
+		},
+	}
+
+	keep := newKeepSet()
+	if err := collectAppRunnerImages(context.Background(), mockClient, keep); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if keep.size() != 1 {
+		t.Fatalf("Expected 1 protected reference, got %d", keep.size())
+	}
+	if !keep.tags["web"]["v7"] {
+		t.Errorf("Expected web:v7 to be protected")
+	}
+}
+
+// TestCollectBatchImages tests collecting images from Batch job definitions
+func TestCollectBatchImages(t *testing.T) {
+	registry := "123456789012.dkr.ecr.us-east-1.amazonaws.com/"
+	mockClient := &MockBatchClient{
+		JobDefinitions: []batchtypes.JobDefinition{
+			{
+				ContainerProperties: &batchtypes.ContainerProperties{Image: aws.String(registry + "etl:v1")},
+			},
+			{
+				NodeProperties: &batchtypes.NodeProperties{
+					NodeRangeProperties: []batchtypes.NodeRangeProperty{
+						{Container: &batchtypes.ContainerProperties{Image: aws.String(registry + "mpi:v2")}},
+					},
+				},
+			},
+			{
+				EksProperties: &batchtypes.EksProperties{
+					PodProperties: &batchtypes.EksPodProperties{
+						Containers: []batchtypes.EksContainer{{Image: aws.String(registry + "train@sha256:abc")}},
+					},
+				},
+			},
+		},
+	}
+
+	keep := newKeepSet()
+	if err := collectBatchImages(context.Background(), mockClient, keep); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if *mockClient.LastInput.Status != "ACTIVE" {
+		t.Errorf("Expected only active job definitions to be requested")
+	}
+	if !keep.tags["etl"]["v1"] || !keep.tags["mpi"]["v2"] || !keep.digests["train"]["sha256:abc"] {
+		t.Errorf("Expected all job definition images to be protected, got %+v", keep)
+	}
+}
+
+// TestCleanupKeepsInUseImages tests that in-use images survive cleanup
+func TestCleanupKeepsInUseImages(t *testing.T) {
+	oldTime := time.Now().AddDate(0, 0, -30)
+	mockClient := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+			Repositories: []types.Repository{{RepositoryName: aws.String("app")}},
+		},
+		ListImagesOutput: &ecr.ListImagesOutput{
+			ImageIds: []types.ImageIdentifier{{ImageTag: aws.String("v1")}, {ImageTag: aws.String("v2")}},
+		},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{
+			ImageDetails: []types.ImageDetail{
+				{ImageDigest: aws.String("sha256:111"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(oldTime)},
+				{ImageDigest: aws.String("sha256:222"), ImageTags: []string{"v2"}, ImagePushedAt: aws.Time(oldTime)},
+			},
+		},
+	}
+
+	keep := newKeepSet()
+	keep.add(imageRef{Repository: "app", Tag: "v1"})
+	cfg := Config{Days: 10, DryRun: true, inUse: keep}
+
+	summary, err := CleanupWithClient(context.Background(), cfg, mockClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 1 {
+		t.Errorf("Expected 1 image selected for deletion, got %d", summary.ImagesDeleted)
+	}
+}
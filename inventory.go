@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the report command, a storage inventory of the
+// registry. It deletes nothing: it scans every repository with the same
+// paging as a cleanup and reports how many images each holds, how much
+// storage they take, how old they are, how many are untagged or were never
+// pulled, and when one was last pulled, largest repositories first.
+
+// RepositoryInventory sums up the images of a repository
+type RepositoryInventory struct {
+	Name          string     `json:"name"`
+	Images        int        `json:"images"`
+	Untagged      int        `json:"untagged"`
+	UntaggedRatio float64    `json:"untagged_ratio"`
+	NeverPulled   int        `json:"never_pulled"` // images without a recorded pull
+	Size          int64      `json:"size_bytes"`
+	UntaggedSize  int64      `json:"untagged_size_bytes"`
+	OldestPush    *time.Time `json:"oldest_pushed_at,omitempty"`
+	NewestPush    *time.Time `json:"newest_pushed_at,omitempty"`
+	LastPull      *time.Time `json:"last_pulled_at,omitempty"` // as recorded by ECR, which updates it about once a day
+}
+
+// runInventory runs the report command: it writes the inventory of every
+// repository to stdout
+func runInventory(config Config) int {
+	ctx := context.Background()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error building report", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+	if config.Public {
+		awsConfig.Region = publicRegion
+		config.SkipListImages = true
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	inventory, err := takeInventory(ctx, client, config)
+	if err != nil {
+		slog.Error("Error building report", "error", err)
+		return 1
+	}
+
+	if strings.EqualFold(config.LogFormat, "json") {
+		err = writeInventoryJSON(os.Stdout, inventory)
+	} else {
+		err = writeInventoryTable(os.Stdout, inventory, time.Now())
+	}
+	if err != nil {
+		slog.Error("Error writing report", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// takeInventory scans every repository and sums up its images, largest
+// repositories first
+func takeInventory(ctx context.Context, client ECRClient, cfg Config) ([]RepositoryInventory, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	var mu sync.Mutex
+	var firstErr error
+	inventory := []RepositoryInventory{}
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		inv, err := inventoryRepository(ctx, client, aws.ToString(repo.RepositoryName), cfg)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		inventory = append(inventory, inv)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	slices.SortFunc(inventory, func(a, b RepositoryInventory) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.Name, b.Name))
+	})
+	return inventory, nil
+}
+
+// inventoryRepository scans a repository and sums up its images
+func inventoryRepository(ctx context.Context, client ECRClient, repoName string, cfg Config) (RepositoryInventory, error) {
+	inv := RepositoryInventory{Name: repoName}
+	err := scanImagePages(ctx, client, repoName, cfg, func(page []types.ImageDetail) error {
+		for _, img := range page {
+			inv.add(img)
+		}
+		return nil
+	})
+	if err != nil {
+		return RepositoryInventory{}, fmt.Errorf("failed to scan repository %s: %w", repoName, err)
+	}
+	if inv.Images > 0 {
+		inv.UntaggedRatio = float64(inv.Untagged) / float64(inv.Images)
+	}
+	return inv, nil
+}
+
+// add counts an image in the inventory
+func (inv *RepositoryInventory) add(img types.ImageDetail) {
+	inv.Images++
+	inv.Size += aws.ToInt64(img.ImageSizeInBytes)
+	if len(img.ImageTags) == 0 {
+		inv.Untagged++
+		inv.UntaggedSize += aws.ToInt64(img.ImageSizeInBytes)
+	}
+	if pushed := img.ImagePushedAt; pushed != nil {
+		if inv.OldestPush == nil || pushed.Before(*inv.OldestPush) {
+			inv.OldestPush = pushed
+		}
+		if inv.NewestPush == nil || pushed.After(*inv.NewestPush) {
+			inv.NewestPush = pushed
+		}
+	}
+	pulled := img.LastRecordedPullTime
+	if pulled == nil {
+		inv.NeverPulled++
+	} else if inv.LastPull == nil || pulled.After(*inv.LastPull) {
+		inv.LastPull = pulled
+	}
+}
+
+// writeInventoryJSON writes the inventory as JSON
+func writeInventoryJSON(w io.Writer, inventory []RepositoryInventory) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string][]RepositoryInventory{"repositories": inventory})
+}
+
+// writeInventoryTable writes the inventory as an aligned table, with ages
+// in days relative to now
+func writeInventoryTable(w io.Writer, inventory []RepositoryInventory, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tIMAGES\tUNTAGGED\tNEVER PULLED\tSIZE\tOLDEST\tNEWEST\tLAST PULL")
+	for _, inv := range inventory {
+		fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%d\t%s\t%s\t%s\t%s\n",
+			inv.Name, inv.Images, inv.UntaggedRatio*100, inv.NeverPulled, formatBytes(inv.Size),
+			formatAge(inv.OldestPush, now, "-"), formatAge(inv.NewestPush, now, "-"), formatAge(inv.LastPull, now, "never"))
+	}
+	return tw.Flush()
+}
+
+// formatAge formats how many days before now t was, or none if t is unset
+func formatAge(t *time.Time, now time.Time, none string) string {
+	if t == nil {
+		return none
+	}
+	return fmt.Sprintf("%dd", int(now.Sub(*t).Hours()/24))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// TestTakeInventory tests summing up the images of every repository
+func TestTakeInventory(t *testing.T) {
+	// Images are 30 to 33 days old
+	client := newGuardrailClient(2, 4)
+	details := client.DescribeImagesOutput.ImageDetails
+	details[0].ImageTags = []string{"latest"}
+	details[1].ImageTags = []string{"v1"}
+	details[2].LastRecordedPullTime = aws.Time(time.Now().AddDate(0, 0, -3))
+
+	inventory, err := takeInventory(context.Background(), client, Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(inventory) != 2 || inventory[0].Name != "repo0" || inventory[1].Name != "repo1" {
+		t.Fatalf("Expected both repositories in name order, got %+v", inventory)
+	}
+
+	inv := inventory[0]
+	if inv.Images != 4 || inv.Untagged != 2 || inv.UntaggedRatio != 0.5 || inv.NeverPulled != 3 || inv.Size != 4000 || inv.UntaggedSize != 2000 {
+		t.Errorf("Unexpected inventory %+v", inv)
+	}
+	if !inv.OldestPush.Equal(*details[3].ImagePushedAt) || !inv.NewestPush.Equal(*details[0].ImagePushedAt) || !inv.LastPull.Equal(*details[2].LastRecordedPullTime) {
+		t.Errorf("Unexpected ages %v, %v, %v", inv.OldestPush, inv.NewestPush, inv.LastPull)
+	}
+
+	var out bytes.Buffer
+	if err := writeInventoryTable(&out, inventory, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fields := strings.Fields(strings.Split(out.String(), "\n")[1]); strings.Join(fields, " ") != "repo0 4 50% 3 3.9 KB 33d 30d 3d" {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
+
+// TestInventoryNeverPulled tests a repository without images or pulls
+func TestInventoryNeverPulled(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeInventoryTable(&out, []RepositoryInventory{{Name: "empty"}}, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fields := strings.Fields(strings.Split(out.String(), "\n")[1]); strings.Join(fields, " ") != "empty 0 0% 0 0 B - - never" {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -keep-clean-image, which makes sure a repository
+// always keeps a rollback target its security policy allows to deploy.
+// Keeping the newest image isn't enough when that image has critical
+// findings, so the newest image whose scan found nothing CRITICAL or HIGH
+// is kept as well, however old. Repositories without scan results keep
+// nothing extra.
+
+// cleanImage reports whether an image was scanned and has no CRITICAL or
+// HIGH findings
+func cleanImage(img types.ImageDetail) bool {
+	summary := img.ImageScanFindingsSummary
+	if summary == nil {
+		return false
+	}
+	if status := img.ImageScanStatus; status != nil && status.Status == types.ScanStatusFailed {
+		return false
+	}
+	counts := summary.FindingSeverityCounts
+	return counts[string(types.FindingSeverityCritical)] == 0 && counts[string(types.FindingSeverityHigh)] == 0
+}
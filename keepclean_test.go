@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestCleanImage tests recognizing images without critical or high findings
+func TestCleanImage(t *testing.T) {
+	summary := func(counts map[string]int32) *types.ImageScanFindingsSummary {
+		return &types.ImageScanFindingsSummary{FindingSeverityCounts: counts}
+	}
+	tests := []struct {
+		name  string
+		img   types.ImageDetail
+		clean bool
+	}{
+		{"not scanned", types.ImageDetail{}, false},
+		{"no findings", types.ImageDetail{ImageScanFindingsSummary: summary(nil)}, true},
+		{"medium findings", types.ImageDetail{ImageScanFindingsSummary: summary(map[string]int32{"MEDIUM": 4, "LOW": 9})}, true},
+		{"high findings", types.ImageDetail{ImageScanFindingsSummary: summary(map[string]int32{"HIGH": 1})}, false},
+		{"critical findings", types.ImageDetail{ImageScanFindingsSummary: summary(map[string]int32{"CRITICAL": 2})}, false},
+		{"failed scan", types.ImageDetail{ImageScanFindingsSummary: summary(nil), ImageScanStatus: &types.ImageScanStatus{Status: types.ScanStatusFailed}}, false},
+	}
+	for _, tt := range tests {
+		if got := cleanImage(tt.img); got != tt.clean {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.clean, got)
+		}
+	}
+}
+
+// TestKeepCleanImage tests keeping the newest clean image past the cutoff
+func TestKeepCleanImage(t *testing.T) {
+	// Images are 30 to 33 days old, newest first
+	client := newGuardrailClient(1, 4)
+	details := client.DescribeImagesOutput.ImageDetails
+	details[0].ImageScanFindingsSummary = &types.ImageScanFindingsSummary{FindingSeverityCounts: map[string]int32{"CRITICAL": 1}}
+	details[1].ImageScanFindingsSummary = &types.ImageScanFindingsSummary{FindingSeverityCounts: map[string]int32{"LOW": 3}}
+	details[2].ImageScanFindingsSummary = &types.ImageScanFindingsSummary{}
+
+	cfg := Config{Days: 10, KeepCleanImage: true}
+	_, toDelete, err := selectRepositoryImages(context.Background(), client, "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := strings.Join(digestsOf(toDelete), ","); got != "sha256:000,sha256:002,sha256:003" {
+		t.Errorf("Expected the newest clean image kept, got %s", got)
+	}
+
+	// Without scan results nothing extra is kept
+	_, toDelete, err = selectRepositoryImages(context.Background(), newGuardrailClient(1, 4), "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(toDelete) != 4 {
+		t.Errorf("Expected every image selected, got %v", digestsOf(toDelete))
+	}
+}
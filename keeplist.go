@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the -keep-list file: images that are never deleted,
+// whatever policy or plan selected them. Each line holds one entry:
+//
+//	sha256:...          an image digest, in any repository
+//	repo@sha256:...     an image digest in one repository
+//	repo:tag            a tag in one repository
+//
+// Blank lines and anything after a # are ignored.
+
+// keepList holds the images listed in a -keep-list file
+type keepList struct {
+	digests map[string]bool
+	repos   *keepSet
+}
+
+// loadKeepList reads the run's -keep-list file, if any
+func loadKeepList(cfg Config) (*keepList, error) {
+	if cfg.KeepListFile == "" {
+		return nil, nil
+	}
+
+	list, err := readKeepList(cfg.KeepListFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keep list: %w", err)
+	}
+	slog.Info("Protecting keep-list entries", "entries", list.size(), "file", cfg.KeepListFile)
+	return list, nil
+}
+
+// readKeepList reads and validates a -keep-list file
+func readKeepList(path string) (*keepList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &keepList{digests: make(map[string]bool), repos: newKeepSet()}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := list.add(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// add records one keep-list entry
+func (k *keepList) add(entry string) error {
+	if strings.HasPrefix(entry, "sha256:") {
+		k.digests[entry] = true
+		return nil
+	}
+
+	ref := imageRef{}
+	if at := strings.Index(entry, "@"); at >= 0 {
+		ref.Repository, ref.Digest = entry[:at], entry[at+1:]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return fmt.Errorf("invalid digest in keep-list entry %q", entry)
+		}
+	} else if colon := strings.LastIndex(entry, ":"); colon > strings.LastIndex(entry, "/") {
+		ref.Repository, ref.Tag = entry[:colon], entry[colon+1:]
+	}
+	if ref.Repository == "" || (ref.Tag == "" && ref.Digest == "") {
+		return fmt.Errorf("invalid keep-list entry %q: expected a digest, repo@digest or repo:tag", entry)
+	}
+
+	k.repos.add(ref)
+	return nil
+}
+
+// size returns the number of entries in the keep-list
+func (k *keepList) size() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.digests) + k.repos.size()
+}
+
+// repositoryEntries returns the number of entries that protect images of
+// the repository, including the digests that apply to every repository
+func (k *keepList) repositoryEntries(repoName string) int {
+	if k == nil {
+		return 0
+	}
+	return len(k.digests) + len(k.repos.tags[repoName]) + len(k.repos.digests[repoName])
+}
+
+// exclude returns the images that the keep-list doesn't protect
+func (k *keepList) exclude(repoName string, images []types.ImageDetail) []types.ImageDetail {
+	if k.size() == 0 {
+		return images
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range images {
+		if k.digests[aws.ToString(img.ImageDigest)] || k.repos.contains(repoName, img) {
+			slog.Info("Keeping listed image", "action", "keep", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
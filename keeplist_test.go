@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestReadKeepList tests reading the -keep-list file
+func TestReadKeepList(t *testing.T) {
+	t.Run("Valid file with comments", func(t *testing.T) {
+		path := writeTestFile(t, "keep.txt", `# golden images
+sha256:aaa
+team/api:v1.0.0   # last known good
+
+web@sha256:bbb
+registry.local:5000/base:2024
+`)
+
+		list, err := readKeepList(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if list.size() != 4 {
+			t.Errorf("Expected 4 entries, got %d", list.size())
+		}
+		if !list.repos.tags["registry.local:5000/base"]["2024"] {
+			t.Error("Expected the tag after the last colon to be used")
+		}
+	})
+
+	t.Run("Invalid entries", func(t *testing.T) {
+		for _, entry := range []string{"no-tag", "repo@latest", ":tag", "repo:"} {
+			_, err := readKeepList(writeTestFile(t, "keep.txt", "sha256:aaa\n"+entry+"\n"))
+			if err == nil || !strings.Contains(err.Error(), ":2:") {
+				t.Errorf("Expected an error with the line number for %q, got %v", entry, err)
+			}
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		if _, err := readKeepList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+			t.Fatal("Expected an error for a missing file")
+		}
+	})
+}
+
+// TestKeepListExclude tests that listed images are never deleted
+func TestKeepListExclude(t *testing.T) {
+	list := &keepList{digests: make(map[string]bool), repos: newKeepSet()}
+	for _, entry := range []string{"sha256:aaa", "api:v1", "api@sha256:ccc"} {
+		if err := list.add(entry); err != nil {
+			t.Fatalf("Expected %q to be valid, got %v", entry, err)
+		}
+	}
+
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa")},
+		{ImageDigest: aws.String("sha256:bbb"), ImageTags: []string{"latest", "v1"}},
+		{ImageDigest: aws.String("sha256:ccc")},
+		{ImageDigest: aws.String("sha256:ddd"), ImageTags: []string{"v2"}},
+	}
+
+	if remaining := list.exclude("api", images); len(remaining) != 1 || *remaining[0].ImageDigest != "sha256:ddd" {
+		t.Errorf("Expected only sha256:ddd in api, got %+v", remaining)
+	}
+	// Repository entries only protect their own repository
+	if remaining := list.exclude("web", images); len(remaining) != 3 {
+		t.Errorf("Expected only the bare digest to be protected in web, got %d images", len(remaining))
+	}
+
+	var empty *keepList
+	if remaining := empty.exclude("api", images); len(remaining) != len(images) {
+		t.Error("Expected no keep-list to protect nothing")
+	}
+}
+
+// TestCleanupWithClientKeepList tests the keep-list across a run
+func TestCleanupWithClientKeepList(t *testing.T) {
+	path := writeTestFile(t, "keep.txt", "sha256:001\nrepo1@sha256:002\n")
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, KeepListFile: path}, newGuardrailClient(2, 5))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 7 {
+		t.Errorf("Expected 7 images deleted, got %d", summary.ImagesDeleted)
+	}
+
+	if _, err := CleanupWithClient(context.Background(), Config{Days: 10, KeepListFile: filepath.Join(t.TempDir(), "missing.txt")}, newGuardrailClient(1, 1)); err == nil {
+		t.Error("Expected a missing keep-list to fail the run")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// This file contains the AWS Lambda handler. When the binary runs inside
+// Lambda it serves invocations instead of parsing the command line, so the
+// cleanup can run on an EventBridge schedule without any servers. Options
+// use the flag names and come from ECR_CLEANUP_* environment variables and
+// the invocation event, with the event taking precedence. The run stops
+// gracefully ahead of the invocation's deadline.
+
+// lambdaStopMargin is how long before the invocation's deadline the run
+// stops, which leaves time to finish the batches in flight and report. Short
+// invocations keep a quarter of their time instead.
+const lambdaStopMargin = 30 * time.Second
+
+// isLambda reports whether the process runs inside the Lambda runtime
+func isLambda() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
+}
+
+// startLambda serves Lambda invocations until the runtime shuts down
+func startLambda() {
+	lambda.Start(handleLambdaEvent)
+}
+
+// handleLambdaEvent runs one cleanup with the options from the environment
+// and the event, and returns the JSON result of the run
+func handleLambdaEvent(ctx context.Context, event json.RawMessage) (runResult, error) {
+	args, err := lambdaArgs(event)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	fs := flag.NewFlagSet("ecr-cleanup", flag.ContinueOnError)
+	config, err := parseFlagSet(fs, args)
+	if err != nil {
+		return runResult{}, fmt.Errorf("invalid options: %w", err)
+	}
+	if err := setupLogging(config); err != nil {
+		return runResult{}, err
+	}
+	if err := validateConfig(config); err != nil {
+		return runResult{}, fmt.Errorf("invalid options: %w", err)
+	}
+
+	config, release := stopBeforeDeadline(ctx, config)
+	defer release()
+	summary, err := runCleanup(config)
+	return newRunResult(summary, config, err), err
+}
+
+// stopBeforeDeadline makes the run stop gracefully a margin ahead of the
+// deadline of ctx, so that the invocation returns the summary of what was
+// deleted rather than timing out
+func stopBeforeDeadline(ctx context.Context, config Config) (Config, func()) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return config, func() {}
+	}
+
+	remaining := time.Until(deadline)
+	margin := lambdaStopMargin
+	if margin > remaining/4 {
+		margin = remaining / 4
+	}
+	return stopAfter(config, remaining-margin, fmt.Errorf("the Lambda invocation ends at %s", deadline.Format(time.RFC3339)))
+}
+
+// lambdaArgs converts the event's options into command-line arguments,
+// which take precedence over the ECR_CLEANUP_* environment variables
+func lambdaArgs(event json.RawMessage) ([]string, error) {
+	var args []string
+
+	options, err := lambdaEventOptions(event)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values, err := optionValues(options[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		for _, value := range values {
+			args = append(args, fmt.Sprintf("-%s=%s", optionName(name), value))
+		}
+	}
+
+	return args, nil
+}
+
+// lambdaEventOptions returns the options object of the event. EventBridge
+// events carry the options in their detail; other events are the options.
+func lambdaEventOptions(event json.RawMessage) (map[string]json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(event))
+	if trimmed == "" || trimmed == "null" {
+		return nil, nil
+	}
+
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(event, &options); err != nil {
+		return nil, fmt.Errorf("event must be a JSON object of options: %w", err)
+	}
+
+	if _, ok := options["detail-type"]; ok {
+		return lambdaEventOptions(options["detail"])
+	}
+	return options, nil
+}
+
+// optionValues converts a JSON option value into flag values. Arrays are
+// repeated, which suits flags like -webhook-header.
+func optionValues(raw json.RawMessage) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("objects are not supported")
+	case nil:
+		return nil, nil
+	case float64:
+		// Print numbers without an exponent, e.g. 1000000 rather than 1e+06
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
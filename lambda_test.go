@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// slowDeleteClient takes a while to delete each batch
+type slowDeleteClient struct {
+	*MockECRClient
+}
+
+func (c *slowDeleteClient) BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	time.Sleep(100 * time.Millisecond)
+	return c.MockECRClient.BatchDeleteImage(ctx, params, optFns...)
+}
+
+// TestLambdaArgs tests converting the event into arguments
+func TestLambdaArgs(t *testing.T) {
+	t.Run("Event options", func(t *testing.T) {
+		event := json.RawMessage(`{"days": 14, "max_images": 5, "regions": "us-east-1,eu-west-1", "webhook-header": ["A: 1", "B: 2"]}`)
+		args, err := lambdaArgs(event)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		want := []string{
+			"-days=14",
+			"-max-images=5",
+			"-regions=us-east-1,eu-west-1",
+			"-webhook-header=A: 1",
+			"-webhook-header=B: 2",
+		}
+		if !reflect.DeepEqual(args, want) {
+			t.Errorf("Expected %v, got %v", want, args)
+		}
+	})
+
+	t.Run("EventBridge event", func(t *testing.T) {
+		event := json.RawMessage(`{"version": "0", "detail-type": "Scheduled Event", "source": "aws.events", "detail": {"days": 7}}`)
+		args, err := lambdaArgs(event)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(args, []string{"-days=7"}) {
+			t.Errorf("Expected the detail options, got %v", args)
+		}
+	})
+
+	t.Run("Empty event", func(t *testing.T) {
+		args, err := lambdaArgs(json.RawMessage(`null`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(args) != 0 {
+			t.Errorf("Expected no options, got %v", args)
+		}
+	})
+
+	t.Run("Invalid events", func(t *testing.T) {
+		for _, event := range []string{`[1, 2]`, `{"days": {"nested": true}}`} {
+			if _, err := lambdaArgs(json.RawMessage(event)); err == nil {
+				t.Errorf("Expected an error for %s", event)
+			}
+		}
+	})
+}
+
+// TestLambdaArgsParse tests that converted arguments parse into a
+// configuration, taking precedence over the environment
+func TestLambdaArgsParse(t *testing.T) {
+	t.Setenv("ECR_CLEANUP_DAYS", "30")
+	t.Setenv("ECR_CLEANUP_MAX_IMAGES", "5")
+	args, err := lambdaArgs(json.RawMessage(`{"dry-run": true, "days": 14, "api-rate": 2.5}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !cfg.DryRun || cfg.Days != 14 || cfg.APIRate != 2.5 || cfg.MaxImages != 5 {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+// TestHandleLambdaEventInvalidOptions tests that unknown options are rejected
+func TestHandleLambdaEventInvalidOptions(t *testing.T) {
+	_, err := handleLambdaEvent(context.Background(), json.RawMessage(`{"no-such-option": 1}`))
+	if err == nil || !strings.Contains(err.Error(), "invalid options") {
+		t.Errorf("Expected an invalid options error, got %v", err)
+	}
+}
+
+// TestHandleLambdaEventConflictingOptions tests that the event's options
+// are validated like the command line's before anything runs
+func TestHandleLambdaEventConflictingOptions(t *testing.T) {
+	_, err := handleLambdaEvent(context.Background(), json.RawMessage(`{"older-than": "720h", "before": "2024-01-01T00:00:00Z"}`))
+	if err == nil || !strings.Contains(err.Error(), "-older-than and -before can't be combined") {
+		t.Errorf("Expected the conflicting options rejected, got %v", err)
+	}
+}
+
+// TestStopBeforeDeadline tests stopping the run gracefully ahead of the
+// invocation's deadline with what it deleted until then
+func TestStopBeforeDeadline(t *testing.T) {
+	if _, release := stopBeforeDeadline(context.Background(), Config{}); release == nil {
+		t.Fatal("Expected a release function without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	client := &slowDeleteClient{MockECRClient: newGuardrailClient(5, 150)}
+	config := Config{Days: 10, MinKeep: 1, KeepNewest: true, Concurrency: 1, client: client}
+	config, release := stopBeforeDeadline(ctx, config)
+	defer release()
+
+	summary, err := runCleanup(config)
+	if !errors.Is(err, errRunStopped) || !strings.Contains(err.Error(), "the Lambda invocation ends at") {
+		t.Fatalf("Expected the run to stop before the deadline, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected the run to return before the deadline")
+	}
+	if summary.ImagesDeleted == 0 || client.BatchDeleteImageCalls == 10 {
+		t.Errorf("Expected a partial run, got %d images deleted in %d batches", summary.ImagesDeleted, client.BatchDeleteImageCalls)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// This file contains the Lambda in-use provider. Functions packaged as
+// container images keep running the image they were deployed with, so the
+// images of every function of a region are never deleted, by tag and by the
+// digest Lambda resolved at deployment. Every published version counts, not
+// only $LATEST: aliases and event sources can point at an older version,
+// which runs the image it was published with. Lambda is called over its
+// REST API with the signed client of awsapi.go.
+
+// lambdaProvider protects the images of Lambda functions
+type lambdaProvider struct{}
+
+// Name identifies the provider
+func (lambdaProvider) Name() string { return "Lambda" }
+
+// Regional reports that Lambda functions are collected in every region
+func (lambdaProvider) Regional() bool { return true }
+
+// Collect adds the images of the functions packaged as container images
+func (lambdaProvider) Collect(ctx context.Context, awsConfig aws.Config, keep *keepSet) error {
+	return collectLambdaImages(ctx, newSignedAPIClient(awsConfig, "lambda"), keep)
+}
+
+// lambdaCall makes one GET call of the Lambda API
+func lambdaCall(ctx context.Context, api *signedAPIClient, operation, path string, output any) error {
+	respBody, err := api.call(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", operation, err)
+	}
+	return json.Unmarshal(respBody, output)
+}
+
+// collectLambdaImages adds the images of every version of the functions
+// packaged as container images
+func collectLambdaImages(ctx context.Context, api *signedAPIClient, keep *keepSet) error {
+	for marker := ""; ; {
+		query := url.Values{"FunctionVersion": {"ALL"}}
+		if marker != "" {
+			query.Set("Marker", marker)
+		}
+		path := "/2015-03-31/functions/?" + query.Encode()
+		var list struct {
+			Functions []struct {
+				FunctionName string `json:"FunctionName"`
+				Version      string `json:"Version"`
+				PackageType  string `json:"PackageType"`
+			} `json:"Functions"`
+			NextMarker string `json:"NextMarker"`
+		}
+		if err := lambdaCall(ctx, api, "ListFunctions", path, &list); err != nil {
+			return err
+		}
+
+		for _, function := range list.Functions {
+			if function.PackageType != "Image" {
+				continue
+			}
+			var out struct {
+				Code struct {
+					ImageURI         string `json:"ImageUri"`
+					ResolvedImageURI string `json:"ResolvedImageUri"`
+				} `json:"Code"`
+			}
+			path := "/2015-03-31/functions/" + url.PathEscape(function.FunctionName)
+			if function.Version != "" {
+				path += "?" + url.Values{"Qualifier": {function.Version}}.Encode()
+			}
+			if err := lambdaCall(ctx, api, "GetFunction", path, &out); err != nil {
+				return err
+			}
+			keep.addURI(out.Code.ImageURI)
+			keep.addURI(out.Code.ResolvedImageURI)
+		}
+
+		if marker = list.NextMarker; marker == "" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestCollectLambdaImages tests protecting the images of every version of
+// the functions packaged as container images
+func TestCollectLambdaImages(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.RequestURI())
+		switch r.URL.RequestURI() {
+		case "/2015-03-31/functions/?FunctionVersion=ALL":
+			w.Write([]byte(`{"Functions":[{"FunctionName":"zip-fn","Version":"$LATEST","PackageType":"Zip"},{"FunctionName":"api","Version":"$LATEST","PackageType":"Image"}],"NextMarker":"m2"}`))
+		case "/2015-03-31/functions/?FunctionVersion=ALL&Marker=m2":
+			w.Write([]byte(`{"Functions":[{"FunctionName":"api","Version":"3","PackageType":"Image"}]}`))
+		case "/2015-03-31/functions/api?Qualifier=%24LATEST":
+			w.Write([]byte(`{"Code":{"ImageUri":"123456789012.dkr.ecr.us-east-1.amazonaws.com/api:v7","ResolvedImageUri":"123456789012.dkr.ecr.us-east-1.amazonaws.com/api@sha256:777"}}`))
+		case "/2015-03-31/functions/api?Qualifier=3":
+			// The published version an alias points at still runs an older image
+			w.Write([]byte(`{"Code":{"ImageUri":"123456789012.dkr.ecr.us-east-1.amazonaws.com/api:v3","ResolvedImageUri":"123456789012.dkr.ecr.us-east-1.amazonaws.com/api@sha256:333"}}`))
+		default:
+			http.Error(w, `{"Message":"not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+	keep := newKeepSet()
+	if err := (lambdaProvider{}).Collect(context.Background(), awsConfig, keep); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(calls) != 4 {
+		t.Errorf("Expected zip functions to be skipped, got calls %v", calls)
+	}
+	if keep.size() != 4 || !keep.contains("api", types.ImageDetail{ImageDigest: aws.String("sha256:777")}) || !keep.contains("api", types.ImageDetail{ImageTags: []string{"v7"}}) {
+		t.Errorf("Expected the tag and resolved digest of $LATEST, got %d references", keep.size())
+	}
+	if !keep.contains("api", types.ImageDetail{ImageDigest: aws.String("sha256:333")}) || !keep.contains("api", types.ImageDetail{ImageTags: []string{"v3"}}) {
+		t.Error("Expected the image of the published version to be protected too")
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the native ECR lifecycle policies equivalent to the
+// retention flags. The generate-lifecycle-policy command prints the policy
+// of every repository, so a registry can move from running this tool to
+// lifecycle policies where they can express the same retention, and
+// -manage-lifecycle-policies puts the policies on the repositories instead
+// of deleting images. Whatever a policy can't express is reported as a
+// warning, and a repository whose retention can't be expressed at all gets
+// no policy.
+
+// LifecyclePolicyClient defines the ECR operations needed to manage lifecycle policies
+type LifecyclePolicyClient interface {
+	GetLifecyclePolicy(ctx context.Context, params *ecr.GetLifecyclePolicyInput, optFns ...func(*ecr.Options)) (*ecr.GetLifecyclePolicyOutput, error)
+	PutLifecyclePolicy(ctx context.Context, params *ecr.PutLifecyclePolicyInput, optFns ...func(*ecr.Options)) (*ecr.PutLifecyclePolicyOutput, error)
+}
+
+// LifecyclePolicy is an ECR lifecycle policy document
+type LifecyclePolicy struct {
+	Rules []LifecycleRule `json:"rules"`
+}
+
+// LifecycleRule is one rule of an ECR lifecycle policy
+type LifecycleRule struct {
+	RulePriority int                `json:"rulePriority"`
+	Description  string             `json:"description,omitempty"`
+	Selection    LifecycleSelection `json:"selection"`
+	Action       LifecycleAction    `json:"action"`
+}
+
+// LifecycleSelection selects the images a lifecycle rule expires
+type LifecycleSelection struct {
+	TagStatus   string `json:"tagStatus"`
+	CountType   string `json:"countType"`
+	CountUnit   string `json:"countUnit,omitempty"`
+	CountNumber int    `json:"countNumber"`
+}
+
+// LifecycleAction is what a lifecycle rule does with the images it selects
+type LifecycleAction struct {
+	Type string `json:"type"`
+}
+
+// RepositoryLifecyclePolicy is the lifecycle policy generated for one
+// repository. Policy is nil when the retention can't be expressed as one.
+type RepositoryLifecyclePolicy struct {
+	Name     string           `json:"name"`
+	Policy   *LifecyclePolicy `json:"lifecycle_policy,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// runGenerateLifecyclePolicy runs the generate-lifecycle-policy command:
+// it writes the lifecycle policy of every repository to stdout as JSON
+func runGenerateLifecyclePolicy(config Config) int {
+	ctx := context.Background()
+
+	keepList, err := loadKeepList(config)
+	if err != nil {
+		slog.Error("Error generating lifecycle policies", "error", err)
+		return 1
+	}
+	config.keepList = keepList
+	if config.inUseProviders, err = loadInUseProviders(config); err != nil {
+		slog.Error("Error generating lifecycle policies", "error", err)
+		return 1
+	}
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error generating lifecycle policies", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+
+	policies, err := generateLifecyclePolicies(ctx, ecr.NewFromConfig(awsConfig), config)
+	if err != nil {
+		slog.Error("Error generating lifecycle policies", "error", err)
+		return 1
+	}
+
+	if err := writeLifecyclePolicies(os.Stdout, policies); err != nil {
+		slog.Error("Error writing lifecycle policies", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// generateLifecyclePolicies generates the lifecycle policy of every
+// repository the client can see
+func generateLifecyclePolicies(ctx context.Context, client ECRClient, cfg Config) ([]RepositoryLifecyclePolicy, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	policies := []RepositoryLifecyclePolicy{}
+	for _, repo := range repos {
+		name := *repo.RepositoryName
+		policy, warnings := lifecyclePolicyFor(name, cfg)
+		if policy == nil {
+			slog.Warn("Retention can't be expressed as a lifecycle policy", "repository", name)
+		}
+		policies = append(policies, RepositoryLifecyclePolicy{Name: name, Policy: policy, Warnings: warnings})
+	}
+	return policies, nil
+}
+
+// lifecyclePolicyFor converts the retention flags into a repository's
+// lifecycle policy, along with what the policy doesn't cover. The policy is
+// nil when the retention can't be expressed as a lifecycle policy.
+func lifecyclePolicyFor(repoName string, cfg Config) (*LifecyclePolicy, []string) {
+	var warnings []string
+
+	// Images the tool never deletes, which a policy can't single out
+	if n := cfg.keepList.repositoryEntries(repoName); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d -keep-list entries are not protected by the policy", n))
+	}
+	if len(cfg.ProtectTags) > 0 {
+		warnings = append(warnings, fmt.Sprintf("images tagged %s are not protected by the policy", strings.Join(cfg.ProtectTags, ", ")))
+	}
+	if cfg.KeepCleanImage {
+		warnings = append(warnings, "the newest image without critical or high findings is not protected by the policy")
+	}
+	if cfg.KeepPerTagFamily {
+		warnings = append(warnings, "the newest image of each tag family is not protected by the policy")
+	}
+	if cfg.ProtectAppRunner || cfg.ProtectBatch || len(cfg.inUseProviders) > 0 || cfg.ProtectDeployments != "" || cfg.ArgoCDServer != "" {
+		warnings = append(warnings, "images used by running workloads are not protected by the policy")
+	}
+	if cfg.TargetRepoSizeGB > 0 || cfg.TargetTotalGB > 0 {
+		warnings = append(warnings, "storage size targets are ignored: the policy expires every eligible image")
+	}
+	if cfg.QuarantineDays > 0 {
+		warnings = append(warnings, "the policy expires images without quarantining them first")
+	}
+	if cfg.NeverPulledDays > 0 {
+		warnings = append(warnings, fmt.Sprintf("images never pulled %d days after their push are not expired by the policy", cfg.NeverPulledDays))
+	}
+	if cfg.HonorExpiryLabels {
+		warnings = append(warnings, "images past their expiry label are not expired by the policy")
+	}
+
+	// The newest images the tool always keeps
+	keep := cfg.MinKeep
+	if cfg.KeepNewest && keep < 1 {
+		keep = 1
+	}
+
+	// Policies count whole days, so a shorter duration is rounded up
+	days := cfg.Days
+	if cfg.OlderThan > 0 {
+		days = int((cfg.OlderThan + 24*time.Hour - 1) / (24 * time.Hour))
+		if cfg.OlderThan%(24*time.Hour) != 0 {
+			warnings = append(warnings, fmt.Sprintf("-older-than %s is rounded up to %d days: the policy counts whole days", formatAgeDuration(cfg.OlderThan), days))
+		}
+	}
+
+	var selection LifecycleSelection
+	var description string
+	switch {
+	case !cfg.Before.IsZero():
+		warnings = append(warnings, "an absolute cutoff (-before) has no lifecycle policy equivalent")
+		return nil, warnings
+
+	case cfg.pushedRange():
+		warnings = append(warnings, "a range of push times (-pushed-after, -pushed-before) has no lifecycle policy equivalent")
+		return nil, warnings
+
+	case cfg.MaxImages > 0 && days > 0:
+		warnings = append(warnings, fmt.Sprintf("keeping the newest %d images however old (-max-images) along with an age limit (-days) has no lifecycle policy equivalent", cfg.MaxImages))
+		return nil, warnings
+
+	case days > 0:
+		if keep > 0 {
+			warnings = append(warnings, fmt.Sprintf("the policy expires every image older than %d days, even the newest %d (-keep-newest, -min-keep)", days, keep))
+		}
+		selection = LifecycleSelection{TagStatus: "any", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: days}
+		description = fmt.Sprintf("Expire images older than %d days", days)
+
+	default:
+		// Without an age limit, every image but the newest few is deleted
+		count := max(cfg.MaxImages, keep)
+		if count == 0 {
+			warnings = append(warnings, "deleting every image of a repository has no lifecycle policy equivalent")
+			return nil, warnings
+		}
+		selection = LifecycleSelection{TagStatus: "any", CountType: "imageCountMoreThan", CountNumber: count}
+		description = fmt.Sprintf("Keep only the newest %d images", count)
+	}
+
+	policy := &LifecyclePolicy{Rules: []LifecycleRule{{
+		RulePriority: 1,
+		Description:  description,
+		Selection:    selection,
+		Action:       LifecycleAction{Type: "expire"},
+	}}}
+	return policy, warnings
+}
+
+// manageLifecyclePolicies puts the lifecycle policy derived from the
+// retention flags on every repository instead of deleting images, reporting
+// the repositories whose existing policy drifted from it
+func manageLifecyclePolicies(ctx context.Context, client ECRClient, policyClient LifecyclePolicyClient, cfg Config) (CleanupSummary, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return CleanupSummary{}, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	aggregator := &summaryAggregator{}
+	processed := 0
+	for _, repo := range repos {
+		if err := stopError(ctx, cfg); err != nil {
+			summary := aggregator.result()
+			summary.RepositoriesProcessed = processed
+			return summary, err
+		}
+
+		name := *repo.RepositoryName
+		start := time.Now()
+		err := manageLifecyclePolicy(ctx, policyClient, name, cfg)
+		aggregator.addRepository(name, CleanupSummary{RepositoriesProcessed: 1}, time.Since(start), err)
+		processed++
+		if err != nil {
+			slog.Error("Error managing lifecycle policy", "repository", name, "error", err)
+			if cfg.FailOnError {
+				summary := aggregator.result()
+				summary.RepositoriesProcessed = processed
+				return summary, fmt.Errorf("aborted after repository %s failed (-fail-on-error): %w", name, err)
+			}
+		}
+	}
+
+	summary := aggregator.result()
+	summary.RepositoriesProcessed = processed
+	return summary, nil
+}
+
+// manageLifecyclePolicy puts the lifecycle policy derived from the
+// retention flags on a repository, unless it already has that policy
+func manageLifecyclePolicy(ctx context.Context, client LifecyclePolicyClient, repoName string, cfg Config) error {
+	policy, warnings := lifecyclePolicyFor(repoName, cfg)
+	for _, warning := range warnings {
+		slog.Warn("Lifecycle policy doesn't cover the retention", "repository", repoName, "warning", warning)
+	}
+	if policy == nil {
+		slog.Warn("Leaving lifecycle policy unchanged: the retention can't be expressed as one", "repository", repoName)
+		return nil
+	}
+
+	desired, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	current, err := getLifecyclePolicyText(ctx, client, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to get lifecycle policy: %w", err)
+	}
+	switch {
+	case current == "":
+		slog.Info("Repository has no lifecycle policy", "repository", repoName)
+	case sameLifecyclePolicy(current, string(desired)):
+		slog.Info("Lifecycle policy is up to date", "repository", repoName)
+		return nil
+	default:
+		slog.Warn("Lifecycle policy drift", "repository", repoName, "current", current, "desired", string(desired))
+	}
+
+	if cfg.DryRun {
+		slog.Info("[DRY RUN] Would put lifecycle policy", "repository", repoName, "policy", string(desired))
+		return nil
+	}
+
+	_, err = client.PutLifecyclePolicy(ctx, &ecr.PutLifecyclePolicyInput{
+		RepositoryName:      aws.String(repoName),
+		LifecyclePolicyText: aws.String(string(desired)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put lifecycle policy: %w", err)
+	}
+	slog.Info("Put lifecycle policy", "repository", repoName)
+	return nil
+}
+
+// getLifecyclePolicyText returns the repository's lifecycle policy, or an
+// empty string when it has none
+func getLifecyclePolicyText(ctx context.Context, client LifecyclePolicyClient, repoName string) (string, error) {
+	resp, err := client.GetLifecyclePolicy(ctx, &ecr.GetLifecyclePolicyInput{RepositoryName: aws.String(repoName)})
+	var notFound *types.LifecyclePolicyNotFoundException
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.LifecyclePolicyText), nil
+}
+
+// sameLifecyclePolicy reports whether two lifecycle policy documents are
+// the same, whatever their formatting
+func sameLifecyclePolicy(a, b string) bool {
+	var decodedA, decodedB any
+	if json.Unmarshal([]byte(a), &decodedA) != nil || json.Unmarshal([]byte(b), &decodedB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// writeLifecyclePolicies writes the generated policies as indented JSON
+func writeLifecyclePolicies(w io.Writer, policies []RepositoryLifecyclePolicy) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string][]RepositoryLifecyclePolicy{"repositories": policies})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// mockLifecycleClient holds the lifecycle policies of repositories in memory
+type mockLifecycleClient struct {
+	policies map[string]string
+	putError error
+	puts     []string
+}
+
+func (m *mockLifecycleClient) GetLifecyclePolicy(ctx context.Context, params *ecr.GetLifecyclePolicyInput, optFns ...func(*ecr.Options)) (*ecr.GetLifecyclePolicyOutput, error) {
+	policy, ok := m.policies[*params.RepositoryName]
+	if !ok {
+		return nil, &types.LifecyclePolicyNotFoundException{Message: aws.String("not found")}
+	}
+	return &ecr.GetLifecyclePolicyOutput{LifecyclePolicyText: aws.String(policy)}, nil
+}
+
+func (m *mockLifecycleClient) PutLifecyclePolicy(ctx context.Context, params *ecr.PutLifecyclePolicyInput, optFns ...func(*ecr.Options)) (*ecr.PutLifecyclePolicyOutput, error) {
+	if m.putError != nil {
+		return nil, m.putError
+	}
+	m.puts = append(m.puts, *params.RepositoryName)
+	m.policies[*params.RepositoryName] = *params.LifecyclePolicyText
+	return &ecr.PutLifecyclePolicyOutput{}, nil
+}
+
+// TestLifecyclePolicyFor tests converting the retention flags into a lifecycle policy
+func TestLifecyclePolicyFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		selection *LifecycleSelection
+		warning   string
+	}{
+		{
+			name:      "Age limit",
+			cfg:       Config{Days: 30},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: 30},
+		},
+		{
+			name:      "Age limit keeping the newest image",
+			cfg:       Config{Days: 30, KeepNewest: true},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: 30},
+			warning:   "even the newest 1",
+		},
+		{
+			name:      "Image count",
+			cfg:       Config{MaxImages: 5, MinKeep: 1},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "imageCountMoreThan", CountNumber: 5},
+		},
+		{
+			name:      "Minimum kept above the image count",
+			cfg:       Config{MaxImages: 2, MinKeep: 3},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "imageCountMoreThan", CountNumber: 3},
+		},
+		{
+			name:    "Image count with an age limit",
+			cfg:     Config{Days: 30, MaxImages: 5},
+			warning: "has no lifecycle policy equivalent",
+		},
+		{
+			name:    "Every image",
+			cfg:     Config{},
+			warning: "deleting every image",
+		},
+		{
+			name:      "Size target",
+			cfg:       Config{Days: 7, TargetRepoSizeGB: 10},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: 7},
+			warning:   "size targets are ignored",
+		},
+		{
+			name:      "Protected tags",
+			cfg:       Config{Days: 7, ProtectTags: []string{"keep", "pinned"}},
+			selection: &LifecycleSelection{TagStatus: "any", CountType: "sinceImagePushed", CountUnit: "days", CountNumber: 7},
+			warning:   "images tagged keep, pinned are not protected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, warnings := lifecyclePolicyFor("api", tt.cfg)
+			if tt.selection == nil {
+				if policy != nil {
+					t.Errorf("Expected no policy, got %+v", policy)
+				}
+			} else if policy == nil || len(policy.Rules) != 1 || policy.Rules[0].Selection != *tt.selection || policy.Rules[0].Action.Type != "expire" {
+				t.Errorf("Expected one rule expiring %+v, got %+v", *tt.selection, policy)
+			}
+
+			joined := strings.Join(warnings, "\n")
+			if tt.warning == "" && joined != "" {
+				t.Errorf("Expected no warnings, got %q", joined)
+			}
+			if !strings.Contains(joined, tt.warning) {
+				t.Errorf("Expected a warning containing %q, got %q", tt.warning, joined)
+			}
+		})
+	}
+}
+
+// TestGenerateLifecyclePolicies tests generating the policy of every repository
+func TestGenerateLifecyclePolicies(t *testing.T) {
+	keep, err := readKeepList(writeTestFile(t, "keep.txt", "repo1:v1\nrepo1@sha256:aaa\n"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	client := newGuardrailClient(2, 0)
+
+	policies, err := generateLifecyclePolicies(context.Background(), client, Config{Days: 14, keepList: keep})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(policies) != 2 || policies[0].Name != "repo0" || policies[1].Name != "repo1" {
+		t.Fatalf("Expected a policy per repository, got %+v", policies)
+	}
+	if len(policies[0].Warnings) != 0 {
+		t.Errorf("Expected no warnings for repo0, got %v", policies[0].Warnings)
+	}
+	if len(policies[1].Warnings) != 1 || !strings.Contains(policies[1].Warnings[0], "2 -keep-list entries") {
+		t.Errorf("Expected the keep-list entries of repo1 to be reported, got %v", policies[1].Warnings)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLifecyclePolicies(&buf, policies); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var decoded struct {
+		Repositories []struct {
+			Name   string          `json:"name"`
+			Policy json.RawMessage `json:"lifecycle_policy"`
+		} `json:"repositories"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	expected := `{"rules":[{"rulePriority":1,"description":"Expire images older than 14 days","selection":{"tagStatus":"any","countType":"sinceImagePushed","countUnit":"days","countNumber":14},"action":{"type":"expire"}}]}`
+	var compact bytes.Buffer
+	json.Compact(&compact, decoded.Repositories[0].Policy)
+	if compact.String() != expected {
+		t.Errorf("Expected policy %s, got %s", expected, compact.String())
+	}
+}
+
+// TestManageLifecyclePolicies tests putting lifecycle policies instead of deleting images
+func TestManageLifecyclePolicies(t *testing.T) {
+	upToDate := `{
+  "rules": [{"rulePriority": 1, "description": "Expire images older than 14 days", "action": {"type": "expire"},
+    "selection": {"tagStatus": "any", "countType": "sinceImagePushed", "countUnit": "days", "countNumber": 14}}]
+}`
+	drifted := `{"rules":[{"rulePriority":1,"selection":{"tagStatus":"any","countType":"imageCountMoreThan","countNumber":100},"action":{"type":"expire"}}]}`
+
+	t.Run("Puts missing and drifted policies", func(t *testing.T) {
+		client := newGuardrailClient(3, 5)
+		policyClient := &mockLifecycleClient{policies: map[string]string{"repo1": upToDate, "repo2": drifted}}
+
+		summary, err := manageLifecyclePolicies(context.Background(), client, policyClient, Config{Days: 14})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if strings.Join(policyClient.puts, ",") != "repo0,repo2" {
+			t.Errorf("Expected policies put on repo0 and repo2, got %v", policyClient.puts)
+		}
+		if !sameLifecyclePolicy(policyClient.policies["repo2"], upToDate) {
+			t.Errorf("Expected the drifted policy replaced, got %s", policyClient.policies["repo2"])
+		}
+		if summary.RepositoriesProcessed != 3 || client.BatchDeleteImageCalls != 0 || client.ListImagesCalls != 0 {
+			t.Errorf("Expected every repository processed without scanning or deleting images, got %+v", summary)
+		}
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		policyClient := &mockLifecycleClient{policies: map[string]string{"repo0": drifted}}
+
+		if _, err := manageLifecyclePolicies(context.Background(), newGuardrailClient(1, 0), policyClient, Config{Days: 14, DryRun: true}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(policyClient.puts) != 0 {
+			t.Errorf("Expected no policies put in a dry run, got %v", policyClient.puts)
+		}
+	})
+
+	t.Run("Retention without a policy", func(t *testing.T) {
+		policyClient := &mockLifecycleClient{policies: map[string]string{"repo0": drifted}}
+
+		if _, err := manageLifecyclePolicies(context.Background(), newGuardrailClient(1, 0), policyClient, Config{Days: 14, MaxImages: 5}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(policyClient.puts) != 0 || policyClient.policies["repo0"] != drifted {
+			t.Error("Expected the existing policy left unchanged")
+		}
+	})
+
+	t.Run("Put error", func(t *testing.T) {
+		policyClient := &mockLifecycleClient{policies: map[string]string{}, putError: errors.New("access denied")}
+
+		summary, err := manageLifecyclePolicies(context.Background(), newGuardrailClient(2, 0), policyClient, Config{Days: 14})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(summary.failedRepositories()) != 2 || exitCode(summary) != 2 {
+			t.Errorf("Expected both repositories to fail, got %+v", summary.Repositories)
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// This file contains -lock-s3 and -lock-table, a lock that keeps two runs
+// from cleaning up the same registry at the same time, e.g. when a slow
+// nightly run overlaps the next one or several schedulers cover the same
+// accounts. Each account and region has its own lock, an S3 object or a
+// DynamoDB item created with a conditional write so that only one run can
+// hold it. A run that finds a registry locked leaves it to the holder and
+// moves on, like a region that fails. A lock left behind by a run that
+// crashed expires after -lock-ttl.
+//
+// The -lock-table table needs the string partition key lock_id. Its items
+// carry their expiry in the number attribute expires_at, which can be the
+// table's TTL attribute so that DynamoDB removes expired locks too. While
+// the run holds an item it extends expires_at every third of -lock-ttl, so
+// a run that outlasts -lock-ttl keeps its lock.
+
+// defaultLockTTL is how long a lock is held unless -lock-ttl says otherwise
+const defaultLockTTL = 12 * time.Hour
+
+// errRegistryLocked means another run holds the lock of a registry
+var errRegistryLocked = errors.New("registry is locked by another run")
+
+// S3LockClient defines the S3 operations needed to take and release locks
+type S3LockClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// DynamoDBLockClient defines the DynamoDB operations needed to take and
+// release locks
+type DynamoDBLockClient interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// lockHolder is the content of a lock object
+type lockHolder struct {
+	RunID      string    `json:"run_id"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// runLocks takes the locks of the registries a run cleans up, in the
+// -lock-s3 location or the -lock-table table. A nil runLocks takes none,
+// so callers don't need to check whether either is set.
+type runLocks struct {
+	client   S3LockClient
+	location s3Location
+	dynamoDB DynamoDBLockClient
+	table    string
+	account  string // the caller's account, for runs that don't assume roles
+	ttl      time.Duration
+	holder   lockHolder
+	now      func() time.Time
+}
+
+// newRunLocks sets up the locks of the run's -lock-s3 location or
+// -lock-table table, if any
+func newRunLocks(client S3LockClient, dynamoDB DynamoDBLockClient, cfg Config, account string) (*runLocks, error) {
+	if cfg.LockS3 == "" && cfg.LockTable == "" {
+		return nil, nil
+	}
+	var location s3Location
+	if cfg.LockS3 != "" {
+		var err error
+		if location, err = parseS3URI(cfg.LockS3); err != nil {
+			return nil, err
+		}
+	}
+
+	ttl := cfg.LockTTL
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	host, _ := os.Hostname()
+	return &runLocks{
+		client:   client,
+		location: location,
+		dynamoDB: dynamoDB,
+		table:    cfg.LockTable,
+		account:  account,
+		ttl:      ttl,
+		holder:   lockHolder{RunID: newRunID(), Host: host},
+		now:      time.Now,
+	}, nil
+}
+
+// key returns the key of a registry's lock object
+func (l *runLocks) key(accountID, region string) string {
+	if accountID == "" {
+		accountID = l.account
+	}
+	return fmt.Sprintf("%s%s/%s.lock", l.location.Prefix, accountID, region)
+}
+
+// acquire takes the lock of a registry, or of a registry whose lock expired,
+// and returns the function that releases it
+func (l *runLocks) acquire(ctx context.Context, accountID, region string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.table != "" {
+		return l.acquireItem(ctx, accountID, region)
+	}
+
+	key := l.key(accountID, region)
+	holder := l.holder
+	holder.AcquiredAt = l.now().UTC()
+	holder.ExpiresAt = holder.AcquiredAt.Add(l.ttl)
+	body, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	put := &s3.PutObjectInput{
+		Bucket:      aws.String(l.location.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+		IfNoneMatch: aws.String("*"),
+	}
+	resp, err := l.client.PutObject(ctx, put)
+	if isPreconditionFailed(err) {
+		// Take over the lock of a run that never released it
+		current, etag, readErr := l.read(ctx, key)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read lock s3://%s/%s: %w", l.location.Bucket, key, readErr)
+		}
+		if l.now().Before(current.ExpiresAt) {
+			return nil, fmt.Errorf("%w: run %s on %s holds it since %s until %s", errRegistryLocked,
+				current.RunID, current.Host, current.AcquiredAt.Format(time.RFC3339), current.ExpiresAt.Format(time.RFC3339))
+		}
+		slog.Warn("Taking over expired lock", "key", key, "run_id", current.RunID, "host", current.Host, "expired_at", current.ExpiresAt)
+		put.Body, put.IfNoneMatch, put.IfMatch = bytes.NewReader(body), nil, etag
+		resp, err = l.client.PutObject(ctx, put)
+		if isPreconditionFailed(err) {
+			return nil, fmt.Errorf("%w: another run took over the expired lock first", errRegistryLocked)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take lock s3://%s/%s: %w", l.location.Bucket, key, err)
+	}
+	slog.Info("Locked registry", "key", key, "run_id", holder.RunID, "expires_at", holder.ExpiresAt)
+
+	return func() {
+		// Release the lock even when the run was stopped or timed out, but
+		// not a lock another run took over after this one's expired
+		ctx := context.WithoutCancel(ctx)
+		_, err := l.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(l.location.Bucket), Key: aws.String(key), IfMatch: resp.ETag})
+		if isPreconditionFailed(err) {
+			slog.Warn("Lock expired and was taken over by another run", "key", key)
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to release lock; it expires on its own", "key", key, "expires_at", holder.ExpiresAt, "error", err)
+			return
+		}
+		slog.Info("Released registry lock", "key", key)
+	}, nil
+}
+
+// lockID returns the partition key of a registry's lock item
+func (l *runLocks) lockID(accountID, region string) string {
+	if accountID == "" {
+		accountID = l.account
+	}
+	return accountID + "/" + region
+}
+
+// acquireItem takes the lock item of a registry in the -lock-table table.
+// One conditional PutItem both creates a missing lock and takes over an
+// expired one, so two runs can't both win. The lock is extended until it is
+// released.
+func (l *runLocks) acquireItem(ctx context.Context, accountID, region string) (func(), error) {
+	id := l.lockID(accountID, region)
+	holder := l.holder
+	holder.AcquiredAt = l.now().UTC()
+	holder.ExpiresAt = holder.AcquiredAt.Add(l.ttl)
+
+	_, err := l.dynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]dynamodbtypes.AttributeValue{
+			"lock_id":     &dynamodbtypes.AttributeValueMemberS{Value: id},
+			"run_id":      &dynamodbtypes.AttributeValueMemberS{Value: holder.RunID},
+			"host":        &dynamodbtypes.AttributeValueMemberS{Value: holder.Host},
+			"acquired_at": &dynamodbtypes.AttributeValueMemberS{Value: holder.AcquiredAt.Format(time.RFC3339)},
+			"expires_at":  &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprint(holder.ExpiresAt.Unix())},
+		},
+		ConditionExpression:                 aws.String("attribute_not_exists(lock_id) OR expires_at < :now"),
+		ExpressionAttributeValues:           map[string]dynamodbtypes.AttributeValue{":now": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprint(holder.AcquiredAt.Unix())}},
+		ReturnValuesOnConditionCheckFailure: dynamodbtypes.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var conflict *dynamodbtypes.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		current := lockItemHolder(conflict.Item)
+		return nil, fmt.Errorf("%w: run %s on %s holds it since %s until %s", errRegistryLocked,
+			current.RunID, current.Host, current.AcquiredAt.Format(time.RFC3339), current.ExpiresAt.Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take lock %s in table %s: %w", id, l.table, err)
+	}
+	slog.Info("Locked registry", "lock_id", id, "table", l.table, "run_id", holder.RunID, "expires_at", holder.ExpiresAt)
+	stopHeartbeat := l.heartbeat(ctx, id, holder.RunID)
+
+	return func() {
+		stopHeartbeat()
+
+		// Release the lock even when the run was stopped or timed out, but
+		// not a lock another run took over after this one's expired
+		ctx := context.WithoutCancel(ctx)
+		_, err := l.dynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName:                 aws.String(l.table),
+			Key:                       map[string]dynamodbtypes.AttributeValue{"lock_id": &dynamodbtypes.AttributeValueMemberS{Value: id}},
+			ConditionExpression:       aws.String("run_id = :run"),
+			ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{":run": &dynamodbtypes.AttributeValueMemberS{Value: holder.RunID}},
+		})
+		if errors.As(err, &conflict) {
+			slog.Warn("Lock expired and was taken over by another run", "lock_id", id, "table", l.table)
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to release lock; it expires on its own", "lock_id", id, "table", l.table, "expires_at", holder.ExpiresAt, "error", err)
+			return
+		}
+		slog.Info("Released registry lock", "lock_id", id, "table", l.table)
+	}, nil
+}
+
+// heartbeat extends the expiry of a lock item every third of -lock-ttl
+// while its run holds it, and returns the function that stops it. The
+// update is conditioned on the run still holding the lock, so a lock that
+// expired and was taken over stays with its new holder.
+func (l *runLocks) heartbeat(ctx context.Context, id, runID string) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			expires := l.now().UTC().Add(l.ttl)
+			_, err := l.dynamoDB.UpdateItem(context.WithoutCancel(ctx), &dynamodb.UpdateItemInput{
+				TableName:           aws.String(l.table),
+				Key:                 map[string]dynamodbtypes.AttributeValue{"lock_id": &dynamodbtypes.AttributeValueMemberS{Value: id}},
+				UpdateExpression:    aws.String("SET expires_at = :expires"),
+				ConditionExpression: aws.String("run_id = :run"),
+				ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+					":expires": &dynamodbtypes.AttributeValueMemberN{Value: fmt.Sprint(expires.Unix())},
+					":run":     &dynamodbtypes.AttributeValueMemberS{Value: runID},
+				},
+			})
+			var conflict *dynamodbtypes.ConditionalCheckFailedException
+			if errors.As(err, &conflict) {
+				slog.Warn("Lock expired and was taken over by another run", "lock_id", id, "table", l.table)
+				return
+			}
+			if err != nil {
+				slog.Warn("Failed to extend lock; retrying on the next heartbeat", "lock_id", id, "table", l.table, "error", err)
+				continue
+			}
+			slog.Debug("Extended registry lock", "lock_id", id, "table", l.table, "expires_at", expires)
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// lockItemHolder reads the holder of a lock item
+func lockItemHolder(item map[string]dynamodbtypes.AttributeValue) lockHolder {
+	var holder lockHolder
+	if v, ok := item["run_id"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		holder.RunID = v.Value
+	}
+	if v, ok := item["host"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		holder.Host = v.Value
+	}
+	if v, ok := item["acquired_at"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		holder.AcquiredAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if v, ok := item["expires_at"].(*dynamodbtypes.AttributeValueMemberN); ok {
+		if seconds, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			holder.ExpiresAt = time.Unix(seconds, 0).UTC()
+		}
+	}
+	return holder
+}
+
+// read reads the holder of a lock along with the object's ETag
+func (l *runLocks) read(ctx context.Context, key string) (lockHolder, *string, error) {
+	resp, err := l.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(l.location.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return lockHolder{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	var holder lockHolder
+	if err := json.NewDecoder(resp.Body).Decode(&holder); err != nil {
+		return lockHolder{}, nil, fmt.Errorf("invalid lock: %w", err)
+	}
+	return holder, resp.ETag, nil
+}
+
+// isPreconditionFailed reports whether a conditional write lost to another
+// writer
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
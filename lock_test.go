@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// mockLockBucket is an S3 bucket that honors conditional writes
+type mockLockBucket struct {
+	objects map[string][]byte
+	etags   map[string]string
+	writes  int
+}
+
+func newMockLockBucket() *mockLockBucket {
+	return &mockLockBucket{objects: make(map[string][]byte), etags: make(map[string]string)}
+}
+
+func (m *mockLockBucket) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	_, exists := m.objects[key]
+	if (params.IfNoneMatch != nil && exists) || (params.IfMatch != nil && aws.ToString(params.IfMatch) != m.etags[key]) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+	}
+	body, _ := io.ReadAll(params.Body)
+	m.writes++
+	m.objects[key] = body
+	m.etags[key] = fmt.Sprintf(`"%d"`, m.writes)
+	return &s3.PutObjectOutput{ETag: aws.String(m.etags[key])}, nil
+}
+
+func (m *mockLockBucket) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	body, ok := m.objects[key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body)), ETag: aws.String(m.etags[key])}, nil
+}
+
+func (m *mockLockBucket) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	if params.IfMatch != nil && aws.ToString(params.IfMatch) != m.etags[key] {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	delete(m.objects, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// newTestRunLocks sets up a run's locks in the bucket
+func newTestRunLocks(t *testing.T, bucket *mockLockBucket) *runLocks {
+	t.Helper()
+	locks, err := newRunLocks(bucket, nil, Config{LockS3: "s3://locks/ecr-cleanup", LockTTL: time.Hour}, "123456789012")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return locks
+}
+
+// TestRunLocks tests that only one run at a time holds a registry's lock
+func TestRunLocks(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMockLockBucket()
+	first, second := newTestRunLocks(t, bucket), newTestRunLocks(t, bucket)
+
+	release, err := first.acquire(ctx, "", "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected the lock to be taken, got %v", err)
+	}
+	if _, ok := bucket.objects["ecr-cleanup/123456789012/us-east-1.lock"]; !ok {
+		t.Errorf("Expected the lock under the caller's account, got %v", bucket.objects)
+	}
+
+	if _, err := second.acquire(ctx, "", "us-east-1"); !errors.Is(err, errRegistryLocked) {
+		t.Errorf("Expected the registry to be locked, got %v", err)
+	}
+	// Other registries are free
+	releaseOther, err := second.acquire(ctx, "210987654321", "us-east-1")
+	if err != nil {
+		t.Errorf("Expected another account's lock to be free, got %v", err)
+	}
+	releaseOther()
+
+	release()
+	release, err = second.acquire(ctx, "", "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected the released lock to be taken, got %v", err)
+	}
+	release()
+
+	var none *runLocks
+	release, err = none.acquire(ctx, "", "us-east-1")
+	if err != nil {
+		t.Errorf("Expected no lock without -lock-s3, got %v", err)
+	}
+	release()
+}
+
+// TestRunLocksExpired tests taking over the lock of a run that never
+// released it
+func TestRunLocksExpired(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMockLockBucket()
+	crashed, next := newTestRunLocks(t, bucket), newTestRunLocks(t, bucket)
+
+	releaseCrashed, err := crashed.acquire(ctx, "", "eu-west-1")
+	if err != nil {
+		t.Fatalf("Expected the lock to be taken, got %v", err)
+	}
+
+	next.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	release, err := next.acquire(ctx, "", "eu-west-1")
+	if err != nil {
+		t.Fatalf("Expected the expired lock to be taken over, got %v", err)
+	}
+	defer release()
+
+	holder, _, err := next.read(ctx, "ecr-cleanup/123456789012/eu-west-1.lock")
+	if err != nil || holder.RunID != next.holder.RunID {
+		t.Errorf("Expected the lock to name the new run, got %+v, %v", holder, err)
+	}
+
+	// The run that lost its lock doesn't release the new holder's
+	releaseCrashed()
+	if _, ok := bucket.objects["ecr-cleanup/123456789012/eu-west-1.lock"]; !ok {
+		t.Error("Expected the new holder's lock to be kept")
+	}
+}
+
+// mockLockTable is a DynamoDB table that evaluates the lock conditions
+type mockLockTable struct {
+	mu      sync.Mutex
+	items   map[string]map[string]dynamodbtypes.AttributeValue
+	updates int
+}
+
+func (m *mockLockTable) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := params.Item["lock_id"].(*dynamodbtypes.AttributeValueMemberS).Value
+	if current, ok := m.items[id]; ok {
+		now := params.ExpressionAttributeValues[":now"].(*dynamodbtypes.AttributeValueMemberN).Value
+		if expires := current["expires_at"].(*dynamodbtypes.AttributeValueMemberN).Value; expires >= now {
+			return nil, &dynamodbtypes.ConditionalCheckFailedException{Message: aws.String("The conditional request failed"), Item: current}
+		}
+	}
+	m.items[id] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockLockTable) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := params.Key["lock_id"].(*dynamodbtypes.AttributeValueMemberS).Value
+	run := params.ExpressionAttributeValues[":run"].(*dynamodbtypes.AttributeValueMemberS).Value
+	current, ok := m.items[id]
+	if !ok || current["run_id"].(*dynamodbtypes.AttributeValueMemberS).Value != run {
+		return nil, &dynamodbtypes.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	current["expires_at"] = params.ExpressionAttributeValues[":expires"]
+	m.updates++
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// updated returns how many times a lock was extended
+func (m *mockLockTable) updated() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updates
+}
+
+func (m *mockLockTable) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := params.Key["lock_id"].(*dynamodbtypes.AttributeValueMemberS).Value
+	run := params.ExpressionAttributeValues[":run"].(*dynamodbtypes.AttributeValueMemberS).Value
+	current, ok := m.items[id]
+	if !ok || current["run_id"].(*dynamodbtypes.AttributeValueMemberS).Value != run {
+		return nil, &dynamodbtypes.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	delete(m.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// TestRunLocksDynamoDB tests locking registries with items of a DynamoDB
+// table, taking over the expired lock of a run that crashed
+func TestRunLocksDynamoDB(t *testing.T) {
+	ctx := context.Background()
+	table := &mockLockTable{items: make(map[string]map[string]dynamodbtypes.AttributeValue)}
+	newLocks := func() *runLocks {
+		locks, err := newRunLocks(nil, table, Config{LockTable: "ecr-cleanup-locks", LockTTL: time.Hour}, "123456789012")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return locks
+	}
+	crashed, next := newLocks(), newLocks()
+
+	releaseCrashed, err := crashed.acquire(ctx, "", "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected the lock to be taken, got %v", err)
+	}
+	if _, ok := table.items["123456789012/us-east-1"]; !ok {
+		t.Errorf("Expected the lock under the caller's account, got %v", table.items)
+	}
+	if _, err := next.acquire(ctx, "", "us-east-1"); !errors.Is(err, errRegistryLocked) || !strings.Contains(err.Error(), crashed.holder.RunID) {
+		t.Errorf("Expected the registry to be locked by the first run, got %v", err)
+	}
+
+	next.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	release, err := next.acquire(ctx, "", "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected the expired lock to be taken over, got %v", err)
+	}
+	if holder := lockItemHolder(table.items["123456789012/us-east-1"]); holder.RunID != next.holder.RunID {
+		t.Errorf("Expected the lock to name the new run, got %+v", holder)
+	}
+
+	// The run that lost its lock doesn't release the new holder's
+	releaseCrashed()
+	if _, ok := table.items["123456789012/us-east-1"]; !ok {
+		t.Error("Expected the new holder's lock to be kept")
+	}
+	release()
+	if len(table.items) != 0 {
+		t.Errorf("Expected the lock to be released, got %v", table.items)
+	}
+}
+
+// TestRunLocksDynamoDBHeartbeat tests extending a lock item while its run
+// holds it, and no longer once it is released
+func TestRunLocksDynamoDBHeartbeat(t *testing.T) {
+	table := &mockLockTable{items: make(map[string]map[string]dynamodbtypes.AttributeValue)}
+	locks, err := newRunLocks(nil, table, Config{LockTable: "ecr-cleanup-locks", LockTTL: 30 * time.Millisecond}, "123456789012")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	release, err := locks.acquire(context.Background(), "", "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected the lock to be taken, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for table.updated() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	release()
+	if table.updated() == 0 {
+		t.Fatal("Expected the lock to be extended while it is held")
+	}
+
+	updates := table.updated()
+	time.Sleep(50 * time.Millisecond)
+	if table.updated() != updates {
+		t.Error("Expected no extension once the lock is released")
+	}
+}
+
+// TestLockTableFormerName tests that -lock-dynamodb still sets -lock-table
+func TestLockTableFormerName(t *testing.T) {
+	for _, name := range []string{"-lock-table", "-lock-dynamodb"} {
+		cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{name, "ecr-cleanup-locks"})
+		if err != nil || cfg.LockTable != "ecr-cleanup-locks" {
+			t.Errorf("Expected %s to set the lock table, got %q and %v", name, cfg.LockTable, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRotatingFile tests rotating the log file by size and removing the
+// rotated files past the limits
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "ecr-cleanup.log")
+	f, err := openRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer f.Close()
+	now := time.Date(2025, 5, 13, 14, 32, 33, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Minute)
+		if _, err := f.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// The first write fits, every later one rotates; two backups are kept
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(backups) != 2 || filepath.Base(backups[0].path) != "ecr-cleanup-2025-05-13T14-36-33.000.log" || filepath.Base(backups[1].path) != "ecr-cleanup-2025-05-13T14-35-33.000.log" {
+		t.Errorf("Unexpected backups %+v", backups)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "0123456789" {
+		t.Errorf("Expected the last write in the current file, got %q", data)
+	}
+
+	// Backups older than the age limit are removed on the next rotation
+	f.maxBackups, f.maxAge = 0, 90*time.Second
+	now = now.Add(time.Minute)
+	f.Write([]byte("0123456789"))
+	if backups, _ := f.backups(); len(backups) != 2 || !backups[1].rotated.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected the backups within 90s, got %+v", backups)
+	}
+
+	if _, err := openRotatingFile(path, -1, 0, 0); err == nil {
+		t.Error("Expected an error for a negative size")
+	}
+}
+
+// TestSetupLoggingFile tests writing the logs to -log-file as well
+func TestSetupLoggingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ecr-cleanup.log")
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	cfg := Config{LogLevel: "info", LogFormat: "json", NoProgress: true, LogFile: path, LogMaxSizeMB: 1}
+	if err := setupLogging(cfg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer func() { activeLogFile.Close(); activeLogFile = nil }()
+	slog.Info("Deleted images", "action", "delete", "images", 3)
+	slog.Debug("Not logged")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the log file, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"msg":"Deleted images"`) || !strings.Contains(lines[0], `"images":3`) {
+		t.Errorf("Unexpected log file %q", data)
+	}
+}
+
+// TestTeeHandler tests passing records to every handler taking their level
+func TestTeeHandler(t *testing.T) {
+	var info, warn bytes.Buffer
+	logger := slog.New(teeHandler{
+		slog.NewTextHandler(&info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(&warn, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	}).With("run", "r1")
+	logger.Info("Processing repository")
+	logger.Warn("Throttled")
+
+	if strings.Count(info.String(), "run=r1") != 2 || strings.Count(warn.String(), "\n") != 1 || !strings.Contains(warn.String(), "Throttled") {
+		t.Errorf("Unexpected output:\n%s\n%s", info.String(), warn.String())
+	}
+}
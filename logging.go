@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// This file contains the logging setup. Every record is structured, with
+// fields such as repository, digest and action, so the logs can be queried
+// in tools like CloudWatch Logs Insights.
+
+// newLogHandler creates a text or JSON handler for the given level name
+func newLogHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+}
+
+// parseLogLevel parses a level name such as info
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+	return lvl, nil
+}
+
+// setupLogging installs the logger configured by -log-level and -log-format.
+// When stderr is a terminal it also enables the progress line, which the
+// logger writes through, and colors the text logs. With -log-file the logs
+// are written to the file too.
+func setupLogging(cfg Config) error {
+	bar := newProgressBar(cfg, os.Stderr)
+
+	var out io.Writer = os.Stderr
+	if bar != nil {
+		out = bar
+	}
+
+	handler, err := newLogHandler(out, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return err
+	}
+	if useColor(cfg, os.Stderr) {
+		lvl, _ := parseLogLevel(cfg.LogLevel)
+		handler = newConsoleHandler(out, lvl)
+	}
+
+	// A run started again in the same process replaces the previous file
+	if activeLogFile != nil {
+		activeLogFile.Close()
+		activeLogFile = nil
+	}
+	if cfg.LogFile != "" {
+		file, err := openRotatingFile(cfg.LogFile, int64(cfg.LogMaxSizeMB)*1024*1024, time.Duration(cfg.LogMaxAge)*24*time.Hour, cfg.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		fileHandler, _ := newLogHandler(file, cfg.LogLevel, cfg.LogFormat)
+		handler = teeHandler{handler, fileHandler}
+		activeLogFile = file
+	}
+	slog.SetDefault(slog.New(handler))
+	activeProgress = bar
+	return nil
+}
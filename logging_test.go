@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestNewLogHandler tests parsing the logging options
+func TestNewLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+
+	handler, err := newLogHandler(&buf, "WARN", "json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	logger := slog.New(handler)
+	logger.Info("hidden")
+	logger.Warn("shown", "repository", "repo1")
+
+	if strings.Contains(buf.String(), "hidden") {
+		t.Error("Expected info records to be filtered at warn level")
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q", buf.String())
+	}
+	if record["msg"] != "shown" || record["repository"] != "repo1" {
+		t.Errorf("Unexpected record: %v", record)
+	}
+
+	if _, err := newLogHandler(&buf, "verbose", "text"); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+	if _, err := newLogHandler(&buf, "info", "xml"); err == nil {
+		t.Error("Expected an error for an invalid format")
+	}
+}
+
+// TestDryRunLogFields tests that dry-run records carry the image fields
+func TestDryRunLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := newLogHandler(&buf, "info", "json")
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	defer slog.SetDefault(previous)
+
+	mockClient := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+			Repositories: []types.Repository{{RepositoryName: aws.String("repo1")}},
+		},
+		ListImagesOutput: &ecr.ListImagesOutput{
+			ImageIds: []types.ImageIdentifier{{ImageTag: aws.String("v1")}},
+		},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{
+			ImageDetails: []types.ImageDetail{{
+				ImageDigest:      aws.String("sha256:111"),
+				ImageTags:        []string{"v1"},
+				ImagePushedAt:    aws.Time(time.Now().AddDate(0, 0, -30)),
+				ImageSizeInBytes: aws.Int64(1000),
+			}},
+		},
+	}
+
+	if _, err := CleanupWithClient(context.Background(), Config{Days: 10, DryRun: true}, mockClient); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected JSON records, got %q", line)
+		}
+		if record["action"] != "would-delete" {
+			continue
+		}
+		if record["repository"] != "repo1" || record["digest"] != "sha256:111" || record["tag"] != "v1" || record["size_bytes"] != float64(1000) {
+			t.Errorf("Unexpected dry-run record: %v", record)
+		}
+		return
+	}
+	t.Errorf("Expected a dry-run record, got:\n%s", buf.String())
+}
@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ECRClient defines an interface for ECR operations
@@ -25,87 +33,1140 @@ type ECRClient interface {
 
 // Config holds the application configuration
 type Config struct {
-	DryRun    bool
-	Days      int
-	Region    string
-	MaxImages int
+	DryRun     bool
+	Yes        bool
+	Days       int
+	Region     string
+	MaxImages  int
+	MinKeep    int
+	KeepNewest bool
+	Profile    string
+
+	// KeepCleanImage keeps the newest image without critical or high scan
+	// findings, however old
+	KeepCleanImage bool
+
+	// KeepPerTagFamily keeps the newest image of every tag family, however
+	// old; TagFamilyPattern finds the family of a tag
+	KeepPerTagFamily bool
+	TagFamilyPattern string
+
+	// OlderThan and Before replace -days with a duration or an absolute
+	// cutoff; at most one of them is set
+	OlderThan time.Duration
+	Before    time.Time
+
+	// PushedAfter and PushedBefore replace the age limit with a range of
+	// push times; either may be unset
+	PushedAfter  time.Time
+	PushedBefore time.Time
+
+	// NeverPulledDays deletes images that were never pulled this many days
+	// after their push, however new (0 disables)
+	NeverPulledDays int
+
+	// HonorExpiryLabels deletes images past the expiry their ExpiryLabel
+	// or quay.expires-after label declares, however new
+	HonorExpiryLabels bool
+	ExpiryLabel       string
+
+	// Concurrency is the number of repositories processed in parallel
+	Concurrency int
+
+	// Deletion limits
+	MaxDeletions            int
+	MaxDeletionsPerRepo     int
+	MaxDeletePercent        int
+	MaxDeletePercentPerRepo int
+	Force                   bool
+
+	// MaxFailurePercent aborts the run once more than this percentage of
+	// its image deletions failed (0 never aborts)
+	MaxFailurePercent int
+
+	// FailOnError aborts the run at the first repository error
+	FailOnError bool
+
+	// Timeout is the deadline of the whole run
+	Timeout time.Duration
+
+	// Window is the maintenance window outside which nothing is deleted
+	Window string
+
+	// Candidate retention values compared by the simulate command
+	SimulateDays      intList
+	SimulateMaxImages intList
+
+	// Thresholds the compliance command checks repositories against, and
+	// whether it imports the results into Security Hub
+	ComplianceMaxSizeGB              float64
+	ComplianceMaxAgeDays             int
+	ComplianceRequireLifecyclePolicy bool
+	SecurityHub                      bool
+
+	// Enforce makes the coverage command put the lifecycle policy of the
+	// retention flags on the repositories without a retention
+	Enforce bool
+
+	// ManageLifecyclePolicies puts lifecycle policies instead of deleting images
+	ManageLifecyclePolicies bool
+
+	// Storage budget
+	TargetRepoSizeGB float64
+	TargetTotalGB    float64
+
+	// StoragePrice is the ECR storage price in USD per GB-month
+	StoragePrice float64
+
+	// Image scanning
+	DescribeConcurrency int
+	SkipListImages      bool
+
+	// API pacing and throttling retries
+	APIRate             float64
+	ThrottleMaxAttempts int
+
+	// Deletion pacing: images per BatchDeleteImage call (0 means the
+	// maximum) and the pause between two batches of a repository
+	DeleteBatchSize  int
+	DeleteBatchDelay time.Duration
+
+	// Repositories limits the run to these repositories; empty means all
+	Repositories []string
+
+	// ReposFrom is a file, or - for stdin, listing more repositories to
+	// limit the run to
+	ReposFrom string
+
+	// Multi-region
+	Regions    []string
+	AllRegions bool
+
+	// Public cleans up ECR Public repositories instead of private ones
+	Public bool
+
+	// Where the AWS clients connect: a single endpoint for every service or
+	// the FIPS and dual-stack endpoints, an HTTP proxy and extra certificate
+	// authorities
+	EndpointURL string
+	FIPS        bool
+	DualStack   bool
+	ProxyURL    string
+	CABundle    string
+
+	// Retryer of the AWS clients; empty and 0 keep the SDK's defaults
+	AWSRetryMode   string
+	AWSMaxAttempts int
+
+	// Role assumption
+	RoleArn         string
+	ExternalID      string
+	RoleSessionName string
+
+	// Multi-account
+	AssumeRolesFile string
+	OrgMode         bool
+	OrgRoleName     string
+	OrgSkipTag      string
+
+	// RegistryID is the account whose registry is cleaned up, when it
+	// isn't the caller's
+	RegistryID string
+
+	// ConfigFile is the YAML file of the settings that don't fit on a
+	// command line, such as the in-use providers
+	ConfigFile string
+
+	// PolicyName is the named policy of the -config file the run uses
+	PolicyName string
+
+	// In-use protection
+	ProtectAppRunner bool
+	ProtectBatch     bool
+
+	// Directory or Git repository of deployment manifests whose images are
+	// never deleted, and the Git branch or tag to read
+	ProtectDeployments    string
+	ProtectDeploymentsRef string
+
+	// Argo CD server whose applications' images are never deleted, and the
+	// API token to list them with
+	ArgoCDServer string
+	ArgoCDToken  string
+
+	// Keep-list of images that are never deleted
+	KeepListFile string
+
+	// ProtectTags are the tags that pin an image so it's never deleted
+	ProtectTags []string
+
+	// ArchiveTo is where images are copied before they are deleted
+	ArchiveTo string
+
+	// ManifestsS3 is where the manifests of deleted images are exported
+	ManifestsS3 string
+
+	// AuditFile is the file every decision about an image is appended to
+	AuditFile string
+
+	// StateFile records the repositories a run has finished, so an
+	// interrupted run can resume
+	StateFile string
+
+	// RetryFile queues the deletions that failed, which the next run
+	// reattempts
+	RetryFile string
+
+	// LockS3 or LockTable is where the locks that keep two runs off the
+	// same registry are kept, and LockTTL how long a lock lasts if its run
+	// never releases it
+	LockS3    string
+	LockTable string
+	LockTTL   time.Duration
+
+	// PreDeleteHook is run for each selected image and can veto its deletion
+	PreDeleteHook string
+
+	// DeleteByTag deletes tagged images by their first tag rather than by
+	// digest, as older versions did
+	DeleteByTag bool
+
+	// UntagOnly removes the tags of selected images instead of deleting them
+	UntagOnly bool
+
+	// Preflight probes the ECR permissions of each registry before
+	// anything in it is touched
+	Preflight bool
+
+	// Replication is what to do about the registry's replicas, which ECR
+	// doesn't delete from: ignore, warn, source-only or fan-out
+	Replication string
+
+	// VerifyDeletions lists each repository again after its deletions and
+	// reports the deleted images it still holds
+	VerifyDeletions bool
+
+	// QuarantineDays tags selected images and only deletes them once they
+	// have been quarantined this many days (0 deletes them right away)
+	QuarantineDays int
+
+	// Empty repositories older than EmptyRepoDays are deleted with
+	// DeleteEmptyRepos
+	DeleteEmptyRepos bool
+	EmptyRepoDays    int
+
+	// Pull-through cache repositories
+	PullThroughDays      int
+	PullThroughMaxImages int
+	SkipPullThrough      bool
+
+	// Per-repository retention from ecr-cleanup/* repository tags, and
+	// the tag (key=value, or key for any value) a repository needs to be
+	// cleaned up at all
+	TagPolicies bool
+	OptInTag    string
+
+	// Per-repository retention from the pulls of the last PullActivityDays:
+	// repositories pulled HotPullCount times keep images HotDays, and
+	// those never pulled ColdDays (0 keeps the retention)
+	PullActivityDays int
+	HotPullCount     int
+	HotDays          int
+	ColdDays         int
+
+	// Reports
+	ReportHTML     string
+	ReportMarkdown string
+	ReportS3       string
+
+	// Run history kept for the trend since the previous run; at most one
+	// is set
+	HistoryS3       string
+	HistoryDynamoDB string
+
+	// CI outputs
+	SummaryFile string
+	GHAOutput   bool
+
+	// Notifications
+	SNSTopicArn    string
+	EventBus       string
+	WebhookURL     string
+	WebhookHeaders []string
+	WebhookRetries int
+	PostRunHook    string
+
+	// Thresholds a run must reach to be announced over SNS or the webhook;
+	// zero disables a threshold
+	NotifyMinFailures  int
+	NotifyMinDeletedGB float64
+
+	// Metrics
+	MetricsAddr    string
+	PushgatewayURL string
+
+	// Tracing
+	OTLPEndpoint string
+
+	// Logging
+	LogLevel   string
+	LogFormat  string
+	NoProgress bool
+	NoColor    bool
+	// DebugAWS logs every AWS request and response, credentials redacted
+	DebugAWS bool
+
+	// Log file
+	LogFile       string
+	LogMaxSizeMB  int
+	LogMaxAge     int
+	LogMaxBackups int
+
+	// ShowVersion prints the build metadata instead of running
+	ShowVersion bool
+
+	// Scheduling
+	Schedule       string
+	ScheduleJitter time.Duration
+	HealthAddr     string
+
+	// Time between two scans of the exporter command
+	ExporterInterval time.Duration
+
+	// API server
+	APIAddr  string
+	APIToken string
+
+	// Plan file written by the plan command and read by the apply command
+	PlanFile string
+
+	// Images of the plan that the restore command restores
+	RestoreImages restoreSelection
+
+	// Interactive selection of the images to delete
+	Interactive bool
+
+	// Clock tells the time images are selected and runs are scheduled
+	// against, and Rand draws the schedule jitter; the system's are used
+	// when unset. They are never set from flags.
+	Clock Clock
+	Rand  *rand.Rand
+
+	// inUse holds the images referenced by running workloads; it is
+	// populated at runtime and never set from flags
+	inUse *keepSet
+
+	// inUseProviders are the providers the flags and -config file enable,
+	// and sharedInUse holds the images of those collected once per run;
+	// they are loaded at runtime
+	inUseProviders []InUseProvider
+	sharedInUse    *keepSet
+
+	// deployments holds the images of the -protect-deployments manifests;
+	// it is loaded at runtime
+	deployments *keepSet
+
+	// argoCD holds the images deployed by the -argocd-server applications;
+	// it is loaded at runtime
+	argoCD *keepSet
+
+	// plan, when set, replaces the retention policy: only the planned
+	// images are deleted. accountID and region locate the repositories
+	// being processed in the plan. All three are set at runtime.
+	plan      *Plan
+	accountID string
+	region    string
+
+	// deletions enforces the deletion limits across the whole run; it is
+	// set at runtime
+	deletions *deletionLimits
+
+	// breaker aborts the run when too many deletions fail; it is set at
+	// runtime
+	breaker *failureBreaker
+
+	// keepList holds the -keep-list entries; it is loaded at runtime
+	keepList *keepList
+
+	// pullThrough holds the pull-through cache rules of the region being
+	// processed; it is set at runtime
+	pullThrough *pullThroughCache
+
+	// archive copies images to -archive-to before they are deleted; it is
+	// set at runtime for the region being processed
+	archive *archiver
+
+	// manifests exports the manifests of images before they are deleted;
+	// it is set at runtime
+	manifests *manifestExport
+
+	// audit appends the run's decisions to -audit-file; it is opened at
+	// runtime
+	audit *auditLog
+
+	// checkpoint records progress to -state-file; it is opened at runtime
+	checkpoint *checkpoint
+
+	// retries queues failed deletions to -retry-file; it is opened at
+	// runtime
+	retries *retryQueue
+
+	// locks takes the -lock-s3 or -lock-table lock of each registry; it
+	// is set at runtime
+	locks *runLocks
+
+	// events sends an event to -event-bus for each deleted image; it is
+	// set at runtime
+	events *eventEmitter
+
+	// runReplication holds the replication configuration of every region
+	// of a multi-region run, and replication what -replication needs of
+	// the region being processed; both are set at runtime
+	runReplication map[string]*registryReplication
+	replication    *replicationState
+
+	// immutableTags holds the repositories of the region being processed
+	// whose tags are immutable; it is set at runtime
+	immutableTags map[string]bool
+
+	// artifactManifests reads the manifests of artifacts in the region
+	// being processed, to find the images they belong to; it is set at
+	// runtime
+	artifactManifests ImageManifestClient
+
+	// expiry reads the expiry labels of images of the region being
+	// processed for -honor-expiry-labels; it is set at runtime
+	expiry *expiryReader
+
+	// tags re-tags images of the region being processed for -untag-only
+	// and -quarantine-days;
+	// it is set at runtime
+	tags TagClient
+
+	// repoDeleter deletes empty repositories of the region being
+	// processed; it is set at runtime
+	repoDeleter RepositoryDeleter
+
+	// repoTags reads the tags of repositories of the region being
+	// processed for -tag-policies and -opt-in-tag, and repoPolicies holds
+	// what they set;
+	// both are set at runtime
+	repoTags     RepositoryTagClient
+	repoPolicies *repositoryPolicies
+
+	// pullMetrics reads the pulls of repositories of the region being
+	// processed for -pull-activity-days, and pullActivity holds them; both
+	// are set at runtime
+	pullMetrics  PullMetricsClient
+	pullActivity *pullActivity
+
+	// apiStats counts the run's ECR calls, throttles and retries; it is
+	// set at runtime
+	apiStats *apiStats
+
+	// tracer records the run's spans when -otlp-endpoint is set; it is set
+	// at runtime
+	tracer trace.Tracer
+
+	// stop, when closed, stops the run gracefully; it is set at runtime
+	stop <-chan struct{}
+
+	// stopReason, when set, tells why stop was closed, e.g. because the
+	// maintenance window closed; a nil reason means an interrupt
+	stopReason func() error
+
+	// client, when set, is the ECR client of the whole run instead of the
+	// ones built from the AWS config; MainEntryWithClient sets it
+	client ECRClient
 }
 
 // CleanupSummary tracks the results of the cleanup operation
 type CleanupSummary struct {
-	RepositoriesProcessed int
-	ImagesDeleted         int
-	SpaceFreed            int64 // in bytes
+	RepositoriesProcessed   int
+	RepositoriesDeleted     int // empty repositories, with -delete-empty-repos
+	ImagesScanned           int
+	ImagesDeleted           int
+	SpaceFreed              int64   // in bytes
+	EstimatedMonthlySavings float64 // storage cost of spaceFreedUpperBound, in USD per month
+	SpaceScanned            int64   // in bytes, stored in the repositories before the run
+
+	// ImagesRemaining counts the deleted images -verify-deletions found
+	// still present; they are not counted as deleted
+	ImagesRemaining int
+
+	// Images selected for deletion, quarantined and released; only set on
+	// the result of a single repository, which the aggregator moves into
+	// its RepositorySummary
+	Images      []ImageSummary
+	Quarantined []ImageSummary
+	Released    []ImageSummary
+
+	// Per-repository results
+	Repositories []RepositorySummary
+
+	// Per-region results, only set for multi-region runs
+	Regions []RegionSummary
+
+	// Per-account results, only set for multi-account runs
+	Accounts []AccountSummary
+
+	// Regions and accounts that could not be cleaned up while the rest of
+	// the run went on, as "region us-east-1: error"
+	Failures []string
+
+	// ECR calls of the whole run by operation; only set on the result of
+	// the run
+	APICalls []APICallStats
+}
+
+// RepositorySummary holds the cleanup results for a single repository
+type RepositorySummary struct {
+	AccountID               string
+	Region                  string
+	Name                    string
+	ImagesScanned           int
+	ImagesDeleted           int
+	SpaceFreed              int64   // in bytes
+	EstimatedMonthlySavings float64 // storage cost of SpaceFreed, in USD per month
+	SpaceScanned            int64   // in bytes, stored in the repository before the run
+	Duration                time.Duration
+	Error                   string
+
+	// Deleted is set when the repository itself was deleted for being
+	// empty, or would be in a dry run
+	Deleted bool
+
+	// Images deleted, or in a dry run the images that would be deleted
+	Images []ImageSummary
+
+	// Quarantined are the images -quarantine-days tagged, and Released
+	// those whose quarantine tags it removed, or would in a dry run
+	Quarantined []ImageSummary
+	Released    []ImageSummary
+}
+
+// ImageSummary identifies an image selected for deletion
+type ImageSummary struct {
+	Digest    string
+	Tags      []string
+	PushedAt  time.Time
+	SizeBytes int64
+}
+
+// newImageSummary converts ECR image details into an ImageSummary
+func newImageSummary(img types.ImageDetail) ImageSummary {
+	return ImageSummary{
+		Digest:    aws.ToString(img.ImageDigest),
+		Tags:      append([]string(nil), img.ImageTags...),
+		PushedAt:  aws.ToTime(img.ImagePushedAt),
+		SizeBytes: aws.ToInt64(img.ImageSizeInBytes),
+	}
+}
+
+// qualifiedName returns the repository name prefixed with its account and
+// region, when known
+func (r RepositorySummary) qualifiedName() string {
+	var parts []string
+	for _, part := range []string{r.AccountID, r.Region, r.Name} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// add accumulates the totals and repositories of another summary into this one
+func (s *CleanupSummary) add(other CleanupSummary) {
+	s.RepositoriesProcessed += other.RepositoriesProcessed
+	s.RepositoriesDeleted += other.RepositoriesDeleted
+	s.ImagesScanned += other.ImagesScanned
+	s.ImagesDeleted += other.ImagesDeleted
+	s.SpaceFreed += other.SpaceFreed
+	s.EstimatedMonthlySavings += other.EstimatedMonthlySavings
+	s.SpaceScanned += other.SpaceScanned
+	s.ImagesRemaining += other.ImagesRemaining
+	s.Repositories = append(s.Repositories, other.Repositories...)
+	s.Failures = append(s.Failures, other.Failures...)
 }
 
 // Main application entry point moved to main_wrapper.go
 
-// parseFlags parses command line flags and returns the configuration
+// parseFlags parses command line flags and environment variables and
+// returns the configuration
 func parseFlags() Config {
-	dryRun := flag.Bool("dry-run", false, "Dry run mode (don't actually delete images)")
-	days := flag.Int("days", 10, "Delete images older than this many days")
-	region := flag.String("region", "", "AWS region (defaults to value from AWS config)")
-	maxImages := flag.Int("max-images", 0, "Maximum number of images to keep per repository (0 means no limit)")
+	// flag.CommandLine exits on invalid flags; invalid variables exit the same way
+	cfg, err := parseFlagSet(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(2)
+	}
+	return cfg
+}
 
-	flag.Parse()
+// parseFlagSet defines the flags on fs and parses args, then the ECR_CLEANUP_*
+// environment variables, into a configuration
+func parseFlagSet(fs *flag.FlagSet, args []string) (Config, error) {
+	dryRun := fs.Bool("dry-run", false, "Dry run mode (don't actually delete images)")
+	yes := fs.Bool("yes", false, "Delete without asking for confirmation, even when attached to a terminal")
+	days := fs.Int("days", 10, "Delete images older than this many days")
+	var olderThan ageDuration
+	fs.Var(&olderThan, "older-than", "Delete images older than this duration, e.g. 36h or 45d12h, instead of -days")
+	var before timestamp
+	fs.Var(&before, "before", "Delete images pushed before this time, e.g. 2024-06-01T00:00:00Z, instead of -days")
+	var pushedAfter, pushedBefore timestamp
+	fs.Var(&pushedAfter, "pushed-after", "Only delete images pushed after this time, e.g. 2024-06-01T00:00:00Z, instead of -days")
+	fs.Var(&pushedBefore, "pushed-before", "Only delete images pushed before this time; with -pushed-after, purges the images pushed in between")
+	region := fs.String("region", "", "AWS region (defaults to value from AWS config)")
+	profile := fs.String("profile", "", "Named AWS profile from the shared config and credentials files")
+	var repositories repositoryNames
+	fs.Var(&repositories, "repository", "Only clean up this repository; may be repeated")
+	reposFrom := fs.String("repos-from", "", "Only clean up the repositories listed (one per line) in this file, or on stdin with -")
+	regions := fs.String("regions", "", "Comma-separated list of AWS regions to clean up in one run")
+	public := fs.Bool("public", false, "Clean up the ECR Public gallery repositories (us-east-1) instead of private repositories")
+	allRegions := fs.Bool("all-regions", false, "Clean up every region enabled for the account")
+	endpointURL := fs.String("endpoint-url", "", "Send every AWS call to this endpoint, e.g. LocalStack (http://localhost:4566) or an interface VPC endpoint")
+	fips := fs.Bool("fips", false, "Use the FIPS 140 validated endpoints of the AWS services, e.g. in GovCloud")
+	dualStack := fs.Bool("dual-stack", false, "Use the dual-stack (IPv4 and IPv6) endpoints of the AWS services")
+	proxyURL := fs.String("proxy-url", "", "Send AWS calls through this HTTP proxy (default from HTTPS_PROXY)")
+	caBundle := fs.String("ca-bundle", "", "PEM file of certificate authorities to trust for AWS calls")
+	awsRetryMode := fs.String("aws-retry-mode", "", "Retry mode of the AWS clients: standard or adaptive (default from the AWS config)")
+	awsMaxAttempts := fs.Int("aws-max-attempts", 0, "Maximum attempts of each AWS call, including the first (0 means the AWS config's default)")
+	roleArn := fs.String("role-arn", "", "IAM role to assume before creating the ECR client")
+	externalID := fs.String("external-id", "", "External ID to pass when assuming roles")
+	roleSessionName := fs.String("role-session-name", defaultRoleSessionName, "Session name to use when assuming roles")
+	assumeRoles := fs.String("assume-roles", "", "File of role ARNs (one per line) to assume and clean up in each account")
+	orgMode := fs.Bool("org-mode", false, "Clean up every active account in the AWS Organization")
+	registryID := fs.String("registry-id", "", "Account ID of the registry to clean up, when the caller has cross-account access to it (default the caller's)")
+	orgRoleName := fs.String("org-role-name", "OrganizationAccountAccessRole", "Role name to assume in each organization account")
+	orgSkipTag := fs.String("org-skip-tag", "ecr-cleanup/skip=true", "Account tag (key=value) that opts an account out of org mode")
+	maxImages := fs.Int("max-images", 0, "Maximum number of images to keep per repository (0 means no limit)")
+	keepNewest := fs.Bool("keep-newest", true, "Never delete the most recent image of a repository, however old (-keep-newest=false to allow it)")
+	neverPulledDays := fs.Int("delete-never-pulled-after-days", 0, "Also delete images never pulled this many days after their push, however new (0 disables)")
+	honorExpiryLabels := fs.Bool("honor-expiry-labels", false, "Also delete images past the expiry of their -expiry-label or quay.expires-after label, however new")
+	expiryLabel := fs.String("expiry-label", defaultExpiryLabel, "Label or annotation holding an image's expiry: a time, or a duration after its push such as 30d")
+	keepCleanImage := fs.Bool("keep-clean-image", false, "Never delete the most recent image without CRITICAL or HIGH scan findings, however old")
+	keepPerTagFamily := fs.Bool("keep-per-tag-family", false, "Never delete the most recent image of each tag family (the tag up to its last dash, e.g. main for main-42), however old")
+	tagFamilyPattern := fs.String("tag-family-pattern", defaultTagFamilyPattern, "Regular expression whose first group is a tag's family with -keep-per-tag-family; tags it doesn't match belong to no family")
+	minKeep := fs.Int("min-keep", defaultMinKeep, "Never leave a repository with fewer than this many images (0 allows emptying repositories)")
+	maxDeletions := fs.Int("max-deletions", 0, "Never delete more than this many images in one run (0 means no limit)")
+	maxDeletionsPerRepo := fs.Int("max-deletions-per-repo", 0, "Never delete more than this many images from one repository (0 means no limit)")
+	maxDeletePercent := fs.Int("max-delete-percent", defaultMaxDeletePercent, "Refuse to delete more than this percentage of a registry's images (0 means no limit)")
+	maxDeletePercentPerRepo := fs.Int("max-delete-percent-per-repo", 0, "Refuse to delete more than this percentage of a repository's images (0 means no limit)")
+	maxFailurePercent := fs.Int("max-failure-percent", 0, "Abort the run once more than this percentage of its image deletions failed (0 means never)")
+	force := fs.Bool("force", false, "Delete even when more than -max-delete-percent or -max-delete-percent-per-repo of the images would be deleted")
+	targetRepoSizeGB := fs.Float64("target-repo-size-gb", 0, "Only delete the oldest eligible images until each repository is under this size in GB (0 means no target)")
+	targetTotalGB := fs.Float64("target-total-gb", 0, "Only delete the oldest eligible images until the registry is under this size in GB (0 means no target)")
+	storagePrice := fs.Float64("storage-price", defaultStoragePrice, "ECR storage price in USD per GB-month, used to estimate monthly savings")
+	timeout := fs.Duration("timeout", 0, "Stop the run after this long, e.g. 30m (0 means no timeout)")
+	var simulateDays, simulateMaxImages intList
+	fs.Var(&simulateDays, "simulate-days", "Comma-separated -days values compared by the simulate command, e.g. 7,14,30")
+	fs.Var(&simulateMaxImages, "simulate-max-images", "Comma-separated -max-images values compared by the simulate command, e.g. 10,25")
+	complianceMaxSizeGB := fs.Float64("compliance-max-size-gb", 0, "Repository size above which the compliance command fails a repository (0 disables the check)")
+	complianceMaxAgeDays := fs.Int("compliance-max-age-days", 0, "Age in days of the oldest image above which the compliance command fails a repository (0 disables the check)")
+	complianceRequireLifecyclePolicy := fs.Bool("compliance-require-lifecycle-policy", false, "Make the compliance command fail repositories without a lifecycle policy")
+	securityHub := fs.Bool("security-hub", false, "Import the compliance command's results into Security Hub as findings")
+	enforce := fs.Bool("enforce", false, "Make the coverage command put the lifecycle policy of the retention flags on repositories without a lifecycle policy or cleanup tag")
+	window := fs.String("window", "", "Only delete images within this maintenance window, e.g. \"Sat 01:00-05:00 UTC\"; dry runs are always allowed")
+	manageLifecyclePolicies := fs.Bool("manage-lifecycle-policies", false, "Put the ECR lifecycle policy derived from the retention flags on every repository instead of deleting images, reporting drift from existing policies")
+	failOnError := fs.Bool("fail-on-error", false, "Abort the run at the first repository error instead of moving on to the next repository")
+	concurrency := fs.Int("concurrency", 1, "Number of repositories to process in parallel")
+	describeConcurrency := fs.Int("image-fetch-concurrency", 4, "Number of DescribeImages batches of 100 images to fetch in parallel per repository")
+	fs.IntVar(describeConcurrency, "describe-concurrency", 4, "Former name of -image-fetch-concurrency")
+	skipListImages := fs.Bool("skip-list-images", false, "Page through DescribeImages directly instead of calling ListImages first")
+	deleteBatchSize := fs.Int("delete-batch-size", batchDeleteSize, "Number of images deleted per BatchDeleteImage call (1 to 100)")
+	deleteBatchDelay := fs.Duration("delete-batch-delay", 0, "Pause between two batches of deletions in a repository, e.g. 2s")
+	apiRate := fs.Float64("api-rate", 0, "Maximum DescribeImages/BatchDeleteImage calls per second (0 means unpaced until throttled)")
+	throttleMaxAttempts := fs.Int("throttle-max-attempts", defaultThrottleMaxAttempts, "Maximum attempts for an ECR call that is throttled")
+	configFile := fs.String("config", "", "YAML file of further settings, such as the in_use_providers that protect images of running workloads")
+	policyName := fs.String("policy-name", "", "Named policy of the -config file whose settings replace the flag defaults")
+	protectAppRunner := fs.Bool("protect-apprunner", false, "Never delete images used by App Runner services")
+	protectDeployments := fs.String("protect-deployments", "", "Never delete images referenced by the Kubernetes manifests, Helm values and docker-compose files in this directory or Git repository URL")
+	protectDeploymentsRef := fs.String("protect-deployments-ref", "", "Branch or tag of the -protect-deployments Git repository (default branch if empty)")
+	argoCDServer := fs.String("argocd-server", "", "Never delete images deployed by the applications of this Argo CD server URL")
+	argoCDToken := fs.String("argocd-token", "", "API token for -argocd-server")
+	keepListFile := fs.String("keep-list", "", "File of digests and repo:tag entries (one per line) that are never deleted")
+	protectTags := fs.String("protect-tags", defaultProtectTags, "Comma-separated tags that pin an image so it's never deleted (empty disables)")
+	archiveTo := fs.String("archive-to", "", "Copy each image to this archive repository, or under this prefix ending in /, before deleting it")
+	manifestsS3 := fs.String("manifests-s3", "", "Store the manifest and metadata of each image under this S3 location (s3://bucket/prefix/) before deleting it")
+	untagOnly := fs.Bool("untag-only", false, "Remove the tags of the images selected for deletion instead of deleting them; each image keeps a retained-<digest> tag")
+	quarantineDays := fs.Int("quarantine-days", 0, "Tag selected images quarantine-<date> and only delete them once they have been quarantined this many days (0 deletes them right away)")
+	deleteEmptyRepos := fs.Bool("delete-empty-repos", false, "Delete repositories that hold no image and were created more than -empty-repo-days ago")
+	emptyRepoDays := fs.Int("empty-repo-days", defaultEmptyRepoDays, "Only delete empty repositories created more than this many days ago")
+	replication := fs.String("replication", replicationIgnore, "What to do about registry replication, which doesn't propagate deletions: ignore, warn, source-only (leave replica repositories alone) or fan-out (also delete from replicas)")
+	preflight := fs.Bool("preflight", false, "Check that the credentials have the ECR permissions the run needs before touching each registry")
+	verifyDeletions := fs.Bool("verify-deletions", false, "After deleting the images of a repository, list it again and report the deleted images it still holds")
+	deleteByTag := fs.Bool("delete-by-tag", false, "Delete tagged images by their first tag instead of by digest (never in repositories with immutable tags)")
+	preDeleteHook := fs.String("pre-delete-hook", "", "Command run (via sh -c) for each image selected for deletion, with its repository, tags and digest as JSON on stdin; a non-zero exit keeps the image")
+	retryFile := fs.String("retry-file", "", "Record failed deletions in this file, and only reattempt those when it lists any")
+	stateFile := fs.String("state-file", "", "Record each finished repository in this file, and skip the repositories it lists when resuming an interrupted run")
+	lockS3 := fs.String("lock-s3", "", "Lock each account and region under this S3 location (s3://bucket/prefix/) so no two runs clean up the same registry at once")
+	lockTable := fs.String("lock-table", "", "Lock each account and region with an item in this DynamoDB table so no two runs clean up the same registry at once")
+	fs.StringVar(lockTable, "lock-dynamodb", "", "Former name of -lock-table")
+	lockTTL := fs.Duration("lock-ttl", defaultLockTTL, "How long a -lock-s3 or -lock-table lock lasts if its run never releases it")
+	auditFile := fs.String("audit-file", "", "Append a JSON line to this file for every image selected, deleted, failed or skipped, tagged with the run ID")
+	pullThroughDays := fs.Int("pull-through-days", 0, "Delete images of pull-through cache repositories older than this many days (0 means -days)")
+	pullThroughMaxImages := fs.Int("pull-through-max-images", 0, "Maximum number of images to keep per pull-through cache repository (0 means -max-images)")
+	skipPullThrough := fs.Bool("skip-pull-through", false, "Leave pull-through cache repositories alone")
+	optInTag := fs.String("opt-in-tag", "", "Only clean up repositories with this key=value resource tag (a key alone matches any value)")
+	tagPolicies := fs.Bool("tag-policies", false, "Let repositories override -days and -max-images, or be skipped, with ecr-cleanup/* resource tags")
+	pullActivityDays := fs.Int("pull-activity-days", 0, "Adapt each repository's retention to its CloudWatch pull count over this many days (0 disables)")
+	hotPullCount := fs.Int("hot-pull-count", defaultHotPullCount, "Pulls within -pull-activity-days that make a repository hot")
+	hotDays := fs.Int("hot-days", 0, "Delete images of hot repositories older than this many days (0 means -days)")
+	coldDays := fs.Int("cold-days", 0, "Delete images of repositories nobody pulled within -pull-activity-days older than this many days (0 means -days)")
+	protectBatch := fs.Bool("protect-batch", false, "Never delete images used by active AWS Batch job definitions")
+	reportHTML := fs.String("report-html", "", "Write an HTML cleanup report to this file")
+	reportMarkdown := fs.String("report-md", "", "Write a Markdown cleanup report to this file")
+	reportS3 := fs.String("report-s3", "", "Upload JSON and CSV reports to this S3 location (s3://bucket/prefix/)")
+	historyS3 := fs.String("history-s3", "", "Keep each run's summary under this S3 location (s3://bucket/prefix/) and report the trend since the previous run")
+	historyDynamoDB := fs.String("history-dynamodb", "", "Keep each run's summary in this DynamoDB table and report the trend since the previous run")
+	summaryFile := fs.String("summary-file", "", "Write the run's totals to this file as versioned JSON, whether the run succeeded or not")
+	ghaOutput := fs.Bool("gha-output", false, "Set the run's totals as GitHub Actions step outputs")
+	snsTopicArn := fs.String("sns-topic-arn", "", "Publish a run summary to this SNS topic when the run finishes")
+	eventBus := fs.String("event-bus", "", "Send an ecr-cleanup.image.deleted event to this EventBridge bus (name or ARN) for each deleted image")
+	webhookURL := fs.String("webhook-url", "", "POST the JSON run summary to this URL when the run finishes")
+	var webhookHeaders headerList
+	fs.Var(&webhookHeaders, "webhook-header", "Header (\"Name: value\") to send with the webhook; may be repeated")
+	webhookRetries := fs.Int("webhook-retries", defaultWebhookRetries, "Number of times to retry a failed webhook call")
+	notifyMinFailures := fs.Int("notify-min-failures", 0, "Only notify over SNS or the webhook when at least this many repositories, regions or accounts fail, or the run fails (0 disables)")
+	notifyMinDeletedGB := fs.Float64("notify-min-deleted-gb", 0, "Only notify over SNS or the webhook when the run frees at least this many GB, or fails (0 disables)")
+	postRunHook := fs.String("post-run-hook", "", "Command run (via sh -c) when the run finishes, with the JSON run summary on stdin")
+	metricsAddr := fs.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090) and keep serving after the run")
+	pushgatewayURL := fs.String("pushgateway-url", "", "Push Prometheus metrics to this Pushgateway when the run finishes")
+	otlpEndpoint := fs.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Export OpenTelemetry traces to this OTLP/HTTP collector (e.g. http://localhost:4318)")
+	logLevel := fs.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "text", "Log format: text or json")
+	debugAWS := fs.Bool("debug-aws", false, "Log every AWS request and response, with credentials redacted")
+	showVersion := fs.Bool("version", false, "Print the version and build metadata, then exit")
+	noProgress := fs.Bool("no-progress", false, "Don't show the progress line, even when attached to a terminal")
+	noColor := fs.Bool("no-color", false, "Don't color the logs, even when attached to a terminal (also set by NO_COLOR)")
+	logFile := fs.String("log-file", "", "Also write the logs to this file, rotating it by size")
+	logMaxSize := fs.Int("log-max-size", 100, "Rotate the -log-file when it reaches this many megabytes (0 never rotates)")
+	logMaxAge := fs.Int("log-max-age", 0, "Remove rotated log files older than this many days (0 keeps them)")
+	logMaxBackups := fs.Int("log-max-backups", 5, "Keep at most this many rotated log files (0 keeps them all)")
+	schedule := fs.String("schedule", "", "Keep running and clean up on this cron schedule (e.g. \"0 3 * * *\")")
+	scheduleJitter := fs.Duration("schedule-jitter", defaultScheduleJitter, "Maximum random delay added to each scheduled run")
+	healthAddr := fs.String("health-addr", "", "Serve a /healthz endpoint on this address (e.g. :8080) while running on a schedule")
+	exporterInterval := fs.Duration("exporter-interval", defaultExporterInterval, "Time between two scans of the exporter command")
+	apiAddr := fs.String("api-addr", ":8080", "Address the serve command listens on")
+	interactive := fs.Bool("interactive", false, "Pick the images to delete in a terminal UI before anything is deleted")
+	planFile := fs.String("plan", "", "Plan file the plan command writes (default stdout) and the apply command deletes")
+	restoreImages := fs.String("restore-images", "", "Comma-separated digests, repositories and repo:tag entries of the plan the restore command restores (default every image)")
+	apiToken := fs.String("api-token", "", "Bearer token the serve command requires on every request")
 
-	return Config{
-		DryRun:    *dryRun,
-		Days:      *days,
-		Region:    *region,
-		MaxImages: *maxImages,
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
 	}
+	if err := applyEnvironment(fs, os.Environ()); err != nil {
+		return Config{}, err
+	}
+	if err := applyPolicy(fs, *configFile, *policyName); err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		DryRun:     *dryRun,
+		Yes:        *yes,
+		Days:       *days,
+		Region:     *region,
+		MaxImages:  *maxImages,
+		MinKeep:    *minKeep,
+		KeepNewest: *keepNewest,
+		Profile:    *profile,
+
+		KeepCleanImage:   *keepCleanImage,
+		KeepPerTagFamily: *keepPerTagFamily,
+		TagFamilyPattern: *tagFamilyPattern,
+
+		OlderThan: time.Duration(olderThan),
+		Before:    time.Time(before),
+
+		PushedAfter:  time.Time(pushedAfter),
+		PushedBefore: time.Time(pushedBefore),
+
+		NeverPulledDays: *neverPulledDays,
+
+		HonorExpiryLabels: *honorExpiryLabels,
+		ExpiryLabel:       *expiryLabel,
+
+		MaxDeletions:            *maxDeletions,
+		MaxDeletionsPerRepo:     *maxDeletionsPerRepo,
+		MaxDeletePercent:        *maxDeletePercent,
+		MaxDeletePercentPerRepo: *maxDeletePercentPerRepo,
+		Force:                   *force,
+
+		MaxFailurePercent: *maxFailurePercent,
+
+		FailOnError: *failOnError,
+		Timeout:     *timeout,
+		Window:      *window,
+
+		SimulateDays:      simulateDays,
+		SimulateMaxImages: simulateMaxImages,
+
+		ComplianceMaxSizeGB:              *complianceMaxSizeGB,
+		ComplianceMaxAgeDays:             *complianceMaxAgeDays,
+		ComplianceRequireLifecyclePolicy: *complianceRequireLifecyclePolicy,
+		SecurityHub:                      *securityHub,
+
+		Enforce: *enforce,
+
+		ManageLifecyclePolicies: *manageLifecyclePolicies,
+
+		TargetRepoSizeGB: *targetRepoSizeGB,
+		TargetTotalGB:    *targetTotalGB,
+
+		StoragePrice: *storagePrice,
+
+		Concurrency: *concurrency,
+
+		DescribeConcurrency: *describeConcurrency,
+		SkipListImages:      *skipListImages,
+
+		APIRate:             *apiRate,
+		DeleteBatchSize:     *deleteBatchSize,
+		DeleteBatchDelay:    *deleteBatchDelay,
+		ThrottleMaxAttempts: *throttleMaxAttempts,
+
+		Repositories: repositories,
+		ReposFrom:    *reposFrom,
+
+		Regions:    parseRegionList(*regions),
+		AllRegions: *allRegions,
+
+		Public: *public,
+
+		EndpointURL: *endpointURL,
+		FIPS:        *fips,
+		DualStack:   *dualStack,
+		ProxyURL:    *proxyURL,
+		CABundle:    *caBundle,
+
+		AWSRetryMode:   *awsRetryMode,
+		AWSMaxAttempts: *awsMaxAttempts,
+
+		RoleArn:         *roleArn,
+		ExternalID:      *externalID,
+		RoleSessionName: *roleSessionName,
+
+		AssumeRolesFile: *assumeRoles,
+		OrgMode:         *orgMode,
+		OrgRoleName:     *orgRoleName,
+		OrgSkipTag:      *orgSkipTag,
+
+		RegistryID: *registryID,
+
+		ConfigFile: *configFile,
+		PolicyName: *policyName,
+
+		ProtectAppRunner: *protectAppRunner,
+		ProtectBatch:     *protectBatch,
+
+		ProtectDeployments:    *protectDeployments,
+		ProtectDeploymentsRef: *protectDeploymentsRef,
+
+		ArgoCDServer: *argoCDServer,
+		ArgoCDToken:  *argoCDToken,
+
+		KeepListFile: *keepListFile,
+		ProtectTags:  parseProtectTags(*protectTags),
+
+		ArchiveTo:   *archiveTo,
+		ManifestsS3: *manifestsS3,
+
+		AuditFile: *auditFile,
+		StateFile: *stateFile,
+		RetryFile: *retryFile,
+
+		LockS3:    *lockS3,
+		LockTable: *lockTable,
+		LockTTL:   *lockTTL,
+
+		PreDeleteHook: *preDeleteHook,
+		DeleteByTag:   *deleteByTag,
+		UntagOnly:     *untagOnly,
+
+		Preflight:       *preflight,
+		VerifyDeletions: *verifyDeletions,
+
+		Replication: *replication,
+
+		QuarantineDays: *quarantineDays,
+
+		DeleteEmptyRepos: *deleteEmptyRepos,
+		EmptyRepoDays:    *emptyRepoDays,
+
+		PullThroughDays:      *pullThroughDays,
+		PullThroughMaxImages: *pullThroughMaxImages,
+		SkipPullThrough:      *skipPullThrough,
+
+		TagPolicies: *tagPolicies,
+		OptInTag:    *optInTag,
+
+		PullActivityDays: *pullActivityDays,
+		HotPullCount:     *hotPullCount,
+		HotDays:          *hotDays,
+		ColdDays:         *coldDays,
+
+		ReportHTML:     *reportHTML,
+		ReportMarkdown: *reportMarkdown,
+		ReportS3:       *reportS3,
+
+		HistoryS3:       *historyS3,
+		HistoryDynamoDB: *historyDynamoDB,
+
+		SummaryFile: *summaryFile,
+		GHAOutput:   *ghaOutput,
+
+		SNSTopicArn:    *snsTopicArn,
+		EventBus:       *eventBus,
+		WebhookURL:     *webhookURL,
+		WebhookHeaders: webhookHeaders,
+		WebhookRetries: *webhookRetries,
+		PostRunHook:    *postRunHook,
+
+		NotifyMinFailures:  *notifyMinFailures,
+		NotifyMinDeletedGB: *notifyMinDeletedGB,
+
+		MetricsAddr:    *metricsAddr,
+		PushgatewayURL: *pushgatewayURL,
+
+		OTLPEndpoint: *otlpEndpoint,
+
+		LogLevel:   *logLevel,
+		LogFormat:  *logFormat,
+		NoProgress: *noProgress,
+		NoColor:    *noColor,
+		DebugAWS:   *debugAWS,
+
+		LogFile:       *logFile,
+		LogMaxSizeMB:  *logMaxSize,
+		LogMaxAge:     *logMaxAge,
+		LogMaxBackups: *logMaxBackups,
+
+		ShowVersion: *showVersion,
+
+		Schedule:       *schedule,
+		ScheduleJitter: *scheduleJitter,
+		HealthAddr:     *healthAddr,
+
+		ExporterInterval: *exporterInterval,
+
+		APIAddr:  *apiAddr,
+		APIToken: *apiToken,
+
+		PlanFile: *planFile,
+
+		RestoreImages: parseRestoreSelection(*restoreImages),
+
+		Interactive: *interactive,
+	}, nil
 }
 
 // cleanupECR performs the ECR cleanup operation
-func cleanupECR(cfg Config) (CleanupSummary, error) {
-	summary := CleanupSummary{}
-	ctx := context.Background()
+func cleanupECR(cfg Config) (summary CleanupSummary, err error) {
+	ctx, cancel := withRunTimeout(context.Background(), cfg)
+	defer cancel()
+	cfg, closeWindow, err := withMaintenanceWindow(cfg, cfg.now())
+	if err != nil {
+		return summary, err
+	}
+	defer closeWindow()
+	ctx, span := startRunSpan(ctx, cfg, "cleanupECR", attribute("dry_run", cfg.DryRun))
+	defer func() { span.end(err) }()
+
+	if cfg.PolicyName != "" {
+		slog.Info("Using named policy", "policy", cfg.PolicyName, "config", cfg.ConfigFile)
+	}
+
+	// Limit deletions across every account and region of the run
+	cfg.deletions = newDeletionLimits(cfg)
+	defer func() {
+		if err == nil {
+			err = cfg.deletions.err()
+		}
+	}()
+
+	// Abort the run when too many of its deletions fail
+	cfg.breaker = newFailureBreaker(cfg)
+	defer func() {
+		if err == nil {
+			err = cfg.breaker.err()
+		}
+	}()
+
+	// Read the keep-list, deployment manifests, Argo CD applications and the
+	// in-use providers collected once per run for every account and region
+	if cfg.keepList, err = loadKeepList(cfg); err != nil {
+		return summary, err
+	}
+	if cfg.deployments, err = loadDeployments(ctx, cfg); err != nil {
+		return summary, err
+	}
+	if cfg.argoCD, err = loadArgoCDImages(ctx, cfg); err != nil {
+		return summary, err
+	}
+	if cfg.inUseProviders, cfg.sharedInUse, err = loadSharedInUse(ctx, cfg); err != nil {
+		return summary, err
+	}
+
+	// Record every decision of the run under one run ID
+	if cfg.AuditFile != "" {
+		if cfg.audit, err = openAuditLog(cfg.AuditFile); err != nil {
+			return summary, err
+		}
+		defer cfg.audit.close()
+		slog.Info("Recording decisions in the audit file", "file", cfg.AuditFile, "run_id", cfg.audit.runID)
+	}
+
+	// Resume an interrupted run, and record progress in case this one is
+	// interrupted too; dry runs delete nothing, so there is nothing to resume
+	if cfg.StateFile != "" && !cfg.DryRun {
+		if cfg.checkpoint, err = openCheckpoint(cfg.StateFile); err != nil {
+			return summary, err
+		}
+		defer func() {
+			cfg.checkpoint.finish(err == nil && len(summary.Failures) == 0 && cfg.deletions.err() == nil)
+		}()
+		if finished := cfg.checkpoint.resumed(); finished > 0 {
+			slog.Info("Resuming interrupted run", "state_file", cfg.StateFile, "repositories_finished", finished)
+		}
+	}
+
+	// Retry the deletions that failed last time instead of cleaning up, and
+	// queue the ones that fail now
+	if cfg.RetryFile != "" {
+		retries, err := openRetryQueue(cfg.RetryFile)
+		if err != nil {
+			return summary, err
+		}
+		if retries.pending != nil && cfg.plan == nil {
+			cfg.plan = retries.pending
+			slog.Info("Retrying failed deletions", "retry_file", cfg.RetryFile, "images", cfg.plan.Images)
+		}
+		if !cfg.DryRun {
+			cfg.retries = retries
+			defer func() { cfg.retries.finish(summary, err) }()
+		}
+	}
 
 	// Load AWS configuration
-	awsConfig, err := loadAWSConfig(ctx, cfg.Region)
+	awsConfig, err := loadRunAWSConfig(ctx, cfg)
 	if err != nil {
 		return summary, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// Create ECR client
-	client := ecr.NewFromConfig(awsConfig)
+	// Count the ECR calls of every account and region
+	cfg.apiStats = newAPIStats()
+	cfg.apiStats.instrument(&awsConfig)
+	defer func() { summary.APICalls = cfg.apiStats.calls() }()
 
-	// Get all repositories
-	repos, err := getRepositories(ctx, client)
+	// Trace the calls of every SDK client, whatever the service
+	if cfg.tracer != nil {
+		traceAWSCalls(&awsConfig)
+	}
+
+	// Show which identity the run uses before touching anything
+	identity, err := getCallerIdentity(ctx, awsConfig)
 	if err != nil {
-		return summary, fmt.Errorf("failed to get repositories: %w", err)
+		return summary, fmt.Errorf("failed to verify AWS credentials: %w", err)
 	}
-	
-	summary.RepositoriesProcessed = len(repos)
+	slog.Info("Running as caller identity", "arn", aws.ToString(identity.Arn), "account", aws.ToString(identity.Account))
 
-	log.Printf("Found %d repositories", len(repos))
+	// Export manifests with the run's base credentials, like reports
+	if cfg.ManifestsS3 != "" {
+		if cfg.manifests, err = newManifestExport(newS3Client(awsConfig), cfg.ManifestsS3, time.Now()); err != nil {
+			return summary, err
+		}
+	}
 
-	// Process each repository
-	for _, repo := range repos {
-		repoSummary, err := processRepository(ctx, client, *repo.RepositoryName, cfg)
+	// Keep the locks with the run's base credentials, like reports; dry
+	// runs delete nothing, so they can overlap other runs
+	if !cfg.DryRun {
+		if cfg.locks, err = newRunLocks(newS3Client(awsConfig), dynamodb.NewFromConfig(awsConfig), cfg, aws.ToString(identity.Account)); err != nil {
+			return summary, err
+		}
+	}
+
+	// Send deletion events with the run's base credentials, like notifications
+	if cfg.EventBus != "" {
+		cfg.events = newEventEmitter(awsConfig, cfg.EventBus)
+	}
+
+	// Run the same policy in every listed account
+	if cfg.AssumeRolesFile != "" {
+		roleArns, err := readRoleArns(cfg.AssumeRolesFile)
 		if err != nil {
-			log.Printf("Error processing repository %s: %v", *repo.RepositoryName, err)
-			continue
+			return summary, fmt.Errorf("failed to read role list: %w", err)
 		}
-		
-		summary.ImagesDeleted += repoSummary.ImagesDeleted
-		summary.SpaceFreed += repoSummary.SpaceFreed
+		return cleanupAccounts(ctx, awsConfig, cfg, targetsFromRoleArns(roleArns))
+	}
+
+	// Run the same policy in every account of the organization
+	if cfg.OrgMode {
+		targets, err := discoverOrgAccounts(ctx, awsConfig, cfg)
+		if err != nil {
+			return summary, fmt.Errorf("failed to discover organization accounts: %w", err)
+		}
+		return cleanupAccounts(ctx, awsConfig, cfg, targets)
 	}
 
-	return summary, nil
+	// Locks, checkpoints and plans belong to the registry, not the caller
+	if cfg.RegistryID != "" {
+		cfg.accountID = cfg.RegistryID
+	}
+	return cleanupAccount(ctx, awsConfig, cfg)
+}
+
+// loadRunAWSConfig loads the AWS configuration and switches to the role
+// from -role-arn, if any, before any clients are created
+func loadRunAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	awsConfig, err := loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if cfg.RoleArn != "" {
+		awsConfig = assumeRoleConfig(awsConfig, cfg.RoleArn, cfg)
+	}
+
+	return awsConfig, nil
 }
 
 // loadAWSConfig loads the AWS configuration
-func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
-	configOpts := []func(*config.LoadOptions) error{}
-	if region != "" {
-		configOpts = append(configOpts, config.WithRegion(region))
+func loadAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	// Tell CloudTrail which version of the tool made each call
+	configOpts := []func(*config.LoadOptions) error{withUserAgent()}
+	if cfg.Region != "" {
+		configOpts = append(configOpts, config.WithRegion(cfg.Region))
+	}
+	if cfg.Profile != "" {
+		configOpts = append(configOpts, config.WithSharedConfigProfile(cfg.Profile))
+	}
+	endpointOpts, err := endpointOptions(cfg)
+	if err != nil {
+		return aws.Config{}, err
 	}
+	configOpts = append(configOpts, endpointOpts...)
+	retryOpts, err := retryOptions(cfg)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	configOpts = append(configOpts, retryOpts...)
+	registryOpts, err := registryIDOptions(cfg)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	configOpts = append(configOpts, registryOpts...)
+	configOpts = append(configOpts, debugAWSOptions(cfg)...)
 
 	return config.LoadDefaultConfig(ctx, configOpts...)
 }
 
+// getCallerIdentity returns the identity the AWS credentials resolve to
+func getCallerIdentity(ctx context.Context, awsConfig aws.Config) (*sts.GetCallerIdentityOutput, error) {
+	return sts.NewFromConfig(awsConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+}
+
 // getRepositories gets all ECR repositories
 func getRepositories(ctx context.Context, client ECRClient) ([]types.Repository, error) {
 	var repositories []types.Repository
@@ -130,106 +1191,336 @@ func getRepositories(ctx context.Context, client ECRClient) ([]types.Repository,
 	return repositories, nil
 }
 
-// processRepository processes a single ECR repository
-func processRepository(ctx context.Context, client ECRClient, repoName string, cfg Config) (CleanupSummary, error) {
-	repoSummary := CleanupSummary{RepositoriesProcessed: 1}
-	log.Printf("Processing repository: %s", repoName)
+// startRepositorySpan starts the span that covers scanning a repository and
+// deleting its images
+func startRepositorySpan(ctx context.Context, repoName string) (context.Context, *span) {
+	return startSpan(ctx, "processRepository", attribute("ecr.repository", repoName))
+}
+
+// endRepositorySpan ends a repository's span with the outcome of its cleanup
+func endRepositorySpan(span *span, repoSummary CleanupSummary, err error) {
+	span.setAttributes(
+		attribute("ecr.images_scanned", repoSummary.ImagesScanned),
+		attribute("ecr.images_deleted", repoSummary.ImagesDeleted))
+	span.end(err)
+}
 
-	// Get all image details
-	images, err := getImageDetails(ctx, client, repoName)
+// selectRepositoryImages scans a repository and returns how many images it
+// holds along with the ones to delete, without deleting anything
+func selectRepositoryImages(ctx context.Context, client ECRClient, repoName string, cfg Config) (stats repositoryStats, toDelete []types.ImageDetail, err error) {
+	slog.Info("Processing repository", "repository", repoName)
+
+	retention := cfg
+	if cfg.pullThrough.contains(repoName) {
+		retention = pullThroughRetention(cfg)
+	}
+	retention = cfg.pullActivity.retention(repoName, retention)
+	retention = cfg.repoPolicies.retention(repoName, retention)
+
+	// Scan the images a page at a time, keeping only those that can be
+	// deleted; signatures and other artifacts are left to follow their image
+	scan, err := scanRepository(ctx, client, repoName, cfg, retention)
 	if err != nil {
-		return repoSummary, fmt.Errorf("failed to get image details: %w", err)
+		return stats, nil, fmt.Errorf("failed to get image details: %w", err)
 	}
+	stats = scan.repositoryStats
 
-	log.Printf("Found %d images in repository %s", len(images), repoName)
+	slog.Info("Found images", "repository", repoName, "images", scan.images)
 
 	// Determine which images to delete
-	toDelete := selectImagesForDeletion(images, cfg)
+	if cfg.plan != nil {
+		toDelete, err = cfg.plan.selectImages(cfg.accountID, cfg.region, repoName, scan.candidates)
+		if err != nil {
+			return stats, nil, err
+		}
+	} else {
+		toDelete = scan.selectCandidates(retention)
+		toDelete = withNeverPulled(repoName, toDelete, scan.neverPulled)
+		toDelete = withExpired(toDelete, scan.expired)
+	}
+	if cfg.UntagOnly {
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, withStaleTags(toDelete), "no tags to remove")
+	}
+	toDelete = keepProtected(repoName, toDelete, cfg)
+	if cfg.KeepNewest {
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, keepNewestImage(repoName, toDelete, []types.ImageDetail{scan.newest}), "newest image of the repository")
+	}
+	if cfg.KeepCleanImage {
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, keepNewestImage(repoName, toDelete, []types.ImageDetail{scan.clean}), "newest image without critical or high findings")
+	}
+	if cfg.KeepPerTagFamily {
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, scan.families.exclude(repoName, toDelete), "newest image of its tag family")
+	}
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, keepMinimum(repoName, toDelete, scan.subjects, cfg.MinKeep), "kept to stay above -min-keep")
 
-	if len(toDelete) == 0 {
-		log.Printf("No images to delete in repository %s", repoName)
-		return repoSummary, nil
+	// With a size target, only delete enough to get under it
+	if cfg.TargetRepoSizeGB > 0 && cfg.plan == nil {
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, trimToTarget(repoName, scan.size, toDelete, gbToBytes(cfg.TargetRepoSizeGB)), "repository within -target-repo-size-gb")
 	}
-	
-	repoSummary.ImagesDeleted = len(toDelete)
-	
-	// Calculate space to be freed
-	for _, img := range toDelete {
-		if img.ImageSizeInBytes != nil {
-			repoSummary.SpaceFreed += *img.ImageSizeInBytes
-		}
+
+	// Let the pre-delete hook veto what's left
+	vetted, err := applyPreDeleteHook(ctx, repoName, toDelete, cfg)
+	if err != nil {
+		return stats, nil, err
 	}
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, vetted, "vetoed by -pre-delete-hook")
 
-	log.Printf("Selected %d images for deletion in repository %s", len(toDelete), repoName)
+	// Give the owners of newly selected images time to object; the tags are
+	// written along with the deletions, once the deletion limits accept them
+	switch {
+	case cfg.plan != nil:
+		stats.quarantine = cfg.plan.quarantineChanges(cfg.accountID, cfg.region, repoName, scan.candidates)
+	case cfg.QuarantineDays > 0 && !cfg.UntagOnly:
+		var due []types.ImageDetail
+		due, stats.quarantine = quarantineImages(repoName, toDelete, scan.quarantined, cfg, cfg.now())
+		toDelete = cfg.audit.kept(cfg, repoName, toDelete, due, fmt.Sprintf("quarantined for less than %d days", cfg.QuarantineDays))
+	}
 
-	// If in dry run mode, just print what would be deleted
-	if cfg.DryRun {
-		for _, img := range toDelete {
-			pushedAtStr := "unknown time"
-			if img.ImagePushedAt != nil {
-				pushedAtStr = img.ImagePushedAt.Format(time.RFC3339)
-			}
-			
-			sizeStr := "unknown size"
-			if img.ImageSizeInBytes != nil {
-				sizeStr = fmt.Sprintf("%.2f MB", float64(*img.ImageSizeInBytes)/1024/1024)
+	// Plans already list the artifacts they delete, and untagging leaves
+	// artifacts their subject. The artifacts that follow their image are
+	// protected and vetted like it.
+	if cfg.plan == nil && !cfg.UntagOnly {
+		cutoff := retention.ageCutoff(cfg.now())
+		selected := len(toDelete)
+		toDelete = scan.artifacts.withArtifacts(repoName, scan.artifactImages, scan.digests, toDelete, func(pushed *time.Time) bool {
+			return retention.agedOut(pushed, cutoff)
+		})
+		if artifacts := keepProtected(repoName, toDelete[selected:], cfg); len(artifacts) > 0 {
+			vetted, err := applyPreDeleteHook(ctx, repoName, artifacts, cfg)
+			if err != nil {
+				return stats, nil, err
 			}
-			
-			log.Printf("[DRY RUN] Would delete image %s:%s (pushed at %s, size: %s)",
-				repoName, getImageTag(img), pushedAtStr, sizeStr)
+			toDelete = append(toDelete[:selected:selected], cfg.audit.kept(cfg, repoName, artifacts, vetted, "vetoed by -pre-delete-hook")...)
+		} else {
+			toDelete = toDelete[:selected]
 		}
+	}
+
+	return stats, toDelete, nil
+}
+
+// keepProtected returns the images that nothing protects: running
+// workloads, deployment manifests, Argo CD, the keep-list and -protect-tags
+func keepProtected(repoName string, toDelete []types.ImageDetail, cfg Config) []types.ImageDetail {
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, cfg.inUse.exclude(repoName, toDelete), "in use")
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, excludeDeployed(repoName, toDelete, cfg.deployments), "in a deployment manifest")
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, cfg.argoCD.exclude(repoName, toDelete), "deployed by Argo CD")
+	toDelete = cfg.audit.kept(cfg, repoName, toDelete, cfg.keepList.exclude(repoName, toDelete), "in the keep-list")
+	return cfg.audit.kept(cfg, repoName, toDelete, excludeProtectedTags(repoName, toDelete, cfg.ProtectTags), "protected tag")
+}
+
+// claimImages claims the deletion of the selected images against the
+// deletion limits and summarizes them. The images quarantined now are
+// deleted by a later run, so they are claimed too.
+func claimImages(repoName string, stats repositoryStats, toDelete []types.ImageDetail, cfg Config) (CleanupSummary, error) {
+	repoSummary := CleanupSummary{RepositoriesProcessed: 1, ImagesScanned: stats.images}
+	quarantine := stats.quarantine
+	if len(toDelete) == 0 && len(quarantine.add) == 0 {
+		slog.Info("No images to delete", "repository", repoName)
+		repoSummary.Released = imageSummaries(quarantine.release)
 		return repoSummary, nil
 	}
 
-	// Delete the images
-	err = deleteImages(ctx, client, repoName, toDelete)
-	if err != nil {
+	// Refuse the repository rather than go past a deletion limit
+	if err := cfg.deletions.reserve(repoName, len(toDelete)+len(quarantine.add), stats.images); err != nil {
+		cfg.audit.record(cfg, auditSkipped, repoName, toDelete, err.Error())
 		return repoSummary, err
 	}
-	
+	if len(toDelete) > 0 {
+		cfg.audit.record(cfg, auditSelected, repoName, toDelete, selectionReason(repoName, cfg))
+		repoSummary = deletionSummary(stats.images, toDelete, cfg)
+		slog.Info("Selected images for deletion",
+			"repository", repoName,
+			"images", len(toDelete),
+			"space_freed_mb", roundMB(repoSummary.SpaceFreed),
+			"estimated_monthly_savings_usd", roundUSD(repoSummary.EstimatedMonthlySavings))
+	}
+	repoSummary.Quarantined = imageSummaries(quarantine.add)
+	repoSummary.Released = imageSummaries(quarantine.release)
 	return repoSummary, nil
 }
 
-// getImageDetails gets details for all images in a repository
-func getImageDetails(ctx context.Context, client ECRClient, repoName string) ([]types.ImageDetail, error) {
-	var images []types.ImageDetail
-	var nextToken *string
+// imageSummaries converts ECR image details into ImageSummaries
+func imageSummaries(images []types.ImageDetail) []ImageSummary {
+	var summaries []ImageSummary
+	for _, img := range images {
+		summaries = append(summaries, newImageSummary(img))
+	}
+	return summaries
+}
 
-	for {
-		// First, get the image IDs
-		listResp, err := client.ListImages(ctx, &ecr.ListImagesInput{
-			RepositoryName: aws.String(repoName),
-			NextToken:      nextToken,
-		})
+// deletionSummary summarizes a repository whose images were scanned and
+// some of them deleted
+func deletionSummary(scanned int, deleted []types.ImageDetail, cfg Config) CleanupSummary {
+	repoSummary := CleanupSummary{
+		RepositoriesProcessed: 1,
+		ImagesScanned:         scanned,
+		ImagesDeleted:         len(deleted),
+	}
+	
+	// Calculate space to be freed; untagged images keep their data
+	for _, img := range deleted {
+		if img.ImageSizeInBytes != nil && !cfg.UntagOnly {
+			repoSummary.SpaceFreed += *img.ImageSizeInBytes
+		}
+		repoSummary.Images = append(repoSummary.Images, newImageSummary(img))
+	}
+	repoSummary.EstimatedMonthlySavings = monthlySavings(repoSummary.SpaceFreed, cfg.StoragePrice)
+	return repoSummary
+}
+
+// deleteRepositoryImages deletes the selected images of a repository, or
+// only logs them in dry run mode. It returns the images that were deleted,
+// which are fewer than selected when the run stopped between two batches
+// or some images couldn't be deleted.
+func deleteRepositoryImages(ctx context.Context, client ECRClient, repoName string, toDelete []types.ImageDetail, cfg Config) ([]types.ImageDetail, error) {
+	// If in dry run mode, just print what would be deleted
+	if cfg.DryRun {
+		logDryRun(repoName, toDelete, cfg, cfg.now())
+		cfg.replication.logDryRun(repoName, toDelete)
+		return toDelete, nil
+	}
+
+	// Delete the images a batch at a time, so a stopped run finishes the
+	// batch in flight and starts no other
+	var deleted []types.ImageDetail
+	failed := 0
+	size := cfg.deleteBatchSize()
+	for i := 0; i < len(toDelete); i += size {
+		if i > 0 {
+			pauseBetweenBatches(ctx, cfg)
+		}
+		if err := stopError(ctx, cfg); err != nil {
+			slog.Warn("Stopped before deleting every selected image", "repository", repoName, "deleted", len(deleted), "selected", len(toDelete))
+			cfg.audit.record(cfg, auditSkipped, repoName, toDelete[i:], err.Error())
+			return deleted, err
+		}
+		if err := cfg.breaker.err(); err != nil {
+			cfg.audit.record(cfg, auditSkipped, repoName, toDelete[i:], err.Error())
+			return deleted, err
+		}
+		
+		batch := toDelete[i:min(i+size, len(toDelete))]
+		batchDeleted, err := deleteRepositoryBatch(ctx, client, repoName, batch, cfg)
+		deleted = append(deleted, batchDeleted...)
+		if errors.Is(err, errImagesNotDeleted) {
+			// The failed images were already audited and queued for retry
+			failed += len(batch) - len(batchDeleted)
+			continue
+		}
 		if err != nil {
+			cfg.audit.record(cfg, auditFailed, repoName, toDelete[i:], err.Error())
+			cfg.retries.add(cfg, repoName, toDelete[i:])
+			return deleted, err
+		}
+	}
+	if failed > 0 {
+		return deleted, fmt.Errorf("%w: %d of %d selected images", errImagesNotDeleted, failed, len(toDelete))
+	}
+	return deleted, nil
+}
+
+// deleteRepositoryBatch exports, archives and deletes a batch of images,
+// and returns the images that were deleted
+func deleteRepositoryBatch(ctx context.Context, client ECRClient, repoName string, batch []types.ImageDetail, cfg Config) ([]types.ImageDetail, error) {
+	if cfg.UntagOnly {
+		if err := untagImages(ctx, client, cfg.tags, repoName, batch, cfg); err != nil {
+			return nil, err
+		}
+		return batch, nil
+	}
+	if cfg.manifests != nil {
+		if err := cfg.manifests.export(ctx, repoName, batch); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.archive != nil {
+		if err := cfg.archive.archiveImages(ctx, repoName, batch); err != nil {
 			return nil, err
 		}
+	}
+	return deleteImages(ctx, client, repoName, batch, cfg)
+}
 
-		// Get detailed information about these images
-		if len(listResp.ImageIds) > 0 {
-			descResp, err := client.DescribeImages(ctx, &ecr.DescribeImagesInput{
-				RepositoryName: aws.String(repoName),
-				ImageIds:       listResp.ImageIds,
-			})
-			if err != nil {
-				return nil, err
-			}
+// batchDeleteSize is the maximum number of images BatchDeleteImage accepts
+const batchDeleteSize = 100
+
+// deleteBatchSize returns the number of images to delete per batch
+func (cfg Config) deleteBatchSize() int {
+	if cfg.DeleteBatchSize <= 0 || cfg.DeleteBatchSize > batchDeleteSize {
+		return batchDeleteSize
+	}
+	return cfg.DeleteBatchSize
+}
+
+// pauseBetweenBatches waits -delete-batch-delay, or less when the run
+// stops in the meantime
+func pauseBetweenBatches(ctx context.Context, cfg Config) {
+	if cfg.DeleteBatchDelay <= 0 {
+		return
+	}
+	timer := time.NewTimer(cfg.DeleteBatchDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-cfg.stop:
+	case <-ctx.Done():
+	}
+}
 
-			images = append(images, descResp.ImageDetails...)
+// describeImagesBatchSize is the maximum number of image IDs DescribeImages accepts
+const describeImagesBatchSize = 100
+
+// describeImageIDs describes the given images in batches that respect the
+// DescribeImages limit, running up to concurrency batches in parallel. The
+// details are returned in the order of the image IDs.
+func describeImageIDs(ctx context.Context, client ECRClient, repoName string, imageIds []types.ImageIdentifier, concurrency int) ([]types.ImageDetail, error) {
+	var batches [][]types.ImageIdentifier
+	for i := 0; i < len(imageIds); i += describeImagesBatchSize {
+		end := i + describeImagesBatchSize
+		if end > len(imageIds) {
+			end = len(imageIds)
 		}
+		batches = append(batches, imageIds[i:end])
+	}
 
-		nextToken = listResp.NextToken
-		if nextToken == nil {
-			break
+	results := make([][]types.ImageDetail, len(batches))
+	indexes := make([]int, len(batches))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	runConcurrently(indexes, concurrency, func(i int) {
+		descResp, err := client.DescribeImages(ctx, &ecr.DescribeImagesInput{
+			RepositoryName: aws.String(repoName),
+			ImageIds:       batches[i],
+		})
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			return
 		}
+		results[i] = descResp.ImageDetails
+	})
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
+	var images []types.ImageDetail
+	for _, details := range results {
+		images = append(images, details...)
+	}
 	return images, nil
 }
 
 // selectImagesForDeletion determines which images should be deleted
 func selectImagesForDeletion(images []types.ImageDetail, cfg Config) []types.ImageDetail {
-	cutoffTime := time.Now().AddDate(0, 0, -cfg.Days)
+	cutoffTime := cfg.ageCutoff(cfg.now())
 	var toDelete []types.ImageDetail
 
 	// Sort images by pushed time (newest first)
@@ -250,8 +1541,8 @@ func selectImagesForDeletion(images []types.ImageDetail, cfg Config) []types.Ima
 			continue
 		}
 
-		// Delete images older than the cutoff time
-		if img.ImagePushedAt != nil && img.ImagePushedAt.Before(cutoffTime) {
+		// Delete images older than the cutoff time, within -pushed-after
+		if cfg.agedOut(img.ImagePushedAt, cutoffTime) {
 			toDelete = append(toDelete, img)
 		}
 	}
@@ -284,13 +1575,21 @@ func getImageTag(img types.ImageDetail) string {
 	return *img.ImageDigest
 }
 
-// deleteImages deletes the specified images from the repository
-func deleteImages(ctx context.Context, client ECRClient, repoName string, images []types.ImageDetail) error {
-	// AWS API has a limit of 100 images per batch delete operation
-	const batchSize = 100
+// errImagesNotDeleted reports images BatchDeleteImage refused to delete.
+// They were logged, audited and queued for retry; the repository fails.
+var errImagesNotDeleted = errors.New("failed to delete some images")
 
-	for i := 0; i < len(images); i += batchSize {
-		end := i + batchSize
+// deleteImages deletes the specified images from the repository by digest,
+// which removes every tag of an image at once. With -delete-by-tag, tagged
+// images are deleted by their first tag instead, except in repositories
+// with immutable tags and when applying a plan, which names exact digests.
+// It returns the images that were deleted, and errImagesNotDeleted when
+// some of them weren't.
+func deleteImages(ctx context.Context, client ECRClient, repoName string, images []types.ImageDetail, cfg Config) ([]types.ImageDetail, error) {
+	byDigest := !cfg.DeleteByTag || cfg.plan != nil || cfg.immutableTags[repoName]
+	var allDeleted []types.ImageDetail
+	for i := 0; i < len(images); i += batchDeleteSize {
+		end := i + batchDeleteSize
 		if end > len(images) {
 			end = len(images)
 		}
@@ -300,7 +1599,7 @@ func deleteImages(ctx context.Context, client ECRClient, repoName string, images
 
 		for j, img := range batch {
 			// Prefer tag if available, otherwise use digest
-			if len(img.ImageTags) > 0 {
+			if len(img.ImageTags) > 0 && !byDigest {
 				imageIds[j] = types.ImageIdentifier{
 					ImageTag: aws.String(img.ImageTags[0]),
 				}
@@ -316,23 +1615,49 @@ func deleteImages(ctx context.Context, client ECRClient, repoName string, images
 			ImageIds:       imageIds,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to delete batch of images: %w", err)
+			cfg.breaker.record(len(batch), len(batch))
+			return allDeleted, fmt.Errorf("failed to delete batch of images: %w", err)
 		}
-
-		log.Printf("Deleted %d images from repository %s", len(batch), repoName)
+		cfg.breaker.record(len(batch), len(result.Failures))
 		
 		// Log any failures
+		failed := make(map[string]string)
 		if len(result.Failures) > 0 {
 			for _, failure := range result.Failures {
-				log.Printf("Failed to delete image: %s, reason: %s, code: %s",
-					getImageIdString(failure.ImageId),
-					*failure.FailureReason,
-					string(failure.FailureCode))
+				slog.Error("Failed to delete image",
+					"action", "delete",
+					"repository", repoName,
+					"image", getImageIdString(failure.ImageId),
+					"reason", aws.ToString(failure.FailureReason),
+					"code", string(failure.FailureCode))
+				failed[getImageIdString(failure.ImageId)] = aws.ToString(failure.FailureReason)
+			}
+		}
+		
+		var deleted []types.ImageDetail
+		for j, img := range batch {
+			if reason, ok := failed[getImageIdString(&imageIds[j])]; ok {
+				cfg.audit.record(cfg, auditFailed, repoName, batch[j:j+1], reason)
+				cfg.retries.add(cfg, repoName, batch[j:j+1])
+			} else {
+				cfg.audit.record(cfg, auditDeleted, repoName, batch[j:j+1], "")
+				deleted = append(deleted, img)
 			}
 		}
+		slog.Info("Deleted images", "action", "delete", "repository", repoName, "images", len(deleted))
+		for _, img := range deleted {
+			slog.Debug("Deleted image", "action", "delete", "repository", repoName,
+				"tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+		}
+		cfg.events.imagesDeleted(ctx, cfg, repoName, deleted)
+		cfg.replication.imagesDeleted(ctx, repoName, deleted)
+		allDeleted = append(allDeleted, deleted...)
 	}
 
-	return nil
+	if len(allDeleted) < len(images) {
+		return allDeleted, fmt.Errorf("%w: %d of %d images", errImagesNotDeleted, len(images)-len(allDeleted), len(images))
+	}
+	return allDeleted, nil
 }
 
 // getImageIdString creates a string representation of an ImageIdentifier
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,10 +45,15 @@ type MockECRClient struct {
 	
 	// Custom handlers for pagination testing
 	NextDescribeRepositoriesOutput *ecr.DescribeRepositoriesOutput
+	
+	// Guards the call tracking when repositories are processed concurrently
+	mu sync.Mutex
 }
 
 // DescribeRepositories mock implementation
 func (m *MockECRClient) DescribeRepositories(ctx context.Context, params *ecr.DescribeRepositoriesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.DescribeRepositoriesCalls++
 	m.LastDescribeRepositoriesInput = params
 	
@@ -67,6 +77,8 @@ func (m *MockECRClient) DescribeRepositories(ctx context.Context, params *ecr.De
 
 // ListImages mock implementation
 func (m *MockECRClient) ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.ListImagesCalls++
 	m.LastListImagesInput = params
 	
@@ -80,6 +92,8 @@ func (m *MockECRClient) ListImages(ctx context.Context, params *ecr.ListImagesIn
 
 // DescribeImages mock implementation
 func (m *MockECRClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.DescribeImagesCalls++
 	m.LastDescribeImagesInput = params
 	
@@ -93,6 +107,8 @@ func (m *MockECRClient) DescribeImages(ctx context.Context, params *ecr.Describe
 
 // BatchDeleteImage mock implementation
 func (m *MockECRClient) BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.BatchDeleteImageCalls++
 	m.LastBatchDeleteImageInput = params
 	
@@ -185,8 +201,8 @@ func TestGetRepositories(t *testing.T) {
 	})
 }
 
-// TestGetImageDetails tests the getImageDetails function
-func TestGetImageDetails(t *testing.T) {
+// TestScanImagePages tests listing and describing the images of a repository
+func TestScanImagePages(t *testing.T) {
 	// Test with a single page of results
 	t.Run("Single page of images", func(t *testing.T) {
 		repoName := "test-repo"
@@ -214,7 +230,7 @@ func TestGetImageDetails(t *testing.T) {
 		}
 
 		// Call the function
-		images, err := getImageDetails(context.Background(), mockClient, repoName)
+		images, err := scanAllImages(context.Background(), mockClient, repoName, Config{})
 
 		// Assertions
 		if err != nil {
@@ -247,7 +263,7 @@ func TestGetImageDetails(t *testing.T) {
 		}
 
 		// Call the function
-		images, err := getImageDetails(context.Background(), mockClient, repoName)
+		images, err := scanAllImages(context.Background(), mockClient, repoName, Config{})
 
 		// Assertions
 		if err != nil {
@@ -264,10 +280,112 @@ func TestGetImageDetails(t *testing.T) {
 		}
 	})
 	
+	// Test that a large ListImages page is split into DescribeImages batches
+	t.Run("Large page is chunked", func(t *testing.T) {
+		imageIds := make([]types.ImageIdentifier, 250)
+		for i := range imageIds {
+			imageIds[i] = types.ImageIdentifier{ImageDigest: aws.String(fmt.Sprintf("sha256:%d", i))}
+		}
+		
+		mockClient := &MockECRClient{
+			ListImagesOutput: &ecr.ListImagesOutput{
+				ImageIds: imageIds,
+			},
+			DescribeImagesOutput: &ecr.DescribeImagesOutput{
+				ImageDetails: []types.ImageDetail{{ImageDigest: aws.String("sha256:1")}},
+			},
+		}
+		
+		// Call the function with parallel describe calls
+		images, err := scanAllImages(context.Background(), mockClient, "test-repo", Config{DescribeConcurrency: 3})
+		
+		// Assertions
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if mockClient.DescribeImagesCalls != 3 {
+			t.Errorf("Expected 3 calls to DescribeImages, got %d", mockClient.DescribeImagesCalls)
+		}
+		if len(images) != 3 {
+			t.Errorf("Expected 3 images (one per batch), got %d", len(images))
+		}
+	})
+	
+	// Test paging through DescribeImages without ListImages
+	t.Run("Skip ListImages", func(t *testing.T) {
+		mockClient := &MockECRClient{
+			DescribeImagesOutput: &ecr.DescribeImagesOutput{
+				ImageDetails: []types.ImageDetail{
+					{ImageDigest: aws.String("sha256:1")},
+					{ImageDigest: aws.String("sha256:2")},
+				},
+			},
+		}
+		
+		// Call the function
+		images, err := scanAllImages(context.Background(), mockClient, "test-repo", Config{SkipListImages: true})
+		
+		// Assertions
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(images) != 2 {
+			t.Errorf("Expected 2 images, got %d", len(images))
+		}
+		if mockClient.ListImagesCalls != 0 {
+			t.Errorf("Expected 0 calls to ListImages, got %d", mockClient.ListImagesCalls)
+		}
+		if mockClient.LastDescribeImagesInput.ImageIds != nil {
+			t.Errorf("Expected DescribeImages to be called without image IDs")
+		}
+	})
+	
 	// Note: We can't effectively test pagination with our mock structure
 	// Because we can't override the ListImages method in Go
 }
 
+// TestDescribeImageIDs tests batching of DescribeImages calls
+func TestDescribeImageIDs(t *testing.T) {
+	// Test that every batch stays within the API limit
+	t.Run("Batches respect the limit", func(t *testing.T) {
+		imageIds := make([]types.ImageIdentifier, 201)
+		for i := range imageIds {
+			imageIds[i] = types.ImageIdentifier{ImageTag: aws.String(fmt.Sprintf("v%d", i))}
+		}
+		
+		mockClient := &MockECRClient{
+			DescribeImagesOutput: &ecr.DescribeImagesOutput{},
+		}
+		
+		_, err := describeImageIDs(context.Background(), mockClient, "test-repo", imageIds, 1)
+		
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if mockClient.DescribeImagesCalls != 3 {
+			t.Errorf("Expected 3 calls to DescribeImages, got %d", mockClient.DescribeImagesCalls)
+		}
+		// The last batch holds the remainder
+		if len(mockClient.LastDescribeImagesInput.ImageIds) != 1 {
+			t.Errorf("Expected last batch to have 1 image ID, got %d", len(mockClient.LastDescribeImagesInput.ImageIds))
+		}
+	})
+	
+	// Test that an error in any batch is returned
+	t.Run("Batch error", func(t *testing.T) {
+		mockClient := &MockECRClient{
+			DescribeImagesError: &types.ServerException{Message: aws.String("Describe error")},
+		}
+		
+		imageIds := []types.ImageIdentifier{{ImageTag: aws.String("v1")}}
+		_, err := describeImageIDs(context.Background(), mockClient, "test-repo", imageIds, 4)
+		
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
 // TestSelectImagesForDeletion tests the selectImagesForDeletion function
 func TestSelectImagesForDeletion(t *testing.T) {
 	now := time.Now()
@@ -542,7 +660,7 @@ func TestDeleteImages(t *testing.T) {
 		}
 		
 		// Call the function
-		err := deleteImages(context.Background(), mockClient, repoName, images)
+		_, err := deleteImages(context.Background(), mockClient, repoName, images, Config{})
 		
 		// Assertions
 		if err != nil {
@@ -580,7 +698,7 @@ func TestDeleteImages(t *testing.T) {
 		}
 		
 		// Call the function
-		err := deleteImages(context.Background(), mockClient, repoName, images)
+		_, err := deleteImages(context.Background(), mockClient, repoName, images, Config{})
 		
 		// Assertions
 		if err != nil {
@@ -625,12 +743,15 @@ func TestDeleteImages(t *testing.T) {
 			},
 		}
 		
-		// Call the function - should not error even with failures
-		err := deleteImages(context.Background(), mockClient, repoName, images)
+		// Call the function - the failed image is reported
+		deleted, err := deleteImages(context.Background(), mockClient, repoName, images, Config{})
 		
 		// Assertions
-		if err != nil {
-			t.Fatalf("Expected no error, got %v", err)
+		if !errors.Is(err, errImagesNotDeleted) {
+			t.Fatalf("Expected the failed image reported, got %v", err)
+		}
+		if len(deleted) != 1 || aws.ToString(deleted[0].ImageDigest) != "sha256:abc" {
+			t.Errorf("Expected only the first image deleted, got %v", deleted)
 		}
 		if mockClient.BatchDeleteImageCalls != 1 {
 			t.Errorf("Expected 1 call to BatchDeleteImage, got %d", mockClient.BatchDeleteImageCalls)
@@ -642,7 +763,7 @@ func TestDeleteImages(t *testing.T) {
 		mockClient := &MockECRClient{}
 		
 		// Call with empty slice
-		err := deleteImages(context.Background(), mockClient, repoName, []types.ImageDetail{})
+		_, err := deleteImages(context.Background(), mockClient, repoName, []types.ImageDetail{}, Config{})
 		
 		// Assertions
 		if err != nil {
@@ -719,8 +840,8 @@ func TestGetImageIdString(t *testing.T) {
 	})
 }
 
-// TestProcessRepository tests the processRepository function
-func TestProcessRepository(t *testing.T) {
+// TestCleanupRepository tests cleaning up a single repository
+func TestCleanupRepository(t *testing.T) {
 	ctx := context.Background()
 	repoName := "test-repo"
 	now := time.Now()
@@ -742,6 +863,9 @@ func TestProcessRepository(t *testing.T) {
 	// Test in dry run mode with images to delete
 	t.Run("Dry run with old images", func(t *testing.T) {
 		mockClient := &MockECRClient{
+			DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+				Repositories: []types.Repository{{RepositoryName: aws.String(repoName)}},
+			},
 			ListImagesOutput: &ecr.ListImagesOutput{
 				ImageIds: []types.ImageIdentifier{
 					{ImageTag: aws.String("v1")},
@@ -758,7 +882,7 @@ func TestProcessRepository(t *testing.T) {
 			DryRun: true,
 		}
 		
-		summary, err := processRepository(ctx, mockClient, repoName, cfg)
+		summary, err := CleanupWithClient(ctx, cfg, mockClient)
 		
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -777,6 +901,9 @@ func TestProcessRepository(t *testing.T) {
 	// Test actual deletion
 	t.Run("Actual deletion", func(t *testing.T) {
 		mockClient := &MockECRClient{
+			DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+				Repositories: []types.Repository{{RepositoryName: aws.String(repoName)}},
+			},
 			ListImagesOutput: &ecr.ListImagesOutput{
 				ImageIds: []types.ImageIdentifier{
 					{ImageTag: aws.String("v1")},
@@ -798,7 +925,7 @@ func TestProcessRepository(t *testing.T) {
 			DryRun: false,
 		}
 		
-		summary, err := processRepository(ctx, mockClient, repoName, cfg)
+		summary, err := CleanupWithClient(ctx, cfg, mockClient)
 		
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -820,6 +947,9 @@ func TestProcessRepository(t *testing.T) {
 	// Test with no images to delete
 	t.Run("No images to delete", func(t *testing.T) {
 		mockClient := &MockECRClient{
+			DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+				Repositories: []types.Repository{{RepositoryName: aws.String(repoName)}},
+			},
 			ListImagesOutput: &ecr.ListImagesOutput{
 				ImageIds: []types.ImageIdentifier{
 					{ImageTag: aws.String("latest")},
@@ -835,7 +965,7 @@ func TestProcessRepository(t *testing.T) {
 			DryRun: false,
 		}
 		
-		summary, err := processRepository(ctx, mockClient, repoName, cfg)
+		summary, err := CleanupWithClient(ctx, cfg, mockClient)
 		
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -908,35 +1038,8 @@ func TestCleanupECR(t *testing.T) {
 			DryRun: false,
 		}
 		
-		// Helper function that simulates cleanupECR but uses our mock client
-		testCleanupWithMockClient := func(ctx context.Context, cfg Config, client ECRClient) (CleanupSummary, error) {
-			summary := CleanupSummary{}
-			
-			// Get repositories
-			repos, err := getRepositories(ctx, client)
-			if err != nil {
-				return summary, err
-			}
-			
-			summary.RepositoriesProcessed = len(repos)
-			
-			// Process each repository
-			for _, repo := range repos {
-				repoSummary, err := processRepository(ctx, client, *repo.RepositoryName, cfg)
-				if err != nil {
-					// Log error and continue in real code
-					continue
-				}
-				
-				summary.ImagesDeleted += repoSummary.ImagesDeleted
-				summary.SpaceFreed += repoSummary.SpaceFreed
-			}
-			
-			return summary, nil
-		}
-		
 		// Execute test
-		summary, err := testCleanupWithMockClient(ctx, cfg, mockClient)
+		summary, err := CleanupWithClient(ctx, cfg, mockClient)
 		
 		// Assertions
 		if err != nil {
@@ -965,7 +1068,7 @@ func TestLoadAWSConfig(t *testing.T) {
 	// Test with default region
 	t.Run("Default region", func(t *testing.T) {
 		ctx := context.Background()
-		_, err := loadAWSConfig(ctx, "")
+		_, err := loadAWSConfig(ctx, Config{})
 		
 		// We're just checking that it doesn't error
 		if err != nil {
@@ -977,7 +1080,7 @@ func TestLoadAWSConfig(t *testing.T) {
 	t.Run("Specified region", func(t *testing.T) {
 		ctx := context.Background()
 		specifiedRegion := "eu-central-1"
-		cfg, err := loadAWSConfig(ctx, specifiedRegion)
+		cfg, err := loadAWSConfig(ctx, Config{Region: specifiedRegion})
 		
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -988,6 +1091,44 @@ func TestLoadAWSConfig(t *testing.T) {
 			t.Errorf("Expected region to be %s, got %s", specifiedRegion, cfg.Region)
 		}
 	})
+	
+	// Test with a named profile that doesn't exist
+	t.Run("Missing profile", func(t *testing.T) {
+		ctx := context.Background()
+		t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+		
+		_, err := loadAWSConfig(ctx, Config{Profile: "does-not-exist"})
+		
+		// The SDK refuses to load a profile that isn't defined
+		if err == nil {
+			t.Fatal("Expected an error for a missing profile")
+		}
+	})
+	
+	// Test with a named profile from a custom config file
+	t.Run("Named profile", func(t *testing.T) {
+		ctx := context.Background()
+		configFile := filepath.Join(t.TempDir(), "config")
+		err := os.WriteFile(configFile, []byte("[profile staging]\nregion = ap-south-1\n"), 0o600)
+		if err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		t.Setenv("AWS_CONFIG_FILE", configFile)
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+		t.Setenv("AWS_REGION", "")
+		t.Setenv("AWS_DEFAULT_REGION", "")
+		
+		cfg, err := loadAWSConfig(ctx, Config{Profile: "staging"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		
+		// The region comes from the profile
+		if cfg.Region != "ap-south-1" {
+			t.Errorf("Expected region from profile to be ap-south-1, got %s", cfg.Region)
+		}
+	})
 }
 
 // TestParseFlags tests the parseFlags function
@@ -1052,4 +1193,39 @@ func TestParseFlags(t *testing.T) {
 			t.Errorf("Expected MaxImages to be 5, got %d", config.MaxImages)
 		}
 	})
-}
\ No newline at end of file
+}
+
+// TestDeleteImagesIdentifiers tests choosing between digests and tags to delete images
+func TestDeleteImagesIdentifiers(t *testing.T) {
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1", "stable"}},
+		{ImageDigest: aws.String("sha256:bbb")},
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected []string
+	}{
+		{"By digest by default", Config{}, []string{"sha256:aaa", "sha256:bbb"}},
+		{"By tag when asked", Config{DeleteByTag: true}, []string{"v1", "sha256:bbb"}},
+		{"By digest when tags are immutable", Config{DeleteByTag: true, immutableTags: map[string]bool{"repo": true}}, []string{"sha256:aaa", "sha256:bbb"}},
+		{"By digest when applying a plan", Config{DeleteByTag: true, plan: &Plan{}}, []string{"sha256:aaa", "sha256:bbb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}
+			if _, err := deleteImages(context.Background(), client, "repo", images, tt.cfg); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			var ids []string
+			for _, id := range client.LastBatchDeleteImageInput.ImageIds {
+				ids = append(ids, getImageIdString(&id))
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
@@ -2,8 +2,16 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
 )
 
 // This file contains wrappers around the main functions to make them more testable.
@@ -16,32 +24,347 @@ func MainEntry(args []string) int {
 	originalArgs := os.Args
 	defer func() { os.Args = originalArgs }()
 	
+	// A leading non-flag argument selects a command
+	command, args := splitCommand(args)
+	
 	// Set args for parseFlags
 	os.Args = args
 	
 	// Parse command line arguments
 	config := parseFlags()
+	if config.ShowVersion || command == "version" {
+		fmt.Println(currentBuildInfo())
+		return 0
+	}
+	if err := setupLogging(config); err != nil {
+		slog.Error("Invalid logging options", "error", err)
+		return 1
+	}
 	
-	// Run the cleanup
-	summary, err := cleanupECR(config)
-	if err != nil {
-		log.Printf("Error cleaning up ECR repositories: %v", err)
+	// Fail on conflicting or invalid options before anything runs
+	if err := validateConfig(config); err != nil {
+		slog.Error("Invalid options", "error", err)
 		return 1
 	}
 	
-	// Print summary
-	log.Printf("ECR Cleanup Summary:")
-	log.Printf("- Repositories processed: %d", summary.RepositoriesProcessed)
-	log.Printf("- Images deleted: %d", summary.ImagesDeleted)
-	if summary.SpaceFreed > 0 {
-		log.Printf("- Space freed: %.2f MB", float64(summary.SpaceFreed)/1024/1024)
+	// Lifecycle policies replace deleting images, so there is nothing to plan
+	if config.ManageLifecyclePolicies && (command == "plan" || command == "apply") {
+		slog.Error("-manage-lifecycle-policies can't be combined with plan or apply")
+		return 1
 	}
 	
-	if config.DryRun {
-		log.Printf("Note: This was a dry run. No images were actually deleted.")
+	// The simulate command compares whole days
+	if command == "simulate" && (config.OlderThan > 0 || !config.Before.IsZero() || config.pushedRange()) {
+		slog.Error("The simulate command compares -simulate-days and can't be combined with -older-than, -before, -pushed-after or -pushed-before")
+		return 1
+	}
+	
+	// Read the repositories to clean up once, since stdin can't be reread
+	if config.ReposFrom != "" {
+		names, err := readRepositoryList(config.ReposFrom, os.Stdin)
+		if err != nil {
+			slog.Error("Invalid repository list", "error", err)
+			return 1
+		}
+		if len(names) == 0 {
+			slog.Error("-repos-from lists no repositories", "path", config.ReposFrom)
+			return 1
+		}
+		config.Repositories = append(config.Repositories, names...)
+	}
+	
+	// ECR Public has no lifecycle policies, and there is nothing to check
+	// without a threshold
+	if command == "compliance" && (config.Public || !hasComplianceChecks(config)) {
+		slog.Error("The compliance command requires -compliance-max-size-gb, -compliance-max-age-days or -compliance-require-lifecycle-policy, and can't be combined with -public")
+		return 1
+	}
+	if config.SecurityHub && command != "compliance" {
+		slog.Error("-security-hub only applies to the compliance command")
+		return 1
+	}
+	
+	// ECR Public has neither lifecycle policies nor repository tags
+	if command == "coverage" && config.Public {
+		slog.Error("The coverage command can't be combined with -public")
+		return 1
+	}
+	if config.Enforce && command != "coverage" {
+		slog.Error("-enforce only applies to the coverage command")
+		return 1
+	}
+	
+	// The exporter only serves what it scans
+	if command == "exporter" && (config.MetricsAddr == "" || config.ExporterInterval <= 0) {
+		slog.Error("The exporter command requires -metrics-addr and a positive -exporter-interval")
+		return 1
+	}
+	
+	switch command {
+	case "":
+	case "serve":
+		return runServe(config)
+	case "plan":
+		return runPlan(config)
+	case "apply":
+		return runApply(config)
+	case "generate-lifecycle-policy":
+		return runGenerateLifecyclePolicy(config)
+	case "simulate":
+		return runSimulate(config)
+	case "report":
+		return runInventory(config)
+	case "compliance":
+		return runCompliance(config)
+	case "coverage":
+		return runCoverage(config)
+	case "exporter":
+		return runExporter(config)
+	case "restore":
+		return runRestore(config)
+	case "print-iam-policy":
+		return runPrintIAMPolicy(config)
+	case "init":
+		return runInit(config)
+	default:
+		slog.Error("Unknown command", "command", command)
+		return 1
+	}
+	
+	// Let the user pick the images to delete
+	if config.Interactive {
+		return runInteractive(config)
+	}
+	
+	// Serve metrics while the run is in progress
+	if config.MetricsAddr != "" {
+		if _, err := startMetricsServer(config.MetricsAddr); err != nil {
+			slog.Error("Error starting metrics server", "error", err)
+			return 1
+		}
+	}
+	
+	// Keep running and clean up on the schedule
+	if config.Schedule != "" {
+		return runSchedule(config)
+	}
+	
+	// Ask before deleting when someone is at the terminal
+	if needsConfirmation(config, os.Stdin) {
+		plan, err := confirmDeletion(config, os.Stdin, os.Stderr)
+		if errors.Is(err, errDeletionDeclined) {
+			slog.Info("Deletion declined; no images were deleted")
+			return 1
+		}
+		if err != nil {
+			slog.Error("Error cleaning up ECR repositories", "error", err)
+			return 1
+		}
+		if plan.Images == 0 && plan.EmptyRepositories == 0 && plan.quarantineImages() == 0 {
+			slog.Info("No images to delete")
+			return 0
+		}
+		config.plan = plan
 	}
 	
-	return 0
+	// Run the cleanup and report on it, stopping gracefully on a signal
+	config, restoreSignals := stopOnSignal(config)
+	summary, err := runCleanup(config)
+	restoreSignals()
+	if err != nil {
+		return 1
+	}
+	
+	// Keep serving the last run's metrics until the process is stopped
+	if config.MetricsAddr != "" {
+		waitForShutdown()
+	}
+	
+	return exitCode(summary)
+}
+
+// validateConfig rejects conflicting and invalid options before anything
+// runs. It covers the options of every kind of run; the checks that depend
+// on a command are made by MainEntry.
+func validateConfig(config Config) error {
+	// ECR Public lives in us-east-1 only and has no lifecycle policies
+	if config.Public && (len(config.Regions) > 0 || config.AllRegions || config.ManageLifecyclePolicies || config.ArchiveTo != "" || config.ManifestsS3 != "") {
+		return fmt.Errorf("-public can't be combined with -regions, -all-regions, -manage-lifecycle-policies, -archive-to or -manifests-s3")
+	}
+
+	// Lifecycle policies replace deleting images, so there is nothing to pick
+	if config.ManageLifecyclePolicies && config.Interactive {
+		return fmt.Errorf("-manage-lifecycle-policies can't be combined with -interactive")
+	}
+
+	// ECR Public doesn't record pulls, so every image would look never pulled
+	if config.Public && config.NeverPulledDays > 0 {
+		return fmt.Errorf("-delete-never-pulled-after-days can't be combined with -public")
+	}
+
+	// ECR Public has no API to read image configs
+	if config.HonorExpiryLabels && (config.Public || config.ExpiryLabel == "") {
+		return fmt.Errorf("-honor-expiry-labels can't be combined with -public and requires an -expiry-label")
+	}
+
+	// -days is the default age limit, so only the finer ones conflict
+	if config.OlderThan > 0 && !config.Before.IsZero() {
+		return fmt.Errorf("-older-than and -before can't be combined")
+	}
+	if config.pushedRange() && (config.OlderThan > 0 || !config.Before.IsZero()) {
+		return fmt.Errorf("-pushed-after and -pushed-before can't be combined with -older-than or -before")
+	}
+	if !config.PushedAfter.IsZero() && !config.PushedBefore.IsZero() && !config.PushedAfter.Before(config.PushedBefore) {
+		return fmt.Errorf("-pushed-after must be before -pushed-before")
+	}
+
+	// Fail on a bad -config file before anything runs
+	if _, err := loadInUseProviders(config); err != nil {
+		return fmt.Errorf("invalid -config file: %w", err)
+	}
+
+	if config.KeepPerTagFamily {
+		if _, err := compileTagFamilyPattern(config.TagFamilyPattern); err != nil {
+			return err
+		}
+	}
+
+	if config.DeleteBatchSize < 1 || config.DeleteBatchSize > batchDeleteSize {
+		return fmt.Errorf("-delete-batch-size must be between 1 and 100, got %d", config.DeleteBatchSize)
+	}
+
+	// Fail before the run rather than lose its outputs after it
+	if config.GHAOutput && os.Getenv("GITHUB_OUTPUT") == "" {
+		return fmt.Errorf("-gha-output requires GITHUB_OUTPUT to be set, as it is in GitHub Actions")
+	}
+
+	// Only private repositories are deleted, and lifecycle policies don't
+	// scan for empty ones
+	if config.DeleteEmptyRepos && (config.Public || config.ManageLifecyclePolicies) {
+		return fmt.Errorf("-delete-empty-repos can't be combined with -public or -manage-lifecycle-policies")
+	}
+
+	// ECR Public repositories and lifecycle policies don't read repository tags
+	if usesRepositoryTags(config) && (config.Public || config.ManageLifecyclePolicies) {
+		return fmt.Errorf("-tag-policies and -opt-in-tag can't be combined with -public or -manage-lifecycle-policies")
+	}
+	if key, _ := parseTagFilter(config.OptInTag); config.OptInTag != "" && key == "" {
+		return fmt.Errorf("invalid opt-in tag %q: expected key=value or key", config.OptInTag)
+	}
+
+	// Untagged images keep their data, so there is nothing to keep a copy of
+	if config.UntagOnly && (config.Public || config.ManageLifecyclePolicies || config.ArchiveTo != "" || config.ManifestsS3 != "" || config.DeleteByTag) {
+		return fmt.Errorf("-untag-only can't be combined with -public, -manage-lifecycle-policies, -archive-to, -manifests-s3 or -delete-by-tag")
+	}
+	// Quarantine tags are how a later run knows when an image was selected
+	if config.QuarantineDays > 0 && (config.Public || config.UntagOnly || config.ManageLifecyclePolicies) {
+		return fmt.Errorf("-quarantine-days can't be combined with -public, -untag-only or -manage-lifecycle-policies")
+	}
+	if config.ManifestsS3 != "" {
+		if _, err := parseS3URI(config.ManifestsS3); err != nil {
+			return fmt.Errorf("invalid manifest export: %w", err)
+		}
+	}
+	if config.ArchiveTo != "" {
+		if _, err := parseArchiveTarget(config.ArchiveTo); err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+	}
+	if config.Window != "" {
+		if _, err := parseMaintenanceWindow(config.Window); err != nil {
+			return fmt.Errorf("invalid maintenance window: %w", err)
+		}
+	}
+	if config.EndpointURL != "" || config.ProxyURL != "" {
+		if _, err := endpointOptions(Config{EndpointURL: config.EndpointURL, FIPS: config.FIPS, DualStack: config.DualStack, ProxyURL: config.ProxyURL}); err != nil {
+			return fmt.Errorf("invalid endpoint options: %w", err)
+		}
+	}
+	if _, err := retryOptions(config); err != nil {
+		return fmt.Errorf("invalid AWS retry options: %w", err)
+	}
+	if config.RegistryID != "" {
+		if _, err := registryIDOptions(config); err != nil {
+			return fmt.Errorf("invalid registry: %w", err)
+		}
+		// One registry is cleaned up, and archives are copied through the
+		// caller's own registry login
+		if config.Public || config.AssumeRolesFile != "" || config.OrgMode || config.ArchiveTo != "" {
+			return fmt.Errorf("-registry-id can't be combined with -public, -assume-roles, -org-mode or -archive-to")
+		}
+	}
+	if config.LockS3 != "" && config.LockTable != "" {
+		return fmt.Errorf("-lock-s3 and -lock-table are mutually exclusive")
+	}
+	if config.LockS3 != "" {
+		if _, err := parseS3URI(config.LockS3); err != nil {
+			return fmt.Errorf("invalid lock location: %w", err)
+		}
+	}
+	if config.HistoryS3 != "" && config.HistoryDynamoDB != "" {
+		return fmt.Errorf("-history-s3 and -history-dynamodb are mutually exclusive")
+	}
+	if config.HistoryS3 != "" {
+		if _, err := parseS3URI(config.HistoryS3); err != nil {
+			return fmt.Errorf("invalid history location: %w", err)
+		}
+	}
+	if config.ArgoCDServer != "" {
+		if _, err := parseArgoCDServer(config.ArgoCDServer); err != nil {
+			return fmt.Errorf("invalid Argo CD server: %w", err)
+		}
+	} else if config.ArgoCDToken != "" {
+		return fmt.Errorf("-argocd-token requires -argocd-server")
+	}
+
+	// Lifecycle policies neither list nor delete images
+	if config.Preflight && config.ManageLifecyclePolicies {
+		return fmt.Errorf("-preflight can't be combined with -manage-lifecycle-policies")
+	}
+
+	// ECR Public publishes no pull metrics, and lifecycle policies can't
+	// tell repositories apart by their pulls
+	if config.PullActivityDays > 0 {
+		if config.HotDays == 0 && config.ColdDays == 0 {
+			return fmt.Errorf("-pull-activity-days requires -hot-days or -cold-days")
+		}
+		if config.PullActivityDays > maxPullActivityDays || config.HotPullCount < 1 || config.HotDays < 0 || config.ColdDays < 0 {
+			return fmt.Errorf("-pull-activity-days must be at most 455 (CloudWatch's retention of daily datapoints), -hot-pull-count positive, and -hot-days and -cold-days not negative")
+		}
+		// The metrics are published in the registry's account, which the
+		// caller can't read
+		if config.Public || config.ManageLifecyclePolicies || config.RegistryID != "" {
+			return fmt.Errorf("-pull-activity-days can't be combined with -public, -manage-lifecycle-policies or -registry-id")
+		}
+	} else if config.HotDays != 0 || config.ColdDays != 0 {
+		return fmt.Errorf("-hot-days and -cold-days require -pull-activity-days")
+	}
+
+	// ECR Public has no replication, and deletions only fan out from a
+	// cleanup that deletes images
+	switch config.Replication {
+	case replicationIgnore:
+	case replicationWarn, replicationSourceOnly, replicationFanOut:
+		if config.Public || config.ManageLifecyclePolicies {
+			return fmt.Errorf("-replication can't be combined with -public or -manage-lifecycle-policies")
+		}
+		if config.Replication == replicationFanOut && config.UntagOnly {
+			return fmt.Errorf("-replication fan-out can't be combined with -untag-only")
+		}
+	default:
+		return fmt.Errorf("invalid -replication %q: must be ignore, warn, source-only or fan-out", config.Replication)
+	}
+
+	return nil
+}
+
+// splitCommand separates a command such as "serve" from the program name
+// and flags that follow it
+func splitCommand(args []string) (string, []string) {
+	if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+		return "", args
+	}
+	return args[1], append([]string{args[0]}, args[2:]...)
 }
 
 // MainEntryWithClient is a testable version that accepts a client for testing
@@ -55,61 +378,441 @@ func MainEntryWithClient(args []string, client ECRClient) int {
 	
 	// Parse command line arguments
 	config := parseFlags()
+	if err := setupLogging(config); err != nil {
+		slog.Error("Invalid logging options", "error", err)
+		return 1
+	}
+	
+	if err := validateConfig(config); err != nil {
+		slog.Error("Invalid options", "error", err)
+		return 1
+	}
 	
-	// Use our injected client
-	ctx := context.Background()
-	summary, err := CleanupWithClient(ctx, config, client)
+	// Run the cleanup with our injected client
+	config.client = client
+	summary, err := runCleanup(config)
 	if err != nil {
-		log.Printf("Error cleaning up ECR repositories: %v", err)
 		return 1
 	}
 	
+	return exitCode(summary)
+}
+
+// runCleanup runs the cleanup along with everything that reports on it:
+// tracing, metrics, the summary, reports and notifications. Errors are
+// logged before they are returned.
+func runCleanup(config Config) (CleanupSummary, error) {
+	cleanup := cleanupECR
+	if config.client != nil {
+		cleanup = func(cfg Config) (CleanupSummary, error) {
+			return CleanupWithClient(context.Background(), cfg, cfg.client)
+		}
+	}
+
+	config, shutdownTracing := setupTracing(config)
+	start := time.Now()
+	summary, err := cleanup(config)
+	shutdownTracing()
+	if metricsErr := exportMetrics(summary, config, time.Since(start), err); metricsErr != nil {
+		slog.Error("Error exporting metrics", "error", metricsErr)
+	}
+	if err != nil {
+		slog.Error("Error cleaning up ECR repositories", "error", err)
+		// Show what a stopped run deleted before it stopped
+		if errors.Is(err, errRunStopped) {
+			printSummary(summary, config)
+		}
+		if err := sendNotifications(summary, config, err); err != nil {
+			slog.Error("Error sending notifications", "error", err)
+		}
+		if err := writeSummaryOutputs(summary, config, err); err != nil {
+			slog.Error("Error writing summary outputs", "error", err)
+		}
+		return summary, err
+	}
+
 	// Print summary
-	log.Printf("ECR Cleanup Summary:")
-	log.Printf("- Repositories processed: %d", summary.RepositoriesProcessed)
-	log.Printf("- Images deleted: %d", summary.ImagesDeleted)
-	if summary.SpaceFreed > 0 {
-		log.Printf("- Space freed: %.2f MB", float64(summary.SpaceFreed)/1024/1024)
+	printSummary(summary, config)
+
+	// Write the requested reports
+	if err := writeReports(summary, config); err != nil {
+		slog.Error("Error writing report", "error", err)
+		return summary, err
+	}
+
+	// Compare the run with the previous one, then add it to the history
+	if err := recordHistory(summary, config); err != nil {
+		slog.Error("Error recording run history", "error", err)
+		return summary, err
+	}
+
+	// Tell subscribers how the run went
+	if err := sendNotifications(summary, config, nil); err != nil {
+		slog.Error("Error sending notifications", "error", err)
+		return summary, err
+	}
+
+	// Hand the totals over to the CI pipeline
+	if err := writeSummaryOutputs(summary, config, nil); err != nil {
+		slog.Error("Error writing summary outputs", "error", err)
+		return summary, err
 	}
+
+	return summary, nil
+}
+
+// printSummary logs the results of a cleanup run
+func printSummary(summary CleanupSummary, config Config) {
+	slog.Info("ECR cleanup summary",
+		"dry_run", config.DryRun,
+		"repositories_processed", summary.RepositoriesProcessed,
+		"repositories_deleted", summary.RepositoriesDeleted,
+		"images_scanned", summary.ImagesScanned,
+		"images_deleted", summary.ImagesDeleted,
+		"space_freed_mb", roundMB(summary.SpaceFreed),
+		"space_freed_upper_bound_mb", roundMB(summary.spaceFreedUpperBound()),
+		"estimated_monthly_savings_usd", roundUSD(summary.EstimatedMonthlySavings))
 	
-	if config.DryRun {
-		log.Printf("Note: This was a dry run. No images were actually deleted.")
+	if len(summary.APICalls) > 0 {
+		total := apiCallTotals(summary.APICalls)
+		slog.Info("ECR API calls",
+			"calls", total.Calls,
+			"throttles", total.Throttles,
+			"retries", total.Retries)
+		for _, stats := range summary.APICalls {
+			slog.Debug("ECR API operation",
+				"operation", stats.Operation,
+				"calls", stats.Calls,
+				"throttles", stats.Throttles,
+				"retries", stats.Retries)
+		}
+	}
+
+	for _, region := range summary.Regions {
+		slog.Info("Region summary",
+			"region", region.Region,
+			"repositories_processed", region.RepositoriesProcessed,
+			"images_deleted", region.ImagesDeleted,
+			"space_freed_mb", roundMB(region.SpaceFreed),
+			"space_freed_upper_bound_mb", roundMB(region.spaceFreedUpperBound()),
+			"estimated_monthly_savings_usd", roundUSD(region.EstimatedMonthlySavings))
+	}
+
+	for _, account := range summary.Accounts {
+		slog.Info("Account summary",
+			"account", account.AccountID,
+			"repositories_processed", account.RepositoriesProcessed,
+			"images_deleted", account.ImagesDeleted,
+			"space_freed_mb", roundMB(account.SpaceFreed),
+			"space_freed_upper_bound_mb", roundMB(account.spaceFreedUpperBound()),
+			"estimated_monthly_savings_usd", roundUSD(account.EstimatedMonthlySavings))
+	}
+
+	if summary.ImagesRemaining > 0 {
+		slog.Warn("Deleted images are still present", "images", summary.ImagesRemaining)
 	}
 	
-	return 0
+	printRepositoryTable(summary, config)
+
+	if config.DryRun {
+		slog.Info("Note: This was a dry run. No images were actually deleted.")
+	}
+}
+
+// roundMB converts a byte count to megabytes rounded to two decimals
+func roundMB(bytes int64) float64 {
+	return math.Round(float64(bytes)/1024/1024*100) / 100
 }
 
 // main is the entry point for the application
 func main() {
+	// Inside Lambda, serve invocations instead of parsing the command line
+	if isLambda() {
+		startLambda()
+		return
+	}
+
 	exitCode := MainEntry(os.Args)
 	if exitCode != 0 {
 		os.Exit(exitCode)
 	}
 }
 
+// repositoryRun is a repository being cleaned up by CleanupWithClient,
+// between selecting its images and deleting them
+type repositoryRun struct {
+	name      string
+	createdAt *time.Time
+	ctx       context.Context
+	span      *span
+	skipped   bool
+	stats     repositoryStats
+	toDelete  []types.ImageDetail
+	summary   CleanupSummary
+	duration  time.Duration
+	err       error
+}
+
 // CleanupWithClient is a testable version of cleanupECR that accepts a client
-func CleanupWithClient(ctx context.Context, cfg Config, client ECRClient) (CleanupSummary, error) {
-	summary := CleanupSummary{}
+func CleanupWithClient(ctx context.Context, cfg Config, client ECRClient) (summary CleanupSummary, err error) {
+	ctx, span := startRunSpan(ctx, cfg, "CleanupWithClient")
+	defer func() {
+		span.setAttributes(attribute("ecr.repositories", summary.RepositoriesProcessed))
+		span.end(err)
+	}()
+	
+	// Limit deletions unless the caller already does for a larger run
+	if cfg.deletions == nil {
+		cfg.deletions = newDeletionLimits(cfg)
+		defer func() {
+			if err == nil {
+				err = cfg.deletions.err()
+			}
+		}()
+	}
+	
+	// Read the keep-list, deployment manifests, Argo CD applications and
+	// in-use providers unless the caller already did for a larger run
+	if cfg.keepList == nil {
+		if cfg.keepList, err = loadKeepList(cfg); err != nil {
+			return summary, err
+		}
+	}
+	if cfg.deployments == nil {
+		if cfg.deployments, err = loadDeployments(ctx, cfg); err != nil {
+			return summary, err
+		}
+	}
+	if cfg.argoCD == nil {
+		if cfg.argoCD, err = loadArgoCDImages(ctx, cfg); err != nil {
+			return summary, err
+		}
+	}
+	if cfg.inUse == nil {
+		if _, cfg.inUse, err = loadSharedInUse(ctx, cfg); err != nil {
+			return summary, err
+		}
+	}
 	
 	// Get all repositories
 	repos, err := getRepositories(ctx, client)
 	if err != nil {
-		return summary, err
+		return summary, fmt.Errorf("failed to get repositories: %w", err)
 	}
+	repos = scopeRepositories(repos, cfg.Repositories)
 	
-	summary.RepositoriesProcessed = len(repos)
+	slog.Info("Found repositories", "repositories", len(repos))
 	
-	// Process each repository
+	// Tags of immutable repositories can't be deleted on their own
+	cfg.immutableTags = make(map[string]bool)
 	for _, repo := range repos {
-		repoSummary, err := processRepository(ctx, client, *repo.RepositoryName, cfg)
-		if err != nil {
-			log.Printf("Error processing repository %s: %v", *repo.RepositoryName, err)
+		if repo.ImageTagMutability == types.ImageTagMutabilityImmutable {
+			cfg.immutableTags[aws.ToString(repo.RepositoryName)] = true
+		}
+	}
+	
+	// When applying a plan, only the planned repositories need scanning
+	if cfg.plan != nil {
+		var planned []types.Repository
+		for _, repo := range repos {
+			if cfg.plan.repository(cfg.accountID, cfg.region, *repo.RepositoryName) != nil {
+				planned = append(planned, repo)
+			}
+		}
+		repos = planned
+	}
+	
+	// Repositories finished before the run was interrupted are done
+	repos = cfg.checkpoint.withoutCompleted(cfg, repos)
+	
+	// Archive repositories are never cleaned up along with the others
+	if cfg.archive != nil {
+		repos = cfg.archive.withoutArchive(repos)
+	}
+	
+	// Replicas are cleaned up through their source
+	repos = cfg.replication.withoutReplicas(repos)
+	
+	// Pull-through cache repositories can be refetched, or left alone
+	if cfg.SkipPullThrough {
+		repos = cfg.pullThrough.withoutPullThrough(repos)
+	}
+	
+	// Repository owners can opt in and set their own retention with tags;
+	// a plan already applied them when it was made
+	if usesRepositoryTags(cfg) && cfg.plan == nil {
+		if cfg.repoPolicies, err = loadRepositoryPolicies(ctx, cfg.repoTags, repos, cfg); err != nil {
+			return summary, err
+		}
+		repos = cfg.repoPolicies.withoutSkipped(repos)
+	}
+	
+	// Hot repositories keep their images longer and those nobody pulls
+	// shorter; a plan already applied it when it was made
+	if cfg.PullActivityDays > 0 && cfg.plan == nil {
+		if cfg.pullActivity, err = loadPullActivity(ctx, cfg.pullMetrics, repos, cfg); err != nil {
+			return summary, err
+		}
+	}
+	
+	// Select the images to delete in every repository before deleting any,
+	// so the registry as a whole can be refused
+	runs := make([]*repositoryRun, len(repos))
+	for i, repo := range repos {
+		runs[i] = &repositoryRun{name: *repo.RepositoryName, createdAt: repo.CreatedAt}
+	}
+	activeProgress.begin(len(repos))
+	defer activeProgress.finish()
+	abort := newRunAbort(cfg)
+	runConcurrently(runs, cfg.Concurrency, func(run *repositoryRun) {
+		if abort.aborted() || stopError(ctx, cfg) != nil {
+			run.skipped = true
+			return
+		}
+		
+		start := time.Now()
+		run.ctx, run.span = startRepositorySpan(ctx, run.name)
+		run.stats, run.toDelete, run.err = selectRepositoryImages(run.ctx, client, run.name, cfg)
+		run.duration = time.Since(start)
+		if run.err != nil {
+			abort.fail(run.name, run.err)
+		}
+	})
+	
+	// With a total size target, only delete enough to get the registry under it
+	if cfg.TargetTotalGB > 0 && cfg.plan == nil {
+		selections := make([][]types.ImageDetail, len(runs))
+		for i, run := range runs {
+			selections[i] = run.toDelete
+		}
+		trimRunsToTarget(runs, gbToBytes(cfg.TargetTotalGB))
+		for i, run := range runs {
+			cfg.audit.kept(cfg, run.name, selections[i], run.toDelete, "registry within -target-total-gb")
+		}
+	}
+	
+	processed, selected, scanned := 0, 0, 0
+	for _, run := range runs {
+		if run.skipped {
+			continue
+		}
+		processed++
+		if run.err != nil {
+			run.summary = CleanupSummary{RepositoriesProcessed: 1, ImagesScanned: run.stats.images}
+			continue
+		}
+		run.summary, run.err = claimImages(run.name, run.stats, run.toDelete, cfg)
+		if run.err != nil {
+			abort.fail(run.name, run.err)
 			continue
 		}
+		selected += len(run.toDelete) + len(run.stats.quarantine.add)
+		scanned += run.stats.images
+	}
+	
+	// Report every repository that was scanned, whether or not its images
+	// were deleted
+	aggregator := &summaryAggregator{price: cfg.StoragePrice}
+	finish := func(run *repositoryRun) {
+		if run.err != nil {
+			slog.Error("Error processing repository", "repository", run.name, "error", run.err)
+		}
+		endRepositorySpan(run.span, run.summary, run.err)
+		run.summary.SpaceScanned = run.stats.size
 		
-		summary.ImagesDeleted += repoSummary.ImagesDeleted
-		summary.SpaceFreed += repoSummary.SpaceFreed
+		aggregator.addRepository(run.name, run.summary, run.duration, run.err)
+		activeProgress.repositoryDone(run.summary)
+	}
+	notDeleted := func(run *repositoryRun, reason error) {
+		if run.err == nil {
+			run.summary = CleanupSummary{RepositoriesProcessed: 1, ImagesScanned: run.stats.images}
+			cfg.audit.record(cfg, auditSkipped, run.name, run.toDelete, reason.Error())
+		}
 	}
 	
-	return summary, nil
+	// Delete nothing once the run is aborted, stopped or the registry is refused
+	stopErr := abort.error()
+	if stopErr == nil {
+		stopErr = stopError(ctx, cfg)
+	}
+	if stopErr == nil {
+		stopErr = cfg.breaker.err()
+	}
+	if stopErr == nil {
+		stopErr = cfg.deletions.checkRegistry(selected, scanned)
+	}
+	if stopErr != nil {
+		for _, run := range runs {
+			if !run.skipped {
+				notDeleted(run, stopErr)
+				finish(run)
+			}
+		}
+		summary = aggregator.result()
+		summary.RepositoriesProcessed = processed
+		return summary, stopErr
+	}
+	
+	// Delete the selected images with a bounded pool of workers
+	runConcurrently(runs, cfg.Concurrency, func(run *repositoryRun) {
+		finished := run.err == nil
+		if run.err == nil && len(run.toDelete) > 0 {
+			if err := abort.error(); err != nil {
+				notDeleted(run, err)
+				finished = false
+			} else if err := cfg.breaker.err(); err != nil {
+				notDeleted(run, err)
+				finished = false
+			} else if err := stopError(ctx, cfg); err != nil {
+				notDeleted(run, err)
+				finished = false
+			} else {
+				start := time.Now()
+				deleted, err := deleteRepositoryImages(run.ctx, client, run.name, run.toDelete, cfg)
+				run.duration += time.Since(start)
+				switch {
+				case errors.Is(err, errRunStopped):
+					// Only report the batches that were deleted
+					run.summary = deletionSummary(run.stats.images, deleted, cfg)
+					finished = false
+				case err != nil:
+					run.summary = deletionSummary(run.stats.images, deleted, cfg)
+					run.err = err
+					abort.fail(run.name, err)
+				default:
+					run.summary = verifyDeletions(run.ctx, client, run.name, run.stats.images, run.toDelete, run.summary, cfg)
+				}
+			}
+		}
+		// The quarantine tags follow the deletions, unless the run is
+		// stopping
+		if finished && run.err == nil && abort.error() == nil && stopError(ctx, cfg) == nil {
+			if err := applyQuarantine(run.ctx, client, run.name, run.stats.quarantine, cfg); err != nil {
+				run.err = err
+				abort.fail(run.name, err)
+			}
+		}
+		if isDeletableEmptyRepository(run, cfg) {
+			if abort.error() != nil || stopError(ctx, cfg) != nil {
+				finished = false
+			} else if deleted, err := deleteEmptyRepository(run.ctx, cfg.repoDeleter, run.name, run.createdAt, cfg); err != nil {
+				run.err = err
+				abort.fail(run.name, err)
+			} else if deleted {
+				run.summary.RepositoriesDeleted = 1
+			}
+		}
+		if finished && run.err == nil {
+			cfg.checkpoint.complete(cfg, run.name)
+		}
+		finish(run)
+	})
+	
+	summary = aggregator.result()
+	summary.RepositoriesProcessed = processed
+	
+	if err := abort.error(); err != nil {
+		return summary, err
+	}
+	return summary, stopError(ctx, cfg)
 }
\ No newline at end of file
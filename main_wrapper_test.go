@@ -2,7 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -245,4 +250,82 @@ func TestMainEntryWithClient(t *testing.T) {
 			t.Errorf("Expected exit code 1 for error case, got %d", exitCode)
 		}
 	})
-}
\ No newline at end of file
+}
+
+// TestMainEntryWithClientRunsCleanup tests that the injected client goes
+// through the same reporting and exit codes as a normal run
+func TestMainEntryWithClientRunsCleanup(t *testing.T) {
+	defer func() { flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError) }()
+
+	for _, tt := range []struct {
+		name     string
+		failure  error
+		expected int
+	}{
+		{"Clean run", nil, 0},
+		{"Failed repository", errors.New("access denied"), 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+			client := newGuardrailClient(2, 3)
+			client.BatchDeleteImageError = tt.failure
+
+			if got := MainEntryWithClient([]string{"cmd", "-days=10"}, client); got != tt.expected {
+				t.Errorf("Expected exit code %d, got %d", tt.expected, got)
+			}
+			if client.BatchDeleteImageCalls != 2 {
+				t.Errorf("Expected both repositories cleaned up with the injected client, got %d calls", client.BatchDeleteImageCalls)
+			}
+		})
+	}
+}
+
+// TestValidateConfig tests rejecting conflicting options whatever runs them
+func TestValidateConfig(t *testing.T) {
+	valid := Config{Days: 10, DeleteBatchSize: batchDeleteSize, Replication: replicationIgnore}
+	if err := validateConfig(valid); err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"Public with regions", func(c *Config) { c.Public, c.Regions = true, []string{"eu-west-1"} }, "-public can't be combined"},
+		{"Batch size", func(c *Config) { c.DeleteBatchSize = 101 }, "-delete-batch-size must be between 1 and 100"},
+		{"Interactive lifecycle policies", func(c *Config) { c.ManageLifecyclePolicies, c.Interactive = true, true }, "-interactive"},
+		{"Hot days alone", func(c *Config) { c.HotDays = 90 }, "require -pull-activity-days"},
+		{"Pull activity of another registry", func(c *Config) { c.PullActivityDays, c.HotPullCount, c.HotDays, c.RegistryID = 14, 1, 90, "123456789012" }, "-registry-id"},
+		{"Unknown replication", func(c *Config) { c.Replication = "mirror" }, "invalid -replication"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+			if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// TestSplitCommand tests separating a command from the flags
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantCommand string
+		wantArgs    []string
+	}{
+		{[]string{"ecr-cleanup"}, "", []string{"ecr-cleanup"}},
+		{[]string{"ecr-cleanup", "-dry-run"}, "", []string{"ecr-cleanup", "-dry-run"}},
+		{[]string{"ecr-cleanup", "serve", "-api-addr", ":9000"}, "serve", []string{"ecr-cleanup", "-api-addr", ":9000"}},
+	}
+
+	for _, tt := range tests {
+		command, args := splitCommand(tt.args)
+		if command != tt.wantCommand || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("splitCommand(%v) = %q, %v; expected %q, %v", tt.args, command, args, tt.wantCommand, tt.wantArgs)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// This file contains the S3 export of deleted images' manifests. Before a
+// batch of images is deleted, each image's manifest is stored with its
+// tags and metadata under the -manifests-s3 prefix, which keeps a durable
+// record of exactly what was removed:
+//
+//	<prefix><run start>/<registry ID>/<region>/<repository>/<digest>.json
+
+// ImageManifestClient defines the ECR operation needed to read manifests
+type ImageManifestClient interface {
+	BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+}
+
+// ManifestRecord is the record stored for each deleted image
+type ManifestRecord struct {
+	RegistryID        string    `json:"registry_id"`
+	Region            string    `json:"region,omitempty"`
+	Repository        string    `json:"repository"`
+	Digest            string    `json:"digest"`
+	Tags              []string  `json:"tags,omitempty"`
+	PushedAt          time.Time `json:"pushed_at"`
+	SizeBytes         int64     `json:"size_bytes"`
+	DeletedAt         time.Time `json:"deleted_at"`
+	ManifestMediaType string    `json:"manifest_media_type"`
+	Manifest          string    `json:"manifest"`
+}
+
+// manifestExport stores the manifests of images before they are deleted
+type manifestExport struct {
+	s3       S3Client
+	location s3Location
+	started  time.Time
+
+	// images and region are those of the region being processed
+	images ImageManifestClient
+	region string
+}
+
+// newManifestExport creates the export to the -manifests-s3 location
+func newManifestExport(client S3Client, uri string, started time.Time) (*manifestExport, error) {
+	location, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestExport{s3: client, location: location, started: started.UTC()}, nil
+}
+
+// forRegion returns the export for the region of the given ECR client
+func (m *manifestExport) forRegion(images ImageManifestClient, region string) *manifestExport {
+	regional := *m
+	regional.images = images
+	regional.region = region
+	return &regional
+}
+
+// export stores the manifests of images of a repository, failing if any
+// can't be stored so they aren't deleted without a record
+func (m *manifestExport) export(ctx context.Context, repoName string, images []types.ImageDetail) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := make([]types.ImageIdentifier, len(images))
+	for i, img := range images {
+		ids[i] = types.ImageIdentifier{ImageDigest: img.ImageDigest}
+	}
+	resp, err := m.images.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		RepositoryName:     aws.String(repoName),
+		ImageIds:           ids,
+		AcceptedMediaTypes: []string{mediaTypeDockerManifest, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get image manifests: %w", err)
+	}
+	for _, failure := range resp.Failures {
+		slog.Warn("Failed to get image manifest", "repository", repoName, "image", getImageIdString(failure.ImageId), "reason", aws.ToString(failure.FailureReason))
+	}
+
+	details := make(map[string]types.ImageDetail, len(images))
+	for _, img := range images {
+		details[aws.ToString(img.ImageDigest)] = img
+	}
+
+	exported := make(map[string]bool)
+	for _, image := range resp.Images {
+		digest := aws.ToString(image.ImageId.ImageDigest)
+		if exported[digest] {
+			continue
+		}
+		detail := details[digest]
+
+		record := ManifestRecord{
+			RegistryID:        aws.ToString(image.RegistryId),
+			Region:            m.region,
+			Repository:        repoName,
+			Digest:            digest,
+			Tags:              detail.ImageTags,
+			PushedAt:          aws.ToTime(detail.ImagePushedAt).UTC(),
+			SizeBytes:         aws.ToInt64(detail.ImageSizeInBytes),
+			DeletedAt:         m.started,
+			ManifestMediaType: aws.ToString(image.ImageManifestMediaType),
+			Manifest:          aws.ToString(image.ImageManifest),
+		}
+		if err := m.put(ctx, record); err != nil {
+			return err
+		}
+		exported[digest] = true
+	}
+
+	slog.Info("Exported image manifests", "repository", repoName, "images", len(exported), "url", "s3://"+m.location.Bucket+"/"+m.location.Prefix)
+	return nil
+}
+
+// key returns the object key of an image's record
+func (m *manifestExport) key(record ManifestRecord) string {
+	return fmt.Sprintf("%s%s/%s/%s/%s/%s.json", m.location.Prefix, m.started.Format("20060102T150405Z"), record.RegistryID, record.Region, record.Repository, record.Digest)
+}
+
+// put stores an image's record
+func (m *manifestExport) put(ctx context.Context, record ManifestRecord) error {
+	body, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	key := m.key(record)
+	_, err = m.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(m.location.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", m.location.Bucket, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// MockImageManifestClient returns a manifest for every requested digest
+// but the missing ones
+type MockImageManifestClient struct {
+	Missing           map[string]bool
+	LastBatchGetInput *ecr.BatchGetImageInput
+}
+
+// BatchGetImage returns the requested images
+func (m *MockImageManifestClient) BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	m.LastBatchGetInput = params
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range params.ImageIds {
+		digest := aws.ToString(id.ImageDigest)
+		if m.Missing[digest] {
+			out.Failures = append(out.Failures, types.ImageFailure{ImageId: &types.ImageIdentifier{ImageDigest: id.ImageDigest}, FailureReason: aws.String("not found")})
+			continue
+		}
+		out.Images = append(out.Images, types.Image{
+			RegistryId:             aws.String("123456789012"),
+			RepositoryName:         params.RepositoryName,
+			ImageId:                &types.ImageIdentifier{ImageDigest: id.ImageDigest},
+			ImageManifest:          aws.String(`{"schemaVersion":2,"layers":["` + digest + `"]}`),
+			ImageManifestMediaType: aws.String(mediaTypeOCIManifest),
+		})
+	}
+	return out, nil
+}
+
+// TestManifestExport tests storing the manifests of images before they are deleted
+func TestManifestExport(t *testing.T) {
+	started := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1", "stable"}, ImagePushedAt: aws.Time(started.AddDate(0, 0, -40)), ImageSizeInBytes: aws.Int64(1024)},
+		{ImageDigest: aws.String("sha256:gone")},
+	}
+
+	t.Run("Stores a record per image", func(t *testing.T) {
+		s3Client := &MockS3Client{}
+		export, err := newManifestExport(s3Client, "s3://audit/ecr", started)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		manifests := &MockImageManifestClient{Missing: map[string]bool{"sha256:gone": true}}
+
+		if err := export.forRegion(manifests, "us-east-1").export(context.Background(), "team/api", images); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(manifests.LastBatchGetInput.AcceptedMediaTypes) != 4 {
+			t.Error("Expected manifests requested as they were pushed")
+		}
+		if len(s3Client.Objects) != 1 {
+			t.Fatalf("Expected one record, got %v", s3Client.Objects)
+		}
+
+		body, ok := s3Client.Objects["audit/ecr/20240501T030000Z/123456789012/us-east-1/team/api/sha256:aaa.json"]
+		if !ok {
+			t.Fatalf("Expected the record under the run's prefix, got %v", s3Client.Objects)
+		}
+		var record ManifestRecord
+		if err := json.Unmarshal([]byte(body), &record); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+		if record.Repository != "team/api" || strings.Join(record.Tags, ",") != "v1,stable" || record.SizeBytes != 1024 ||
+			!record.DeletedAt.Equal(started) || record.ManifestMediaType != mediaTypeOCIManifest || !strings.Contains(record.Manifest, "sha256:aaa") {
+			t.Errorf("Expected the image's metadata and manifest, got %+v", record)
+		}
+	})
+
+	t.Run("Nothing is deleted without a record", func(t *testing.T) {
+		export, _ := newManifestExport(&MockS3Client{PutObjectError: errors.New("access denied")}, "s3://audit/", started)
+		client := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}
+		cfg := Config{manifests: export.forRegion(&MockImageManifestClient{}, "us-east-1")}
+
+		deleted, err := deleteRepositoryImages(context.Background(), client, "team/api", images, cfg)
+		if err == nil || !strings.Contains(err.Error(), "access denied") {
+			t.Errorf("Expected the upload error, got %v", err)
+		}
+		if len(deleted) != 0 || client.BatchDeleteImageCalls != 0 {
+			t.Errorf("Expected nothing deleted, got %d calls", client.BatchDeleteImageCalls)
+		}
+	})
+
+	if _, err := newManifestExport(&MockS3Client{}, "audit/ecr", started); err == nil {
+		t.Error("Expected an error for a location that isn't an S3 URI")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file contains the Prometheus metrics. The results of the last run are
+// rendered in the text exposition format and either served on /metrics or
+// pushed to a Pushgateway, so runs in Kubernetes can be monitored without
+// parsing logs.
+
+// metricsJob is the Pushgateway job name for the tool's metrics
+const metricsJob = "ecr-cleanup"
+
+// metricsStore holds the rendered metrics of the last run
+type metricsStore struct {
+	mu   sync.Mutex
+	body string
+}
+
+// lastRunMetrics is served by the /metrics endpoint
+var lastRunMetrics = &metricsStore{}
+
+// set replaces the stored metrics
+func (m *metricsStore) set(body string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.body = body
+}
+
+// ServeHTTP writes the stored metrics in the Prometheus text format
+func (m *metricsStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	body := m.body
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, body)
+}
+
+// metricsWriter builds a text exposition, writing each metric's HELP and
+// TYPE lines once
+type metricsWriter struct {
+	b    strings.Builder
+	seen map[string]bool
+}
+
+// gauge writes one sample of a gauge
+func (w *metricsWriter) gauge(name, help string, labels map[string]string, value float64) {
+	if w.seen == nil {
+		w.seen = make(map[string]bool)
+	}
+	if !w.seen[name] {
+		w.seen[name] = true
+		fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escapeLabelValue(labels[key])))
+		}
+		fmt.Fprintf(&w.b, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(&w.b, " %g\n", value)
+}
+
+// labelValueEscaper escapes label values as the text format requires
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value for the text format
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// boolValue converts a flag into a gauge value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// renderMetrics renders the results of a run in the Prometheus text format
+func renderMetrics(summary CleanupSummary, cfg Config, duration time.Duration, runErr error, finished time.Time) string {
+	w := &metricsWriter{}
+
+	w.gauge("ecr_cleanup_last_run_timestamp_seconds", "Unix time the last run finished.", nil, float64(finished.Unix()))
+	w.gauge("ecr_cleanup_last_run_success", "Whether the last run succeeded.", nil, boolValue(runErr == nil))
+	w.gauge("ecr_cleanup_last_run_dry_run", "Whether the last run was a dry run.", nil, boolValue(cfg.DryRun))
+	w.gauge("ecr_cleanup_last_run_duration_seconds", "Duration of the last run.", nil, duration.Seconds())
+	w.gauge("ecr_cleanup_repositories_processed", "Repositories processed in the last run.", nil, float64(summary.RepositoriesProcessed))
+	w.gauge("ecr_cleanup_repositories_failed", "Repositories that failed in the last run.", nil, float64(len(summary.failedRepositories())))
+	w.gauge("ecr_cleanup_images_scanned", "Images scanned in the last run.", nil, float64(summary.ImagesScanned))
+	w.gauge("ecr_cleanup_images_deleted", "Images deleted in the last run.", nil, float64(summary.ImagesDeleted))
+	w.gauge("ecr_cleanup_space_freed_bytes", "Bytes freed in the last run.", nil, float64(summary.SpaceFreed))
+	w.gauge("ecr_cleanup_space_freed_upper_bound_bytes", "Upper bound of the bytes freed in the last run, counting each digest once per registry.", nil, float64(summary.spaceFreedUpperBound()))
+
+	for _, repo := range summary.Repositories {
+		labels := map[string]string{"repository": repo.Name}
+		if repo.AccountID != "" {
+			labels["account"] = repo.AccountID
+		}
+		if repo.Region != "" {
+			labels["region"] = repo.Region
+		}
+
+		w.gauge("ecr_cleanup_repository_images_scanned", "Images scanned in the repository in the last run.", labels, float64(repo.ImagesScanned))
+		w.gauge("ecr_cleanup_repository_images_deleted", "Images deleted from the repository in the last run.", labels, float64(repo.ImagesDeleted))
+		w.gauge("ecr_cleanup_repository_space_freed_bytes", "Bytes freed in the repository in the last run.", labels, float64(repo.SpaceFreed))
+		w.gauge("ecr_cleanup_repository_duration_seconds", "Time spent scanning and deleting in the repository in the last run.", labels, repo.Duration.Seconds())
+		w.gauge("ecr_cleanup_repository_failed", "Whether the repository failed in the last run.", labels, boolValue(repo.Error != ""))
+	}
+
+	return w.b.String()
+}
+
+// startMetricsServer serves /metrics on addr in the background
+func startMetricsServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", lastRunMetrics)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Serving metrics", "url", "http://"+server.Addr+"/metrics")
+	return server, nil
+}
+
+// exportMetrics records the run for the /metrics endpoint and pushes it to
+// the Pushgateway, if configured
+func exportMetrics(summary CleanupSummary, cfg Config, duration time.Duration, runErr error) error {
+	if cfg.MetricsAddr == "" && cfg.PushgatewayURL == "" {
+		return nil
+	}
+
+	body := renderMetrics(summary, cfg, duration, runErr, time.Now())
+	lastRunMetrics.set(body)
+
+	if cfg.PushgatewayURL != "" {
+		client := &http.Client{Timeout: webhookTimeout}
+		if err := pushMetrics(context.Background(), client, cfg.PushgatewayURL, body); err != nil {
+			return fmt.Errorf("failed to push metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pushMetrics replaces the tool's metrics group on the Pushgateway
+func pushMetrics(ctx context.Context, client *http.Client, gatewayURL, body string) error {
+	url := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + metricsJob
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway returned %s", resp.Status)
+	}
+
+	slog.Info("Pushed metrics", "url", url)
+	return nil
+}
+
+// waitForShutdown blocks until the process receives SIGINT or SIGTERM
+func waitForShutdown() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Run finished; serving metrics until interrupted")
+	<-ctx.Done()
+}
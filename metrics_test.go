@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenderMetrics tests the Prometheus text exposition
+func TestRenderMetrics(t *testing.T) {
+	summary := testReportSummary()
+	summary.Repositories[1].Duration = 1500 * time.Millisecond
+	summary.Repositories[1].Region = "us-east-1"
+
+	body := renderMetrics(summary, Config{DryRun: true}, 2*time.Second, nil, time.Unix(1700000000, 0))
+	for _, want := range []string{
+		"# TYPE ecr_cleanup_images_deleted gauge\necr_cleanup_images_deleted 12\n",
+		"ecr_cleanup_last_run_timestamp_seconds 1.7e+09\n",
+		"ecr_cleanup_last_run_success 1\n",
+		"ecr_cleanup_last_run_dry_run 1\n",
+		"ecr_cleanup_last_run_duration_seconds 2\n",
+		"ecr_cleanup_repositories_failed 1\n",
+		`ecr_cleanup_repository_images_deleted{region="us-east-1",repository="big"} 10`,
+		`ecr_cleanup_repository_duration_seconds{region="us-east-1",repository="big"} 1.5`,
+		`ecr_cleanup_repository_failed{repository="<broken>"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Count(body, "# TYPE ecr_cleanup_repository_images_scanned gauge") != 1 {
+		t.Error("Expected one TYPE line per metric")
+	}
+
+	body = renderMetrics(CleanupSummary{}, Config{}, time.Second, errors.New("boom"), time.Now())
+	if !strings.Contains(body, "ecr_cleanup_last_run_success 0\n") {
+		t.Errorf("Expected a failed run, got:\n%s", body)
+	}
+}
+
+// TestEscapeLabelValue tests escaping label values
+func TestEscapeLabelValue(t *testing.T) {
+	if got := escapeLabelValue("a\\b\"c\nd"); got != `a\\b\"c\nd` {
+		t.Errorf("Unexpected escaped value: %s", got)
+	}
+}
+
+// TestMetricsEndpoint tests serving the last run's metrics
+func TestMetricsEndpoint(t *testing.T) {
+	server, err := startMetricsServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer server.Close()
+
+	lastRunMetrics.set("ecr_cleanup_images_deleted 3\n")
+	defer lastRunMetrics.set("")
+
+	resp, err := http.Get("http://" + server.Addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ecr_cleanup_images_deleted 3\n" {
+		t.Errorf("Unexpected metrics body: %s", body)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+}
+
+// TestPushMetrics tests pushing to a Pushgateway
+func TestPushMetrics(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotPath, gotBody = r.Method, r.URL.Path, string(body)
+	}))
+	defer gateway.Close()
+
+	if err := pushMetrics(context.Background(), gateway.Client(), gateway.URL+"/", "metric 1\n"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/ecr-cleanup" || gotBody != "metric 1\n" {
+		t.Errorf("Unexpected push: %s %s %q", gotMethod, gotPath, gotBody)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+
+	if err := pushMetrics(context.Background(), failing.Client(), failing.URL, "metric 1\n"); err == nil {
+		t.Error("Expected an error for a rejected push")
+	}
+}
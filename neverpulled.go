@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -delete-never-pulled-after-days, which cleans up the
+// images CI pushes that nobody ever deploys. ECR records when an image was
+// last pulled; an image without a recorded pull that was pushed more than
+// the given number of days ago is deleted even when the age limit or
+// -max-images would keep it. The keep-list, protected tags, in-use
+// protection, -keep-newest and -min-keep still apply. ECR only records pulls
+// since mid-2021, so an older image that hasn't been pulled since counts as
+// never pulled too.
+
+// neverPulled reports whether an image was pushed before the cutoff and
+// hasn't been pulled since
+func neverPulled(img types.ImageDetail, cutoff time.Time) bool {
+	return img.LastRecordedPullTime == nil && img.ImagePushedAt != nil && img.ImagePushedAt.Before(cutoff)
+}
+
+// withNeverPulled adds the never-pulled images the retention policy didn't
+// select
+func withNeverPulled(repoName string, selected, unpulled []types.ImageDetail) []types.ImageDetail {
+	if len(unpulled) == 0 {
+		return selected
+	}
+	digests := make(map[string]bool, len(selected))
+	for _, img := range selected {
+		digests[aws.ToString(img.ImageDigest)] = true
+	}
+	for _, img := range unpulled {
+		digest := aws.ToString(img.ImageDigest)
+		if digests[digest] {
+			continue
+		}
+		slog.Info("Selecting never pulled image", "repository", repoName, "digest", digest, "pushed_at", img.ImagePushedAt)
+		selected = append(selected, img)
+	}
+	return selected
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestDeleteNeverPulled tests deleting never pulled images that the age
+// limit keeps
+func TestDeleteNeverPulled(t *testing.T) {
+	// Images are 30 to 34 days old, and the second oldest was pulled
+	client := newGuardrailClient(1, 5)
+	client.DescribeImagesOutput.ImageDetails[3].LastRecordedPullTime = aws.Time(time.Now())
+
+	cfg := Config{Days: 90, NeverPulledDays: 31}
+	_, toDelete, err := selectRepositoryImages(context.Background(), client, "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var digests []string
+	for _, img := range toDelete {
+		digests = append(digests, aws.ToString(img.ImageDigest))
+	}
+	slices.Sort(digests)
+	if want := []string{"sha256:001", "sha256:002", "sha256:004"}; !slices.Equal(digests, want) {
+		t.Errorf("Expected %v to be deleted, got %v", want, digests)
+	}
+
+	// Guardrails still apply
+	cfg.MinKeep = 4
+	if _, toDelete, _ := selectRepositoryImages(context.Background(), client, "repo0", cfg); len(toDelete) != 1 {
+		t.Errorf("Expected -min-keep to keep all but one image, got %d deleted", len(toDelete))
+	}
+}
+
+// TestWithNeverPulled tests not selecting an image twice
+func TestWithNeverPulled(t *testing.T) {
+	a := types.ImageDetail{ImageDigest: aws.String("sha256:a")}
+	b := types.ImageDetail{ImageDigest: aws.String("sha256:b")}
+	if selected := withNeverPulled("repo", []types.ImageDetail{a}, []types.ImageDetail{a, b}); len(selected) != 2 {
+		t.Errorf("Expected both images once, got %d", len(selected))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// This file contains the end-of-run notifications, which let scheduled
+// cleanups fan out to email, pagers or automation without anyone reading the
+// logs. With -notify-min-failures or -notify-min-deleted-gb, only the runs
+// that cross a threshold are announced, so a routine nightly run stays quiet.
+
+// notificationFailureLimit caps how many failed repositories are listed
+const notificationFailureLimit = 10
+
+// failedRepositories returns the repositories that could not be processed
+func (s CleanupSummary) failedRepositories() []RepositorySummary {
+	var failed []RepositorySummary
+	for _, repo := range s.Repositories {
+		if repo.Error != "" {
+			failed = append(failed, repo)
+		}
+	}
+	return failed
+}
+
+// notificationSubject returns a one-line summary of the run
+func notificationSubject(summary CleanupSummary, cfg Config, runErr error) string {
+	switch {
+	case runErr != nil:
+		return "ECR cleanup failed"
+	case cfg.DryRun:
+		return fmt.Sprintf("ECR cleanup dry run: %d images would be deleted", summary.ImagesDeleted)
+	default:
+		return fmt.Sprintf("ECR cleanup: %d images deleted", summary.ImagesDeleted)
+	}
+}
+
+// notificationMessage returns the plain-text summary of the run
+func notificationMessage(summary CleanupSummary, cfg Config, runErr error) string {
+	var b strings.Builder
+
+	if runErr != nil {
+		fmt.Fprintf(&b, "ECR cleanup failed: %v\n\n", runErr)
+	} else if cfg.DryRun {
+		b.WriteString("ECR cleanup dry run finished. No images were actually deleted.\n\n")
+	} else {
+		b.WriteString("ECR cleanup finished.\n\n")
+	}
+
+	failed := summary.failedRepositories()
+	fmt.Fprintf(&b, "Repositories processed: %d\n", summary.RepositoriesProcessed)
+	fmt.Fprintf(&b, "Images scanned: %d\n", summary.ImagesScanned)
+	fmt.Fprintf(&b, "Images deleted: %d\n", summary.ImagesDeleted)
+	fmt.Fprintf(&b, "Space freed: %s (at most %s with shared digests counted once)\n", formatMB(summary.SpaceFreed), formatMB(summary.spaceFreedUpperBound()))
+	fmt.Fprintf(&b, "Estimated monthly savings: %s\n", formatUSD(summary.EstimatedMonthlySavings))
+	fmt.Fprintf(&b, "Failures: %d\n", len(failed))
+
+	for i, repo := range failed {
+		if i == notificationFailureLimit {
+			fmt.Fprintf(&b, "- ... and %d more\n", len(failed)-notificationFailureLimit)
+			break
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", repo.qualifiedName(), repo.Error)
+	}
+
+	return b.String()
+}
+
+// failureCount returns the number of repositories, regions and accounts
+// that could not be cleaned up
+func (s CleanupSummary) failureCount() int {
+	return len(s.failedRepositories()) + len(s.Failures)
+}
+
+// notificationDue reports whether the run is worth announcing: every run is
+// without thresholds, otherwise a run that failed or crossed one
+func notificationDue(summary CleanupSummary, cfg Config, runErr error) bool {
+	if cfg.NotifyMinFailures <= 0 && cfg.NotifyMinDeletedGB <= 0 {
+		return true
+	}
+	if runErr != nil {
+		return true
+	}
+	if cfg.NotifyMinFailures > 0 && summary.failureCount() >= cfg.NotifyMinFailures {
+		return true
+	}
+	return cfg.NotifyMinDeletedGB > 0 && summary.SpaceFreed >= gbToBytes(cfg.NotifyMinDeletedGB)
+}
+
+// sendNotifications sends the run summary to every configured notifier. A
+// notifier that fails does not stop the others. The post-run hook runs
+// after every run, whatever the thresholds.
+func sendNotifications(summary CleanupSummary, cfg Config, runErr error) error {
+	var errs []error
+
+	due := notificationDue(summary, cfg, runErr)
+	if !due && (cfg.SNSTopicArn != "" || cfg.WebhookURL != "") {
+		slog.Info("Run within the notification thresholds; not notifying",
+			"failures", summary.failureCount(),
+			"space_freed_mb", roundMB(summary.SpaceFreed))
+	}
+
+	if cfg.SNSTopicArn != "" && due {
+		if err := publishSNSNotification(summary, cfg, runErr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish to SNS: %w", err))
+		}
+	}
+
+	if cfg.WebhookURL != "" && due {
+		if err := postWebhookNotification(summary, cfg, runErr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to post to webhook: %w", err))
+		}
+	}
+
+	if cfg.PostRunHook != "" {
+		if err := runPostRunHook(summary, cfg, runErr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to run post-run hook: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// This file contains the SNS notifier, which publishes the run summary to a
+// topic so it can fan out to email, SMS or pager subscriptions.
+
+// snsSubjectLimit is the maximum length SNS accepts for a message subject
+const snsSubjectLimit = 100
+
+// SNSClient defines the SNS operations needed to publish notifications
+type SNSClient interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// publishSNSNotification publishes the run summary to the -sns-topic-arn
+// topic using the run's base credentials
+func publishSNSNotification(summary CleanupSummary, cfg Config, runErr error) error {
+	ctx := context.Background()
+	awsConfig, err := loadRunAWSConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Publish in the topic's region, which may differ from the cleanup region
+	if topic, err := arn.Parse(cfg.SNSTopicArn); err == nil {
+		awsConfig.Region = topic.Region
+	}
+
+	return publishSNSNotificationWithClient(ctx, sns.NewFromConfig(awsConfig), cfg.SNSTopicArn,
+		notificationSubject(summary, cfg, runErr), notificationMessage(summary, cfg, runErr))
+}
+
+// publishSNSNotificationWithClient publishes the subject and message to the topic
+func publishSNSNotificationWithClient(ctx context.Context, client SNSClient, topicArn, subject, message string) error {
+	if len(subject) > snsSubjectLimit {
+		subject = subject[:snsSubjectLimit]
+	}
+
+	resp, err := client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Published run summary", "topic", topicArn, "message_id", aws.ToString(resp.MessageId))
+	return nil
+}
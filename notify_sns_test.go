@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// MockSNSClient is a mock implementation of the SNSClient interface
+type MockSNSClient struct {
+	PublishError     error
+	LastPublishInput *sns.PublishInput
+}
+
+// Publish records the published message
+func (m *MockSNSClient) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	m.LastPublishInput = params
+	if m.PublishError != nil {
+		return nil, m.PublishError
+	}
+	return &sns.PublishOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+// TestPublishSNSNotificationWithClient tests publishing the run summary
+func TestPublishSNSNotificationWithClient(t *testing.T) {
+	topicArn := "arn:aws:sns:us-east-1:123456789012:ecr-cleanup"
+
+	t.Run("Publishes subject and message", func(t *testing.T) {
+		client := &MockSNSClient{}
+		err := publishSNSNotificationWithClient(context.Background(), client, topicArn, "subject", "message")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		input := client.LastPublishInput
+		if aws.ToString(input.TopicArn) != topicArn || aws.ToString(input.Subject) != "subject" || aws.ToString(input.Message) != "message" {
+			t.Errorf("Unexpected publish input: %+v", input)
+		}
+	})
+
+	t.Run("Truncates long subjects", func(t *testing.T) {
+		client := &MockSNSClient{}
+		err := publishSNSNotificationWithClient(context.Background(), client, topicArn, strings.Repeat("x", 150), "message")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(aws.ToString(client.LastPublishInput.Subject)) != snsSubjectLimit {
+			t.Errorf("Expected subject truncated to %d characters", snsSubjectLimit)
+		}
+	})
+
+	t.Run("Publish error", func(t *testing.T) {
+		client := &MockSNSClient{PublishError: errors.New("not authorized")}
+		err := publishSNSNotificationWithClient(context.Background(), client, topicArn, "subject", "message")
+		if err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
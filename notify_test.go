@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNotificationSubject tests the one-line run summary
+func TestNotificationSubject(t *testing.T) {
+	summary := CleanupSummary{ImagesDeleted: 7}
+
+	if got := notificationSubject(summary, Config{}, nil); got != "ECR cleanup: 7 images deleted" {
+		t.Errorf("Unexpected subject: %s", got)
+	}
+	if got := notificationSubject(summary, Config{DryRun: true}, nil); got != "ECR cleanup dry run: 7 images would be deleted" {
+		t.Errorf("Unexpected dry run subject: %s", got)
+	}
+	if got := notificationSubject(summary, Config{}, errors.New("boom")); got != "ECR cleanup failed" {
+		t.Errorf("Unexpected failure subject: %s", got)
+	}
+}
+
+// TestNotificationMessage tests the plain-text run summary
+func TestNotificationMessage(t *testing.T) {
+	t.Run("Lists failures", func(t *testing.T) {
+		message := notificationMessage(testReportSummary(), Config{}, nil)
+		for _, want := range []string{
+			"ECR cleanup finished.",
+			"Repositories processed: 3",
+			"Images deleted: 12",
+			"Space freed: 3.00 MB",
+			"Failures: 1",
+			"- <broken>: access denied | retry",
+		} {
+			if !strings.Contains(message, want) {
+				t.Errorf("Expected message to contain %q, got:\n%s", want, message)
+			}
+		}
+	})
+
+	t.Run("Caps the failure list", func(t *testing.T) {
+		summary := CleanupSummary{}
+		for i := 0; i < 15; i++ {
+			summary.Repositories = append(summary.Repositories, RepositorySummary{
+				Name:  fmt.Sprintf("repo%d", i),
+				Error: "denied",
+			})
+		}
+
+		message := notificationMessage(summary, Config{}, nil)
+		if !strings.Contains(message, "Failures: 15") || !strings.Contains(message, "- ... and 5 more") {
+			t.Errorf("Expected a capped failure list, got:\n%s", message)
+		}
+		if strings.Contains(message, "repo10") {
+			t.Errorf("Expected repo10 to be left out, got:\n%s", message)
+		}
+	})
+
+	t.Run("Run error", func(t *testing.T) {
+		message := notificationMessage(CleanupSummary{}, Config{}, errors.New("all regions failed"))
+		if !strings.HasPrefix(message, "ECR cleanup failed: all regions failed") {
+			t.Errorf("Unexpected message:\n%s", message)
+		}
+	})
+}
+
+// TestSendNotificationsNoneConfigured tests that nothing is sent by default
+func TestSendNotificationsNoneConfigured(t *testing.T) {
+	if err := sendNotifications(CleanupSummary{}, Config{}, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+// TestNotificationDue tests announcing only the runs that cross a threshold
+func TestNotificationDue(t *testing.T) {
+	summary := testReportSummary() // one failed repository, 3 MB freed
+	tests := []struct {
+		name   string
+		cfg    Config
+		runErr error
+		want   bool
+	}{
+		{"No thresholds", Config{}, nil, true},
+		{"Enough failures", Config{NotifyMinFailures: 1}, nil, true},
+		{"Too few failures", Config{NotifyMinFailures: 2}, nil, false},
+		{"Too little deleted", Config{NotifyMinDeletedGB: 1}, nil, false},
+		{"Enough deleted", Config{NotifyMinDeletedGB: 0.002}, nil, true},
+		{"Either threshold", Config{NotifyMinFailures: 2, NotifyMinDeletedGB: 0.002}, nil, true},
+		{"Run error", Config{NotifyMinFailures: 2}, errors.New("boom"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notificationDue(summary, tt.cfg, tt.runErr); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Regions and accounts that failed count too
+	if !notificationDue(CleanupSummary{Failures: []string{"region eu-west-1: denied"}}, Config{NotifyMinFailures: 1}, nil) {
+		t.Error("Expected a failed region to count as a failure")
+	}
+}
+
+// TestSendNotificationsBelowThresholds tests that a quiet run isn't posted
+// to the webhook
+func TestSendNotificationsBelowThresholds(t *testing.T) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer server.Close()
+
+	cfg := Config{WebhookURL: server.URL, NotifyMinFailures: 5}
+	if err := sendNotifications(testReportSummary(), cfg, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if posts != 0 {
+		t.Errorf("Expected no webhook call, got %d", posts)
+	}
+
+	cfg.NotifyMinFailures = 1
+	if err := sendNotifications(testReportSummary(), cfg, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if posts != 1 {
+		t.Errorf("Expected one webhook call, got %d", posts)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// This file contains the webhook notifier, which POSTs the JSON run summary
+// to an arbitrary endpoint so the tool can plug into internal automation.
+
+const (
+	// defaultWebhookRetries is how often a failed webhook call is retried
+	defaultWebhookRetries = 3
+
+	// webhookTimeout bounds a single webhook request
+	webhookTimeout = 10 * time.Second
+)
+
+// headerList collects repeated -webhook-header flags
+type headerList []string
+
+// String returns the headers as a comma-separated list
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set adds a header in "Name: value" form
+func (h *headerList) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q: expected \"Name: value\"", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// postWebhookNotification POSTs the run summary to -webhook-url
+func postWebhookNotification(summary CleanupSummary, cfg Config, runErr error) error {
+	body, err := json.Marshal(newRunResult(summary, cfg, runErr))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	return postWebhookWithClient(context.Background(), client, cfg.WebhookURL, cfg.WebhookHeaders, cfg.WebhookRetries, body)
+}
+
+// postWebhookWithClient POSTs the body, retrying network errors, 429s and
+// server errors with backoff. Other client errors are not retried.
+func postWebhookWithClient(ctx context.Context, client *http.Client, url string, headers []string, retries int, body []byte) error {
+	if retries < 0 {
+		retries = 0
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := postWebhookOnce(ctx, client, url, headers, body)
+		if err == nil {
+			slog.Info("Posted run summary to webhook")
+			return nil
+		}
+		if !retryable || attempt > retries {
+			return err
+		}
+
+		delay := backoffDelay(attempt)
+		slog.Warn("Webhook call failed, retrying", "error", err, "delay", delay.Round(time.Millisecond), "attempt", attempt+1, "max_attempts", retries+1)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// postWebhookOnce makes a single webhook request and reports whether a
+// failure is worth retrying
+func postWebhookOnce(ctx context.Context, client *http.Client, url string, headers []string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ecr-cleanup")
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestHeaderList tests parsing -webhook-header values
+func TestHeaderList(t *testing.T) {
+	var headers headerList
+	if err := headers.Set("Authorization: Bearer token"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := headers.Set("missing-colon"); err == nil {
+		t.Error("Expected an error for a header without a colon")
+	}
+	if err := headers.Set(": value"); err == nil {
+		t.Error("Expected an error for a header without a name")
+	}
+	if len(headers) != 1 {
+		t.Errorf("Expected 1 header, got %d", len(headers))
+	}
+}
+
+// TestNewRunResult tests the JSON result of a run
+func TestNewRunResult(t *testing.T) {
+	payload := newRunResult(testReportSummary(), Config{DryRun: true}, nil)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if decoded["status"] != "succeeded" || decoded["failures"] != float64(1) || decoded["images_deleted"] != float64(12) {
+		t.Errorf("Unexpected payload: %s", body)
+	}
+	if _, ok := decoded["error"]; ok {
+		t.Errorf("Expected no error field, got %s", body)
+	}
+
+	payload = newRunResult(CleanupSummary{}, Config{}, errors.New("boom"))
+	if payload.Status != "failed" || payload.Error != "boom" {
+		t.Errorf("Unexpected failure payload: %+v", payload)
+	}
+}
+
+// TestPostWebhookWithClient tests posting with headers and retries
+func TestPostWebhookWithClient(t *testing.T) {
+	t.Run("Sends body and headers", func(t *testing.T) {
+		var gotBody, gotAuth, gotType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			gotBody = string(body)
+			gotAuth = r.Header.Get("Authorization")
+			gotType = r.Header.Get("Content-Type")
+		}))
+		defer server.Close()
+
+		err := postWebhookWithClient(context.Background(), server.Client(), server.URL,
+			[]string{"Authorization: Bearer secret"}, 0, []byte(`{"status":"succeeded"}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if gotBody != `{"status":"succeeded"}` || gotAuth != "Bearer secret" || gotType != "application/json" {
+			t.Errorf("Unexpected request: body=%s auth=%s type=%s", gotBody, gotAuth, gotType)
+		}
+	})
+
+	t.Run("Retries server errors", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		err := postWebhookWithClient(context.Background(), server.Client(), server.URL, nil, 3, []byte(`{}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls)
+		}
+	})
+
+	t.Run("Does not retry client errors", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		err := postWebhookWithClient(context.Background(), server.Client(), server.URL, nil, 3, []byte(`{}`))
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("Gives up after retries", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := postWebhookWithClient(context.Background(), server.Client(), server.URL, nil, 1, []byte(`{}`))
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if calls != 2 {
+			t.Errorf("Expected 2 calls, got %d", calls)
+		}
+	})
+}
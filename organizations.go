@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+// This file contains the AWS Organizations auto-discovery. Every active
+// account in the organization is cleaned up by assuming a well-known role in
+// it, unless the account carries the opt-out tag.
+
+// OrganizationsClient defines the AWS Organizations operations needed to discover accounts
+type OrganizationsClient interface {
+	ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error)
+	ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error)
+}
+
+// parseTagFilter splits a key=value tag filter. A filter without a value
+// matches any value of the key.
+func parseTagFilter(filter string) (key, value string) {
+	key, value, _ = strings.Cut(filter, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value)
+}
+
+// discoverOrgAccounts lists the accounts to clean up in org mode
+func discoverOrgAccounts(ctx context.Context, awsConfig aws.Config, cfg Config) ([]accountTarget, error) {
+	identity, err := getCallerIdentity(ctx, awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	return listOrgAccounts(ctx, organizations.NewFromConfig(awsConfig), cfg, aws.ToString(identity.Account))
+}
+
+// listOrgAccounts returns a target for every active, non-opted-out account.
+// The caller's own account is cleaned up with the current credentials.
+func listOrgAccounts(ctx context.Context, client OrganizationsClient, cfg Config, callerAccountID string) ([]accountTarget, error) {
+	var targets []accountTarget
+	var nextToken *string
+
+	for {
+		resp, err := client.ListAccounts(ctx, &organizations.ListAccountsInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, account := range resp.Accounts {
+			accountID := aws.ToString(account.Id)
+			if account.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+
+			skip, err := accountOptedOut(ctx, client, accountID, cfg.OrgSkipTag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tags for account %s: %w", accountID, err)
+			}
+			if skip {
+				slog.Info("Skipping opted-out account", "account", accountID, "tag", cfg.OrgSkipTag)
+				continue
+			}
+
+			target := accountTarget{AccountID: accountID}
+			if accountID != callerAccountID {
+				target.RoleArn = orgRoleArn(aws.ToString(account.Arn), accountID, cfg.OrgRoleName)
+			}
+			targets = append(targets, target)
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return targets, nil
+}
+
+// accountOptedOut reports whether the account carries the opt-out tag
+func accountOptedOut(ctx context.Context, client OrganizationsClient, accountID, skipTag string) (bool, error) {
+	key, value := parseTagFilter(skipTag)
+	if key == "" {
+		return false, nil
+	}
+
+	var nextToken *string
+	for {
+		resp, err := client.ListTagsForResource(ctx, &organizations.ListTagsForResourceInput{
+			ResourceId: aws.String(accountID),
+			NextToken:  nextToken,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		for _, tag := range resp.Tags {
+			if aws.ToString(tag.Key) == key && (value == "" || strings.EqualFold(aws.ToString(tag.Value), value)) {
+				return true, nil
+			}
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			return false, nil
+		}
+	}
+}
+
+// orgRoleArn builds the ARN of the role to assume in an organization account,
+// using the partition from the account's own ARN
+func orgRoleArn(accountArn, accountID, roleName string) string {
+	partition := "aws"
+	if parsed, err := arn.Parse(accountArn); err == nil {
+		partition = parsed.Partition
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountID, roleName)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+// MockOrganizationsClient implements the OrganizationsClient interface for testing
+type MockOrganizationsClient struct {
+	Accounts []orgtypes.Account
+	Tags     map[string][]orgtypes.Tag
+}
+
+// ListAccounts mock implementation
+func (m *MockOrganizationsClient) ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	return &organizations.ListAccountsOutput{Accounts: m.Accounts}, nil
+}
+
+// ListTagsForResource mock implementation
+func (m *MockOrganizationsClient) ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	return &organizations.ListTagsForResourceOutput{Tags: m.Tags[*params.ResourceId]}, nil
+}
+
+// orgAccount creates a test organization account
+func orgAccount(id string, status orgtypes.AccountStatus) orgtypes.Account {
+	return orgtypes.Account{
+		Id:     aws.String(id),
+		Arn:    aws.String("arn:aws:organizations::111111111111:account/o-abc/" + id),
+		Status: status,
+	}
+}
+
+// TestListOrgAccounts tests account discovery in org mode
+func TestListOrgAccounts(t *testing.T) {
+	mockClient := &MockOrganizationsClient{
+		Accounts: []orgtypes.Account{
+			orgAccount("111111111111", orgtypes.AccountStatusActive),
+			orgAccount("222222222222", orgtypes.AccountStatusActive),
+			orgAccount("333333333333", orgtypes.AccountStatusSuspended),
+			orgAccount("444444444444", orgtypes.AccountStatusActive),
+		},
+		Tags: map[string][]orgtypes.Tag{
+			"444444444444": {{Key: aws.String("ecr-cleanup/skip"), Value: aws.String("true")}},
+		},
+	}
+	cfg := Config{
+		OrgRoleName: "OrganizationAccountAccessRole",
+		OrgSkipTag:  "ecr-cleanup/skip=true",
+	}
+
+	targets, err := listOrgAccounts(context.Background(), mockClient, cfg, "111111111111")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []accountTarget{
+		{AccountID: "111111111111"},
+		{AccountID: "222222222222", RoleArn: "arn:aws:iam::222222222222:role/OrganizationAccountAccessRole"},
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, targets)
+	}
+}
+
+// TestParseTagFilter tests splitting key=value tag filters
+func TestParseTagFilter(t *testing.T) {
+	testCases := []struct {
+		filter string
+		key    string
+		value  string
+	}{
+		{"ecr-cleanup/skip=true", "ecr-cleanup/skip", "true"},
+		{"team", "team", ""},
+		{" env = prod ", "env", "prod"},
+		{"", "", ""},
+	}
+
+	for _, tc := range testCases {
+		key, value := parseTagFilter(tc.filter)
+		if key != tc.key || value != tc.value {
+			t.Errorf("parseTagFilter(%q) = %q, %q; expected %q, %q", tc.filter, key, value, tc.key, tc.value)
+		}
+	}
+}
+
+// TestOrgRoleArn tests building role ARNs across partitions
+func TestOrgRoleArn(t *testing.T) {
+	roleArn := orgRoleArn("arn:aws-us-gov:organizations::111111111111:account/o-abc/222222222222", "222222222222", "Admin")
+	if roleArn != "arn:aws-us-gov:iam::222222222222:role/Admin" {
+		t.Errorf("Unexpected role ARN %s", roleArn)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/mchineboy/ecr-cleanup/schema"
+)
+
+// This file contains deletion plans. A plan records the exact images a dry
+// run selected, so they can be reviewed and then deleted as approved:
+// applying a plan deletes the planned images and nothing else, whatever the
+// retention policy would select by then. The plan command writes a plan to
+// a file and the apply command deletes what it contains.
+
+// Plan is the set of images a dry run selected for deletion. Its JSON form
+// is schema.Plan, the stable format other tools consume.
+type Plan schema.Plan
+
+// PlanRepository holds the planned deletions of one repository. AccountID
+// and Region are set as in the run summary: the account only for
+// multi-account runs.
+type PlanRepository = schema.PlanRepository
+
+// PlanImage is an image planned for deletion
+type PlanImage = schema.PlanImage
+
+// newPlan builds a plan from the images a dry run selected
+func newPlan(summary CleanupSummary, now time.Time) *Plan {
+	plan := &Plan{
+		SchemaVersion: schema.PlanVersion,
+		ID:            randomHexID(8),
+		CreatedAt:     now.UTC(),
+		Repositories:  []PlanRepository{},
+	}
+
+	for _, repo := range summary.Repositories {
+		if len(repo.Images) == 0 && !repo.Deleted && len(repo.Quarantined) == 0 && len(repo.Released) == 0 {
+			continue
+		}
+
+		planRepo := PlanRepository{
+			AccountID:        repo.AccountID,
+			Region:           repo.Region,
+			Name:             repo.Name,
+			DeleteRepository: repo.Deleted,
+			Quarantine:       planImages(repo.Quarantined),
+			Release:          planImages(repo.Released),
+		}
+		if repo.Deleted {
+			plan.EmptyRepositories++
+		}
+		for _, img := range repo.Images {
+			planRepo.Images = append(planRepo.Images, PlanImage{
+				Digest:    img.Digest,
+				Tags:      img.Tags,
+				PushedAt:  img.PushedAt.UTC(),
+				SizeBytes: img.SizeBytes,
+			})
+			plan.Images++
+			plan.SizeBytes += img.SizeBytes
+		}
+		plan.Repositories = append(plan.Repositories, planRepo)
+	}
+
+	return plan
+}
+
+// planImages converts the images of a summary into planned images
+func planImages(images []ImageSummary) []PlanImage {
+	var planned []PlanImage
+	for _, img := range images {
+		planned = append(planned, PlanImage{Digest: img.Digest, Tags: img.Tags, PushedAt: img.PushedAt.UTC(), SizeBytes: img.SizeBytes})
+	}
+	return planned
+}
+
+// quarantineImages counts the images the plan quarantines or releases
+func (p *Plan) quarantineImages() int {
+	n := 0
+	for _, repo := range p.Repositories {
+		n += len(repo.Quarantine) + len(repo.Release)
+	}
+	return n
+}
+
+// quarantineChanges returns the repository's images that the plan
+// quarantines and releases. Images that no longer exist are skipped.
+func (p *Plan) quarantineChanges(accountID, region, repoName string, images []types.ImageDetail) quarantineChanges {
+	var changes quarantineChanges
+	repo := p.repository(accountID, region, repoName)
+	if repo == nil {
+		return changes
+	}
+
+	byDigest := make(map[string]types.ImageDetail, len(images))
+	for _, img := range images {
+		byDigest[aws.ToString(img.ImageDigest)] = img
+	}
+	for _, planned := range repo.Quarantine {
+		if img, ok := byDigest[planned.Digest]; ok {
+			changes.add = append(changes.add, img)
+		}
+	}
+	for _, planned := range repo.Release {
+		if img, ok := byDigest[planned.Digest]; ok {
+			changes.release = append(changes.release, img)
+		}
+	}
+	return changes
+}
+
+// repository returns the planned deletions of a repository, or nil when the
+// plan has none
+func (p *Plan) repository(accountID, region, name string) *PlanRepository {
+	for i, repo := range p.Repositories {
+		if repo.AccountID == accountID && repo.Region == region && repo.Name == name {
+			return &p.Repositories[i]
+		}
+	}
+	return nil
+}
+
+// selectImages returns the repository's images that the plan deletes.
+// Planned images that no longer exist are skipped. It refuses the whole
+// repository if any planned image was re-pushed or re-tagged since the plan
+// was created, since deleting it would no longer be what was reviewed.
+func (p *Plan) selectImages(accountID, region, repoName string, images []types.ImageDetail) ([]types.ImageDetail, error) {
+	repo := p.repository(accountID, region, repoName)
+	if repo == nil {
+		return nil, nil
+	}
+
+	byDigest := make(map[string]types.ImageDetail, len(images))
+	tagDigests := make(map[string]string)
+	for _, img := range images {
+		digest := aws.ToString(img.ImageDigest)
+		byDigest[digest] = img
+		for _, tag := range img.ImageTags {
+			tagDigests[tag] = digest
+		}
+	}
+
+	var toDelete []types.ImageDetail
+	for _, planned := range repo.Images {
+		for _, tag := range planned.Tags {
+			if digest, ok := tagDigests[tag]; ok && digest != planned.Digest {
+				return nil, fmt.Errorf("refusing to apply plan: tag %s was re-pushed since the plan was created (now %s)", tag, digest)
+			}
+		}
+
+		img, ok := byDigest[planned.Digest]
+		if !ok {
+			slog.Warn("Planned image no longer exists", "repository", repoName, "digest", planned.Digest)
+			continue
+		}
+		if pushedAt := aws.ToTime(img.ImagePushedAt); !pushedAt.IsZero() && !pushedAt.Equal(planned.PushedAt) {
+			return nil, fmt.Errorf("refusing to apply plan: image %s was re-pushed since the plan was created", planned.Digest)
+		}
+		for _, tag := range img.ImageTags {
+			if !slices.Contains(planned.Tags, tag) {
+				return nil, fmt.Errorf("refusing to apply plan: image %s was tagged %s since the plan was created", planned.Digest, tag)
+			}
+		}
+		toDelete = append(toDelete, img)
+	}
+	return toDelete, nil
+}
+
+// writePlan writes the plan as indented JSON
+func writePlan(w io.Writer, plan *Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// writePlanFile writes the plan to path, or to stdout when path is empty
+func writePlanFile(plan *Plan, path string) error {
+	if path == "" {
+		return writePlan(os.Stdout, plan)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+	if err := writePlan(file, plan); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return file.Close()
+}
+
+// readPlanFile reads and validates a plan written by the plan command
+func readPlanFile(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.SchemaVersion == 0 {
+		// Plans written before schema_version had the same format under
+		// version
+		var legacy struct {
+			Version int `json:"version"`
+		}
+		json.Unmarshal(data, &legacy)
+		plan.SchemaVersion = legacy.Version
+	}
+	if plan.SchemaVersion != schema.PlanVersion {
+		return nil, fmt.Errorf("unsupported plan schema version %d in %s: expected %d", plan.SchemaVersion, path, schema.PlanVersion)
+	}
+	for _, repo := range plan.Repositories {
+		for _, img := range repo.Images {
+			if img.Digest == "" {
+				return nil, fmt.Errorf("invalid plan %s: image without digest in repository %s", path, repo.Name)
+			}
+		}
+	}
+
+	return &plan, nil
+}
+
+// scanPlan scans like a dry run and returns the images it selected as a
+// plan, without the reporting of a full run
+func scanPlan(config Config) (*Plan, error) {
+	config.DryRun = true
+	summary, err := cleanupECR(config)
+	if err != nil {
+		return nil, err
+	}
+	return newPlan(summary, time.Now()), nil
+}
+
+// runPlan runs the plan command: a dry run whose selected images are
+// written to the -plan file
+func runPlan(config Config) int {
+	config.DryRun = true
+
+	config, restoreSignals := stopOnSignal(config)
+	summary, err := runCleanup(config)
+	restoreSignals()
+	if err != nil {
+		return 1
+	}
+
+	plan := newPlan(summary, time.Now())
+	if err := writePlanFile(plan, config.PlanFile); err != nil {
+		slog.Error("Error writing plan", "error", err)
+		return 1
+	}
+	if failed := summary.failedRepositories(); len(failed) > 0 {
+		slog.Warn("Repositories that failed are not part of the plan", "repositories", len(failed))
+	}
+
+	slog.Info("Wrote plan", "plan_id", plan.ID, "images", plan.Images, "empty_repositories", plan.EmptyRepositories, "size_mb", roundMB(plan.SizeBytes), "file", config.PlanFile)
+	return exitCode(summary)
+}
+
+// runApply runs the apply command: it deletes exactly the images of the
+// -plan file. With -dry-run it only shows what applying would delete.
+func runApply(config Config) int {
+	if config.PlanFile == "" {
+		slog.Error("The apply command requires -plan")
+		return 1
+	}
+
+	plan, err := readPlanFile(config.PlanFile)
+	if err != nil {
+		slog.Error("Invalid plan", "error", err)
+		return 1
+	}
+	config.plan = plan
+	slog.Info("Applying plan", "plan_id", plan.ID, "created_at", plan.CreatedAt.Format(time.RFC3339), "images", plan.Images, "empty_repositories", plan.EmptyRepositories)
+
+	config, restoreSignals := stopOnSignal(config)
+	summary, err := runCleanup(config)
+	restoreSignals()
+	if err != nil {
+		return 1
+	}
+
+	// Refused repositories fail the apply, so nothing goes unnoticed
+	if failed := summary.failedRepositories(); len(failed) > 0 {
+		slog.Error("Plan was not fully applied", "repositories_failed", len(failed))
+	}
+	return exitCode(summary)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/mchineboy/ecr-cleanup/schema"
+)
+
+// testPlanSummary returns a dry run summary with images selected in two
+// repositories
+func testPlanSummary() CleanupSummary {
+	pushed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return CleanupSummary{
+		Repositories: []RepositorySummary{
+			{Region: "us-east-1", Name: "api", Images: []ImageSummary{
+				{Digest: "sha256:aaa", Tags: []string{"v1"}, PushedAt: pushed, SizeBytes: 100},
+				{Digest: "sha256:bbb", PushedAt: pushed, SizeBytes: 200},
+			}},
+			{Region: "us-east-1", Name: "empty"},
+			{Region: "eu-west-1", Name: "api", Images: []ImageSummary{
+				{Digest: "sha256:ccc", PushedAt: pushed, SizeBytes: 300},
+			}},
+		},
+	}
+}
+
+// TestNewPlan tests building a plan from a dry run summary
+func TestNewPlan(t *testing.T) {
+	plan := newPlan(testPlanSummary(), time.Now())
+
+	if plan.ID == "" {
+		t.Error("Expected a plan ID")
+	}
+	if plan.Images != 3 || plan.SizeBytes != 600 {
+		t.Errorf("Expected 3 images and 600 bytes, got %d and %d", plan.Images, plan.SizeBytes)
+	}
+	if len(plan.Repositories) != 2 {
+		t.Fatalf("Expected repositories without images to be left out, got %d", len(plan.Repositories))
+	}
+	if repo := plan.repository("", "eu-west-1", "api"); repo == nil || repo.Images[0].Digest != "sha256:ccc" {
+		t.Errorf("Expected the eu-west-1 repository, got %+v", repo)
+	}
+	if repo := plan.repository("", "us-east-1", "empty"); repo != nil {
+		t.Errorf("Expected no entry for a repository without images, got %+v", repo)
+	}
+}
+
+// TestPlanSelectImages tests that only planned images are selected
+func TestPlanSelectImages(t *testing.T) {
+	plan := newPlan(testPlanSummary(), time.Now())
+	pushed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(pushed)},
+		{ImageDigest: aws.String("sha256:new"), ImageTags: []string{"v2"}, ImagePushedAt: aws.Time(pushed.Add(time.Hour))},
+		{ImageDigest: aws.String("sha256:ccc"), ImagePushedAt: aws.Time(pushed)},
+	}
+
+	selected, err := plan.selectImages("", "us-east-1", "api", images)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(selected) != 1 || aws.ToString(selected[0].ImageDigest) != "sha256:aaa" {
+		t.Errorf("Expected only sha256:aaa, got %v", selected)
+	}
+
+	if selected, _ := plan.selectImages("", "us-east-1", "other", images); len(selected) != 0 {
+		t.Errorf("Expected nothing for an unplanned repository, got %v", selected)
+	}
+}
+
+// TestPlanSelectImagesRepushed tests refusing images that changed since the
+// plan was created
+func TestPlanSelectImagesRepushed(t *testing.T) {
+	plan := newPlan(testPlanSummary(), time.Now())
+	pushed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		images []types.ImageDetail
+	}{
+		{"Tag moved to a new image", []types.ImageDetail{
+			{ImageDigest: aws.String("sha256:aaa"), ImagePushedAt: aws.Time(pushed)},
+			{ImageDigest: aws.String("sha256:new"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(pushed.Add(time.Hour))},
+		}},
+		{"Image pushed again", []types.ImageDetail{
+			{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(pushed.Add(time.Hour))},
+		}},
+		{"Image tagged since", []types.ImageDetail{
+			{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1", "prod"}, ImagePushedAt: aws.Time(pushed)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := plan.selectImages("", "us-east-1", "api", tt.images)
+			if err == nil || !strings.Contains(err.Error(), "refusing to apply plan") {
+				t.Errorf("Expected the repository to be refused, got %v", err)
+			}
+			if len(selected) != 0 {
+				t.Errorf("Expected nothing selected, got %v", selected)
+			}
+		})
+	}
+}
+
+// TestPlanFile tests writing and reading plan files
+func TestPlanFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plan.json")
+
+	plan := newPlan(testPlanSummary(), time.Now())
+	if err := writePlanFile(plan, path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	read, err := readPlanFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(read, plan) {
+		t.Errorf("Expected the plan to round-trip, got %+v", read)
+	}
+
+	invalid := map[string]string{
+		"version.json": `{"schema_version": 2, "repositories": []}`,
+		"legacy.json":  `{"version": 2, "repositories": []}`,
+		"missing.json": `{"repositories": []}`,
+		"digest.json":  `{"version": 1, "repositories": [{"name": "api", "images": [{"tags": ["v1"]}]}]}`,
+		"syntax.json":  `{"version": 1,`,
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := readPlanFile(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := readPlanFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected an error for a missing plan")
+	}
+}
+
+// TestPlanSchema tests that plans are written in the format of the schema
+// package, and that plans written before schema_version can still be
+// applied
+func TestPlanSchema(t *testing.T) {
+	var b strings.Builder
+	if err := writePlan(&b, newPlan(testPlanSummary(), time.Now())); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var plan schema.Plan
+	if err := json.Unmarshal([]byte(b.String()), &plan); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if plan.SchemaVersion != schema.PlanVersion || plan.Images != 3 || plan.Repositories[0].Images[0].Digest != "sha256:aaa" {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	os.WriteFile(path, []byte(`{"version": 1, "id": "a1b2", "repositories": [{"name": "api", "images": [{"digest": "sha256:aaa"}]}]}`), 0o644)
+	legacy, err := readPlanFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if legacy.SchemaVersion != schema.PlanVersion || legacy.ID != "a1b2" {
+		t.Errorf("Unexpected legacy plan: %+v", legacy)
+	}
+}
+
+// TestCleanupWithClientPlan tests that applying a plan ignores the retention
+// policy and unplanned repositories
+func TestCleanupWithClientPlan(t *testing.T) {
+	now := time.Now()
+	client := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+			Repositories: []types.Repository{
+				{RepositoryName: aws.String("api")},
+				{RepositoryName: aws.String("web")},
+			},
+		},
+		ListImagesOutput: &ecr.ListImagesOutput{
+			ImageIds: []types.ImageIdentifier{{ImageDigest: aws.String("sha256:aaa")}, {ImageDigest: aws.String("sha256:old")}},
+		},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{
+			ImageDetails: []types.ImageDetail{
+				// Recent, but planned
+				{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1"}, ImageSizeInBytes: aws.Int64(100)},
+				// Old, but not planned
+				{ImageDigest: aws.String("sha256:old"), ImagePushedAt: aws.Time(now.AddDate(-1, 0, 0)), ImageSizeInBytes: aws.Int64(100)},
+			},
+		},
+		BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{},
+	}
+
+	cfg := Config{Days: 10, region: "us-east-1", plan: newPlan(testPlanSummary(), now)}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if summary.RepositoriesProcessed != 1 {
+		t.Errorf("Expected only the planned repository to be processed, got %d", summary.RepositoriesProcessed)
+	}
+	if summary.ImagesDeleted != 1 {
+		t.Errorf("Expected 1 image deleted, got %d", summary.ImagesDeleted)
+	}
+	ids := client.LastBatchDeleteImageInput.ImageIds
+	if len(ids) != 1 || aws.ToString(ids[0].ImageDigest) != "sha256:aaa" || ids[0].ImageTag != nil {
+		t.Errorf("Expected only the planned image to be deleted by digest, got %v", ids)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// This file contains the named policies of the -config file, so one shared
+// file can serve several scheduled jobs of different strictness. Each
+// policy sets flags by name, and -policy-name picks the one a run uses:
+//
+//	policies:
+//	  aggressive-ci:
+//	    days: 3
+//	    max-images: 10
+//	    keep-newest: false
+//	  prod-conservative:
+//	    days: 90
+//	    min-keep: 5
+//	    protect-tags: [keep, release]
+//
+// The flags of the policy take the place of the defaults: flags given on
+// the command line or in the environment still take precedence over them.
+
+// unpolicedFlags are the flags a policy can't set, since they choose the
+// policy
+var unpolicedFlags = map[string]bool{
+	"config":      true,
+	"policy-name": true,
+}
+
+// policyValues returns the values a policy setting gives its flag, as
+// written in the file: one per element for lists, which repeatable flags
+// take one at a time and the others as a comma-separated value
+func policyValues(name string, node yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		values := make([]string, len(node.Content))
+		for i, element := range node.Content {
+			if element.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("expected a list of values")
+			}
+			values[i] = element.Value
+		}
+		if repeatableFlags[name] {
+			return values, nil
+		}
+		return []string{strings.Join(values, ",")}, nil
+	default:
+		return nil, fmt.Errorf("expected a value or a list of values")
+	}
+}
+
+// applyPolicy sets the flags that weren't given on the command line or in
+// the environment from the -policy-name policy of the -config file. A
+// policy that doesn't exist, or a setting that matches no flag, is an
+// error, so a misspelled one doesn't go unnoticed.
+func applyPolicy(fs *flag.FlagSet, configFile, policyName string) error {
+	if policyName == "" {
+		return nil
+	}
+	if configFile == "" {
+		return fmt.Errorf("-policy-name requires a -config file")
+	}
+	file, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	policy, ok := file.Policies[policyName]
+	if !ok {
+		names := make([]string, 0, len(file.Policies))
+		for name := range file.Policies {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("%s defines no policy %q (policies: %s)", configFile, policyName, strings.Join(names, ", "))
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// Sorted, so the first invalid setting is always the one reported
+	keys := make([]string, 0, len(policy))
+	for key := range policy {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		name := optionName(key)
+		if fs.Lookup(name) == nil || unpolicedFlags[name] {
+			return fmt.Errorf("%s: policies.%s.%s doesn't match any flag a policy can set", configFile, policyName, key)
+		}
+		if given[name] {
+			continue
+		}
+		values, err := policyValues(name, policy[key])
+		if err != nil {
+			return fmt.Errorf("%s: policies.%s.%s: %w", configFile, policyName, key, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid value %q for policies.%s.%s: %w", configFile, value, policyName, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePolicies writes a -config file defining named policies
+func writePolicies(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ecr-cleanup.yaml")
+	content := `policies:
+  aggressive-ci:
+    days: 3
+    max_images: 10
+    keep-newest: false
+    repository: [team/ci-a, team/ci-b]
+  prod-conservative:
+    days: 90
+    before: 2024-06-01
+    protect-tags: [keep, release]
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestApplyPolicy tests setting flags from a named policy, with the command
+// line and the environment taking precedence
+func TestApplyPolicy(t *testing.T) {
+	path := writePolicies(t)
+	t.Setenv("ECR_CLEANUP_MAX_IMAGES", "20")
+
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-policy-name", "aggressive-ci", "-dry-run"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Days != 3 || cfg.KeepNewest || cfg.MaxImages != 20 || !cfg.DryRun {
+		t.Errorf("Expected the policy under the environment and the command line, got days %d, keep newest %v, max images %d", cfg.Days, cfg.KeepNewest, cfg.MaxImages)
+	}
+	if !reflect.DeepEqual(cfg.Repositories, []string{"team/ci-a", "team/ci-b"}) {
+		t.Errorf("Expected a repository per list element, got %v", cfg.Repositories)
+	}
+
+	cfg, err = parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-policy-name", "prod-conservative", "-days", "60"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Days != 60 || !cfg.Before.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the command line over the policy, got days %d, before %v", cfg.Days, cfg.Before)
+	}
+	if !reflect.DeepEqual(cfg.ProtectTags, []string{"keep", "release"}) {
+		t.Errorf("Expected the policy's protected tags, got %v", cfg.ProtectTags)
+	}
+}
+
+// TestApplyPolicyErrors tests rejecting unknown policies and settings
+func TestApplyPolicyErrors(t *testing.T) {
+	path := writePolicies(t)
+	bad := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := os.WriteFile(bad, []byte("policies:\n  ci:\n    daze: 3\n  nested:\n    config: other.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-policy-name", "ci"}, "-policy-name requires a -config file"},
+		{[]string{"-config", path, "-policy-name", "staging"}, `defines no policy "staging" (policies: aggressive-ci, prod-conservative)`},
+		{[]string{"-config", bad, "-policy-name", "ci"}, "policies.ci.daze doesn't match any flag"},
+		{[]string{"-config", bad, "-policy-name", "nested"}, "policies.nested.config doesn't match any flag"},
+	}
+	for _, tt := range tests {
+		_, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: expected %q, got %v", tt.args, tt.want, err)
+		}
+	}
+}
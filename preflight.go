@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+)
+
+// This file contains -preflight, which checks that the credentials of a
+// registry have the ECR permissions the cleanup needs before anything in it
+// is touched, rather than failing halfway through. Each permission is
+// probed with a call that changes nothing: the read calls fetch a single
+// result, and BatchDeleteImage is asked to delete a digest no image can
+// have, which ECR only answers with a failure for the image once the caller
+// is allowed to delete. print-iam-policy prints the policy that grants
+// them.
+
+// preflightDigest is the digest of the image the BatchDeleteImage probe
+// deletes; no image has it
+const preflightDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// isAccessDenied reports whether AWS refused a call for lack of permission
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDeniedException", "AccessDenied", "UnauthorizedOperation":
+		return true
+	}
+	return false
+}
+
+// preflight probes the ECR permissions of the cleanup and fails with the
+// actions that are denied
+func preflight(ctx context.Context, client ECRClient, cfg Config) error {
+	var denied []string
+	probe := func(action string, call func() error) error {
+		err := call()
+		switch {
+		case err == nil:
+			return nil
+		case isAccessDenied(err):
+			denied = append(denied, action)
+			return nil
+		default:
+			return fmt.Errorf("preflight check of %s failed: %w", action, err)
+		}
+	}
+
+	// The other calls need a repository to probe
+	var repoName *string
+	err := probe("ecr:DescribeRepositories", func() error {
+		resp, err := client.DescribeRepositories(ctx, &ecr.DescribeRepositoriesInput{MaxResults: aws.Int32(1)})
+		if err == nil && len(resp.Repositories) > 0 {
+			repoName = resp.Repositories[0].RepositoryName
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if repoName == nil {
+		if len(denied) == 0 {
+			slog.Warn("No repository to check the image permissions against", "action", "preflight")
+		}
+		return preflightResult(denied, cfg)
+	}
+
+	if !cfg.Public && !cfg.SkipListImages {
+		err := probe("ecr:ListImages", func() error {
+			_, err := client.ListImages(ctx, &ecr.ListImagesInput{RepositoryName: repoName, MaxResults: aws.Int32(1)})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = probe("ecr:DescribeImages", func() error {
+		_, err := client.DescribeImages(ctx, &ecr.DescribeImagesInput{RepositoryName: repoName, MaxResults: aws.Int32(1)})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !cfg.DryRun && !cfg.UntagOnly {
+		err := probe("ecr:BatchDeleteImage", func() error {
+			_, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+				RepositoryName: repoName,
+				ImageIds:       []types.ImageIdentifier{{ImageDigest: aws.String(preflightDigest)}},
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return preflightResult(denied, cfg)
+}
+
+// preflightResult fails with the denied actions, if any
+func preflightResult(denied []string, cfg Config) error {
+	if len(denied) > 0 {
+		if cfg.Public {
+			for i, action := range denied {
+				denied[i] = strings.Replace(action, "ecr:", "ecr-public:", 1)
+			}
+		}
+		return fmt.Errorf("preflight check failed: the credentials are not allowed to call %s; print-iam-policy prints the policy the run needs", strings.Join(denied, ", "))
+	}
+	slog.Info("Preflight check passed", "action", "preflight")
+	return nil
+}
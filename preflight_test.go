@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/smithy-go"
+)
+
+// TestPreflight tests probing the ECR permissions of a registry
+func TestPreflight(t *testing.T) {
+	client := newGuardrailClient(1, 1)
+	if err := preflight(context.Background(), client, Config{}); err != nil {
+		t.Fatalf("Expected the preflight check to pass, got %v", err)
+	}
+	if client.ListImagesCalls != 1 || client.DescribeImagesCalls != 1 || client.BatchDeleteImageCalls != 1 {
+		t.Errorf("Expected each permission probed once, got %d, %d and %d calls", client.ListImagesCalls, client.DescribeImagesCalls, client.BatchDeleteImageCalls)
+	}
+	if got := client.LastBatchDeleteImageInput.ImageIds[0].ImageDigest; got == nil || *got != preflightDigest {
+		t.Errorf("Expected the deletion probe to name a digest no image has, got %v", got)
+	}
+
+	// Denied calls are reported together
+	denied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}
+	client = newGuardrailClient(1, 1)
+	client.ListImagesError = denied
+	client.BatchDeleteImageError = denied
+	err := preflight(context.Background(), client, Config{})
+	if err == nil || !strings.Contains(err.Error(), "ecr:ListImages, ecr:BatchDeleteImage") {
+		t.Errorf("Expected the denied actions reported, got %v", err)
+	}
+
+	// Dry runs don't probe deletion
+	client = newGuardrailClient(1, 1)
+	client.BatchDeleteImageError = denied
+	if err := preflight(context.Background(), client, Config{DryRun: true}); err != nil || client.BatchDeleteImageCalls != 0 {
+		t.Errorf("Expected a dry run not to probe deletion, got %v after %d calls", err, client.BatchDeleteImageCalls)
+	}
+
+	// Other errors fail the check as they are
+	client = newGuardrailClient(0, 0)
+	client.DescribeRepositoriesOutput = &ecr.DescribeRepositoriesOutput{}
+	client.DescribeRepositoriesError = &smithy.GenericAPIError{Code: "ServerException"}
+	if err := preflight(context.Background(), client, Config{}); err == nil || isAccessDenied(err) {
+		t.Errorf("Expected the error of the call, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// This file contains the progress line shown while repositories are
+// processed. It is only drawn when stderr is a terminal, and log records are
+// written through it so they appear above the line instead of garbling it.
+
+// progressRefreshInterval is how often the line is redrawn between updates
+const progressRefreshInterval = time.Second
+
+// progressBar tracks and draws the progress of the repositories in a run
+type progressBar struct {
+	mu      sync.Mutex
+	out     io.Writer
+	dryRun  bool
+	drawn   bool
+	total   int
+	done    int
+	scanned int
+	deleted int
+	start   time.Time
+	stop    chan struct{}
+}
+
+// activeProgress is the progress bar of the current run, or nil when the
+// progress line is off
+var activeProgress *progressBar
+
+// isTerminal reports whether the file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressBar returns a progress bar drawing to out, or nil when progress
+// is disabled or out is not a terminal
+func newProgressBar(cfg Config, out *os.File) *progressBar {
+	if cfg.NoProgress || !isTerminal(out) {
+		return nil
+	}
+	return &progressBar{out: out, dryRun: cfg.DryRun}
+}
+
+// begin starts tracking a batch of repositories and redraws the line every
+// second so long repositories don't look hung
+func (p *progressBar) begin(total int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total, p.done, p.scanned, p.deleted = total, 0, 0, 0
+	p.start = time.Now()
+	p.stop = make(chan struct{})
+	p.draw()
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(progressRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.draw()
+				p.mu.Unlock()
+			}
+		}
+	}(p.stop)
+}
+
+// repositoryDone records a finished repository
+func (p *progressBar) repositoryDone(repoSummary CleanupSummary) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.scanned += repoSummary.ImagesScanned
+	p.deleted += repoSummary.ImagesDeleted
+	p.draw()
+}
+
+// finish stops the refresh and removes the line
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.clear()
+}
+
+// Write writes a log record above the progress line
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wasDrawn := p.drawn
+	p.clear()
+	n, err := p.out.Write(b)
+	if wasDrawn {
+		p.draw()
+	}
+	return n, err
+}
+
+// clear erases the progress line; the caller holds the lock
+func (p *progressBar) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// draw redraws the progress line; the caller holds the lock
+func (p *progressBar) draw() {
+	if p.stop == nil {
+		return
+	}
+	fmt.Fprint(p.out, "\r\033[K"+p.line(time.Now()))
+	p.drawn = true
+}
+
+// line renders the progress line at the given time
+func (p *progressBar) line(now time.Time) string {
+	percent := 100
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+
+	deletedLabel := "deleted"
+	if p.dryRun {
+		deletedLabel = "would delete"
+	}
+
+	elapsed := now.Sub(p.start)
+	eta := "--"
+	if p.done > 0 && p.done < p.total {
+		remaining := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		eta = remaining.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("Repositories %d/%d (%d%%) | images scanned %d | %s %d | elapsed %s | ETA %s",
+		p.done, p.total, percent, p.scanned, deletedLabel, p.deleted,
+		elapsed.Round(time.Second), eta)
+}
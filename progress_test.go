@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewProgressBar tests that the progress line is off when not on a terminal
+func TestNewProgressBar(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer f.Close()
+
+	if bar := newProgressBar(Config{}, f); bar != nil {
+		t.Error("Expected no progress bar for a regular file")
+	}
+}
+
+// TestProgressBarNil tests that a nil progress bar is a no-op
+func TestProgressBarNil(t *testing.T) {
+	var bar *progressBar
+	bar.begin(10)
+	bar.repositoryDone(CleanupSummary{ImagesScanned: 1})
+	bar.finish()
+}
+
+// TestProgressBarLine tests rendering the progress line
+func TestProgressBarLine(t *testing.T) {
+	start := time.Now()
+	bar := &progressBar{total: 4, done: 1, scanned: 120, deleted: 30, start: start}
+
+	line := bar.line(start.Add(10 * time.Second))
+	want := "Repositories 1/4 (25%) | images scanned 120 | deleted 30 | elapsed 10s | ETA 30s"
+	if line != want {
+		t.Errorf("Expected %q, got %q", want, line)
+	}
+
+	bar.dryRun = true
+	bar.done = 0
+	line = bar.line(start)
+	if !strings.Contains(line, "would delete 30") || !strings.Contains(line, "ETA --") {
+		t.Errorf("Unexpected dry run line: %q", line)
+	}
+}
+
+// TestProgressBarWrite tests that log records are written above the line
+func TestProgressBarWrite(t *testing.T) {
+	var out bytes.Buffer
+	bar := &progressBar{out: &out}
+
+	bar.begin(2)
+	bar.repositoryDone(CleanupSummary{ImagesScanned: 5, ImagesDeleted: 2})
+	out.Reset()
+
+	if _, err := bar.Write([]byte("log record\n")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	written := out.String()
+	if !strings.HasPrefix(written, "\r\033[Klog record\n") {
+		t.Errorf("Expected the line to be cleared before the record, got %q", written)
+	}
+	if !strings.Contains(written, "Repositories 1/2") {
+		t.Errorf("Expected the line to be redrawn after the record, got %q", written)
+	}
+
+	bar.finish()
+	out.Reset()
+	bar.Write([]byte("after\n"))
+	if out.String() != "after\n" {
+		t.Errorf("Expected plain output after finish, got %q", out.String())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -protect-tags, a naming convention for pinning images:
+// an image carrying one of the tags is never deleted, whatever policy or
+// plan selected it. A developer pins a build from CI by pushing one extra
+// tag, e.g. docker tag app:1.4.2 app:pinned, without editing a keep-list.
+
+// defaultProtectTags are the tags that pin an image unless -protect-tags says
+// otherwise
+const defaultProtectTags = "keep,pinned,do-not-delete"
+
+// parseProtectTags splits a comma-separated list of tags, ignoring blanks
+func parseProtectTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// protectedTag returns the first of the image's tags that pins it, if any
+func protectedTag(img types.ImageDetail, protect []string) (string, bool) {
+	for _, tag := range img.ImageTags {
+		if slices.Contains(protect, tag) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// excludeProtectedTags returns the images that no protected tag pins
+func excludeProtectedTags(repoName string, images []types.ImageDetail, protect []string) []types.ImageDetail {
+	if len(protect) == 0 {
+		return images
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range images {
+		if tag, ok := protectedTag(img, protect); ok {
+			slog.Info("Keeping pinned image", "action", "keep", "repository", repoName, "tag", tag, "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestParseProtectTags tests the default convention and disabling it
+func TestParseProtectTags(t *testing.T) {
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(cfg.ProtectTags, []string{"keep", "pinned", "do-not-delete"}) {
+		t.Errorf("Expected the default tags, got %v", cfg.ProtectTags)
+	}
+
+	cfg, _ = parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-protect-tags", ""})
+	if len(cfg.ProtectTags) != 0 {
+		t.Errorf("Expected an empty value to disable the convention, got %v", cfg.ProtectTags)
+	}
+	if tags := parseProtectTags(" release , ,golden"); !reflect.DeepEqual(tags, []string{"release", "golden"}) {
+		t.Errorf("Expected blanks ignored, got %v", tags)
+	}
+}
+
+// TestExcludeProtectedTags tests that images carrying a protected tag are
+// never deleted
+func TestExcludeProtectedTags(t *testing.T) {
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:aaa"), ImageTags: []string{"v1", "pinned"}},
+		{ImageDigest: aws.String("sha256:bbb"), ImageTags: []string{"keep-me"}},
+		{ImageDigest: aws.String("sha256:ccc")},
+		{ImageDigest: aws.String("sha256:ddd"), ImageTags: []string{"do-not-delete"}},
+	}
+
+	remaining := excludeProtectedTags("api", images, parseProtectTags(defaultProtectTags))
+	if len(remaining) != 2 || *remaining[0].ImageDigest != "sha256:bbb" || *remaining[1].ImageDigest != "sha256:ccc" {
+		t.Errorf("Expected only the unpinned images, got %+v", remaining)
+	}
+	if remaining := excludeProtectedTags("api", images, nil); len(remaining) != len(images) {
+		t.Error("Expected no protected tags to protect nothing")
+	}
+}
+
+// TestCleanupWithProtectedTags tests pinned images across a run
+func TestCleanupWithProtectedTags(t *testing.T) {
+	client := newGuardrailClient(1, 5)
+	client.DescribeImagesOutput.ImageDetails[1].ImageTags = []string{"pinned"}
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, ProtectTags: []string{"pinned"}}, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 4 {
+		t.Errorf("Expected the pinned image kept, got %d images deleted", summary.ImagesDeleted)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+)
+
+// This file contains the in-use providers. A provider knows one source of
+// running workloads and contributes the images they use to the run's
+// keep-set, which no policy can delete from. Regional providers (App
+// Runner, Batch, ECS, Lambda) are collected in every region the run cleans
+// up; the others (Argo CD, a file of image URIs, a command) once per run.
+// -protect-apprunner and -protect-batch enable their providers, and any
+// provider can be enabled under in_use_providers in the -config file:
+//
+//	in_use_providers:
+//	  - type: ecs
+//	  - type: lambda
+//	  - type: argocd
+//	    server: https://argocd.example.com
+//	    token_env: ARGOCD_TOKEN
+//	  - type: file
+//	    path: /etc/ecr-cleanup/in-use.txt
+//	  - type: exec
+//	    command: kubectl get pods -A -o jsonpath='{..image}'
+
+// InUseProvider contributes the images a source of workloads uses to the
+// keep-set
+type InUseProvider interface {
+	// Name identifies the provider in logs and errors
+	Name() string
+	// Regional reports whether the provider is collected in every region,
+	// with the region's AWS config, rather than once per run
+	Regional() bool
+	// Collect adds the images in use to the keep-set
+	Collect(ctx context.Context, awsConfig aws.Config, keep *keepSet) error
+}
+
+// providerConfig configures an in-use provider in the -config file
+type providerConfig struct {
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Command  string `yaml:"command,omitempty"`
+}
+
+// newInUseProvider creates the provider a -config entry configures
+func newInUseProvider(pc providerConfig) (InUseProvider, error) {
+	switch pc.Type {
+	case "app-runner":
+		return appRunnerProvider{}, nil
+	case "batch":
+		return batchProvider{}, nil
+	case "ecs":
+		return ecsProvider{}, nil
+	case "lambda":
+		return lambdaProvider{}, nil
+	case "argocd":
+		if _, err := parseArgoCDServer(pc.Server); err != nil {
+			return nil, err
+		}
+		token := pc.Token
+		if pc.TokenEnv != "" {
+			token = os.Getenv(pc.TokenEnv)
+		}
+		return argoCDProvider{server: pc.Server, token: token}, nil
+	case "file":
+		if pc.Path == "" {
+			return nil, fmt.Errorf("the file provider requires a path")
+		}
+		return fileProvider{path: pc.Path}, nil
+	case "exec":
+		if pc.Command == "" {
+			return nil, fmt.Errorf("the exec provider requires a command")
+		}
+		return execProvider{command: pc.Command}, nil
+	case "eks":
+		// Pods are listed through each cluster's Kubernetes API, with its
+		// own authentication, which the exec provider leaves to kubectl
+		return nil, fmt.Errorf("there is no eks in-use provider: list the images of an EKS cluster's pods with the exec provider, e.g. command: kubectl get pods -A -o jsonpath='{..image}'")
+	default:
+		return nil, fmt.Errorf("unknown in-use provider type %q: must be app-runner, batch, ecs, lambda, argocd, file or exec", pc.Type)
+	}
+}
+
+// loadInUseProviders returns the providers the flags and the -config file
+// enable
+func loadInUseProviders(cfg Config) ([]InUseProvider, error) {
+	var providers []InUseProvider
+	if cfg.ProtectAppRunner {
+		providers = append(providers, appRunnerProvider{})
+	}
+	if cfg.ProtectBatch {
+		providers = append(providers, batchProvider{})
+	}
+	if cfg.ConfigFile == "" {
+		return providers, nil
+	}
+
+	file, err := readConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	for i, pc := range file.InUseProviders {
+		provider, err := newInUseProvider(pc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: in_use_providers[%d]: %w", cfg.ConfigFile, i, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// loadSharedInUse loads the run's in-use providers and collects those
+// collected once per run
+func loadSharedInUse(ctx context.Context, cfg Config) ([]InUseProvider, *keepSet, error) {
+	providers, err := loadInUseProviders(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep, err := collectInUseImages(ctx, aws.Config{}, providers, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keep.size() > 0 {
+		slog.Info("Protecting image references of the in-use providers", "images", keep.size())
+	}
+	return providers, keep, nil
+}
+
+// collectInUseImages builds the keep-set from the providers collected in
+// a region, or from those collected once per run
+func collectInUseImages(ctx context.Context, awsConfig aws.Config, providers []InUseProvider, regional bool) (*keepSet, error) {
+	keep := newKeepSet()
+	for _, provider := range providers {
+		if provider.Regional() != regional {
+			continue
+		}
+		if err := provider.Collect(ctx, awsConfig, keep); err != nil {
+			return nil, fmt.Errorf("failed to collect %s images: %w", provider.Name(), err)
+		}
+	}
+	return keep, nil
+}
+
+// appRunnerProvider protects the images of App Runner services
+type appRunnerProvider struct{}
+
+// Name identifies the provider
+func (appRunnerProvider) Name() string { return "App Runner" }
+
+// Regional reports that App Runner services are collected in every region
+func (appRunnerProvider) Regional() bool { return true }
+
+// Collect adds the images of the App Runner services
+func (appRunnerProvider) Collect(ctx context.Context, awsConfig aws.Config, keep *keepSet) error {
+	return collectAppRunnerImages(ctx, apprunner.NewFromConfig(awsConfig), keep)
+}
+
+// batchProvider protects the images of active Batch job definitions
+type batchProvider struct{}
+
+// Name identifies the provider
+func (batchProvider) Name() string { return "Batch" }
+
+// Regional reports that Batch job definitions are collected in every region
+func (batchProvider) Regional() bool { return true }
+
+// Collect adds the images of the active Batch job definitions
+func (batchProvider) Collect(ctx context.Context, awsConfig aws.Config, keep *keepSet) error {
+	return collectBatchImages(ctx, batch.NewFromConfig(awsConfig), keep)
+}
+
+// argoCDProvider protects the images deployed by the applications of an
+// Argo CD server
+type argoCDProvider struct {
+	server string
+	token  string
+}
+
+// Name identifies the provider
+func (argoCDProvider) Name() string { return "Argo CD" }
+
+// Regional reports that Argo CD is collected once per run
+func (argoCDProvider) Regional() bool { return false }
+
+// Collect adds the images of the Argo CD applications
+func (p argoCDProvider) Collect(ctx context.Context, _ aws.Config, keep *keepSet) error {
+	images, err := collectArgoCDImages(ctx, &http.Client{Timeout: argoCDTimeout}, p.server, p.token)
+	if err != nil {
+		return err
+	}
+	keep.merge(images)
+	return nil
+}
+
+// fileProvider protects the image URIs listed in a file, one per line,
+// e.g. an inventory exported by a deployment system. Blank lines and
+// anything after a # are ignored.
+type fileProvider struct {
+	path string
+}
+
+// Name identifies the provider
+func (p fileProvider) Name() string { return "file " + p.path }
+
+// Regional reports that the file is read once per run
+func (fileProvider) Regional() bool { return false }
+
+// Collect adds the image URIs of the file
+func (p fileProvider) Collect(_ context.Context, _ aws.Config, keep *keepSet) error {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			keep.addURI(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// execProvider protects the image URIs a command prints, separated by
+// whitespace, e.g. kubectl listing the images of an EKS cluster. The
+// command runs through sh -c like the hooks, and failing or running for
+// more than a minute fails the run, so nothing is deleted unchecked.
+type execProvider struct {
+	command string
+}
+
+// Name identifies the provider
+func (execProvider) Name() string { return "exec" }
+
+// Regional reports that the command runs once per run
+func (execProvider) Regional() bool { return false }
+
+// Collect runs the command and adds the image URIs it prints
+func (p execProvider) Collect(ctx context.Context, _ aws.Config, keep *keepSet) error {
+	ctx, cancel := context.WithTimeout(ctx, preDeleteHookTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "sh", "-c", p.command).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return err
+	}
+	for _, uri := range strings.Fields(string(out)) {
+		keep.addURI(uri)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestLoadInUseProviders tests enabling providers with flags and the
+// -config file
+func TestLoadInUseProviders(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`in_use_providers:
+  - type: ecs
+  - type: lambda
+  - type: argocd
+    server: https://argocd.example.com
+    token_env: TEST_ARGOCD_TOKEN
+  - type: file
+    path: /etc/ecr-cleanup/in-use.txt
+  - type: exec
+    command: echo
+`), 0o644)
+	t.Setenv("TEST_ARGOCD_TOKEN", "secret")
+
+	providers, err := loadInUseProviders(Config{ProtectAppRunner: true, ConfigFile: path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var names []string
+	for _, p := range providers {
+		names = append(names, p.Name())
+	}
+	if got := strings.Join(names, ","); got != "App Runner,ECS,Lambda,Argo CD,file /etc/ecr-cleanup/in-use.txt,exec" {
+		t.Errorf("Unexpected providers %s", got)
+	}
+	if argo := providers[3].(argoCDProvider); argo.token != "secret" {
+		t.Errorf("Expected the token from the environment, got %q", argo.token)
+	}
+
+	os.WriteFile(path, []byte("in_use_providers:\n  - type: eks\n"), 0o644)
+	if _, err := loadInUseProviders(Config{ConfigFile: path}); err == nil || !strings.Contains(err.Error(), "with the exec provider") {
+		t.Errorf("Expected EKS clusters to be pointed at the exec provider, got %v", err)
+	}
+
+	for _, content := range []string{
+		"in_use_providers:\n  - type: file\n",
+		"in_use_providers:\n  - type: argocd\n    server: argocd.example.com\n",
+		"in_use_provider:\n  - type: ecs\n",
+	} {
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadInUseProviders(Config{ConfigFile: path}); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+// TestSharedInUseProviders tests collecting the providers collected once
+// per run and protecting their images
+func TestSharedInUseProviders(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "in-use.txt")
+	os.WriteFile(list, []byte("# deployed\n123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1\n\n"), 0o644)
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`in_use_providers:
+  - type: file
+    path: `+list+`
+  - type: exec
+    command: echo 123456789012.dkr.ecr.us-east-1.amazonaws.com/app@sha256:222 nginx:latest
+  - type: ecs
+`), 0o644)
+
+	providers, keep, err := loadSharedInUse(context.Background(), Config{ConfigFile: path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(providers) != 3 || keep.size() != 2 {
+		t.Fatalf("Expected 3 providers and 2 protected references, got %d and %d", len(providers), keep.size())
+	}
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:111"), ImageTags: []string{"v1"}},
+		{ImageDigest: aws.String("sha256:222")},
+		{ImageDigest: aws.String("sha256:333"), ImageTags: []string{"v3"}},
+	}
+	if remaining := keep.exclude("app", images); len(remaining) != 1 || aws.ToString(remaining[0].ImageDigest) != "sha256:333" {
+		t.Errorf("Expected only sha256:333 to remain, got %v", digestsOf(remaining))
+	}
+
+	os.WriteFile(path, []byte("in_use_providers:\n  - type: exec\n    command: echo broken >&2; exit 3\n"), 0o644)
+	if _, _, err := loadSharedInUse(context.Background(), Config{ConfigFile: path}); err == nil || !strings.Contains(err.Error(), "failed to collect exec images") || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected the failing command to fail the run, got %v", err)
+	}
+}
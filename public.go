@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	publictypes "github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+)
+
+// This file contains the ECR Public support. With -public the run cleans up
+// the account's public gallery repositories instead of its private ones.
+// ECR Public has its own API, so publicClient adapts it to ECRClient and
+// the same selection, guardrails and reporting apply.
+
+// publicRegion is the only region with an ECR Public API endpoint
+const publicRegion = "us-east-1"
+
+// PublicECRClient defines the ECR Public operations needed for a cleanup
+type PublicECRClient interface {
+	DescribeRepositories(ctx context.Context, params *ecrpublic.DescribeRepositoriesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error)
+	DescribeImages(ctx context.Context, params *ecrpublic.DescribeImagesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeImagesOutput, error)
+	BatchDeleteImage(ctx context.Context, params *ecrpublic.BatchDeleteImageInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.BatchDeleteImageOutput, error)
+}
+
+// publicClient serves the ECRClient operations from ECR Public
+type publicClient struct {
+	client PublicECRClient
+}
+
+// newECRClient creates the client for the region of the AWS config: ECR,
+// or ECR Public with -public
+func newECRClient(awsConfig aws.Config, cfg Config) ECRClient {
+	if cfg.Public {
+		return &publicClient{client: ecrpublic.NewFromConfig(awsConfig)}
+	}
+	return ecr.NewFromConfig(awsConfig)
+}
+
+// DescribeRepositories lists the public repositories
+func (c *publicClient) DescribeRepositories(ctx context.Context, params *ecr.DescribeRepositoriesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error) {
+	resp, err := c.client.DescribeRepositories(ctx, &ecrpublic.DescribeRepositoriesInput{
+		RegistryId:      params.RegistryId,
+		RepositoryNames: params.RepositoryNames,
+		MaxResults:      params.MaxResults,
+		NextToken:       params.NextToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ecr.DescribeRepositoriesOutput{NextToken: resp.NextToken}
+	for _, repo := range resp.Repositories {
+		out.Repositories = append(out.Repositories, types.Repository{
+			CreatedAt:      repo.CreatedAt,
+			RegistryId:     repo.RegistryId,
+			RepositoryArn:  repo.RepositoryArn,
+			RepositoryName: repo.RepositoryName,
+			RepositoryUri:  repo.RepositoryUri,
+		})
+	}
+	return out, nil
+}
+
+// ListImages lists the images of a public repository. ECR Public has no
+// ListImages, so the images are listed with DescribeImages.
+func (c *publicClient) ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	resp, err := c.client.DescribeImages(ctx, &ecrpublic.DescribeImagesInput{
+		RegistryId:     params.RegistryId,
+		RepositoryName: params.RepositoryName,
+		MaxResults:     params.MaxResults,
+		NextToken:      params.NextToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ecr.ListImagesOutput{NextToken: resp.NextToken}
+	for _, img := range resp.ImageDetails {
+		out.ImageIds = append(out.ImageIds, types.ImageIdentifier{ImageDigest: img.ImageDigest})
+	}
+	return out, nil
+}
+
+// DescribeImages describes the images of a public repository
+func (c *publicClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	resp, err := c.client.DescribeImages(ctx, &ecrpublic.DescribeImagesInput{
+		RegistryId:     params.RegistryId,
+		RepositoryName: params.RepositoryName,
+		ImageIds:       toPublicImageIDs(params.ImageIds),
+		MaxResults:     params.MaxResults,
+		NextToken:      params.NextToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ecr.DescribeImagesOutput{NextToken: resp.NextToken}
+	for _, img := range resp.ImageDetails {
+		out.ImageDetails = append(out.ImageDetails, types.ImageDetail{
+			ArtifactMediaType:      img.ArtifactMediaType,
+			ImageDigest:            img.ImageDigest,
+			ImageManifestMediaType: img.ImageManifestMediaType,
+			ImagePushedAt:          img.ImagePushedAt,
+			ImageSizeInBytes:       img.ImageSizeInBytes,
+			ImageTags:              img.ImageTags,
+			RegistryId:             img.RegistryId,
+			RepositoryName:         img.RepositoryName,
+		})
+	}
+	return out, nil
+}
+
+// BatchDeleteImage deletes images from a public repository
+func (c *publicClient) BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	resp, err := c.client.BatchDeleteImage(ctx, &ecrpublic.BatchDeleteImageInput{
+		RegistryId:     params.RegistryId,
+		RepositoryName: params.RepositoryName,
+		ImageIds:       toPublicImageIDs(params.ImageIds),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ecr.BatchDeleteImageOutput{}
+	for _, id := range resp.ImageIds {
+		out.ImageIds = append(out.ImageIds, types.ImageIdentifier{ImageDigest: id.ImageDigest, ImageTag: id.ImageTag})
+	}
+	for _, failure := range resp.Failures {
+		converted := types.ImageFailure{
+			FailureCode:   types.ImageFailureCode(failure.FailureCode),
+			FailureReason: failure.FailureReason,
+		}
+		if failure.ImageId != nil {
+			converted.ImageId = &types.ImageIdentifier{ImageDigest: failure.ImageId.ImageDigest, ImageTag: failure.ImageId.ImageTag}
+		}
+		out.Failures = append(out.Failures, converted)
+	}
+	return out, nil
+}
+
+// toPublicImageIDs converts image identifiers to their ECR Public type
+func toPublicImageIDs(ids []types.ImageIdentifier) []publictypes.ImageIdentifier {
+	var converted []publictypes.ImageIdentifier
+	for _, id := range ids {
+		converted = append(converted, publictypes.ImageIdentifier{ImageDigest: id.ImageDigest, ImageTag: id.ImageTag})
+	}
+	return converted
+}
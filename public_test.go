@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	publictypes "github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+)
+
+// mockPublicClient serves one public repository from memory
+type mockPublicClient struct {
+	images  []publictypes.ImageDetail
+	deleted []string
+}
+
+func (m *mockPublicClient) DescribeRepositories(ctx context.Context, params *ecrpublic.DescribeRepositoriesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error) {
+	return &ecrpublic.DescribeRepositoriesOutput{Repositories: []publictypes.Repository{{RepositoryName: aws.String("gallery")}}}, nil
+}
+
+func (m *mockPublicClient) DescribeImages(ctx context.Context, params *ecrpublic.DescribeImagesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeImagesOutput, error) {
+	return &ecrpublic.DescribeImagesOutput{ImageDetails: m.images}, nil
+}
+
+func (m *mockPublicClient) BatchDeleteImage(ctx context.Context, params *ecrpublic.BatchDeleteImageInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.BatchDeleteImageOutput, error) {
+	out := &ecrpublic.BatchDeleteImageOutput{}
+	for _, id := range params.ImageIds {
+		if aws.ToString(id.ImageDigest) == "sha256:locked" {
+			out.Failures = append(out.Failures, publictypes.ImageFailure{
+				ImageId:       &publictypes.ImageIdentifier{ImageDigest: id.ImageDigest},
+				FailureCode:   publictypes.ImageFailureCodeImageReferencedByManifestList,
+				FailureReason: aws.String("referenced by a manifest list"),
+			})
+			continue
+		}
+		m.deleted = append(m.deleted, aws.ToString(id.ImageDigest)+aws.ToString(id.ImageTag))
+		out.ImageIds = append(out.ImageIds, id)
+	}
+	return out, nil
+}
+
+// TestPublicClientCleanup tests cleaning up a public repository through the
+// same selection as private repositories
+func TestPublicClientCleanup(t *testing.T) {
+	now := time.Now()
+	mock := &mockPublicClient{images: []publictypes.ImageDetail{
+		{ImageDigest: aws.String("sha256:new"), ImagePushedAt: aws.Time(now), ImageSizeInBytes: aws.Int64(100)},
+		{ImageDigest: aws.String("sha256:old"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -30)), ImageSizeInBytes: aws.Int64(100)},
+		{ImageDigest: aws.String("sha256:older"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -60)), ImageSizeInBytes: aws.Int64(100), ImageTags: []string{"v1"}},
+	}}
+
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, SkipListImages: true}, &publicClient{client: mock})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesScanned != 3 || summary.ImagesDeleted != 2 {
+		t.Errorf("Expected 2 of 3 images deleted, got %+v", summary)
+	}
+	if len(mock.deleted) != 2 || mock.deleted[0] != "sha256:old" || mock.deleted[1] != "sha256:older" {
+		t.Errorf("Expected the old images deleted, got %v", mock.deleted)
+	}
+}
+
+// TestPublicClientBatchDeleteFailures tests converting ECR Public deletion failures
+func TestPublicClientBatchDeleteFailures(t *testing.T) {
+	client := &publicClient{client: &mockPublicClient{}}
+
+	resp, err := client.BatchDeleteImage(context.Background(), &ecr.BatchDeleteImageInput{
+		RepositoryName: aws.String("gallery"),
+		ImageIds:       []types.ImageIdentifier{{ImageDigest: aws.String("sha256:ok")}, {ImageDigest: aws.String("sha256:locked")}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(resp.ImageIds) != 1 || len(resp.Failures) != 1 {
+		t.Fatalf("Expected one deletion and one failure, got %+v", resp)
+	}
+	failure := resp.Failures[0]
+	if aws.ToString(failure.ImageId.ImageDigest) != "sha256:locked" || string(failure.FailureCode) != "ImageReferencedByManifestList" {
+		t.Errorf("Expected the failure converted, got %+v", failure)
+	}
+}
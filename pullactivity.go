@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -pull-activity-days, which adapts each repository's
+// retention to how much it is pulled. The RepositoryPullCount metric ECR
+// publishes to CloudWatch is summed over the last -pull-activity-days:
+// repositories pulled at least -hot-pull-count times keep their images
+// -hot-days, and repositories nobody pulled keep them -cold-days. The
+// tags of -tag-policies still take precedence. CloudWatch is called over
+// its query API with the signed client of awsapi.go.
+
+const (
+	// pullMetricNamespace and pullMetricName identify the pull count ECR
+	// publishes for each repository
+	pullMetricNamespace = "AWS/ECR"
+	pullMetricName      = "RepositoryPullCount"
+
+	// maxMetricDataQueries is how many metrics one GetMetricData call reads
+	maxMetricDataQueries = 500
+
+	// maxPullActivityDays is how long CloudWatch keeps daily datapoints
+	maxPullActivityDays = 455
+
+	// defaultHotPullCount is the default of -hot-pull-count
+	defaultHotPullCount = 1000
+)
+
+// How much repositories are pulled
+const (
+	pullsHot  = "hot"
+	pullsCold = "cold"
+)
+
+// PullMetricsClient reads how many times repositories were pulled
+type PullMetricsClient interface {
+	// RepositoryPulls returns the pulls of each repository between start
+	// and end; repositories without a datapoint are left out
+	RepositoryPulls(ctx context.Context, repoNames []string, start, end time.Time) (map[string]float64, error)
+}
+
+// cloudWatchClient reads the pull counts from CloudWatch
+type cloudWatchClient struct {
+	api *signedAPIClient
+}
+
+// newCloudWatchClient creates a CloudWatch client for the AWS config's
+// region
+func newCloudWatchClient(awsConfig aws.Config) *cloudWatchClient {
+	return &cloudWatchClient{api: newSignedAPIClient(awsConfig, "monitoring")}
+}
+
+// getMetricDataResponse is the part of the GetMetricData response the tool
+// reads
+type getMetricDataResponse struct {
+	Results []struct {
+		ID     string    `xml:"Id"`
+		Values []float64 `xml:"Values>member"`
+	} `xml:"GetMetricDataResult>MetricDataResults>member"`
+	NextToken string `xml:"GetMetricDataResult>NextToken"`
+}
+
+// RepositoryPulls implements PullMetricsClient: the daily sums of the
+// metric are read maxMetricDataQueries repositories at a time
+func (c *cloudWatchClient) RepositoryPulls(ctx context.Context, repoNames []string, start, end time.Time) (map[string]float64, error) {
+	pulls := make(map[string]float64)
+	for i := 0; i < len(repoNames); i += maxMetricDataQueries {
+		batch := repoNames[i:min(i+maxMetricDataQueries, len(repoNames))]
+
+		form := url.Values{}
+		form.Set("Action", "GetMetricData")
+		form.Set("Version", "2010-08-01")
+		form.Set("StartTime", start.UTC().Format(time.RFC3339))
+		form.Set("EndTime", end.UTC().Format(time.RFC3339))
+		for j, repoName := range batch {
+			query := fmt.Sprintf("MetricDataQueries.member.%d.", j+1)
+			form.Set(query+"Id", "r"+strconv.Itoa(j))
+			form.Set(query+"MetricStat.Metric.Namespace", pullMetricNamespace)
+			form.Set(query+"MetricStat.Metric.MetricName", pullMetricName)
+			form.Set(query+"MetricStat.Metric.Dimensions.member.1.Name", "RepositoryName")
+			form.Set(query+"MetricStat.Metric.Dimensions.member.1.Value", repoName)
+			form.Set(query+"MetricStat.Period", strconv.Itoa(24*60*60))
+			form.Set(query+"MetricStat.Stat", "Sum")
+		}
+
+		// A partial result continues on the next page
+		for {
+			body, err := c.api.call(ctx, http.MethodPost, "/", map[string]string{
+				"Content-Type": "application/x-www-form-urlencoded; charset=utf-8",
+			}, []byte(form.Encode()))
+			if err != nil {
+				return nil, fmt.Errorf("CloudWatch GetMetricData: %w", err)
+			}
+
+			var resp getMetricDataResponse
+			if err := xml.Unmarshal(body, &resp); err != nil {
+				return nil, fmt.Errorf("invalid GetMetricData response: %w", err)
+			}
+			for _, result := range resp.Results {
+				index, _ := strings.CutPrefix(result.ID, "r")
+				j, err := strconv.Atoi(index)
+				if err != nil || j < 0 || j >= len(batch) {
+					continue
+				}
+				for _, value := range result.Values {
+					pulls[batch[j]] += value
+				}
+			}
+
+			if resp.NextToken == "" {
+				break
+			}
+			form.Set("NextToken", resp.NextToken)
+		}
+	}
+	return pulls, nil
+}
+
+// pullActivity holds the pulls of the repositories of the region being
+// processed. A nil pullActivity changes no retention.
+type pullActivity struct {
+	pulls     map[string]float64
+	hotPulls  int
+	hotDays   int
+	coldDays  int
+	sinceDays int
+}
+
+// loadPullActivity reads the pulls of every repository over the last
+// -pull-activity-days
+func loadPullActivity(ctx context.Context, client PullMetricsClient, repos []types.Repository, cfg Config) (*pullActivity, error) {
+	if client == nil {
+		return nil, fmt.Errorf("reading pull metrics isn't supported here")
+	}
+
+	repoNames := make([]string, len(repos))
+	for i, repo := range repos {
+		repoNames[i] = aws.ToString(repo.RepositoryName)
+	}
+	end := cfg.now()
+	pulls, err := client.RepositoryPulls(ctx, repoNames, end.AddDate(0, 0, -cfg.PullActivityDays), end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pull activity of repositories: %w", err)
+	}
+
+	// CloudWatch publishes no datapoint for a repository nobody pulled, but
+	// no datapoint at all means the metrics aren't there to read, e.g. they
+	// are published in another account: every repository would look cold
+	if len(pulls) == 0 {
+		slog.Warn("No pull activity found for any repository; keeping the retention of every repository", "repositories", len(repoNames), "days", cfg.PullActivityDays)
+		return nil, nil
+	}
+
+	activity := &pullActivity{pulls: pulls, hotPulls: cfg.HotPullCount, hotDays: cfg.HotDays, coldDays: cfg.ColdDays, sinceDays: cfg.PullActivityDays}
+	hot, cold := 0, 0
+	for _, repoName := range repoNames {
+		switch activity.temperature(repoName) {
+		case pullsHot:
+			hot++
+		case pullsCold:
+			cold++
+		}
+	}
+	slog.Info("Read pull activity", "repositories", len(repoNames), "hot", hot, "cold", cold, "days", cfg.PullActivityDays)
+	return activity, nil
+}
+
+// temperature returns whether a repository is hot, cold (never pulled
+// within the window), or neither
+func (p *pullActivity) temperature(repoName string) string {
+	pulls := p.pulls[repoName]
+	switch {
+	case pulls == 0:
+		return pullsCold
+	case pulls >= float64(p.hotPulls):
+		return pullsHot
+	}
+	return ""
+}
+
+// retention applies a repository's pull activity to its retention
+func (p *pullActivity) retention(repoName string, retention Config) Config {
+	if p == nil {
+		return retention
+	}
+
+	days := 0
+	switch p.temperature(repoName) {
+	case pullsHot:
+		days = p.hotDays
+	case pullsCold:
+		days = p.coldDays
+	}
+	if days == 0 {
+		return retention
+	}
+	slog.Info("Using the retention of the repository's pull activity", "repository", repoName, "pulls", p.pulls[repoName], "pull_activity_days", p.sinceDays, "days", days)
+	return retention.withDays(days)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// pullMetricsClient returns fixed pull counts
+type pullMetricsClient struct {
+	pulls      map[string]float64
+	err        error
+	repoNames  []string
+	start, end time.Time
+}
+
+func (m *pullMetricsClient) RepositoryPulls(ctx context.Context, repoNames []string, start, end time.Time) (map[string]float64, error) {
+	m.repoNames, m.start, m.end = repoNames, start, end
+	return m.pulls, m.err
+}
+
+// TestCloudWatchRepositoryPulls tests summing the daily pull counts of
+// each repository across the pages of GetMetricData
+func TestCloudWatchRepositoryPulls(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Invalid body: %v", err)
+		}
+		if r.PostForm.Get("Action") != "GetMetricData" || r.PostForm.Get("MetricDataQueries.member.2.MetricStat.Metric.Dimensions.member.1.Value") != "web" ||
+			r.PostForm.Get("MetricDataQueries.member.1.MetricStat.Metric.MetricName") != pullMetricName || r.PostForm.Get("StartTime") != "2024-05-02T00:00:00Z" {
+			t.Errorf("Unexpected request %v", r.PostForm)
+		}
+
+		// The first page is partial
+		if r.PostForm.Get("NextToken") == "" {
+			fmt.Fprint(w, `<GetMetricDataResponse><GetMetricDataResult><MetricDataResults>
+<member><Id>r0</Id><Values><member>10</member><member>5</member></Values><StatusCode>PartialData</StatusCode></member>
+</MetricDataResults><NextToken>page2</NextToken></GetMetricDataResult></GetMetricDataResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<GetMetricDataResponse><GetMetricDataResult><MetricDataResults>
+<member><Id>r0</Id><Values><member>2</member></Values><StatusCode>Complete</StatusCode></member>
+<member><Id>r1</Id><Values></Values><StatusCode>Complete</StatusCode></member>
+</MetricDataResults></GetMetricDataResult></GetMetricDataResponse>`)
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+	end := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	pulls, err := newCloudWatchClient(awsConfig).RepositoryPulls(context.Background(), []string{"api", "web"}, end.AddDate(0, 0, -30), end)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls != 2 || pulls["api"] != 17 || pulls["web"] != 0 {
+		t.Errorf("Expected 17 pulls of api over two pages, got %v in %d calls", pulls, calls)
+	}
+
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>`, http.StatusForbidden)
+	}))
+	defer denied.Close()
+	awsConfig.BaseEndpoint = aws.String(denied.URL)
+	if _, err := newCloudWatchClient(awsConfig).RepositoryPulls(context.Background(), []string{"api"}, end.AddDate(0, 0, -30), end); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+}
+
+// TestPullActivityRetention tests relaxing the retention of hot
+// repositories and tightening that of repositories nobody pulled
+func TestPullActivityRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	client := &pullMetricsClient{pulls: map[string]float64{"repo0": 5000, "repo1": 20}}
+	cfg := Config{Days: 30, PullActivityDays: 14, HotPullCount: 1000, HotDays: 90, ColdDays: 7, Clock: FixedClock(now)}
+	activity, err := loadPullActivity(context.Background(), client, newGuardrailClient(3, 0).DescribeRepositoriesOutput.Repositories, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(client.repoNames) != 3 || !client.start.Equal(now.AddDate(0, 0, -14)) || !client.end.Equal(now) {
+		t.Errorf("Expected the pulls of the last 14 days read for every repository, got %v from %v to %v", client.repoNames, client.start, client.end)
+	}
+
+	for repoName, days := range map[string]int{"repo0": 90, "repo1": 30, "repo2": 7} {
+		if got := activity.retention(repoName, cfg).Days; got != days {
+			t.Errorf("Expected %s to keep images %d days, got %d", repoName, days, got)
+		}
+	}
+
+	// Without -cold-days the repositories nobody pulled keep the retention
+	activity.coldDays = 0
+	if got := activity.retention("repo2", cfg).Days; got != 30 {
+		t.Errorf("Expected the global retention without -cold-days, got %d", got)
+	}
+	var none *pullActivity
+	if got := none.retention("repo0", cfg).Days; got != 30 {
+		t.Errorf("Expected the global retention without -pull-activity-days, got %d", got)
+	}
+}
+
+// TestPullActivityWithoutDatapoints tests that a registry without any pull
+// metric keeps its retention rather than looking cold
+func TestPullActivityWithoutDatapoints(t *testing.T) {
+	cfg := Config{Days: 30, PullActivityDays: 14, HotPullCount: 1000, ColdDays: 7}
+	activity, err := loadPullActivity(context.Background(), &pullMetricsClient{pulls: map[string]float64{}}, newGuardrailClient(2, 0).DescribeRepositoriesOutput.Repositories, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := activity.retention("repo0", cfg).Days; got != 30 {
+		t.Errorf("Expected the global retention without datapoints, got %d", got)
+	}
+}
+
+// TestCleanupWithPullActivity tests that the cleanup applies the pull
+// activity, and fails rather than ignore it
+func TestCleanupWithPullActivity(t *testing.T) {
+	// Images are 30 to 34 days old
+	cfg := Config{Days: 10, PullActivityDays: 30, HotPullCount: 100, HotDays: 365, pullMetrics: &pullMetricsClient{pulls: map[string]float64{"repo0": 500, "repo1": 1}}}
+	summary, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(2, 5))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	deleted := make(map[string]int)
+	for _, repo := range summary.Repositories {
+		deleted[repo.Name] = repo.ImagesDeleted
+	}
+	if deleted["repo0"] != 0 || deleted["repo1"] != 5 {
+		t.Errorf("Expected only the hot repository's images kept, got %v", deleted)
+	}
+
+	cfg.pullMetrics = &pullMetricsClient{err: errors.New("access denied")}
+	if _, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(2, 5)); err == nil {
+		t.Error("Expected an error when the pull activity can't be read")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the handling of pull-through cache repositories. ECR
+// creates them under the prefix of a pull-through cache rule, and whatever
+// they hold can be pulled again from the upstream registry, so they can get
+// their own, usually shorter, retention or be skipped altogether.
+
+// rootPullThroughPrefix is the prefix of a rule whose repositories are
+// created without one, so they can't be told apart by name
+const rootPullThroughPrefix = "ROOT"
+
+// PullThroughCacheClient defines the ECR operations needed to find
+// pull-through cache repositories
+type PullThroughCacheClient interface {
+	DescribePullThroughCacheRules(ctx context.Context, params *ecr.DescribePullThroughCacheRulesInput, optFns ...func(*ecr.Options)) (*ecr.DescribePullThroughCacheRulesOutput, error)
+}
+
+// pullThroughCache holds the repository prefixes of the registry's
+// pull-through cache rules
+type pullThroughCache struct {
+	prefixes []string
+}
+
+// handlesPullThrough reports whether pull-through cache repositories are
+// treated differently from the others
+func handlesPullThrough(cfg Config) bool {
+	return cfg.SkipPullThrough || cfg.PullThroughDays > 0 || cfg.PullThroughMaxImages > 0
+}
+
+// listPullThroughCache lists the repository prefixes of the registry's
+// pull-through cache rules
+func listPullThroughCache(ctx context.Context, client PullThroughCacheClient) (*pullThroughCache, error) {
+	cache := &pullThroughCache{}
+	var nextToken *string
+
+	for {
+		resp, err := client.DescribePullThroughCacheRules(ctx, &ecr.DescribePullThroughCacheRulesInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range resp.PullThroughCacheRules {
+			prefix := aws.ToString(rule.EcrRepositoryPrefix)
+			if prefix == rootPullThroughPrefix {
+				slog.Warn("Pull-through cache rule without a repository prefix; its repositories get the regular retention", "upstream", aws.ToString(rule.UpstreamRegistryUrl))
+				continue
+			}
+			if prefix != "" {
+				cache.prefixes = append(cache.prefixes, prefix+"/")
+			}
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return cache, nil
+}
+
+// contains reports whether a repository was created by a pull-through cache rule
+func (p *pullThroughCache) contains(repoName string) bool {
+	if p == nil {
+		return false
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(repoName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutPullThrough returns the repositories that weren't created by a
+// pull-through cache rule
+func (p *pullThroughCache) withoutPullThrough(repos []types.Repository) []types.Repository {
+	var remaining []types.Repository
+	for _, repo := range repos {
+		if p.contains(aws.ToString(repo.RepositoryName)) {
+			slog.Info("Skipping pull-through cache repository", "repository", aws.ToString(repo.RepositoryName))
+			continue
+		}
+		remaining = append(remaining, repo)
+	}
+	return remaining
+}
+
+// pullThroughRetention returns the config that selects the images of a
+// pull-through cache repository: -pull-through-days and
+// -pull-through-max-images replace -days and -max-images when set
+func pullThroughRetention(cfg Config) Config {
+	if cfg.PullThroughDays > 0 {
+		cfg = cfg.withDays(cfg.PullThroughDays)
+	}
+	if cfg.PullThroughMaxImages > 0 {
+		cfg.MaxImages = cfg.PullThroughMaxImages
+	}
+	return cfg
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// mockPullThroughClient returns pull-through cache rules a page at a time
+type mockPullThroughClient struct {
+	pages [][]types.PullThroughCacheRule
+	err   error
+}
+
+func (m *mockPullThroughClient) DescribePullThroughCacheRules(ctx context.Context, params *ecr.DescribePullThroughCacheRulesInput, optFns ...func(*ecr.Options)) (*ecr.DescribePullThroughCacheRulesOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	page := 0
+	if params.NextToken != nil {
+		page = 1
+	}
+	out := &ecr.DescribePullThroughCacheRulesOutput{PullThroughCacheRules: m.pages[page]}
+	if page+1 < len(m.pages) {
+		out.NextToken = aws.String("next")
+	}
+	return out, nil
+}
+
+// TestListPullThroughCache tests finding the prefixes of pull-through cache repositories
+func TestListPullThroughCache(t *testing.T) {
+	client := &mockPullThroughClient{pages: [][]types.PullThroughCacheRule{
+		{{EcrRepositoryPrefix: aws.String("docker-hub")}, {EcrRepositoryPrefix: aws.String("ROOT")}},
+		{{EcrRepositoryPrefix: aws.String("quay")}},
+	}}
+
+	cache, err := listPullThroughCache(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := map[string]bool{
+		"docker-hub/library/nginx": true,
+		"quay/coreos/etcd":         true,
+		"docker-hub-mirror/nginx":  false,
+		"team/api":                 false,
+	}
+	for repo, expected := range tests {
+		if got := cache.contains(repo); got != expected {
+			t.Errorf("Expected contains(%q) to be %v, got %v", repo, expected, got)
+		}
+	}
+
+	if _, err := listPullThroughCache(context.Background(), &mockPullThroughClient{err: errors.New("access denied")}); err == nil {
+		t.Error("Expected an error when the rules can't be listed")
+	}
+}
+
+// TestCleanupWithClientPullThrough tests the retention of pull-through cache repositories
+func TestCleanupWithClientPullThrough(t *testing.T) {
+	now := time.Now()
+	client := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{
+			{RepositoryName: aws.String("docker-hub/library/nginx")},
+			{RepositoryName: aws.String("team/api")},
+		}},
+		ListImagesOutput: &ecr.ListImagesOutput{ImageIds: []types.ImageIdentifier{{ImageDigest: aws.String("sha256:a")}, {ImageDigest: aws.String("sha256:b")}}},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{ImageDetails: []types.ImageDetail{
+			{ImageDigest: aws.String("sha256:a"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -1))},
+			{ImageDigest: aws.String("sha256:b"), ImagePushedAt: aws.Time(now.AddDate(0, 0, -5))},
+		}},
+		BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{},
+	}
+	cache := &pullThroughCache{prefixes: []string{"docker-hub/"}}
+
+	t.Run("Dedicated retention", func(t *testing.T) {
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 30, PullThroughDays: 3, pullThrough: cache}, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for _, repo := range summary.Repositories {
+			expected := 0
+			if repo.Name == "docker-hub/library/nginx" {
+				expected = 1
+			}
+			if repo.ImagesDeleted != expected {
+				t.Errorf("Expected %d images deleted from %s, got %d", expected, repo.Name, repo.ImagesDeleted)
+			}
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		summary, err := CleanupWithClient(context.Background(), Config{Days: 3, SkipPullThrough: true, pullThrough: cache}, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(summary.Repositories) != 1 || summary.Repositories[0].Name != "team/api" || summary.ImagesDeleted != 1 {
+			t.Errorf("Expected only team/api cleaned up, got %+v", summary.Repositories)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+)
+
+// This file contains -pushed-after and -pushed-before, which replace the age
+// limit with an explicit range of push times. Only images pushed within the
+// range are deleted, which purges a window of bad builds, such as the
+// images a compromised CI runner produced between two dates, and leaves
+// everything pushed before or after it alone. Either bound may be left
+// out: without -pushed-before the range runs until now. Repository
+// overrides of -days replace the range like they replace -before.
+
+// pushedRange reports whether -pushed-after or -pushed-before is set
+func (cfg Config) pushedRange() bool {
+	return !cfg.PushedAfter.IsZero() || !cfg.PushedBefore.IsZero()
+}
+
+// agedOut reports whether an image pushed at the given time is old enough
+// to be deleted under the cutoff, and not older than -pushed-after
+func (cfg Config) agedOut(pushed *time.Time, cutoff time.Time) bool {
+	return pushed != nil && pushed.Before(cutoff) && pushed.After(cfg.PushedAfter)
+}
+
+// pushedRangeLimit describes the range, e.g. "pushed between
+// 2024-06-01T00:00:00Z and 2024-06-03T00:00:00Z"
+func (cfg Config) pushedRangeLimit() string {
+	switch {
+	case cfg.PushedBefore.IsZero():
+		return "pushed after " + cfg.PushedAfter.Format(time.RFC3339)
+	case cfg.PushedAfter.IsZero():
+		return "pushed before " + cfg.PushedBefore.Format(time.RFC3339)
+	default:
+		return "pushed between " + cfg.PushedAfter.Format(time.RFC3339) + " and " + cfg.PushedBefore.Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -quarantine-days, a two-phase deletion that gives the
+// teams using a registry time to object. An image the policy selects isn't
+// deleted right away: it gets a quarantine-<date>-<digest> tag instead, and
+// only a later run that selects it again once it has been quarantined for
+// the given number of days deletes it. An image is rescued by pinning it
+// with one of -protect-tags or the keep-list, or by anything else that
+// keeps the policy from selecting it, before then; the next run removes its
+// quarantine tag, so it starts over if it is ever selected again.
+//
+// Quarantining is decided when images are selected and carried out along
+// with the deletions, once the deletion limits have accepted the
+// repository. Plans record the images to quarantine and release, so
+// applying a plan, or confirming a run, tags them too.
+
+// quarantineTagPrefix starts the tag that records when an image was
+// quarantined
+const quarantineTagPrefix = "quarantine-"
+
+// quarantineDateLayout is the date format of quarantine tags
+const quarantineDateLayout = "20060102"
+
+// quarantineTag returns the tag that quarantines an image on the given day.
+// Tags are unique within a repository, so each image gets its own.
+func quarantineTag(now time.Time, digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	return quarantineTagPrefix + now.UTC().Format(quarantineDateLayout) + "-" + hex[:min(12, len(hex))]
+}
+
+// quarantineTags returns an image's quarantine tags
+func quarantineTags(img types.ImageDetail) []string {
+	var tags []string
+	for _, tag := range img.ImageTags {
+		if strings.HasPrefix(tag, quarantineTagPrefix) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// quarantinedAt returns the day an image was first quarantined, if it was
+func quarantinedAt(img types.ImageDetail) (time.Time, bool) {
+	var earliest time.Time
+	for _, tag := range quarantineTags(img) {
+		date, _, _ := strings.Cut(strings.TrimPrefix(tag, quarantineTagPrefix), "-")
+		day, err := time.Parse(quarantineDateLayout, date)
+		if err == nil && (earliest.IsZero() || day.Before(earliest)) {
+			earliest = day
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// quarantineChanges are the quarantine tags a repository's cleanup adds
+// and removes
+type quarantineChanges struct {
+	// add are the selected images that weren't quarantined yet
+	add []types.ImageDetail
+	// release are the quarantined images the policy no longer selects
+	release []types.ImageDetail
+}
+
+// quarantineImages returns the selected images that have been quarantined
+// for -quarantine-days and can be deleted, along with the images to
+// quarantine and those quarantined no longer selected. It tags nothing.
+func quarantineImages(repoName string, selected, quarantined []types.ImageDetail, cfg Config, now time.Time) ([]types.ImageDetail, quarantineChanges) {
+	var due []types.ImageDetail
+	var changes quarantineChanges
+	isSelected := make(map[string]bool, len(selected))
+	for _, img := range selected {
+		isSelected[aws.ToString(img.ImageDigest)] = true
+		day, ok := quarantinedAt(img)
+		switch {
+		case !ok:
+			changes.add = append(changes.add, img)
+		case !now.Before(day.AddDate(0, 0, cfg.QuarantineDays)):
+			due = append(due, img)
+		default:
+			slog.Info("Keeping quarantined image", "action", "keep", "repository", repoName, "digest", aws.ToString(img.ImageDigest),
+				"quarantined_at", day.Format(time.DateOnly), "until", day.AddDate(0, 0, cfg.QuarantineDays).Format(time.DateOnly))
+		}
+	}
+	for _, img := range quarantined {
+		if !isSelected[aws.ToString(img.ImageDigest)] {
+			changes.release = append(changes.release, img)
+		}
+	}
+	return due, changes
+}
+
+// applyQuarantine tags the images to quarantine and removes the quarantine
+// tags of the released ones, or only logs them in dry run mode. Removing an
+// image's last tag would delete it, so an image without other tags keeps
+// its quarantine tag.
+func applyQuarantine(ctx context.Context, client ECRClient, repoName string, changes quarantineChanges, cfg Config) error {
+	now := cfg.now()
+	if cfg.DryRun {
+		for _, img := range changes.add {
+			digest := aws.ToString(img.ImageDigest)
+			slog.Info("[DRY RUN] Would quarantine image", "action", "would-quarantine", "repository", repoName, "digest", digest, "tag", quarantineTag(now, digest))
+		}
+		for _, img := range changes.release {
+			slog.Info("[DRY RUN] Would release image from quarantine", "action", "would-release", "repository", repoName, "digest", aws.ToString(img.ImageDigest), "tags", quarantineTags(img))
+		}
+		return nil
+	}
+
+	if len(changes.add) > 0 {
+		ids := make([]types.ImageIdentifier, len(changes.add))
+		for i, img := range changes.add {
+			ids[i] = types.ImageIdentifier{ImageDigest: img.ImageDigest}
+		}
+		if err := tagImages(ctx, cfg.tags, repoName, ids, func(digest string) string { return quarantineTag(now, digest) }); err != nil {
+			return fmt.Errorf("failed to quarantine images: %w", err)
+		}
+		slog.Info("Quarantined images", "action", "quarantine", "repository", repoName, "images", len(ids),
+			"until", now.UTC().AddDate(0, 0, cfg.QuarantineDays).Format(time.DateOnly))
+	}
+
+	var ids []types.ImageIdentifier
+	for _, img := range changes.release {
+		tags := quarantineTags(img)
+		if len(tags) == len(img.ImageTags) {
+			slog.Warn("Keeping the quarantine tag of an image without other tags", "repository", repoName, "digest", aws.ToString(img.ImageDigest), "tags", tags)
+			continue
+		}
+		for _, tag := range tags {
+			ids = append(ids, types.ImageIdentifier{ImageTag: aws.String(tag)})
+		}
+	}
+	for i := 0; i < len(ids); i += batchDeleteSize {
+		batch := ids[i:min(i+batchDeleteSize, len(ids))]
+		result, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+			RepositoryName: aws.String(repoName),
+			ImageIds:       batch,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to release images from quarantine: %w", err)
+		}
+		for _, failure := range result.Failures {
+			slog.Error("Failed to remove quarantine tag", "action", "release", "repository", repoName, "tag", getImageIdString(failure.ImageId), "reason", aws.ToString(failure.FailureReason))
+		}
+		slog.Info("Released images from quarantine", "action", "release", "repository", repoName, "tags", len(batch)-len(result.Failures))
+	}
+	return nil
+}
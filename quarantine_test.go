@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestQuarantinedAt tests reading the quarantine day from an image's tags
+func TestQuarantinedAt(t *testing.T) {
+	img := types.ImageDetail{ImageTags: []string{"v1", "quarantine-20250520-aaaaaaaaaaaa", "quarantine-20250510", "quarantine-soon"}}
+	if day, ok := quarantinedAt(img); !ok || !day.Equal(time.Date(2025, 5, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the earliest quarantine day, got %v, %v", day, ok)
+	}
+	if _, ok := quarantinedAt(types.ImageDetail{ImageTags: []string{"v1"}}); ok {
+		t.Error("Expected an image without a quarantine tag not to be quarantined")
+	}
+	if got := quarantineTag(time.Date(2025, 5, 17, 23, 0, 0, 0, time.UTC), testDigest("a")); got != "quarantine-20250517-aaaaaaaaaaaa" {
+		t.Errorf("Expected a tag of the day and digest, got %s", got)
+	}
+}
+
+// TestQuarantineImages tests deciding which images to quarantine, delete
+// and release
+func TestQuarantineImages(t *testing.T) {
+	now := time.Date(2025, 5, 17, 3, 0, 0, 0, time.UTC)
+	selected := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:new")},
+		{ImageDigest: aws.String("sha256:waiting"), ImageTags: []string{"quarantine-20250511"}},
+		{ImageDigest: aws.String("sha256:due"), ImageTags: []string{"v1", "quarantine-20250510-000000000000"}},
+	}
+	quarantined := []types.ImageDetail{
+		selected[1],
+		selected[2],
+		{ImageDigest: aws.String("sha256:rescued"), ImageTags: []string{"v2", "quarantine-20250512-111111111111"}},
+	}
+
+	due, changes := quarantineImages("repo", selected, quarantined, Config{QuarantineDays: 7}, now)
+	if len(due) != 1 || aws.ToString(due[0].ImageDigest) != "sha256:due" {
+		t.Errorf("Expected only the image quarantined 7 days ago to be due, got %v", due)
+	}
+	if len(changes.add) != 1 || aws.ToString(changes.add[0].ImageDigest) != "sha256:new" {
+		t.Errorf("Expected the new image to be quarantined, got %v", changes.add)
+	}
+	if len(changes.release) != 1 || aws.ToString(changes.release[0].ImageDigest) != "sha256:rescued" {
+		t.Errorf("Expected the image no longer selected to be released, got %v", changes.release)
+	}
+}
+
+// TestApplyQuarantine tests tagging each image with its own quarantine tag
+// and removing the tags of released images
+func TestApplyQuarantine(t *testing.T) {
+	now := time.Date(2025, 5, 17, 3, 0, 0, 0, time.UTC)
+	var changes quarantineChanges
+	for i := 0; i < 150; i++ {
+		changes.add = append(changes.add, types.ImageDetail{ImageDigest: aws.String(fmt.Sprintf("sha256:%012d", i))})
+	}
+	changes.release = []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:rescued"), ImageTags: []string{"v2", "quarantine-20250512-111111111111"}},
+		// Removing its only tag would delete it
+		{ImageDigest: aws.String("sha256:untagged"), ImageTags: []string{"quarantine-20250512-222222222222"}},
+	}
+	tags := &mockTagClient{}
+	client := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{}}
+	cfg := Config{QuarantineDays: 7, tags: tags, Clock: FixedClock(now)}
+
+	if err := applyQuarantine(context.Background(), client, "repo", changes, cfg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(tags.gets) != 2 || tags.gets[0] != 100 || tags.gets[1] != 50 {
+		t.Errorf("Expected the manifests read 100 images at a time, got %v", tags.gets)
+	}
+	if len(tags.puts) != 150 || aws.ToString(tags.puts[1].ImageTag) != "quarantine-20250517-000000000001" {
+		t.Errorf("Expected each image tagged with its own quarantine tag, got %d tags", len(tags.puts))
+	}
+	if client.BatchDeleteImageCalls != 1 || len(client.LastBatchDeleteImageInput.ImageIds) != 1 ||
+		aws.ToString(client.LastBatchDeleteImageInput.ImageIds[0].ImageTag) != "quarantine-20250512-111111111111" {
+		t.Errorf("Expected only the quarantine tag of the tagged image removed, got %d calls", client.BatchDeleteImageCalls)
+	}
+
+	// Dry runs change nothing
+	tags, client = &mockTagClient{}, &MockECRClient{}
+	cfg.DryRun, cfg.tags = true, tags
+	if err := applyQuarantine(context.Background(), client, "repo", changes, cfg); err != nil || len(tags.puts) != 0 || client.BatchDeleteImageCalls != 0 {
+		t.Errorf("Expected a dry run to change nothing, got %v, %d tagged and %d deletions", err, len(tags.puts), client.BatchDeleteImageCalls)
+	}
+}
+
+// TestCleanupQuarantineLimits tests that the deletion limits refuse a
+// repository before any of its images is quarantined
+func TestCleanupQuarantineLimits(t *testing.T) {
+	tags := &mockTagClient{}
+	cfg := Config{Days: 10, QuarantineDays: 7, MaxDeletions: 2, SkipListImages: true, tags: tags}
+	if _, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(1, 5)); err == nil {
+		t.Error("Expected the deletion limit to refuse the repository")
+	}
+	if len(tags.puts) != 0 {
+		t.Errorf("Expected no image quarantined past the limit, got %d", len(tags.puts))
+	}
+
+	cfg.MaxDeletions = 0
+	client := newGuardrailClient(1, 5)
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(tags.puts) != 5 || client.BatchDeleteImageCalls != 0 || len(summary.Repositories) != 1 || len(summary.Repositories[0].Quarantined) != 5 {
+		t.Errorf("Expected the 5 images quarantined and none deleted, got %d tagged and %d deletions", len(tags.puts), client.BatchDeleteImageCalls)
+	}
+}
+
+// TestQuarantinePlan tests that a plan, which is also what a confirmation
+// applies, records the images to quarantine and that applying it tags them
+func TestQuarantinePlan(t *testing.T) {
+	cfg := Config{Days: 10, QuarantineDays: 7, SkipListImages: true, DryRun: true, tags: &mockTagClient{}}
+	summary, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(1, 3))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	plan := newPlan(summary, time.Now())
+	if plan.Images != 0 || plan.quarantineImages() != 3 || len(plan.Repositories) != 1 || len(plan.Repositories[0].Quarantine) != 3 {
+		t.Fatalf("Expected a plan quarantining 3 images, got %+v", plan)
+	}
+
+	tags := &mockTagClient{}
+	client := newGuardrailClient(1, 3)
+	if _, err := CleanupWithClient(context.Background(), Config{SkipListImages: true, plan: plan, tags: tags}, client); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(tags.puts) != 3 || !strings.HasPrefix(aws.ToString(tags.puts[0].ImageTag), quarantineTagPrefix) || client.BatchDeleteImageCalls != 0 {
+		t.Errorf("Expected applying the plan to quarantine its 3 images, got %d tagged and %d deletions", len(tags.puts), client.BatchDeleteImageCalls)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/account"
+	accounttypes "github.com/aws/aws-sdk-go-v2/service/account/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// This file contains the multi-region support. A run can target a list of
+// regions, or every region enabled for the account, and each region gets its
+// own ECR client and summary.
+
+// AccountClient defines the AWS Account operations needed to discover regions
+type AccountClient interface {
+	ListRegions(ctx context.Context, params *account.ListRegionsInput, optFns ...func(*account.Options)) (*account.ListRegionsOutput, error)
+}
+
+// RegionSummary holds the cleanup results for a single region
+type RegionSummary struct {
+	Region string
+	CleanupSummary
+}
+
+// parseRegionList splits a comma-separated list of regions, ignoring blanks
+func parseRegionList(value string) []string {
+	var regions []string
+	for _, region := range strings.Split(value, ",") {
+		region = strings.TrimSpace(region)
+		if region != "" {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// resolveRegions returns the regions to clean up, or nil for a single-region
+// run against the region from the AWS configuration
+func resolveRegions(ctx context.Context, awsConfig aws.Config, cfg Config) ([]string, error) {
+	if cfg.AllRegions {
+		return listEnabledRegions(ctx, account.NewFromConfig(awsConfig))
+	}
+	return cfg.Regions, nil
+}
+
+// listEnabledRegions lists every region enabled for the account
+func listEnabledRegions(ctx context.Context, client AccountClient) ([]string, error) {
+	var regions []string
+	var nextToken *string
+
+	for {
+		resp, err := client.ListRegions(ctx, &account.ListRegionsInput{
+			NextToken: nextToken,
+			RegionOptStatusContains: []accounttypes.RegionOptStatus{
+				accounttypes.RegionOptStatusEnabled,
+				accounttypes.RegionOptStatusEnabledByDefault,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, region := range resp.Regions {
+			if region.RegionName != nil {
+				regions = append(regions, *region.RegionName)
+			}
+		}
+
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// cleanupRegions runs the cleanup in each region and aggregates the results
+func cleanupRegions(ctx context.Context, awsConfig aws.Config, cfg Config, regions []string) (CleanupSummary, error) {
+	summary := CleanupSummary{}
+	var lastErr error
+
+	// Tell the replica repositories from their sources before any region
+	// is cleaned up
+	if cfg.Replication == replicationSourceOnly || cfg.Replication == replicationFanOut {
+		var err error
+		if cfg.runReplication, err = describeRunReplication(ctx, awsConfig, regions); err != nil {
+			return summary, err
+		}
+	}
+
+	for _, region := range regions {
+		regionConfig := awsConfig.Copy()
+		regionConfig.Region = region
+
+		slog.Info("Cleaning up region", "region", region)
+		regionSummary, err := cleanupRegion(ctx, regionConfig, cfg)
+		if err != nil && !errors.Is(err, errRunStopped) {
+			if cfg.FailOnError {
+				return summary, fmt.Errorf("region %s: %w", region, err)
+			}
+			slog.Error("Error cleaning up region", "region", region, "error", err)
+			summary.Failures = append(summary.Failures, fmt.Sprintf("region %s: %v", region, err))
+			lastErr = err
+			continue
+		}
+
+		summary.add(regionSummary)
+		summary.Regions = append(summary.Regions, RegionSummary{
+			Region:         region,
+			CleanupSummary: regionSummary,
+		})
+
+		// A stopped run reports what it deleted and goes no further
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	// Only fail the run when no region could be cleaned up at all
+	if len(summary.Regions) == 0 && lastErr != nil {
+		return summary, fmt.Errorf("all regions failed: %w", lastErr)
+	}
+
+	return summary, nil
+}
+
+// cleanupRegion runs the cleanup against the region in the given AWS config
+func cleanupRegion(ctx context.Context, awsConfig aws.Config, cfg Config) (CleanupSummary, error) {
+	// ECR Public only has an endpoint in us-east-1, and no ListImages
+	if cfg.Public {
+		awsConfig = awsConfig.Copy()
+		awsConfig.Region = publicRegion
+		cfg.SkipListImages = true
+	}
+
+	// Fail on missing permissions before touching the registry
+	if cfg.Preflight {
+		if err := preflight(ctx, newECRClient(awsConfig, cfg), cfg); err != nil {
+			return CleanupSummary{}, err
+		}
+	}
+
+	// Leave the registry to a run that is already cleaning it up
+	release, err := cfg.locks.acquire(ctx, cfg.accountID, awsConfig.Region)
+	if err != nil {
+		return CleanupSummary{}, err
+	}
+	defer release()
+
+	// Hand the retention over to ECR instead of deleting images
+	if cfg.ManageLifecyclePolicies {
+		ecrClient := ecr.NewFromConfig(awsConfig)
+		summary, err := manageLifecyclePolicies(ctx, newThrottledClient(ecrClient, cfg), ecrClient, cfg)
+		for i := range summary.Repositories {
+			summary.Repositories[i].Region = awsConfig.Region
+		}
+		return summary, err
+	}
+
+	// Find the replicas the cleanup would drift from
+	if cfg.Replication != replicationIgnore && !cfg.Public {
+		if cfg.replication, err = newReplicationState(ctx, awsConfig, cfg); err != nil {
+			return CleanupSummary{}, err
+		}
+	}
+
+	// Collect images referenced by running workloads in this region
+	inUse, err := collectInUseImages(ctx, awsConfig, cfg.inUseProviders, true)
+	if err != nil {
+		return CleanupSummary{}, err
+	}
+	inUse.merge(cfg.sharedInUse)
+	if inUse.size() > 0 {
+		slog.Info("Protecting in-use image references", "images", inUse.size())
+	}
+	cfg.inUse = inUse
+	cfg.region = awsConfig.Region
+
+	// Read manifests from this region's registry
+	if cfg.manifests != nil {
+		cfg.manifests = cfg.manifests.forRegion(ecr.NewFromConfig(awsConfig), awsConfig.Region)
+	}
+	if !cfg.Public {
+		regionClient := ecr.NewFromConfig(awsConfig)
+		cfg.artifactManifests = regionClient
+		if cfg.HonorExpiryLabels {
+			cfg.expiry = newExpiryReader(regionClient, awsConfig.HTTPClient, cfg.ExpiryLabel)
+		}
+		cfg.repoDeleter = regionClient
+		cfg.repoTags = regionClient
+		if cfg.PullActivityDays > 0 {
+			cfg.pullMetrics = newCloudWatchClient(awsConfig)
+		}
+		if cfg.UntagOnly || cfg.QuarantineDays > 0 || cfg.plan != nil {
+			cfg.tags = regionClient
+		}
+	}
+
+	// Log in to the archive before anything is deleted
+	if cfg.ArchiveTo != "" {
+		cfg.archive, err = newRegionArchiver(ctx, awsConfig, cfg.ArchiveTo)
+		if err != nil {
+			return CleanupSummary{}, fmt.Errorf("failed to set up the archive: %w", err)
+		}
+	}
+
+	// Find the pull-through cache repositories when they get their own retention
+	if handlesPullThrough(cfg) && !cfg.Public {
+		cfg.pullThrough, err = listPullThroughCache(ctx, ecr.NewFromConfig(awsConfig))
+		if err != nil {
+			return CleanupSummary{}, fmt.Errorf("failed to list pull-through cache rules: %w", err)
+		}
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, cfg), cfg)
+	summary, err := CleanupWithClient(ctx, cfg, client)
+
+	// Replicas that drifted fail the run, though their sources were cleaned up
+	summary.Failures = append(summary.Failures, cfg.replication.failures()...)
+	for i := range summary.Repositories {
+		summary.Repositories[i].Region = awsConfig.Region
+	}
+	return summary, err
+}
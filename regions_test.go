@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/account"
+	accounttypes "github.com/aws/aws-sdk-go-v2/service/account/types"
+)
+
+// MockAccountClient implements the AccountClient interface for testing
+type MockAccountClient struct {
+	Pages     []*account.ListRegionsOutput
+	Calls     int
+	LastInput *account.ListRegionsInput
+}
+
+// ListRegions mock implementation
+func (m *MockAccountClient) ListRegions(ctx context.Context, params *account.ListRegionsInput, optFns ...func(*account.Options)) (*account.ListRegionsOutput, error) {
+	m.LastInput = params
+	page := m.Pages[m.Calls]
+	m.Calls++
+	return page, nil
+}
+
+// TestParseRegionList tests parsing of the -regions flag value
+func TestParseRegionList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"Empty", "", nil},
+		{"Single region", "us-east-1", []string{"us-east-1"}},
+		{"Multiple regions", "us-east-1,eu-west-1", []string{"us-east-1", "eu-west-1"}},
+		{"Spaces and blanks", " us-east-1 , ,eu-west-1,", []string{"us-east-1", "eu-west-1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			regions := parseRegionList(tc.value)
+			if !reflect.DeepEqual(regions, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, regions)
+			}
+		})
+	}
+}
+
+// TestListEnabledRegions tests region discovery for -all-regions
+func TestListEnabledRegions(t *testing.T) {
+	mockClient := &MockAccountClient{
+		Pages: []*account.ListRegionsOutput{
+			{
+				Regions: []accounttypes.Region{
+					{RegionName: aws.String("us-west-2")},
+					{RegionName: aws.String("eu-west-1")},
+				},
+				NextToken: aws.String("page2"),
+			},
+			{
+				Regions: []accounttypes.Region{
+					{RegionName: aws.String("ap-southeast-2")},
+				},
+			},
+		},
+	}
+
+	regions, err := listEnabledRegions(context.Background(), mockClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"ap-southeast-2", "eu-west-1", "us-west-2"}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("Expected %v, got %v", expected, regions)
+	}
+	if mockClient.Calls != 2 {
+		t.Errorf("Expected 2 calls to ListRegions, got %d", mockClient.Calls)
+	}
+	if len(mockClient.LastInput.RegionOptStatusContains) != 2 {
+		t.Errorf("Expected only enabled regions to be requested")
+	}
+}
+
+// TestResolveRegions tests choosing between explicit and discovered regions
+func TestResolveRegions(t *testing.T) {
+	t.Run("Single region run", func(t *testing.T) {
+		regions, err := resolveRegions(context.Background(), aws.Config{}, Config{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if regions != nil {
+			t.Errorf("Expected no regions for a single-region run, got %v", regions)
+		}
+	})
+
+	t.Run("Explicit regions", func(t *testing.T) {
+		cfg := Config{Regions: []string{"us-east-1", "eu-west-1"}}
+		regions, err := resolveRegions(context.Background(), aws.Config{}, cfg)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(regions, cfg.Regions) {
+			t.Errorf("Expected %v, got %v", cfg.Regions, regions)
+		}
+	})
+}
+
+// TestCleanupSummaryAdd tests aggregating summaries across regions
+func TestCleanupSummaryAdd(t *testing.T) {
+	summary := CleanupSummary{RepositoriesProcessed: 1, ImagesDeleted: 2, SpaceFreed: 100}
+	summary.add(CleanupSummary{RepositoriesProcessed: 3, ImagesDeleted: 4, SpaceFreed: 200})
+
+	if summary.RepositoriesProcessed != 4 || summary.ImagesDeleted != 6 || summary.SpaceFreed != 300 {
+		t.Errorf("Unexpected aggregated summary: %+v", summary)
+	}
+}
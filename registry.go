@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// This file contains a minimal client of the OCI distribution API that ECR
+// registries serve, used to copy images between repositories: manifests
+// are copied byte for byte, so the images keep their digests, along with
+// the layers and config they reference.
+
+// Manifest media types the registry client copies
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept lists every manifest media type, so the registry returns
+// manifests as they were pushed
+var manifestAccept = strings.Join([]string{mediaTypeDockerManifest, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex}, ", ")
+
+// AuthorizationClient defines the ECR operation needed to log in to a registry
+type AuthorizationClient interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+// registryClient talks to one registry over the OCI distribution API
+type registryClient struct {
+	baseURL string
+	auth    string
+	http    aws.HTTPClient
+}
+
+// descriptor points at a manifest or blob
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// manifest holds the references of an image manifest or index
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *descriptor  `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// newRegistryClient logs in to the ECR registry of the client's region. The
+// HTTP client of the AWS config is used, so -proxy-url and -ca-bundle apply.
+func newRegistryClient(ctx context.Context, client AuthorizationClient, httpClient aws.HTTPClient) (*registryClient, error) {
+	resp, err := client.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registry authorization: %w", err)
+	}
+	if len(resp.AuthorizationData) == 0 {
+		return nil, errors.New("failed to get registry authorization: no authorization data")
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	data := resp.AuthorizationData[0]
+	return &registryClient{
+		baseURL: strings.TrimSuffix(aws.ToString(data.ProxyEndpoint), "/"),
+		auth:    "Basic " + aws.ToString(data.AuthorizationToken),
+		http:    httpClient,
+	}, nil
+}
+
+// host returns the registry host, as used in image references
+func (r *registryClient) host() string {
+	if u, err := url.Parse(r.baseURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return r.baseURL
+}
+
+// copyImage copies the image with the given digest from a repository of
+// src to a repository of this registry, along with the images of an index,
+// and tags the copy
+func (r *registryClient) copyImage(ctx context.Context, src *registryClient, srcRepo, digest, dstRepo string, tags []string) error {
+	body, mediaType, err := src.getManifest(ctx, srcRepo, digest)
+	if err != nil {
+		return err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", digest, err)
+	}
+
+	// An index references the images of each platform
+	for _, child := range m.Manifests {
+		if err := r.copyImage(ctx, src, srcRepo, child.Digest, dstRepo, nil); err != nil {
+			return err
+		}
+	}
+
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+	for _, blob := range blobs {
+		// Foreign layers are not stored in the registry
+		if strings.Contains(blob.MediaType, "foreign") {
+			continue
+		}
+		if err := r.copyBlob(ctx, src, srcRepo, blob, dstRepo); err != nil {
+			return err
+		}
+	}
+
+	if err := r.putManifest(ctx, dstRepo, digest, body, mediaType); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := r.putManifest(ctx, dstRepo, tag, body, mediaType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManifest returns a manifest as it was pushed, with its media type
+func (r *registryClient) getManifest(ctx context.Context, repo, reference string) ([]byte, string, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.url("/v2/%s/manifests/%s", repo, reference), nil, -1, map[string]string{"Accept": manifestAccept}, http.StatusOK)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// manifestDigest returns the digest of the manifest a tag or digest
+// points at, or "" when there is none
+func (r *registryClient) manifestDigest(ctx context.Context, repo, reference string) (string, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.url("/v2/%s/manifests/%s", repo, reference), nil, -1, map[string]string{"Accept": manifestAccept}, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// putManifest pushes a manifest under a digest or tag
+func (r *registryClient) putManifest(ctx context.Context, repo, reference string, body []byte, mediaType string) error {
+	resp, err := r.do(ctx, http.MethodPut, r.url("/v2/%s/manifests/%s", repo, reference), bytes.NewReader(body), int64(len(body)), map[string]string{"Content-Type": mediaType}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// copyBlob copies a blob into a repository of this registry unless it's
+// already there. Within one registry the blob is mounted rather than
+// downloaded and uploaded again, when the registry allows it.
+func (r *registryClient) copyBlob(ctx context.Context, src *registryClient, srcRepo string, blob descriptor, dstRepo string) error {
+	resp, err := r.do(ctx, http.MethodHead, r.url("/v2/%s/blobs/%s", dstRepo, blob.Digest), nil, -1, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	uploadURL := r.url("/v2/%s/blobs/uploads/", dstRepo)
+	if src.baseURL == r.baseURL {
+		uploadURL += "?" + url.Values{"mount": {blob.Digest}, "from": {srcRepo}}.Encode()
+	}
+	resp, err = r.do(ctx, http.MethodPost, uploadURL, nil, 0, nil, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	location, err := r.location(resp)
+	if err != nil {
+		return err
+	}
+
+	// Stream the blob from the source into the upload
+	download, err := src.do(ctx, http.MethodGet, src.url("/v2/%s/blobs/%s", srcRepo, blob.Digest), nil, -1, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer download.Body.Close()
+
+	resp, err = r.do(ctx, http.MethodPatch, location, download.Body, blob.Size, map[string]string{"Content-Type": "application/octet-stream"}, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if location, err = r.location(resp); err != nil {
+		return err
+	}
+
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	resp, err = r.do(ctx, http.MethodPut, location+separator+url.Values{"digest": {blob.Digest}}.Encode(), nil, 0, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// url returns the URL of a registry API path
+func (r *registryClient) url(format string, args ...any) string {
+	return r.baseURL + fmt.Sprintf(format, args...)
+}
+
+// location returns the absolute upload URL of a response
+func (r *registryClient) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("%s %s: no upload location", resp.Request.Method, resp.Request.URL.Path)
+	}
+	if strings.HasPrefix(location, "/") {
+		location = r.baseURL + location
+	}
+	return location, nil
+}
+
+// do sends an authenticated request and fails unless the response has one
+// of the expected statuses. size is the body length, or -1 when unknown.
+func (r *registryClient) do(ctx context.Context, method, rawURL string, body io.Reader, size int64, headers map[string]string, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Authorization", r.auth)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+}
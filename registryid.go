@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// This file contains -registry-id, which cleans up the registry of another
+// account whose repository policies grant the caller access, without
+// assuming a role there. A middleware on the AWS configuration gives every
+// ECR call the registry ID, like the apiStats middleware sees every call,
+// so the scan, the deletion and every optional feature that reads or
+// re-tags images address the same registry.
+
+// registryIDPattern matches a registry ID, the account ID of the registry
+var registryIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// registryIDOptions returns the AWS config options of -registry-id
+func registryIDOptions(cfg Config) ([]func(*config.LoadOptions) error, error) {
+	if cfg.RegistryID == "" {
+		return nil, nil
+	}
+	if !registryIDPattern.MatchString(cfg.RegistryID) {
+		return nil, fmt.Errorf("invalid registry ID %q: expected a 12-digit account ID", cfg.RegistryID)
+	}
+	return []func(*config.LoadOptions) error{config.WithAPIOptions([]func(*middleware.Stack) error{
+		func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ecrCleanupRegistryID", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if awsmiddleware.GetServiceID(ctx) == "ECR" {
+					setRegistryID(in.Parameters, cfg.RegistryID)
+				}
+				return next.HandleInitialize(ctx, in)
+			}), middleware.After)
+		},
+	})}, nil
+}
+
+// setRegistryID addresses an ECR call's input to the registry, unless the
+// call already names one
+func setRegistryID(params interface{}, registryID string) {
+	var field **string
+	switch input := params.(type) {
+	case *ecr.DescribeRepositoriesInput:
+		field = &input.RegistryId
+	case *ecr.ListImagesInput:
+		field = &input.RegistryId
+	case *ecr.DescribeImagesInput:
+		field = &input.RegistryId
+	case *ecr.BatchDeleteImageInput:
+		field = &input.RegistryId
+	case *ecr.BatchGetImageInput:
+		field = &input.RegistryId
+	case *ecr.PutImageInput:
+		field = &input.RegistryId
+	case *ecr.GetDownloadUrlForLayerInput:
+		field = &input.RegistryId
+	case *ecr.GetLifecyclePolicyInput:
+		field = &input.RegistryId
+	case *ecr.PutLifecyclePolicyInput:
+		field = &input.RegistryId
+	case *ecr.DeleteRepositoryInput:
+		field = &input.RegistryId
+	case *ecr.DescribePullThroughCacheRulesInput:
+		field = &input.RegistryId
+	default:
+		return
+	}
+	if *field == nil {
+		*field = aws.String(registryID)
+	}
+}
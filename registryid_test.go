@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// recordingHTTPClient records the bodies of the requests it answers
+type recordingHTTPClient struct {
+	bodies []string
+}
+
+func (c *recordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	c.bodies = append(c.bodies, string(body))
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+// TestRegistryID tests addressing every ECR call to another registry
+func TestRegistryID(t *testing.T) {
+	awsConfig, err := loadAWSConfig(context.Background(), Config{Region: "us-east-1", RegistryID: "123456789012"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	httpClient := &recordingHTTPClient{}
+	client := ecr.NewFromConfig(awsConfig, func(o *ecr.Options) {
+		o.HTTPClient = httpClient
+		o.Credentials = credentials.NewStaticCredentialsProvider("id", "secret", "")
+	})
+
+	ctx := context.Background()
+	if _, err := client.ListImages(ctx, &ecr.ListImagesInput{RepositoryName: aws.String("repo")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{RepositoryName: aws.String("repo"), RegistryId: aws.String("210987654321"), ImageIds: []types.ImageIdentifier{{ImageTag: aws.String("v1")}}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(httpClient.bodies) != 2 || !strings.Contains(httpClient.bodies[0], `"registryId":"123456789012"`) {
+		t.Errorf("Expected the call to be addressed to the registry, got %v", httpClient.bodies)
+	}
+	if !strings.Contains(httpClient.bodies[1], `"registryId":"210987654321"`) {
+		t.Errorf("Expected an explicit registry to be kept, got %s", httpClient.bodies[1])
+	}
+
+	for _, id := range []string{"12345", "registry", "1234567890123"} {
+		if _, err := registryIDOptions(Config{RegistryID: id}); err == nil {
+			t.Errorf("Expected %q to be rejected", id)
+		}
+	}
+}
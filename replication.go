@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -replication. ECR replicates pushes to the regions and
+// accounts of the registry's replication rules, but not deletions, so a
+// source cleaned up on its own drifts from its replicas, whose images also
+// look newer since their push time is when they were replicated. -replication
+// picks what to do about it:
+//
+//   - ignore doesn't look at the replication configuration
+//   - warn logs the replicas deletions won't reach
+//   - source-only leaves replica repositories alone, among the regions of
+//     the run, and only cleans up their sources
+//   - fan-out does the same, and deletes every image deleted from a source
+//     from its replicas in the same account too
+
+// Values of -replication
+const (
+	replicationIgnore     = "ignore"
+	replicationWarn       = "warn"
+	replicationSourceOnly = "source-only"
+	replicationFanOut     = "fan-out"
+)
+
+// RegistryClient defines the ECR operation that reads the replication
+// configuration of a registry
+type RegistryClient interface {
+	DescribeRegistry(ctx context.Context, params *ecr.DescribeRegistryInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRegistryOutput, error)
+}
+
+// registryReplication is the replication configuration of the registry of
+// one region
+type registryReplication struct {
+	registryID string
+	region     string
+	rules      []types.ReplicationRule
+}
+
+// describeReplication reads the replication configuration of a region's
+// registry
+func describeReplication(ctx context.Context, client RegistryClient, region string) (*registryReplication, error) {
+	resp, err := client.DescribeRegistry(ctx, &ecr.DescribeRegistryInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the registry of %s: %w", region, err)
+	}
+	replication := &registryReplication{registryID: aws.ToString(resp.RegistryId), region: region}
+	if resp.ReplicationConfiguration != nil {
+		replication.rules = resp.ReplicationConfiguration.Rules
+	}
+	return replication, nil
+}
+
+// replicates reports whether a rule replicates a repository: rules without
+// a filter replicate every repository
+func replicates(rule types.ReplicationRule, repoName string) bool {
+	if len(rule.RepositoryFilters) == 0 {
+		return true
+	}
+	for _, filter := range rule.RepositoryFilters {
+		if filter.FilterType == types.RepositoryFilterTypePrefixMatch && strings.HasPrefix(repoName, aws.ToString(filter.Filter)) {
+			return true
+		}
+	}
+	return false
+}
+
+// destinations returns the replicas of a repository, or of any repository
+// when repoName is empty
+func (r *registryReplication) destinations(repoName string) []types.ReplicationDestination {
+	var destinations []types.ReplicationDestination
+	for _, rule := range r.rules {
+		if repoName != "" && !replicates(rule, repoName) {
+			continue
+		}
+		for _, destination := range rule.Destinations {
+			if !slices.ContainsFunc(destinations, func(d types.ReplicationDestination) bool {
+				return aws.ToString(d.Region) == aws.ToString(destination.Region) && aws.ToString(d.RegistryId) == aws.ToString(destination.RegistryId)
+			}) {
+				destinations = append(destinations, destination)
+			}
+		}
+	}
+	return destinations
+}
+
+// replicatesTo reports whether the registry replicates a repository to a
+// region of its own account
+func (r *registryReplication) replicatesTo(repoName, region string) bool {
+	for _, destination := range r.destinations(repoName) {
+		if aws.ToString(destination.Region) == region && aws.ToString(destination.RegistryId) == r.registryID {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDestinations lists replicas as region (account)
+func formatDestinations(destinations []types.ReplicationDestination) string {
+	names := make([]string, len(destinations))
+	for i, destination := range destinations {
+		names[i] = fmt.Sprintf("%s (%s)", aws.ToString(destination.Region), aws.ToString(destination.RegistryId))
+	}
+	return strings.Join(names, ", ")
+}
+
+// describeRunReplication reads the replication configuration of every
+// region of a multi-region run, so each region knows which of its
+// repositories are replicas of another
+func describeRunReplication(ctx context.Context, awsConfig aws.Config, regions []string) (map[string]*registryReplication, error) {
+	registries := make(map[string]*registryReplication)
+	for _, region := range regions {
+		regionConfig := awsConfig.Copy()
+		regionConfig.Region = region
+		replication, err := describeReplication(ctx, ecr.NewFromConfig(regionConfig), region)
+		if err != nil {
+			return nil, err
+		}
+		registries[region] = replication
+	}
+	return registries, nil
+}
+
+// replicationState holds what -replication needs of the region being
+// processed. A nil replicationState changes nothing.
+type replicationState struct {
+	registry *registryReplication
+	// sources are the other regions of the run that replicate into this
+	// one, with source-only and fan-out
+	sources []*registryReplication
+	// replicas are the clients of the regions of the same account the
+	// registry replicates to, with fan-out
+	replicas map[string]ECRClient
+
+	// notDeleted counts the images fan-out failed to delete from each
+	// replica repository, by "region repository"
+	mu         sync.Mutex
+	notDeleted map[string]int
+}
+
+// newReplicationState reads the replication configuration of the region's
+// registry, unless the run already did, and warns about the replicas the
+// cleanup won't keep in sync
+func newReplicationState(ctx context.Context, awsConfig aws.Config, cfg Config) (*replicationState, error) {
+	registry := cfg.runReplication[awsConfig.Region]
+	if registry == nil {
+		var err error
+		registry, err = describeReplication(ctx, ecr.NewFromConfig(awsConfig), awsConfig.Region)
+		if err != nil {
+			return nil, err
+		}
+	}
+	state := &replicationState{registry: registry}
+
+	if cfg.Replication != replicationWarn {
+		for region, source := range cfg.runReplication {
+			if region != awsConfig.Region && source.registryID == registry.registryID {
+				state.sources = append(state.sources, source)
+			}
+		}
+		slices.SortFunc(state.sources, func(a, b *registryReplication) int { return strings.Compare(a.region, b.region) })
+	}
+
+	destinations := registry.destinations("")
+	if len(destinations) == 0 {
+		return state, nil
+	}
+	if cfg.Replication != replicationFanOut {
+		slog.Warn("Deletions won't propagate to the replicas of the registry", "region", awsConfig.Region, "replicas", formatDestinations(destinations))
+		return state, nil
+	}
+
+	var unreachable []types.ReplicationDestination
+	state.replicas = make(map[string]ECRClient)
+	for _, destination := range destinations {
+		if aws.ToString(destination.RegistryId) != registry.registryID {
+			unreachable = append(unreachable, destination)
+			continue
+		}
+		replicaConfig := awsConfig.Copy()
+		replicaConfig.Region = aws.ToString(destination.Region)
+		state.replicas[replicaConfig.Region] = newThrottledClient(ecr.NewFromConfig(replicaConfig), cfg)
+	}
+	if len(unreachable) > 0 {
+		slog.Warn("Deletions won't propagate to the replicas in other accounts", "region", awsConfig.Region, "replicas", formatDestinations(unreachable))
+	}
+	return state, nil
+}
+
+// withoutReplicas returns the repositories that aren't replicas of another
+// region of the run
+func (s *replicationState) withoutReplicas(repos []types.Repository) []types.Repository {
+	if s == nil || len(s.sources) == 0 {
+		return repos
+	}
+	var remaining []types.Repository
+	for _, repo := range repos {
+		name := aws.ToString(repo.RepositoryName)
+		if i := slices.IndexFunc(s.sources, func(source *registryReplication) bool { return source.replicatesTo(name, s.registry.region) }); i >= 0 {
+			slog.Info("Skipping replica repository", "repository", name, "source_region", s.sources[i].region)
+			continue
+		}
+		remaining = append(remaining, repo)
+	}
+	return remaining
+}
+
+// replicaRegions returns the regions a repository's deletions fan out to
+func (s *replicationState) replicaRegions(repoName string) []string {
+	if s == nil || len(s.replicas) == 0 {
+		return nil
+	}
+	var regions []string
+	for _, destination := range s.registry.destinations(repoName) {
+		if _, ok := s.replicas[aws.ToString(destination.Region)]; ok && aws.ToString(destination.RegistryId) == s.registry.registryID {
+			regions = append(regions, aws.ToString(destination.Region))
+		}
+	}
+	return regions
+}
+
+// logDryRun logs the replicas a dry run's deletions would fan out to
+func (s *replicationState) logDryRun(repoName string, images []types.ImageDetail) {
+	for _, region := range s.replicaRegions(repoName) {
+		slog.Info("[DRY RUN] Would also delete images from replica", "action", "would-delete", "repository", repoName, "replica_region", region, "images", len(images))
+	}
+}
+
+// imagesDeleted deletes the images deleted from a source repository from
+// its replicas. The source images are gone by then, so failures are
+// recorded for the run's summary rather than failing the repository, and
+// images the replica doesn't have are skipped.
+func (s *replicationState) imagesDeleted(ctx context.Context, repoName string, images []types.ImageDetail) {
+	if len(images) == 0 {
+		return
+	}
+	for _, region := range s.replicaRegions(repoName) {
+		client := s.replicas[region]
+		for i := 0; i < len(images); i += batchDeleteSize {
+			batch := images[i:min(i+batchDeleteSize, len(images))]
+			imageIds := make([]types.ImageIdentifier, len(batch))
+			for j, img := range batch {
+				imageIds[j] = types.ImageIdentifier{ImageDigest: img.ImageDigest}
+			}
+
+			result, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+				RepositoryName: aws.String(repoName),
+				ImageIds:       imageIds,
+			})
+			var notFound *types.RepositoryNotFoundException
+			if errors.As(err, &notFound) {
+				slog.Warn("Replica repository not found", "repository", repoName, "replica_region", region)
+				break
+			}
+			if err != nil {
+				slog.Error("Failed to delete images from replica", "action", "delete", "repository", repoName, "replica_region", region, "images", len(batch), "error", err)
+				s.recordNotDeleted(repoName, region, len(batch))
+				continue
+			}
+
+			deleted := len(batch)
+			for _, failure := range result.Failures {
+				deleted--
+				if failure.FailureCode == types.ImageFailureCodeImageNotFound {
+					continue
+				}
+				s.recordNotDeleted(repoName, region, 1)
+				slog.Error("Failed to delete image from replica",
+					"action", "delete",
+					"repository", repoName,
+					"replica_region", region,
+					"image", getImageIdString(failure.ImageId),
+					"reason", aws.ToString(failure.FailureReason),
+					"code", string(failure.FailureCode))
+			}
+			slog.Info("Deleted images from replica", "action", "delete", "repository", repoName, "replica_region", region, "images", deleted)
+		}
+	}
+}
+
+// recordNotDeleted records images left in a replica repository
+func (s *replicationState) recordNotDeleted(repoName, region string, images int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notDeleted == nil {
+		s.notDeleted = make(map[string]int)
+	}
+	s.notDeleted[region+" "+repoName] += images
+}
+
+// failures returns the replica repositories that drifted from their
+// source, for the summary's failures
+func (s *replicationState) failures() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failures []string
+	for key, images := range s.notDeleted {
+		region, repoName, _ := strings.Cut(key, " ")
+		failures = append(failures, fmt.Sprintf("replica %s in %s: %d images not deleted", repoName, region, images))
+	}
+	slices.Sort(failures)
+	return failures
+}
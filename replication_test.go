@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// mockRegistryClient returns a registry's replication configuration
+type mockRegistryClient struct {
+	rules []types.ReplicationRule
+}
+
+func (m *mockRegistryClient) DescribeRegistry(ctx context.Context, params *ecr.DescribeRegistryInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRegistryOutput, error) {
+	return &ecr.DescribeRegistryOutput{
+		RegistryId:               aws.String("123456789012"),
+		ReplicationConfiguration: &types.ReplicationConfiguration{Rules: m.rules},
+	}, nil
+}
+
+// testReplicationRules replicate every repository to us-west-2, and the
+// prod/ ones to another account too
+var testReplicationRules = []types.ReplicationRule{
+	{Destinations: []types.ReplicationDestination{{Region: aws.String("us-west-2"), RegistryId: aws.String("123456789012")}}},
+	{
+		Destinations:      []types.ReplicationDestination{{Region: aws.String("eu-west-1"), RegistryId: aws.String("210987654321")}},
+		RepositoryFilters: []types.RepositoryFilter{{Filter: aws.String("prod/"), FilterType: types.RepositoryFilterTypePrefixMatch}},
+	},
+}
+
+// TestReplicationDestinations tests finding the replicas of a repository
+func TestReplicationDestinations(t *testing.T) {
+	registry, err := describeReplication(context.Background(), &mockRegistryClient{rules: testReplicationRules}, "us-east-1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := formatDestinations(registry.destinations("prod/api")); got != "us-west-2 (123456789012), eu-west-1 (210987654321)" {
+		t.Errorf("Expected both replicas of prod/api, got %s", got)
+	}
+	if got := formatDestinations(registry.destinations("dev/api")); got != "us-west-2 (123456789012)" {
+		t.Errorf("Expected the unfiltered replica of dev/api, got %s", got)
+	}
+	if !registry.replicatesTo("dev/api", "us-west-2") || registry.replicatesTo("prod/api", "eu-west-1") {
+		t.Error("Expected only replicas of the registry's own account to be reported")
+	}
+}
+
+// TestWithoutReplicas tests leaving alone the repositories another region
+// of the run replicates into
+func TestWithoutReplicas(t *testing.T) {
+	source := &registryReplication{registryID: "123456789012", region: "us-east-1", rules: []types.ReplicationRule{{
+		Destinations:      []types.ReplicationDestination{{Region: aws.String("us-west-2"), RegistryId: aws.String("123456789012")}},
+		RepositoryFilters: []types.RepositoryFilter{{Filter: aws.String("prod/"), FilterType: types.RepositoryFilterTypePrefixMatch}},
+	}}}
+	state := &replicationState{
+		registry: &registryReplication{registryID: "123456789012", region: "us-west-2"},
+		sources:  []*registryReplication{source},
+	}
+
+	repos := []types.Repository{{RepositoryName: aws.String("prod/api")}, {RepositoryName: aws.String("local/tools")}}
+	remaining := state.withoutReplicas(repos)
+	if len(remaining) != 1 || aws.ToString(remaining[0].RepositoryName) != "local/tools" {
+		t.Errorf("Expected only the local repository left, got %v", remaining)
+	}
+
+	var none *replicationState
+	if len(none.withoutReplicas(repos)) != 2 {
+		t.Error("Expected every repository without -replication")
+	}
+}
+
+// TestReplicationFanOut tests deleting the images deleted from a source
+// from its replicas
+func TestReplicationFanOut(t *testing.T) {
+	replica := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{
+		Failures: []types.ImageFailure{{ImageId: &types.ImageIdentifier{ImageDigest: aws.String("sha256:002")}, FailureCode: types.ImageFailureCodeImageNotFound}},
+	}}
+	state := &replicationState{
+		registry: &registryReplication{registryID: "123456789012", region: "us-east-1", rules: testReplicationRules},
+		replicas: map[string]ECRClient{"us-west-2": replica},
+	}
+
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:001"), ImageTags: []string{"v1"}},
+		{ImageDigest: aws.String("sha256:002")},
+	}
+	state.imagesDeleted(context.Background(), "prod/api", images)
+
+	if replica.BatchDeleteImageCalls != 1 {
+		t.Fatalf("Expected one delete call to the replica, got %d", replica.BatchDeleteImageCalls)
+	}
+	var digests []string
+	for _, id := range replica.LastBatchDeleteImageInput.ImageIds {
+		digests = append(digests, aws.ToString(id.ImageDigest))
+	}
+	if !reflect.DeepEqual(digests, []string{"sha256:001", "sha256:002"}) || aws.ToString(replica.LastBatchDeleteImageInput.RepositoryName) != "prod/api" {
+		t.Errorf("Expected the deleted digests removed from the replica, got %v", replica.LastBatchDeleteImageInput)
+	}
+
+	if failures := state.failures(); len(failures) != 0 {
+		t.Errorf("Expected images missing from the replica not to fail, got %v", failures)
+	}
+
+	// Images the replica refused to delete, or a failed call, are failures
+	replica.BatchDeleteImageOutput.Failures = append(replica.BatchDeleteImageOutput.Failures, types.ImageFailure{
+		ImageId: &types.ImageIdentifier{ImageDigest: aws.String("sha256:001")}, FailureCode: types.ImageFailureCodeImageReferencedByManifestList,
+	})
+	state.imagesDeleted(context.Background(), "prod/api", images)
+	replica.BatchDeleteImageError = errors.New("throttled")
+	state.imagesDeleted(context.Background(), "prod/api", images)
+	replica.BatchDeleteImageError = nil
+	if failures := state.failures(); !reflect.DeepEqual(failures, []string{"replica prod/api in us-west-2: 3 images not deleted"}) {
+		t.Errorf("Expected the replica's drift recorded, got %v", failures)
+	}
+	calls := replica.BatchDeleteImageCalls
+
+	// Without fan-out nothing is deleted from the replicas
+	var none *replicationState
+	none.imagesDeleted(context.Background(), "prod/api", images)
+	if replica.BatchDeleteImageCalls != calls || none.failures() != nil {
+		t.Error("Expected no delete call without -replication fan-out")
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mchineboy/ecr-cleanup/schema"
+)
+
+// This file contains the cleanup reports. Every format is built from the
+// same reportData so they always show the same numbers.
+
+// reportChartSize is the number of repositories shown in the space chart
+const reportChartSize = 10
+
+// reportBarWidth is the width in characters of the longest Markdown bar
+const reportBarWidth = 30
+
+// reportData is the view of a cleanup run rendered into reports
+type reportData struct {
+	GeneratedAt  time.Time
+	DryRun       bool
+	Days         int
+	OlderThan    time.Duration
+	Before       time.Time
+	PushedAfter  time.Time
+	PushedBefore time.Time
+	MaxImages    int
+	Summary      CleanupSummary
+	Repositories []RepositorySummary
+	Chart        []reportBar
+	ShowAccount  bool
+	ShowRegion   bool
+}
+
+// AgeThreshold describes the age limit of the run, e.g. "10 days"
+func (data reportData) AgeThreshold() string {
+	switch {
+	case !data.PushedAfter.IsZero() && !data.PushedBefore.IsZero():
+		return "between " + data.PushedAfter.Format(time.RFC3339) + " and " + data.PushedBefore.Format(time.RFC3339)
+	case !data.PushedAfter.IsZero():
+		return "after " + data.PushedAfter.Format(time.RFC3339)
+	case !data.PushedBefore.IsZero():
+		return "before " + data.PushedBefore.Format(time.RFC3339)
+	case !data.Before.IsZero():
+		return "before " + data.Before.Format(time.RFC3339)
+	case data.OlderThan > 0:
+		return formatAgeDuration(data.OlderThan)
+	default:
+		return fmt.Sprintf("%d days", data.Days)
+	}
+}
+
+// SpaceFreedUpperBound is the most space the run freed, with each digest
+// counted once per registry
+func (data reportData) SpaceFreedUpperBound() int64 {
+	return data.Summary.spaceFreedUpperBound()
+}
+
+// reportBar is one entry of the space reclaimed chart
+type reportBar struct {
+	Label      string
+	SpaceFreed int64
+	Percent    float64 // relative to the largest bar
+}
+
+// newReportData prepares the summary for rendering. Repositories are sorted
+// by space freed so the biggest wins come first.
+func newReportData(summary CleanupSummary, cfg Config, now time.Time) reportData {
+	data := reportData{
+		GeneratedAt:  now.UTC(),
+		DryRun:       cfg.DryRun,
+		Days:         cfg.Days,
+		OlderThan:    cfg.OlderThan,
+		Before:       cfg.Before,
+		PushedAfter:  cfg.PushedAfter,
+		PushedBefore: cfg.PushedBefore,
+		MaxImages:    cfg.MaxImages,
+		Summary:      summary,
+		Repositories: sortRepositoriesBySpaceFreed(summary.Repositories),
+	}
+
+	for _, repo := range data.Repositories {
+		if repo.AccountID != "" {
+			data.ShowAccount = true
+		}
+		if repo.Region != "" {
+			data.ShowRegion = true
+		}
+	}
+
+	for _, repo := range data.Repositories {
+		if repo.SpaceFreed <= 0 || len(data.Chart) == reportChartSize {
+			break
+		}
+		data.Chart = append(data.Chart, reportBar{
+			Label:      repo.qualifiedName(),
+			SpaceFreed: repo.SpaceFreed,
+			Percent:    float64(repo.SpaceFreed) / float64(data.Repositories[0].SpaceFreed) * 100,
+		})
+	}
+
+	return data
+}
+
+// formatMB formats a byte count in megabytes like the log summary does
+func formatMB(bytes int64) string {
+	return fmt.Sprintf("%.2f MB", float64(bytes)/1024/1024)
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 18.2 GB
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	for _, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		value /= unit
+		if value < unit || suffix == "TB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return ""
+}
+
+// writeReports writes every report requested in the configuration
+func writeReports(summary CleanupSummary, cfg Config) error {
+	data := newReportData(summary, cfg, time.Now())
+
+	if cfg.ReportMarkdown != "" {
+		if err := writeReportFile(cfg.ReportMarkdown, data, writeMarkdownReport); err != nil {
+			return fmt.Errorf("failed to write Markdown report: %w", err)
+		}
+	}
+
+	if cfg.ReportHTML != "" {
+		if err := writeReportFile(cfg.ReportHTML, data, writeHTMLReport); err != nil {
+			return fmt.Errorf("failed to write HTML report: %w", err)
+		}
+	}
+
+	if cfg.ReportS3 != "" {
+		if err := uploadReports(cfg, data); err != nil {
+			return fmt.Errorf("failed to upload reports to S3: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeReportFile creates the file at path and renders the report into it
+func writeReportFile(path string, data reportData, render func(io.Writer, reportData) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := render(f, data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeMarkdownReport renders the report as Markdown with a text bar chart
+func writeMarkdownReport(w io.Writer, data reportData) error {
+	var b strings.Builder
+
+	b.WriteString("# ECR Cleanup Report\n\n")
+	fmt.Fprintf(&b, "Generated %s\n\n", data.GeneratedAt.Format(time.RFC3339))
+	if data.DryRun {
+		b.WriteString("> This was a dry run. No images were actually deleted.\n\n")
+	}
+
+	b.WriteString("## Totals\n\n")
+	b.WriteString("| Metric | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Repositories processed | %d |\n", data.Summary.RepositoriesProcessed)
+	fmt.Fprintf(&b, "| Images scanned | %d |\n", data.Summary.ImagesScanned)
+	fmt.Fprintf(&b, "| Images deleted | %d |\n", data.Summary.ImagesDeleted)
+	fmt.Fprintf(&b, "| Space freed | %s |\n", formatMB(data.Summary.SpaceFreed))
+	fmt.Fprintf(&b, "| Space freed at most, shared digests counted once | %s |\n", formatMB(data.SpaceFreedUpperBound()))
+	fmt.Fprintf(&b, "| Estimated monthly savings | %s |\n", formatUSD(data.Summary.EstimatedMonthlySavings))
+	fmt.Fprintf(&b, "| Age threshold | %s |\n", data.AgeThreshold())
+	if data.MaxImages > 0 {
+		fmt.Fprintf(&b, "| Max images kept | %d |\n", data.MaxImages)
+	}
+	b.WriteString("\n")
+
+	if len(data.Chart) > 0 {
+		b.WriteString("## Space Reclaimed\n\n```\n")
+		width := 0
+		for _, bar := range data.Chart {
+			if len(bar.Label) > width {
+				width = len(bar.Label)
+			}
+		}
+		for _, bar := range data.Chart {
+			length := int(bar.Percent / 100 * reportBarWidth)
+			if length < 1 {
+				length = 1
+			}
+			fmt.Fprintf(&b, "%-*s %s %s\n", width, bar.Label, strings.Repeat("#", length), formatMB(bar.SpaceFreed))
+		}
+		b.WriteString("```\n\n")
+	}
+
+	b.WriteString("## Repositories\n\n")
+	if len(data.Repositories) == 0 {
+		b.WriteString("No repositories were processed.\n")
+	} else {
+		header, separator := "|", "|"
+		if data.ShowAccount {
+			header += " Account |"
+			separator += "---|"
+		}
+		if data.ShowRegion {
+			header += " Region |"
+			separator += "---|"
+		}
+		header += " Repository | Images scanned | Images deleted | Space freed | Error |\n"
+		separator += "---|---:|---:|---:|---|\n"
+		b.WriteString(header)
+		b.WriteString(separator)
+
+		for _, repo := range data.Repositories {
+			b.WriteString("|")
+			if data.ShowAccount {
+				fmt.Fprintf(&b, " %s |", repo.AccountID)
+			}
+			if data.ShowRegion {
+				fmt.Fprintf(&b, " %s |", repo.Region)
+			}
+			fmt.Fprintf(&b, " %s | %d | %d | %s | %s |\n",
+				repo.Name, repo.ImagesScanned, repo.ImagesDeleted,
+				formatMB(repo.SpaceFreed), markdownEscape(repo.Error))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownEscape keeps a value from breaking out of its table cell
+func markdownEscape(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+// htmlReportTemplate renders the report as a standalone HTML page
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"mb":  formatMB,
+	"usd": formatUSD,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>ECR Cleanup Report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.num { text-align: right; }
+.note { background: #fff4ce; padding: 8px; border-left: 4px solid #e0b000; }
+.chart { margin-bottom: 2em; }
+.chart .row { display: flex; align-items: center; margin: 2px 0; }
+.chart .label { width: 20em; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.chart .bar { background: #ff9900; height: 1em; margin-right: 8px; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>ECR Cleanup Report</h1>
+<p>Generated {{.GeneratedAt.Format "2006-01-02T15:04:05Z07:00"}}</p>
+{{if .DryRun}}<p class="note">This was a dry run. No images were actually deleted.</p>{{end}}
+<h2>Totals</h2>
+<table>
+<tr><th>Repositories processed</th><td class="num">{{.Summary.RepositoriesProcessed}}</td></tr>
+<tr><th>Images scanned</th><td class="num">{{.Summary.ImagesScanned}}</td></tr>
+<tr><th>Images deleted</th><td class="num">{{.Summary.ImagesDeleted}}</td></tr>
+<tr><th>Space freed</th><td class="num">{{mb .Summary.SpaceFreed}}</td></tr>
+<tr><th>Space freed at most, shared digests counted once</th><td class="num">{{mb .SpaceFreedUpperBound}}</td></tr>
+<tr><th>Estimated monthly savings</th><td class="num">{{usd .Summary.EstimatedMonthlySavings}}</td></tr>
+<tr><th>Age threshold</th><td class="num">{{.AgeThreshold}}</td></tr>
+{{if gt .MaxImages 0}}<tr><th>Max images kept</th><td class="num">{{.MaxImages}}</td></tr>{{end}}
+</table>
+{{if .Chart}}<h2>Space Reclaimed</h2>
+<div class="chart">
+{{range .Chart}}<div class="row"><span class="label">{{.Label}}</span><span class="bar" style="width: {{printf "%.1f" .Percent}}%"></span><span>{{mb .SpaceFreed}}</span></div>
+{{end}}</div>
+{{end}}<h2>Repositories</h2>
+{{if .Repositories}}<table>
+<tr>{{if .ShowAccount}}<th>Account</th>{{end}}{{if .ShowRegion}}<th>Region</th>{{end}}<th>Repository</th><th>Images scanned</th><th>Images deleted</th><th>Space freed</th><th>Error</th></tr>
+{{$data := .}}{{range .Repositories}}<tr>{{if $data.ShowAccount}}<td>{{.AccountID}}</td>{{end}}{{if $data.ShowRegion}}<td>{{.Region}}</td>{{end}}<td>{{.Name}}</td><td class="num">{{.ImagesScanned}}</td><td class="num">{{.ImagesDeleted}}</td><td class="num">{{mb .SpaceFreed}}</td><td class="error">{{.Error}}</td></tr>
+{{end}}</table>
+{{else}}<p>No repositories were processed.</p>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLReport renders the report as a standalone HTML page with a bar chart
+func writeHTMLReport(w io.Writer, data reportData) error {
+	return htmlReportTemplate.Execute(w, data)
+}
+
+// jsonReport is the machine-readable form of a cleanup run, in the stable
+// format of the schema package
+type jsonReport = schema.Report
+
+// jsonAPICall is the statistics of one ECR operation in the JSON report
+type jsonAPICall = schema.APICall
+
+// jsonRepository is one repository in the JSON report
+type jsonRepository = schema.ReportRepository
+
+// newJSONReport converts the report data into its JSON form
+func newJSONReport(data reportData) jsonReport {
+	report := jsonReport{
+		SchemaVersion:         schema.ReportVersion,
+		GeneratedAt:           data.GeneratedAt,
+		DryRun:                data.DryRun,
+		Days:                  data.Days,
+		MaxImages:             data.MaxImages,
+		RepositoriesProcessed: data.Summary.RepositoriesProcessed,
+		ImagesScanned:         data.Summary.ImagesScanned,
+		ImagesDeleted:         data.Summary.ImagesDeleted,
+		SpaceFreed:            data.Summary.SpaceFreed,
+		SpaceFreedUpperBound:  data.SpaceFreedUpperBound(),
+		MonthlySavings:        roundUSD(data.Summary.EstimatedMonthlySavings),
+		APICalls:              []jsonAPICall{},
+		Repositories:          []jsonRepository{},
+	}
+	if data.OlderThan > 0 {
+		report.OlderThan = formatAgeDuration(data.OlderThan)
+	}
+	if !data.Before.IsZero() {
+		report.Before = &data.Before
+	}
+	if !data.PushedAfter.IsZero() {
+		report.PushedAfter = &data.PushedAfter
+	}
+	if !data.PushedBefore.IsZero() {
+		report.PushedBefore = &data.PushedBefore
+	}
+
+	for _, stats := range data.Summary.APICalls {
+		report.APICalls = append(report.APICalls, jsonAPICall(stats))
+	}
+
+	for _, repo := range data.Repositories {
+		report.Repositories = append(report.Repositories, newJSONRepository(repo))
+	}
+
+	return report
+}
+
+// newJSONRepository converts a repository's results into its JSON form
+func newJSONRepository(repo RepositorySummary) jsonRepository {
+	return jsonRepository{
+		AccountID:      repo.AccountID,
+		Region:         repo.Region,
+		Name:           repo.Name,
+		ImagesScanned:  repo.ImagesScanned,
+		ImagesDeleted:  repo.ImagesDeleted,
+		SpaceFreed:     repo.SpaceFreed,
+		MonthlySavings: roundUSD(repo.EstimatedMonthlySavings),
+		Error:          repo.Error,
+	}
+}
+
+// runResult is the JSON result of a run, as POSTed to the webhook and
+// returned by the Lambda handler
+type runResult = schema.RunResult
+
+// newRunResult builds the JSON result of a run
+func newRunResult(summary CleanupSummary, cfg Config, runErr error) runResult {
+	result := runResult{
+		Status:   "succeeded",
+		Failures: len(summary.failedRepositories()),
+		Report:   newJSONReport(newReportData(summary, cfg, time.Now())),
+	}
+	if runErr != nil {
+		result.Status = "failed"
+		result.Error = runErr.Error()
+	}
+	return result
+}
+
+// writeJSONReport renders the report as indented JSON
+func writeJSONReport(w io.Writer, data reportData) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newJSONReport(data))
+}
+
+// writeCSVReport renders one CSV row per repository
+func writeCSVReport(w io.Writer, data reportData) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"account_id", "region", "repository", "images_scanned", "images_deleted", "space_freed_bytes", "estimated_monthly_savings_usd", "error"})
+
+	for _, repo := range data.Repositories {
+		writer.Write([]string{
+			repo.AccountID,
+			repo.Region,
+			repo.Name,
+			fmt.Sprint(repo.ImagesScanned),
+			fmt.Sprint(repo.ImagesDeleted),
+			fmt.Sprint(repo.SpaceFreed),
+			fmt.Sprintf("%.2f", repo.EstimatedMonthlySavings),
+			repo.Error,
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
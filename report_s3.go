@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// This file contains the S3 upload of run reports. Each run stores its JSON
+// and CSV reports under a timestamped key, which leaves an audit trail for
+// unattended executions.
+
+// S3Client defines the S3 operations needed to upload reports
+type S3Client interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3Location is a bucket and key prefix parsed from an s3:// URI
+type s3Location struct {
+	Bucket string
+	Prefix string
+}
+
+// parseS3URI parses s3://bucket/prefix/ into its bucket and key prefix. A
+// non-empty prefix always ends in a slash so keys land inside it.
+func parseS3URI(uri string) (s3Location, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		return s3Location{}, fmt.Errorf("invalid S3 URI %q: must start with s3://", uri)
+	}
+
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return s3Location{}, fmt.Errorf("invalid S3 URI %q: missing bucket", uri)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return s3Location{Bucket: bucket, Prefix: prefix}, nil
+}
+
+// reportKey returns the object key of a report generated at the given time
+func (l s3Location) reportKey(data reportData, extension string) string {
+	return fmt.Sprintf("%secr-cleanup-%s.%s", l.Prefix, data.GeneratedAt.Format("20060102T150405Z"), extension)
+}
+
+// uploadReports uploads the JSON and CSV reports to the -report-s3 location
+// using the run's base credentials
+func uploadReports(cfg Config, data reportData) error {
+	location, err := parseS3URI(cfg.ReportS3)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	awsConfig, err := loadRunAWSConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return uploadReportsWithClient(ctx, newS3Client(awsConfig), location, data)
+}
+
+// uploadReportsWithClient renders and uploads each report format
+func uploadReportsWithClient(ctx context.Context, client S3Client, location s3Location, data reportData) error {
+	reports := []struct {
+		extension   string
+		contentType string
+		render      func(io.Writer, reportData) error
+	}{
+		{"json", "application/json", writeJSONReport},
+		{"csv", "text/csv", writeCSVReport},
+	}
+
+	for _, report := range reports {
+		var body bytes.Buffer
+		if err := report.render(&body, data); err != nil {
+			return err
+		}
+
+		key := location.reportKey(data, report.extension)
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(location.Bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(body.Bytes()),
+			ContentType: aws.String(report.contentType),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload s3://%s/%s: %w", location.Bucket, key, err)
+		}
+		slog.Info("Uploaded report", "url", "s3://"+location.Bucket+"/"+key)
+	}
+
+	return nil
+}
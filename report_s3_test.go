@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MockS3Client is a mock implementation of the S3Client interface
+type MockS3Client struct {
+	PutObjectError error
+	Objects        map[string]string
+}
+
+// PutObject records the uploaded object
+func (m *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if m.PutObjectError != nil {
+		return nil, m.PutObjectError
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if m.Objects == nil {
+		m.Objects = make(map[string]string)
+	}
+	m.Objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// TestParseS3URI tests parsing -report-s3 locations
+func TestParseS3URI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    s3Location
+		wantErr bool
+	}{
+		{uri: "s3://bucket/reports/", want: s3Location{Bucket: "bucket", Prefix: "reports/"}},
+		{uri: "s3://bucket/reports", want: s3Location{Bucket: "bucket", Prefix: "reports/"}},
+		{uri: "s3://bucket", want: s3Location{Bucket: "bucket"}},
+		{uri: "s3:///reports", wantErr: true},
+		{uri: "bucket/reports", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseS3URI(tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseS3URI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseS3URI(%q) = %+v, want %+v", tt.uri, got, tt.want)
+		}
+	}
+}
+
+// TestUploadReportsWithClient tests uploading the JSON and CSV reports
+func TestUploadReportsWithClient(t *testing.T) {
+	data := newReportData(testReportSummary(), Config{}, time.Date(2025, 5, 13, 14, 32, 33, 0, time.UTC))
+	location := s3Location{Bucket: "audit", Prefix: "ecr/"}
+
+	t.Run("Uploads timestamped reports", func(t *testing.T) {
+		client := &MockS3Client{}
+		if err := uploadReportsWithClient(context.Background(), client, location, data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		for _, key := range []string{
+			"audit/ecr/ecr-cleanup-20250513T143233Z.json",
+			"audit/ecr/ecr-cleanup-20250513T143233Z.csv",
+		} {
+			if client.Objects[key] == "" {
+				t.Errorf("Expected %s to be uploaded, got %v", key, client.Objects)
+			}
+		}
+	})
+
+	t.Run("Upload error", func(t *testing.T) {
+		client := &MockS3Client{PutObjectError: errors.New("access denied")}
+		if err := uploadReportsWithClient(context.Background(), client, location, data); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
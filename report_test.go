@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchineboy/ecr-cleanup/schema"
+)
+
+// testReportSummary returns a summary with a mix of repositories
+func testReportSummary() CleanupSummary {
+	return CleanupSummary{
+		RepositoriesProcessed: 3,
+		ImagesScanned:         30,
+		ImagesDeleted:         12,
+		SpaceFreed:            3 * 1024 * 1024,
+		Repositories: []RepositorySummary{
+			{Name: "small", ImagesScanned: 10, ImagesDeleted: 2, SpaceFreed: 1024 * 1024},
+			{Name: "big", ImagesScanned: 15, ImagesDeleted: 10, SpaceFreed: 2 * 1024 * 1024},
+			{Name: "<broken>", ImagesScanned: 5, Error: "access denied | retry"},
+		},
+	}
+}
+
+// TestNewReportData tests sorting and chart preparation
+func TestNewReportData(t *testing.T) {
+	data := newReportData(testReportSummary(), Config{Days: 10}, time.Now())
+
+	if data.Repositories[0].Name != "big" || data.Repositories[1].Name != "small" {
+		t.Errorf("Expected repositories sorted by space freed, got %v", data.Repositories)
+	}
+	if len(data.Chart) != 2 {
+		t.Fatalf("Expected 2 chart bars, got %d", len(data.Chart))
+	}
+	if data.Chart[0].Percent != 100 || data.Chart[1].Percent != 50 {
+		t.Errorf("Expected bars at 100%% and 50%%, got %v and %v", data.Chart[0].Percent, data.Chart[1].Percent)
+	}
+	if data.ShowAccount || data.ShowRegion {
+		t.Error("Expected no account or region columns for a single-region run")
+	}
+
+	summary := testReportSummary()
+	summary.Repositories[1].AccountID = "123456789012"
+	summary.Repositories[1].Region = "us-east-1"
+	data = newReportData(summary, Config{}, time.Now())
+	if !data.ShowAccount || !data.ShowRegion {
+		t.Error("Expected account and region columns")
+	}
+	if data.Chart[0].Label != "123456789012/us-east-1/big" {
+		t.Errorf("Expected qualified chart label, got %s", data.Chart[0].Label)
+	}
+}
+
+// TestWriteMarkdownReport tests the Markdown report contents
+func TestWriteMarkdownReport(t *testing.T) {
+	var b strings.Builder
+	data := newReportData(testReportSummary(), Config{DryRun: true, Days: 10, MaxImages: 5}, time.Now())
+	if err := writeMarkdownReport(&b, data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	report := b.String()
+	for _, want := range []string{
+		"# ECR Cleanup Report",
+		"This was a dry run",
+		"| Images scanned | 30 |",
+		"| Space freed | 3.00 MB |",
+		"| Max images kept | 5 |",
+		"big   ############################## 2.00 MB",
+		"| big | 15 | 10 | 2.00 MB |  |",
+		"access denied \\| retry",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+}
+
+// TestWriteHTMLReport tests the HTML report contents and escaping
+func TestWriteHTMLReport(t *testing.T) {
+	var b strings.Builder
+	data := newReportData(testReportSummary(), Config{Days: 10}, time.Now())
+	if err := writeHTMLReport(&b, data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	report := b.String()
+	for _, want := range []string{
+		"<title>ECR Cleanup Report</title>",
+		"<td class=\"num\">2.00 MB</td>",
+		"width: 50.0%",
+		"&lt;broken&gt;",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "dry run") {
+		t.Error("Expected no dry run note")
+	}
+}
+
+// TestWriteReports tests writing the requested report files
+func TestWriteReports(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		ReportHTML:     dir + "/report.html",
+		ReportMarkdown: dir + "/report.md",
+	}
+
+	if err := writeReports(testReportSummary(), cfg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, path := range []string{cfg.ReportHTML, cfg.ReportMarkdown} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("Expected report %s to be written, got %v", path, err)
+		}
+	}
+
+	cfg = Config{ReportMarkdown: dir + "/missing/report.md"}
+	if err := writeReports(testReportSummary(), cfg); err == nil {
+		t.Error("Expected an error for an unwritable path")
+	}
+}
+
+// TestWriteJSONReport tests the JSON report contents
+func TestWriteJSONReport(t *testing.T) {
+	var b strings.Builder
+	summary := testReportSummary()
+	summary.APICalls = []APICallStats{{Operation: "DescribeImages", Calls: 7, Throttles: 2, Retries: 2}}
+	data := newReportData(summary, Config{Days: 10}, time.Date(2025, 5, 13, 14, 32, 33, 0, time.UTC))
+	if err := writeJSONReport(&b, data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal([]byte(b.String()), &report); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if report.SchemaVersion != schema.ReportVersion {
+		t.Errorf("Expected schema version %d, got %d", schema.ReportVersion, report.SchemaVersion)
+	}
+	if report.ImagesDeleted != 12 || report.SpaceFreed != 3*1024*1024 {
+		t.Errorf("Unexpected totals: %+v", report)
+	}
+	if len(report.Repositories) != 3 || report.Repositories[0].Name != "big" {
+		t.Errorf("Unexpected repositories: %+v", report.Repositories)
+	}
+	if len(report.APICalls) != 1 || report.APICalls[0] != (jsonAPICall{Operation: "DescribeImages", Calls: 7, Throttles: 2, Retries: 2}) {
+		t.Errorf("Unexpected API calls: %+v", report.APICalls)
+	}
+	if !strings.Contains(b.String(), `"generated_at": "2025-05-13T14:32:33Z"`) {
+		t.Errorf("Expected generation time in report, got:\n%s", b.String())
+	}
+}
+
+// TestWriteCSVReport tests the CSV report contents
+func TestWriteCSVReport(t *testing.T) {
+	var b strings.Builder
+	data := newReportData(testReportSummary(), Config{}, time.Now())
+	if err := writeCSVReport(&b, data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %d lines", len(lines))
+	}
+	if lines[0] != "account_id,region,repository,images_scanned,images_deleted,space_freed_bytes,estimated_monthly_savings_usd,error" {
+		t.Errorf("Unexpected header: %s", lines[0])
+	}
+	if lines[1] != ",,big,15,10,2097152,0.00," {
+		t.Errorf("Unexpected first row: %s", lines[1])
+	}
+}
+
+// TestFormatBytes tests human-readable sizes
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:                     "0 B",
+		1023:                  "1023 B",
+		1536:                  "1.5 KB",
+		19541180416:           "18.2 GB",
+		5 * 1024 * 1024 << 30: "5120.0 TB",
+	}
+	for bytes, want := range tests {
+		if got := formatBytes(bytes); got != want {
+			t.Errorf("formatBytes(%d) = %q, expected %q", bytes, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the scoping of a run to named repositories, for
+// testing a new policy on one repository or cleaning up a single busy one
+// without touching the rest of the registry. Repositories are named with
+// -repository, or listed in a file or on stdin with -repos-from so other
+// tools can pick them.
+
+// repositoryNames collects repeated -repository flags
+type repositoryNames []string
+
+// String returns the repositories as a comma-separated list
+func (r *repositoryNames) String() string {
+	return strings.Join(*r, ", ")
+}
+
+// Set adds a repository name
+func (r *repositoryNames) Set(value string) error {
+	name := strings.TrimSpace(value)
+	if name == "" {
+		return fmt.Errorf("invalid repository %q: expected a repository name", value)
+	}
+	*r = append(*r, name)
+	return nil
+}
+
+// scopeRepositories returns the repositories named in names, or every
+// repository when names is empty. Names that match no repository are
+// logged, since they are likely typos.
+func scopeRepositories(repos []types.Repository, names []string) []types.Repository {
+	if len(names) == 0 {
+		return repos
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var scoped []types.Repository
+	for _, repo := range repos {
+		name := aws.ToString(repo.RepositoryName)
+		if wanted[name] {
+			scoped = append(scoped, repo)
+			delete(wanted, name)
+		}
+	}
+	for _, name := range names {
+		if wanted[name] {
+			slog.Warn("Repository not found", "repository", name)
+			delete(wanted, name)
+		}
+	}
+	return scoped
+}
+
+// readRepositoryList reads repository names, one per line, from a file or
+// from stdin when path is "-". Blank lines and lines starting with # are
+// ignored.
+func readRepositoryList(path string, stdin io.Reader) ([]string, error) {
+	in := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(in)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid repository name %q", path, lineNo, line)
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
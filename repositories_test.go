@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestRepositoryNames tests collecting repeated -repository flags
+func TestRepositoryNames(t *testing.T) {
+	var names repositoryNames
+	if err := names.Set(" team/api "); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := names.Set("web"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if names.String() != "team/api, web" {
+		t.Errorf("Expected both repositories, got %q", names.String())
+	}
+	if err := names.Set(" "); err == nil {
+		t.Error("Expected an empty name rejected")
+	}
+}
+
+// TestScopeRepositories tests that only the named repositories are cleaned up
+func TestScopeRepositories(t *testing.T) {
+	repos := []types.Repository{
+		{RepositoryName: aws.String("api")},
+		{RepositoryName: aws.String("web")},
+		{RepositoryName: aws.String("worker")},
+	}
+	if got := scopeRepositories(repos, nil); len(got) != 3 {
+		t.Errorf("Expected every repository without names, got %d", len(got))
+	}
+	got := scopeRepositories(repos, []string{"worker", "missing", "api"})
+	if len(got) != 2 || aws.ToString(got[0].RepositoryName) != "api" || aws.ToString(got[1].RepositoryName) != "worker" {
+		t.Errorf("Expected api and worker, got %v", got)
+	}
+
+	// Only the named repository is scanned and cleaned up
+	client := newGuardrailClient(3, 5)
+	summary, err := CleanupWithClient(context.Background(), Config{Days: 10, MinKeep: 1, Repositories: []string{"repo1"}}, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.RepositoriesProcessed != 1 || len(summary.Repositories) != 1 || summary.Repositories[0].Name != "repo1" {
+		t.Errorf("Expected only repo1 processed, got %+v", summary.Repositories)
+	}
+}
+
+// TestReadRepositoryList tests reading repositories from a file and stdin
+func TestReadRepositoryList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repos.txt")
+	if err := os.WriteFile(path, []byte("# over budget\nteam/api\n\n  web  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := readRepositoryList(path, nil)
+	if err != nil || strings.Join(names, ",") != "team/api,web" {
+		t.Errorf("Expected team/api and web, got %v, %v", names, err)
+	}
+
+	names, err = readRepositoryList("-", strings.NewReader("worker\n"))
+	if err != nil || len(names) != 1 || names[0] != "worker" {
+		t.Errorf("Expected worker from stdin, got %v, %v", names, err)
+	}
+
+	if _, err := readRepositoryList("-", strings.NewReader("api 12 GB\n")); err == nil || !strings.Contains(err.Error(), "-:1") {
+		t.Errorf("Expected the invalid line reported, got %v", err)
+	}
+	if _, err := readRepositoryList(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -tag-policies, which lets repository owners set their
+// own retention by tagging the repository instead of changing a central
+// configuration:
+//
+//	ecr-cleanup/days=45        overrides -days
+//	ecr-cleanup/max-images=20  overrides -max-images
+//	ecr-cleanup/skip=true      leaves the repository alone
+//
+// The tags take precedence over every flag, including the pull-through
+// cache retention. A tag with an invalid value is ignored with a warning, so
+// one owner's typo falls back to the global policy instead of failing the
+// run.
+//
+// It also contains -opt-in-tag, which only cleans up the repositories that
+// carry a given tag, so that a large organization can adopt the tool one
+// team at a time.
+
+// Repository tags read by -tag-policies
+const (
+	tagPolicyDays      = "ecr-cleanup/days"
+	tagPolicyMaxImages = "ecr-cleanup/max-images"
+	tagPolicySkip      = "ecr-cleanup/skip"
+)
+
+// RepositoryTagClient defines the ECR operation needed to read the tags of
+// repositories
+type RepositoryTagClient interface {
+	ListTagsForResource(ctx context.Context, params *ecr.ListTagsForResourceInput, optFns ...func(*ecr.Options)) (*ecr.ListTagsForResourceOutput, error)
+}
+
+// repositoryPolicy is the retention a repository's tags set; nil fields
+// keep the global value
+type repositoryPolicy struct {
+	days      *int
+	maxImages *int
+	skip      bool
+}
+
+// repositoryPolicies holds what the repositories' tags set: the policies
+// of the repositories tagged with one, and with -opt-in-tag which ones
+// opted in. A nil repositoryPolicies overrides nothing, so callers don't
+// need to check whether -tag-policies or -opt-in-tag is set.
+type repositoryPolicies struct {
+	byName  map[string]repositoryPolicy
+	optIn   string
+	optedIn map[string]bool
+}
+
+// usesRepositoryTags reports whether the run reads the repositories' tags
+func usesRepositoryTags(cfg Config) bool {
+	return cfg.TagPolicies || cfg.OptInTag != ""
+}
+
+// loadRepositoryPolicies reads the tags of every repository, concurrency at
+// a time
+func loadRepositoryPolicies(ctx context.Context, client RepositoryTagClient, repos []types.Repository, cfg Config) (*repositoryPolicies, error) {
+	if client == nil {
+		return nil, fmt.Errorf("reading repository tags isn't supported here")
+	}
+
+	policies := &repositoryPolicies{byName: make(map[string]repositoryPolicy), optIn: cfg.OptInTag, optedIn: make(map[string]bool)}
+	var mu sync.Mutex
+	var firstErr error
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		repoName := aws.ToString(repo.RepositoryName)
+		resp, err := client.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{ResourceArn: repo.RepositoryArn})
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to read the tags of repository %s: %w", repoName, err)
+			}
+			return
+		}
+		if policies.optIn != "" && hasTag(resp.Tags, policies.optIn) {
+			policies.optedIn[repoName] = true
+		}
+		if !cfg.TagPolicies {
+			return
+		}
+		if policy, ok := parseRepositoryPolicy(repoName, resp.Tags); ok {
+			policies.byName[repoName] = policy
+		}
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return policies, nil
+}
+
+// parseRepositoryPolicy reads the policy from a repository's tags, and
+// reports whether the repository has one
+func parseRepositoryPolicy(repoName string, tags []types.Tag) (repositoryPolicy, bool) {
+	var policy repositoryPolicy
+	found := false
+	for _, tag := range tags {
+		key, value := aws.ToString(tag.Key), aws.ToString(tag.Value)
+		switch key {
+		case tagPolicyDays, tagPolicyMaxImages:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				slog.Warn("Ignoring repository tag with an invalid value", "repository", repoName, "tag", key, "value", value)
+				continue
+			}
+			if key == tagPolicyDays {
+				policy.days = &n
+			} else {
+				policy.maxImages = &n
+			}
+		case tagPolicySkip:
+			skip, err := strconv.ParseBool(value)
+			if err != nil {
+				slog.Warn("Ignoring repository tag with an invalid value", "repository", repoName, "tag", key, "value", value)
+				continue
+			}
+			policy.skip = skip
+		default:
+			continue
+		}
+		found = true
+	}
+	return policy, found
+}
+
+// hasTag reports whether the tags match a key=value tag filter, like
+// -org-skip-tag does
+func hasTag(tags []types.Tag, filter string) bool {
+	key, value := parseTagFilter(filter)
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key && (value == "" || strings.EqualFold(aws.ToString(tag.Value), value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSkipped filters out the repositories tagged to be left alone, and
+// with -opt-in-tag those that didn't opt in
+func (p *repositoryPolicies) withoutSkipped(repos []types.Repository) []types.Repository {
+	if p == nil {
+		return repos
+	}
+
+	var kept []types.Repository
+	notOptedIn := 0
+	for _, repo := range repos {
+		repoName := aws.ToString(repo.RepositoryName)
+		if p.optIn != "" && !p.optedIn[repoName] {
+			notOptedIn++
+			continue
+		}
+		if p.byName[repoName].skip {
+			slog.Info("Skipping repository", "repository", repoName, "reason", tagPolicySkip+"=true")
+			continue
+		}
+		kept = append(kept, repo)
+	}
+	if notOptedIn > 0 {
+		slog.Info("Skipping repositories that didn't opt in", "repositories", notOptedIn, "opt_in_tag", p.optIn)
+	}
+	return kept
+}
+
+// retention applies a repository's tags to its retention
+func (p *repositoryPolicies) retention(repoName string, retention Config) Config {
+	if p == nil {
+		return retention
+	}
+
+	policy, ok := p.byName[repoName]
+	if !ok {
+		return retention
+	}
+	if policy.days != nil {
+		retention = retention.withDays(*policy.days)
+	}
+	if policy.maxImages != nil {
+		retention.MaxImages = *policy.maxImages
+	}
+	slog.Info("Using the repository's tagged retention", "repository", repoName, "days", retention.Days, "max_images", retention.MaxImages)
+	return retention
+}
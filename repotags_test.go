@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// repositoryTagsClient serves repository tags by repository ARN
+type repositoryTagsClient struct {
+	tags map[string][]types.Tag
+	err  error
+}
+
+func (m *repositoryTagsClient) ListTagsForResource(ctx context.Context, params *ecr.ListTagsForResourceInput, optFns ...func(*ecr.Options)) (*ecr.ListTagsForResourceOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &ecr.ListTagsForResourceOutput{Tags: m.tags[aws.ToString(params.ResourceArn)]}, nil
+}
+
+// repositoryTags builds repository tags from key/value pairs
+func repositoryTags(pairs ...string) []types.Tag {
+	var tags []types.Tag
+	for i := 0; i < len(pairs); i += 2 {
+		tags = append(tags, types.Tag{Key: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+	}
+	return tags
+}
+
+// TestParseRepositoryPolicy tests reading the policy from repository tags
+func TestParseRepositoryPolicy(t *testing.T) {
+	policy, ok := parseRepositoryPolicy("repo", repositoryTags("team", "api", tagPolicyDays, "45", tagPolicyMaxImages, "20"))
+	if !ok || *policy.days != 45 || *policy.maxImages != 20 || policy.skip {
+		t.Errorf("Expected 45 days and 20 images, got %+v", policy)
+	}
+
+	if _, ok := parseRepositoryPolicy("repo", repositoryTags("team", "api")); ok {
+		t.Error("Expected no policy without ecr-cleanup tags")
+	}
+
+	// Invalid values fall back to the global policy
+	policy, ok = parseRepositoryPolicy("repo", repositoryTags(tagPolicyDays, "forever", tagPolicyMaxImages, "-1", tagPolicySkip, "TRUE"))
+	if !ok || policy.days != nil || policy.maxImages != nil || !policy.skip {
+		t.Errorf("Expected only the skip tag applied, got %+v", policy)
+	}
+
+	var none *repositoryPolicies
+	if none.retention("repo", Config{Days: 10}).Days != 10 || len(none.withoutSkipped([]types.Repository{{}})) != 1 {
+		t.Error("Expected nothing overridden without -tag-policies")
+	}
+}
+
+// TestCleanupWithTagPolicies tests that tagged repositories override the
+// global retention or are skipped
+func TestCleanupWithTagPolicies(t *testing.T) {
+	client := newGuardrailClient(4, 5)
+	for i := range client.DescribeRepositoriesOutput.Repositories {
+		repo := &client.DescribeRepositoriesOutput.Repositories[i]
+		repo.RepositoryArn = aws.String("arn:aws:ecr:us-east-1:123456789012:repository/" + aws.ToString(repo.RepositoryName))
+	}
+	tags := &repositoryTagsClient{tags: map[string][]types.Tag{
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo0": repositoryTags(tagPolicySkip, "true"),
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo1": repositoryTags(tagPolicyDays, "365"),
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo2": repositoryTags(tagPolicyMaxImages, "3"),
+	}}
+
+	cfg := Config{Days: 10, TagPolicies: true, repoTags: tags, Concurrency: 2}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.RepositoriesProcessed != 3 {
+		t.Errorf("Expected the skipped repository left out, got %d processed", summary.RepositoriesProcessed)
+	}
+
+	deleted := make(map[string]int)
+	for _, repo := range summary.Repositories {
+		deleted[repo.Name] = repo.ImagesDeleted
+	}
+	want := map[string]int{"repo1": 0, "repo2": 2, "repo3": 5}
+	for name, n := range want {
+		if deleted[name] != n {
+			t.Errorf("Expected %d images deleted from %s, got %d", n, name, deleted[name])
+		}
+	}
+
+	// The run fails rather than ignore the owners' policies
+	cfg.repoTags = &repositoryTagsClient{err: errors.New("access denied")}
+	if _, err := CleanupWithClient(context.Background(), cfg, newGuardrailClient(2, 5)); err == nil {
+		t.Error("Expected an error when the tags can't be read")
+	}
+}
+
+// TestCleanupWithOptInTag tests cleaning up only the repositories tagged
+// to opt in
+func TestCleanupWithOptInTag(t *testing.T) {
+	client := newGuardrailClient(3, 5)
+	for i := range client.DescribeRepositoriesOutput.Repositories {
+		repo := &client.DescribeRepositoriesOutput.Repositories[i]
+		repo.RepositoryArn = aws.String("arn:aws:ecr:us-east-1:123456789012:repository/" + aws.ToString(repo.RepositoryName))
+	}
+	tags := &repositoryTagsClient{tags: map[string][]types.Tag{
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo0": repositoryTags("ecr-cleanup", "enabled"),
+		"arn:aws:ecr:us-east-1:123456789012:repository/repo1": repositoryTags("ecr-cleanup", "disabled"),
+	}}
+
+	cfg := Config{Days: 10, OptInTag: "ecr-cleanup=enabled", repoTags: tags}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.RepositoriesProcessed != 1 || summary.Repositories[0].Name != "repo0" || summary.ImagesDeleted != 5 {
+		t.Errorf("Expected only repo0 cleaned up, got %+v", summary.Repositories)
+	}
+
+	// A key alone matches any value, and values ignore case
+	tagged := repositoryTags("ecr-cleanup", "Enabled")
+	for filter, want := range map[string]bool{"ecr-cleanup=enabled": true, "ecr-cleanup": true, "ecr-cleanup=disabled": false, "team": false} {
+		if hasTag(tagged, filter) != want {
+			t.Errorf("Expected %q to match %v", filter, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// This file contains the restore command, the way back from -archive-to:
+// the images of a plan are copied from the archive back into the
+// repositories they were deleted from, by digest, and tagged as they were.
+// A tag that was pushed again since the deletion keeps its new image.
+
+// restoreSelection holds the -restore-images entries: digests, repository
+// names and repo:tag references. An empty selection restores every image.
+type restoreSelection []string
+
+// parseRestoreSelection splits the comma-separated -restore-images value
+func parseRestoreSelection(value string) restoreSelection {
+	var selection restoreSelection
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			selection = append(selection, entry)
+		}
+	}
+	return selection
+}
+
+// matches reports whether a planned image of a repository is selected
+func (s restoreSelection) matches(repoName string, img PlanImage) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, entry := range s {
+		if entry == img.Digest || entry == repoName {
+			return true
+		}
+		for _, tag := range img.Tags {
+			if entry == repoName+":"+tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RestoreSummary tracks the results of the restore command
+type RestoreSummary struct {
+	ImagesRestored int
+	ImagesFailed   int
+}
+
+// restoreImage copies an image from the archive back into its repository
+// and re-applies the tags that weren't pushed again since
+func (a *archiver) restoreImage(ctx context.Context, repoName string, img PlanImage) error {
+	var tags []string
+	for _, tag := range img.Tags {
+		digest, err := a.src.manifestDigest(ctx, repoName, tag)
+		if err != nil {
+			return fmt.Errorf("failed to check tag %s: %w", tag, err)
+		}
+		if digest != "" && digest != img.Digest {
+			slog.Warn("Not restoring tag, which was pushed again since", "repository", repoName, "tag", tag, "digest", digest)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := a.src.copyImage(ctx, a.dst, a.repository(repoName), img.Digest, repoName, tags); err != nil {
+		return fmt.Errorf("failed to restore image %s: %w", img.Digest, err)
+	}
+	return nil
+}
+
+// restorePlan restores the selected images of a plan from the archive.
+// Repositories of other accounts than accountID are skipped, since the
+// images can only be restored with that account's credentials. archiverFor
+// returns the archiver of a region of the plan, "" being the default one.
+func restorePlan(ctx context.Context, plan *Plan, selection restoreSelection, accountID string, dryRun bool, archiverFor func(region string) (*archiver, error)) (RestoreSummary, error) {
+	var summary RestoreSummary
+	archivers := make(map[string]*archiver)
+
+	for _, repo := range plan.Repositories {
+		if repo.AccountID != "" && repo.AccountID != accountID {
+			slog.Warn("Skipping repository of another account", "account", repo.AccountID, "repository", repo.Name)
+			continue
+		}
+
+		for _, img := range repo.Images {
+			if !selection.matches(repo.Name, img) {
+				continue
+			}
+			if dryRun {
+				slog.Info("Would restore image", "action", "restore", "region", repo.Region, "repository", repo.Name, "digest", img.Digest, "tags", img.Tags)
+				summary.ImagesRestored++
+				continue
+			}
+
+			a, ok := archivers[repo.Region]
+			if !ok {
+				var err error
+				if a, err = archiverFor(repo.Region); err != nil {
+					return summary, fmt.Errorf("failed to set up the archive: %w", err)
+				}
+				archivers[repo.Region] = a
+			}
+
+			if err := a.restoreImage(ctx, repo.Name, img); err != nil {
+				slog.Error("Error restoring image", "region", repo.Region, "repository", repo.Name, "error", err)
+				summary.ImagesFailed++
+				continue
+			}
+			slog.Info("Restored image", "action", "restore", "region", repo.Region, "repository", repo.Name, "digest", img.Digest, "tags", img.Tags)
+			summary.ImagesRestored++
+		}
+	}
+
+	return summary, nil
+}
+
+// runRestore runs the restore command: it copies the images of the -plan
+// file back from -archive-to into their repositories. With -dry-run it
+// only shows what would be restored.
+func runRestore(config Config) int {
+	if config.PlanFile == "" || config.ArchiveTo == "" {
+		slog.Error("The restore command requires -plan and -archive-to")
+		return 1
+	}
+
+	plan, err := readPlanFile(config.PlanFile)
+	if err != nil {
+		slog.Error("Invalid plan", "error", err)
+		return 1
+	}
+	slog.Info("Restoring plan", "plan_id", plan.ID, "created_at", plan.CreatedAt.Format(time.RFC3339), "images", plan.Images)
+
+	ctx, cancel := withRunTimeout(context.Background(), config)
+	defer cancel()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error loading AWS config", "error", err)
+		return 1
+	}
+	identity, err := getCallerIdentity(ctx, awsConfig)
+	if err != nil {
+		slog.Error("Error verifying AWS credentials", "error", err)
+		return 1
+	}
+	slog.Info("Running as caller identity", "arn", aws.ToString(identity.Arn), "account", aws.ToString(identity.Account))
+
+	summary, err := restorePlan(ctx, plan, config.RestoreImages, aws.ToString(identity.Account), config.DryRun, func(region string) (*archiver, error) {
+		regionConfig := awsConfig.Copy()
+		if region != "" {
+			regionConfig.Region = region
+		}
+		return newRegionArchiver(ctx, regionConfig, config.ArchiveTo)
+	})
+	slog.Info("Restore summary", "dry_run", config.DryRun, "images_restored", summary.ImagesRestored, "images_failed", summary.ImagesFailed)
+	if err != nil {
+		slog.Error("Error restoring images", "error", err)
+		return 1
+	}
+	if summary.ImagesFailed > 0 {
+		return 1
+	}
+	return 0
+}
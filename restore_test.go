@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRestoreSelection tests picking the images of a plan to restore
+func TestRestoreSelection(t *testing.T) {
+	img := PlanImage{Digest: "sha256:aaa", Tags: []string{"v1.4.2"}}
+
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"", true},
+		{"sha256:aaa", true},
+		{"api", true},
+		{"api:v1.4.2", true},
+		{" web , api:v1.4.2 ", true},
+		{"api:v1.4.3", false},
+		{"web,sha256:bbb", false},
+	}
+
+	for _, tt := range tests {
+		if got := parseRestoreSelection(tt.value).matches("api", img); got != tt.expected {
+			t.Errorf("parseRestoreSelection(%q).matches() = %v, want %v", tt.value, got, tt.expected)
+		}
+	}
+}
+
+// TestRestorePlan tests copying planned images back from the archive
+func TestRestorePlan(t *testing.T) {
+	registry := newFakeRegistry(t)
+	digest := pushTestImage(registry, "archive/api")
+	repushed := pushTestImage(registry, "api", "v2")
+	client := &mockArchiveClient{endpoint: registry.URL}
+
+	plan := &Plan{Repositories: []PlanRepository{
+		{Region: "us-east-1", Name: "api", Images: []PlanImage{
+			{Digest: digest, Tags: []string{"v1", "v2"}},
+			{Digest: "sha256:missing"},
+		}},
+		{AccountID: "210987654321", Region: "us-east-1", Name: "web", Images: []PlanImage{{Digest: digest}}},
+	}}
+
+	var regions []string
+	archiverFor := func(region string) (*archiver, error) {
+		regions = append(regions, region)
+		return newArchiver(context.Background(), client, client, archiveTarget{Repository: "archive/"}, nil)
+	}
+
+	t.Run("Dry run", func(t *testing.T) {
+		summary, err := restorePlan(context.Background(), plan, nil, "123456789012", true, archiverFor)
+		if err != nil || summary.ImagesRestored != 2 || len(regions) != 0 {
+			t.Errorf("Expected both images of the account listed without logging in, got %+v, %v", summary, err)
+		}
+		if _, ok := registry.manifest("api", digest); ok {
+			t.Error("Expected nothing restored in a dry run")
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		summary, err := restorePlan(context.Background(), plan, nil, "123456789012", false, archiverFor)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if summary.ImagesRestored != 1 || summary.ImagesFailed != 1 {
+			t.Errorf("Expected one image restored and the missing one failed, got %+v", summary)
+		}
+		if len(regions) != 1 || regions[0] != "us-east-1" {
+			t.Errorf("Expected one login to the plan's region, got %v", regions)
+		}
+
+		if _, ok := registry.manifest("api", digest); !ok {
+			t.Error("Expected the image restored by digest")
+		}
+		if m, ok := registry.manifest("api", "v1"); !ok || digestOf(m.body) != digest {
+			t.Error("Expected the tag re-applied")
+		}
+		if m, _ := registry.manifest("api", "v2"); digestOf(m.body) != repushed {
+			t.Error("Expected the tag pushed again since to keep its new image")
+		}
+		if _, ok := registry.manifest("web", digest); ok {
+			t.Error("Expected the repository of another account skipped")
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/mchineboy/ecr-cleanup/schema"
+)
+
+// This file contains -retry-file, the queue of deletions that failed. Every
+// image BatchDeleteImage refuses, or that a failed batch left behind, is
+// written to the file as a plan. A run started with a retry file that lists
+// images reattempts only those, like applying the plan, and rewrites the
+// file with the ones that failed again; once nothing is left to retry the
+// file is removed and the next run cleans up as usual.
+
+// retryQueue collects the failed deletions of a run. A nil retryQueue
+// collects nothing, so callers don't need to check whether -retry-file is
+// set.
+type retryQueue struct {
+	path    string
+	pending *Plan // the deletions this run retries, if any
+
+	mu     sync.Mutex
+	failed map[CheckpointEntry][]PlanImage
+}
+
+// openRetryQueue reads the deletions to retry from the retry file, if any
+func openRetryQueue(path string) (*retryQueue, error) {
+	q := &retryQueue{path: path, failed: make(map[CheckpointEntry][]PlanImage)}
+
+	plan, err := readPlanFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry file: %w", err)
+	}
+	if plan.Images > 0 {
+		q.pending = plan
+	}
+	return q, nil
+}
+
+// add records images whose deletion failed
+func (q *retryQueue) add(cfg Config, repoName string, images []types.ImageDetail) {
+	if q == nil || len(images) == 0 {
+		return
+	}
+
+	key := checkpointKey(cfg.accountID, cfg.region, repoName)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, img := range images {
+		q.failed[key] = append(q.failed[key], PlanImage{
+			Digest:    aws.ToString(img.ImageDigest),
+			Tags:      img.ImageTags,
+			PushedAt:  aws.ToTime(img.ImagePushedAt).UTC(),
+			SizeBytes: aws.ToInt64(img.ImageSizeInBytes),
+		})
+	}
+}
+
+// finish writes the deletions to retry to the file, or removes it when
+// there are none. The pending deletions of repositories the run couldn't
+// process are kept, as are all of them when the run itself failed.
+func (q *retryQueue) finish(summary CleanupSummary, runErr error) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	plan := &Plan{SchemaVersion: schema.PlanVersion, ID: randomHexID(8), CreatedAt: time.Now().UTC(), Repositories: []PlanRepository{}}
+	seen := make(map[CheckpointEntry]map[string]bool)
+	addImages := func(key CheckpointEntry, images []PlanImage) {
+		if seen[key] == nil {
+			seen[key] = make(map[string]bool)
+		}
+		for _, img := range images {
+			if seen[key][img.Digest] {
+				continue
+			}
+			seen[key][img.Digest] = true
+			if repo := plan.repository(key.AccountID, key.Region, key.Repository); repo != nil {
+				repo.Images = append(repo.Images, img)
+			} else {
+				plan.Repositories = append(plan.Repositories, PlanRepository{AccountID: key.AccountID, Region: key.Region, Name: key.Repository, Images: []PlanImage{img}})
+			}
+			plan.Images++
+			plan.SizeBytes += img.SizeBytes
+		}
+	}
+
+	for key, images := range q.failed {
+		addImages(key, images)
+	}
+	if q.pending != nil {
+		failedRepos := make(map[CheckpointEntry]bool)
+		for _, repo := range summary.failedRepositories() {
+			failedRepos[checkpointKey(repo.AccountID, repo.Region, repo.Name)] = true
+		}
+		for _, repo := range q.pending.Repositories {
+			key := checkpointKey(repo.AccountID, repo.Region, repo.Name)
+			if runErr != nil || len(summary.Failures) > 0 || failedRepos[key] {
+				addImages(key, repo.Images)
+			}
+		}
+	}
+
+	if plan.Images == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove the retry file", "retry_file", q.path, "error", err)
+		}
+		return
+	}
+	if err := writePlanFile(plan, q.path); err != nil {
+		slog.Error("Failed to write the retry file", "retry_file", q.path, "error", err)
+		return
+	}
+	slog.Warn("Recorded failed deletions to retry", "retry_file", q.path, "images", plan.Images)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestRetryQueue tests queueing failed deletions and retrying them
+func TestRetryQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.json")
+	queue, err := openRetryQueue(path)
+	if err != nil || queue.pending != nil {
+		t.Fatalf("Expected an empty queue without a retry file, got %+v, %v", queue, err)
+	}
+
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:a"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(time.Now()), ImageSizeInBytes: aws.Int64(100)},
+		{ImageDigest: aws.String("sha256:b"), ImagePushedAt: aws.Time(time.Now()), ImageSizeInBytes: aws.Int64(200)},
+	}
+	cfg := Config{region: "us-east-1"}
+	queue.add(cfg, "repo", images)
+	queue.add(cfg, "repo", images[:1])
+	queue.finish(CleanupSummary{}, nil)
+
+	// The next run retries the failed deletions only
+	queue, err = openRetryQueue(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if queue.pending == nil || queue.pending.Images != 2 || queue.pending.SizeBytes != 300 {
+		t.Fatalf("Expected both images to be retried once, got %+v", queue.pending)
+	}
+	if repo := queue.pending.repository("", "us-east-1", "repo"); repo == nil || repo.Images[0].Tags[0] != "v1" {
+		t.Errorf("Expected the images of repo in us-east-1, got %+v", queue.pending.Repositories)
+	}
+
+	// A run that failed keeps them
+	queue.finish(CleanupSummary{}, errors.New("failed to load AWS config"))
+	if queue, _ = openRetryQueue(path); queue.pending == nil || queue.pending.Images != 2 {
+		t.Fatalf("Expected the pending deletions to be kept, got %+v", queue.pending)
+	}
+
+	// A run that retried them all removes the file
+	queue.finish(CleanupSummary{Repositories: []RepositorySummary{{Region: "us-east-1", Name: "repo"}}}, nil)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the retry file to be removed, got %v", err)
+	}
+}
+
+// TestDeleteImagesQueuesFailures tests queueing the images BatchDeleteImage
+// refuses
+func TestDeleteImagesQueuesFailures(t *testing.T) {
+	client := &MockECRClient{BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{
+		Failures: []types.ImageFailure{{ImageId: &types.ImageIdentifier{ImageDigest: aws.String("sha256:b")}, FailureReason: aws.String("denied")}},
+	}}
+	queue, err := openRetryQueue(filepath.Join(t.TempDir(), "retry.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cfg := Config{retries: queue}
+
+	images := []types.ImageDetail{{ImageDigest: aws.String("sha256:a")}, {ImageDigest: aws.String("sha256:b")}}
+	if _, err := deleteImages(context.Background(), client, "repo", images, cfg); !errors.Is(err, errImagesNotDeleted) {
+		t.Fatalf("Expected the failed image reported, got %v", err)
+	}
+	failed := queue.failed[checkpointKey("", "", "repo")]
+	if len(failed) != 1 || failed[0].Digest != "sha256:b" {
+		t.Errorf("Expected sha256:b to be queued, got %+v", failed)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// This file contains -aws-retry-mode and -aws-max-attempts, which set the
+// retryer of every AWS client. The SDK's defaults suit interactive tools;
+// a bulk cleanup may prefer adaptive mode, which slows the client down on
+// its own once AWS starts throttling, or more attempts so a long run
+// survives a bad minute. The ECR calls the throttled client of throttle.go
+// makes are the exception for throttling, which only it retries, up to
+// -throttle-max-attempts times.
+
+// retryOptions returns the AWS config options of -aws-retry-mode and
+// -aws-max-attempts; unset, the SDK's settings apply, such as
+// AWS_RETRY_MODE and AWS_MAX_ATTEMPTS
+func retryOptions(cfg Config) ([]func(*config.LoadOptions) error, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.AWSRetryMode != "" {
+		mode, err := aws.ParseRetryMode(cfg.AWSRetryMode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry mode %q: expected standard or adaptive", cfg.AWSRetryMode)
+		}
+		opts = append(opts, config.WithRetryMode(mode))
+	}
+	if cfg.AWSMaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid maximum attempts %d: expected a positive number, or 0 for the default", cfg.AWSMaxAttempts)
+	}
+	if cfg.AWSMaxAttempts > 0 {
+		opts = append(opts, config.WithRetryMaxAttempts(cfg.AWSMaxAttempts))
+	}
+	return opts, nil
+}
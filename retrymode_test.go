@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// TestLoadAWSConfigRetry tests setting the retryer of the AWS clients
+func TestLoadAWSConfigRetry(t *testing.T) {
+	awsConfig, err := loadAWSConfig(context.Background(), Config{Region: "us-east-1", AWSRetryMode: "adaptive", AWSMaxAttempts: 8})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if awsConfig.RetryMode != aws.RetryModeAdaptive || awsConfig.RetryMaxAttempts != 8 {
+		t.Errorf("Expected adaptive mode with 8 attempts, got %s with %d", awsConfig.RetryMode, awsConfig.RetryMaxAttempts)
+	}
+
+	for _, cfg := range []Config{{AWSRetryMode: "legacy"}, {AWSMaxAttempts: -1}} {
+		if _, err := retryOptions(cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+// TestRetryLayers tests how often an ECR call is attempted under the SDK's
+// retryer and the throttled client: throttling is only retried by the
+// throttled client, the other transient errors only by the SDK
+func TestRetryLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		code     string
+		attempts int
+	}{
+		{"Throttling", http.StatusBadRequest, "ThrottlingException", 4},
+		{"Server error", http.StatusInternalServerError, "ServerException", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("X-Amzn-ErrorType", tt.code)
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, `{"__type":%q,"message":"failed"}`, tt.code)
+			}))
+			defer server.Close()
+
+			cfg := Config{Region: "us-east-1", AWSMaxAttempts: 3, ThrottleMaxAttempts: 4}
+			awsConfig, err := loadAWSConfig(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			awsConfig.BaseEndpoint = aws.String(server.URL)
+			awsConfig.Credentials = credentials.NewStaticCredentialsProvider("id", "secret", "")
+			// The SDK's attempts without its backoff
+			awsConfig.Retryer = func() aws.Retryer {
+				return retry.NewStandard(func(o *retry.StandardOptions) {
+					o.MaxAttempts = awsConfig.RetryMaxAttempts
+					o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+				})
+			}
+
+			client, _ := newTestThrottledClient(ecr.NewFromConfig(awsConfig), cfg)
+			if _, err := client.DescribeImages(context.Background(), &ecr.DescribeImagesInput{RepositoryName: aws.String("api")}); err == nil {
+				t.Fatal("Expected an error")
+			}
+			if calls != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the streaming scan of a repository. Repositories can
+// hold hundreds of thousands of images, so instead of keeping the details of
+// every image, the scan goes through a page at a time, sums up what the
+// retention policy needs to know about the whole repository, and buffers
+// only the images that can be selected for deletion: those older than the
+// age limit (the newer ones are never deleted, so counting them is enough
+// for -max-images), the artifacts that follow their image, and when
+// applying a plan the images the plan names. Beyond that, only the digest
+// of each image is kept, to recognize artifacts whose image is gone.
+
+// listImagesPageSize is the maximum number of image IDs ListImages returns,
+// so that each page is described in several batches in parallel
+const listImagesPageSize = 1000
+
+// repositoryStats sums up a scanned repository
+type repositoryStats struct {
+	images int
+	size   int64 // in bytes
+
+	// quarantine holds the quarantine tags to add and remove once the
+	// deletions are claimed, with -quarantine-days or a plan
+	quarantine quarantineChanges
+}
+
+// repositoryScan holds what the selection needs to know about a repository
+type repositoryScan struct {
+	repositoryStats
+
+	subjects int               // images that aren't artifacts
+	newer    int               // subjects pushed after the age limit
+	newest   types.ImageDetail // most recently pushed subject
+	clean    types.ImageDetail // most recently pushed subject without critical or high findings
+	families *tagFamilies      // most recently pushed subject of each tag family, with -keep-per-tag-family
+	digests  map[string]bool   // digest of every image
+
+	artifacts      imageArtifacts
+	artifactImages []types.ImageDetail
+	candidates     []types.ImageDetail // subjects that can be selected
+	neverPulled    []types.ImageDetail // subjects never pulled since -delete-never-pulled-after-days
+	expired        []types.ImageDetail // subjects past their expiry label
+	quarantined    []types.ImageDetail // subjects with a quarantine tag, with -quarantine-days
+}
+
+// scanImagePages calls fn with each page of a repository's image details
+func scanImagePages(ctx context.Context, client ECRClient, repoName string, cfg Config, fn func([]types.ImageDetail) error) error {
+	// DescribeImages can page through the repository on its own, which
+	// halves the number of API calls
+	if cfg.SkipListImages {
+		var nextToken *string
+		for {
+			resp, err := client.DescribeImages(ctx, &ecr.DescribeImagesInput{
+				RepositoryName: aws.String(repoName),
+				NextToken:      nextToken,
+			})
+			if err != nil {
+				return err
+			}
+			if err := fn(resp.ImageDetails); err != nil {
+				return err
+			}
+			if nextToken = resp.NextToken; nextToken == nil {
+				return nil
+			}
+		}
+	}
+
+	// List the next page of image IDs while the current one is described
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pages := make(chan []types.ImageIdentifier, 1)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(pages)
+		var nextToken *string
+		for {
+			resp, err := client.ListImages(ctx, &ecr.ListImagesInput{
+				RepositoryName: aws.String(repoName),
+				NextToken:      nextToken,
+				MaxResults:     aws.Int32(listImagesPageSize),
+			})
+			if err != nil {
+				listErr <- err
+				return
+			}
+			select {
+			case pages <- resp.ImageIds:
+			case <-ctx.Done():
+				return
+			}
+			if nextToken = resp.NextToken; nextToken == nil {
+				return
+			}
+		}
+	}()
+
+	for imageIds := range pages {
+		if len(imageIds) == 0 {
+			continue
+		}
+		details, err := describeImageIDs(ctx, client, repoName, imageIds, cfg.DescribeConcurrency)
+		if err != nil {
+			return err
+		}
+		if err := fn(details); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-listErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// scanRepository scans a repository a page at a time under the retention
+// policy, buffering only the images that can be selected for deletion
+func scanRepository(ctx context.Context, client ECRClient, repoName string, cfg, retention Config) (*repositoryScan, error) {
+	scan := &repositoryScan{digests: make(map[string]bool), artifacts: make(imageArtifacts)}
+	if cfg.KeepPerTagFamily {
+		families, err := newTagFamilies(cfg.TagFamilyPattern)
+		if err != nil {
+			return nil, err
+		}
+		scan.families = families
+	}
+	now := cfg.now()
+	cutoff := retention.ageCutoff(now)
+	pullCutoff := now.AddDate(0, 0, -retention.NeverPulledDays)
+
+	var planned func(types.ImageDetail) bool
+	if cfg.plan != nil {
+		planned = cfg.plan.names(cfg.accountID, cfg.region, repoName)
+	}
+
+	err := scanImagePages(ctx, client, repoName, cfg, func(page []types.ImageDetail) error {
+		maps.Copy(scan.artifacts, findArtifacts(ctx, cfg.artifactManifests, repoName, page))
+		var subjects []types.ImageDetail
+		for _, img := range page {
+			clean := cleanImage(img)
+			img = compactImage(img)
+			scan.images++
+			scan.size += aws.ToInt64(img.ImageSizeInBytes)
+			scan.digests[aws.ToString(img.ImageDigest)] = true
+
+			if scan.artifacts.isArtifact(img) {
+				scan.artifactImages = append(scan.artifactImages, img)
+				if planned != nil && planned(img) {
+					scan.candidates = append(scan.candidates, img)
+				}
+				continue
+			}
+
+			scan.subjects++
+			subjects = append(subjects, img)
+			if img.ImagePushedAt != nil && (scan.newest.ImagePushedAt == nil || img.ImagePushedAt.After(*scan.newest.ImagePushedAt)) {
+				scan.newest = img
+			}
+			if clean && img.ImagePushedAt != nil && (scan.clean.ImagePushedAt == nil || img.ImagePushedAt.After(*scan.clean.ImagePushedAt)) {
+				scan.clean = img
+			}
+			if scan.families != nil {
+				scan.families.add(img)
+			}
+			if planned == nil && cfg.QuarantineDays > 0 && len(quarantineTags(img)) > 0 {
+				scan.quarantined = append(scan.quarantined, img)
+			}
+			if planned == nil && retention.NeverPulledDays > 0 && neverPulled(img, pullCutoff) {
+				scan.neverPulled = append(scan.neverPulled, img)
+			}
+			switch {
+			case planned != nil:
+				if planned(img) {
+					scan.candidates = append(scan.candidates, img)
+				}
+			case retention.agedOut(img.ImagePushedAt, cutoff):
+				scan.candidates = append(scan.candidates, img)
+			case img.ImagePushedAt != nil && !img.ImagePushedAt.Before(cutoff):
+				scan.newer++
+			}
+		}
+		if planned == nil {
+			scan.expired = append(scan.expired, cfg.expiry.expired(ctx, repoName, subjects, cfg.now())...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// selectCandidates applies the retention policy to the buffered images.
+// The images newer than the age limit are never deleted and all rank
+// before the candidates, so they take up that many of the -max-images
+// newest images the policy keeps.
+func (s *repositoryScan) selectCandidates(retention Config) []types.ImageDetail {
+	if retention.MaxImages > 0 {
+		// With none left to keep, 0 deletes every candidate as it should
+		retention.MaxImages = max(retention.MaxImages-s.newer, 0)
+	}
+	return selectImagesForDeletion(s.candidates, retention)
+}
+
+// compactImage keeps the fields of an image's details that the run uses
+func compactImage(img types.ImageDetail) types.ImageDetail {
+	return types.ImageDetail{
+		RegistryId:             img.RegistryId,
+		ImageDigest:            img.ImageDigest,
+		ImageTags:              img.ImageTags,
+		ImagePushedAt:          img.ImagePushedAt,
+		ImageSizeInBytes:       img.ImageSizeInBytes,
+		ArtifactMediaType:      img.ArtifactMediaType,
+		ImageManifestMediaType: img.ImageManifestMediaType,
+		LastRecordedPullTime:   img.LastRecordedPullTime,
+	}
+}
+
+// names returns whether an image of the repository is named by the plan,
+// by its digest or by one of its planned tags. The images the plan
+// quarantines or releases are named by their digest.
+func (p *Plan) names(accountID, region, repoName string) func(types.ImageDetail) bool {
+	digests, tags := make(map[string]bool), make(map[string]bool)
+	if repo := p.repository(accountID, region, repoName); repo != nil {
+		for _, img := range repo.Images {
+			digests[img.Digest] = true
+			for _, tag := range img.Tags {
+				tags[tag] = true
+			}
+		}
+		for _, img := range slices.Concat(repo.Quarantine, repo.Release) {
+			digests[img.Digest] = true
+		}
+	}
+	return func(img types.ImageDetail) bool {
+		return digests[aws.ToString(img.ImageDigest)] || slices.ContainsFunc(img.ImageTags, func(tag string) bool { return tags[tag] })
+	}
+}
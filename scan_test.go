@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// pagedClient serves a repository's images a page at a time
+type pagedClient struct {
+	*MockECRClient
+	images   []types.ImageDetail
+	pageSize int
+	pages    int
+}
+
+func (c *pagedClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	start := 0
+	if params.NextToken != nil {
+		fmt.Sscan(*params.NextToken, &start)
+	}
+	c.pages++
+	out := &ecr.DescribeImagesOutput{ImageDetails: c.images[start:min(start+c.pageSize, len(c.images))]}
+	if start+c.pageSize < len(c.images) {
+		out.NextToken = aws.String(fmt.Sprint(start + c.pageSize))
+	}
+	return out, nil
+}
+
+// TestScanRepositoryMatchesFullSelection tests that selecting from the
+// buffered candidates deletes exactly what selecting from every image does
+func TestScanRepositoryMatchesFullSelection(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	now := time.Now()
+	for trial := 0; trial < 50; trial++ {
+		var images []types.ImageDetail
+		for i := 0; i < 1+r.Intn(300); i++ {
+			img := types.ImageDetail{ImageDigest: aws.String(fmt.Sprintf("sha256:%04d", i)), ImageSizeInBytes: aws.Int64(10)}
+			if r.Intn(20) > 0 {
+				// Distinct times, since the order of ties is arbitrary
+				img.ImagePushedAt = aws.Time(now.Add(-time.Duration(r.Intn(60*24))*time.Hour - time.Duration(i)*time.Second))
+			}
+			images = append(images, img)
+		}
+		cfg := Config{Days: r.Intn(60), MaxImages: r.Intn(100), SkipListImages: true}
+
+		client := &pagedClient{MockECRClient: &MockECRClient{}, images: images, pageSize: 1 + r.Intn(100)}
+		scan, err := scanRepository(context.Background(), client, "repo", cfg, cfg)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		got := digestsOf(scan.selectCandidates(cfg))
+		want := digestsOf(selectImagesForDeletion(slices.Clone(images), cfg))
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("Trial %d (%+v): expected %v, got %v", trial, cfg, want, got)
+		}
+		if scan.images != len(images) || scan.size != int64(10*len(images)) {
+			t.Errorf("Expected %d images scanned, got %d", len(images), scan.images)
+		}
+	}
+}
+
+// TestScanRepositoryBuffersCandidates tests that only the images old enough
+// to be deleted are kept in memory
+func TestScanRepositoryBuffersCandidates(t *testing.T) {
+	now := time.Now()
+	var images []types.ImageDetail
+	for i := 0; i < 1000; i++ {
+		images = append(images, types.ImageDetail{
+			ImageDigest:   aws.String(fmt.Sprintf("sha256:%04d", i)),
+			ImagePushedAt: aws.Time(now.Add(-time.Duration(i) * time.Hour)),
+			ImageScanFindingsSummary: &types.ImageScanFindingsSummary{
+				FindingSeverityCounts: map[string]int32{"HIGH": 1},
+			},
+		})
+	}
+
+	// Images older than 30 days are the last 280 of 1000 hourly pushes
+	client := &pagedClient{MockECRClient: &MockECRClient{}, images: images, pageSize: 100}
+	cfg := Config{Days: 30, SkipListImages: true}
+	scan, err := scanRepository(context.Background(), client, "repo", cfg, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if client.pages != 10 || len(scan.candidates) != 280 || scan.newer != 720 {
+		t.Errorf("Expected 280 candidates over 10 pages, got %d candidates, %d newer, %d pages", len(scan.candidates), scan.newer, client.pages)
+	}
+	if scan.candidates[0].ImageScanFindingsSummary != nil {
+		t.Error("Expected the candidates compacted")
+	}
+	if aws.ToString(scan.newest.ImageDigest) != "sha256:0000" {
+		t.Errorf("Expected the newest image tracked, got %s", aws.ToString(scan.newest.ImageDigest))
+	}
+}
+
+// TestScanRepositoryPlanned tests that applying a plan buffers the planned
+// images and those carrying a planned tag
+func TestScanRepositoryPlanned(t *testing.T) {
+	now := time.Now()
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String("sha256:a"), ImageTags: []string{"v1"}, ImagePushedAt: aws.Time(now)},
+		{ImageDigest: aws.String("sha256:b"), ImageTags: []string{"v2"}, ImagePushedAt: aws.Time(now)},
+		{ImageDigest: aws.String("sha256:c"), ImageTags: []string{"v3"}, ImagePushedAt: aws.Time(now)},
+	}
+	plan := &Plan{Repositories: []PlanRepository{{Name: "repo", Images: []PlanImage{
+		{Digest: "sha256:a", Tags: []string{"v1"}},
+		{Digest: "sha256:z", Tags: []string{"v3"}},
+	}}}}
+
+	client := &pagedClient{MockECRClient: &MockECRClient{}, images: images, pageSize: 2}
+	cfg := Config{SkipListImages: true, plan: plan}
+	scan, err := scanRepository(context.Background(), client, "repo", cfg, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := digestsOf(scan.candidates); !slices.Equal(got, []string{"sha256:a", "sha256:c"}) {
+		t.Errorf("Expected the planned image and the re-pushed tag, got %v", got)
+	}
+}
+
+// digestsOf returns the digests of the images
+func digestsOf(images []types.ImageDetail) []string {
+	var digests []string
+	for _, img := range images {
+		digests = append(digests, aws.ToString(img.ImageDigest))
+	}
+	return digests
+}
+
+// listingClient lists image IDs a page at a time and records how many
+// DescribeImages calls run at once
+type listingClient struct {
+	*MockECRClient
+	ids      []types.ImageIdentifier
+	listErr  error
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (c *listingClient) ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	start := 0
+	if params.NextToken != nil {
+		if c.listErr != nil {
+			return nil, c.listErr
+		}
+		fmt.Sscan(*params.NextToken, &start)
+	}
+	size := int(aws.ToInt32(params.MaxResults))
+	out := &ecr.ListImagesOutput{ImageIds: c.ids[start:min(start+size, len(c.ids))]}
+	if start+size < len(c.ids) {
+		out.NextToken = aws.String(fmt.Sprint(start + size))
+	}
+	return out, nil
+}
+
+func (c *listingClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	c.mu.Lock()
+	c.inFlight++
+	c.peak = max(c.peak, c.inFlight)
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	out := &ecr.DescribeImagesOutput{}
+	for _, id := range params.ImageIds {
+		out.ImageDetails = append(out.ImageDetails, types.ImageDetail{ImageDigest: id.ImageDigest})
+	}
+	return out, nil
+}
+
+// TestScanImagePagesConcurrently tests describing each page of image IDs in
+// parallel batches, in order
+func TestScanImagePagesConcurrently(t *testing.T) {
+	client := &listingClient{MockECRClient: &MockECRClient{}}
+	for i := 0; i < 2500; i++ {
+		client.ids = append(client.ids, types.ImageIdentifier{ImageDigest: aws.String(fmt.Sprintf("sha256:%04d", i))})
+	}
+
+	images, err := scanAllImages(context.Background(), client, "repo", Config{DescribeConcurrency: 5})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(images) != 2500 || aws.ToString(images[1234].ImageDigest) != "sha256:1234" {
+		t.Fatalf("Expected every image in order, got %d", len(images))
+	}
+	if client.peak != 5 {
+		t.Errorf("Expected 5 batches described at once, got %d", client.peak)
+	}
+
+	// A listing error past the first page fails the scan
+	client.listErr = errors.New("throttled")
+	if _, err := scanAllImages(context.Background(), client, "repo", Config{DescribeConcurrency: 5}); err == nil || err.Error() != "throttled" {
+		t.Errorf("Expected the listing error, got %v", err)
+	}
+}
+
+// scanAllImages returns every image of a repository, a page at a time
+func scanAllImages(ctx context.Context, client ECRClient, repoName string, cfg Config) ([]types.ImageDetail, error) {
+	var images []types.ImageDetail
+	err := scanImagePages(ctx, client, repoName, cfg, func(page []types.ImageDetail) error {
+		images = append(images, page...)
+		return nil
+	})
+	return images, err
+}
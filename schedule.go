@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file contains the scheduler. With -schedule the process stays up and
+// runs the cleanup on a cron schedule, so a single long-lived container can
+// replace an external scheduler. A random jitter spreads out the runs of
+// many instances, and /healthz reports on the scheduler for probes.
+
+// defaultScheduleJitter is the default upper bound of the delay added to
+// each scheduled run
+const defaultScheduleJitter = time.Minute
+
+// cronSchedule is a parsed five-field cron expression. Each field is a
+// bitset of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar record unrestricted day fields, which change how
+	// the two day fields combine
+	domStar, dowStar bool
+}
+
+// cronField describes the range and value names of a cron field
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12,
+		names: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronDow = cronField{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cronMacros maps the supported @ shorthands to their expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCronSchedule parses a standard five-field cron expression (minute,
+// hour, day of month, month, day of week) or one of the @ shorthands
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	for i, target := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dom, cronDom},
+		{&s.month, cronMonth},
+		{&s.dow, cronDow},
+	} {
+		bits, err := parseCronField(fields[i], target.field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		*target.bits = bits
+	}
+
+	// Sunday may be written as 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// such as "*/15", "1-5" or "mon,wed,fri"
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, field.name)
+			}
+			step = n
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = field.min, field.max
+		case strings.Contains(rangePart, "-"):
+			loPart, hiPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = field.value(loPart); err != nil {
+				return 0, err
+			}
+			if hi, err = field.value(hiPart); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, field.name)
+			}
+		default:
+			n, err := field.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			// A single value with a step, like 5/15, runs to the end of the range
+			lo, hi = n, n
+			if hasStep {
+				hi = field.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or name within the field's range
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field: must be %d-%d", s, f.name, f.min, f.max)
+	}
+	return n, nil
+}
+
+// dayMatches reports whether t's day matches the schedule. As in cron, when
+// both day fields are restricted a day matching either one runs.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t that matches the schedule, or the
+// zero time if nothing matches within five years (e.g. "0 0 30 2 *")
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// scheduleJitter returns a random delay in [0, -schedule-jitter)
+func scheduleJitter(config Config) time.Duration {
+	if config.ScheduleJitter <= 0 {
+		return 0
+	}
+	return time.Duration(config.int63n(int64(config.ScheduleJitter)))
+}
+
+// schedulerHealth tracks the scheduler's state for the health endpoint
+type schedulerHealth struct {
+	mu        sync.Mutex
+	schedule  string
+	started   time.Time
+	running   bool
+	runs      int
+	failures  int
+	nextRun   time.Time
+	lastStart time.Time
+	lastEnd   time.Time
+	lastErr   error
+}
+
+// healthResponse is the JSON body of the health endpoint
+type healthResponse struct {
+	Status          string     `json:"status"`
+	Schedule        string     `json:"schedule"`
+	StartedAt       time.Time  `json:"started_at"`
+	Running         bool       `json:"running"`
+	Runs            int        `json:"runs"`
+	Failures        int        `json:"failures"`
+	NextRun         *time.Time `json:"next_run,omitempty"`
+	LastRunStarted  *time.Time `json:"last_run_started,omitempty"`
+	LastRunFinished *time.Time `json:"last_run_finished,omitempty"`
+	LastRunStatus   string     `json:"last_run_status,omitempty"`
+	LastRunError    string     `json:"last_run_error,omitempty"`
+}
+
+// scheduled records the time of the next run
+func (h *schedulerHealth) scheduled(next time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextRun = next
+}
+
+// runStarted records the start of a run
+func (h *schedulerHealth) runStarted(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = true
+	h.nextRun = time.Time{}
+	h.lastStart = now
+}
+
+// runFinished records the outcome of a run
+func (h *schedulerHealth) runFinished(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+	h.runs++
+	if err != nil {
+		h.failures++
+	}
+	h.lastEnd = now
+	h.lastErr = err
+}
+
+// response builds the health endpoint body. The scheduler is healthy while
+// it is alive; a failed run is reported but does not fail the probe, since
+// restarting the container would not fix it.
+func (h *schedulerHealth) response() healthResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resp := healthResponse{
+		Status:    "ok",
+		Schedule:  h.schedule,
+		StartedAt: h.started,
+		Running:   h.running,
+		Runs:      h.runs,
+		Failures:  h.failures,
+	}
+	if !h.nextRun.IsZero() {
+		next := h.nextRun
+		resp.NextRun = &next
+	}
+	if !h.lastStart.IsZero() {
+		start := h.lastStart
+		resp.LastRunStarted = &start
+	}
+	if !h.lastEnd.IsZero() {
+		end := h.lastEnd
+		resp.LastRunFinished = &end
+		resp.LastRunStatus = "succeeded"
+		if h.lastErr != nil {
+			resp.LastRunStatus = "failed"
+			resp.LastRunError = h.lastErr.Error()
+		}
+	}
+	return resp
+}
+
+// ServeHTTP writes the scheduler state as JSON
+func (h *schedulerHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.response())
+}
+
+// startHealthServer serves the health endpoint on addr in the background
+func startHealthServer(addr string, health *schedulerHealth) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+
+	slog.Info("Serving health checks", "url", "http://"+server.Addr+"/healthz")
+	return server, nil
+}
+
+// runSchedule runs the cleanup on the -schedule cron schedule until the
+// process receives SIGINT or SIGTERM. A run in progress is finished first.
+func runSchedule(config Config) int {
+	schedule, err := parseCronSchedule(config.Schedule)
+	if err != nil {
+		slog.Error("Invalid schedule", "error", err)
+		return 1
+	}
+
+	health := &schedulerHealth{schedule: config.Schedule, started: config.now()}
+	if config.HealthAddr != "" {
+		if _, err := startHealthServer(config.HealthAddr, health); err != nil {
+			slog.Error("Error starting health server", "error", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return runScheduleLoop(ctx, schedule, config, health, func() error {
+		_, err := runCleanup(config)
+		return err
+	})
+}
+
+// runScheduleLoop waits for each scheduled time plus jitter and runs the
+// cleanup, until ctx is cancelled. Runs never overlap: the next run is
+// scheduled after the previous one finishes.
+func runScheduleLoop(ctx context.Context, schedule *cronSchedule, config Config, health *schedulerHealth, run func() error) int {
+	for {
+		next := schedule.next(config.now())
+		if next.IsZero() {
+			slog.Error("Schedule never runs", "schedule", config.Schedule)
+			return 1
+		}
+		next = next.Add(scheduleJitter(config))
+		health.scheduled(next)
+		slog.Info("Next cleanup scheduled", "schedule", config.Schedule, "next_run", next.Format(time.RFC3339))
+
+		if err := sleepContext(ctx, next.Sub(config.now())); err != nil || ctx.Err() != nil {
+			slog.Info("Scheduler stopped")
+			return 0
+		}
+
+		health.runStarted(config.now())
+		err := run()
+		health.runFinished(config.now(), err)
+
+		if ctx.Err() != nil {
+			slog.Info("Scheduler stopped")
+			return 0
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestParseCronSchedule tests parsing valid and invalid cron expressions
+func TestParseCronSchedule(t *testing.T) {
+	for _, expr := range []string{"0 3 * * *", "*/15 * * * *", "0 9-17/2 * * mon-fri", "30 1 1,15 * *", "0 0 * JAN,jul 7", "@daily", "@Hourly"} {
+		if _, err := parseCronSchedule(expr); err != nil {
+			t.Errorf("Expected %q to parse, got %v", expr, err)
+		}
+	}
+
+	for _, expr := range []string{"", "0 3 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "0 0 * * funday", "@reboot"} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+// TestCronScheduleNext tests finding the next matching time
+func TestCronScheduleNext(t *testing.T) {
+	// Wednesday, 15 May 2024
+	from := time.Date(2024, 5, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 5, 16, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 15, 0, 0, time.UTC)},
+		{"7 10 * * *", time.Date(2024, 5, 16, 10, 7, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * *", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match either one
+		{"0 0 20 * fri", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		// Impossible dates never run
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		schedule, err := parseCronSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("Expected %q to parse, got %v", tt.expr, err)
+		}
+		if got := schedule.next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+}
+
+// TestScheduleJitter tests that jitter stays within its bound, and is
+// drawn from Config.Rand when set
+func TestScheduleJitter(t *testing.T) {
+	if got := scheduleJitter(Config{}); got != 0 {
+		t.Errorf("Expected no jitter, got %v", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := scheduleJitter(Config{ScheduleJitter: time.Second}); got < 0 || got >= time.Second {
+			t.Fatalf("Expected jitter in [0, 1s), got %v", got)
+		}
+	}
+
+	first := scheduleJitter(Config{ScheduleJitter: time.Hour, Rand: rand.New(rand.NewSource(42))})
+	if second := scheduleJitter(Config{ScheduleJitter: time.Hour, Rand: rand.New(rand.NewSource(42))}); first != second {
+		t.Errorf("Expected the same jitter from the same seed, got %v and %v", first, second)
+	}
+}
+
+// TestRunScheduleLoop tests running the cleanup repeatedly until cancelled
+func TestRunScheduleLoop(t *testing.T) {
+	schedule, _ := parseCronSchedule("* * * * *")
+
+	// Every call advances the clock a minute, so no wait is ever needed
+	clock := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+	now := func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	health := &schedulerHealth{schedule: "* * * * *"}
+	runs := 0
+	code := runScheduleLoop(ctx, schedule, Config{Schedule: "* * * * *", Clock: ClockFunc(now)}, health, func() error {
+		runs++
+		if runs == 3 {
+			cancel()
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if runs != 3 {
+		t.Errorf("Expected 3 runs, got %d", runs)
+	}
+
+	resp := health.response()
+	if resp.Runs != 3 || resp.Failures != 1 || resp.LastRunStatus != "failed" || resp.LastRunError != "boom" || resp.Running {
+		t.Errorf("Unexpected health: %+v", resp)
+	}
+}
+
+// TestHealthEndpoint tests serving the scheduler state
+func TestHealthEndpoint(t *testing.T) {
+	health := &schedulerHealth{schedule: "0 3 * * *", started: time.Now()}
+	health.scheduled(time.Date(2024, 5, 16, 3, 0, 0, 0, time.UTC))
+
+	server, err := startHealthServer("127.0.0.1:0", health)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr + "/healthz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var body healthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+	if body.Status != "ok" || body.Schedule != "0 3 * * *" || body.NextRun == nil || body.LastRunStatus != "" {
+		t.Errorf("Unexpected health: %+v", body)
+	}
+}
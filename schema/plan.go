@@ -0,0 +1,46 @@
+package schema
+
+import "time"
+
+// Plan is the set of images a dry run selected for deletion, as written by
+// the plan command and deleted by the apply command
+type Plan struct {
+	SchemaVersion int              `json:"schema_version"`
+	ID            string           `json:"id"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Images        int              `json:"images"`
+	SizeBytes     int64            `json:"size_bytes"`
+	Repositories  []PlanRepository `json:"repositories"`
+
+	// EmptyRepositories counts the repositories planned for deletion for
+	// being empty
+	EmptyRepositories int `json:"empty_repositories,omitempty"`
+}
+
+// PlanRepository holds the planned deletions of one repository. AccountID
+// is only set for multi-account runs.
+type PlanRepository struct {
+	AccountID string      `json:"account_id,omitempty"`
+	Region    string      `json:"region,omitempty"`
+	Name      string      `json:"name"`
+	Images    []PlanImage `json:"images"`
+
+	// DeleteRepository is set when the repository is empty and planned
+	// for deletion
+	DeleteRepository bool `json:"delete_repository,omitempty"`
+
+	// Quarantine lists the images -quarantine-days tags when the plan is
+	// applied, and Release the quarantined images whose quarantine tags it
+	// removes since they are no longer selected
+	Quarantine []PlanImage `json:"quarantine,omitempty"`
+	Release    []PlanImage `json:"release,omitempty"`
+}
+
+// PlanImage is an image planned for deletion. Applying the plan refuses a
+// repository if one of its images was re-pushed or re-tagged since.
+type PlanImage struct {
+	Digest    string    `json:"digest"`
+	Tags      []string  `json:"tags,omitempty"`
+	PushedAt  time.Time `json:"pushed_at"`
+	SizeBytes int64     `json:"size_bytes"`
+}
@@ -0,0 +1,57 @@
+package schema
+
+import "time"
+
+// Report is the JSON report of a cleanup run
+type Report struct {
+	SchemaVersion         int                `json:"schema_version"`
+	GeneratedAt           time.Time          `json:"generated_at"`
+	DryRun                bool               `json:"dry_run"`
+	Days                  int                `json:"days"`
+	OlderThan             string             `json:"older_than,omitempty"`
+	Before                *time.Time         `json:"before,omitempty"`
+	PushedAfter           *time.Time         `json:"pushed_after,omitempty"`
+	PushedBefore          *time.Time         `json:"pushed_before,omitempty"`
+	MaxImages             int                `json:"max_images"`
+	RepositoriesProcessed int                `json:"repositories_processed"`
+	ImagesScanned         int                `json:"images_scanned"`
+	ImagesDeleted         int                `json:"images_deleted"`
+	SpaceFreed            int64              `json:"space_freed_bytes"`
+	SpaceFreedUpperBound  int64              `json:"space_freed_upper_bound_bytes"`
+	MonthlySavings        float64            `json:"estimated_monthly_savings_usd"`
+	APICalls              []APICall          `json:"api_calls"`
+	Repositories          []ReportRepository `json:"repositories"`
+}
+
+// RunResult is the result of a run, as POSTed to the webhook, passed to the
+// post-run hook and returned by the Lambda handler and the serve command:
+// the report, with whether the run succeeded
+type RunResult struct {
+	// Status is succeeded or failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Failures counts the repositories that failed
+	Failures int `json:"failures"`
+	Report
+}
+
+// APICall is the statistics of one ECR operation during the run
+type APICall struct {
+	Operation string `json:"operation"`
+	Calls     int    `json:"calls"`
+	Throttles int    `json:"throttles"`
+	Retries   int    `json:"retries"`
+}
+
+// ReportRepository is the result of one repository. AccountID is only set
+// for multi-account runs, and Error only for repositories that failed.
+type ReportRepository struct {
+	AccountID      string  `json:"account_id,omitempty"`
+	Region         string  `json:"region,omitempty"`
+	Name           string  `json:"name"`
+	ImagesScanned  int     `json:"images_scanned"`
+	ImagesDeleted  int     `json:"images_deleted"`
+	SpaceFreed     int64   `json:"space_freed_bytes"`
+	MonthlySavings float64 `json:"estimated_monthly_savings_usd"`
+	Error          string  `json:"error,omitempty"`
+}
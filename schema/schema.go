@@ -0,0 +1,28 @@
+// Package schema defines the JSON documents ecr-cleanup writes for other
+// tools to consume: the deletion plans of the plan command and the JSON
+// report of a run, also POSTed to the webhook and returned by the Lambda
+// handler. Approval bots, dashboards and wrappers can decode them into these
+// types instead of copying the field names.
+//
+// Every document carries a schema_version. Within a version, fields are only
+// ever added, and a field a document omits has its zero value, so a
+// consumer that ignores unknown fields keeps working across releases.
+// Removing or renaming a field, or changing its type or meaning, increments
+// the version. A consumer should refuse a document whose version it doesn't
+// know:
+//
+//	var plan schema.Plan
+//	if err := json.Unmarshal(data, &plan); err != nil {
+//		return err
+//	}
+//	if plan.SchemaVersion != schema.PlanVersion {
+//		return fmt.Errorf("unsupported plan schema version %d", plan.SchemaVersion)
+//	}
+package schema
+
+// PlanVersion is the schema version of the plans this release writes
+const PlanVersion = 1
+
+// ReportVersion is the schema version of the JSON reports this release
+// writes
+const ReportVersion = 1
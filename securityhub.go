@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// This file contains the Security Hub import of the compliance command's
+// findings. The tool doesn't depend on the Security Hub SDK for a single
+// operation, so BatchImportFindings is called over its REST API with the
+// signed client of awsapi.go. Findings go to the account's
+// default product in AWS Security Finding Format (ASFF), one per
+// repository and check; a repository that complies again gets its finding
+// updated to PASSED, which resolves it.
+
+// securityHubBatchSize is the maximum number of findings
+// BatchImportFindings accepts per call
+const securityHubBatchSize = 100
+
+// asffFinding is a finding in AWS Security Finding Format
+type asffFinding struct {
+	SchemaVersion string         `json:"SchemaVersion"`
+	ID            string         `json:"Id"`
+	ProductArn    string         `json:"ProductArn"`
+	GeneratorID   string         `json:"GeneratorId"`
+	AwsAccountID  string         `json:"AwsAccountId"`
+	Types         []string       `json:"Types"`
+	CreatedAt     string         `json:"CreatedAt"`
+	UpdatedAt     string         `json:"UpdatedAt"`
+	Severity      asffSeverity   `json:"Severity"`
+	Title         string         `json:"Title"`
+	Description   string         `json:"Description"`
+	Resources     []asffResource `json:"Resources"`
+	Compliance    asffCompliance `json:"Compliance"`
+	RecordState   string         `json:"RecordState"`
+}
+
+// asffSeverity is the severity of a finding
+type asffSeverity struct {
+	Label string `json:"Label"`
+}
+
+// asffResource is the resource a finding is about
+type asffResource struct {
+	Type   string `json:"Type"`
+	ID     string `json:"Id"`
+	Region string `json:"Region"`
+}
+
+// asffCompliance is the result of the check behind a finding
+type asffCompliance struct {
+	Status string `json:"Status"`
+}
+
+// FindingsImporter imports findings into Security Hub
+type FindingsImporter interface {
+	BatchImportFindings(ctx context.Context, findings []asffFinding) error
+}
+
+// securityHubClient calls the Security Hub API of a region
+type securityHubClient struct {
+	api *signedAPIClient
+}
+
+// newSecurityHubClient creates the client of the AWS config's region,
+// honoring -endpoint-url
+func newSecurityHubClient(awsConfig aws.Config) *securityHubClient {
+	return &securityHubClient{api: newSignedAPIClient(awsConfig, "securityhub")}
+}
+
+// BatchImportFindings imports the findings, a batch at a time, failing if
+// any is rejected
+func (c *securityHubClient) BatchImportFindings(ctx context.Context, findings []asffFinding) error {
+	for i := 0; i < len(findings); i += securityHubBatchSize {
+		if err := c.importBatch(ctx, findings[i:min(i+securityHubBatchSize, len(findings))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importBatch makes one BatchImportFindings call
+func (c *securityHubClient) importBatch(ctx context.Context, findings []asffFinding) error {
+	body, err := json.Marshal(map[string][]asffFinding{"Findings": findings})
+	if err != nil {
+		return err
+	}
+	respBody, err := c.api.call(ctx, http.MethodPost, "/findings/import", map[string]string{"Content-Type": "application/json"}, body)
+	if err != nil {
+		return fmt.Errorf("failed to import findings: %w", err)
+	}
+
+	var out struct {
+		FailedCount    int `json:"FailedCount"`
+		FailedFindings []struct {
+			ID           string `json:"Id"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"FailedFindings"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return fmt.Errorf("invalid response importing findings: %w", err)
+	}
+	if out.FailedCount > 0 {
+		reason := ""
+		if len(out.FailedFindings) > 0 {
+			reason = ": " + out.FailedFindings[0].ID + ": " + out.FailedFindings[0].ErrorMessage
+		}
+		return fmt.Errorf("failed to import %d of %d findings%s", out.FailedCount, len(findings), reason)
+	}
+	return nil
+}
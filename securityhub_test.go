@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// TestSecurityHubImport tests importing findings in batches over the
+// signed REST API
+func TestSecurityHubImport(t *testing.T) {
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/findings/import" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/us-east-1/securityhub/aws4_request") {
+			t.Errorf("Expected a SigV4 signature for Security Hub, got %q", auth)
+		}
+		var body struct {
+			Findings []asffFinding `json:"Findings"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Invalid body: %v", err)
+		}
+		batches = append(batches, len(body.Findings))
+		fmt.Fprintf(w, `{"FailedCount":0,"SuccessCount":%d,"FailedFindings":[]}`, len(body.Findings))
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	}
+	findings := make([]asffFinding, 150)
+	if err := newSecurityHubClient(awsConfig).BatchImportFindings(context.Background(), findings); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(batches) != 2 || batches[0] != 100 || batches[1] != 50 {
+		t.Errorf("Expected batches of 100 and 50 findings, got %v", batches)
+	}
+}
+
+// TestSecurityHubImportFailures tests reporting rejected findings
+func TestSecurityHubImportFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"FailedCount":1,"SuccessCount":0,"FailedFindings":[{"Id":"f1","ErrorCode":"InvalidInput","ErrorMessage":"bad finding"}]}`))
+	}))
+	defer server.Close()
+
+	awsConfig := aws.Config{Region: "us-east-1", BaseEndpoint: aws.String(server.URL), Credentials: credentials.NewStaticCredentialsProvider("id", "secret", "")}
+	err := newSecurityHubClient(awsConfig).BatchImportFindings(context.Background(), []asffFinding{{ID: "f1"}})
+	if err == nil || !strings.Contains(err.Error(), "bad finding") {
+		t.Errorf("Expected the rejected finding to be reported, got %v", err)
+	}
+
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"not subscribed"}`, http.StatusForbidden)
+	}))
+	defer denied.Close()
+	awsConfig.BaseEndpoint = aws.String(denied.URL)
+	if err := newSecurityHubClient(awsConfig).BatchImportFindings(context.Background(), []asffFinding{{ID: "f1"}}); err == nil || !strings.Contains(err.Error(), "not subscribed") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file contains the serve command, an HTTP API for running the cleanup
+// from an internal portal. Clients trigger a dry run, review the plan it
+// produced, approve it to delete exactly those images, and query the run
+// history. Every endpoint requires the -api-token bearer token.
+
+const (
+	// maxRunHistory is how many runs the API remembers
+	maxRunHistory = 100
+
+	// apiShutdownTimeout bounds waiting for requests in flight on shutdown
+	apiShutdownTimeout = 10 * time.Second
+)
+
+// runRecord is an entry in the run history
+type runRecord struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	PlanID     string     `json:"plan_id,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Result     *runResult `json:"result,omitempty"`
+}
+
+// planResponse is the body of GET /v1/plan
+type planResponse struct {
+	Status     string `json:"status"`
+	ApplyRunID string `json:"apply_run_id,omitempty"`
+	*Plan
+}
+
+// approveRequest is the body of POST /v1/plan/approve. The plan ID must
+// match the latest plan, so a plan can't be approved without being seen.
+type approveRequest struct {
+	PlanID string `json:"plan_id"`
+}
+
+// apiServer holds the state behind the API. Only one run happens at a time.
+type apiServer struct {
+	cfg Config
+	run func(Config) (CleanupSummary, error)
+
+	mu         sync.Mutex
+	active     bool
+	plan       *Plan
+	applyRunID string
+	history    []*runRecord
+
+	// runs tracks runs in progress so shutdown can wait for them
+	runs sync.WaitGroup
+}
+
+// newAPIServer creates the API state for the configuration
+func newAPIServer(cfg Config, run func(Config) (CleanupSummary, error)) *apiServer {
+	return &apiServer{cfg: cfg, run: run}
+}
+
+// handler returns the API routes behind the bearer token check
+func (s *apiServer) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/runs", s.handleTriggerDryRun)
+	mux.HandleFunc("GET /v1/runs", s.handleListRuns)
+	mux.HandleFunc("GET /v1/runs/{id}", s.handleGetRun)
+	mux.HandleFunc("GET /v1/plan", s.handleGetPlan)
+	mux.HandleFunc("POST /v1/plan/approve", s.handleApprovePlan)
+	return requireBearerToken(token, mux)
+}
+
+// requireBearerToken rejects requests without the expected bearer token
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ecr-cleanup"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes an error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handleTriggerDryRun starts a dry run whose plan replaces the latest one
+func (s *apiServer) handleTriggerDryRun(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfg
+	cfg.DryRun = true
+
+	record, err := s.start("dry-run", cfg)
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, record)
+}
+
+// handleApprovePlan starts deleting the images of the latest plan
+func (s *apiServer) handleApprovePlan(w http.ResponseWriter, r *http.Request) {
+	var req approveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PlanID == "" {
+		writeAPIError(w, http.StatusBadRequest, `request body must be {"plan_id": "..."}`)
+		return
+	}
+
+	s.mu.Lock()
+	plan := s.plan
+	s.mu.Unlock()
+
+	if plan == nil || plan.ID != req.PlanID {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("plan %s is not the latest plan", req.PlanID))
+		return
+	}
+
+	cfg := s.cfg
+	cfg.DryRun = false
+	cfg.plan = plan
+
+	record, err := s.start("apply", cfg)
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, record)
+}
+
+// handleListRuns returns the run history, newest first
+func (s *apiServer) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	runs := make([]runRecord, 0, len(s.history))
+	for i := len(s.history) - 1; i >= 0; i-- {
+		runs = append(runs, *s.history[i])
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string][]runRecord{"runs": runs})
+}
+
+// handleGetRun returns one run of the history
+func (s *apiServer) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.history {
+		if record.ID == id {
+			writeJSON(w, http.StatusOK, *record)
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, "run "+id+" not found")
+}
+
+// handleGetPlan returns the plan of the latest successful dry run
+func (s *apiServer) handleGetPlan(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	plan, applyRunID := s.plan, s.applyRunID
+	s.mu.Unlock()
+
+	if plan == nil {
+		writeAPIError(w, http.StatusNotFound, "no plan yet; trigger a dry run first")
+		return
+	}
+
+	status := "pending"
+	if applyRunID != "" {
+		status = "approved"
+	}
+	writeJSON(w, http.StatusOK, planResponse{Status: status, ApplyRunID: applyRunID, Plan: plan})
+}
+
+// start records a new run and runs it in the background. Only one run
+// happens at a time, and a plan can only be applied once while it is the
+// latest plan.
+func (s *apiServer) start(runType string, cfg Config) (runRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active {
+		return runRecord{}, errors.New("a run is already in progress")
+	}
+
+	var planID string
+	if cfg.plan != nil {
+		planID = cfg.plan.ID
+		if s.plan != cfg.plan {
+			return runRecord{}, fmt.Errorf("plan %s is not the latest plan", planID)
+		}
+		if s.applyRunID != "" {
+			return runRecord{}, fmt.Errorf("plan %s was already approved by run %s", planID, s.applyRunID)
+		}
+	}
+	s.active = true
+
+	record := &runRecord{
+		ID:        randomHexID(8),
+		Type:      runType,
+		Status:    "running",
+		PlanID:    planID,
+		StartedAt: time.Now().UTC(),
+	}
+	if runType == "apply" {
+		s.applyRunID = record.ID
+	}
+	s.history = append(s.history, record)
+	if len(s.history) > maxRunHistory {
+		s.history = s.history[len(s.history)-maxRunHistory:]
+	}
+
+	slog.Info("Starting run", "run_id", record.ID, "type", runType, "plan_id", planID)
+	s.runs.Add(1)
+	go s.execute(record, cfg)
+
+	return *record, nil
+}
+
+// execute runs the cleanup and records its outcome. A successful dry run
+// becomes the latest plan.
+func (s *apiServer) execute(record *runRecord, cfg Config) {
+	defer s.runs.Done()
+
+	summary, err := s.run(cfg)
+	result := newRunResult(summary, cfg, err)
+	finished := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = false
+	record.Status = result.Status
+	record.FinishedAt = &finished
+	record.Result = &result
+
+	if record.Type == "dry-run" && err == nil {
+		plan := newPlan(summary, record.StartedAt)
+		record.PlanID = plan.ID
+		s.plan = plan
+		s.applyRunID = ""
+	}
+}
+
+// runServe serves the API on -api-addr until the process receives SIGINT
+// or SIGTERM, then waits for a run in progress to finish
+func runServe(config Config) int {
+	if config.APIToken == "" {
+		slog.Error("The serve command requires -api-token or ECR_CLEANUP_API_TOKEN")
+		return 1
+	}
+
+	listener, err := net.Listen("tcp", config.APIAddr)
+	if err != nil {
+		slog.Error("Error starting API server", "error", err)
+		return 1
+	}
+
+	api := newAPIServer(config, runCleanup)
+	server := &http.Server{Handler: api.handler(config.APIToken), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("API server stopped", "error", err)
+		}
+	}()
+	slog.Info("Serving API", "url", "http://"+listener.Addr().String()+"/v1")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	slog.Info("Shutting down API server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	api.runs.Wait()
+
+	return 0
+}
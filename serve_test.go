@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testAPIRequest makes an authenticated API request and decodes the JSON
+// response into out
+func testAPIRequest(t *testing.T, server *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Expected a JSON response, got %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestAPIAuthentication tests that requests need the bearer token
+func TestAPIAuthentication(t *testing.T) {
+	api := newAPIServer(Config{}, func(Config) (CleanupSummary, error) { return CleanupSummary{}, nil })
+	server := httptest.NewServer(api.handler("secret"))
+	defer server.Close()
+
+	for _, header := range []string{"", "Bearer wrong", "secret", "Basic c2VjcmV0"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/runs", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected status 401, got %d", header, resp.StatusCode)
+		}
+	}
+}
+
+// TestAPIDryRunAndApprove tests the dry run, review and approval workflow
+func TestAPIDryRunAndApprove(t *testing.T) {
+	var runs []Config
+	api := newAPIServer(Config{Days: 30}, func(cfg Config) (CleanupSummary, error) {
+		runs = append(runs, cfg)
+		if cfg.plan != nil {
+			return CleanupSummary{ImagesDeleted: cfg.plan.Images}, nil
+		}
+		return testPlanSummary(), nil
+	})
+	server := httptest.NewServer(api.handler("secret"))
+	defer server.Close()
+
+	// No plan before the first dry run
+	if status := testAPIRequest(t, server, http.MethodGet, "/v1/plan", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 before a dry run, got %d", status)
+	}
+
+	// Trigger a dry run
+	var run runRecord
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/runs", "", &run); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	if run.Type != "dry-run" || run.Status != "running" {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+	api.runs.Wait()
+
+	var plan planResponse
+	if status := testAPIRequest(t, server, http.MethodGet, "/v1/plan", "", &plan); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if plan.Status != "pending" || plan.Images != 3 {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+
+	// Approving needs the ID of the latest plan
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/plan/approve", `{"plan_id": "other"}`, nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409 for another plan, got %d", status)
+	}
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/plan/approve", `{}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a plan ID, got %d", status)
+	}
+
+	var apply runRecord
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/plan/approve", `{"plan_id": "`+plan.ID+`"}`, &apply); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	api.runs.Wait()
+
+	// A plan is only applied once
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/plan/approve", `{"plan_id": "`+plan.ID+`"}`, nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409 for an approved plan, got %d", status)
+	}
+
+	if len(runs) != 2 || !runs[0].DryRun || runs[1].DryRun || runs[1].plan == nil || runs[1].plan.ID != plan.ID {
+		t.Errorf("Expected a dry run followed by applying the plan, got %+v", runs)
+	}
+
+	// The history lists the newest run first
+	var history struct {
+		Runs []runRecord `json:"runs"`
+	}
+	testAPIRequest(t, server, http.MethodGet, "/v1/runs", "", &history)
+	if len(history.Runs) != 2 || history.Runs[0].ID != apply.ID || history.Runs[1].ID != run.ID {
+		t.Fatalf("Unexpected history: %+v", history.Runs)
+	}
+	if history.Runs[0].Status != "succeeded" || history.Runs[0].PlanID != plan.ID || history.Runs[0].Result.ImagesDeleted != 3 {
+		t.Errorf("Unexpected apply run: %+v", history.Runs[0])
+	}
+
+	var got runRecord
+	if status := testAPIRequest(t, server, http.MethodGet, "/v1/runs/"+run.ID, "", &got); status != http.StatusOK || got.PlanID != plan.ID {
+		t.Errorf("Expected the dry run with its plan ID, got %d %+v", status, got)
+	}
+	if status := testAPIRequest(t, server, http.MethodGet, "/v1/runs/missing", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown run, got %d", status)
+	}
+
+	testAPIRequest(t, server, http.MethodGet, "/v1/plan", "", &plan)
+	if plan.Status != "approved" || plan.ApplyRunID != apply.ID {
+		t.Errorf("Expected the plan to be approved by the apply run, got %+v", plan)
+	}
+}
+
+// TestAPIOneRunAtATime tests that runs don't overlap and failed dry runs
+// don't replace the plan
+func TestAPIOneRunAtATime(t *testing.T) {
+	release := make(chan struct{})
+	api := newAPIServer(Config{}, func(Config) (CleanupSummary, error) {
+		<-release
+		return CleanupSummary{}, errors.New("boom")
+	})
+	server := httptest.NewServer(api.handler("secret"))
+	defer server.Close()
+
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/runs", "", nil); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	if status := testAPIRequest(t, server, http.MethodPost, "/v1/runs", "", nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409 while a run is in progress, got %d", status)
+	}
+	close(release)
+	api.runs.Wait()
+
+	var history struct {
+		Runs []runRecord `json:"runs"`
+	}
+	testAPIRequest(t, server, http.MethodGet, "/v1/runs", "", &history)
+	if len(history.Runs) != 1 || history.Runs[0].Status != "failed" || history.Runs[0].Result.Error != "boom" {
+		t.Errorf("Expected one failed run, got %+v", history.Runs)
+	}
+	if status := testAPIRequest(t, server, http.MethodGet, "/v1/plan", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected no plan after a failed dry run, got %d", status)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+// This file contains the accounting of images whose digest is shared.
+// SpaceFreed adds up the size of every image deleted, which is the logical
+// space freed. The same digest pushed to several repositories of a
+// registry shares its layers, though, so deleting it from each of them
+// frees its size once. Counting each digest once per registry gives an
+// upper bound of the space freed, which the savings estimate is priced on:
+// layers shared with images that are kept, in the same or another
+// repository, stay stored.
+
+// spaceFreedUpperBound returns the most bytes the deletions of the
+// repositories free, counting each digest once per registry
+func spaceFreedUpperBound(repos []RepositorySummary) int64 {
+	type registryDigest struct {
+		accountID, region, digest string
+	}
+	seen := make(map[registryDigest]bool)
+	var total int64
+	for _, repo := range repos {
+		// Removing tags frees no space
+		if repo.SpaceFreed == 0 {
+			continue
+		}
+		for _, img := range repo.Images {
+			key := registryDigest{repo.AccountID, repo.Region, img.Digest}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			total += img.SizeBytes
+		}
+	}
+	return total
+}
+
+// spaceFreedUpperBound returns the most bytes the run frees, counting each
+// digest once per registry
+func (s CleanupSummary) spaceFreedUpperBound() int64 {
+	return spaceFreedUpperBound(s.Repositories)
+}
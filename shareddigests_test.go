@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+// TestSpaceFreedUpperBound tests counting a digest deleted from several
+// repositories of a registry once
+func TestSpaceFreedUpperBound(t *testing.T) {
+	shared := ImageSummary{Digest: "sha256:111", SizeBytes: 1000}
+	other := ImageSummary{Digest: "sha256:222", SizeBytes: 500}
+	summary := CleanupSummary{
+		SpaceFreed: 4500,
+		Repositories: []RepositorySummary{
+			{Region: "us-east-1", Name: "app", SpaceFreed: 1500, Images: []ImageSummary{shared, other}},
+			{Region: "us-east-1", Name: "app-mirror", SpaceFreed: 1000, Images: []ImageSummary{shared}},
+			// The same digest in another region is stored again
+			{Region: "eu-west-1", Name: "app", SpaceFreed: 1000, Images: []ImageSummary{shared}},
+			// Removing tags frees nothing
+			{Region: "us-east-1", Name: "untagged", Images: []ImageSummary{{Digest: "sha256:333", SizeBytes: 700}}},
+		},
+	}
+	if got := summary.spaceFreedUpperBound(); got != 2500 {
+		t.Errorf("Expected 2500 bytes, got %d", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the handling of signatures, attestations and SBOMs
+// attached to images. They are stored as images of their own, either
+// tagged after the image they belong to (cosign's sha256-<hex>.sig, .att
+// and .sbom tags) or untagged with a subject in their manifest (OCI 1.1
+// referrers). Left to the retention policy they would count toward
+// -max-images and be deleted apart from their image, so the retention
+// policy only sees the images themselves, and artifacts are deleted along
+// with the image they belong to.
+
+// cosignTag matches the tags cosign gives the artifacts of an image
+var cosignTag = regexp.MustCompile(`^sha256-([0-9a-f]{64})\.(sig|att|sbom)$`)
+
+// Config media types of container images, which are never artifacts
+var imageConfigMediaTypes = map[string]bool{
+	"application/vnd.docker.container.image.v1+json": true,
+	"application/vnd.oci.image.config.v1+json":       true,
+}
+
+// imageArtifacts maps the digest of each artifact of a repository to the
+// digest of the image it belongs to
+type imageArtifacts map[string]string
+
+// findArtifacts finds the artifacts among a repository's images. Untagged
+// images that aren't container images have their manifest read through
+// client to find their subject; when client is nil or the manifests can't
+// be read, only cosign's tags are recognized.
+func findArtifacts(ctx context.Context, client ImageManifestClient, repoName string, images []types.ImageDetail) imageArtifacts {
+	artifacts := make(imageArtifacts)
+	var candidates []types.ImageIdentifier
+	for _, img := range images {
+		digest := aws.ToString(img.ImageDigest)
+		if subject := cosignSubject(img.ImageTags); subject != "" {
+			artifacts[digest] = subject
+			continue
+		}
+		if len(img.ImageTags) == 0 && aws.ToString(img.ArtifactMediaType) != "" && !imageConfigMediaTypes[aws.ToString(img.ArtifactMediaType)] {
+			candidates = append(candidates, types.ImageIdentifier{ImageDigest: img.ImageDigest})
+		}
+	}
+	if client == nil || len(candidates) == 0 {
+		return artifacts
+	}
+
+	for i := 0; i < len(candidates); i += describeImagesBatchSize {
+		resp, err := client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			RepositoryName:     aws.String(repoName),
+			ImageIds:           candidates[i:min(i+describeImagesBatchSize, len(candidates))],
+			AcceptedMediaTypes: []string{mediaTypeDockerManifest, mediaTypeOCIManifest, mediaTypeOCIIndex},
+		})
+		if err != nil {
+			slog.Warn("Failed to read artifact manifests; only cosign tags identify signatures", "repository", repoName, "error", err)
+			return artifacts
+		}
+		for _, image := range resp.Images {
+			var m struct {
+				Subject *descriptor `json:"subject"`
+			}
+			if json.Unmarshal([]byte(aws.ToString(image.ImageManifest)), &m) == nil && m.Subject != nil && m.Subject.Digest != "" {
+				artifacts[aws.ToString(image.ImageId.ImageDigest)] = m.Subject.Digest
+			}
+		}
+	}
+	return artifacts
+}
+
+// cosignSubject returns the digest of the image that an artifact's cosign
+// tags point at, or "" unless every tag is a cosign tag of one image
+func cosignSubject(tags []string) string {
+	var subject string
+	for _, tag := range tags {
+		match := cosignTag.FindStringSubmatch(tag)
+		if match == nil || (subject != "" && subject != "sha256:"+match[1]) {
+			return ""
+		}
+		subject = "sha256:" + match[1]
+	}
+	return subject
+}
+
+// isArtifact reports whether an image is an artifact of another image
+func (a imageArtifacts) isArtifact(img types.ImageDetail) bool {
+	_, ok := a[aws.ToString(img.ImageDigest)]
+	return ok
+}
+
+// withArtifacts adds to the images selected for deletion the artifacts of
+// those images, recursively since signatures can themselves be signed.
+// Artifacts whose image isn't present in the repository are added when
+// agedOut says they are old enough, whatever -max-images says.
+func (a imageArtifacts) withArtifacts(repoName string, images []types.ImageDetail, present map[string]bool, toDelete []types.ImageDetail, agedOut func(*time.Time) bool) []types.ImageDetail {
+	if len(a) == 0 {
+		return toDelete
+	}
+
+	deleted := make(map[string]bool, len(toDelete))
+	for _, img := range toDelete {
+		deleted[aws.ToString(img.ImageDigest)] = true
+	}
+
+	for added := true; added; {
+		added = false
+		for _, img := range images {
+			digest := aws.ToString(img.ImageDigest)
+			subject, ok := a[digest]
+			if !ok || deleted[digest] {
+				continue
+			}
+			switch {
+			case deleted[subject]:
+				slog.Info("Deleting artifact along with its image", "repository", repoName, "artifact", getImageTag(img), "image", subject)
+			case !present[subject] && agedOut(img.ImagePushedAt):
+				slog.Info("Deleting artifact of an image that no longer exists", "repository", repoName, "artifact", getImageTag(img), "image", subject)
+			default:
+				continue
+			}
+			toDelete = append(toDelete, img)
+			deleted[digest] = true
+			added = true
+		}
+	}
+	return toDelete
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// testDigest returns a digest made of one repeated hex character
+func testDigest(c string) string {
+	return "sha256:" + strings.Repeat(c, 64)
+}
+
+// cosignTagOf returns the cosign tag of an artifact of the image
+func cosignTagOf(digest, suffix string) string {
+	return strings.Replace(digest, ":", "-", 1) + "." + suffix
+}
+
+// mockReferrerClient returns manifests with the given subjects
+type mockReferrerClient struct {
+	subjects map[string]string
+	err      error
+}
+
+func (m *mockReferrerClient) BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range params.ImageIds {
+		body := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+		if subject, ok := m.subjects[aws.ToString(id.ImageDigest)]; ok {
+			body = `{"schemaVersion":2,"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + subject + `","size":1}}`
+		}
+		out.Images = append(out.Images, types.Image{ImageId: &types.ImageIdentifier{ImageDigest: id.ImageDigest}, ImageManifest: aws.String(body)})
+	}
+	return out, nil
+}
+
+// TestCosignSubject tests recognizing cosign's artifact tags
+func TestCosignSubject(t *testing.T) {
+	image, other := testDigest("a"), testDigest("b")
+	tests := []struct {
+		tags     []string
+		expected string
+	}{
+		{[]string{cosignTagOf(image, "sig")}, image},
+		{[]string{cosignTagOf(image, "att"), cosignTagOf(image, "sbom")}, image},
+		{[]string{cosignTagOf(image, "sig"), "latest"}, ""},
+		{[]string{cosignTagOf(image, "sig"), cosignTagOf(other, "sig")}, ""},
+		{[]string{"sha256-abc.sig"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := cosignSubject(tt.tags); got != tt.expected {
+			t.Errorf("cosignSubject(%v) = %q, want %q", tt.tags, got, tt.expected)
+		}
+	}
+}
+
+// TestFindArtifacts tests finding signatures by tag and referrers by subject
+func TestFindArtifacts(t *testing.T) {
+	image := testDigest("a")
+	images := []types.ImageDetail{
+		{ImageDigest: aws.String(image), ImageTags: []string{"v1"}, ArtifactMediaType: aws.String("application/vnd.oci.image.config.v1+json")},
+		{ImageDigest: aws.String(testDigest("b")), ImageTags: []string{cosignTagOf(image, "sig")}},
+		{ImageDigest: aws.String(testDigest("c")), ArtifactMediaType: aws.String("application/spdx+json")},
+		{ImageDigest: aws.String(testDigest("d")), ArtifactMediaType: aws.String("application/vnd.docker.container.image.v1+json")},
+	}
+
+	client := &mockReferrerClient{subjects: map[string]string{testDigest("c"): image}}
+	artifacts := findArtifacts(context.Background(), client, "api", images)
+	if len(artifacts) != 2 || artifacts[testDigest("b")] != image || artifacts[testDigest("c")] != image {
+		t.Errorf("Expected the signature and the SBOM referrer, got %v", artifacts)
+	}
+
+	// Without manifests, only cosign tags are recognized
+	artifacts = findArtifacts(context.Background(), &mockReferrerClient{err: errors.New("access denied")}, "api", images)
+	if len(artifacts) != 1 || artifacts[testDigest("b")] != image {
+		t.Errorf("Expected only the tagged signature, got %v", artifacts)
+	}
+}
+
+// TestSignaturesFollowTheirImage tests that artifacts don't count toward
+// -max-images and are deleted along with their image
+func TestSignaturesFollowTheirImage(t *testing.T) {
+	now := time.Now()
+	newest, old, oldest := testDigest("1"), testDigest("2"), testDigest("3")
+	detail := func(digest string, days int, tags ...string) types.ImageDetail {
+		return types.ImageDetail{ImageDigest: aws.String(digest), ImageTags: tags, ImagePushedAt: aws.Time(now.AddDate(0, 0, -days)), ImageSizeInBytes: aws.Int64(100)}
+	}
+	images := []types.ImageDetail{
+		detail(newest, 2, "v3"),
+		detail(testDigest("4"), 2, cosignTagOf(newest, "sig")),
+		detail(testDigest("5"), 1, cosignTagOf(newest, "att")),
+		detail(old, 30, "v2"),
+		detail(testDigest("6"), 30, cosignTagOf(old, "sig")),
+		detail(oldest, 40, "v1"),
+		detail(testDigest("9"), 40, cosignTagOf(oldest, "sig")),
+		detail(testDigest("7"), 50, cosignTagOf(testDigest("f"), "sig")),
+		detail(testDigest("8"), 2, cosignTagOf(testDigest("e"), "sig")),
+	}
+	client := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{{RepositoryName: aws.String("api")}}},
+		DescribeImagesOutput:       &ecr.DescribeImagesOutput{ImageDetails: images},
+		BatchDeleteImageOutput:     &ecr.BatchDeleteImageOutput{},
+	}
+
+	cfg := Config{Days: 10, MaxImages: 2, SkipListImages: true, artifactManifests: &mockReferrerClient{}}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var deleted []string
+	for _, id := range client.LastBatchDeleteImageInput.ImageIds {
+		deleted = append(deleted, getImageIdString(&id))
+	}
+	sort.Strings(deleted)
+	expected := []string{oldest, testDigest("7"), testDigest("9")}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") || summary.ImagesDeleted != 3 {
+		t.Errorf("Expected v1 with its signature and the old orphaned signature deleted, got %v", deleted)
+	}
+
+	// Artifacts are protected like the images they follow
+	cfg.ProtectTags = []string{cosignTagOf(oldest, "sig")}
+	client.BatchDeleteImageCalls = 0
+	summary, err = CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	deleted = nil
+	for _, id := range client.LastBatchDeleteImageInput.ImageIds {
+		deleted = append(deleted, getImageIdString(&id))
+	}
+	sort.Strings(deleted)
+	expected = []string{oldest, testDigest("7")}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") || summary.ImagesDeleted != 2 {
+		t.Errorf("Expected the protected signature kept, got %v", deleted)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains the simulate command, which helps pick a retention
+// policy before enabling deletions. It scans the registry once and reports
+// how many images and how much storage each combination of the candidate
+// -simulate-days and -simulate-max-images values would reclaim. Only the
+// retention itself is simulated: guardrails such as -min-keep, the
+// keep-list and in-use protection can only keep more images, so the
+// figures are an upper bound. Signatures and other artifacts, which follow
+// their image, aren't counted.
+
+// intList collects a comma-separated list of non-negative integers
+type intList []int
+
+// String returns the values as a comma-separated list
+func (l *intList) String() string {
+	values := make([]string, len(*l))
+	for i, n := range *l {
+		values[i] = strconv.Itoa(n)
+	}
+	return strings.Join(values, ",")
+}
+
+// Set replaces the values with a comma-separated list
+func (l *intList) Set(value string) error {
+	var values []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value %q: expected a non-negative number", field)
+		}
+		values = append(values, n)
+	}
+	*l = values
+	return nil
+}
+
+// SimulatedPolicy is what one candidate retention would reclaim
+type SimulatedPolicy struct {
+	Days                    int     `json:"days"`
+	MaxImages               int     `json:"max_images"`
+	Repositories            int     `json:"repositories"` // repositories it would delete images from
+	ImagesDeleted           int     `json:"images_deleted"`
+	SpaceFreed              int64   `json:"space_freed_bytes"`
+	EstimatedMonthlySavings float64 `json:"estimated_monthly_savings_usd"`
+}
+
+// simulatedPolicies returns every combination of the candidate values,
+// falling back to -days and -max-images when no candidates are given
+func simulatedPolicies(cfg Config) []SimulatedPolicy {
+	days, maxImages := []int(cfg.SimulateDays), []int(cfg.SimulateMaxImages)
+	if len(days) == 0 {
+		days = []int{cfg.Days}
+	}
+	if len(maxImages) == 0 {
+		maxImages = []int{cfg.MaxImages}
+	}
+
+	var policies []SimulatedPolicy
+	for _, d := range days {
+		for _, m := range maxImages {
+			policies = append(policies, SimulatedPolicy{Days: d, MaxImages: m})
+		}
+	}
+	return policies
+}
+
+// runSimulate runs the simulate command: it writes what each candidate
+// policy would reclaim to stdout
+func runSimulate(config Config) int {
+	ctx := context.Background()
+
+	awsConfig, err := loadRunAWSConfig(ctx, config)
+	if err != nil {
+		slog.Error("Error simulating policies", "error", fmt.Errorf("failed to load AWS config: %w", err))
+		return 1
+	}
+	if config.Public {
+		awsConfig.Region = publicRegion
+		config.SkipListImages = true
+	}
+
+	client := newThrottledClient(newECRClient(awsConfig, config), config)
+	policies, err := simulatePolicies(ctx, client, config, time.Now())
+	if err != nil {
+		slog.Error("Error simulating policies", "error", err)
+		return 1
+	}
+
+	if strings.EqualFold(config.LogFormat, "json") {
+		err = writeSimulationJSON(os.Stdout, policies)
+	} else {
+		err = writeSimulationTable(os.Stdout, policies)
+	}
+	if err != nil {
+		slog.Error("Error writing simulation", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// simulatePolicies scans every repository once and applies each candidate
+// policy to it
+func simulatePolicies(ctx context.Context, client ECRClient, cfg Config, now time.Time) ([]SimulatedPolicy, error) {
+	repos, err := getRepositories(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	repos = scopeRepositories(repos, cfg.Repositories)
+
+	policies := simulatedPolicies(cfg)
+
+	// The smallest age limit buffers the candidates of every policy
+	scanRetention := cfg.withDays(slices.MinFunc(policies, func(a, b SimulatedPolicy) int { return a.Days - b.Days }).Days)
+
+	var mu sync.Mutex
+	var firstErr error
+	runConcurrently(repos, cfg.Concurrency, func(repo types.Repository) {
+		repoName := aws.ToString(repo.RepositoryName)
+		scan, err := scanRepository(ctx, client, repoName, cfg, scanRetention)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to scan repository %s: %w", repoName, err)
+			}
+			return
+		}
+		for i := range policies {
+			selected := scan.simulate(policies[i], now)
+			if len(selected) == 0 {
+				continue
+			}
+			policies[i].Repositories++
+			policies[i].ImagesDeleted += len(selected)
+			for _, img := range selected {
+				policies[i].SpaceFreed += aws.ToInt64(img.ImageSizeInBytes)
+			}
+		}
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	for i := range policies {
+		policies[i].EstimatedMonthlySavings = roundUSD(monthlySavings(policies[i].SpaceFreed, cfg.StoragePrice))
+	}
+	return policies, nil
+}
+
+// simulate returns the images a policy would select from a scan made with
+// an age limit no larger than the policy's
+func (s *repositoryScan) simulate(policy SimulatedPolicy, now time.Time) []types.ImageDetail {
+	cutoff := now.AddDate(0, 0, -policy.Days)
+	var candidates []types.ImageDetail
+	newer := s.newer
+	for _, img := range s.candidates {
+		if img.ImagePushedAt.Before(cutoff) {
+			candidates = append(candidates, img)
+		} else {
+			newer++
+		}
+	}
+
+	retention := Config{Days: policy.Days, MaxImages: policy.MaxImages}
+	if retention.MaxImages > 0 {
+		retention.MaxImages = max(retention.MaxImages-newer, 0)
+	}
+	return selectImagesForDeletion(candidates, retention)
+}
+
+// writeSimulationJSON writes the policies as JSON
+func writeSimulationJSON(w io.Writer, policies []SimulatedPolicy) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string][]SimulatedPolicy{"policies": policies})
+}
+
+// writeSimulationTable writes the policies as an aligned table
+func writeSimulationTable(w io.Writer, policies []SimulatedPolicy) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAYS\tMAX IMAGES\tREPOSITORIES\tIMAGES\tFREED\tSAVINGS/MONTH")
+	for _, p := range policies {
+		maxImages := "-"
+		if p.MaxImages > 0 {
+			maxImages = strconv.Itoa(p.MaxImages)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\n",
+			p.Days, maxImages, p.Repositories, p.ImagesDeleted, formatBytes(p.SpaceFreed), formatUSD(p.EstimatedMonthlySavings))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSimulatedPolicies tests combining the candidate values
+func TestSimulatedPolicies(t *testing.T) {
+	cfg, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-simulate-days", "7, 14,30", "-max-images", "5"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []SimulatedPolicy{{Days: 7, MaxImages: 5}, {Days: 14, MaxImages: 5}, {Days: 30, MaxImages: 5}}
+	if policies := simulatedPolicies(cfg); !reflect.DeepEqual(policies, want) {
+		t.Errorf("Expected %+v, got %+v", want, policies)
+	}
+
+	if _, err := parseFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-simulate-max-images", "10,-1"}); err == nil {
+		t.Error("Expected a negative value to be rejected")
+	}
+}
+
+// TestSimulatePolicies tests evaluating every policy from a single scan
+func TestSimulatePolicies(t *testing.T) {
+	// Images are 30 to 34 days old
+	client := newGuardrailClient(2, 5)
+	cfg := Config{SimulateDays: intList{7, 31}, SimulateMaxImages: intList{0, 2}, StoragePrice: 0.10}
+
+	policies, err := simulatePolicies(context.Background(), client, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	deleted := map[[2]int]int{{7, 0}: 10, {7, 2}: 6, {31, 0}: 8, {31, 2}: 6}
+	for _, p := range policies {
+		if want := deleted[[2]int{p.Days, p.MaxImages}]; p.ImagesDeleted != want || p.SpaceFreed != int64(want)*1000 || p.Repositories != 2 {
+			t.Errorf("Expected %d images deleted from 2 repositories with %+v", want, p)
+		}
+	}
+	if n := client.DescribeImagesCalls; n != 2 {
+		t.Errorf("Expected each repository to be scanned once, got %d scans", n)
+	}
+
+	var out bytes.Buffer
+	if err := writeSimulationTable(&out, policies); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 || !strings.HasPrefix(lines[1], "7     -") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// This file contains the outputs meant for CI pipelines: -summary-file,
+// which writes the run's totals as JSON with a versioned schema that only
+// ever gains fields, and -gha-output, which sets them as GitHub Actions
+// step outputs. Both are written whether the run succeeded or not, so a
+// pipeline can always read how it went.
+
+// summaryFileVersion is the version of the summary file schema. Fields are
+// only added within a version; renaming or removing one bumps it.
+const summaryFileVersion = 1
+
+// summaryFile is the content of -summary-file
+type summaryFile struct {
+	Version               int      `json:"version"`
+	Status                string   `json:"status"` // succeeded or failed
+	Error                 string   `json:"error"`
+	DryRun                bool     `json:"dry_run"`
+	RepositoriesProcessed int      `json:"repositories_processed"`
+	RepositoriesDeleted   int      `json:"repositories_deleted"`
+	RepositoriesFailed    int      `json:"repositories_failed"`
+	ImagesScanned         int      `json:"images_scanned"`
+	ImagesDeleted         int      `json:"images_deleted"`
+	SpaceFreed            int64    `json:"space_freed_bytes"`
+	SpaceFreedMB          float64  `json:"space_freed_mb"`
+	SpaceFreedUpperBound  int64    `json:"space_freed_upper_bound_bytes"`
+	MonthlySavings        float64  `json:"estimated_monthly_savings_usd"`
+	Failures              []string `json:"failures"` // regions, accounts and replicas that failed
+}
+
+// newSummaryFile sums up the run for CI
+func newSummaryFile(summary CleanupSummary, cfg Config, runErr error) summaryFile {
+	file := summaryFile{
+		Version:               summaryFileVersion,
+		Status:                "succeeded",
+		DryRun:                cfg.DryRun,
+		RepositoriesProcessed: summary.RepositoriesProcessed,
+		RepositoriesDeleted:   summary.RepositoriesDeleted,
+		RepositoriesFailed:    len(summary.failedRepositories()),
+		ImagesScanned:         summary.ImagesScanned,
+		ImagesDeleted:         summary.ImagesDeleted,
+		SpaceFreed:            summary.SpaceFreed,
+		SpaceFreedMB:          roundMB(summary.SpaceFreed),
+		SpaceFreedUpperBound:  summary.spaceFreedUpperBound(),
+		MonthlySavings:        roundUSD(summary.EstimatedMonthlySavings),
+		Failures:              append([]string{}, summary.Failures...),
+	}
+	if runErr != nil {
+		file.Status = "failed"
+		file.Error = runErr.Error()
+	}
+	return file
+}
+
+// stepOutputs returns the GitHub Actions step outputs, in a fixed order
+func (f summaryFile) stepOutputs() [][2]string {
+	return [][2]string{
+		{"status", f.Status},
+		{"dry_run", fmt.Sprint(f.DryRun)},
+		{"repositories_processed", fmt.Sprint(f.RepositoriesProcessed)},
+		{"repositories_deleted", fmt.Sprint(f.RepositoriesDeleted)},
+		{"repositories_failed", fmt.Sprint(f.RepositoriesFailed)},
+		{"images_scanned", fmt.Sprint(f.ImagesScanned)},
+		{"images_deleted", fmt.Sprint(f.ImagesDeleted)},
+		{"space_freed_bytes", fmt.Sprint(f.SpaceFreed)},
+		{"space_freed_mb", fmt.Sprint(f.SpaceFreedMB)},
+		{"space_freed_upper_bound_bytes", fmt.Sprint(f.SpaceFreedUpperBound)},
+		{"estimated_monthly_savings_usd", fmt.Sprint(f.MonthlySavings)},
+	}
+}
+
+// writeSummaryOutputs writes the summary file and step outputs requested
+// in the configuration
+func writeSummaryOutputs(summary CleanupSummary, cfg Config, runErr error) error {
+	if cfg.SummaryFile == "" && !cfg.GHAOutput {
+		return nil
+	}
+	file := newSummaryFile(summary, cfg, runErr)
+
+	if cfg.SummaryFile != "" {
+		body, err := json.MarshalIndent(file, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cfg.SummaryFile, append(body, '\n'), 0o644); err != nil {
+			return fmt.Errorf("failed to write summary file: %w", err)
+		}
+	}
+
+	if cfg.GHAOutput {
+		if err := appendStepOutputs(os.Getenv("GITHUB_OUTPUT"), file.stepOutputs()); err != nil {
+			return fmt.Errorf("failed to set GitHub Actions outputs: %w", err)
+		}
+	}
+	return nil
+}
+
+// appendStepOutputs appends name=value lines to the $GITHUB_OUTPUT file
+func appendStepOutputs(path string, outputs [][2]string) error {
+	if path == "" {
+		return fmt.Errorf("GITHUB_OUTPUT is not set")
+	}
+
+	var b strings.Builder
+	for _, output := range outputs {
+		fmt.Fprintf(&b, "%s=%s\n", output[0], output[1])
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriteSummaryFile tests writing the totals as versioned JSON
+func TestWriteSummaryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	summary := testReportSummary()
+	summary.Failures = []string{"region eu-west-1: access denied"}
+
+	if err := writeSummaryOutputs(summary, Config{SummaryFile: path, DryRun: true}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the summary file written, got %v", err)
+	}
+
+	var file summaryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if file.Version != summaryFileVersion || file.Status != "succeeded" || !file.DryRun {
+		t.Errorf("Unexpected header: %+v", file)
+	}
+	if file.ImagesDeleted != 12 || file.SpaceFreedMB != 3 || file.RepositoriesFailed != 1 || len(file.Failures) != 1 {
+		t.Errorf("Unexpected totals: %+v", file)
+	}
+	if strings.Contains(string(data), `"repositories":`) {
+		t.Errorf("Expected only the totals, got:\n%s", data)
+	}
+
+	// A failed run still writes its totals
+	if err := writeSummaryOutputs(CleanupSummary{}, Config{SummaryFile: path}, errors.New("access denied")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), `"status": "failed"`) || !strings.Contains(string(data), `"failures": []`) {
+		t.Errorf("Expected a failed run with every field, got:\n%s", data)
+	}
+}
+
+// TestWriteGHAOutput tests appending the totals to the step outputs
+func TestWriteGHAOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "github_output")
+	os.WriteFile(path, []byte("previous=1\n"), 0o644)
+	t.Setenv("GITHUB_OUTPUT", path)
+
+	if err := writeSummaryOutputs(testReportSummary(), Config{GHAOutput: true}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, line := range []string{"previous=1", "status=succeeded", "images_deleted=12", "space_freed_mb=3", "dry_run=false"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("Expected %q in the outputs, got:\n%s", line, data)
+		}
+	}
+
+	t.Setenv("GITHUB_OUTPUT", "")
+	if err := writeSummaryOutputs(testReportSummary(), Config{GHAOutput: true}, nil); err == nil {
+		t.Error("Expected an error without GITHUB_OUTPUT")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// This file contains the per-repository table printed at the end of a run.
+// With text logs it is a plain table on stderr; with JSON logs it is a
+// single record holding every repository, like the JSON report.
+
+// sortRepositoriesBySpaceFreed returns the repositories sorted by space
+// freed, largest first, then by name
+func sortRepositoriesBySpaceFreed(repos []RepositorySummary) []RepositorySummary {
+	sorted := append([]RepositorySummary(nil), repos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].SpaceFreed != sorted[j].SpaceFreed {
+			return sorted[i].SpaceFreed > sorted[j].SpaceFreed
+		}
+		return sorted[i].qualifiedName() < sorted[j].qualifiedName()
+	})
+	return sorted
+}
+
+// printRepositoryTable prints the results of every repository at the end
+// of a run, unless info logging is off
+func printRepositoryTable(summary CleanupSummary, config Config) {
+	if len(summary.Repositories) == 0 || !slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+
+	repos := sortRepositoriesBySpaceFreed(summary.Repositories)
+	if strings.EqualFold(config.LogFormat, "json") {
+		rows := make([]jsonRepository, len(repos))
+		for i, repo := range repos {
+			rows[i] = newJSONRepository(repo)
+		}
+		slog.Info("Repository summary", "repositories", rows)
+		return
+	}
+
+	if err := writeRepositoryTable(os.Stderr, repos); err != nil {
+		slog.Warn("Error printing repository summary", "error", err)
+	}
+}
+
+// writeRepositoryTable writes the repositories as an aligned table
+func writeRepositoryTable(w io.Writer, repos []RepositorySummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSCANNED\tDELETED\tFREED\tSAVINGS/MONTH\tERROR")
+	for _, repo := range repos {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n",
+			repo.qualifiedName(), repo.ImagesScanned, repo.ImagesDeleted,
+			formatMB(repo.SpaceFreed), formatUSD(repo.EstimatedMonthlySavings), repo.Error)
+	}
+	return tw.Flush()
+}
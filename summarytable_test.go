@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// TestWriteRepositoryTable tests the table printed at the end of a run
+func TestWriteRepositoryTable(t *testing.T) {
+	var b strings.Builder
+	if err := writeRepositoryTable(&b, sortRepositoriesBySpaceFreed(testReportSummary().Repositories)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	expected := []string{
+		"REPOSITORY  SCANNED  DELETED  FREED    SAVINGS/MONTH  ERROR",
+		"big         15       10       2.00 MB  $0.00",
+		"small       10       2        1.00 MB  $0.00",
+		"<broken>    5        0        0.00 MB  $0.00          access denied | retry",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected), b.String())
+	}
+	for i, want := range expected {
+		if strings.TrimRight(lines[i], " ") != want {
+			t.Errorf("Line %d: expected %q, got %q", i, want, lines[i])
+		}
+	}
+}
+
+// TestSortRepositoriesBySpaceFreed tests ordering ties by name
+func TestSortRepositoriesBySpaceFreed(t *testing.T) {
+	repos := sortRepositoriesBySpaceFreed([]RepositorySummary{
+		{Name: "b"}, {Name: "c", SpaceFreed: 5}, {Name: "a"},
+	})
+	if repos[0].Name != "c" || repos[1].Name != "a" || repos[2].Name != "b" {
+		t.Errorf("Expected c, a, b, got %v", repos)
+	}
+}
+
+// TestPrintRepositoryTableJSON tests the table as a single JSON record
+func TestPrintRepositoryTableJSON(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := newLogHandler(&buf, "info", "json")
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	defer slog.SetDefault(previous)
+
+	printRepositoryTable(testReportSummary(), Config{LogFormat: "json"})
+
+	var record struct {
+		Msg          string           `json:"msg"`
+		Repositories []jsonRepository `json:"repositories"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q", buf.String())
+	}
+	if record.Msg != "Repository summary" || len(record.Repositories) != 3 || record.Repositories[0].Name != "big" {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -keep-per-tag-family, which keeps the newest image of
+// every family of tags, however old. A branch or release line such as
+// release-1.4 may stop being pushed, so an age limit would otherwise delete
+// every image of it. -tag-family-pattern finds a tag's family: by default
+// it is the tag up to its last dash, so main-41 and main-42 are both of the
+// main family and only the newest of them is kept. Tags the pattern doesn't
+// match, such as commit hashes, versions or latest, belong to no family, as
+// do the tags -quarantine-days adds.
+
+// defaultTagFamilyPattern is the default of -tag-family-pattern: the tag up
+// to its last dash
+const defaultTagFamilyPattern = `^(.+)-[^-]+$`
+
+// compileTagFamilyPattern compiles -tag-family-pattern, or the default
+// when it is empty
+func compileTagFamilyPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = defaultTagFamilyPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -tag-family-pattern: %w", err)
+	}
+	return re, nil
+}
+
+// tagFamily returns the family of a tag: the first group of the pattern, or
+// its whole match without groups. Tags without a family return false.
+func tagFamily(pattern *regexp.Regexp, tag string) (string, bool) {
+	if strings.HasPrefix(tag, quarantineTagPrefix) {
+		return "", false
+	}
+	match := pattern.FindStringSubmatch(tag)
+	if match == nil {
+		return "", false
+	}
+	family := match[0]
+	if len(match) > 1 {
+		family = match[1]
+	}
+	return family, family != ""
+}
+
+// tagFamilies tracks the newest image of each tag family of a repository
+type tagFamilies struct {
+	pattern *regexp.Regexp
+	newest  map[string]types.ImageDetail
+}
+
+// newTagFamilies creates the tag families of a repository found with
+// -tag-family-pattern
+func newTagFamilies(pattern string) (*tagFamilies, error) {
+	re, err := compileTagFamilyPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &tagFamilies{pattern: re, newest: make(map[string]types.ImageDetail)}, nil
+}
+
+// add records an image under the families of its tags, if it is the
+// newest of them so far
+func (f *tagFamilies) add(img types.ImageDetail) {
+	if img.ImagePushedAt == nil {
+		return
+	}
+	for _, tag := range img.ImageTags {
+		family, ok := tagFamily(f.pattern, tag)
+		if !ok {
+			continue
+		}
+		if newest, ok := f.newest[family]; !ok || img.ImagePushedAt.After(*newest.ImagePushedAt) {
+			f.newest[family] = img
+		}
+	}
+}
+
+// exclude returns the images that aren't the newest of any tag family
+func (f *tagFamilies) exclude(repoName string, images []types.ImageDetail) []types.ImageDetail {
+	if f == nil || len(f.newest) == 0 {
+		return images
+	}
+
+	newest := make(map[string]string, len(f.newest))
+	for family, img := range f.newest {
+		newest[aws.ToString(img.ImageDigest)] = family
+	}
+	var remaining []types.ImageDetail
+	for _, img := range images {
+		if family, ok := newest[aws.ToString(img.ImageDigest)]; ok {
+			slog.Info("Keeping newest image of tag family", "action", "keep", "repository", repoName, "family", family, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+			continue
+		}
+		remaining = append(remaining, img)
+	}
+	return remaining
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestTagFamily tests finding the family of a tag
+func TestTagFamily(t *testing.T) {
+	pattern, err := compileTagFamilyPattern("")
+	if err != nil {
+		t.Fatalf("Expected the default pattern to compile, got %v", err)
+	}
+	tests := map[string]string{
+		"main-42":                   "main",
+		"feature-auth-7":            "feature-auth",
+		"release-1.4-3":             "release-1.4",
+		"stable":                    "",
+		"latest":                    "",
+		"v1.2.3":                    "",
+		"3f9c2ab":                   "",
+		"-rc":                       "",
+		"main-":                     "",
+		"quarantine-20240601T0000Z": "",
+	}
+	for tag, want := range tests {
+		if got, ok := tagFamily(pattern, tag); got != want || ok != (want != "") {
+			t.Errorf("%s: expected %q, got %q", tag, want, got)
+		}
+	}
+
+	// The first group of a custom pattern is the family, or its whole match
+	for pattern, want := range map[string]string{`^(v\d+)\.`: "v1", `^v\d+`: "v1"} {
+		re, err := compileTagFamilyPattern(pattern)
+		if err != nil {
+			t.Fatalf("Expected %s to compile, got %v", pattern, err)
+		}
+		if got, _ := tagFamily(re, "v1.2.3"); got != want {
+			t.Errorf("%s: expected %q, got %q", pattern, want, got)
+		}
+	}
+	if _, err := compileTagFamilyPattern("("); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+// TestKeepPerTagFamily tests keeping the newest image of each tag family
+// past the cutoff
+func TestKeepPerTagFamily(t *testing.T) {
+	// Images are 30 to 34 days old, newest first
+	client := newGuardrailClient(1, 5)
+	details := client.DescribeImagesOutput.ImageDetails
+	details[0].ImageTags = []string{"main-42"}
+	details[1].ImageTags = []string{"main-41", "quarantine-20240601T0000Z"}
+	details[2].ImageTags = []string{"release-1.4-2"}
+	details[3].ImageTags = []string{"main-40", "v1"}
+	details[4].ImageTags = []string{"3f9c2ab", "latest"}
+
+	cfg := Config{Days: 10, KeepPerTagFamily: true}
+	_, toDelete, err := selectRepositoryImages(context.Background(), client, "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := strings.Join(digestsOf(toDelete), ","); got != "sha256:001,sha256:003,sha256:004" {
+		t.Errorf("Expected the newest image of each family kept, and undashed tags in no family, got %s", got)
+	}
+
+	// A custom pattern puts versions in families by major version
+	cfg.TagFamilyPattern = `^(v\d+)`
+	_, toDelete, err = selectRepositoryImages(context.Background(), client, "repo0", cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := strings.Join(digestsOf(toDelete), ","); got != "sha256:000,sha256:001,sha256:002,sha256:004" {
+		t.Errorf("Expected only the v1 image kept, got %s", got)
+	}
+
+	// Without the flag every image is selected
+	_, toDelete, err = selectRepositoryImages(context.Background(), client, "repo0", Config{Days: 10})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(toDelete) != 5 {
+		t.Errorf("Expected every image selected, got %v", digestsOf(toDelete))
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/smithy-go"
+)
+
+// This file contains the rate-limiting layer around the ECR client. Calls
+// that hit ECR's per-account TPS limits hardest (DescribeImages and
+// BatchDeleteImage) are paced, and throttled calls are retried with
+// exponential backoff while the pacing adapts to the observed throttling.
+// This layer is the only one retrying throttled calls: the SDK's retryer,
+// set by -aws-retry-mode and -aws-max-attempts, still retries the other
+// transient errors, but not throttling, so a throttled call is attempted
+// -throttle-max-attempts times rather than that times the SDK's attempts.
+
+const (
+	// defaultThrottleMaxAttempts is how often a throttled call is attempted
+	defaultThrottleMaxAttempts = 5
+
+	// throttleBaseDelay and throttleMaxDelay bound the retry backoff
+	throttleBaseDelay = 200 * time.Millisecond
+	throttleMaxDelay  = 20 * time.Second
+
+	// minThrottledInterval is the pacing applied after the first throttle
+	// when no explicit rate was configured
+	minThrottledInterval = 50 * time.Millisecond
+)
+
+// isThrottlingError reports whether ECR rejected the call for exceeding its rate limits
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ThrottlingException", "TooManyRequestsException", "RequestLimitExceeded":
+		return true
+	}
+	return false
+}
+
+// throttleRetryer is the SDK retryer of the calls the throttled client
+// makes: it retries what the SDK's retryer does, except throttling, which
+// the throttled client retries itself
+type throttleRetryer struct {
+	aws.Retryer
+}
+
+// IsErrorRetryable leaves throttling errors to the throttled client
+func (r throttleRetryer) IsErrorRetryable(err error) bool {
+	return !isThrottlingError(err) && r.Retryer.IsErrorRetryable(err)
+}
+
+// GetAttemptToken keeps the client-side rate limiting of adaptive mode
+func (r throttleRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if v2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return v2.GetAttemptToken(ctx)
+	}
+	return r.GetInitialToken(), nil
+}
+
+// withoutThrottleRetries appends the option that keeps the SDK from
+// retrying the throttling of a call to its options
+func withoutThrottleRetries(optFns []func(*ecr.Options)) []func(*ecr.Options) {
+	return append(optFns[:len(optFns):len(optFns)], func(o *ecr.Options) {
+		if o.Retryer != nil {
+			o.Retryer = throttleRetryer{o.Retryer}
+		}
+	})
+}
+
+// adaptiveLimiter spaces calls at least interval apart. The interval grows
+// when ECR throttles and shrinks back towards the configured rate on success.
+type adaptiveLimiter struct {
+	mu           sync.Mutex
+	baseInterval time.Duration
+	interval     time.Duration
+	next         time.Time
+}
+
+// newAdaptiveLimiter creates a limiter for the given rate in calls per
+// second; a rate of zero starts unpaced
+func newAdaptiveLimiter(rate float64) *adaptiveLimiter {
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	return &adaptiveLimiter{baseInterval: interval, interval: interval}
+}
+
+// reserve returns how long the caller must wait for its slot
+func (l *adaptiveLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return wait
+}
+
+// onThrottle slows the pace down
+func (l *adaptiveLimiter) onThrottle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval *= 2
+	if l.interval < minThrottledInterval {
+		l.interval = minThrottledInterval
+	}
+	if l.interval > throttleMaxDelay {
+		l.interval = throttleMaxDelay
+	}
+}
+
+// onSuccess speeds the pace back up towards the configured rate
+func (l *adaptiveLimiter) onSuccess() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= l.baseInterval {
+		return
+	}
+	l.interval -= l.interval / 10
+	if l.interval < l.baseInterval || l.interval < time.Millisecond {
+		l.interval = l.baseInterval
+	}
+}
+
+// throttledClient wraps an ECRClient with pacing and throttling retries
+type throttledClient struct {
+	client      ECRClient
+	limiter     *adaptiveLimiter
+	maxAttempts int
+	stats       *apiStats
+
+	// sleep waits for the given duration; replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newThrottledClient wraps the client using the rate and attempts from the configuration
+func newThrottledClient(client ECRClient, cfg Config) *throttledClient {
+	maxAttempts := cfg.ThrottleMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultThrottleMaxAttempts
+	}
+
+	return &throttledClient{
+		client:      client,
+		limiter:     newAdaptiveLimiter(cfg.APIRate),
+		maxAttempts: maxAttempts,
+		stats:       cfg.apiStats,
+		sleep:       sleepContext,
+	}
+}
+
+// sleepContext waits for the duration or until the context is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoffDelay returns the jittered exponential delay before the given retry
+func backoffDelay(attempt int) time.Duration {
+	delay := throttleBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > throttleMaxDelay {
+		delay = throttleMaxDelay
+	}
+	// Full jitter keeps concurrent workers from retrying in lockstep
+	return time.Duration(rand.Int63n(int64(delay))) + 1
+}
+
+// call runs fn, pacing it if requested and retrying it while ECR throttles
+func (c *throttledClient) call(ctx context.Context, operation string, paced bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if paced {
+			if err := c.sleep(ctx, c.limiter.reserve()); err != nil {
+				return err
+			}
+		}
+
+		err := fn()
+		if err == nil {
+			c.limiter.onSuccess()
+			return nil
+		}
+		if !isThrottlingError(err) || attempt >= c.maxAttempts {
+			return err
+		}
+
+		c.limiter.onThrottle()
+		c.stats.retried(operation)
+		delay := backoffDelay(attempt)
+		slog.Warn("ECR call was throttled, retrying", "operation", operation, "delay", delay.Round(time.Millisecond), "attempt", attempt+1, "max_attempts", c.maxAttempts)
+		if err := c.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// DescribeRepositories retries throttled calls
+func (c *throttledClient) DescribeRepositories(ctx context.Context, params *ecr.DescribeRepositoriesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error) {
+	var out *ecr.DescribeRepositoriesOutput
+	err := c.call(ctx, "DescribeRepositories", false, func() (err error) {
+		out, err = c.client.DescribeRepositories(ctx, params, withoutThrottleRetries(optFns)...)
+		return err
+	})
+	return out, err
+}
+
+// ListImages retries throttled calls
+func (c *throttledClient) ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	var out *ecr.ListImagesOutput
+	err := c.call(ctx, "ListImages", false, func() (err error) {
+		out, err = c.client.ListImages(ctx, params, withoutThrottleRetries(optFns)...)
+		return err
+	})
+	return out, err
+}
+
+// DescribeImages paces calls and retries throttled ones
+func (c *throttledClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	var out *ecr.DescribeImagesOutput
+	err := c.call(ctx, "DescribeImages", true, func() (err error) {
+		out, err = c.client.DescribeImages(ctx, params, withoutThrottleRetries(optFns)...)
+		return err
+	})
+	return out, err
+}
+
+// BatchDeleteImage paces calls and retries throttled ones
+func (c *throttledClient) BatchDeleteImage(ctx context.Context, params *ecr.BatchDeleteImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	var out *ecr.BatchDeleteImageOutput
+	err := c.call(ctx, "BatchDeleteImage", true, func() (err error) {
+		out, err = c.client.BatchDeleteImage(ctx, params, withoutThrottleRetries(optFns)...)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/smithy-go"
+)
+
+// FlakyECRClient returns throttling errors for the first N DescribeImages calls
+type FlakyECRClient struct {
+	MockECRClient
+	Throttles int
+}
+
+// DescribeImages fails with a throttling error until Throttles reaches zero
+func (f *FlakyECRClient) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	if f.Throttles > 0 {
+		f.Throttles--
+		f.DescribeImagesCalls++
+		return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	}
+	return f.MockECRClient.DescribeImages(ctx, params, optFns...)
+}
+
+// newTestThrottledClient creates a throttled client that records sleeps instead of sleeping
+func newTestThrottledClient(client ECRClient, cfg Config) (*throttledClient, *[]time.Duration) {
+	var sleeps []time.Duration
+	throttled := newThrottledClient(client, cfg)
+	throttled.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			sleeps = append(sleeps, d)
+		}
+		return nil
+	}
+	return throttled, &sleeps
+}
+
+// TestIsThrottlingError tests detection of ECR throttling errors
+func TestIsThrottlingError(t *testing.T) {
+	if !isThrottlingError(&smithy.GenericAPIError{Code: "ThrottlingException"}) {
+		t.Error("Expected ThrottlingException to be a throttling error")
+	}
+	if isThrottlingError(&types.RepositoryNotFoundException{Message: aws.String("missing")}) {
+		t.Error("Expected RepositoryNotFoundException not to be a throttling error")
+	}
+	if isThrottlingError(errors.New("connection reset")) {
+		t.Error("Expected a plain error not to be a throttling error")
+	}
+}
+
+// TestThrottledClientRetries tests retrying throttled calls
+func TestThrottledClientRetries(t *testing.T) {
+	t.Run("Succeeds after throttling", func(t *testing.T) {
+		flaky := &FlakyECRClient{
+			MockECRClient: MockECRClient{
+				DescribeImagesOutput: &ecr.DescribeImagesOutput{},
+			},
+			Throttles: 2,
+		}
+		client, sleeps := newTestThrottledClient(flaky, Config{})
+
+		_, err := client.DescribeImages(context.Background(), &ecr.DescribeImagesInput{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if flaky.DescribeImagesCalls != 3 {
+			t.Errorf("Expected 3 calls to DescribeImages, got %d", flaky.DescribeImagesCalls)
+		}
+		if len(*sleeps) < 2 {
+			t.Errorf("Expected backoff sleeps between attempts, got %v", *sleeps)
+		}
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		flaky := &FlakyECRClient{Throttles: 10}
+		client, _ := newTestThrottledClient(flaky, Config{ThrottleMaxAttempts: 3})
+
+		_, err := client.DescribeImages(context.Background(), &ecr.DescribeImagesInput{})
+		if !isThrottlingError(err) {
+			t.Fatalf("Expected the throttling error to be returned, got %v", err)
+		}
+		if flaky.DescribeImagesCalls != 3 {
+			t.Errorf("Expected 3 calls to DescribeImages, got %d", flaky.DescribeImagesCalls)
+		}
+	})
+
+	t.Run("Does not retry other errors", func(t *testing.T) {
+		mockClient := &MockECRClient{
+			BatchDeleteImageError: &types.ServerException{Message: aws.String("boom")},
+		}
+		client, _ := newTestThrottledClient(mockClient, Config{})
+
+		_, err := client.BatchDeleteImage(context.Background(), &ecr.BatchDeleteImageInput{})
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if mockClient.BatchDeleteImageCalls != 1 {
+			t.Errorf("Expected 1 call to BatchDeleteImage, got %d", mockClient.BatchDeleteImageCalls)
+		}
+	})
+}
+
+// TestAdaptiveLimiter tests pacing and adaptation
+func TestAdaptiveLimiter(t *testing.T) {
+	t.Run("Paces to the configured rate", func(t *testing.T) {
+		limiter := newAdaptiveLimiter(10)
+
+		if wait := limiter.reserve(); wait != 0 {
+			t.Errorf("Expected first call not to wait, got %s", wait)
+		}
+		if wait := limiter.reserve(); wait < 90*time.Millisecond {
+			t.Errorf("Expected second call to wait about 100ms, got %s", wait)
+		}
+	})
+
+	t.Run("Slows down on throttle and recovers", func(t *testing.T) {
+		limiter := newAdaptiveLimiter(0)
+
+		limiter.onThrottle()
+		if limiter.interval != minThrottledInterval {
+			t.Errorf("Expected interval %s after throttle, got %s", minThrottledInterval, limiter.interval)
+		}
+		limiter.onThrottle()
+		if limiter.interval != 2*minThrottledInterval {
+			t.Errorf("Expected interval to double, got %s", limiter.interval)
+		}
+
+		for i := 0; i < 100; i++ {
+			limiter.onSuccess()
+		}
+		if limiter.interval != 0 {
+			t.Errorf("Expected interval to recover to unpaced, got %s", limiter.interval)
+		}
+	})
+}
+
+// TestBackoffDelay tests that backoff stays within bounds
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 1; attempt < 40; attempt++ {
+		delay := backoffDelay(attempt)
+		if delay <= 0 || delay > throttleMaxDelay {
+			t.Errorf("Attempt %d: delay %s out of bounds", attempt, delay)
+		}
+	}
+}
+
+// TestThrottleRetryer tests that the SDK's retryer leaves throttling to the
+// throttled client and still retries the other transient errors
+func TestThrottleRetryer(t *testing.T) {
+	opts := ecr.Options{Retryer: retry.NewStandard()}
+	for _, optFn := range withoutThrottleRetries(nil) {
+		optFn(&opts)
+	}
+	if opts.Retryer.IsErrorRetryable(&smithy.GenericAPIError{Code: "ThrottlingException"}) {
+		t.Error("Expected the SDK not to retry throttling")
+	}
+	if !opts.Retryer.IsErrorRetryable(&smithy.GenericAPIError{Code: "RequestTimeout"}) {
+		t.Error("Expected the SDK to still retry transient errors")
+	}
+	if _, ok := opts.Retryer.(aws.RetryerV2); !ok {
+		t.Error("Expected the retryer to keep its attempt tokens")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/smithy-go/middleware"
+	otelattribute "go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// This file contains the OpenTelemetry tracing. Spans are recorded for the
+// run, each repository and each AWS API call, and exported to an OTLP/HTTP
+// collector by the OpenTelemetry SDK's batch span processor, so long runs
+// can be profiled and API hot spots found. Spans are sent while the run
+// goes on, and the rest are flushed when it finishes. The tracer is carried
+// on the Config of the run and, below its first span, by the span in the
+// context. Tracing is off unless an endpoint is configured, in which case
+// every helper here is a no-op.
+
+// tracingServiceName is reported as the service.name resource attribute and
+// names the tracer
+const tracingServiceName = "ecr-cleanup"
+
+// attribute creates a span attribute; values are strings, ints or bools,
+// and anything else is recorded as its string form
+func attribute(key string, value interface{}) otelattribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return otelattribute.String(key, v)
+	case int:
+		return otelattribute.Int(key, v)
+	case int64:
+		return otelattribute.Int64(key, v)
+	case bool:
+		return otelattribute.Bool(key, v)
+	}
+	return otelattribute.String(key, fmt.Sprint(value))
+}
+
+// span is one timed operation. A nil span is valid and records nothing.
+type span struct {
+	span trace.Span
+}
+
+// setupTracing enables tracing to the configured OTLP endpoint by setting
+// cfg.tracer, and returns a function that flushes the spans not exported
+// yet
+func setupTracing(cfg Config) (Config, func()) {
+	if cfg.OTLPEndpoint == "" {
+		return cfg, func() {}
+	}
+
+	endpoint := strings.TrimRight(cfg.OTLPEndpoint, "/") + "/v1/traces"
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithTimeout(webhookTimeout))
+	if err != nil {
+		slog.Error("Error setting up trace export; tracing is off", "endpoint", endpoint, "error", err)
+		return cfg, func() {}
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(otelattribute.String("service.name", tracingServiceName))),
+	)
+	cfg.tracer = provider.Tracer(tracingServiceName)
+
+	return cfg, func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			slog.Error("Error exporting traces", "endpoint", endpoint, "error", err)
+		}
+	}
+}
+
+// startRunSpan starts the span of a run with cfg.tracer, as a child of the
+// span in ctx, if any. The spans started under it use the same tracer.
+func startRunSpan(ctx context.Context, cfg Config, name string, attributes ...otelattribute.KeyValue) (context.Context, *span) {
+	if cfg.tracer == nil {
+		return startSpan(ctx, name, attributes...)
+	}
+	ctx, s := cfg.tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+	return ctx, &span{span: s}
+}
+
+// startSpan starts a span as a child of the span in ctx. Without one, the
+// run isn't traced and the span records nothing.
+func startSpan(ctx context.Context, name string, attributes ...otelattribute.KeyValue) (context.Context, *span) {
+	return startSpanWithKind(ctx, name, trace.SpanKindInternal, attributes...)
+}
+
+// startSpanWithKind starts a span of the given kind with the tracer of the
+// span in ctx
+func startSpanWithKind(ctx context.Context, name string, kind trace.SpanKind, attributes ...otelattribute.KeyValue) (context.Context, *span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, nil
+	}
+	ctx, s := parent.TracerProvider().Tracer(tracingServiceName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+	return ctx, &span{span: s}
+}
+
+// setAttributes adds attributes to the span
+func (s *span) setAttributes(attributes ...otelattribute.KeyValue) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributes...)
+}
+
+// end finishes the span, marking it as failed when err is not nil
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// randomHexID returns a random ID of n bytes, hex encoded
+func randomHexID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// traceAWSCalls records a client span for every call of the clients
+// created from awsConfig, and of the copies made for other accounts and
+// regions, whatever the service
+func traceAWSCalls(awsConfig *aws.Config) {
+	awsConfig.APIOptions = append(awsConfig.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ecrCleanupTracing", handleTracedCall), middleware.After)
+	})
+}
+
+// handleTracedCall records the span of one AWS API call, the SDK's own
+// retries included, under the span in ctx
+func handleTracedCall(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+	ctx, span := startSpanWithKind(ctx, service+"/"+operation, trace.SpanKindClient,
+		append([]otelattribute.KeyValue{
+			attribute("rpc.system", "aws-api"),
+			attribute("rpc.service", service),
+			attribute("rpc.method", operation),
+		}, ecrCallAttributes(in.Parameters)...)...)
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	span.end(err)
+	return out, metadata, err
+}
+
+// ecrCallAttributes returns the repository and image count of the ECR calls
+// that work on a repository
+func ecrCallAttributes(params interface{}) []otelattribute.KeyValue {
+	switch p := params.(type) {
+	case *ecr.ListImagesInput:
+		return []otelattribute.KeyValue{attribute("ecr.repository", aws.ToString(p.RepositoryName))}
+	case *ecr.DescribeImagesInput:
+		return []otelattribute.KeyValue{
+			attribute("ecr.repository", aws.ToString(p.RepositoryName)),
+			attribute("ecr.image_ids", len(p.ImageIds))}
+	case *ecr.BatchDeleteImageInput:
+		return []otelattribute.KeyValue{
+			attribute("ecr.repository", aws.ToString(p.RepositoryName)),
+			attribute("ecr.image_ids", len(p.ImageIds))}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	otelattribute "go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testCollector is an OTLP/HTTP collector that records exported spans
+type testCollector struct {
+	mu      sync.Mutex
+	spans   []*tracepb.Span
+	service string
+}
+
+// ServeHTTP decodes an export request and records its spans
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req collectortrace.ExportTraceServiceRequest
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" || proto.Unmarshal(body, &req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, attr := range resourceSpans.GetResource().GetAttributes() {
+			if attr.Key == "service.name" {
+				c.service = attr.GetValue().GetStringValue()
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	out, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Write(out)
+}
+
+// spanNamed returns the first recorded span with the given name
+func (c *testCollector) spanNamed(name string) (*tracepb.Span, bool) {
+	for _, s := range c.spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return &tracepb.Span{}, false
+}
+
+// TestTracingDisabled tests that spans are no-ops without an endpoint
+func TestTracingDisabled(t *testing.T) {
+	cfg, shutdown := setupTracing(Config{})
+	defer shutdown()
+	if cfg.tracer != nil {
+		t.Error("Expected no tracer without an endpoint")
+	}
+
+	ctx, span := startRunSpan(context.Background(), cfg, "noop")
+	if span != nil {
+		t.Error("Expected a nil span when tracing is disabled")
+	}
+	span.setAttributes(attribute("key", "value"))
+	span.end(errors.New("ignored"))
+
+	if trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		t.Error("Expected no span in the context")
+	}
+	if _, span := startSpan(ctx, "child"); span != nil {
+		t.Error("Expected a nil span outside of a traced run")
+	}
+}
+
+// TestTracingExport tests recording and exporting a trace of a cleanup
+func TestTracingExport(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	mockClient := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{
+			Repositories: []types.Repository{{RepositoryName: aws.String("repo1")}},
+		},
+		ListImagesOutput: &ecr.ListImagesOutput{
+			ImageIds: []types.ImageIdentifier{{ImageTag: aws.String("v1")}},
+		},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{
+			ImageDetails: []types.ImageDetail{{
+				ImageDigest:   aws.String("sha256:111"),
+				ImageTags:     []string{"v1"},
+				ImagePushedAt: aws.Time(time.Now().AddDate(0, 0, -30)),
+			}},
+		},
+		BatchDeleteImageError: errors.New("access denied"),
+	}
+
+	cfg, shutdown := setupTracing(Config{Days: 10, OTLPEndpoint: server.URL + "/"})
+	_, err := CleanupWithClient(context.Background(), cfg, mockClient)
+	shutdown()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	root, ok := collector.spanNamed("CleanupWithClient")
+	if !ok {
+		t.Fatalf("Expected a CleanupWithClient span, got %+v", collector.spans)
+	}
+	if collector.service != tracingServiceName {
+		t.Errorf("Expected the service name %s, got %q", tracingServiceName, collector.service)
+	}
+	repo, ok := collector.spanNamed("processRepository")
+	if !ok || !bytes.Equal(repo.ParentSpanId, root.SpanId) || !bytes.Equal(repo.TraceId, root.TraceId) {
+		t.Errorf("Expected processRepository to be a child of CleanupWithClient, got %+v", repo)
+	}
+	if repo.GetStatus().GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("Expected processRepository to be marked as failed, got %+v", repo.Status)
+	}
+}
+
+// TestTracingAWSCalls tests recording a client span for the calls of every
+// SDK client created from a traced AWS config
+func TestTracingAWSCalls(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer api.Close()
+
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(api.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	}
+	traceAWSCalls(&awsConfig)
+
+	// Calls outside of a traced run record nothing
+	ecrClient := ecr.NewFromConfig(awsConfig)
+	if _, err := ecrClient.DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cfg, shutdown := setupTracing(Config{OTLPEndpoint: server.URL})
+	ctx, span := startRunSpan(context.Background(), cfg, "run")
+	_, err := ecrClient.DescribeImages(ctx, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String("repo1"),
+		ImageIds:       []types.ImageIdentifier{{ImageTag: aws.String("v1")}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := dynamodb.NewFromConfig(awsConfig).DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String("locks")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	span.end(nil)
+	shutdown()
+
+	if _, ok := collector.spanNamed("ECR/DescribeRepositories"); ok {
+		t.Error("Expected no span for a call outside of the run")
+	}
+	root, _ := collector.spanNamed("run")
+	call, ok := collector.spanNamed("ECR/DescribeImages")
+	if !ok || !bytes.Equal(call.ParentSpanId, root.SpanId) || call.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("Expected a client span for DescribeImages under the run, got %+v", call)
+	}
+	attrs := make(map[string]string)
+	imageIDs := int64(0)
+	for _, attr := range call.Attributes {
+		attrs[attr.Key] = attr.GetValue().GetStringValue()
+		if attr.Key == "ecr.image_ids" {
+			imageIDs = attr.GetValue().GetIntValue()
+		}
+	}
+	if attrs["rpc.service"] != "ECR" || attrs["rpc.method"] != "DescribeImages" || attrs["ecr.repository"] != "repo1" || imageIDs != 1 {
+		t.Errorf("Unexpected attributes: %v, %d image IDs", attrs, imageIDs)
+	}
+	if call, ok := collector.spanNamed("DynamoDB/DescribeTable"); !ok || !bytes.Equal(call.ParentSpanId, root.SpanId) {
+		t.Errorf("Expected a span for DescribeTable under the run, got %+v", call)
+	}
+}
+
+// TestSpanAttributes tests converting attribute values
+func TestSpanAttributes(t *testing.T) {
+	attrs := []otelattribute.KeyValue{
+		attribute("s", "value"),
+		attribute("i", 42),
+		attribute("b", true),
+		attribute("d", time.Second),
+	}
+	if attrs[0].Value.AsString() != "value" || attrs[1].Value.AsInt64() != 42 || !attrs[2].Value.AsBool() || attrs[3].Value.AsString() != "1s" {
+		t.Errorf("Unexpected attributes: %+v", attrs)
+	}
+}
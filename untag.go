@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -untag-only, which removes the tags of the images the
+// retention policy selects instead of deleting them. ECR deletes an image
+// along with its last tag, so each image first gets a retained-<digest>
+// tag through PutImage, and then every other tag is removed: the tag list
+// only keeps current tags, and old images stay pullable by digest.
+
+// retainedTagPrefix starts the tag that keeps an untagged image's data
+const retainedTagPrefix = "retained-"
+
+// TagClient defines the ECR operations needed to re-tag images
+type TagClient interface {
+	BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	PutImage(ctx context.Context, params *ecr.PutImageInput, optFns ...func(*ecr.Options)) (*ecr.PutImageOutput, error)
+}
+
+// retainedTag returns the tag that keeps an image once its tags are removed
+func retainedTag(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	return retainedTagPrefix + hex[:min(12, len(hex))]
+}
+
+// staleTags returns the tags -untag-only removes from an image: all but
+// its retained tag
+func staleTags(img types.ImageDetail) []string {
+	var stale []string
+	for _, tag := range img.ImageTags {
+		if tag != retainedTag(aws.ToString(img.ImageDigest)) {
+			stale = append(stale, tag)
+		}
+	}
+	return stale
+}
+
+// withStaleTags returns the images that have tags to remove
+func withStaleTags(images []types.ImageDetail) []types.ImageDetail {
+	var tagged []types.ImageDetail
+	for _, img := range images {
+		if len(staleTags(img)) > 0 {
+			tagged = append(tagged, img)
+		}
+	}
+	return tagged
+}
+
+// untagImages gives each image its retained tag and removes its other tags
+func untagImages(ctx context.Context, client ECRClient, tagClient TagClient, repoName string, images []types.ImageDetail, cfg Config) error {
+	if err := retainImages(ctx, tagClient, repoName, images); err != nil {
+		return err
+	}
+
+	var ids []types.ImageIdentifier
+	owners := make(map[string]types.ImageDetail)
+	for _, img := range images {
+		for _, tag := range staleTags(img) {
+			ids = append(ids, types.ImageIdentifier{ImageTag: aws.String(tag)})
+			owners[tag] = img
+		}
+	}
+
+	for i := 0; i < len(ids); i += batchDeleteSize {
+		batch := ids[i:min(i+batchDeleteSize, len(ids))]
+		result, err := client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+			RepositoryName: aws.String(repoName),
+			ImageIds:       batch,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+
+		failed := make(map[string]bool)
+		for _, failure := range result.Failures {
+			tag := getImageIdString(failure.ImageId)
+			failed[tag] = true
+			slog.Error("Failed to remove tag", "action", "untag", "repository", repoName, "tag", tag, "reason", aws.ToString(failure.FailureReason))
+			cfg.audit.record(cfg, auditFailed, repoName, []types.ImageDetail{owners[tag]}, "failed to remove tag "+tag+": "+aws.ToString(failure.FailureReason))
+		}
+		for _, id := range batch {
+			tag := aws.ToString(id.ImageTag)
+			if !failed[tag] {
+				img := owners[tag]
+				slog.Info("Removed tag", "action", "untag", "repository", repoName, "tag", tag, "digest", aws.ToString(img.ImageDigest))
+				cfg.audit.record(cfg, auditDeleted, repoName, []types.ImageDetail{img}, "removed tag "+tag+", kept as "+retainedTag(aws.ToString(img.ImageDigest)))
+			}
+		}
+	}
+	return nil
+}
+
+// retainImages tags the images that don't have their retained tag yet
+func retainImages(ctx context.Context, tagClient TagClient, repoName string, images []types.ImageDetail) error {
+	var ids []types.ImageIdentifier
+	for _, img := range images {
+		if len(staleTags(img)) == len(img.ImageTags) {
+			ids = append(ids, types.ImageIdentifier{ImageDigest: img.ImageDigest})
+		}
+	}
+	return tagImages(ctx, tagClient, repoName, ids, retainedTag)
+}
+
+// tagImages adds a tag, derived from each image's digest, to the images.
+// The manifests are read describeImagesBatchSize images at a time, the most
+// BatchGetImage accepts.
+func tagImages(ctx context.Context, tagClient TagClient, repoName string, ids []types.ImageIdentifier, tagFor func(digest string) string) error {
+	for i := 0; i < len(ids); i += describeImagesBatchSize {
+		resp, err := tagClient.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			RepositoryName:     aws.String(repoName),
+			ImageIds:           ids[i:min(i+describeImagesBatchSize, len(ids))],
+			AcceptedMediaTypes: []string{mediaTypeDockerManifest, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get image manifests: %w", err)
+		}
+		if len(resp.Failures) > 0 {
+			failure := resp.Failures[0]
+			return fmt.Errorf("failed to get image manifest %s: %s", getImageIdString(failure.ImageId), aws.ToString(failure.FailureReason))
+		}
+
+		for _, image := range resp.Images {
+			digest := aws.ToString(image.ImageId.ImageDigest)
+			_, err := tagClient.PutImage(ctx, &ecr.PutImageInput{
+				RepositoryName:         aws.String(repoName),
+				ImageManifest:          image.ImageManifest,
+				ImageManifestMediaType: image.ImageManifestMediaType,
+				ImageDigest:            aws.String(digest),
+				ImageTag:               aws.String(tagFor(digest)),
+			})
+			var exists *types.ImageAlreadyExistsException
+			if err != nil && !errors.As(err, &exists) {
+				return fmt.Errorf("failed to tag image %s: %w", digest, err)
+			}
+		}
+	}
+	return nil
+}
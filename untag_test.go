@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// mockTagClient returns a manifest for every image and records the tags
+// it is asked to put
+type mockTagClient struct {
+	puts []*ecr.PutImageInput
+	gets []int // the number of images of each BatchGetImage call
+}
+
+func (m *mockTagClient) BatchGetImage(ctx context.Context, params *ecr.BatchGetImageInput, optFns ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	m.gets = append(m.gets, len(params.ImageIds))
+	out := &ecr.BatchGetImageOutput{}
+	for _, id := range params.ImageIds {
+		out.Images = append(out.Images, types.Image{
+			ImageId:                &types.ImageIdentifier{ImageDigest: id.ImageDigest},
+			ImageManifest:          aws.String(`{"schemaVersion":2}`),
+			ImageManifestMediaType: aws.String(mediaTypeOCIManifest),
+		})
+	}
+	return out, nil
+}
+
+func (m *mockTagClient) PutImage(ctx context.Context, params *ecr.PutImageInput, optFns ...func(*ecr.Options)) (*ecr.PutImageOutput, error) {
+	m.puts = append(m.puts, params)
+	return &ecr.PutImageOutput{}, nil
+}
+
+// TestRetainedTag tests the tag that keeps an untagged image
+func TestRetainedTag(t *testing.T) {
+	if got := retainedTag(testDigest("a")); got != "retained-aaaaaaaaaaaa" {
+		t.Errorf("Expected retained-aaaaaaaaaaaa, got %s", got)
+	}
+	img := types.ImageDetail{ImageDigest: aws.String(testDigest("a")), ImageTags: []string{"retained-aaaaaaaaaaaa", "v1"}}
+	if stale := staleTags(img); len(stale) != 1 || stale[0] != "v1" {
+		t.Errorf("Expected only v1 stale, got %v", stale)
+	}
+}
+
+// TestUntagOnly tests that -untag-only removes tags and keeps every image
+func TestUntagOnly(t *testing.T) {
+	now := time.Now()
+	detail := func(digest string, days int, tags ...string) types.ImageDetail {
+		return types.ImageDetail{ImageDigest: aws.String(digest), ImageTags: tags, ImagePushedAt: aws.Time(now.AddDate(0, 0, -days)), ImageSizeInBytes: aws.Int64(100)}
+	}
+	images := []types.ImageDetail{
+		detail(testDigest("1"), 1, "v4", "latest"),
+		detail(testDigest("2"), 30, "v3", "stable"),
+		detail(testDigest("3"), 40, retainedTag(testDigest("3")), "v2"),
+		detail(testDigest("4"), 50, retainedTag(testDigest("4"))),
+		detail(testDigest("5"), 60),
+	}
+	client := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: []types.Repository{{RepositoryName: aws.String("api")}}},
+		DescribeImagesOutput:       &ecr.DescribeImagesOutput{ImageDetails: images},
+		BatchDeleteImageOutput:     &ecr.BatchDeleteImageOutput{},
+	}
+	tagClient := &mockTagClient{}
+
+	cfg := Config{Days: 10, UntagOnly: true, SkipListImages: true, tags: tagClient}
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Only the image without a retained tag gets one
+	if len(tagClient.puts) != 1 || aws.ToString(tagClient.puts[0].ImageTag) != retainedTag(testDigest("2")) || aws.ToString(tagClient.puts[0].ImageDigest) != testDigest("2") {
+		t.Fatalf("Expected v3 retained, got %+v", tagClient.puts)
+	}
+
+	var removed []string
+	for _, id := range client.LastBatchDeleteImageInput.ImageIds {
+		if id.ImageDigest != nil {
+			t.Fatalf("Expected tags removed by tag, got digest %s", aws.ToString(id.ImageDigest))
+		}
+		removed = append(removed, aws.ToString(id.ImageTag))
+	}
+	sort.Strings(removed)
+	if strings.Join(removed, ",") != "stable,v2,v3" {
+		t.Errorf("Expected stable, v2 and v3 removed, got %v", removed)
+	}
+	if summary.ImagesDeleted != 2 || summary.SpaceFreed != 0 {
+		t.Errorf("Expected 2 images untagged freeing nothing, got %d images and %d bytes", summary.ImagesDeleted, summary.SpaceFreed)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// This file contains -verify-deletions, a pass after each repository's
+// deletions that lists the repository again and checks the deleted digests
+// are gone. BatchDeleteImage reporting success doesn't always mean the
+// image is gone: deleting by tag leaves an image that has other tags, and a
+// push racing the run can bring a digest back. Images still present are
+// logged, recorded as failed in the audit file and queued in the
+// -retry-file, and the summary counts only the images that are really
+// gone.
+
+// remainingImages lists the repository and returns the deleted images it
+// still holds
+func remainingImages(ctx context.Context, client ECRClient, repoName string, deleted []types.ImageDetail) ([]types.ImageDetail, error) {
+	present := make(map[string]bool)
+	var nextToken *string
+	for {
+		resp, err := client.ListImages(ctx, &ecr.ListImagesInput{
+			RepositoryName: aws.String(repoName),
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list images of %s: %w", repoName, err)
+		}
+		for _, id := range resp.ImageIds {
+			present[aws.ToString(id.ImageDigest)] = true
+		}
+		nextToken = resp.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	var remaining []types.ImageDetail
+	for _, img := range deleted {
+		if present[aws.ToString(img.ImageDigest)] {
+			remaining = append(remaining, img)
+		}
+	}
+	return remaining, nil
+}
+
+// verifyDeletions checks that the deleted images of a repository are gone
+// and returns the repository's summary reconciled with what is left. A
+// repository that can't be listed keeps its summary.
+func verifyDeletions(ctx context.Context, client ECRClient, repoName string, scanned int, deleted []types.ImageDetail, summary CleanupSummary, cfg Config) CleanupSummary {
+	if !cfg.VerifyDeletions || cfg.DryRun || cfg.UntagOnly || len(deleted) == 0 {
+		return summary
+	}
+
+	remaining, err := remainingImages(ctx, client, repoName, deleted)
+	if err != nil {
+		slog.Warn("Could not verify the deletions", "repository", repoName, "error", err)
+		return summary
+	}
+	if len(remaining) == 0 {
+		slog.Debug("Verified the deletions", "repository", repoName, "images", len(deleted))
+		return summary
+	}
+
+	for _, img := range remaining {
+		slog.Warn("Deleted image is still present", "repository", repoName, "tag", getImageTag(img), "digest", aws.ToString(img.ImageDigest))
+	}
+	cfg.audit.record(cfg, auditFailed, repoName, remaining, "still present after deletion")
+	cfg.retries.add(cfg, repoName, remaining)
+
+	gone := make([]types.ImageDetail, 0, len(deleted)-len(remaining))
+	for _, img := range deleted {
+		if !containsDigest(remaining, aws.ToString(img.ImageDigest)) {
+			gone = append(gone, img)
+		}
+	}
+	reconciled := deletionSummary(scanned, gone, cfg)
+	reconciled.ImagesRemaining = len(remaining)
+	reconciled.Quarantined, reconciled.Released = summary.Quarantined, summary.Released
+	return reconciled
+}
+
+// containsDigest reports whether one of the images has the digest
+func containsDigest(images []types.ImageDetail, digest string) bool {
+	for _, img := range images {
+		if aws.ToString(img.ImageDigest) == digest {
+			return true
+		}
+	}
+	return false
+}
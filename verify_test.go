@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// deletingClient lists only the images that were not deleted, except for
+// the digests it keeps to simulate deletions that silently didn't happen
+type deletingClient struct {
+	*MockECRClient
+	survivors map[string]bool
+}
+
+// ListImages lists the images left after the deletions
+func (c *deletingClient) ListImages(ctx context.Context, params *ecr.ListImagesInput, optFns ...func(*ecr.Options)) (*ecr.ListImagesOutput, error) {
+	out, err := c.MockECRClient.ListImages(ctx, params, optFns...)
+	if err != nil || c.BatchDeleteImageCalls == 0 {
+		return out, err
+	}
+	var ids []types.ImageIdentifier
+	for _, id := range out.ImageIds {
+		if c.survivors[aws.ToString(id.ImageDigest)] {
+			ids = append(ids, id)
+		}
+	}
+	return &ecr.ListImagesOutput{ImageIds: ids}, nil
+}
+
+// TestVerifyDeletions tests reconciling the summary with the images still
+// present after deleting
+func TestVerifyDeletions(t *testing.T) {
+	client := &deletingClient{MockECRClient: newGuardrailClient(2, 3), survivors: map[string]bool{"sha256:001": true}}
+	cfg := Config{Days: 10, Concurrency: 1, VerifyDeletions: true}
+
+	summary, err := CleanupWithClient(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.ImagesDeleted != 4 || summary.ImagesRemaining != 2 || summary.SpaceFreed != 4000 {
+		t.Errorf("Expected 4 images deleted and 2 remaining, got %d, %d and %d bytes", summary.ImagesDeleted, summary.ImagesRemaining, summary.SpaceFreed)
+	}
+	for _, repo := range summary.Repositories {
+		if repo.ImagesDeleted != 2 || len(repo.Images) != 2 {
+			t.Errorf("Unexpected repository summary %+v", repo)
+		}
+		for _, img := range repo.Images {
+			if img.Digest == "sha256:001" {
+				t.Errorf("Expected the surviving image to be left out of %s", repo.Name)
+			}
+		}
+	}
+
+	// Without the flag the repository isn't listed again
+	client = &deletingClient{MockECRClient: newGuardrailClient(1, 3), survivors: map[string]bool{"sha256:001": true}}
+	summary, err = CleanupWithClient(context.Background(), Config{Days: 10}, client)
+	if err != nil || summary.ImagesDeleted != 3 || client.ListImagesCalls != 1 {
+		t.Errorf("Expected an unverified deletion, got %+v, %d calls, %v", summary, client.ListImagesCalls, err)
+	}
+	client = &deletingClient{MockECRClient: newGuardrailClient(1, 3), survivors: map[string]bool{}}
+	summary, err = CleanupWithClient(context.Background(), Config{Days: 10, VerifyDeletions: true}, client)
+	if err != nil || summary.ImagesDeleted != 3 || summary.ImagesRemaining != 0 || client.ListImagesCalls != 2 {
+		t.Errorf("Expected a verified deletion, got %+v, %d calls, %v", summary, client.ListImagesCalls, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// This file contains the build metadata shown by -version and the version
+// command. Release builds inject it with ldflags:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Other builds fall back to what the Go toolchain recorded, such as the
+// module version of "go install ...@v1.4.0" or the VCS revision of a build
+// from a checkout. The version is also added to the User-Agent of every AWS
+// call, so CloudTrail shows which version of the tool deleted an image.
+
+// Build metadata, set with -ldflags "-X main.version=..."
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the running binary
+type buildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+	SDK       string
+	Platform  string
+}
+
+// currentBuildInfo returns the injected build metadata, completed from the
+// Go toolchain's
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		SDK:       "aws-sdk-go-v2/" + aws.SDKVersion,
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if recorded, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && recorded.Main.Version != "" && recorded.Main.Version != "(devel)" {
+			info.Version = strings.TrimPrefix(recorded.Main.Version, "v")
+		}
+		for _, setting := range recorded.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// String formats the build metadata for -version
+func (b buildInfo) String() string {
+	return fmt.Sprintf("ecr-cleanup %s\n  commit:   %s\n  built:    %s\n  go:       %s\n  sdk:      %s\n  platform: %s",
+		b.Version, b.Commit, b.BuildDate, b.GoVersion, b.SDK, b.Platform)
+}
+
+// withUserAgent adds "ecr-cleanup/<version>" to the User-Agent of AWS calls
+func withUserAgent() func(*config.LoadOptions) error {
+	return config.WithAPIOptions([]func(*middleware.Stack) error{
+		awsmiddleware.AddUserAgentKeyValue("ecr-cleanup", currentBuildInfo().Version),
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// TestCurrentBuildInfo tests preferring the metadata injected with ldflags
+func TestCurrentBuildInfo(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "1.4.0", "3f9c2a7", "2025-05-13T14:32:33Z"
+
+	info := currentBuildInfo()
+	if info.Version != "1.4.0" || info.Commit != "3f9c2a7" || info.BuildDate != "2025-05-13T14:32:33Z" {
+		t.Errorf("Expected the injected metadata, got %+v", info)
+	}
+	if !strings.HasPrefix(info.GoVersion, "go") || !strings.HasPrefix(info.SDK, "aws-sdk-go-v2/") {
+		t.Errorf("Expected the Go and SDK versions, got %+v", info)
+	}
+	if !strings.HasPrefix(info.String(), "ecr-cleanup 1.4.0\n") {
+		t.Errorf("Unexpected output:\n%s", info)
+	}
+
+	version = ""
+	if info := currentBuildInfo(); info.Version == "" || info.Commit == "" || info.BuildDate == "" {
+		t.Errorf("Expected every field filled in without ldflags, got %+v", info)
+	}
+}
+
+// TestUserAgent tests that AWS calls name the tool and its version
+func TestUserAgent(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "1.4.0"
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"repositories":[]}`)
+	}))
+	defer server.Close()
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background(),
+		withUserAgent(),
+		config.WithRegion("us-east-1"),
+		config.WithBaseEndpoint(server.URL),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("id", "secret", "")),
+	)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := ecr.NewFromConfig(awsConfig).DescribeRepositories(context.Background(), &ecr.DescribeRepositoriesInput{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(userAgent, "ecr-cleanup/1.4.0") {
+		t.Errorf("Expected the tool in the User-Agent, got %q", userAgent)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// This file contains -window, the maintenance window outside which the tool
+// refuses to delete anything, so that nobody cleans up a registry in the
+// middle of a deploy by accident. A window is written as
+//
+//	[days] HH:MM-HH:MM [time zone]
+//
+// e.g. "Sat 01:00-05:00 UTC", "Mon-Fri 22:00-02:00 Europe/Berlin" or
+// "03:00-04:00". Days take the same names, ranges and lists as the day of
+// week of -schedule and default to every day; a window that ends before it
+// starts runs past midnight into the next day. The time zone defaults to the
+// local one. Dry runs are allowed at any time. A run started inside the
+// window stops gracefully when the window closes.
+
+// errWindowClosed means the maintenance window closed while the run was
+// deleting images
+var errWindowClosed = errors.New("maintenance window closed")
+
+// maintenanceWindow is a parsed -window
+type maintenanceWindow struct {
+	spec       string
+	days       uint64 // bitset of weekdays, Sunday first
+	start, end int    // minutes after midnight
+	loc        *time.Location
+}
+
+// parseMaintenanceWindow parses a -window value
+func parseMaintenanceWindow(spec string) (*maintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	w := &maintenanceWindow{spec: spec, days: 1<<7 - 1, loc: time.Local}
+
+	// The time range is the only field with a colon
+	timeField := -1
+	for i, field := range fields {
+		if strings.Contains(field, ":") {
+			timeField = i
+			break
+		}
+	}
+	if timeField < 0 || timeField > 1 || len(fields) > timeField+2 {
+		return nil, fmt.Errorf("invalid maintenance window %q: expected [days] HH:MM-HH:MM [time zone]", spec)
+	}
+
+	if timeField == 1 {
+		days, err := parseCronField(fields[0], cronDow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		// Sunday may be written as 0 or 7
+		w.days = days&(1<<7-1) | days>>7
+	}
+
+	from, to, ok := strings.Cut(fields[timeField], "-")
+	var err error
+	if ok {
+		w.start, err = parseClock(from)
+		if err == nil {
+			w.end, err = parseClock(to)
+		}
+	}
+	if !ok || err != nil || w.start == w.end {
+		return nil, fmt.Errorf("invalid maintenance window %q: expected a time range such as 01:00-05:00", spec)
+	}
+
+	if len(fields) > timeField+1 {
+		if w.loc, err = time.LoadLocation(fields[timeField+1]); err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight; 24:00 is the end of
+// the day
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		if value == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// at returns the time the given minutes after midnight of t's day
+func (w *maintenanceWindow) at(t time.Time, minutes int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, minutes, 0, 0, w.loc)
+}
+
+// dayMatches reports whether a window may open on t's day
+func (w *maintenanceWindow) dayMatches(t time.Time) bool {
+	return w.days&(1<<uint(t.Weekday())) != 0
+}
+
+// closesAt returns when the window that is open at now closes, and false
+// if the window is closed at now
+func (w *maintenanceWindow) closesAt(now time.Time) (time.Time, bool) {
+	now = now.In(w.loc)
+	minutes := now.Hour()*60 + now.Minute()
+
+	if w.start < w.end {
+		if w.dayMatches(now) && minutes >= w.start && minutes < w.end {
+			return w.at(now, w.end), true
+		}
+		return time.Time{}, false
+	}
+
+	// The window runs past midnight
+	if w.dayMatches(now) && minutes >= w.start {
+		return w.at(now.AddDate(0, 0, 1), w.end), true
+	}
+	if yesterday := now.AddDate(0, 0, -1); w.dayMatches(yesterday) && minutes < w.end {
+		return w.at(now, w.end), true
+	}
+	return time.Time{}, false
+}
+
+// opensAt returns when the window next opens after now
+func (w *maintenanceWindow) opensAt(now time.Time) time.Time {
+	now = now.In(w.loc)
+	for day := 0; day <= 7; day++ {
+		date := now.AddDate(0, 0, day)
+		if start := w.at(date, w.start); w.dayMatches(date) && start.After(now) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// withMaintenanceWindow refuses a run that would delete images outside the
+// -window, and otherwise returns the config of a run that stops gracefully
+// when the window closes. The returned function releases the window's
+// timer once the run is over.
+func withMaintenanceWindow(cfg Config, now time.Time) (Config, func(), error) {
+	if cfg.Window == "" || cfg.DryRun {
+		return cfg, func() {}, nil
+	}
+
+	window, err := parseMaintenanceWindow(cfg.Window)
+	if err != nil {
+		return cfg, nil, err
+	}
+	closes, open := window.closesAt(now)
+	if !open {
+		return cfg, nil, fmt.Errorf("outside the maintenance window %q: deleting images is refused until %s (dry runs are allowed)",
+			cfg.Window, window.opensAt(now).Format(time.RFC3339))
+	}
+
+	cfg, closeWindow := stopAfter(cfg, closes.Sub(now), fmt.Errorf("%w at %s", errWindowClosed, closes.Format(time.RFC3339)))
+	return cfg, closeWindow, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseMaintenanceWindow tests the accepted -window forms
+func TestParseMaintenanceWindow(t *testing.T) {
+	w, err := parseMaintenanceWindow("Sat,sun 01:00-05:30 UTC")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w.days != 1<<0|1<<6 || w.start != 60 || w.end != 330 || w.loc != time.UTC {
+		t.Errorf("Unexpected window %+v", w)
+	}
+
+	if w, err := parseMaintenanceWindow("7 22:00-24:00"); err != nil || w.days != 1 || w.end != 24*60 || w.loc != time.Local {
+		t.Errorf("Expected Sunday until midnight in the local time zone, got %+v, %v", w, err)
+	}
+
+	for _, invalid := range []string{"", "Sat", "Sat 01:00", "Sat 05:00-05:00", "Fun 01:00-02:00", "01:00-02:00 Mars/Base", "Sat 01:00-02:00 UTC extra", "1am-2am"} {
+		if _, err := parseMaintenanceWindow(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+// TestMaintenanceWindowClosesAt tests telling whether a window is open
+func TestMaintenanceWindowClosesAt(t *testing.T) {
+	saturday := func(hour, minute int) time.Time { return time.Date(2025, 5, 17, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		window string
+		now    time.Time
+		closes time.Time // zero when closed
+	}{
+		{"Sat 01:00-05:00 UTC", saturday(1, 0), saturday(5, 0)},
+		{"Sat 01:00-05:00 UTC", saturday(5, 0), time.Time{}},
+		{"Sun 01:00-05:00 UTC", saturday(2, 0), time.Time{}},
+		{"Sat 01:00-05:00 Europe/Berlin", saturday(2, 0), saturday(3, 0)},
+		{"Fri 22:00-02:00 UTC", saturday(1, 30), saturday(2, 0)},
+		{"Sat 22:00-02:00 UTC", saturday(23, 0), saturday(26, 0)},
+		{"Sat 22:00-02:00 UTC", saturday(1, 30), time.Time{}},
+	}
+	for _, tt := range tests {
+		w, err := parseMaintenanceWindow(tt.window)
+		if err != nil {
+			t.Fatalf("Expected %q to be valid, got %v", tt.window, err)
+		}
+		closes, open := w.closesAt(tt.now)
+		if open != !tt.closes.IsZero() || !closes.Equal(tt.closes) {
+			t.Errorf("%s at %s: expected %v, got %v (open %v)", tt.window, tt.now, tt.closes, closes, open)
+		}
+	}
+
+	w, _ := parseMaintenanceWindow("Sat 01:00-05:00 UTC")
+	if opens := w.opensAt(saturday(6, 0)); !opens.Equal(saturday(7*24+1, 0)) {
+		t.Errorf("Expected the window to open next Saturday, got %v", opens)
+	}
+}
+
+// TestWithMaintenanceWindow tests refusing real runs outside the window and
+// stopping them when it closes
+func TestWithMaintenanceWindow(t *testing.T) {
+	now := time.Date(2025, 5, 17, 6, 0, 0, 0, time.UTC)
+
+	_, _, err := withMaintenanceWindow(Config{Window: "Sat 01:00-05:00 UTC"}, now)
+	if err == nil || !strings.Contains(err.Error(), "refused until 2025-05-24T01:00:00Z") {
+		t.Errorf("Expected the run to be refused, got %v", err)
+	}
+	if _, _, err := withMaintenanceWindow(Config{Window: "Sat 01:00-05:00 UTC", DryRun: true}, now); err != nil {
+		t.Errorf("Expected dry runs to be allowed, got %v", err)
+	}
+
+	// Inside the window the run stops gracefully when it closes
+	cfg, closeWindow, err := withMaintenanceWindow(Config{Window: "Sat 05:00-06:00 UTC"}, now.Add(-10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected the run to be allowed, got %v", err)
+	}
+	defer closeWindow()
+	if err := stopError(context.Background(), cfg); err != nil {
+		t.Errorf("Expected the run to go on while the window is open, got %v", err)
+	}
+	select {
+	case <-cfg.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to stop when the window closes")
+	}
+	if err := stopError(context.Background(), cfg); !errors.Is(err, errRunStopped) || !strings.Contains(err.Error(), "maintenance window closed at 2025-05-17T06:00:00Z") {
+		t.Errorf("Expected the run to stop for the window, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// This file contains the concurrency helpers used to process repositories in
+// parallel while keeping the aggregated results consistent.
+
+// runConcurrently calls fn for every item using at most concurrency goroutines
+// and returns once all calls have finished. A concurrency below 1 is treated
+// as 1, which processes the items serially in order.
+func runConcurrently[T any](items []T, concurrency int, fn func(T)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if concurrency == 1 {
+		for _, item := range items {
+			fn(item)
+		}
+		return
+	}
+
+	work := make(chan T)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+
+	wg.Wait()
+}
+
+// summaryAggregator accumulates repository results from concurrent workers
+type summaryAggregator struct {
+	mu      sync.Mutex
+	summary CleanupSummary
+	price   float64 // -storage-price, in USD per GB-month
+}
+
+// addRepository records the results of one repository. A repository that
+// failed is listed with its error and only counts towards the totals with
+// the images it deleted before failing.
+func (a *summaryAggregator) addRepository(repoName string, repoSummary CleanupSummary, duration time.Duration, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	repo := RepositorySummary{
+		Name:                    repoName,
+		ImagesScanned:           repoSummary.ImagesScanned,
+		ImagesDeleted:           repoSummary.ImagesDeleted,
+		SpaceFreed:              repoSummary.SpaceFreed,
+		EstimatedMonthlySavings: repoSummary.EstimatedMonthlySavings,
+		Images:                  repoSummary.Images,
+		Quarantined:             repoSummary.Quarantined,
+		Released:                repoSummary.Released,
+		Duration:                duration,
+	}
+	a.summary.ImagesDeleted += repoSummary.ImagesDeleted
+	a.summary.SpaceFreed += repoSummary.SpaceFreed
+	if err != nil {
+		repo.Error = err.Error()
+	} else {
+		repo.SpaceScanned = repoSummary.SpaceScanned
+		repo.Deleted = repoSummary.RepositoriesDeleted > 0
+
+		a.summary.RepositoriesDeleted += repoSummary.RepositoriesDeleted
+		a.summary.ImagesScanned += repoSummary.ImagesScanned
+		a.summary.SpaceScanned += repoSummary.SpaceScanned
+		a.summary.ImagesRemaining += repoSummary.ImagesRemaining
+	}
+	a.summary.Repositories = append(a.summary.Repositories, repo)
+}
+
+// result returns a copy of the aggregated summary with the repositories
+// sorted by name, so concurrent runs produce stable output. The savings are
+// those of the space freed with shared digests counted once, which the
+// repositories of a registry share.
+func (a *summaryAggregator) result() CleanupSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	summary := a.summary
+	summary.Repositories = append([]RepositorySummary(nil), a.summary.Repositories...)
+	sort.Slice(summary.Repositories, func(i, j int) bool {
+		return summary.Repositories[i].Name < summary.Repositories[j].Name
+	})
+	summary.EstimatedMonthlySavings = monthlySavings(summary.spaceFreedUpperBound(), a.price)
+	return summary
+}
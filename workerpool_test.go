@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// TestRunConcurrently tests the bounded worker pool
+func TestRunConcurrently(t *testing.T) {
+	t.Run("Processes every item", func(t *testing.T) {
+		items := make([]int, 50)
+		for i := range items {
+			items[i] = i
+		}
+
+		var mu sync.Mutex
+		seen := make(map[int]bool)
+		runConcurrently(items, 8, func(item int) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[item] = true
+		})
+
+		if len(seen) != len(items) {
+			t.Errorf("Expected %d items to be processed, got %d", len(items), len(seen))
+		}
+	})
+
+	t.Run("Respects the concurrency limit", func(t *testing.T) {
+		var running, peak int32
+		runConcurrently(make([]int, 20), 3, func(int) {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+
+		if peak > 3 {
+			t.Errorf("Expected at most 3 concurrent calls, got %d", peak)
+		}
+	})
+
+	t.Run("Serial when concurrency is zero", func(t *testing.T) {
+		var order []int
+		runConcurrently([]int{1, 2, 3}, 0, func(item int) {
+			order = append(order, item)
+		})
+
+		if fmt.Sprint(order) != "[1 2 3]" {
+			t.Errorf("Expected items in order, got %v", order)
+		}
+	})
+}
+
+// TestCleanupWithClientConcurrent tests that concurrent processing aggregates correctly
+func TestCleanupWithClientConcurrent(t *testing.T) {
+	var repos []types.Repository
+	for i := 0; i < 10; i++ {
+		repos = append(repos, types.Repository{RepositoryName: aws.String(fmt.Sprintf("repo%d", i))})
+	}
+
+	mockClient := &MockECRClient{
+		DescribeRepositoriesOutput: &ecr.DescribeRepositoriesOutput{Repositories: repos},
+		ListImagesOutput: &ecr.ListImagesOutput{
+			ImageIds: []types.ImageIdentifier{{ImageTag: aws.String("v1")}},
+		},
+		DescribeImagesOutput: &ecr.DescribeImagesOutput{
+			ImageDetails: []types.ImageDetail{
+				{
+					ImageDigest:      aws.String("sha256:111"),
+					ImageTags:        []string{"v1"},
+					ImagePushedAt:    aws.Time(time.Now().AddDate(0, 0, -30)),
+					ImageSizeInBytes: aws.Int64(1000),
+				},
+			},
+		},
+		BatchDeleteImageOutput: &ecr.BatchDeleteImageOutput{},
+	}
+
+	cfg := Config{Days: 10, Concurrency: 4}
+	summary, err := CleanupWithClient(context.Background(), cfg, mockClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if summary.RepositoriesProcessed != 10 {
+		t.Errorf("Expected 10 repositories processed, got %d", summary.RepositoriesProcessed)
+	}
+	if summary.ImagesDeleted != 10 {
+		t.Errorf("Expected 10 images deleted, got %d", summary.ImagesDeleted)
+	}
+	if summary.SpaceFreed != 10000 {
+		t.Errorf("Expected 10000 bytes freed, got %d", summary.SpaceFreed)
+	}
+	if mockClient.BatchDeleteImageCalls != 10 {
+		t.Errorf("Expected 10 calls to BatchDeleteImage, got %d", mockClient.BatchDeleteImageCalls)
+	}
+	if len(summary.Repositories) != 10 {
+		t.Fatalf("Expected 10 repository summaries, got %d", len(summary.Repositories))
+	}
+	for i, repo := range summary.Repositories {
+		if want := fmt.Sprintf("repo%d", i); repo.Name != want {
+			t.Errorf("Expected repository %d to be %s, got %s", i, want, repo.Name)
+		}
+		if repo.ImagesScanned != 1 || repo.ImagesDeleted != 1 {
+			t.Errorf("Expected 1 image scanned and deleted in %s, got %d and %d", repo.Name, repo.ImagesScanned, repo.ImagesDeleted)
+		}
+	}
+}